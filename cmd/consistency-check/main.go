@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// exit codes, so a CI job can tell diverging replicas apart from replicas it could not reach
+const (
+	exitConsistent = 0
+	exitDiverging  = 1
+	exitError      = 2
+)
+
+func main() {
+	dbAddrs := flag.String("db-addrs", strings.Join(server.DefaultConfig().DatabaseAddresses, ","), "Comma separated addresses of the database replicas to compare")
+	prefix := flag.String("prefix", "", "Only compare sensors whose ID starts with this prefix (empty = all data)")
+	logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	var addresses []string
+	for _, address := range strings.Split(*dbAddrs, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) < 2 {
+		log.Printf("Need at least two database addresses, got %d", len(addresses))
+		os.Exit(exitError)
+	}
+
+	report, err := database.CheckConsistency(addresses, *prefix)
+	if err != nil {
+		log.Printf("Consistency check failed: %v", err)
+		os.Exit(exitError)
+	}
+
+	fmt.Print(report)
+	if !report.Consistent() {
+		os.Exit(exitDiverging)
+	}
+	os.Exit(exitConsistent)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -13,13 +14,37 @@ import (
 
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
 	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
 )
 
 func main() {
 	port := flag.Int("port", 50051, "Database server port")
 	dataLimit := flag.Int("data-limit", 1_000_000, "Maximum number of data points to store")
+	backend := flag.String("backend", "memory", "Storage backend: memory or bolt")
+	dataFile := flag.String("data-file", "", "Snapshot file for disk persistence (empty = in-memory only), with -backend bolt the bolt file")
+	snapshotFormat := flag.String("snapshot-format", "json", "Format of written snapshots: json, gob or binary (loading detects the format)")
+	snapshotGzip := flag.Bool("snapshot-gzip", false, "Gzip written snapshots")
+	maxPrepared := flag.Int("max-prepared", 0, "Prepared transactions held at most before new ones are refused as overloaded (0 = unlimited)")
+	upsert := flag.Bool("upsert", false, "Replace a stored point with the same sensor ID and timestamp instead of storing a duplicate")
+	syncCommit := flag.Bool("sync-commit", false, "Sync every 2PC commit to a log next to -data-file before acknowledging it, so it survives a crash (memory backend; bolt syncs every commit anyway)")
+	jsonPort := flag.Int("json-port", 0, "Port of the JSON adapter serving POST /rpc/<Method> over HTTP for debugging (0 = disabled)")
+	keepaliveMinInterval := flag.Duration("keepalive-min-interval", database.DefaultKeepaliveMinInterval, "Shortest interval in which clients may ping to check the connection; clients pinging more often are disconnected")
+	var tracingConfig tracing.Config
+	tracingConfig.RegisterFlags(flag.CommandLine)
+	logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	if err := tracingConfig.Init(context.Background(), "iot-database"); err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	format, err := database.ParseSnapshotFormat(*snapshotFormat)
+	if err != nil {
+		log.Fatalf("Invalid -snapshot-format: %v", err)
+	}
+
 	addr := fmt.Sprintf("0.0.0.0:%d", *port)
 
 	//create a TCP listener and listen on the provided addr
@@ -31,11 +56,60 @@ func main() {
 	grpcServer := grpc.NewServer(
 		grpc.MaxRecvMsgSize(200*1024*1024), //200MB receive limit
 		grpc.MaxSendMsgSize(200*1024*1024), //200MB send limit
+		database.KeepaliveEnforcement(*keepaliveMinInterval),
+		grpc.UnaryInterceptor(tracing.UnaryServerInterceptor()), //a no-op without -otlp-endpoint
 	)
 
-	databaseService := database.DatabaseServiceFactory(*dataLimit)
+	opts := []database.ServiceOption{database.WithMaxPreparedTransactions(*maxPrepared)}
+	if *upsert {
+		opts = append(opts, database.WithUpsert())
+	}
+	switch *backend {
+	case "memory":
+		if *dataFile != "" {
+			opts = append(opts, database.WithDataFile(*dataFile), database.WithSnapshotFormat(format, *snapshotGzip))
+		}
+		if *syncCommit {
+			if *dataFile == "" {
+				log.Fatalf("-sync-commit requires -data-file")
+			}
+			opts = append(opts, database.WithSyncCommit())
+		}
+	case "bolt":
+		//every commit is written to the bolt file directly, there are no snapshots to take
+		if *dataFile == "" {
+			log.Fatalf("-backend bolt requires -data-file")
+		}
+		if *syncCommit {
+			log.Printf("-sync-commit has no effect with -backend bolt, bolt syncs every commit to disk anyway")
+		}
+		storage, err := database.BoltStorageFactory(*dataFile, *dataLimit)
+		if err != nil {
+			log.Fatalf("Failed to open bolt storage: %v", err)
+		}
+		defer storage.Close()
+		opts = append(opts, database.WithStorage(storage))
+	default:
+		log.Fatalf("Invalid -backend %q: must be memory or bolt", *backend)
+	}
+
+	databaseService, err := database.DatabaseServiceFactory(*dataLimit, opts...)
+	if err != nil {
+		log.Fatalf("Failed to start the database service: %v", err)
+	}
 	pb.RegisterDatabaseServiceServer(grpcServer, databaseService)
 
+	//the JSON adapter calls the same service in-process, for clients without gRPC such as a browser or curl
+	var jsonServer *http.Server
+	if *jsonPort != 0 {
+		jsonServer = http.ServerFactory("0.0.0.0", *jsonPort)
+		database.RegisterJSONHandlers(jsonServer, databaseService)
+		if err := jsonServer.Start(); err != nil {
+			log.Fatalf("Failed to start JSON adapter on port %d: %v", *jsonPort, err)
+		}
+		log.Printf("JSON adapter listening on port %d", *jsonPort)
+	}
+
 	//set up signal handling for graceful shutdown like when ctrl c is pressed for example
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -53,5 +127,14 @@ func main() {
 
 	//wait for the conns to die off on their own first (basically dont force stop)
 	grpcServer.GracefulStop()
+	if jsonServer != nil {
+		jsonServer.Stop()
+	}
+
+	//stops the cleanup goroutine and writes the final snapshot when persistence is enabled
+	databaseService.Stop()
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		log.Printf("Failed to send the last trace spans: %v", err)
+	}
 	log.Println("Database server stopped")
 }
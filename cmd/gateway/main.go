@@ -1,190 +1,114 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"sync"
+	"strconv"
 	"syscall"
 	"time"
 
-	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
-	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/gateway"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
 )
 
-// Gateway represents the IoT Gateway that receives data via MQTT and forwards via HTTP
-type Gateway struct {
-	ServerURL     string           // HTTP server URL to forward data to
-	MQTTBrokerURL string           // MQTT broker URL
-	Client        *http.HttpClient // HTTP client for forwarding data
-	MQTTClient    mqtt.Client      // MQTT client for receiving sensor data
-	StopChan      chan struct{}    // Channel for graceful shutdown
-	WaitGroup     sync.WaitGroup   // Ensures clean shutdown
-	MessageCount  int64            // Count of processed messages
-	mutex         sync.Mutex       // Protects message count
-}
-
-// GatewayFactory creates a new IoT Gateway
-func GatewayFactory(serverURL, mqttBrokerURL string) *Gateway {
-	return &Gateway{
-		ServerURL:     serverURL,
-		MQTTBrokerURL: mqttBrokerURL,
-		Client:        http.HttpClientFactory(5 * time.Second),
-		StopChan:      make(chan struct{}),
-		MessageCount:  0,
-	}
-}
-
-// Start starts the IoT Gateway
-func (g *Gateway) Start() error {
-	log.Printf("Starting IoT Gateway")
-	log.Printf("HTTP Server: %s", g.ServerURL)
-	log.Printf("MQTT Broker: %s", g.MQTTBrokerURL)
-
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", g.MQTTBrokerURL))
-	opts.SetClientID("iot-gateway")
-	opts.SetCleanSession(true)
-	opts.SetAutoReconnect(true)
-	opts.SetKeepAlive(60 * time.Second)
-	opts.SetPingTimeout(10 * time.Second)
-
-	// Connection handlers
-	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Println("Gateway connected to MQTT broker")
-		g.subscribeToTopics(client)
-	})
-
-	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("Gateway lost connection to MQTT broker: %v", err)
-	})
-
-	g.MQTTClient = mqtt.NewClient(opts)
-
-	//connect to MQTT broker
-	if token := g.MQTTClient.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
-	}
-
-	log.Println("Gateway started successfully")
-	return nil
-}
-
-// subscribeToTopics subscribes to all sensor topics
-func (g *Gateway) subscribeToTopics(client mqtt.Client) {
-	//subscribe to all sensor topics using wildcard
-	topic := "sensors/+/+"
-
-	token := client.Subscribe(topic, 0, g.messageHandler)
-	token.Wait()
-
-	if token.Error() != nil {
-		log.Printf("Failed to subscribe to topic %s: %v", topic, token.Error())
-	} else {
-		log.Printf("Successfully subscribed to topic: %s", topic)
-	}
-}
-
-// messageHandler handles incoming MQTT messages
-func (g *Gateway) messageHandler(client mqtt.Client, msg mqtt.Message) {
-	log.Printf("Received message from topic %s", msg.Topic())
-
-	var sensorData types.SensorData
-	if err := json.Unmarshal(msg.Payload(), &sensorData); err != nil {
-		log.Printf("Error parsing sensor data from topic %s: %v", msg.Topic(), err)
-		return
-	}
-
-	//forward data to HTTP server
-	g.WaitGroup.Add(1)
-	go func() {
-		defer g.WaitGroup.Done()
-
-		startTime := time.Now()
-		if err := g.forwardData(sensorData); err != nil {
-			log.Printf("Error forwarding data from sensor %s: %v", sensorData.SensorID, err)
-		} else {
-			rtt := time.Since(startTime)
-			log.Printf("Successfully forwarded data from %s (RTT: %v)", sensorData.SensorID, rtt)
-
-			//update message count
-			g.mutex.Lock()
-			g.MessageCount++
-			if g.MessageCount%100 == 0 {
-				log.Printf("Processed %d messages", g.MessageCount)
-			}
-			g.mutex.Unlock()
-		}
-	}()
-}
-
-// forwardData forwards sensor data to the HTTP server
-func (g *Gateway) forwardData(data types.SensorData) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("error marshaling data to JSON: %w", err)
-	}
-
-	resp, err := g.Client.PostJSON(g.ServerURL+"/data", jsonData)
-	if err != nil {
-		return fmt.Errorf("error sending data to server: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned non-OK status: %d %s", resp.StatusCode, resp.StatusText)
-	}
-
-	return nil
-}
-
-// Stop stops the IoT Gateway
-func (g *Gateway) Stop() {
-	log.Println("Stopping IoT Gateway...")
-
-	//signal all goroutines to stop
-	close(g.StopChan)
-
-	//wait for all message processing to complete
-	g.WaitGroup.Wait()
-
-	//disconn from MQTT broker
-	if g.MQTTClient != nil && g.MQTTClient.IsConnected() {
-		g.MQTTClient.Disconnect(250)
-		log.Println("Disconnected from MQTT broker")
-	}
-
-	g.mutex.Lock()
-	finalCount := g.MessageCount
-	g.mutex.Unlock()
-
-	log.Printf("IoT Gateway stopped. Total messages processed: %d", finalCount)
-}
-
-// GetMessageCount returns the current message count (thread-safe)
-func (g *Gateway) GetMessageCount() int64 {
-	g.mutex.Lock()
-	defer g.mutex.Unlock()
-	return g.MessageCount
-}
-
 func main() {
+	configPath := flag.String("config", "", "YAML config file of the gateway, flags given explicitly override its values")
 	serverHost := flag.String("server-host", "localhost", "Server hostname")
 	serverPort := flag.Int("server-port", 8080, "Server port")
+	serverSocket := flag.String("server-socket", "", "Unix domain socket of the server (overrides server-host and server-port)")
 	mqttHost := flag.String("mqtt-host", "localhost", "MQTT broker hostname")
 	mqttPort := flag.Int("mqtt-port", 1883, "MQTT broker port")
+	mqttURL := flag.String("mqtt-url", "", "MQTT broker as tcp://, ssl:// or tls:// URL, e.g. tls://broker:8883, instead of -mqtt-host and -mqtt-port")
+	var security mqttconfig.Security
+	security.RegisterFlags(flag.CommandLine)
+	topicTemplate := flag.String("topic-template", mqttconfig.DefaultTopicTemplate, "Topic the sensors publish to, {type} and {id} stand for sensor type and ID, e.g. site/{type}/{id}/telemetry")
+	topicFilter := flag.String("topic-filter", "", "Subscription filter, must match every topic of -topic-template (empty = the template with + for {type} and {id})")
 	duration := flag.Int("duration", 0, "Run duration in seconds (0 = run until interrupted)")
+	mqttTimeout := flag.Duration("mqtt-timeout", gateway.DefaultMQTTTimeout, "How long to wait for the MQTT broker to acknowledge a connect or subscribe")
+	forwardQueue := flag.Int("forward-queue", 0, "Messages buffered for forwarding, the lowest priority is dropped first when full (0 = forward every message at once)")
+	forwardWorkers := flag.Int("forward-workers", gateway.DefaultForwardWorkers, "Forwards running at once when the forward queue is used, also the number of connections to the server kept open")
+	batchMin := flag.Int("batch-min", 1, "Fewest queued messages forwarded in one request when batching")
+	batchMax := flag.Int("batch-max", 0, "Most queued messages forwarded in one request, the batch adapts to the server's latency in between (0 = no batching, needs -forward-queue)")
+	batchLatency := flag.Duration("batch-latency", gateway.DefaultBatchLatency, "Forward latency up to which the batch grows; slower forwards and 503s halve it")
+	deadLetterFile := flag.String("dead-letter-file", "", "JSONL file the readings that could not be forwarded are appended to, with the reason (empty = only logged)")
+	deadLetterMaxSize := flag.Int64("dead-letter-max-size", gateway.DefaultDeadLetterMaxSize, "Size in bytes at which the dead-letter file is rotated to <file>.1")
+	logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	serverURL := fmt.Sprintf("http://%s:%d", *serverHost, *serverPort)
-	mqttBrokerURL := fmt.Sprintf("%s:%d", *mqttHost, *mqttPort)
+	config := gateway.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		config, err = gateway.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
 
-	gateway := GatewayFactory(serverURL, mqttBrokerURL)
+	//only flags given on the command line override the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server-host", "server-port":
+			//each replaces only its part of the URL, -server-socket still wins if it is given as well since flags are
+			//visited in name order
+			host, port := *serverHost, strconv.Itoa(*serverPort)
+			if f.Name == "server-host" {
+				port = ""
+			} else {
+				host = ""
+			}
+			if err := config.SetServerAddress(host, port); err != nil {
+				log.Fatalf("Failed to apply -%s: %v", f.Name, err)
+			}
+		case "server-socket":
+			config.ServerSocket = *serverSocket
+		case "mqtt-host", "mqtt-port":
+			config.MQTTBrokers = []string{fmt.Sprintf("%s:%d", *mqttHost, *mqttPort)}
+		case "mqtt-url": //visited after -mqtt-host and -mqtt-port, so it wins over them
+			config.MQTTBrokers = []string{*mqttURL}
+		case "mqtt-username":
+			config.MQTTUsername = security.Username
+		case "mqtt-password":
+			config.MQTTPassword = security.Password
+		case "mqtt-ca-file":
+			config.MQTTCAFile = security.CAFile
+		case "mqtt-cert-file":
+			config.MQTTCertFile = security.CertFile
+		case "mqtt-key-file":
+			config.MQTTKeyFile = security.KeyFile
+		case "topic-template":
+			config.TopicTemplate = *topicTemplate
+		case "topic-filter":
+			config.TopicFilter = *topicFilter
+		case "mqtt-timeout":
+			config.MQTTTimeout = *mqttTimeout
+		case "forward-queue":
+			config.ForwardQueueSize = *forwardQueue
+		case "forward-workers":
+			config.ForwardWorkers = *forwardWorkers
+		case "batch-min":
+			config.BatchMin = *batchMin
+		case "batch-max":
+			config.BatchMax = *batchMax
+		case "batch-latency":
+			config.BatchLatency = *batchLatency
+		case "dead-letter-file":
+			config.DeadLetterFile = *deadLetterFile
+		case "dead-letter-max-size":
+			config.DeadLetterMaxSize = *deadLetterMaxSize
+		}
+	})
+
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		log.Fatalf("Failed to create gateway: %v", err)
+	}
+	log.Printf("Gateway config: %s", config)
 
-	if err := gateway.Start(); err != nil {
+	if err := gw.Start(); err != nil {
 		log.Fatalf("Failed to start gateway: %v", err)
 	}
 
@@ -207,5 +131,5 @@ func main() {
 		log.Println("Received termination signal")
 	}
 
-	gateway.Stop()
+	gw.Stop()
 }
@@ -1,266 +1,81 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
-	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/sensor"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
 )
 
-// SensorSimulator represents a single sensor that publishes data to MQTT
-type SensorSimulator struct {
-	SensorType types.Sensor
-	SensorID   string
-	MQTTClient mqtt.Client
-	StopChan   chan struct{}
-	WaitGroup  *sync.WaitGroup
-}
-
-// SensorManager manages multiple sensor simulators
-type SensorManager struct {
-	BrokerURL      string
-	Sensors        []types.Sensor
-	SensorsPerType int
-	Duration       int
-	Simulators     []*SensorSimulator
-	WaitGroup      sync.WaitGroup
-}
-
-var sensors = []types.Sensor{
-	{
-		ID:                     "temp",
-		Name:                   "Temperature Sensor",
-		MinValue:               -40.0,
-		MaxValue:               130.0,
-		Unit:                   "°C",
-		NoiseLevel:             0.05,
-		DataGenerationInterval: 1000,
-	},
-	{
-		ID:                     "humid",
-		Name:                   "Humidity Sensor",
-		MinValue:               30.0,
-		MaxValue:               80.0,
-		Unit:                   "%",
-		NoiseLevel:             0.05,
-		DataGenerationInterval: 500,
-	},
-	{
-		ID:                     "press",
-		Name:                   "Pressure Sensor",
-		MinValue:               980.0,
-		MaxValue:               1020.0,
-		Unit:                   "hPa",
-		NoiseLevel:             0.01,
-		DataGenerationInterval: 2000,
-	},
-	{
-		ID:                     "light",
-		Name:                   "Light Sensor",
-		MinValue:               0.0,
-		MaxValue:               1000.0,
-		Unit:                   "cd",
-		NoiseLevel:             0.10,
-		DataGenerationInterval: 1500,
-	},
-}
-
-// NewSensorManager creates a new sensor manager
-func NewSensorManager(brokerURL string, sensorsPerType, duration int) *SensorManager {
-	return &SensorManager{
-		BrokerURL:      brokerURL,
-		Sensors:        sensors,
-		SensorsPerType: sensorsPerType,
-		Duration:       duration,
-		Simulators:     make([]*SensorSimulator, 0),
-	}
-}
-
-// Start starts all sensor simulators
-func (sm *SensorManager) Start() error {
-	log.Printf("Starting sensor manager with %d sensor types, %d instances each",
-		len(sm.Sensors), sm.SensorsPerType)
+func main() {
+	brokerHost := flag.String("mqtt-host", "localhost", "MQTT broker hostname")
+	brokerPort := flag.Int("mqtt-port", 1883, "MQTT broker port")
+	brokerURL := flag.String("mqtt-url", "", "MQTT broker as tcp://, ssl:// or tls:// URL, e.g. tls://broker:8883, instead of -mqtt-host and -mqtt-port")
+	var security mqttconfig.Security
+	security.RegisterFlags(flag.CommandLine)
+	topicTemplate := flag.String("topic-template", mqttconfig.DefaultTopicTemplate, "Topic the readings are published to, {type} and {id} stand for sensor type and ID, e.g. site/{type}/{id}/telemetry")
+	instancesPerType := flag.Int("instances", 3, "Number of instances per sensor type")
+	duration := flag.Int("duration", 0, "Run duration in seconds (0 = run until interrupted)")
+	burst := flag.Int("burst", 1, "Number of readings published per tick as one JSON array message (1 = single object)")
+	jitter := flag.Float64("jitter", 0, "Fraction of the interval (0-1) used as random start offset and per-tick deviation (0 = disabled)")
+	seed := flag.Int64("seed", 0, "Seed for the per-sensor random number generators (0 = seed from current time)")
+	control := flag.Bool("control", false, "Let each sensor change its publish interval at runtime on control/<sensorID>/interval (milliseconds)")
+	precision := flag.Int("precision", -1, "Decimal places all readings are rounded to, overriding the precision of each sensor type (-1 = per sensor type)")
+	mqttTimeout := flag.Duration("mqtt-timeout", sensor.DefaultMQTTTimeout, "How long to wait for the MQTT broker to acknowledge a connect, subscribe or publish")
+	statsInterval := flag.Duration("stats-interval", 0, "How often to log how many readings each sensor published and dropped (0 = only when stopping)")
+	profilesPath := flag.String("profiles", "", "YAML file with seasonality and scheduled anomalies per sensor ID or sensor type (empty = plain drift and noise)")
+	replayDB := flag.String("replay-db", "", "Instead of simulating, publish the readings stored in the database at this address again, e.g. localhost:50051")
+	replayPrefix := flag.String("replay-prefix", "", "Replay only the sensor IDs starting with this prefix (empty = all)")
+	replayFrom := flag.String("replay-from", "", "Replay only readings with a timestamp at or after this time (RFC 3339, empty = no lower bound)")
+	replayTo := flag.String("replay-to", "", "Replay only readings with a timestamp before this time (RFC 3339, empty = no upper bound)")
+	replayRate := flag.Float64("replay-rate", 0, "Readings replayed per second (0 = as fast as the broker acknowledges them)")
+	logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
 
-	//create sensor simulators
-	for _, sensorType := range sm.Sensors {
-		for i := 0; i < sm.SensorsPerType; i++ {
-			sensorID := fmt.Sprintf("%s-%d", sensorType.ID, i+1)
-			simulator, err := sm.createSensorSimulator(sensorType, sensorID)
-			if err != nil {
-				return fmt.Errorf("failed to create sensor %s: %w", sensorID, err)
-			}
-			sm.Simulators = append(sm.Simulators, simulator)
-		}
+	broker := fmt.Sprintf("%s:%d", *brokerHost, *brokerPort)
+	if *brokerURL != "" {
+		broker = *brokerURL
 	}
-
-	//start all simulators
-	for _, simulator := range sm.Simulators {
-		sm.WaitGroup.Add(1)
-		go simulator.Start(&sm.WaitGroup)
+	if err := security.Validate([]string{broker}); err != nil {
+		log.Fatalf("Invalid MQTT settings: %v", err)
 	}
-
-	return nil
-}
-
-// Stop stops all sensor simulators
-func (sm *SensorManager) Stop() {
-	log.Println("Stopping all sensor simulators...")
-
-	for _, simulator := range sm.Simulators {
-		close(simulator.StopChan)
+	topic, err := mqttconfig.ParseTopicTemplate(*topicTemplate)
+	if err != nil {
+		log.Fatalf("Invalid -topic-template: %v", err)
 	}
 
-	sm.WaitGroup.Wait()
-
-	//disconn MQTT clients
-	for _, simulator := range sm.Simulators {
-		if simulator.MQTTClient.IsConnected() {
-			simulator.MQTTClient.Disconnect(250)
-		}
+	if *replayDB != "" {
+		replay(broker, security, topic, *replayDB, *replayPrefix, *replayFrom, *replayTo, *replayRate, *mqttTimeout)
+		return
 	}
 
-	log.Println("All sensor simulators stopped")
-}
-
-// createSensorSimulator creates and connects a sensor simulator to MQTT
-func (sm *SensorManager) createSensorSimulator(sensorType types.Sensor, sensorID string) (*SensorSimulator, error) {
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", sm.BrokerURL))
-	opts.SetClientID(fmt.Sprintf("sensor-%s", sensorID))
-	opts.SetCleanSession(true)
-	opts.SetAutoReconnect(true)
-	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Printf("Sensor %s connected to MQTT broker", sensorID)
-	})
-	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("Sensor %s lost connection to MQTT broker: %v", sensorID, err)
-	})
-
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
 	}
-
-	return &SensorSimulator{
-		SensorType: sensorType,
-		SensorID:   sensorID,
-		MQTTClient: client,
-		StopChan:   make(chan struct{}),
-	}, nil
-}
-
-// Start starts the sensor simulation
-func (s *SensorSimulator) Start(wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	ticker := time.NewTicker(time.Duration(s.SensorType.DataGenerationInterval) * time.Millisecond)
-	defer ticker.Stop()
-
-	//init with base value
-	baseValue := s.SensorType.MinValue + rand.Float64()*(s.SensorType.MaxValue-s.SensorType.MinValue)
-
-	log.Printf("Started sensor simulation for %s (%s)", s.SensorID, s.SensorType.Name)
-
-	for {
-		select {
-		case <-s.StopChan:
-			log.Printf("Stopping sensor %s", s.SensorID)
-			return
-		case <-ticker.C:
-			value := s.generateSensorValue(baseValue)
-			data := types.SensorData{
-				SensorID:  s.SensorID,
-				Timestamp: time.Now(),
-				Value:     value,
-				Unit:      s.SensorType.Unit,
-			}
-
-			//publish to MQTT
-			if err := s.publishData(data); err != nil {
-				log.Printf("Error publishing data from sensor %s: %v", s.SensorID, err)
-			}
-
-			//apply drift for next reading
-			baseValue = s.applyDrift(baseValue)
+	log.Printf("Using random seed %d", *seed)
+
+	manager := sensor.NewSensorManager(broker, *instancesPerType, *duration, *burst, *jitter, *seed, *control)
+	manager.MQTTTimeout = *mqttTimeout
+	manager.MQTTSecurity = security
+	manager.Topic = topic
+	manager.Precision = *precision
+	manager.StatsInterval = *statsInterval
+
+	if *profilesPath != "" {
+		profiles, err := sensor.LoadProfiles(*profilesPath)
+		if err != nil {
+			log.Fatalf("Failed to load sensor profiles: %v", err)
 		}
+		manager.Profiles = profiles
+		log.Printf("Loaded %d sensor profiles from %s", len(profiles), *profilesPath)
 	}
-}
-
-// generateSensorValue generates a sensor value with noise
-func (s *SensorSimulator) generateSensorValue(baseValue float64) float64 {
-	noise := (rand.Float64()*2 - 1) * s.SensorType.NoiseLevel * baseValue
-	value := baseValue + noise
-
-	//ensure value is within sensor range
-	if value < s.SensorType.MinValue {
-		value = s.SensorType.MinValue
-	} else if value > s.SensorType.MaxValue {
-		value = s.SensorType.MaxValue
-	}
-
-	return value
-}
-
-// applyDrift applies random drift to the base value
-func (s *SensorSimulator) applyDrift(baseValue float64) float64 {
-	driftRange := (s.SensorType.MaxValue - s.SensorType.MinValue) * 0.001
-	drift := (rand.Float64()*2 - 1) * driftRange
-
-	newValue := baseValue + drift
-
-	//wnsure the value stays within range
-	if newValue < s.SensorType.MinValue {
-		newValue = s.SensorType.MinValue
-	} else if newValue > s.SensorType.MaxValue {
-		newValue = s.SensorType.MaxValue
-	}
-
-	return newValue
-}
-
-// publishData publishes sensor data to MQTT topic
-func (s *SensorSimulator) publishData(data types.SensorData) error {
-	topic := fmt.Sprintf("sensors/%s/%s", s.SensorType.ID, s.SensorID)
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal sensor data: %w", err)
-	}
-
-	//publish topci to MQTT
-	token := s.MQTTClient.Publish(topic, 0, false, jsonData)
-	token.Wait()
-
-	if token.Error() != nil {
-		return fmt.Errorf("failed to publish to topic %s: %w", topic, token.Error())
-	}
-
-	log.Printf("Published data from %s: %.2f %s to topic %s",
-		s.SensorID, data.Value, data.Unit, topic)
-
-	return nil
-}
-
-func main() {
-	brokerHost := flag.String("mqtt-host", "localhost", "MQTT broker hostname")
-	brokerPort := flag.Int("mqtt-port", 1883, "MQTT broker port")
-	instancesPerType := flag.Int("instances", 3, "Number of instances per sensor type")
-	duration := flag.Int("duration", 0, "Run duration in seconds (0 = run until interrupted)")
-	flag.Parse()
-
-	rand.Seed(time.Now().UnixNano())
-
-	brokerURL := fmt.Sprintf("%s:%d", *brokerHost, *brokerPort)
-	manager := NewSensorManager(brokerURL, *instancesPerType, *duration)
 
 	if err := manager.Start(); err != nil {
 		log.Fatalf("Failed to start sensor manager: %v", err)
@@ -287,3 +102,44 @@ func main() {
 
 	manager.Stop()
 }
+
+// replay publishes the readings stored in a database again until all are sent or the process is interrupted
+func replay(brokerURL string, security mqttconfig.Security, topic mqttconfig.TopicTemplate, databaseAddr, prefix, from, to string, rate float64, mqttTimeout time.Duration) {
+	replayer := sensor.NewReplayer(brokerURL, databaseAddr)
+	replayer.MQTTSecurity = security
+	replayer.Topic = topic
+	replayer.Prefix = prefix
+	replayer.Rate = rate
+	replayer.MQTTTimeout = mqttTimeout
+
+	var err error
+	if replayer.TimeRange.From, err = parseTime(from); err != nil {
+		log.Fatalf("Invalid -replay-from: %v", err)
+	}
+	if replayer.TimeRange.To, err = parseTime(to); err != nil {
+		log.Fatalf("Invalid -replay-to: %v", err)
+	}
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Received termination signal")
+		close(stop)
+	}()
+
+	result, err := replayer.Run(stop)
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+	log.Printf("Replayed %d of %d readings from %s (%d failed)", result.Published, result.Read, databaseAddr, result.Failed)
+}
+
+// parseTime parses an RFC 3339 time, an empty value is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -1,40 +1,105 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
-	"time"
 
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
-	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
 )
 
 func main() {
-	host := flag.String("host", "0.0.0.0", "Server host")
-	port := flag.Int("port", 8080, "Server port")
-	dbAddr1 := flag.String("db-addr1", "localhost:50051", "First database server address")
-	dbAddr2 := flag.String("db-addr2", "localhost:50052", "Second database server address")
+	defaults := server.DefaultConfig()
+	config := defaults
+
+	flag.StringVar(&config.Host, "host", defaults.Host, "Server host")
+	flag.IntVar(&config.Port, "port", defaults.Port, "Server port")
+	dbAddr1 := flag.String("db-addr1", defaults.DatabaseAddresses[0], "First database server address")
+	dbAddr2 := flag.String("db-addr2", defaults.DatabaseAddresses[1], "Second database server address")
+	flag.StringVar(&config.AdminUser, "admin-user", defaults.AdminUser, "Username for the admin endpoints")
+	flag.StringVar(&config.AdminPassword, "admin-password", "", "Password for the admin endpoints (empty = admin endpoints disabled)")
+	flag.BoolVar(&config.DryRun, "dry-run", false, "Only validate 2PC writes: prepare on all databases, then always abort")
+	flag.StringVar(&config.SocketPath, "socket", "", "Unix domain socket path to listen on instead of host:port")
+	flag.IntVar(&config.CompressionThreshold, "gzip-min-size", defaults.CompressionThreshold, "Minimum response size in bytes that is gzipped for clients accepting it (0 = disabled)")
+	flag.DurationVar(&config.WriteTimeout, "write-timeout", defaults.WriteTimeout, "Time a client gets to read one response before its connection is dropped")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", defaults.BreakerThreshold, "Consecutive failed calls after which a database is skipped (0 = circuit breaker disabled)")
+	flag.DurationVar(&config.BreakerCooldown, "breaker-cooldown", defaults.BreakerCooldown, "Time a skipped database is left alone before it is probed again")
+	flag.BoolVar(&config.DegradedWrites, "degraded-writes", false, "Keep accepting writes on the reachable databases when one is down and replay them later (trades consistency for availability)")
+	flag.DurationVar(&config.ReconcileInterval, "reconcile-interval", defaults.ReconcileInterval, "How often writes missed by a database are replayed in degraded mode")
+	flag.DurationVar(&config.ReadyWait, "ready-wait", 0, "How long a write waits for the connection to a restarted database to become ready before it counts as failed (0 = no waiting)")
+	flag.DurationVar(&config.DBConnectTimeout, "db-connect-timeout", 0, "How long the startup retries databases that are not reachable yet before it gives up (0 = do not check them at startup)")
+	flag.DurationVar(&config.DBConnectBackoff, "db-connect-backoff", defaults.DBConnectBackoff, "Wait after the first failed connection attempt to a database, doubled after every further attempt")
+	flag.DurationVar(&config.DBConnectMaxBackoff, "db-connect-max-backoff", defaults.DBConnectMaxBackoff, "Longest wait between two connection attempts to a database")
+	flag.BoolVar(&config.DBConnectFailFast, "db-connect-fail-fast", false, "Exit at startup if any database is not reachable, without retrying")
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", defaults.ShutdownTimeout, "Time requests in progress and then 2PC transactions in progress get to finish on shutdown (0 = no limit)")
+	flag.DurationVar(&config.KeepaliveInterval, "keepalive-interval", defaults.KeepaliveInterval, "Idle time after which a database connection is pinged to detect a dead database (0 = no pings, at least 10s)")
+	flag.DurationVar(&config.KeepaliveTimeout, "keepalive-timeout", defaults.KeepaliveTimeout, "Time a database gets to answer a ping before its connection is dropped and re-established")
+	flag.IntVar(&config.ValueFormat.Precision, "value-precision", defaults.ValueFormat.Precision, "Digits after the decimal point of returned reading values (-1 = shortest exact representation)")
+	flag.BoolVar(&config.ValueFormat.AsString, "value-as-string", false, "Return reading values as JSON strings, e.g. \"23.10\", for clients that would parse them as float64")
+	flag.BoolVar(&config.ValueFormat.EpochMillis, "timestamps-millis", false, "Return reading timestamps as epoch milliseconds instead of RFC 3339 (per request: ?timestamps=millis or ?timestamps=rfc3339)")
+	flag.DurationVar(&config.IdempotencyTTL, "idempotency-ttl", defaults.IdempotencyTTL, "How long the response to a POST /data with an Idempotency-Key header is replayed for repeats of the key (0 = keys ignored)")
+	flag.IntVar(&config.IdempotencyMaxKeys, "idempotency-max-keys", defaults.IdempotencyMaxKeys, "Maximum number of remembered idempotency keys, the oldest one is forgotten first")
+	flag.StringVar(&config.ServerHeader, "server-header", defaults.ServerHeader, "Server header of every response (empty = omitted, e.g. to not reveal the implementation)")
+	flag.IntVar(&config.ListenBacklog, "listen-backlog", 0, "Length of the queue of accepted connections not yet taken by the server, capped by the kernel (0 = system default)")
+	flag.IntVar(&config.MaxRequestsPerConn, "max-requests-per-conn", 0, "Requests served on one persistent connection before the server closes it, announced with Connection: close (0 = unlimited)")
+	trailingSlash := flag.String("trailing-slash", http.TrailingSlashStrict.String(), "How a path differing from a route only by a trailing slash is served: strict (404), redirect (308 to the route) or merge (served by the route)")
+	flag.BoolVar(&config.ReusePort, "reuse-port", false, "Set SO_REUSEPORT so several server processes can listen on the same port and share its connections")
+	flag.BoolVar(&config.RouteListing, "list-routes", false, "Serve GET /_routes listing all registered handlers as JSON (for development)")
+	flag.IntVar(&config.MaxTransactions, "max-transactions", 0, "2PC transactions running at once, further writes wait or are refused depending on -busy-policy (0 = unlimited)")
+	busyPolicy := flag.String("busy-policy", defaults.BusyPolicy.String(), "What a write does when -max-transactions are running: block (wait for a free slot) or reject (503 too_busy)")
+	flag.DurationVar(&config.BusyWait, "busy-wait", defaults.BusyWait, "How long a write waits for a free slot with -busy-policy block before it is refused with 503 too_busy")
+	flag.DurationVar(&config.ReadCacheTTL, "read-cache-ttl", 0, "How long the readings of a sensor are cached for GET /data/{sensorId}; writes through this server invalidate them at once (0 = no cache)")
+	flag.IntVar(&config.ReadCacheSize, "read-cache-size", defaults.ReadCacheSize, "Maximum number of sensors in the read cache, the least recently read one is dropped first")
+	flag.DurationVar(&config.LeaseTTL, "lease-ttl", 0, "Run as one of several servers sharing the databases: only the server holding the coordinator lease accepts writes, renewing it every third of this time; the others serve reads and take over once it expires (0 = always accept writes)")
+	flag.StringVar(&config.LeaseHolder, "lease-id", "", "ID of this server in the coordinator lease, must be unique among the servers (empty = hostname:port plus a random suffix)")
+	readStrategy := flag.String("read-strategy", defaults.ReadStrategy.String(), "How reads are spread across the databases: first, round-robin or weighted")
+	readWeights := flag.String("read-weights", "", "Comma separated read weights of the databases for the weighted strategy, e.g. 1,3 (default 1 each)")
+	var tracingConfig tracing.Config
+	tracingConfig.RegisterFlags(flag.CommandLine)
+	logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	//create a 2PC client with both database addresses (one main and one 'redundant')
-	dbAddresses := []string{*dbAddr1, *dbAddr2}
-	tpcClient, err := database.TwoPhaseCommitClientFactory(dbAddresses)
-	if err != nil {
-		log.Fatalf("Failed to connect to database services: %v", err)
+	if err := tracingConfig.Init(context.Background(), "iot-server"); err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
 	}
-	defer tpcClient.Close()
 
-	server := http.ServerFactory(*host, *port)
+	//one main and one 'redundant' database
+	config.DatabaseAddresses = []string{*dbAddr1, *dbAddr2}
 
-	registerHandlers(server, tpcClient)
+	var err error
+	config.BusyPolicy, err = database.ParseBusyPolicy(*busyPolicy)
+	if err != nil {
+		log.Fatalf("Invalid -busy-policy: %v", err)
+	}
+	config.TrailingSlash, err = http.ParseTrailingSlash(*trailingSlash)
+	if err != nil {
+		log.Fatalf("Invalid -trailing-slash: %v", err)
+	}
+	config.ReadStrategy, err = database.ParseReadStrategy(*readStrategy)
+	if err != nil {
+		log.Fatalf("Invalid -read-strategy: %v", err)
+	}
+	config.ReadWeights, err = parseWeights(*readWeights)
+	if err != nil {
+		log.Fatalf("Invalid -read-weights: %v", err)
+	}
 
-	err = server.Start()
+	app, err := server.AppFactory(config)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	err = app.Start()
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -45,226 +110,27 @@ func main() {
 	<-sigChan
 
 	log.Println("Shutting down server...")
-	server.Stop()
+	if err := app.Shutdown(config.ShutdownTimeout); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		log.Printf("Failed to send the last trace spans: %v", err)
+	}
 }
 
-// registerHandlers registers all HTTP handlers for the server
-func registerHandlers(server *http.Server, tpcClient *database.TwoPhaseCommitClient) {
-	//for HTTP POST requests to add sensor data using 2PC
-	server.RegisterHandler(
-		http.POST,
-		"/data",
-		func(req *http.Request) *http.Response {
-			var sensorData types.SensorData
-			err := json.Unmarshal(req.Body, &sensorData)
-			if err != nil {
-				log.Printf("Error parsing sensor data: %v", err)
-				resp := http.NewResponse(http.StatusBadRequest)
-				resp.SetBodyString(fmt.Sprintf("Invalid JSON: %v", err))
-				return resp
-			}
-
-			//validate the data received
-			if sensorData.SensorID == "" {
-				resp := http.NewResponse(http.StatusBadRequest)
-				resp.SetBodyString("Missing sensorId")
-				return resp
-			}
-
-			//set timestamp to current time if not provided
-			if sensorData.Timestamp.IsZero() {
-				sensorData.Timestamp = time.Now()
-			}
-
-			//store the data using Two-Phase Commit across both databases
-			err = tpcClient.AddDataPointWithTwoPhaseCommit(sensorData)
-			if err != nil {
-				log.Printf("Error storing data with 2PC: %v", err)
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Error storing data: %v", err))
-				return resp
-			}
-
-			log.Printf(
-				"Stored data from sensor %s: %.2f %s using 2PC",
-				sensorData.SensorID,
-				sensorData.Value,
-				sensorData.Unit,
-			)
-
-			resp := http.NewResponse(http.StatusOK)
-			resp.SetBodyString("Data stored successfully using Two-Phase Commit")
-			return resp
-		},
-	)
-
-	//for HTTP GET requests to retrieve all sensor data
-	server.RegisterHandler(
-		http.GET,
-		"/data",
-		func(req *http.Request) *http.Response {
-			allData, err := tpcClient.GetAllDataPoints()
-			if err != nil {
-				log.Printf("Error retrieving data: %v", err)
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Error retrieving data: %v", err))
-				return resp
-			}
-
-			jsonData, err := json.Marshal(allData)
-			if err != nil {
-				log.Printf("Error marshaling data to JSON: %v", err)
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Server error: %v", err))
-				return resp
-			}
-
-			return http.CreateJSONResponse(http.StatusOK, jsonData)
-		},
-	)
-
-	//for HTTP GET requests to retrieve data for a specific sensor
-	server.RegisterHandler(
-		http.GET,
-		"/data/*",
-		func(req *http.Request) *http.Response {
-			//extract sensor ID from path
-			path := req.Path
-			if path == "/data/" {
-				resp := http.NewResponse(http.StatusBadRequest)
-				resp.SetBodyString("Missing sensor ID")
-				return resp
-			}
-
-			sensorID := path[6:] //remove "/data/" from the req path
-
-			sensorData, err := tpcClient.GetDataPointBySensorId(sensorID)
-			if err != nil {
-				log.Printf("Error retrieving data for sensor %s: %v", sensorID, err)
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Error retrieving data: %v", err))
-				return resp
-			}
-
-			if len(sensorData) == 0 {
-				resp := http.NewResponse(http.StatusNotFound)
-				resp.SetBodyString(fmt.Sprintf("No data found for sensor %s", sensorID))
-				return resp
-			}
-
-			jsonData, err := json.Marshal(sensorData)
-			if err != nil {
-				log.Printf("Error marshaling data to JSON: %v", err)
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Server error: %v", err))
-				return resp
-			}
-
-			return http.CreateJSONResponse(http.StatusOK, jsonData)
-		},
-	)
-
-	//for HTTP GET requests to the root path (for browser access)
-	server.RegisterHandler(
-		http.GET,
-		"/",
-		func(req *http.Request) *http.Response {
-			html := `
-				<!DOCTYPE html>
-				<html>
-				<head>
-					<title>IoT Data Viewer - Redundant Storage</title>
-					<style>
-						body { font-family: Arial, sans-serif; margin: 0; padding: 20px; }
-						h1 { color: #333; }
-						.info { background-color: #e8f4fd; padding: 10px; border-radius: 5px; margin-bottom: 20px; }
-						table { border-collapse: collapse; width: 100%; }
-						th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
-						th { background-color: #f2f2f2; }
-						tr:nth-child(even) { background-color: #f9f9f9; }
-					</style>
-					<script>
-						// Fetch data every x seconds
-						function fetchData() {
-							fetch('/data')
-								.then(response => response.json())
-								.then(data => {
-									const tableBody = document.getElementById('dataTable').getElementsByTagName('tbody')[0];
-									tableBody.innerHTML = '';
-									
-									// Sort by timestamp (newest first)
-									data.sort((a, b) => new Date(b.timestamp) - new Date(a.timestamp));
-									
-									data.forEach(item => {
-										const row = tableBody.insertRow();
-										row.insertCell(0).textContent = item.sensorId;
-										row.insertCell(1).textContent = new Date(item.timestamp).toLocaleString();
-										row.insertCell(2).textContent = item.value + ' ' + item.unit;
-									});
-								})
-								.catch(error => console.error('Error fetching data:', error));
-						}
-						
-						// Initial fetch and setup interval
-						document.addEventListener('DOMContentLoaded', () => {
-							fetchData();
-							setInterval(fetchData, 1000);
-						});
-					</script>
-				</head>
-				<body>
-					<h1>IoT Sensor Data - Redundant Storage</h1>
-					<div class="info">
-						<strong>Two-Phase Commit:</strong> Data is stored redundantly across two database servers for high availability.
-					</div>
-					<table id="dataTable">
-						<thead>
-							<tr>
-								<th>Sensor ID</th>
-								<th>Timestamp</th>
-								<th>Value</th>
-							</tr>
-						</thead>
-						<tbody>
-							<!-- Data will be inserted here by JavaScript -->
-						</tbody>
-					</table>
-				</body>
-				</html>
-			`
-			return http.CreateHTMLResponse(http.StatusOK, []byte(html))
-		},
-	)
-
-	//handler for performance testing of the 2PC interface
-	server.RegisterHandler(
-		http.GET,
-		"/performance/2pc",
-		func(req *http.Request) *http.Response {
-			iterations := 10_000 //smaller number for 2PC becuase it's mad expensive
-			min, max, avg, err := tpcClient.RunTwoPhaseCommitPerformanceTest(iterations)
-			if err != nil {
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("2PC performance test failed: %v", err))
-				return resp
-			}
-
-			result := map[string]interface{}{
-				"iterations": iterations,
-				"min_rtt":    min.String(),
-				"max_rtt":    max.String(),
-				"avg_rtt":    avg.String(),
-				"protocol":   "Two-Phase Commit",
-			}
-
-			jsonData, err := json.Marshal(result)
-			if err != nil {
-				resp := http.NewResponse(http.StatusServerError)
-				resp.SetBodyString(fmt.Sprintf("Error marshaling results: %v", err))
-				return resp
-			}
+// parseWeights parses a comma separated list of non-negative weights, an empty list keeps the defaults
+func parseWeights(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
 
-			return http.CreateJSONResponse(http.StatusOK, jsonData)
-		},
-	)
+	var weights []int
+	for _, field := range strings.Split(list, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight %q is not a non-negative integer", field)
+		}
+		weights = append(weights, weight)
+	}
+	return weights, nil
 }
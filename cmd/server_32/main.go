@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
 )
 
@@ -75,6 +76,7 @@ func main() {
 
 	server := http.ServerFactory(*host, *port)
 
+	logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	dataStore := DataStoreFactory(*dataLimit)
 
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrDuplicateAddress is returned by TwoPhaseCommitClientFactory if two database addresses refer to the same database:
+// nothing would be redundant, and every write fails since both prepares of a transaction reach the same process
+var ErrDuplicateAddress = errors.New("duplicate database address")
+
+// checkDuplicateAddresses fails with ErrDuplicateAddress if an address refers to the same database as an earlier one
+func checkDuplicateAddresses(addresses []string) error {
+	seen := make(map[string]string, len(addresses))
+	for _, addr := range addresses {
+		key := addressKey(addr)
+		if earlier, ok := seen[key]; ok {
+			return fmt.Errorf("%w: %s and %s are the same database, 2PC needs distinct replicas", ErrDuplicateAddress, earlier, addr)
+		}
+		seen[key] = addr
+	}
+	return nil
+}
+
+// addressKey returns a form of the address in which addresses of the same database are equal: the host is compared
+// case-insensitively and localhost, 127.0.0.1, ::1 and an empty host all mean this machine. Names are not resolved,
+// so two different names of one host are not detected
+func addressKey(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr //compared as it is, the connection reports the invalid address
+	}
+
+	host = strings.ToLower(host)
+	switch host {
+	case "", "localhost", "::1":
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
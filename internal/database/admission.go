@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrTooBusy is returned for a transaction refused because the limit of concurrent transactions is reached and the
+// policy is BusyReject, or no slot got free within the busy wait with BusyBlock; like ErrCapacityFull it is temporary
+// and the write can be retried later
+var ErrTooBusy = errors.New("too many 2PC transactions in progress")
+
+// DefaultBusyWait is how long a transaction waits for a free slot with BusyBlock unless WithBusyWait sets another time
+const DefaultBusyWait = 5 * time.Second
+
+// BusyPolicy decides what happens to a transaction that finds the limit of concurrent transactions reached
+type BusyPolicy int
+
+const (
+	BusyBlock  BusyPolicy = iota //wait until a running transaction finished, at most the busy wait or until the context of the caller is done
+	BusyReject                   //fail at once with ErrTooBusy
+)
+
+// String returns the name of the policy as accepted by ParseBusyPolicy
+func (p BusyPolicy) String() string {
+	switch p {
+	case BusyBlock:
+		return "block"
+	case BusyReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseBusyPolicy returns the policy with the given name ("block" or "reject")
+func ParseBusyPolicy(name string) (BusyPolicy, error) {
+	for _, policy := range []BusyPolicy{BusyBlock, BusyReject} {
+		if strings.EqualFold(name, policy.String()) {
+			return policy, nil
+		}
+	}
+	return BusyBlock, fmt.Errorf("unknown busy policy %q (expected block or reject)", name)
+}
+
+// admission bounds the number of 2PC rounds running at once, so a burst of writes does not pile up prepared
+// transactions on the databases and make them fight over their transaction locks
+type admission struct {
+	slots    *semaphore.Weighted //one unit per running transaction, nil = unlimited
+	limit    int
+	policy   BusyPolicy
+	maxWait  time.Duration //longest wait for a slot with BusyBlock, DefaultBusyWait if not positive
+	inFlight atomic.Int64  //transactions admitted and not finished yet
+	rejected atomic.Int64  //transactions refused with ErrTooBusy
+}
+
+// WithMaxConcurrentTransactions lets at most limit 2PC transactions run at once; a further one waits for a free slot
+// or fails with ErrTooBusy, depending on policy. Reads, manual transactions and the replay of degraded writes are not
+// counted. 0 disables the limit
+func WithMaxConcurrentTransactions(limit int, policy BusyPolicy) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.admission.limit = max(limit, 0)
+		tpc.admission.policy = policy
+		tpc.admission.slots = nil
+		if limit > 0 {
+			tpc.admission.slots = semaphore.NewWeighted(int64(limit))
+		}
+	}
+}
+
+// WithBusyWait bounds the wait for a free slot with BusyBlock, a transaction still waiting after wait fails with
+// ErrTooBusy like with BusyReject. 0 keeps DefaultBusyWait
+func WithBusyWait(wait time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.admission.maxWait = wait
+	}
+}
+
+// admit takes a slot for a transaction, see WithMaxConcurrentTransactions; every successful admit must be followed
+// by a release
+func (tpc *TwoPhaseCommitClient) admit(ctx context.Context) error {
+	a := &tpc.admission
+	if a.slots != nil {
+		if a.policy == BusyReject {
+			if !a.slots.TryAcquire(1) {
+				a.rejected.Add(1)
+				return fmt.Errorf("%w (limit %d)", ErrTooBusy, a.limit)
+			}
+		} else if err := a.acquire(ctx); err != nil {
+			return err
+		}
+	}
+
+	a.inFlight.Add(1)
+	return nil
+}
+
+// acquire waits for a free slot for at most the busy wait; after that the transaction is refused with ErrTooBusy, so
+// a blocked write is answered before its client gives up
+func (a *admission) acquire(ctx context.Context) error {
+	wait := a.maxWait
+	if wait <= 0 {
+		wait = DefaultBusyWait
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	if err := a.slots.Acquire(waitCtx, 1); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("waiting for a free transaction slot: %w", ctx.Err())
+		}
+		a.rejected.Add(1)
+		return fmt.Errorf("%w (no free slot of %d within %v)", ErrTooBusy, a.limit, wait)
+	}
+	return nil
+}
+
+// release frees the slot taken by admit
+func (tpc *TwoPhaseCommitClient) release() {
+	a := &tpc.admission
+	a.inFlight.Add(-1)
+	if a.slots != nil {
+		a.slots.Release(1)
+	}
+}
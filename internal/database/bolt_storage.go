@@ -0,0 +1,526 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// layout of the bolt file:
+//
+//	points:  insertion sequence -> encoded point, iterated in order for GetAll and the FIFO eviction
+//	sensors: one bucket per sensor ID, time key + insertion sequence -> empty, for the per-sensor (range) queries
+//	meta:    the number of stored points, so the eviction does not have to count on every write
+var (
+	boltPointsBucket  = []byte("points")
+	boltSensorsBucket = []byte("sensors")
+	boltMetaBucket    = []byte("meta")
+	boltCountKey      = []byte("count")
+)
+
+// BoltStorage is a Storage on disk backed by a bolt file. Every call runs in one bolt transaction, so a committed
+// 2PC write is durable once the commit returns and a crash never leaves part of a batch behind. Like MemoryStorage it
+// keeps at most limit points and evicts the oldest ones first
+type BoltStorage struct {
+	db    *bolt.DB
+	limit int
+}
+
+// BoltStorageFactory opens (or creates) the bolt file at path; the caller has to Close the storage
+func BoltStorageFactory(path string, limit int) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening bolt file %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltPointsBucket, boltSensorsBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing bolt file %s: %w", path, err)
+	}
+
+	return &BoltStorage{db: db, limit: limit}, nil
+}
+
+// Close closes the bolt file
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+// Add stores all readings in one transaction and applies the eviction in the same transaction
+func (b *BoltStorage) Add(readings []types.SensorData) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		count := boltCount(tx)
+		for _, point := range readings {
+			if err := boltPut(tx, point); err != nil {
+				return err
+			}
+			count++
+		}
+		return boltEvict(tx, count, b.limit)
+	})
+}
+
+// Upsert stores all readings in one transaction, looking up existing points in the time index of their sensor
+func (b *BoltStorage) Upsert(readings []types.SensorData) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		count := boltCount(tx)
+		for _, point := range readings {
+			replaced, err := boltReplace(tx, point)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				continue
+			}
+			if err := boltPut(tx, point); err != nil {
+				return err
+			}
+			count++
+		}
+		return boltEvict(tx, count, b.limit)
+	})
+}
+
+// GetAll returns all points in insertion order
+func (b *BoltStorage) GetAll() ([]types.SensorData, error) {
+	var result []types.SensorData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		result = make([]types.SensorData, 0, boltCount(tx))
+		return tx.Bucket(boltPointsBucket).ForEach(func(_, value []byte) error {
+			point, err := decodeBoltPoint(value)
+			if err != nil {
+				return err
+			}
+			result = append(result, point)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// GetBySensor returns the points of one sensor ordered by timestamp
+func (b *BoltStorage) GetBySensor(sensorID string) ([]types.SensorData, error) {
+	var result []types.SensorData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = boltSensorRange(tx, sensorID, nil, nil)
+		return err
+	})
+	return result, err
+}
+
+// GetBySensorRange returns the points of one sensor within [from, to) ordered by timestamp, seeking in the time index
+// instead of reading all points of the sensor
+func (b *BoltStorage) GetBySensorRange(sensorID string, from, to time.Time) ([]types.SensorData, error) {
+	var result []types.SensorData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = boltSensorRange(tx, sensorID, boltTimeKey(from), boltTimeKey(to))
+		return err
+	})
+	return result, err
+}
+
+// GetByPrefix returns the points of all sensors whose ID starts with prefix in insertion order
+func (b *BoltStorage) GetByPrefix(prefix string) ([]types.SensorData, error) {
+	var result []types.SensorData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		//the sensor buckets are sorted by ID, so the matching ones follow each other
+		var sequences [][]byte
+		sensors := tx.Bucket(boltSensorsBucket).Cursor()
+		for name, _ := sensors.Seek([]byte(prefix)); name != nil && strings.HasPrefix(string(name), prefix); name, _ = sensors.Next() {
+			tx.Bucket(boltSensorsBucket).Bucket(name).ForEach(func(key, _ []byte) error {
+				sequences = append(sequences, key[8:])
+				return nil
+			})
+		}
+
+		sort.Slice(sequences, func(i, j int) bool {
+			return bytes.Compare(sequences[i], sequences[j]) < 0
+		})
+
+		var err error
+		result, err = boltLookup(tx, sequences)
+		return err
+	})
+	return result, err
+}
+
+// GetBySensors returns the points of several sensors grouped by sensor ID, each group ordered by timestamp
+func (b *BoltStorage) GetBySensors(sensorIDs []string) (map[string][]types.SensorData, error) {
+	groups := make(map[string][]types.SensorData)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, sensorID := range sensorIDs {
+			if _, ok := groups[sensorID]; ok {
+				continue
+			}
+			points, err := boltSensorRange(tx, sensorID, nil, nil)
+			if err != nil {
+				return err
+			}
+			if len(points) > 0 {
+				groups[sensorID] = points
+			}
+		}
+		return nil
+	})
+	return groups, err
+}
+
+// Update replaces value and unit of the oldest stored point matching sensor ID and timestamp
+func (b *BoltStorage) Update(update types.SensorData) (bool, error) {
+	updated := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		updated, err = boltReplace(tx, update)
+		return err
+	})
+	return updated, err
+}
+
+// Patch applies the patch to the oldest stored point matching sensor ID and timestamp
+func (b *BoltStorage) Patch(patch types.SensorDataPatch) (bool, error) {
+	patched := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		sequence := boltFind(tx, patch.SensorID, patch.Timestamp)
+		if sequence == nil {
+			return nil
+		}
+
+		points := tx.Bucket(boltPointsBucket)
+		point, err := decodeBoltPoint(points.Get(sequence))
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeBoltPoint(patch.Apply(point))
+		if err != nil {
+			return err
+		}
+		patched = true
+		return points.Put(sequence, encoded)
+	})
+	return patched, err
+}
+
+// Delete removes all points of a sensor
+func (b *BoltStorage) Delete(sensorID string) (int, error) {
+	removed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		sensor := boltSensorBucket(tx, sensorID)
+		if sensor == nil {
+			return nil
+		}
+
+		points := tx.Bucket(boltPointsBucket)
+		err := sensor.ForEach(func(key, _ []byte) error {
+			removed++
+			return points.Delete(key[8:])
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(boltSensorsBucket).DeleteBucket([]byte(sensorID)); err != nil {
+			return err
+		}
+		return boltSetCount(tx, boltCount(tx)-removed)
+	})
+	return removed, err
+}
+
+// DeleteAll removes all points
+func (b *BoltStorage) DeleteAll() (int, error) {
+	removed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		removed = boltCount(tx)
+		return boltReset(tx)
+	})
+	return removed, err
+}
+
+// Replace swaps the content in one transaction, keeping only the newest points if there are more than the limit
+func (b *BoltStorage) Replace(data []types.SensorData) error {
+	if len(data) > b.limit {
+		data = data[len(data)-b.limit:]
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := boltReset(tx); err != nil {
+			return err
+		}
+		for _, point := range data {
+			if err := boltPut(tx, point); err != nil {
+				return err
+			}
+		}
+		return boltSetCount(tx, len(data))
+	})
+}
+
+// Count returns the number of stored points
+func (b *BoltStorage) Count() (int, error) {
+	count := 0
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = boltCount(tx)
+		return nil
+	})
+	return count, err
+}
+
+// boltPut stores a point under the next insertion sequence and adds it to the time index of its sensor
+func boltPut(tx *bolt.Tx, point types.SensorData) error {
+	if point.SensorID == "" {
+		return errors.New("missing sensor ID")
+	}
+
+	encoded, err := encodeBoltPoint(point)
+	if err != nil {
+		return err
+	}
+
+	points := tx.Bucket(boltPointsBucket)
+	next, err := points.NextSequence()
+	if err != nil {
+		return err
+	}
+	sequence := binary.BigEndian.AppendUint64(nil, next)
+	if err := points.Put(sequence, encoded); err != nil {
+		return err
+	}
+
+	sensor, err := tx.Bucket(boltSensorsBucket).CreateBucketIfNotExists([]byte(point.SensorID))
+	if err != nil {
+		return err
+	}
+	return sensor.Put(append(boltTimeKey(point.Timestamp), sequence...), nil)
+}
+
+// boltReplace overwrites the oldest stored point with the sensor ID and timestamp of point, reporting whether there was one
+func boltReplace(tx *bolt.Tx, point types.SensorData) (bool, error) {
+	sequence := boltFind(tx, point.SensorID, point.Timestamp)
+	if sequence == nil {
+		return false, nil
+	}
+
+	encoded, err := encodeBoltPoint(point)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Bucket(boltPointsBucket).Put(sequence, encoded)
+}
+
+// boltFind returns the sequence of the oldest point of the sensor with the timestamp, nil if there is none
+func boltFind(tx *bolt.Tx, sensorID string, timestamp time.Time) []byte {
+	sensor := boltSensorBucket(tx, sensorID)
+	if sensor == nil {
+		return nil
+	}
+
+	timeKey := boltTimeKey(timestamp)
+	key, _ := sensor.Cursor().Seek(timeKey)
+	if key == nil || !bytes.Equal(key[:8], timeKey) {
+		return nil
+	}
+	//keys passed to Put have to stay valid for the whole transaction, the cursor's key may not
+	return bytes.Clone(key[8:])
+}
+
+// boltEvict removes the oldest points following FIFO while there are more than limit and stores the new count
+func boltEvict(tx *bolt.Tx, count, limit int) error {
+	points := tx.Bucket(boltPointsBucket).Cursor()
+	for ; count > limit; count-- {
+		key, value := points.First()
+		if key == nil {
+			break
+		}
+		point, err := decodeBoltPoint(value)
+		if err != nil {
+			return err
+		}
+		if err := boltRemove(tx, key, point); err != nil {
+			return err
+		}
+	}
+	return boltSetCount(tx, count)
+}
+
+// boltRemove deletes the point stored under sequence from both buckets, dropping the sensor bucket once it is empty
+func boltRemove(tx *bolt.Tx, sequence []byte, point types.SensorData) error {
+	if err := tx.Bucket(boltPointsBucket).Delete(sequence); err != nil {
+		return err
+	}
+
+	sensor := boltSensorBucket(tx, point.SensorID)
+	if sensor == nil {
+		return nil
+	}
+	if err := sensor.Delete(append(boltTimeKey(point.Timestamp), sequence...)); err != nil {
+		return err
+	}
+	if key, _ := sensor.Cursor().First(); key == nil {
+		return tx.Bucket(boltSensorsBucket).DeleteBucket([]byte(point.SensorID))
+	}
+	return nil
+}
+
+// boltSensorRange returns the points of a sensor with from <= time key < to, a nil bound is open
+func boltSensorRange(tx *bolt.Tx, sensorID string, from, to []byte) ([]types.SensorData, error) {
+	sensor := boltSensorBucket(tx, sensorID)
+	if sensor == nil {
+		return nil, nil
+	}
+
+	var sequences [][]byte
+	cursor := sensor.Cursor()
+	key, _ := cursor.First()
+	if from != nil {
+		key, _ = cursor.Seek(from)
+	}
+	for ; key != nil; key, _ = cursor.Next() {
+		if to != nil && bytes.Compare(key[:8], to) >= 0 {
+			break
+		}
+		sequences = append(sequences, key[8:])
+	}
+
+	return boltLookup(tx, sequences)
+}
+
+// boltLookup reads the points stored under the given sequences
+func boltLookup(tx *bolt.Tx, sequences [][]byte) ([]types.SensorData, error) {
+	if len(sequences) == 0 {
+		return nil, nil
+	}
+
+	points := tx.Bucket(boltPointsBucket)
+	result := make([]types.SensorData, len(sequences))
+	for i, sequence := range sequences {
+		value := points.Get(sequence)
+		if value == nil {
+			return nil, fmt.Errorf("time index points to missing point %x", sequence)
+		}
+		point, err := decodeBoltPoint(value)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = point
+	}
+	return result, nil
+}
+
+// boltSensorBucket returns the time index of a sensor, nil if it has no points
+func boltSensorBucket(tx *bolt.Tx, sensorID string) *bolt.Bucket {
+	if sensorID == "" {
+		return nil
+	}
+	return tx.Bucket(boltSensorsBucket).Bucket([]byte(sensorID))
+}
+
+// boltReset empties the points and sensors buckets
+func boltReset(tx *bolt.Tx) error {
+	for _, name := range [][]byte{boltPointsBucket, boltSensorsBucket} {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return boltSetCount(tx, 0)
+}
+
+// boltCount returns the number of stored points as kept in the meta bucket
+func boltCount(tx *bolt.Tx) int {
+	value := tx.Bucket(boltMetaBucket).Get(boltCountKey)
+	if len(value) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
+
+// boltSetCount updates the number of stored points in the meta bucket
+func boltSetCount(tx *bolt.Tx, count int) error {
+	return tx.Bucket(boltMetaBucket).Put(boltCountKey, binary.BigEndian.AppendUint64(nil, uint64(count)))
+}
+
+// boltTimeKey encodes a timestamp so that the byte order of the keys is the order in time, also before 1970
+func boltTimeKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 16), uint64(t.UnixNano())^(1<<63))
+}
+
+// encodeBoltPoint encodes a point like a single entry of the binary snapshot format
+func encodeBoltPoint(point types.SensorData) ([]byte, error) {
+	if len(point.SensorID) > math.MaxUint16 || len(point.Unit) > math.MaxUint16 {
+		return nil, fmt.Errorf("sensor ID or unit of %q too long to store", point.SensorID)
+	}
+
+	encoded := make([]byte, 0, 36+len(point.SensorID)+len(point.Unit))
+	encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(point.SensorID)))
+	encoded = append(encoded, point.SensorID...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(point.Timestamp.UnixNano()))
+	encoded = binary.BigEndian.AppendUint64(encoded, math.Float64bits(point.Value))
+	encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(point.Unit)))
+	encoded = append(encoded, point.Unit...)
+	encoded = binary.BigEndian.AppendUint64(encoded, uint64(unixNanoOrZero(point.IngestedAt)))
+	encoded = binary.BigEndian.AppendUint64(encoded, point.Sequence)
+	return encoded, nil
+}
+
+// decodeBoltPoint reads a point written by encodeBoltPoint
+func decodeBoltPoint(encoded []byte) (types.SensorData, error) {
+	var point types.SensorData
+	readString := func() (string, bool) {
+		if len(encoded) < 2 {
+			return "", false
+		}
+		length := int(binary.BigEndian.Uint16(encoded))
+		if len(encoded) < 2+length {
+			return "", false
+		}
+		value := string(encoded[2 : 2+length])
+		encoded = encoded[2+length:]
+		return value, true
+	}
+
+	sensorID, ok := readString()
+	if !ok || len(encoded) < 16 {
+		return point, errors.New("truncated point in bolt file")
+	}
+	point.SensorID = sensorID
+	point.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(encoded)))
+	point.Value = math.Float64frombits(binary.BigEndian.Uint64(encoded[8:]))
+	encoded = encoded[16:]
+
+	unit, ok := readString()
+	if !ok {
+		return point, errors.New("truncated point in bolt file")
+	}
+	point.Unit = unit
+
+	//points written before the ingestion time or the store sequence was recorded end after the unit or the time
+	if len(encoded) >= 8 {
+		point.IngestedAt = timeFromUnixNano(int64(binary.BigEndian.Uint64(encoded)))
+		encoded = encoded[8:]
+	}
+	if len(encoded) >= 8 {
+		point.Sequence = binary.BigEndian.Uint64(encoded)
+	}
+	return point, nil
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling a replica whose circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open, replica skipped")
+
+// BreakerState is the state of a replica's circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //calls go through, failures are counted
+	BreakerOpen                         //calls fail fast until the cooldown has passed
+	BreakerHalfOpen                     //a single call goes through as probe, its result closes or reopens the breaker
+)
+
+// String returns the name of the state (used in logs and stats)
+func (b BreakerState) String() string {
+	switch b {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker tracks consecutive failures of a single replica
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int           //consecutive failures
+	threshold int           //consecutive failures that open the breaker, 0 disables the breaker
+	cooldown  time.Duration //time the breaker stays open before probing
+	openedAt  time.Time
+	probeAt   time.Time //start of the probe in flight while half-open
+}
+
+// newCircuitBreaker creates a closed breaker
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a call may be sent and must be followed by record of its outcome. An open breaker turns
+// half-open once the cooldown has passed and then lets a single probe through; other calls fail fast until the probe
+// is recorded. A probe whose outcome is never recorded is replaced by a new one after another cooldown
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 || cb.state == BreakerClosed {
+		return true
+	}
+
+	now := time.Now()
+	switch cb.state {
+	case BreakerOpen:
+		if now.Sub(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if now.Sub(cb.probeAt) < cb.cooldown {
+			return false
+		}
+	}
+	cb.probeAt = now
+	return true
+}
+
+// record updates the breaker with the outcome of a call
+func (cb *circuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 {
+		return
+	}
+
+	if success {
+		cb.failures = 0
+		cb.state = BreakerClosed
+		return
+	}
+
+	cb.failures++
+
+	//a failed probe reopens immediately, otherwise wait for the threshold
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = BreakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// snapshot returns the current state and number of consecutive failures
+func (cb *circuitBreaker) snapshot() (BreakerState, int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	//report an expired open breaker as half-open, that is how the next call will see it
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+		return BreakerHalfOpen, cb.failures
+	}
+	return cb.state, cb.failures
+}
@@ -4,40 +4,184 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/grpc/status"
 
 	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
 )
 
+// ErrCapacityFull is returned when a transaction was aborted because a database is at capacity; unlike other
+// failures it is temporary and the write can be retried later
+var ErrCapacityFull = errors.New("database at capacity")
+
+// ErrDataNotFound is returned when a patch transaction was aborted because a database does not store the point
+var ErrDataNotFound = errors.New("data not found")
+
+// ErrRejected is returned when a database voted no because the data failed its validation (see WithPrepareValidator)
+var ErrRejected = errors.New("data rejected by a database")
+
+// connectPingTimeout bounds a single reachability check of WithConnectRetry
+const connectPingTimeout = 2 * time.Second
+
 // Client represents a client for the database service
 type Client struct {
 	conn   *grpc.ClientConn
 	client pb.DatabaseServiceClient
+	ctx    context.Context //parent of the contexts of all calls, see withContext; context.Background() if nil
+}
+
+// withContext returns a client on the same connection whose calls are made under ctx, e.g. so the spans of the calls
+// of a 2PC phase become children of the phase span. The deadlines of the calls still apply
+func (c *Client) withContext(ctx context.Context) *Client {
+	if ctx == c.parentContext() {
+		return c
+	}
+	return &Client{conn: c.conn, client: c.client, ctx: ctx}
+}
+
+// parentContext returns the context the calls of the client derive their contexts from
+func (c *Client) parentContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // TwoPhaseCommitClient manages our new 2PC operations across multiple(2) database instances
 type TwoPhaseCommitClient struct {
-	clients []*Client
-	timeout time.Duration
+	clients   []*Client
+	addresses []string
+	timeout   time.Duration
+	dryRun    bool              //prepare on all databases but always abort, leaving the data untouched
+	breakers  []*circuitBreaker //one per replica, in address order
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	readStrategy ReadStrategy
+	readWeights  []int          //read weight per replica in address order, see WithReplicaWeights
+	readCounter  atomic.Uint64  //position of the round-robin read strategy
+	reads        []atomic.Int64 //successful reads served per replica
+
+	sequence atomic.Uint64   //last commit sequence assigned, see nextSequence
+	applied  []atomic.Uint64 //highest commit sequence known to be applied per replica
+
+	degraded degradedState //pending log of writes committed without every replica, see WithDegradedWrites
+	drain    drainState    //transactions in progress, see DrainAndClose
+
+	admission admission         //limit of concurrent transactions, see WithMaxConcurrentTransactions
+	cache     *readCache        //recent reads by sensor ID, nil = disabled, see WithReadCache
+	lease     *CoordinatorLease //leadership among several servers, nil = always coordinate, see WithCoordinatorLease
+
+	readyWait time.Duration //how long a transaction waits for a replica connection to become ready, 0 = not at all
+
+	clientOptions []ClientOption //applied to the connection of every replica, see WithClientOptions
+
+	connectCheck      bool          //probe every replica before the factory returns, see WithConnectRetry
+	connectTimeout    time.Duration //how long the factory keeps retrying an unreachable replica, 0 = one attempt
+	connectBackoff    time.Duration //wait after the first failed attempt, doubled after every further one
+	connectMaxBackoff time.Duration
+}
+
+// ReplicaStats holds the circuit breaker state of a single replica
+type ReplicaStats struct {
+	Address             string `json:"address"`
+	BreakerState        string `json:"breakerState"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	ReadWeight          int    `json:"readWeight"`
+	Reads               int64  `json:"reads"`           //successful reads served by the replica
+	PendingWrites       int    `json:"pendingWrites"`   //degraded writes the replica has not caught up on yet
+	ConnectionState     string `json:"connectionState"` //state of the gRPC connection, e.g. READY or TRANSIENT_FAILURE
+}
+
+// TwoPhaseCommitStats holds runtime information about the 2PC client
+type TwoPhaseCommitStats struct {
+	Replicas      []ReplicaStats `json:"replicas"`
+	Degraded      bool           `json:"degraded"`        //some replica misses writes that were committed in degraded mode
+	InFlight      int64          `json:"inFlight"`        //2PC transactions running right now
+	MaxConcurrent int            `json:"maxConcurrent"`   //limit of concurrent transactions, 0 = unlimited
+	Rejected      int64          `json:"rejected"`        //transactions refused with ErrTooBusy since the client was created
+	CacheEntries  int            `json:"cacheEntries"`    //sensors in the read cache, see WithReadCache
+	CacheHits     int64          `json:"cacheHits"`       //reads answered from the cache
+	CacheMisses   int64          `json:"cacheMisses"`     //reads the cache had to pass on to a replica
+	Lease         *LeaseStats    `json:"lease,omitempty"` //coordinator lease, see WithCoordinatorLease
+}
+
+// TwoPhaseCommitOption configures optional behavior of a TwoPhaseCommitClient
+type TwoPhaseCommitOption func(*TwoPhaseCommitClient)
+
+// WithDryRun makes every 2PC write a dry run: prepare is sent to validate connectivity and voting, then the transaction is aborted
+func WithDryRun() TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.dryRun = true
+	}
+}
+
+// FlushResult holds the outcome of a flush on a single database replica
+type FlushResult struct {
+	Address       string `json:"address"`
+	Success       bool   `json:"success"`
+	PointsWritten int64  `json:"pointsWritten"`
+	FilePath      string `json:"filePath"`
+	Message       string `json:"message"`
+}
+
+// TwoPhaseCommitBreakdown holds the timing of a single 2PC transaction, the per replica slices follow the address order
+type TwoPhaseCommitBreakdown struct {
+	Total        time.Duration
+	Prepare      []time.Duration //prepare round trip per replica
+	Commit       []time.Duration //commit round trip per replica (zero if the transaction was aborted)
+	Coordination time.Duration   //time spent in the coordinator itself, Total minus all replica round trips
+}
+
+// SlowestReplica returns the index of the replica with the longest prepare plus commit time
+func (b *TwoPhaseCommitBreakdown) SlowestReplica() int {
+	slowest := 0
+	for i := range b.Prepare {
+		if b.Prepare[i]+b.Commit[i] > b.Prepare[slowest]+b.Commit[slowest] {
+			slowest = i
+		}
+	}
+	return slowest
 }
 
 // ClientFactory creates a new client connected to the database service
-func ClientFactory(serverAddr string) (*Client, error) {
-	//set up the conn to our server
-	conn, err := grpc.NewClient(serverAddr,
+func ClientFactory(serverAddr string, opts ...ClientOption) (*Client, error) {
+	config := defaultClientConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	dialOptions := append([]grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(200*1024*1024), //200MB receive limit
 			grpc.MaxCallSendMsgSize(200*1024*1024), //200MB send limit
 		),
-	)
+	}, config.dialOptions()...)
+
+	//only connections made after tracing.Init are traced, so disabled tracing costs nothing per call
+	if tracing.Enabled() {
+		dialOptions = append(dialOptions, grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()))
+	}
+
+	//set up the conn to our server
+	conn, err := grpc.NewClient(serverAddr, dialOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database server: %w", err)
 	}
@@ -52,14 +196,29 @@ func ClientFactory(serverAddr string) (*Client, error) {
 }
 
 // TwoPhaseCommitClientFactory creates a new 2PC client that manages multiple database connections
-func TwoPhaseCommitClientFactory(serverAddresses []string) (*TwoPhaseCommitClient, error) {
+func TwoPhaseCommitClientFactory(serverAddresses []string, opts ...TwoPhaseCommitOption) (*TwoPhaseCommitClient, error) {
 	if len(serverAddresses) < 2 {
 		return nil, fmt.Errorf("2PC requires at least 2 database addresses, got %d", len(serverAddresses))
 	}
+	if err := checkDuplicateAddresses(serverAddresses); err != nil {
+		return nil, err
+	}
+
+	tpc := &TwoPhaseCommitClient{
+		addresses:        serverAddresses,
+		timeout:          30 * time.Second, //30 second timeout for 2PC operations
+		breakerThreshold: 5,
+		breakerCooldown:  10 * time.Second,
+	}
+
+	//the options come first, some of them configure the connections
+	for _, opt := range opts {
+		opt(tpc)
+	}
 
 	clients := make([]*Client, len(serverAddresses))
 	for i, addr := range serverAddresses {
-		client, err := ClientFactory(addr)
+		client, err := ClientFactory(addr, tpc.clientOptions...)
 		if err != nil {
 			//when creating a TwoPhaseCommitClient for our case here, we need to connect to multiple databases.
 			//if any connection fails, we should clean up the connections that were already successful.
@@ -73,11 +232,219 @@ func TwoPhaseCommitClientFactory(serverAddresses []string) (*TwoPhaseCommitClien
 		}
 		clients[i] = client
 	}
+	tpc.clients = clients
 
-	return &TwoPhaseCommitClient{
-		clients: clients,
-		timeout: 30 * time.Second, //30 second timeout for 2PC operations
-	}, nil
+	if tpc.connectCheck {
+		if err := tpc.waitForReplicas(); err != nil {
+			for _, client := range clients {
+				client.Close()
+			}
+			return nil, err
+		}
+	}
+
+	tpc.breakers = make([]*circuitBreaker, len(clients))
+	for i := range clients {
+		tpc.breakers[i] = newCircuitBreaker(tpc.breakerThreshold, tpc.breakerCooldown)
+	}
+	tpc.reads = make([]atomic.Int64, len(clients))
+	tpc.applied = make([]atomic.Uint64, len(clients))
+	tpc.startReconciler()
+	tpc.startLease()
+
+	return tpc, nil
+}
+
+// WithReadyWait makes every transaction wait up to timeout for the connection of each replica to become ready before
+// sending the prepare, e.g. right after a database restarted; a replica that is still not ready then fails its
+// prepare as usual. Replicas with an open circuit breaker are not waited for
+func WithReadyWait(timeout time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.readyWait = timeout
+	}
+}
+
+// WithClientOptions applies the options to the connection of every replica, e.g. WithKeepalive
+func WithClientOptions(opts ...ClientOption) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.clientOptions = append(tpc.clientOptions, opts...)
+	}
+}
+
+// WithConnectRetry makes the factory check that every database answers before it returns, since the connections
+// are only opened lazily otherwise. An unreachable database is retried after initialBackoff, doubling the wait up to
+// maxBackoff, until timeout has passed; then the factory fails. A timeout of 0 fails on the first unreachable database
+func WithConnectRetry(timeout, initialBackoff, maxBackoff time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.connectCheck = true
+		tpc.connectTimeout = timeout
+		tpc.connectBackoff = initialBackoff
+		tpc.connectMaxBackoff = maxBackoff
+	}
+}
+
+// WithCircuitBreaker configures the per replica circuit breakers: after threshold consecutive failed calls a replica is
+// skipped (counting as a no-vote) for the cooldown, then probed again. A threshold of 0 disables the breakers
+func WithCircuitBreaker(threshold int, cooldown time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.breakerThreshold = threshold
+		tpc.breakerCooldown = cooldown
+	}
+}
+
+// Stats returns the circuit breaker state and read distribution of every replica
+func (tpc *TwoPhaseCommitClient) Stats() TwoPhaseCommitStats {
+	stats := TwoPhaseCommitStats{
+		Replicas:      make([]ReplicaStats, len(tpc.clients)),
+		InFlight:      tpc.admission.inFlight.Load(),
+		MaxConcurrent: tpc.admission.limit,
+		Rejected:      tpc.admission.rejected.Load(),
+	}
+	if tpc.cache != nil {
+		stats.CacheEntries, stats.CacheHits, stats.CacheMisses = tpc.cache.counts()
+	}
+	if tpc.lease != nil {
+		lease := tpc.lease.Stats()
+		stats.Lease = &lease
+	}
+	pending := tpc.pendingWrites()
+
+	for i, breaker := range tpc.breakers {
+		state, failures := breaker.snapshot()
+		stats.Replicas[i] = ReplicaStats{
+			Address:             tpc.addresses[i],
+			BreakerState:        state.String(),
+			ConsecutiveFailures: failures,
+			ReadWeight:          tpc.readWeight(i),
+			Reads:               tpc.reads[i].Load(),
+			PendingWrites:       pending[i],
+			ConnectionState:     tpc.clients[i].State().String(),
+		}
+		stats.Degraded = stats.Degraded || pending[i] > 0
+	}
+
+	return stats
+}
+
+// overloadCause returns ErrCapacityFull if a replica refused the prepare because it is at capacity, either with an
+// overloaded no-vote or a ResourceExhausted gRPC error, and nil otherwise
+func overloadCause(responses []*pb.PrepareResponse, errs []error) error {
+	for i, err := range errs {
+		if status.Code(err) == codes.ResourceExhausted || (err == nil && responses[i] != nil && responses[i].Overloaded) {
+			return ErrCapacityFull
+		}
+	}
+	return nil
+}
+
+// notFoundCause returns ErrDataNotFound if a replica refused the prepare because it does not store the point to
+// patch, and nil otherwise
+func notFoundCause(responses []*pb.PrepareResponse, errs []error) error {
+	for i, err := range errs {
+		if err == nil && responses[i] != nil && responses[i].NotFound {
+			return ErrDataNotFound
+		}
+	}
+	return nil
+}
+
+// rejectedCause returns ErrRejected with the reason given by the first replica that refused the prepare because the
+// data failed its validation, and nil otherwise
+func rejectedCause(responses []*pb.PrepareResponse, errs []error) error {
+	for i, err := range errs {
+		if err == nil && responses[i] != nil && responses[i].Rejected {
+			return fmt.Errorf("%w: %s", ErrRejected, responses[i].Message)
+		}
+	}
+	return nil
+}
+
+// isReplicaFailure reports whether an error means the replica could not be reached (a gRPC error),
+// as opposed to a reachable replica rejecting the request
+func isReplicaFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := status.FromError(err)
+	return ok
+}
+
+// DryRun reports whether the client only performs dry runs
+func (tpc *TwoPhaseCommitClient) DryRun() bool {
+	return tpc.dryRun
+}
+
+// State returns the state of the connection to the database
+func (c *Client) State() connectivity.State {
+	return c.conn.GetState()
+}
+
+// WaitForReady waits up to timeout for the connection to become ready and reports whether it did. An idle connection
+// is connected and one that failed is retried right away instead of after gRPC's reconnect backoff, so a restarted
+// database is picked up as soon as it accepts connections
+func (c *Client) WaitForReady(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		state := c.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return true
+		case connectivity.Idle:
+			c.conn.Connect()
+		case connectivity.TransientFailure:
+			c.conn.ResetConnectBackoff()
+		}
+
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return false
+		}
+	}
+}
+
+// Ping checks that the database answers within timeout; unlike a normal call it does not wait out the reconnect
+// backoff of a connection that failed before
+func (c *Client) Ping(timeout time.Duration) error {
+	c.conn.ResetConnectBackoff()
+
+	ctx, cancel := context.WithTimeout(c.parentContext(), timeout)
+	defer cancel()
+
+	if _, err := c.client.GetAppliedSequence(ctx, &pb.EmptyRequest{}); err != nil {
+		return fmt.Errorf("database not reachable: %w", err)
+	}
+	return nil
+}
+
+// waitForReplicas pings every replica in turn, retrying an unreachable one with exponential backoff until the
+// connect timeout has passed since the first attempt
+func (tpc *TwoPhaseCommitClient) waitForReplicas() error {
+	deadline := time.Now().Add(tpc.connectTimeout)
+
+	for i, client := range tpc.clients {
+		backoff := tpc.connectBackoff
+		for attempt := 1; ; attempt++ {
+			//a single attempt must not outlast the deadline, but even without any time left one attempt is made
+			timeout := connectPingTimeout
+			if remaining := time.Until(deadline); remaining > 0 && remaining < timeout {
+				timeout = remaining
+			}
+
+			err := client.Ping(timeout)
+			if err == nil {
+				break
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return fmt.Errorf("failed to connect to database %s after %d attempts: %w", tpc.addresses[i], attempt, err)
+			}
+
+			logging.Warnf("Database %s not reachable yet (attempt %d), retrying in %v: %v", tpc.addresses[i], attempt, backoff, err)
+			time.Sleep(backoff)
+			backoff = min(2*backoff, tpc.connectMaxBackoff)
+		}
+	}
+	return nil
 }
 
 // Close closes the client connection
@@ -85,8 +452,12 @@ func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
-// Close closes all client connections in the 2PC client
+// Close stops the reconciliation of degraded writes, releases the coordinator lease and closes all client
+// connections in the 2PC client
 func (tpc *TwoPhaseCommitClient) Close() error {
+	tpc.stopReconciler()
+	tpc.stopLease()
+
 	var lastError error
 	for _, client := range tpc.clients {
 		if err := client.Close(); err != nil {
@@ -108,41 +479,201 @@ func generateTransactionID() string {
 
 // AddDataPoint adds a new sensor data point to the database (direct, non-2PC)
 func (c *Client) AddDataPoint(sensorData types.SensorData) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	resp, err := c.AddDataPointDetailed(sensorData)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("failed to add data point: %s", resp.Message)
+	}
+
+	return nil
+}
+
+// AddDataPointDetailed adds a new sensor data point and returns the raw response of the database
+func (c *Client) AddDataPointDetailed(sensorData types.SensorData) (*pb.OperationResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
-	req := &pb.SensorDataRequest{
-		SensorId:  sensorData.SensorID,
-		Timestamp: timestamppb.New(sensorData.Timestamp),
-		Value:     sensorData.Value,
-		Unit:      sensorData.Unit,
+	resp, err := c.client.CreateSensorData(ctx, toSensorDataRequest(sensorData))
+	if err != nil {
+		return nil, fmt.Errorf("error adding data point: %w", err)
 	}
 
-	resp, err := c.client.CreateSensorData(ctx, req)
+	return resp, nil
+}
+
+// UpdateDataPoint updates the value and unit of the data point matching the sensor ID and timestamp
+func (c *Client) UpdateDataPoint(sensorData types.SensorData) error {
+	resp, err := c.UpdateDataPointDetailed(sensorData)
 	if err != nil {
-		return fmt.Errorf("error adding data point: %w", err)
+		return err
 	}
 
 	if !resp.Success {
-		return fmt.Errorf("failed to add data point: %s", resp.Message)
+		return fmt.Errorf("failed to update data point: %s", resp.Message)
 	}
 
 	return nil
 }
 
+// UpdateDataPointDetailed updates a data point and returns the raw response of the database
+func (c *Client) UpdateDataPointDetailed(sensorData types.SensorData) (*pb.OperationResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.UpdateSensorData(ctx, toSensorDataRequest(sensorData))
+	if err != nil {
+		return nil, fmt.Errorf("error updating data point: %w", err)
+	}
+
+	return resp, nil
+}
+
+// PatchDataPoint changes only the fields set in the patch of the data point matching the sensor ID and timestamp
+func (c *Client) PatchDataPoint(patch types.SensorDataPatch) error {
+	resp, err := c.PatchDataPointDetailed(patch)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("failed to patch data point: %s", resp.Message)
+	}
+
+	return nil
+}
+
+// PatchDataPointDetailed patches a data point and returns the raw response of the database
+func (c *Client) PatchDataPointDetailed(patch types.SensorDataPatch) (*pb.OperationResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.PatchSensorData(ctx, toSensorDataPatch(patch))
+	if err != nil {
+		return nil, fmt.Errorf("error patching data point: %w", err)
+	}
+
+	return resp, nil
+}
+
+// DeleteDataPoint deletes all data points of a sensor
+func (c *Client) DeleteDataPoint(sensorID string) error {
+	resp, err := c.DeleteDataPointDetailed(sensorID)
+	if err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("failed to delete data for sensor %s: %s", sensorID, resp.Message)
+	}
+
+	return nil
+}
+
+// DeleteDataPointDetailed deletes all data points of a sensor and returns the raw response of the database
+func (c *Client) DeleteDataPointDetailed(sensorID string) (*pb.OperationResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.DeleteSensorData(ctx, &pb.SensorIdRequest{SensorId: sensorID})
+	if err != nil {
+		return nil, fmt.Errorf("error deleting data for sensor %s: %w", sensorID, err)
+	}
+
+	return resp, nil
+}
+
+// toSensorDataRequest converts sensor data into its protobuf request
+func toSensorDataRequest(sensorData types.SensorData) *pb.SensorDataRequest {
+	return &pb.SensorDataRequest{
+		SensorId:  sensorData.SensorID,
+		Timestamp: timestampToProto(sensorData.Timestamp),
+		Value:     sensorData.Value,
+		Unit:      sensorData.Unit,
+	}
+}
+
+// toSensorDataPatch converts a patch into its protobuf message, fields that are not set stay unset
+func toSensorDataPatch(patch types.SensorDataPatch) *pb.SensorDataPatch {
+	return &pb.SensorDataPatch{
+		SensorId:  patch.SensorID,
+		Timestamp: timestampToProto(patch.Timestamp),
+		Value:     patch.Value,
+		Unit:      patch.Unit,
+	}
+}
+
 // PrepareTransaction sends a prepare request to the database (Phase 1 of 2PC)
 func (c *Client) PrepareTransaction(transactionID string, sensorData types.SensorData) (*pb.PrepareResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.TransactionRequest{
+		TransactionId: transactionID,
+		SensorData:    toSensorDataRequest(sensorData),
+	}
+
+	resp, err := c.client.PrepareTransaction(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing transaction %s: %w", transactionID, err)
+	}
+
+	return resp, nil
+}
+
+// PrepareDeleteAll sends a prepare request for removing all data to the database (Phase 1 of 2PC)
+func (c *Client) PrepareDeleteAll(transactionID string) (*pb.PrepareResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.TransactionRequest{
+		TransactionId: transactionID,
+		Operation:     pb.TransactionOperation_TRANSACTION_OPERATION_DELETE_ALL,
+	}
+
+	resp, err := c.client.PrepareTransaction(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing transaction %s: %w", transactionID, err)
+	}
+
+	return resp, nil
+}
+
+// PrepareBatch sends a prepare request for adding several readings at once to the database (Phase 1 of 2PC)
+func (c *Client) PrepareBatch(transactionID string, readings []types.SensorData) (*pb.PrepareResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	batch := make([]*pb.SensorDataRequest, len(readings))
+	for i, sensorData := range readings {
+		batch[i] = toSensorDataRequest(sensorData)
+	}
+
+	req := &pb.TransactionRequest{
+		TransactionId: transactionID,
+		Operation:     pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH,
+		Batch:         batch,
+	}
+
+	resp, err := c.client.PrepareTransaction(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing transaction %s: %w", transactionID, err)
+	}
+
+	return resp, nil
+}
+
+// PreparePatch sends a prepare request for a partial update of a stored point to the database (Phase 1 of 2PC)
+func (c *Client) PreparePatch(transactionID string, patch types.SensorDataPatch) (*pb.PrepareResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	req := &pb.TransactionRequest{
 		TransactionId: transactionID,
-		SensorData: &pb.SensorDataRequest{
-			SensorId:  sensorData.SensorID,
-			Timestamp: timestamppb.New(sensorData.Timestamp),
-			Value:     sensorData.Value,
-			Unit:      sensorData.Unit,
-		},
+		Operation:     pb.TransactionOperation_TRANSACTION_OPERATION_PATCH,
+		Patch:         toSensorDataPatch(patch),
 	}
 
 	resp, err := c.client.PrepareTransaction(ctx, req)
@@ -155,28 +686,54 @@ func (c *Client) PrepareTransaction(transactionID string, sensorData types.Senso
 
 // CommitTransaction sends a commit request to the database (Phase 2 of 2PC)
 func (c *Client) CommitTransaction(transactionID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_, err := c.CommitTransactionDetailed(transactionID)
+	return err
+}
+
+// CommitTransactionDetailed sends a commit request and returns the raw response of a successful commit
+func (c *Client) CommitTransactionDetailed(transactionID string) (*pb.OperationResponse, error) {
+	return c.CommitTransactionWithSequence(transactionID, 0)
+}
+
+// CommitTransactionWithSequence commits a transaction under a coordinator assigned commit sequence, which the
+// database reports as applied afterwards (see AppliedSequence)
+func (c *Client) CommitTransactionWithSequence(transactionID string, sequence uint64) (*pb.OperationResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	req := &pb.TransactionId{
 		TransactionId: transactionID,
+		Sequence:      sequence,
 	}
 
 	resp, err := c.client.CommitTransaction(ctx, req)
 	if err != nil {
-		return fmt.Errorf("error committing transaction %s: %w", transactionID, err)
+		return nil, fmt.Errorf("error committing transaction %s: %w", transactionID, err)
 	}
 
 	if !resp.Success {
-		return fmt.Errorf("failed to commit transaction %s: %s", transactionID, resp.Message)
+		return nil, fmt.Errorf("failed to commit transaction %s: %s", transactionID, resp.Message)
 	}
 
-	return nil
+	return resp, nil
+}
+
+// AppliedSequence returns the highest commit sequence the database has applied
+func (c *Client) AppliedSequence() (uint64, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.GetAppliedSequence(ctx, &pb.EmptyRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("error getting applied sequence: %w", err)
+	}
+
+	return resp.AppliedSequence, nil
 }
 
 // AbortTransaction sends an abort request to the database (Phase 2 of 2PC)
 func (c *Client) AbortTransaction(transactionID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	req := &pb.TransactionId{
@@ -197,28 +754,220 @@ func (c *Client) AbortTransaction(transactionID string) error {
 
 // AddDataPointWithTwoPhaseCommit performs a full 2PC operation to add sensor data across all databases
 func (tpc *TwoPhaseCommitClient) AddDataPointWithTwoPhaseCommit(sensorData types.SensorData) error {
+	_, err := tpc.addDataPointWithTwoPhaseCommit(context.Background(), sensorData, nil, tpc.dryRun)
+	return err
+}
+
+// AddDataPointWithSequence performs the 2PC add and returns the commit sequence of the write; reads through
+// ReadAtLeast with that sequence are guaranteed to see it (0 in a dry run, where nothing is committed)
+func (tpc *TwoPhaseCommitClient) AddDataPointWithSequence(sensorData types.SensorData) (uint64, error) {
+	return tpc.addDataPointWithTwoPhaseCommit(context.Background(), sensorData, nil, tpc.dryRun)
+}
+
+// AddDataPointWithSequenceContext is AddDataPointWithSequence with the spans of the transaction started as children
+// of the span in ctx, e.g. the span of the HTTP request that carried the reading
+func (tpc *TwoPhaseCommitClient) AddDataPointWithSequenceContext(ctx context.Context, sensorData types.SensorData) (uint64, error) {
+	return tpc.addDataPointWithTwoPhaseCommit(ctx, sensorData, nil, tpc.dryRun)
+}
+
+// DryRunTwoPhaseCommit prepares the sensor data on all databases and then aborts, leaving the data untouched.
+// It returns nil only if every database is reachable and voted yes
+func (tpc *TwoPhaseCommitClient) DryRunTwoPhaseCommit(sensorData types.SensorData) error {
+	_, err := tpc.addDataPointWithTwoPhaseCommit(context.Background(), sensorData, nil, true)
+	return err
+}
+
+// AddDataPointWithTwoPhaseCommitDetailed performs a full 2PC add and returns the timing breakdown of the transaction
+func (tpc *TwoPhaseCommitClient) AddDataPointWithTwoPhaseCommitDetailed(sensorData types.SensorData) (*TwoPhaseCommitBreakdown, error) {
+	breakdown := &TwoPhaseCommitBreakdown{
+		Prepare: make([]time.Duration, len(tpc.clients)),
+		Commit:  make([]time.Duration, len(tpc.clients)),
+	}
+
+	start := time.Now()
+	_, err := tpc.addDataPointWithTwoPhaseCommit(context.Background(), sensorData, breakdown, tpc.dryRun)
+	breakdown.Total = time.Since(start)
+
+	//whatever is not spent waiting for a replica is spent in the coordinator
+	breakdown.Coordination = breakdown.Total
+	for i := range tpc.clients {
+		breakdown.Coordination -= breakdown.Prepare[i] + breakdown.Commit[i]
+	}
+
+	return breakdown, err
+}
+
+// addDataPointWithTwoPhaseCommit runs the 2PC add and returns its commit sequence, recording the phase timings
+// into breakdown if it is not nil
+func (tpc *TwoPhaseCommitClient) addDataPointWithTwoPhaseCommit(ctx context.Context, sensorData types.SensorData, breakdown *TwoPhaseCommitBreakdown, dryRun bool) (uint64, error) {
+	transactionID := generateTransactionID()
+
+	logging.Debugf("Starting 2PC transaction %s for sensor %s", transactionID, sensorData.SensorID)
+
+	_, sequence, err := tpc.runTwoPhaseCommit(ctx, transactionID, func(client *Client, transactionID string) (*pb.PrepareResponse, error) {
+		return client.PrepareTransaction(transactionID, sensorData)
+	}, breakdown, dryRun)
+	if !dryRun {
+		tpc.invalidateCache(sensorData.SensorID)
+	}
+	return sequence, err
+}
+
+// AddDataPointsWithTwoPhaseCommit adds several readings across all databases in a single 2PC transaction,
+// so either every reading is stored on every database or none is
+func (tpc *TwoPhaseCommitClient) AddDataPointsWithTwoPhaseCommit(readings []types.SensorData) error {
+	return tpc.AddDataPointsWithTwoPhaseCommitContext(context.Background(), readings)
+}
+
+// AddDataPointsWithTwoPhaseCommitContext is AddDataPointsWithTwoPhaseCommit with the spans of the transaction started
+// as children of the span in ctx
+func (tpc *TwoPhaseCommitClient) AddDataPointsWithTwoPhaseCommitContext(ctx context.Context, readings []types.SensorData) error {
+	_, err := tpc.addDataPointsWithTwoPhaseCommit(ctx, readings, tpc.dryRun)
+	return err
+}
+
+// AddDataPointsWithSequenceContext adds the readings in a single 2PC transaction like
+// AddDataPointsWithTwoPhaseCommitContext and returns the commit sequence of the write, which covers every reading of
+// the batch (0 in a dry run, where nothing is committed)
+func (tpc *TwoPhaseCommitClient) AddDataPointsWithSequenceContext(ctx context.Context, readings []types.SensorData) (uint64, error) {
+	return tpc.addDataPointsWithTwoPhaseCommit(ctx, readings, tpc.dryRun)
+}
+
+// DryRunBatchTwoPhaseCommit prepares the readings as one batch on all databases and then aborts, leaving the data
+// untouched. It returns nil only if every database is reachable and voted yes for the whole batch
+func (tpc *TwoPhaseCommitClient) DryRunBatchTwoPhaseCommit(ctx context.Context, readings []types.SensorData) error {
+	_, err := tpc.addDataPointsWithTwoPhaseCommit(ctx, readings, true)
+	return err
+}
+
+// addDataPointsWithTwoPhaseCommit runs the 2PC batch add and returns its commit sequence
+func (tpc *TwoPhaseCommitClient) addDataPointsWithTwoPhaseCommit(ctx context.Context, readings []types.SensorData, dryRun bool) (uint64, error) {
+	transactionID := generateTransactionID()
+
+	logging.Debugf("Starting 2PC transaction %s for a batch of %d readings", transactionID, len(readings))
+
+	_, sequence, err := tpc.runTwoPhaseCommit(ctx, transactionID, func(client *Client, transactionID string) (*pb.PrepareResponse, error) {
+		return client.PrepareBatch(transactionID, readings)
+	}, nil, dryRun)
+	if !dryRun {
+		sensorIDs := make([]string, len(readings))
+		for i, reading := range readings {
+			sensorIDs[i] = reading.SensorID
+		}
+		tpc.invalidateCache(sensorIDs...)
+	}
+	return sequence, err
+}
+
+// PatchDataPointWithTwoPhaseCommit applies a partial update to the point on every database using 2PC and returns the
+// highest number of points patched on a single database; if a database does not store the point, the transaction is
+// aborted with ErrDataNotFound
+func (tpc *TwoPhaseCommitClient) PatchDataPointWithTwoPhaseCommit(patch types.SensorDataPatch) (int64, error) {
+	transactionID := generateTransactionID()
+
+	logging.Debugf("Starting 2PC transaction %s to patch sensor %s", transactionID, patch.SensorID)
+
+	affected, _, err := tpc.runTwoPhaseCommit(context.Background(), transactionID, func(client *Client, transactionID string) (*pb.PrepareResponse, error) {
+		return client.PreparePatch(transactionID, patch)
+	}, nil, tpc.dryRun)
+	if !tpc.dryRun {
+		tpc.invalidateCache(patch.SensorID)
+	}
+	return affected, err
+}
+
+// DeleteAllWithTwoPhaseCommit removes all data from every database using 2PC and returns the number of removed points
+func (tpc *TwoPhaseCommitClient) DeleteAllWithTwoPhaseCommit() (int64, error) {
 	transactionID := generateTransactionID()
 
-	log.Printf("Starting 2PC transaction %s for sensor %s", transactionID, sensorData.SensorID)
+	logging.Debugf("Starting 2PC transaction %s to delete all data", transactionID)
+
+	affected, _, err := tpc.runTwoPhaseCommit(context.Background(), transactionID, (*Client).PrepareDeleteAll, nil, tpc.dryRun)
+	if !tpc.dryRun {
+		tpc.invalidateCache()
+	}
+	return affected, err
+}
+
+// runTwoPhaseCommit prepares the transaction on all databases and then commits or aborts it.
+// It returns the highest number of points affected on a single database and the commit sequence of the transaction. Phase timings are recorded into breakdown if it is not nil.
+// In a dry run the transaction is aborted even if all databases voted yes. With degraded writes enabled, a transaction
+// that only failed to prepare on unreachable databases is committed on the others (see commitDegraded).
+// If tracing is enabled, the transaction gets a span under the span in ctx with one child span per phase; the span of
+// phase 2 links to the one of phase 1. With WithMaxConcurrentTransactions the transaction first needs a free slot
+func (tpc *TwoPhaseCommitClient) runTwoPhaseCommit(ctx context.Context, transactionID string, prepare prepareFunc, breakdown *TwoPhaseCommitBreakdown, dryRun bool) (affected int64, sequence uint64, err error) {
+	if !tpc.beginTransaction() {
+		return 0, 0, ErrClientClosed
+	}
+	defer tpc.endTransaction()
+
+	if err := tpc.checkLeader(); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tpc.admit(ctx); err != nil {
+		return 0, 0, err
+	}
+	defer tpc.release()
+
+	ctx, span := tracing.Start(ctx, "2PC transaction")
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String("tpc.transaction_id", transactionID),
+			attribute.Int("tpc.databases", len(tpc.clients)),
+			attribute.Bool("tpc.dry_run", dryRun),
+		)
+	}
+	defer func() {
+		if err != nil {
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}()
 
 	//phase 1: Prepare
-	log.Printf("Phase 1: Preparing transaction %s across %d databases", transactionID, len(tpc.clients))
+	logging.Debugf("Phase 1: Preparing transaction %s across %d databases", transactionID, len(tpc.clients))
+	prepareCtx, prepareSpan := tracing.Start(ctx, "2PC prepare")
 
 	prepareResponses := make([]*pb.PrepareResponse, len(tpc.clients))
 	prepareErrors := make([]error, len(tpc.clients))
 
 	//send prepare to all databases
 	for i, client := range tpc.clients {
-		resp, err := client.PrepareTransaction(transactionID, sensorData)
+		//a replica that still has to catch up on degraded writes gets this one queued as well, so it replays them in order
+		if tpc.isLagging(i) {
+			prepareErrors[i] = errReplicaLagging
+			logging.Warnf("Prepare skipped for database %d: %v", i, errReplicaLagging)
+			continue
+		}
+
+		//a replica with an open breaker fails fast and counts as a no-vote
+		if !tpc.breakers[i].allow() {
+			prepareErrors[i] = ErrCircuitOpen
+			logging.Warnf("Prepare skipped for database %d: %v", i, ErrCircuitOpen)
+			continue
+		}
+
+		//a reconnecting replica gets the chance to come back before it would vote no
+		if tpc.readyWait > 0 && client.State() != connectivity.Ready && !client.WaitForReady(tpc.readyWait) {
+			logging.Warnf("Database %d not ready after %v, preparing anyway", i, tpc.readyWait)
+		}
+
+		prepareStart := time.Now()
+		resp, err := prepare(client.withContext(prepareCtx), transactionID)
+		if breakdown != nil {
+			breakdown.Prepare[i] = time.Since(prepareStart)
+		}
+		tpc.breakers[i].record(!isReplicaFailure(err))
 		prepareResponses[i] = resp
 		prepareErrors[i] = err
 
 		if err != nil {
-			log.Printf("Prepare failed for database %d: %v", i, err)
+			logging.Warnf("Prepare failed for database %d: %v", i, err)
 		} else if !resp.Success {
-			log.Printf("Prepare rejected by database %d: %s", i, resp.Message)
+			logging.Warnf("Prepare rejected by database %d: %s", i, resp.Message)
 		} else {
-			log.Printf("Prepare successful for database %d", i)
+			logging.Debugf("Prepare successful for database %d", i)
 		}
 	}
 
@@ -231,69 +980,150 @@ func (tpc *TwoPhaseCommitClient) AddDataPointWithTwoPhaseCommit(sensorData types
 		}
 	}
 
+	prepareSpan.SetAttributes(attribute.Bool("tpc.all_prepared", allPrepared))
+	prepareSpan.End()
+
 	//phase 2: Commit or Abort
-	if allPrepared {
-		log.Printf("Phase 2: All databases prepared successfully, committing transaction %s", transactionID)
-		return tpc.commitAll(transactionID)
+	if allPrepared && dryRun {
+		logging.Debugf("Phase 2: Dry run, all databases voted yes, aborting transaction %s", transactionID)
+		phaseCtx, phaseSpan := startSecondPhase(ctx, "2PC abort", prepareCtx)
+		defer phaseSpan.End()
+		return 0, 0, tpc.abortAll(phaseCtx, transactionID, prepareErrors, true)
+	} else if allPrepared {
+		logging.Debugf("Phase 2: All databases prepared successfully, committing transaction %s", transactionID)
+		phaseCtx, phaseSpan := startSecondPhase(ctx, "2PC commit", prepareCtx)
+		defer phaseSpan.End()
+		sequence := tpc.nextSequence()
+		affected, err := tpc.commitAll(phaseCtx, transactionID, sequence, breakdown)
+		return affected, sequence, err
+	} else if survivors, ok := tpc.degradedSurvivors(prepareResponses, prepareErrors); ok && !dryRun {
+		log.Printf("Phase 2: Write quorum lost, committing transaction %s in degraded mode on %d of %d databases", transactionID, len(survivors), len(tpc.clients))
+		phaseCtx, phaseSpan := startSecondPhase(ctx, "2PC commit", prepareCtx, attribute.Bool("tpc.degraded", true))
+		defer phaseSpan.End()
+		sequence := tpc.nextSequence()
+		affected, err := tpc.commitDegraded(phaseCtx, transactionID, sequence, prepare, survivors, breakdown)
+		return affected, sequence, err
 	} else {
-		log.Printf("Phase 2: One or more databases failed to prepare, aborting transaction %s", transactionID)
-		return tpc.abortAll(transactionID)
+		logging.Warnf("Phase 2: One or more databases failed to prepare, aborting transaction %s", transactionID)
+		phaseCtx, phaseSpan := startSecondPhase(ctx, "2PC abort", prepareCtx)
+		defer phaseSpan.End()
+		err := tpc.abortAll(phaseCtx, transactionID, prepareErrors, false)
+		if cause := overloadCause(prepareResponses, prepareErrors); cause != nil {
+			err = fmt.Errorf("%w: %w", err, cause)
+		} else if cause := notFoundCause(prepareResponses, prepareErrors); cause != nil {
+			err = fmt.Errorf("%w: %w", err, cause)
+		} else if cause := rejectedCause(prepareResponses, prepareErrors); cause != nil {
+			err = fmt.Errorf("%w: %w", err, cause)
+		}
+		return 0, 0, err
+	}
+}
+
+// startSecondPhase starts the span of phase 2 as a child of the transaction span in ctx, linked to the span of phase 1
+// in prepareCtx, so a trace viewer can go from the votes to the decision
+func startSecondPhase(ctx context.Context, name string, prepareCtx context.Context, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !tracing.Enabled() {
+		return tracing.Start(ctx, name)
 	}
+	return tracing.Start(ctx, name, trace.WithLinks(trace.LinkFromContext(prepareCtx)), trace.WithAttributes(attributes...))
 }
 
-// commitAll sends commit to all databases
-func (tpc *TwoPhaseCommitClient) commitAll(transactionID string) error {
+// commitAll sends commit to all databases and returns the highest number of points affected on a single database
+func (tpc *TwoPhaseCommitClient) commitAll(ctx context.Context, transactionID string, sequence uint64, breakdown *TwoPhaseCommitBreakdown) (int64, error) {
 	var lastError error
+	var affected int64
 	successCount := 0
 
 	for i, client := range tpc.clients {
-		err := client.CommitTransaction(transactionID)
+		commitStart := time.Now()
+		resp, err := client.withContext(ctx).CommitTransactionWithSequence(transactionID, sequence)
+		if breakdown != nil {
+			breakdown.Commit[i] = time.Since(commitStart)
+		}
+		tpc.breakers[i].record(!isReplicaFailure(err))
 		if err != nil {
-			log.Printf("Commit failed for database %d: %v", i, err)
+			logging.Warnf("Commit failed for database %d: %v", i, err)
 			lastError = err
 		} else {
-			log.Printf("Commit successful for database %d", i)
+			logging.Debugf("Commit successful for database %d", i)
+			tpc.recordApplied(i, resp.AppliedSequence)
 			successCount++
+			affected = max(affected, resp.PointsAffected)
 		}
 	}
 
 	if successCount == len(tpc.clients) {
-		log.Printf("Transaction %s committed successfully across all %d databases", transactionID, successCount)
-		return nil
+		logging.Debugf("Transaction %s committed successfully across all %d databases", transactionID, successCount)
+		return affected, nil
 	} else {
-		return fmt.Errorf("transaction %s: only %d of %d databases committed successfully, last error: %v",
+		return affected, fmt.Errorf("transaction %s: only %d of %d databases committed successfully, last error: %v",
 			transactionID, successCount, len(tpc.clients), lastError)
 	}
 }
 
-// abortAll sends abort to all databases
-func (tpc *TwoPhaseCommitClient) abortAll(transactionID string) error {
+// abortAll sends abort to all databases the prepare was sent to; an abort after a successful dry run only returns an
+// error if an abort failed. Aborts are best effort and bypass the circuit breakers: they neither use up the probe of
+// a half-open breaker nor count as a sign of health, e.g. when a replica answers that it never prepared the transaction
+func (tpc *TwoPhaseCommitClient) abortAll(ctx context.Context, transactionID string, prepareErrors []error, dryRun bool) error {
 	var lastError error
 	abortCount := 0
 
 	for i, client := range tpc.clients {
-		err := client.AbortTransaction(transactionID)
+		//nothing was prepared on a replica that was skipped
+		if errors.Is(prepareErrors[i], ErrCircuitOpen) || errors.Is(prepareErrors[i], errReplicaLagging) {
+			logging.Debugf("Abort skipped for database %d: %v", i, prepareErrors[i])
+			continue
+		}
+
+		err := client.withContext(ctx).AbortTransaction(transactionID)
 		if err != nil {
-			log.Printf("Abort failed for database %d: %v", i, err)
+			logging.Warnf("Abort failed for database %d: %v", i, err)
 			lastError = err
 		} else {
-			log.Printf("Abort successful for database %d", i)
+			logging.Debugf("Abort successful for database %d", i)
 			abortCount++
 		}
 	}
 
-	log.Printf("Transaction %s aborted on %d of %d databases", transactionID, abortCount, len(tpc.clients))
+	//every dry run ends in an abort, that is only worth a warning if a real transaction failed
+	if dryRun {
+		logging.Debugf("Transaction %s aborted on %d of %d databases", transactionID, abortCount, len(tpc.clients))
+	} else {
+		logging.Warnf("Transaction %s aborted on %d of %d databases", transactionID, abortCount, len(tpc.clients))
+	}
 
 	if lastError != nil {
 		return fmt.Errorf("transaction %s aborted, but some abort operations failed: %v", transactionID, lastError)
 	}
 
+	if dryRun {
+		logging.Debugf("Dry run of transaction %s completed, no data was changed", transactionID)
+		return nil
+	}
+
 	return fmt.Errorf("transaction %s was aborted due to prepare phase failures", transactionID)
 }
 
+// DeleteAllDataPoints removes all data from the database directly (non-2PC) and returns the number of removed points
+func (c *Client) DeleteAllDataPoints() (int64, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.DeleteAllSensorData(ctx, &pb.EmptyRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting all data points: %w", err)
+	}
+
+	if !resp.Success {
+		return 0, fmt.Errorf("failed to delete all data points: %s", resp.Message)
+	}
+
+	return resp.PointsAffected, nil
+}
+
 // GetAllDataPoints returns all stored sensor data from the first database
 func (c *Client) GetAllDataPoints() ([]types.SensorData, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	resp, err := c.client.GetAllSensorData(ctx, &pb.EmptyRequest{})
@@ -301,32 +1131,23 @@ func (c *Client) GetAllDataPoints() ([]types.SensorData, error) {
 		return nil, fmt.Errorf("error getting all data points: %w", err)
 	}
 
-	result := make([]types.SensorData, len(resp.Data))
-	for i, data := range resp.Data {
-		result[i] = types.SensorData{
-			SensorID:  data.SensorId,
-			Timestamp: data.Timestamp.AsTime(),
-			Value:     data.Value,
-			Unit:      data.Unit,
-		}
-	}
-
-	return result, nil
+	return protoListToSensorData(resp.Data), nil
 }
 
-// GetAllDataPoints returns all stored sensor data from the first database (2PC client)
+// GetAllDataPoints returns all stored sensor data from the replica chosen by the read strategy (2PC client)
 func (tpc *TwoPhaseCommitClient) GetAllDataPoints() ([]types.SensorData, error) {
-	if len(tpc.clients) == 0 {
-		return nil, fmt.Errorf("no database clients available")
-	}
+	return readFromReplicas(tpc, 0, (*Client).GetAllDataPoints)
+}
 
-	//for read operations, we can use any database, but here i have taken the first one
-	return tpc.clients[0].GetAllDataPoints()
+// GetAllDataPointsFrom returns all stored sensor data from the given replica only (2PC client), so the store
+// sequences of successive reads can be compared
+func (tpc *TwoPhaseCommitClient) GetAllDataPointsFrom(replica int) ([]types.SensorData, error) {
+	return readFromReplica(tpc, replica, (*Client).GetAllDataPoints)
 }
 
 // GetDataPointBySensorId returns data for a specific sensor
 func (c *Client) GetDataPointBySensorId(sensorID string) ([]types.SensorData, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	resp, err := c.client.GetSensorDataBySensorId(ctx, &pb.SensorIdRequest{
@@ -336,27 +1157,180 @@ func (c *Client) GetDataPointBySensorId(sensorID string) ([]types.SensorData, er
 		return nil, fmt.Errorf("error getting data points for sensor %s: %w", sensorID, err)
 	}
 
-	result := make([]types.SensorData, len(resp.Data))
-	for i, data := range resp.Data {
-		result[i] = types.SensorData{
-			SensorID:  data.SensorId,
-			Timestamp: data.Timestamp.AsTime(),
-			Value:     data.Value,
-			Unit:      data.Unit,
+	return protoListToSensorData(resp.Data), nil
+}
+
+// GetDataPointBySensorId returns data for a specific sensor from the replica chosen by the read strategy (2PC client),
+// or from the read cache if WithReadCache is set and the sensor was read recently
+func (tpc *TwoPhaseCommitClient) GetDataPointBySensorId(sensorID string) ([]types.SensorData, error) {
+	if tpc.cache == nil {
+		return readFromReplicas(tpc, 0, func(client *Client) ([]types.SensorData, error) {
+			return client.GetDataPointBySensorId(sensorID)
+		})
+	}
+
+	data, generation, ok := tpc.cache.lookup(sensorID)
+	if ok {
+		return data, nil
+	}
+	data, err := readFromReplicas(tpc, 0, func(client *Client) ([]types.SensorData, error) {
+		return client.GetDataPointBySensorId(sensorID)
+	})
+	if err == nil {
+		tpc.cache.store(sensorID, data, generation)
+	}
+	return data, err
+}
+
+// Flush asks the database to write a snapshot of its data to disk
+func (c *Client) Flush() (*pb.FlushResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 30*time.Second)
+	defer cancel()
+
+	resp, err := c.client.FlushSnapshot(ctx, &pb.EmptyRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error flushing database: %w", err)
+	}
+
+	return resp, nil
+}
+
+// FlushAll fans a flush out to every database and reports each replica's result
+func (tpc *TwoPhaseCommitClient) FlushAll() []FlushResult {
+	results := make([]FlushResult, len(tpc.clients))
+
+	for i, client := range tpc.clients {
+		results[i].Address = tpc.addresses[i]
+
+		resp, err := client.Flush()
+		if err != nil {
+			log.Printf("Flush failed for database %d: %v", i, err)
+			results[i].Message = err.Error()
+			continue
 		}
+
+		results[i].Success = resp.Success
+		results[i].PointsWritten = resp.PointsWritten
+		results[i].FilePath = resp.FilePath
+		results[i].Message = resp.Message
 	}
 
-	return result, nil
+	return results
 }
 
-// GetDataPointBySensorId returns data for a specific sensor (2PC client)
-func (tpc *TwoPhaseCommitClient) GetDataPointBySensorId(sensorID string) ([]types.SensorData, error) {
-	if len(tpc.clients) == 0 {
-		return nil, fmt.Errorf("no database clients available")
+// ReplicaOutcome holds the result of a single, manually driven 2PC phase on one database replica
+type ReplicaOutcome struct {
+	Address string `json:"address"`
+	Success bool   `json:"success"` //the vote for a prepare, the outcome for a commit or abort
+	Message string `json:"message"`
+}
+
+// ManualPrepare runs only phase 1 for the sensor data on every database and returns the new transaction ID with each
+// replica's vote. The transaction stays prepared until ManualCommit or ManualAbort is called with that ID
+func (tpc *TwoPhaseCommitClient) ManualPrepare(sensorData types.SensorData) (string, []ReplicaOutcome) {
+	transactionID := generateTransactionID()
+	outcomes := make([]ReplicaOutcome, len(tpc.clients))
+
+	for i, client := range tpc.clients {
+		outcomes[i].Address = tpc.addresses[i]
+
+		resp, err := client.PrepareTransaction(transactionID, sensorData)
+		if err != nil {
+			log.Printf("Manual prepare of transaction %s failed for database %d: %v", transactionID, i, err)
+			outcomes[i].Message = err.Error()
+			continue
+		}
+
+		outcomes[i].Success = resp.Success
+		outcomes[i].Message = resp.Message
 	}
 
-	//for read operations, we can use any database, but here i have taken the first one
-	return tpc.clients[0].GetDataPointBySensorId(sensorID)
+	return transactionID, outcomes
+}
+
+// ManualCommit runs phase 2 as a commit of a manually prepared transaction on every database
+func (tpc *TwoPhaseCommitClient) ManualCommit(transactionID string) []ReplicaOutcome {
+	//the coordinator does not know which sensor the transaction wrote, so the whole read cache goes
+	defer tpc.invalidateCache()
+	return tpc.manualPhase(transactionID, "commit", (*Client).CommitTransaction)
+}
+
+// ManualAbort runs phase 2 as an abort of a manually prepared transaction on every database
+func (tpc *TwoPhaseCommitClient) ManualAbort(transactionID string) []ReplicaOutcome {
+	return tpc.manualPhase(transactionID, "abort", (*Client).AbortTransaction)
+}
+
+// manualPhase sends a commit or abort to every database and reports each replica's outcome
+func (tpc *TwoPhaseCommitClient) manualPhase(transactionID, phase string, send func(client *Client, transactionID string) error) []ReplicaOutcome {
+	outcomes := make([]ReplicaOutcome, len(tpc.clients))
+
+	for i, client := range tpc.clients {
+		outcomes[i].Address = tpc.addresses[i]
+
+		if err := send(client, transactionID); err != nil {
+			log.Printf("Manual %s of transaction %s failed for database %d: %v", phase, transactionID, i, err)
+			outcomes[i].Message = err.Error()
+			continue
+		}
+
+		outcomes[i].Success = true
+		outcomes[i].Message = fmt.Sprintf("%s of transaction %s succeeded", phase, transactionID)
+	}
+
+	return outcomes
+}
+
+// GetDataPointsByPrefix returns data for all sensors whose ID starts with the prefix
+func (c *Client) GetDataPointsByPrefix(prefix string) ([]types.SensorData, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.GetSensorDataByPrefix(ctx, &pb.SensorPrefixRequest{
+		Prefix: prefix,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting data points for prefix %s: %w", prefix, err)
+	}
+
+	return protoListToSensorData(resp.Data), nil
+}
+
+// GetDataPointsByPrefix returns data for all sensors whose ID starts with the prefix (2PC client)
+func (tpc *TwoPhaseCommitClient) GetDataPointsByPrefix(prefix string) ([]types.SensorData, error) {
+	return readFromReplicas(tpc, 0, func(client *Client) ([]types.SensorData, error) {
+		return client.GetDataPointsByPrefix(prefix)
+	})
+}
+
+// GetDataPointsByIds returns the data of several sensors in one call, grouped by sensor ID (IDs without data map to an empty slice)
+func (c *Client) GetDataPointsByIds(sensorIDs []string) (map[string][]types.SensorData, error) {
+	if len(sensorIDs) > MaxSensorIdsPerRequest {
+		return nil, fmt.Errorf("too many sensor IDs: %d (max %d)", len(sensorIDs), MaxSensorIdsPerRequest)
+	}
+
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.GetSensorDataByIds(ctx, &pb.SensorIdsRequest{
+		SensorIds: sensorIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting data points for %d sensors: %w", len(sensorIDs), err)
+	}
+
+	result := make(map[string][]types.SensorData, len(resp.Groups))
+	for sensorID, group := range resp.Groups {
+		result[sensorID] = protoListToSensorData(group.Data)
+	}
+
+	return result, nil
+}
+
+// GetDataPointsByIds returns the data of several sensors grouped by sensor ID (2PC client)
+func (tpc *TwoPhaseCommitClient) GetDataPointsByIds(sensorIDs []string) (map[string][]types.SensorData, error) {
+	return readFromReplicas(tpc, 0, func(client *Client) (map[string][]types.SensorData, error) {
+		return client.GetDataPointsByIds(sensorIDs)
+	})
 }
 
 // MeasureRPCLatency measures the round-trip time for an RPC call
@@ -371,12 +1345,12 @@ func (c *Client) MeasureRPCLatency() (time.Duration, error) {
 	//to measure time for a round-trip call
 	start := time.Now()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
 	defer cancel()
 
 	req := &pb.SensorDataRequest{
 		SensorId:  dummySensorData.SensorID,
-		Timestamp: timestamppb.New(dummySensorData.Timestamp),
+		Timestamp: timestampToProto(dummySensorData.Timestamp),
 		Value:     dummySensorData.Value,
 		Unit:      dummySensorData.Unit,
 	}
@@ -391,6 +1365,16 @@ func (c *Client) MeasureRPCLatency() (time.Duration, error) {
 
 // MeasureTwoPhaseCommitLatency measures the round-trip time for a 2PC operation
 func (tpc *TwoPhaseCommitClient) MeasureTwoPhaseCommitLatency() (time.Duration, error) {
+	breakdown, err := tpc.MeasureTwoPhaseCommitLatencyDetailed()
+	if err != nil {
+		return 0, err
+	}
+
+	return breakdown.Total, nil
+}
+
+// MeasureTwoPhaseCommitLatencyDetailed measures a 2PC operation and returns the per replica and per phase breakdown
+func (tpc *TwoPhaseCommitClient) MeasureTwoPhaseCommitLatencyDetailed() (*TwoPhaseCommitBreakdown, error) {
 	sensorData := types.SensorData{
 		SensorID:  "2pc-perf-test",
 		Timestamp: time.Now(),
@@ -398,13 +1382,12 @@ func (tpc *TwoPhaseCommitClient) MeasureTwoPhaseCommitLatency() (time.Duration,
 		Unit:      "test",
 	}
 
-	start := time.Now()
-	err := tpc.AddDataPointWithTwoPhaseCommit(sensorData)
+	breakdown, err := tpc.AddDataPointWithTwoPhaseCommitDetailed(sensorData)
 	if err != nil {
-		return 0, fmt.Errorf("error during 2PC performance test: %w", err)
+		return nil, fmt.Errorf("error during 2PC performance test: %w", err)
 	}
 
-	return time.Since(start), nil
+	return breakdown, nil
 }
 
 // RunPerformanceTest runs a simple performance test and returns statistics
@@ -440,20 +1423,37 @@ func (c *Client) RunPerformanceTest(iterations int) (min, max, avg time.Duration
 	return min, max, avg, nil
 }
 
-// RunTwoPhaseCommitPerformanceTest runs a 2PC performance test
+// RunTwoPhaseCommitPerformanceTest runs a 2PC performance test; the results cover the successful iterations, it fails
+// if none succeeded
 func (tpc *TwoPhaseCommitClient) RunTwoPhaseCommitPerformanceTest(iterations int) (min, max, avg time.Duration, err error) {
 	log.Printf("Running 2PC performance test with %d iterations across %d databases", iterations, len(tpc.clients))
 
 	var total time.Duration
 	min = time.Hour
 
+	//per replica sums of the phase timings of the successful iterations
+	prepareTotals := make([]time.Duration, len(tpc.clients))
+	commitTotals := make([]time.Duration, len(tpc.clients))
+	slowestCounts := make([]int, len(tpc.clients))
+	succeeded := 0
+	var lastErr error
+
 	for i := range iterations {
-		rtt, err := tpc.MeasureTwoPhaseCommitLatency()
+		breakdown, err := tpc.MeasureTwoPhaseCommitLatencyDetailed()
 		if err != nil {
 			log.Printf("2PC iteration %d failed: %v", i, err)
+			lastErr = err
 			continue
 		}
 
+		rtt := breakdown.Total
+		succeeded++
+		for r := range tpc.clients {
+			prepareTotals[r] += breakdown.Prepare[r]
+			commitTotals[r] += breakdown.Commit[r]
+		}
+		slowestCounts[breakdown.SlowestReplica()]++
+
 		if rtt < min {
 			min = rtt
 		}
@@ -463,14 +1463,23 @@ func (tpc *TwoPhaseCommitClient) RunTwoPhaseCommitPerformanceTest(iterations int
 		total += rtt
 	}
 
-	avg = total / time.Duration(iterations)
+	if succeeded == 0 {
+		return 0, 0, 0, fmt.Errorf("all %d 2PC iterations failed, last error: %w", iterations, lastErr)
+	}
+	avg = total / time.Duration(succeeded)
 
 	log.Printf("2PC Performance Test Results:")
 	log.Printf("  Total requests: %d", iterations)
+	log.Printf("  Succeeded:      %d", succeeded)
 	log.Printf("  Min RTT:        %v", min)
 	log.Printf("  Max RTT:        %v", max)
 	log.Printf("  Mean RTT:       %v", avg)
 	log.Printf("  Databases:      %d", len(tpc.clients))
 
+	for r, addr := range tpc.addresses {
+		log.Printf("  Replica %s: avg prepare %v, avg commit %v, slowest in %d of %d transactions",
+			addr, prepareTotals[r]/time.Duration(succeeded), commitTotals[r]/time.Duration(succeeded), slowestCounts[r], succeeded)
+	}
+
 	return min, max, avg, nil
 }
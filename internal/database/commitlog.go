@@ -0,0 +1,233 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// operations of a commit record
+const (
+	commitOpAdd       = "add"
+	commitOpPatch     = "patch"
+	commitOpDeleteAll = "deleteAll"
+)
+
+// commitRecord is one line of the commit log: a committed 2PC write as it was applied to the storage
+type commitRecord struct {
+	Operation string                 `json:"op"`
+	Readings  []types.SensorData     `json:"readings,omitempty"` //for add, with the ingestion time and store sequence they were stored with
+	Patch     *types.SensorDataPatch `json:"patch,omitempty"`    //for patch
+	Sequence  uint64                 `json:"sequence,omitempty"` //for patch and deleteAll, the store sequence of the last write when it was committed
+}
+
+// commitLog is the write-ahead log of the 2PC commits of a database with WithSyncCommit. Every commit is appended
+// and synced to disk before it is applied and acknowledged, after a restart the log is replayed on top of the
+// snapshot. Writing a snapshot empties it; a crash between the two leaves commits in the log that the snapshot
+// already holds, the replay recognizes them by their store sequence
+type commitLog struct {
+	file *os.File
+}
+
+// WithSyncCommit makes every 2PC commit durable before it is acknowledged: the commit is appended to a log next to
+// the data file (<data file>.log) and synced to disk, so a commit survives a crash right after the coordinator got
+// its success, not only the next Flush. This costs an fsync per commit, and a Flush holds up commits until the
+// snapshot is on disk. Direct writes outside of 2PC are persisted on Flush as before. Requires WithDataFile; a
+// storage that writes to disk itself, like BoltStorage, is durable on every commit anyway
+func WithSyncCommit() ServiceOption {
+	return func(s *DatabaseService) {
+		s.syncCommit = true
+	}
+}
+
+// commitLogPath returns the path of the commit log belonging to the data file
+func (s *DatabaseService) commitLogPath() string {
+	return s.dataFile + ".log"
+}
+
+// openCommitLog opens the commit log for appending if sync commits are enabled
+func (s *DatabaseService) openCommitLog() error {
+	if !s.syncCommit {
+		return nil
+	}
+	if s.dataFile == "" {
+		return errors.New("sync commits need a data file")
+	}
+
+	file, err := os.OpenFile(s.commitLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening commit log: %w", err)
+	}
+	s.commitLog = &commitLog{file: file}
+	return nil
+}
+
+// append writes the record and syncs it to disk
+func (l *commitLog) append(record commitRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding commit record: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing commit log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing commit log: %w", err)
+	}
+	return nil
+}
+
+// close closes the log file
+func (l *commitLog) close() error {
+	return l.file.Close()
+}
+
+// truncate empties the log once a snapshot holds all of its commits
+func (l *commitLog) truncate() error {
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating commit log: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// logCommit appends the commit to the commit log if sync commits are enabled
+func (s *DatabaseService) logCommit(record commitRecord) error {
+	if s.commitLog == nil {
+		return nil
+	}
+	return s.commitLog.append(record)
+}
+
+// logSequenced appends a patch or delete-all commit with the store sequence of the last write, which tells the replay
+// whether the snapshot already holds it
+func (s *DatabaseService) logSequenced(record commitRecord) error {
+	s.storeMu.Lock()
+	record.Sequence = s.storeSeq
+	s.storeMu.Unlock()
+	return s.logCommit(record)
+}
+
+// replayCommitLog applies the commits logged since the last snapshot to the storage, a missing log is not an error.
+// A last line without its newline is a commit that was not synced completely and therefore never acknowledged, it
+// is skipped.
+//
+// Every commit logged after a snapshot was written carries a higher store sequence than the snapshot holds, an add
+// for its readings, a patch or delete-all as the sequence of the last write before it. A commit below that is left
+// over from a crash between writing the snapshot and emptying the log and is skipped, so its readings are not stored
+// twice and a delete-all does not remove the readings stored after it. A patch or delete-all at the highest sequence
+// of the snapshot is applied again, it can only be followed by other patches and delete-alls, which repeat the same
+// result
+func (s *DatabaseService) replayCommitLog() error {
+	raw, err := os.ReadFile(s.commitLogPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stored, err := s.storage.GetAll()
+	if err != nil {
+		return err
+	}
+	var covered uint64 //highest store sequence in the snapshot
+	for _, point := range stored {
+		covered = max(covered, point.Sequence)
+	}
+	highest := covered
+
+	store := s.storage.Add
+	if s.upsert {
+		store = s.storage.Upsert
+	}
+
+	complete := raw[:bytes.LastIndexByte(raw, '\n')+1]
+	if len(bytes.TrimSpace(raw[len(complete):])) > 0 {
+		log.Printf("Skipping the incomplete last commit in %s", s.commitLogPath())
+	}
+
+	replayed, skipped := 0, 0
+	for i, line := range bytes.Split(complete, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record commitRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("line %d of the commit log: %w", i+1, err)
+		}
+		switch record.Operation {
+		case commitOpAdd:
+			if len(record.Readings) == 0 || record.Readings[0].Sequence <= covered {
+				skipped++
+				continue
+			}
+			highest = max(highest, record.Readings[0].Sequence)
+			err = store(record.Readings)
+		case commitOpPatch, commitOpDeleteAll:
+			if record.Sequence < covered {
+				skipped++
+				continue
+			}
+			highest = max(highest, record.Sequence)
+			if record.Operation == commitOpDeleteAll {
+				_, err = s.storage.DeleteAll()
+			} else if record.Patch != nil {
+				_, err = s.storage.Patch(*record.Patch)
+			}
+		default:
+			err = fmt.Errorf("unknown operation %q", record.Operation)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d of the commit log: %w", i+1, err)
+		}
+		replayed++
+	}
+
+	if replayed > 0 || skipped > 0 {
+		log.Printf("Replayed %d commits from %s, skipped %d the snapshot already holds", replayed, s.commitLogPath(), skipped)
+	}
+
+	//a delete-all may have removed the readings with the highest sequence, the next write must still get a higher one
+	s.storeMu.Lock()
+	s.storeSeq = max(s.storeSeq, highest)
+	s.storeMu.Unlock()
+	return nil
+}
+
+// writeFileSynced writes data to path through a temporary file that is synced and renamed, so a crash leaves either
+// the old or the complete new file behind, and the rename itself is on disk once it returns
+func writeFileSynced(path string, data []byte) error {
+	tmpFile := path + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// ConsistencyReport is the result of comparing the data of all replicas
+type ConsistencyReport struct {
+	Addresses []string
+	Counts    []int              //number of points per replica, in address order
+	Sensors   int                //number of sensor IDs found on any replica
+	Diverging []SensorDivergence //sensors whose points differ, sorted by sensor ID
+}
+
+// SensorDivergence describes one sensor whose points differ between the replicas
+type SensorDivergence struct {
+	SensorID string
+	Counts   []int //number of points of the sensor per replica, in address order
+}
+
+// Consistent reports whether all replicas hold the same points
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Diverging) == 0
+}
+
+// String returns a summary of the report with one line per diverging sensor
+func (r *ConsistencyReport) String() string {
+	var b strings.Builder
+	state := "consistent"
+	if !r.Consistent() {
+		state = fmt.Sprintf("%d of %d sensors diverge", len(r.Diverging), r.Sensors)
+	}
+	fmt.Fprintf(&b, "%d replicas, %d sensors: %s\n", len(r.Addresses), r.Sensors, state)
+	for i, address := range r.Addresses {
+		fmt.Fprintf(&b, "  %s: %d points\n", address, r.Counts[i])
+	}
+	for _, divergence := range r.Diverging {
+		fmt.Fprintf(&b, "  %s: %s\n", divergence.SensorID, formatCounts(divergence.Counts))
+	}
+	return b.String()
+}
+
+// formatCounts formats the per-replica point counts of a sensor, e.g. "3 / 2 points"
+func formatCounts(counts []int) string {
+	parts := make([]string, len(counts))
+	for i, count := range counts {
+		parts[i] = fmt.Sprint(count)
+	}
+	return strings.Join(parts, " / ") + " points"
+}
+
+// CheckConsistency fetches the points of every replica, restricted to sensor IDs starting with prefix unless it is
+// empty, and compares them sensor by sensor. Points are compared independent of their order on the replicas, so a
+// sensor diverges if its points differ in number or in any timestamp, value or unit
+func CheckConsistency(addresses []string, prefix string) (*ConsistencyReport, error) {
+	report := &ConsistencyReport{Addresses: addresses, Counts: make([]int, len(addresses))}
+
+	//sensor ID -> points per replica
+	bySensor := make(map[string][][]types.SensorData)
+	for i, address := range addresses {
+		data, err := fetchReplicaData(address, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("error reading database %s: %w", address, err)
+		}
+		report.Counts[i] = len(data)
+
+		for _, point := range data {
+			if bySensor[point.SensorID] == nil {
+				bySensor[point.SensorID] = make([][]types.SensorData, len(addresses))
+			}
+			bySensor[point.SensorID][i] = append(bySensor[point.SensorID][i], point)
+		}
+	}
+
+	report.Sensors = len(bySensor)
+	for sensorID, replicas := range bySensor {
+		if !sameSensorData(replicas) {
+			counts := make([]int, len(replicas))
+			for i, points := range replicas {
+				counts[i] = len(points)
+			}
+			report.Diverging = append(report.Diverging, SensorDivergence{SensorID: sensorID, Counts: counts})
+		}
+	}
+	sort.Slice(report.Diverging, func(i, j int) bool {
+		return report.Diverging[i].SensorID < report.Diverging[j].SensorID
+	})
+
+	return report, nil
+}
+
+// fetchReplicaData reads the points of a single replica, using the prefix query when a prefix is given
+func fetchReplicaData(address, prefix string) ([]types.SensorData, error) {
+	client, err := ClientFactory(address)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if prefix != "" {
+		return client.GetDataPointsByPrefix(prefix)
+	}
+	return client.GetAllDataPoints()
+}
+
+// sameSensorData reports whether every replica holds the same points of a sensor, in any order
+func sameSensorData(replicas [][]types.SensorData) bool {
+	for _, points := range replicas {
+		slices.SortFunc(points, compareSensorData)
+	}
+
+	for _, points := range replicas[1:] {
+		if !slices.EqualFunc(replicas[0], points, types.SensorData.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareSensorData orders the points of one sensor by timestamp, then value and unit
+func compareSensorData(a, b types.SensorData) int {
+	if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.Value, b.Value); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Unit, b.Unit)
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// errReplicaLagging is the no-vote of a replica that has degraded writes left to replay
+var errReplicaLagging = errors.New("replica is catching up on degraded writes, write queued")
+
+// prepareFunc sends the prepare of a transaction to a single replica
+type prepareFunc func(client *Client, transactionID string) (*pb.PrepareResponse, error)
+
+// pendingWrite is a write that was committed in degraded mode and still has to be applied to a replica
+type pendingWrite struct {
+	transactionID string //transaction under which the write was committed on the surviving replicas
+	sequence      uint64 //commit sequence of that transaction, applied again by the replay
+	prepare       prepareFunc
+}
+
+// degradedState holds the pending log of the degraded write mode
+type degradedState struct {
+	mu       sync.Mutex
+	enabled  bool
+	interval time.Duration    //how often the reconciler tries to replay pending writes
+	pending  [][]pendingWrite //per replica in address order, oldest first
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// WithDegradedWrites lets writes continue when the write quorum (every replica) is lost: a transaction that only
+// failed to prepare on unreachable replicas is committed on the reachable ones and queued for the others, which
+// a background reconciler replays every interval once they are back. This trades consistency for availability,
+// the replicas differ until reconciliation completes, so it is off by default
+func WithDegradedWrites(interval time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.degraded.enabled = true
+		tpc.degraded.interval = interval
+	}
+}
+
+// startReconciler allocates the pending log and starts the background reconciler if degraded writes are enabled
+func (tpc *TwoPhaseCommitClient) startReconciler() {
+	if !tpc.degraded.enabled {
+		return
+	}
+
+	tpc.degraded.pending = make([][]pendingWrite, len(tpc.clients))
+	tpc.degraded.stop = make(chan struct{})
+
+	tpc.degraded.done.Add(1)
+	go func() {
+		defer tpc.degraded.done.Done()
+
+		ticker := time.NewTicker(tpc.degraded.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-tpc.degraded.stop:
+				return
+			case <-ticker.C:
+				for i := range tpc.clients {
+					tpc.reconcile(i)
+				}
+			}
+		}
+	}()
+}
+
+// stopReconciler stops the background reconciler; pending writes that were not replayed yet are lost
+func (tpc *TwoPhaseCommitClient) stopReconciler() {
+	if tpc.degraded.stop == nil {
+		return
+	}
+
+	close(tpc.degraded.stop)
+	tpc.degraded.done.Wait()
+	tpc.degraded.stop = nil
+
+	for i, pending := range tpc.pendingWrites() {
+		if pending > 0 {
+			log.Printf("Database %s still misses %d degraded writes", tpc.addresses[i], pending)
+		}
+	}
+}
+
+// isLagging reports whether a replica has degraded writes left to replay
+func (tpc *TwoPhaseCommitClient) isLagging(replica int) bool {
+	if !tpc.degraded.enabled {
+		return false
+	}
+
+	tpc.degraded.mu.Lock()
+	defer tpc.degraded.mu.Unlock()
+	return len(tpc.degraded.pending[replica]) > 0
+}
+
+// pendingWrites returns the number of writes each replica still has to replay
+func (tpc *TwoPhaseCommitClient) pendingWrites() []int {
+	counts := make([]int, len(tpc.clients))
+	if !tpc.degraded.enabled {
+		return counts
+	}
+
+	tpc.degraded.mu.Lock()
+	defer tpc.degraded.mu.Unlock()
+	for i, pending := range tpc.degraded.pending {
+		counts[i] = len(pending)
+	}
+	return counts
+}
+
+// Degraded reports whether the client currently runs in degraded mode, i.e. some replica misses committed writes
+func (tpc *TwoPhaseCommitClient) Degraded() bool {
+	for _, pending := range tpc.pendingWrites() {
+		if pending > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// degradedSurvivors returns the replicas that voted yes if degraded writes are enabled and every other replica
+// is merely unreachable (a replica that answered with a no-vote still aborts the transaction)
+func (tpc *TwoPhaseCommitClient) degradedSurvivors(responses []*pb.PrepareResponse, errs []error) ([]int, bool) {
+	if !tpc.degraded.enabled {
+		return nil, false
+	}
+
+	var survivors []int
+	for i, err := range errs {
+		switch {
+		case err == nil && responses[i] != nil && responses[i].Success:
+			survivors = append(survivors, i)
+		case errors.Is(err, ErrCircuitOpen) || errors.Is(err, errReplicaLagging) || isReplicaFailure(err):
+			//unreachable, the write is queued for this replica
+		default:
+			return nil, false
+		}
+	}
+
+	return survivors, len(survivors) > 0
+}
+
+// commitDegraded commits a transaction on the replicas that prepared it and queues it for all others
+func (tpc *TwoPhaseCommitClient) commitDegraded(ctx context.Context, transactionID string, sequence uint64, prepare prepareFunc, survivors []int, breakdown *TwoPhaseCommitBreakdown) (int64, error) {
+	var lastError error
+	var affected int64
+	successCount := 0
+
+	committed := make([]bool, len(tpc.clients))
+	for _, i := range survivors {
+		commitStart := time.Now()
+		resp, err := tpc.clients[i].withContext(ctx).CommitTransactionWithSequence(transactionID, sequence)
+		if breakdown != nil {
+			breakdown.Commit[i] = time.Since(commitStart)
+		}
+		tpc.breakers[i].record(!isReplicaFailure(err))
+		if err != nil {
+			logging.Warnf("Commit failed for database %d: %v", i, err)
+			lastError = err
+			continue
+		}
+
+		committed[i] = true
+		tpc.recordApplied(i, resp.AppliedSequence)
+		successCount++
+		affected = max(affected, resp.PointsAffected)
+	}
+
+	if successCount == 0 {
+		return 0, fmt.Errorf("transaction %s: no database committed in degraded mode, last error: %v", transactionID, lastError)
+	}
+
+	tpc.degraded.mu.Lock()
+	for i := range tpc.clients {
+		if !committed[i] {
+			tpc.degraded.pending[i] = append(tpc.degraded.pending[i], pendingWrite{transactionID: transactionID, sequence: sequence, prepare: prepare})
+		}
+	}
+	tpc.degraded.mu.Unlock()
+
+	log.Printf("Transaction %s committed in degraded mode on %d of %d databases, queued for the others", transactionID, successCount, len(tpc.clients))
+	return affected, nil
+}
+
+// reconcile replays the pending writes of a replica in order, stopping at the first one that cannot be applied
+func (tpc *TwoPhaseCommitClient) reconcile(replica int) {
+	for {
+		tpc.degraded.mu.Lock()
+		if len(tpc.degraded.pending[replica]) == 0 {
+			tpc.degraded.mu.Unlock()
+			return
+		}
+		write := tpc.degraded.pending[replica][0]
+		tpc.degraded.mu.Unlock()
+
+		if !tpc.breakers[replica].allow() {
+			return
+		}
+
+		err := tpc.replay(replica, write)
+		tpc.breakers[replica].record(!isReplicaFailure(err))
+		if isReplicaFailure(err) {
+			return
+		}
+		if err != nil {
+			//the replica answered but refused, retrying would not change that
+			log.Printf("Dropping degraded write %s for database %s: %v", write.transactionID, tpc.addresses[replica], err)
+		}
+
+		tpc.degraded.mu.Lock()
+		tpc.degraded.pending[replica] = tpc.degraded.pending[replica][1:]
+		remaining := len(tpc.degraded.pending[replica])
+		tpc.degraded.mu.Unlock()
+
+		if remaining == 0 {
+			log.Printf("Database %s caught up on all degraded writes", tpc.addresses[replica])
+		}
+	}
+}
+
+// replay applies a pending write to a single replica with its own prepare and commit; a fresh transaction ID is used
+// because an earlier prepare of the original one may still be lingering on the replica
+func (tpc *TwoPhaseCommitClient) replay(replica int, write pendingWrite) error {
+	client := tpc.clients[replica]
+	transactionID := generateTransactionID()
+
+	resp, err := write.prepare(client, transactionID)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("prepare rejected: %s", resp.Message)
+	}
+
+	commitResp, err := client.CommitTransactionWithSequence(transactionID, write.sequence)
+	if err != nil {
+		return err
+	}
+	tpc.recordApplied(replica, commitResp.AppliedSequence)
+
+	log.Printf("Replayed degraded write %s on database %s as transaction %s", write.transactionID, tpc.addresses[replica], transactionID)
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// DefaultKeepaliveInterval is how long a database connection may be idle before the client pings the database; gRPC
+// does not ping more often than every 10s
+const DefaultKeepaliveInterval = 10 * time.Second
+
+// DefaultKeepaliveTimeout is how long the client waits for the answer to a ping before it drops the connection
+const DefaultKeepaliveTimeout = 3 * time.Second
+
+// DefaultKeepaliveMinInterval is the shortest ping interval a database accepts, below DefaultKeepaliveInterval so
+// that clients with the default settings are never cut off for pinging too often
+const DefaultKeepaliveMinInterval = 5 * time.Second
+
+// ClientOption configures optional behavior of a Client
+type ClientOption func(*clientConfig)
+
+// clientConfig holds the settings applied when a Client connects
+type clientConfig struct {
+	keepaliveInterval time.Duration //0 disables the pings
+	keepaliveTimeout  time.Duration
+	keepaliveIdle     bool //ping even without RPCs in progress
+}
+
+// defaultClientConfig returns the settings of a Client created without options
+func defaultClientConfig() clientConfig {
+	return clientConfig{
+		keepaliveInterval: DefaultKeepaliveInterval,
+		keepaliveTimeout:  DefaultKeepaliveTimeout,
+		keepaliveIdle:     true,
+	}
+}
+
+// WithKeepalive makes the client ping the database after interval without traffic and drop the connection if no
+// answer arrives within timeout, so a connection whose peer is gone (e.g. a killed database behind a half-open TCP
+// connection) is noticed and re-established before the next call stalls on it. With permitWithoutStream the pings
+// are also sent while no RPC is in progress, which is most of the time for the 2PC coordinator. An interval of 0
+// disables the pings
+func WithKeepalive(interval, timeout time.Duration, permitWithoutStream bool) ClientOption {
+	return func(config *clientConfig) {
+		config.keepaliveInterval = interval
+		config.keepaliveTimeout = timeout
+		config.keepaliveIdle = permitWithoutStream
+	}
+}
+
+// dialOptions returns the gRPC dial options of the settings
+func (config clientConfig) dialOptions() []grpc.DialOption {
+	if config.keepaliveInterval <= 0 {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                config.keepaliveInterval,
+		Timeout:             config.keepaliveTimeout,
+		PermitWithoutStream: config.keepaliveIdle,
+	})}
+}
+
+// KeepaliveEnforcement returns the server option that lets clients ping a database every minInterval, also while no
+// RPC is in progress. The gRPC default only allows a ping every 5 minutes during an RPC and closes the connection of
+// a client pinging more often, so a database has to be started with it for WithKeepalive to work
+func KeepaliveEnforcement(minInterval time.Duration) grpc.ServerOption {
+	return grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             minInterval,
+		PermitWithoutStream: true,
+	})
+}
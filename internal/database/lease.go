@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// ErrNotLeader is returned for a transaction refused because another coordinator holds the coordinator lease (see
+// WithCoordinatorLease); the write has to go to the active server, reads are not affected
+var ErrNotLeader = errors.New("not the active coordinator")
+
+// DefaultLeaseTTL is the default time a coordinator lease is granted for before it has to be renewed
+const DefaultLeaseTTL = 10 * time.Second
+
+// leaseState is the coordinator lease as granted by a database
+type leaseState struct {
+	mu      sync.Mutex
+	holder  string //coordinator holding the lease, empty if it was never granted or released
+	expires time.Time
+}
+
+// response returns the state of the lease for the coordinator asking, the caller holds mu
+func (l *leaseState) response(holder string, now time.Time) *pb.LeaseResponse {
+	if l.holder == "" || !now.Before(l.expires) {
+		return &pb.LeaseResponse{}
+	}
+	return &pb.LeaseResponse{
+		Granted:     l.holder == holder,
+		Holder:      l.holder,
+		RemainingMs: l.expires.Sub(now).Milliseconds(),
+	}
+}
+
+// AcquireLease grants the coordinator lease to the holder for the requested time if it is free or expired, or renews
+// it if the holder has it already; otherwise the response names the current holder
+func (s *DatabaseService) AcquireLease(ctx context.Context, req *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	if req.Holder == "" || req.TtlMs <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "a lease needs a holder and a positive ttl, got %q and %dms", req.Holder, req.TtlMs)
+	}
+
+	s.lease.mu.Lock()
+	defer s.lease.mu.Unlock()
+
+	now := time.Now()
+	if s.lease.holder == req.Holder || s.lease.holder == "" || !now.Before(s.lease.expires) {
+		if s.lease.holder != req.Holder {
+			log.Printf("Coordinator lease granted to %s", req.Holder)
+		}
+		s.lease.holder = req.Holder
+		s.lease.expires = now.Add(time.Duration(req.TtlMs) * time.Millisecond)
+	}
+	return s.lease.response(req.Holder, now), nil
+}
+
+// ReleaseLease gives up the coordinator lease if the holder has it, so another coordinator can take over without
+// waiting for it to expire; granted reports whether it was held
+func (s *DatabaseService) ReleaseLease(ctx context.Context, req *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	s.lease.mu.Lock()
+	defer s.lease.mu.Unlock()
+
+	now := time.Now()
+	if s.lease.holder != req.Holder || !now.Before(s.lease.expires) {
+		return s.lease.response(req.Holder, now), nil
+	}
+
+	log.Printf("Coordinator lease released by %s", req.Holder)
+	s.lease.holder = ""
+	return &pb.LeaseResponse{Granted: true}, nil
+}
+
+// AcquireLease asks the database to grant or renew the coordinator lease for holder
+func (c *Client) AcquireLease(holder string, ttl time.Duration) (*pb.LeaseResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.AcquireLease(ctx, &pb.LeaseRequest{Holder: holder, TtlMs: ttl.Milliseconds()})
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring the coordinator lease: %w", err)
+	}
+	return resp, nil
+}
+
+// ReleaseLease gives up the coordinator lease of holder on the database
+func (c *Client) ReleaseLease(holder string) (*pb.LeaseResponse, error) {
+	ctx, cancel := context.WithTimeout(c.parentContext(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.client.ReleaseLease(ctx, &pb.LeaseRequest{Holder: holder})
+	if err != nil {
+		return nil, fmt.Errorf("error releasing the coordinator lease: %w", err)
+	}
+	return resp, nil
+}
+
+// CoordinatorLease decides which of several servers sharing the databases coordinates the writes. Each database
+// grants the lease to one coordinator at a time; a coordinator holding it on a majority of them is the leader until
+// the lease runs out. It renews the lease three times per ttl, the standbys try to take it over at the same pace
+// and so do once the leader stops renewing, e.g. because it crashed or lost the databases
+type CoordinatorLease struct {
+	holder    string        //ID of this coordinator, unique among the servers sharing the databases
+	ttl       time.Duration //time the databases grant the lease for
+	clients   []*Client
+	addresses []string
+
+	mu         sync.Mutex
+	validUntil time.Time //end of the lease held on a majority, in the past while standing by
+	leader     string    //holder reported by the databases the last time the lease was not granted
+	leading    bool      //leadership as of the last acquire, to log the changes
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// LeaseStats holds the state of the coordinator lease
+type LeaseStats struct {
+	Holder string `json:"holder"` //ID of this coordinator
+	Leader bool   `json:"leader"` //this coordinator holds the lease and accepts writes
+	Active string `json:"active"` //coordinator holding the lease as far as known, empty if none
+}
+
+// WithCoordinatorLease makes the client coordinate writes only while it holds the coordinator lease on a majority of
+// the databases, so several servers can share them with one active coordinator and the others standing by. A
+// transaction started without the lease fails with ErrNotLeader; reads, manual transactions and the replay of
+// degraded writes are not affected. The factory tries to acquire the lease once before it returns. With two
+// databases both have to grant it, so no coordinator accepts writes while one of them is down, not even with
+// WithDegradedWrites. holder must be unique among the servers; a ttl of 0 uses DefaultLeaseTTL
+func WithCoordinatorLease(holder string, ttl time.Duration) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		if ttl <= 0 {
+			ttl = DefaultLeaseTTL
+		}
+		tpc.lease = &CoordinatorLease{holder: holder, ttl: ttl}
+	}
+}
+
+// Lease returns the coordinator lease of the client, nil without WithCoordinatorLease
+func (tpc *TwoPhaseCommitClient) Lease() *CoordinatorLease {
+	return tpc.lease
+}
+
+// startLease acquires the coordinator lease for the first time and starts renewing it, if it is enabled
+func (tpc *TwoPhaseCommitClient) startLease() {
+	l := tpc.lease
+	if l == nil {
+		return
+	}
+
+	l.clients = tpc.clients
+	l.addresses = tpc.addresses
+	l.stop = make(chan struct{})
+	l.acquire()
+
+	l.done.Add(1)
+	go func() {
+		defer l.done.Done()
+		for {
+			//standbys retry at a random pace, so two of them that split the databases between them do not keep
+			//running into each other
+			wait := l.ttl / 3
+			if !l.IsLeader() {
+				wait = wait/2 + rand.N(wait)
+			}
+
+			select {
+			case <-l.stop:
+				return
+			case <-time.After(wait):
+				l.acquire()
+			}
+		}
+	}()
+}
+
+// stopLease stops renewing the coordinator lease and releases it, so a standby takes over without waiting for it to
+// expire
+func (tpc *TwoPhaseCommitClient) stopLease() {
+	l := tpc.lease
+	if l == nil || l.stop == nil {
+		return
+	}
+
+	close(l.stop)
+	l.done.Wait()
+	l.stop = nil
+
+	l.mu.Lock()
+	wasLeader := time.Now().Before(l.validUntil)
+	l.validUntil = time.Time{}
+	l.leading = false
+	l.mu.Unlock()
+
+	l.release()
+	if wasLeader {
+		log.Printf("Released the coordinator lease of %s", l.holder)
+	}
+}
+
+// checkLeader returns ErrNotLeader, naming the active coordinator if known, unless the client holds the lease or
+// does not use one
+func (tpc *TwoPhaseCommitClient) checkLeader() error {
+	if tpc.lease == nil || tpc.lease.IsLeader() {
+		return nil
+	}
+	if active := tpc.lease.Active(); active != "" {
+		return fmt.Errorf("%w, %s is", ErrNotLeader, active)
+	}
+	return fmt.Errorf("%w, no coordinator holds the lease", ErrNotLeader)
+}
+
+// IsLeader reports whether this coordinator holds the lease and may coordinate writes
+func (l *CoordinatorLease) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.validUntil)
+}
+
+// Holder returns the ID of this coordinator
+func (l *CoordinatorLease) Holder() string {
+	return l.holder
+}
+
+// Active returns the ID of the coordinator holding the lease as far as known, empty if none does
+func (l *CoordinatorLease) Active() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Now().Before(l.validUntil) {
+		return l.holder
+	}
+	return l.leader
+}
+
+// Stats returns the state of the lease
+func (l *CoordinatorLease) Stats() LeaseStats {
+	return LeaseStats{Holder: l.holder, Leader: l.IsLeader(), Active: l.Active()}
+}
+
+// acquire asks every database for the lease at once and becomes or stays leader if a majority grants it. The lease
+// counts from before the requests were sent, less a tenth of the ttl for the clocks of the databases running faster,
+// so this coordinator always gives it up before any database hands it to another one
+func (l *CoordinatorLease) acquire() {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+	defer cancel()
+
+	responses := make([]*pb.LeaseResponse, len(l.clients))
+	errs := make([]error, len(l.clients))
+	var wg sync.WaitGroup
+	for i, client := range l.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = client.withContext(ctx).AcquireLease(l.holder, l.ttl)
+		}()
+	}
+	wg.Wait()
+
+	var granted []int
+	active := ""
+	for i, resp := range responses {
+		switch {
+		case errs[i] != nil:
+			logging.Debugf("Database %s did not answer the lease request: %v", l.addresses[i], errs[i])
+		case resp.Granted:
+			granted = append(granted, i)
+		case active == "":
+			active = resp.Holder
+		}
+	}
+
+	l.mu.Lock()
+	if len(granted) > len(l.clients)/2 {
+		l.validUntil = start.Add(l.ttl - l.ttl/10)
+	}
+	l.leader = active
+	wasLeader := l.leading
+	isLeader := time.Now().Before(l.validUntil)
+	l.leading = isLeader
+	l.mu.Unlock()
+
+	switch {
+	case isLeader && !wasLeader:
+		log.Printf("Acquired the coordinator lease as %s, accepting writes", l.holder)
+	case !isLeader && wasLeader:
+		log.Printf("Lost the coordinator lease (granted by %d of %d databases), refusing writes", len(granted), len(l.clients))
+	}
+
+	//a minority is of no use, giving it back lets another coordinator gather a majority; a leader whose renewal
+	//failed keeps it until the lease runs out
+	if !isLeader && len(granted) > 0 {
+		l.release(granted...)
+	}
+}
+
+// release gives the lease back on the given databases, on all of them if none are given
+func (l *CoordinatorLease) release(replicas ...int) {
+	if len(replicas) == 0 {
+		for i := range l.clients {
+			replicas = append(replicas, i)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, i := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.clients[i].withContext(ctx).ReleaseLease(l.holder); err != nil {
+				logging.Debugf("Failed to release the coordinator lease on %s: %v", l.addresses[i], err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"log"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// DataObserver is called for every data point stored, whether directly or by a 2PC commit
+type DataObserver func(types.SensorData)
+
+// ErrServiceStopped is returned by OnDataStored once the service stopped
+var ErrServiceStopped = errors.New("database service stopped")
+
+// observerQueueSize is the number of points buffered per observer before points are dropped for it
+const observerQueueSize = 1024
+
+// dataObserver is a registered observer with its own queue, drained by its own goroutine
+type dataObserver struct {
+	fn    DataObserver
+	queue chan types.SensorData
+}
+
+// OnDataStored registers an observer for newly stored data. Observers run on their own goroutine, so a slow observer
+// neither blocks writers nor other observers; if it falls more than observerQueueSize points behind, further points
+// are dropped for it. The points of one write arrive in order, but concurrent writes may be observed in a different
+// order than they were stored. Observers stop when the service stops, registering one afterwards fails
+func (s *DatabaseService) OnDataStored(fn DataObserver) error {
+	observer := &dataObserver{
+		fn:    fn,
+		queue: make(chan types.SensorData, observerQueueSize),
+	}
+
+	s.observerMu.Lock()
+	if s.observersStopped {
+		s.observerMu.Unlock()
+		return ErrServiceStopped
+	}
+	s.observers = append(s.observers, observer)
+	s.observerMu.Unlock()
+
+	go func() {
+		for data := range observer.queue {
+			observer.fn(data)
+		}
+	}()
+	return nil
+}
+
+// notifyStored hands a stored point to every observer without blocking, called after the storage call returned
+func (s *DatabaseService) notifyStored(data types.SensorData) {
+	s.observerMu.RLock()
+	defer s.observerMu.RUnlock()
+
+	for _, observer := range s.observers {
+		select {
+		case observer.queue <- data:
+		default:
+			log.Printf("Observer queue full, dropping notification for sensor %s", data.SensorID)
+		}
+	}
+}
+
+// stopObservers closes all observer queues, the observers finish the points already queued
+func (s *DatabaseService) stopObservers() {
+	s.observerMu.Lock()
+	defer s.observerMu.Unlock()
+
+	for _, observer := range s.observers {
+		close(observer.queue)
+	}
+	s.observers = nil
+	s.observersStopped = true
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// readCache keeps the recent results of GetDataPointBySensorId, so clients polling the same sensor do not query a
+// replica every time. Entries expire after the TTL and the least recently used one is evicted when the cache is full;
+// a write committed through the coordinator drops the entries of the sensors it touched
+type readCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	size       int                      //maximum number of cached sensors
+	entries    map[string]*list.Element //sensor ID -> element of lru holding a *cacheEntry
+	lru        *list.List               //most recently used at the front
+	generation uint64                   //incremented by every invalidation, see lookup and store
+	hits       int64
+	misses     int64
+}
+
+// cacheEntry is the cached result of one sensor
+type cacheEntry struct {
+	sensorID string
+	data     []types.SensorData
+	expires  time.Time
+}
+
+// WithReadCache caches the result of GetDataPointBySensorId per sensor for ttl, keeping at most size sensors. Writes
+// committed by this client invalidate the sensors they touch at once; writes that bypass it, e.g. those
+// of another server, may stay unseen for up to ttl. Read sessions (ReadAtLeast) never use the cache. A ttl or size of 0
+// disables the cache, which is the default
+func WithReadCache(ttl time.Duration, size int) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.cache = nil
+		if ttl > 0 && size > 0 {
+			tpc.cache = &readCache{ttl: ttl, size: size, entries: make(map[string]*list.Element), lru: list.New()}
+		}
+	}
+}
+
+// lookup returns a copy of the cached data of a sensor and whether it was found. On a miss it also returns the
+// generation to pass to store, so a result read before a write committed is not cached after the write invalidated
+func (c *readCache) lookup(sensorID string) ([]types.SensorData, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[sensorID]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.hits++
+			return slices.Clone(entry.data), 0, true
+		}
+		c.remove(element)
+	}
+
+	c.misses++
+	return nil, c.generation, false
+}
+
+// store caches a copy of the data of a sensor read at generation, unless an invalidation happened since
+func (c *readCache) store(sensorID string, data []types.SensorData, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &cacheEntry{sensorID: sensorID, data: slices.Clone(data), expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[sensorID]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[sensorID] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the cached data of the sensors, or of every sensor if none is given
+func (c *readCache) invalidate(sensorIDs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if len(sensorIDs) == 0 {
+		clear(c.entries)
+		c.lru.Init()
+		return
+	}
+	for _, sensorID := range sensorIDs {
+		if element, ok := c.entries[sensorID]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// remove drops an element, the caller holds mu
+func (c *readCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*cacheEntry).sensorID)
+	c.lru.Remove(element)
+}
+
+// counts returns the number of cached sensors, hits and misses for Stats
+func (c *readCache) counts() (entries int, hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.hits, c.misses
+}
+
+// invalidateCache drops the cached data of the sensors touched by a write, or of every sensor if none is given; it
+// does nothing without WithReadCache
+func (tpc *TwoPhaseCommitClient) invalidateCache(sensorIDs ...string) {
+	if tpc.cache != nil {
+		tpc.cache.invalidate(sensorIDs...)
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// ReadStrategy decides which replica serves a read of the 2PC client
+type ReadStrategy int
+
+const (
+	ReadFirst      ReadStrategy = iota //always the first available replica in address order
+	ReadRoundRobin                     //cycle through the available replicas
+	ReadWeighted                       //pick a random available replica, proportionally to its weight
+)
+
+// String returns the name of the strategy as accepted by ParseReadStrategy
+func (s ReadStrategy) String() string {
+	switch s {
+	case ReadFirst:
+		return "first"
+	case ReadRoundRobin:
+		return "round-robin"
+	case ReadWeighted:
+		return "weighted"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseReadStrategy returns the strategy with the given name ("first", "round-robin" or "weighted")
+func ParseReadStrategy(name string) (ReadStrategy, error) {
+	for _, strategy := range []ReadStrategy{ReadFirst, ReadRoundRobin, ReadWeighted} {
+		if strings.EqualFold(name, strategy.String()) {
+			return strategy, nil
+		}
+	}
+	return ReadFirst, fmt.Errorf("unknown read strategy %q (expected first, round-robin or weighted)", name)
+}
+
+// WithReadStrategy sets how reads are spread across the replicas; the default is ReadFirst
+func WithReadStrategy(strategy ReadStrategy) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.readStrategy = strategy
+	}
+}
+
+// WithReplicaWeights sets the read weight of every replica in address order (default 1 each). A replica with weight 0
+// only serves reads when no weighted replica is available; missing weights default to 1
+func WithReplicaWeights(weights ...int) TwoPhaseCommitOption {
+	return func(tpc *TwoPhaseCommitClient) {
+		tpc.readWeights = weights
+	}
+}
+
+// readWeight returns the read weight of a replica
+func (tpc *TwoPhaseCommitClient) readWeight(replica int) int {
+	if replica < len(tpc.readWeights) && tpc.readWeights[replica] >= 0 {
+		return tpc.readWeights[replica]
+	}
+	return 1
+}
+
+// readOrder returns the replicas to try for a read: the one chosen by the read strategy first, then the other
+// available replicas in address order as fallbacks. Replicas with an open circuit breaker or pending degraded writes
+// are left out, unless none is left, then every replica is returned so that the caller reports why each one was skipped
+func (tpc *TwoPhaseCommitClient) readOrder() []int {
+	var available, weighted []int
+	for i, breaker := range tpc.breakers {
+		//a replica catching up on degraded writes would serve stale data
+		if state, _ := breaker.snapshot(); state == BreakerOpen || tpc.isLagging(i) {
+			continue
+		}
+		available = append(available, i)
+		if tpc.readWeight(i) > 0 {
+			weighted = append(weighted, i)
+		}
+	}
+
+	if len(available) == 0 {
+		for i := range tpc.clients {
+			available = append(available, i)
+		}
+		return available
+	}
+	if len(weighted) == 0 {
+		weighted = available
+	}
+
+	chosen := weighted[0]
+	switch tpc.readStrategy {
+	case ReadRoundRobin:
+		chosen = weighted[(tpc.readCounter.Add(1)-1)%uint64(len(weighted))]
+	case ReadWeighted:
+		chosen = pickWeighted(weighted, tpc.readWeight)
+	}
+
+	order := []int{chosen}
+	for _, i := range available {
+		if i != chosen {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// pickWeighted picks one of the replicas at random, proportionally to its weight (uniformly if all weights are 0)
+func pickWeighted(replicas []int, weight func(int) int) int {
+	total := 0
+	for _, i := range replicas {
+		total += weight(i)
+	}
+	if total == 0 {
+		return replicas[rand.IntN(len(replicas))]
+	}
+
+	n := rand.IntN(total)
+	for _, i := range replicas {
+		n -= weight(i)
+		if n < 0 {
+			return i
+		}
+	}
+	return replicas[len(replicas)-1]
+}
+
+// readFromReplicas runs a read on the replica chosen by the read strategy and falls back to the other replicas if it
+// cannot be reached or has not applied minSequence yet; outcomes are recorded in the circuit breakers like those of
+// 2PC calls
+func readFromReplicas[T any](tpc *TwoPhaseCommitClient, minSequence uint64, read func(client *Client) (T, error)) (T, error) {
+	var result T
+	if len(tpc.clients) == 0 {
+		return result, fmt.Errorf("no database clients available")
+	}
+
+	var lastErr error
+	for _, i := range tpc.readOrder() {
+		if !tpc.breakers[i].allow() {
+			lastErr = fmt.Errorf("database %s: %w", tpc.addresses[i], ErrCircuitOpen)
+			continue
+		}
+		if minSequence > 0 {
+			applied, err := tpc.hasApplied(i, minSequence)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if !applied {
+				lastErr = errNotApplied(tpc.addresses[i], minSequence)
+				continue
+			}
+		}
+
+		value, err := read(tpc.clients[i])
+		tpc.breakers[i].record(!isReplicaFailure(err))
+		if err == nil {
+			tpc.reads[i].Add(1)
+			return value, nil
+		}
+
+		lastErr = err
+		if !isReplicaFailure(err) {
+			//the replica answered, another one would answer the same
+			break
+		}
+		logging.Warnf("Read from database %s failed, trying the next replica: %v", tpc.addresses[i], err)
+	}
+
+	return result, lastErr
+}
+
+// readFromReplica reads from one replica without falling back to another, for readers that compare the store
+// sequences of successive reads, which only agree within one replica
+func readFromReplica[T any](tpc *TwoPhaseCommitClient, replica int, read func(client *Client) (T, error)) (T, error) {
+	var result T
+	if replica < 0 || replica >= len(tpc.clients) {
+		return result, fmt.Errorf("no database %d, there are %d", replica, len(tpc.clients))
+	}
+	if !tpc.breakers[replica].allow() {
+		return result, fmt.Errorf("database %s: %w", tpc.addresses[replica], ErrCircuitOpen)
+	}
+
+	value, err := read(tpc.clients[replica])
+	tpc.breakers[replica].record(!isReplicaFailure(err))
+	if err != nil {
+		return result, err
+	}
+	tpc.reads[replica].Add(1)
+	return value, nil
+}
@@ -2,30 +2,45 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
 )
 
 // TransactionState represents the state of a prepared transaction
 type TransactionState struct {
 	TransactionID string
+	Operation     pb.TransactionOperation
 	SensorData    types.SensorData
+	Batch         []types.SensorData    //readings of an ADD_BATCH transaction
+	Patch         types.SensorDataPatch //partial update of a PATCH transaction
 	PreparedAt    time.Time
 }
 
 // DatabaseService implements the DatabaseService gRPC service.
 type DatabaseService struct {
 	pb.UnimplementedDatabaseServiceServer
-	mu            sync.RWMutex
-	data          []types.SensorData
-	maxDataPoints int
+	storage Storage // the stored sensor data, a MemoryStorage unless WithStorage is given
+	upsert  bool    // a write with the sensor ID and timestamp of a stored point replaces it instead of appending
+
+	validator PrepareValidator // business rules a prepared write must pass, nil = none
+
+	lease leaseState // coordinator lease granted to one of the servers, see WithCoordinatorLease
+
+	storeMu  sync.Mutex // held from assigning a store sequence until the write is stored, so sequences follow the store order
+	storeSeq uint64     // store sequence of the last write, guarded by storeMu
 
 	// Two-Phase Commit state management
 	preparedTxns  map[string]*TransactionState // transaction_id -> prepared transaction
@@ -33,22 +48,123 @@ type DatabaseService struct {
 	txnTimeout    time.Duration                // timeout for prepared transactions
 	cleanupTicker *time.Ticker                 // cleanup ticker for expired transactions
 	stopCleanup   chan struct{}                // channel to stop cleanup goroutine
+	appliedSeq    atomic.Uint64                // highest commit sequence applied, raised under txnMutex
+	maxPrepared   int                          // prepared transactions held at most, 0 = unlimited
+
+	// Disk persistence
+	dataFile       string         // snapshot file path, empty disables persistence
+	snapshotFormat SnapshotFormat // serialization used when writing snapshots
+	snapshotGzip   bool           // wrap written snapshots in gzip
+	flushMu        sync.Mutex     // serializes snapshot writes
+	syncCommit     bool           // every 2PC commit is synced to the commit log before it is acknowledged
+	commitLog      *commitLog     // open while syncCommit is set, appended to under txnMutex
+
+	// Observers notified about newly stored data
+	observers        []*dataObserver
+	observersStopped bool // set by Stop, later registrations are refused
+	observerMu       sync.RWMutex
+}
+
+// ServiceOption configures optional behavior of a DatabaseService
+type ServiceOption func(*DatabaseService)
+
+// WithDataFile enables disk persistence: the store is loaded from path on startup and written back on Flush and Stop
+func WithDataFile(path string) ServiceOption {
+	return func(s *DatabaseService) {
+		s.dataFile = path
+	}
+}
+
+// WithMaxPreparedTransactions caps the number of prepared transactions the database holds at once, so a coordinator
+// that prepares without ever committing cannot grow them without bound; further prepares are refused as overloaded
+// until some of them are committed, aborted or expire, which the coordinator reports as ErrCapacityFull. Expired
+// transactions are dropped as soon as the cap is reached instead of holding their slots until the next cleanup.
+// 0 disables the cap
+func WithMaxPreparedTransactions(limit int) ServiceOption {
+	return func(s *DatabaseService) {
+		s.maxPrepared = limit
+	}
+}
+
+// WithPreparedTransactionTimeout sets how long a prepared transaction is held without a commit or abort before it
+// is dropped, 30s by default
+func WithPreparedTransactionTimeout(timeout time.Duration) ServiceOption {
+	return func(s *DatabaseService) {
+		if timeout > 0 {
+			s.txnTimeout = timeout
+		}
+	}
+}
+
+// WithStorage stores the data in the given backend instead of a MemoryStorage; the size limit passed to
+// DatabaseServiceFactory only applies to the default MemoryStorage
+func WithStorage(storage Storage) ServiceOption {
+	return func(s *DatabaseService) {
+		s.storage = storage
+	}
+}
+
+// WithUpsert makes writes idempotent: a reading with the sensor ID and timestamp of a stored point replaces value and
+// unit of that point instead of being stored a second time, e.g. when a sensor re-sends a reading. This applies to
+// direct writes and 2PC commits alike
+func WithUpsert() ServiceOption {
+	return func(s *DatabaseService) {
+		s.upsert = true
+	}
+}
+
+// PrepareValidator checks a reading against rules beyond the basic field checks, e.g. the expected value range of a
+// sensor; a non-nil error makes the database vote no
+type PrepareValidator func(types.SensorData) error
+
+// WithPrepareValidator makes the database vote no on every 2PC write whose readings do not pass validator, so the
+// coordinator aborts the transaction on all databases. It checks the reading of an add, every reading of a batch and
+// the point a patch would produce; direct writes outside of 2PC are not checked
+func WithPrepareValidator(validator PrepareValidator) ServiceOption {
+	return func(s *DatabaseService) {
+		s.validator = validator
+	}
 }
 
-// DatabaseServiceFactory creates a new database service with a specified size limit.
-func DatabaseServiceFactory(limit int) *DatabaseService {
+// DatabaseServiceFactory creates a new database service with a specified size limit. It fails if the commit log of
+// sync commits cannot be replayed or opened, the log is left untouched then, since it holds acknowledged commits
+func DatabaseServiceFactory(limit int, opts ...ServiceOption) (*DatabaseService, error) {
 	service := &DatabaseService{
-		data:          make([]types.SensorData, 0, limit),
-		maxDataPoints: limit,
-		preparedTxns:  make(map[string]*TransactionState),
-		txnTimeout:    30 * time.Second, //30 second timeout for prepared transactions
-		stopCleanup:   make(chan struct{}),
+		preparedTxns:   make(map[string]*TransactionState),
+		txnTimeout:     30 * time.Second, //30 second timeout for prepared transactions
+		stopCleanup:    make(chan struct{}),
+		snapshotFormat: SnapshotJSON,
+	}
+
+	for _, opt := range opts {
+		opt(service)
+	}
+	if service.storage == nil {
+		service.storage = MemoryStorageFactory(limit)
+	}
+
+	//restore the previous snapshot and the commits logged after it if persistence is enabled
+	if service.dataFile != "" {
+		if err := service.loadSnapshot(); err != nil {
+			log.Printf("Failed to load snapshot from %s: %v", service.dataFile, err)
+		}
+		if err := service.replayCommitLog(); err != nil {
+			return nil, fmt.Errorf("error replaying the commit log %s: %w", service.commitLogPath(), err)
+		}
+	}
+	if err := service.openCommitLog(); err != nil {
+		return nil, err
+	}
+
+	//a persistent store continues its store sequence after a restart
+	if err := service.restoreStoreSequence(); err != nil {
+		log.Printf("Failed to restore the store sequence: %v", err)
 	}
 
 	//start cleanup goroutine for expired transactions
 	service.startTransactionCleanup()
 
-	return service
+	return service, nil
 }
 
 // startTransactionCleanup starts a goroutine to clean up expired prepared transactions
@@ -72,61 +188,317 @@ func (s *DatabaseService) startTransactionCleanup() {
 func (s *DatabaseService) cleanupExpiredTransactions() {
 	s.txnMutex.Lock()
 	defer s.txnMutex.Unlock()
+	s.removeExpiredTransactions()
+}
 
+// removeExpiredTransactions drops the prepared transactions older than the timeout and returns how many, the caller
+// holds txnMutex
+func (s *DatabaseService) removeExpiredTransactions() int {
 	now := time.Now()
+	removed := 0
 	for txnID, txnState := range s.preparedTxns {
 		if now.Sub(txnState.PreparedAt) > s.txnTimeout {
 			delete(s.preparedTxns, txnID)
 			log.Printf("Cleaned up expired transaction: %s", txnID)
+			removed++
 		}
 	}
+	return removed
 }
 
-// Stop gracefully stops the database service
+// Stop gracefully stops the database service, flushing the data to disk if persistence is enabled
 func (s *DatabaseService) Stop() {
 	close(s.stopCleanup)
+	s.stopObservers()
+
+	if s.dataFile != "" {
+		if _, _, err := s.Flush(); err != nil {
+			log.Printf("Failed to flush data on stop: %v", err)
+		}
+	}
+	if s.commitLog != nil {
+		s.commitLog.close()
+	}
+}
+
+// Flush writes a snapshot of all stored data to the data file and returns the number of points written and the file path
+func (s *DatabaseService) Flush() (int, string, error) {
+	if s.dataFile == "" {
+		return 0, "", errors.New("persistence is not enabled (no data file configured)")
+	}
+
+	//the commit log is emptied once the snapshot holds its commits, so no commit may come in between
+	if s.commitLog != nil {
+		s.txnMutex.Lock()
+		defer s.txnMutex.Unlock()
+	}
+	return s.writeSnapshot()
+}
+
+// writeSnapshot writes the snapshot for Flush and empties the commit log, with sync commits the caller holds txnMutex
+func (s *DatabaseService) writeSnapshot() (int, string, error) {
+	//work on a copy so the (slow) disk write doesnt block writers
+	snapshot, err := s.storage.GetAll()
+	if err != nil {
+		return 0, s.dataFile, fmt.Errorf("error reading data for snapshot: %w", err)
+	}
+
+	encoded, err := encodeSnapshot(snapshot, s.snapshotFormat, s.snapshotGzip)
+	if err != nil {
+		return 0, s.dataFile, err
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	//write to a temp file first and rename, so a crash mid-write never leaves a truncated snapshot behind
+	if err := writeFileSynced(s.dataFile, encoded); err != nil {
+		return 0, s.dataFile, fmt.Errorf("error writing snapshot: %w", err)
+	}
+
+	//the snapshot holds the logged commits now, a log left over from a run with sync commits is not needed anymore
+	if s.commitLog != nil {
+		if err := s.commitLog.truncate(); err != nil {
+			return len(snapshot), s.dataFile, err
+		}
+	} else if err := os.Remove(s.commitLogPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return len(snapshot), s.dataFile, fmt.Errorf("error removing commit log: %w", err)
+	}
+
+	log.Printf("Flushed %d data points to %s", len(snapshot), s.dataFile)
+
+	return len(snapshot), s.dataFile, nil
+}
+
+// loadSnapshot restores the stored data from the data file, a missing file is not an error
+func (s *DatabaseService) loadSnapshot() error {
+	raw, err := os.ReadFile(s.dataFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	//the format is detected from the file itself, not from the configured write format
+	snapshot, err := decodeSnapshot(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.Replace(snapshot); err != nil {
+		return err
+	}
+
+	count, err := s.storage.Count()
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded %d data points from %s", count, s.dataFile)
+
+	return nil
+}
+
+// Timestamps cross the wire as protobuf Timestamps (seconds + nanos), so the instant including sub-second precision
+// survives a store/fetch cycle. The time zone and the monotonic clock reading do not: a fetched timestamp is always
+// in UTC, so it has to be compared with Time.Equal (or SensorData.Equal), never with ==
+
+// timestampToProto converts a timestamp for the wire
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	return timestamppb.New(t)
+}
+
+// timestampFromProto converts a timestamp received over the wire, the result is in UTC
+func timestampFromProto(ts *timestamppb.Timestamp) time.Time {
+	return ts.AsTime()
 }
 
-// Convert from SensorDataRequest (protobuf) to SensorData (internal type)
+// Convert from SensorDataRequest (protobuf) to SensorData (internal type); a missing timestamp means "now"
 func protoToSensorData(req *pb.SensorDataRequest) types.SensorData {
 	var timestamp time.Time
 	if req.Timestamp != nil {
-		timestamp = req.Timestamp.AsTime()
+		timestamp = timestampFromProto(req.Timestamp)
 	} else {
 		timestamp = time.Now()
 	}
 
-	return types.SensorData{
+	data := types.SensorData{
 		SensorID:  req.SensorId,
 		Timestamp: timestamp,
 		Value:     req.Value,
 		Unit:      req.Unit,
 	}
+	if req.IngestedAt != nil {
+		data.IngestedAt = timestampFromProto(req.IngestedAt)
+	}
+	data.Sequence = req.Sequence
+	return data
+}
+
+// sensorDataListResponse turns the result of a Storage read into an RPC response
+func sensorDataListResponse(list []types.SensorData, err error) (*pb.SensorDataList, error) {
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error reading data: %v", err)
+	}
+	return &pb.SensorDataList{Data: sensorDataListToProto(list)}, nil
+}
+
+// sensorDataListToProto converts a list of stored points for the wire
+func sensorDataListToProto(list []types.SensorData) []*pb.SensorDataRequest {
+	result := make([]*pb.SensorDataRequest, len(list))
+	for i, data := range list {
+		result[i] = sensorDataToProto(data)
+	}
+	return result
 }
 
 // Convert from SensorData (internal type) to SensorDataRequest (protobuf)
 func sensorDataToProto(data types.SensorData) *pb.SensorDataRequest {
-	return &pb.SensorDataRequest{
+	req := &pb.SensorDataRequest{
 		SensorId:  data.SensorID,
-		Timestamp: timestamppb.New(data.Timestamp),
+		Timestamp: timestampToProto(data.Timestamp),
 		Value:     data.Value,
 		Unit:      data.Unit,
+		Sequence:  data.Sequence,
 	}
+	if !data.IngestedAt.IsZero() {
+		req.IngestedAt = timestampToProto(data.IngestedAt)
+	}
+	return req
+}
+
+// protoToSensorDataPatch converts a patch received over the wire; unlike a reading, a missing timestamp stays zero
+// because a patch has to address a stored point
+func protoToSensorDataPatch(req *pb.SensorDataPatch) types.SensorDataPatch {
+	patch := types.SensorDataPatch{
+		SensorID: req.SensorId,
+		Value:    req.Value,
+		Unit:     req.Unit,
+	}
+	if req.Timestamp != nil {
+		patch.Timestamp = timestampFromProto(req.Timestamp)
+	}
+	return patch
+}
+
+// protoListToSensorData converts a list of stored points received over the wire
+func protoListToSensorData(list []*pb.SensorDataRequest) []types.SensorData {
+	result := make([]types.SensorData, len(list))
+	for i, data := range list {
+		result[i] = protoToSensorData(data)
+	}
+	return result
 }
 
 // addDataPointInternal adds sensor data to the internal storage (used by both direct and 2PC paths)
-func (s *DatabaseService) addDataPointInternal(sensorData types.SensorData) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *DatabaseService) addDataPointInternal(sensorData types.SensorData) error {
+	return s.addDataPointsInternal([]types.SensorData{sensorData})
+}
+
+// addDataPointsInternal stores all readings in one Storage call, so readers never see part of a batch. Every reading
+// gets the current time as IngestedAt and the next store sequence, whatever the request carried
+func (s *DatabaseService) addDataPointsInternal(readings []types.SensorData) error {
+	return s.storeReadings(readings, nil)
+}
+
+// storeReadings stores the readings like addDataPointsInternal; beforeStore, if not nil, gets them with their
+// ingestion time and store sequence right before they are stored, and nothing is stored if it fails
+func (s *DatabaseService) storeReadings(readings []types.SensorData, beforeStore func([]types.SensorData) error) error {
+	s.storeMu.Lock()
+	sequence := s.storeSeq + 1
+	ingestedAt := time.Now()
+	for i := range readings {
+		readings[i].IngestedAt = ingestedAt
+		readings[i].Sequence = sequence
+	}
+
+	if beforeStore != nil {
+		if err := beforeStore(readings); err != nil {
+			s.storeMu.Unlock()
+			return err
+		}
+	}
+
+	store := s.storage.Add
+	if s.upsert {
+		store = s.storage.Upsert
+	}
+	if err := store(readings); err != nil {
+		s.storeMu.Unlock()
+		return fmt.Errorf("error storing data: %w", err)
+	}
+	//a failed write does not use up its sequence, so a gap always means a missed write
+	s.storeSeq = sequence
+	s.storeMu.Unlock()
+
+	if len(readings) == 1 {
+		logging.Debugf("Stored data from sensor %s: %.2f %s", readings[0].SensorID, readings[0].Value, readings[0].Unit)
+	} else {
+		logging.Debugf("Stored batch of %d data points", len(readings))
+	}
+
+	//observers are notified after the store call returned so they can read the store themselves
+	for _, sensorData := range readings {
+		s.notifyStored(sensorData)
+	}
+	return nil
+}
+
+// restoreStoreSequence continues the store sequence after the highest one of the stored points
+func (s *DatabaseService) restoreStoreSequence() error {
+	stored, err := s.storage.GetAll()
+	if err != nil {
+		return err
+	}
+
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	for _, point := range stored {
+		s.storeSeq = max(s.storeSeq, point.Sequence)
+	}
+	return nil
+}
+
+// Snapshot returns a copy of all stored points in insertion order, e.g. to save the state of a test fixture and
+// Restore it afterwards
+func (s *DatabaseService) Snapshot() ([]types.SensorData, error) {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	return s.storage.GetAll()
+}
+
+// Restore replaces the whole store with points, e.g. a previous Snapshot, and drops pending prepared transactions.
+// Unlike a write the points keep their ingestion time and store sequence, and the store sequence continues after the
+// highest one of them. With sync commits the restored points are written as the snapshot and the commit log is emptied
+func (s *DatabaseService) Restore(points []types.SensorData) error {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
 
-	s.data = append(s.data, sensorData)
+	if err := s.storage.Replace(points); err != nil {
+		return fmt.Errorf("error restoring data: %w", err)
+	}
+	s.preparedTxns = make(map[string]*TransactionState)
+
+	s.storeSeq = 0
+	for _, point := range points {
+		s.storeSeq = max(s.storeSeq, point.Sequence)
+	}
 
-	//if we exceeded the limit, remove the oldest data points following FIFO
-	if len(s.data) > s.maxDataPoints {
-		s.data = s.data[len(s.data)-s.maxDataPoints:]
+	//the logged commits belong to the replaced data, a restart must start from the restored points instead
+	if s.commitLog != nil {
+		if _, _, err := s.writeSnapshot(); err != nil {
+			return fmt.Errorf("error persisting restored data: %w", err)
+		}
 	}
+	return nil
+}
 
-	log.Printf("Stored data from sensor %s: %.2f %s", sensorData.SensorID, sensorData.Value, sensorData.Unit)
+// Clear empties the store and drops pending prepared transactions, so the next write gets store sequence 1 again like
+// in a new database
+func (s *DatabaseService) Clear() error {
+	return s.Restore(nil)
 }
 
 // CreateSensorData adds new sensor data to the store (direct path, non-2PC).
@@ -139,7 +511,12 @@ func (s *DatabaseService) CreateSensorData(ctx context.Context, req *pb.SensorDa
 	}
 
 	sensorData := protoToSensorData(req)
-	s.addDataPointInternal(sensorData)
+	if err := s.addDataPointInternal(sensorData); err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
 
 	return &pb.OperationResponse{
 		Success: true,
@@ -156,18 +533,58 @@ func (s *DatabaseService) PrepareTransaction(ctx context.Context, req *pb.Transa
 		}, nil
 	}
 
-	if req.SensorData == nil {
-		return &pb.PrepareResponse{
-			Success: false,
-			Message: "Missing sensor data",
-		}, nil
+	//a delete-all transaction carries no sensor data, a batch carries its readings in batch
+	switch req.Operation {
+	case pb.TransactionOperation_TRANSACTION_OPERATION_DELETE_ALL:
+		//nothing to validate
+	case pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH:
+		if len(req.Batch) == 0 {
+			return &pb.PrepareResponse{
+				Success: false,
+				Message: "Empty batch",
+			}, nil
+		}
+
+		for i, data := range req.Batch {
+			if data.GetSensorId() == "" {
+				return &pb.PrepareResponse{
+					Success: false,
+					Message: fmt.Sprintf("Missing sensor ID in batch entry %d", i),
+				}, nil
+			}
+		}
+	case pb.TransactionOperation_TRANSACTION_OPERATION_PATCH:
+		if req.Patch == nil {
+			return &pb.PrepareResponse{
+				Success: false,
+				Message: "Missing patch",
+			}, nil
+		}
+
+		if err := protoToSensorDataPatch(req.Patch).Validate(); err != nil {
+			return &pb.PrepareResponse{
+				Success: false,
+				Message: fmt.Sprintf("Invalid patch: %v", err),
+			}, nil
+		}
+	default:
+		if req.SensorData == nil {
+			return &pb.PrepareResponse{
+				Success: false,
+				Message: "Missing sensor data",
+			}, nil
+		}
+
+		if req.SensorData.SensorId == "" {
+			return &pb.PrepareResponse{
+				Success: false,
+				Message: "Missing sensor ID in sensor data",
+			}, nil
+		}
 	}
 
-	if req.SensorData.SensorId == "" {
-		return &pb.PrepareResponse{
-			Success: false,
-			Message: "Missing sensor ID in sensor data",
-		}, nil
+	if resp := s.validatePrepare(req); resp != nil {
+		return resp, nil
 	}
 
 	s.txnMutex.Lock()
@@ -182,16 +599,75 @@ func (s *DatabaseService) PrepareTransaction(ctx context.Context, req *pb.Transa
 		}, nil
 	}
 
-	sensorData := protoToSensorData(req.SensorData)
+	//backpressure: refuse new work instead of queueing an unbounded number of transactions; expired ones are dropped
+	//first so they do not hold their slots until the next cleanup
+	if s.maxPrepared > 0 && len(s.preparedTxns) >= s.maxPrepared && s.removeExpiredTransactions() == 0 {
+		return &pb.PrepareResponse{
+			Success:       false,
+			Message:       fmt.Sprintf("Too many prepared transactions (%d), retry later", s.maxPrepared),
+			TransactionId: req.TransactionId,
+			Overloaded:    true,
+		}, nil
+	}
+
+	var sensorData types.SensorData
+	if req.SensorData != nil {
+		sensorData = protoToSensorData(req.SensorData)
+	}
+
+	//a patch of a point that is not stored could never be applied, so it gets a no-vote instead of committing nothing
+	var patch types.SensorDataPatch
+	if req.Operation == pb.TransactionOperation_TRANSACTION_OPERATION_PATCH {
+		patch = protoToSensorDataPatch(req.Patch)
+		point, exists, err := s.storedPoint(patch.SensorID, patch.Timestamp)
+		if err != nil {
+			return &pb.PrepareResponse{
+				Success:       false,
+				Message:       fmt.Sprintf("error reading data: %v", err),
+				TransactionId: req.TransactionId,
+			}, nil
+		}
+		if !exists {
+			return &pb.PrepareResponse{
+				Success:       false,
+				Message:       "Data not found",
+				TransactionId: req.TransactionId,
+				NotFound:      true,
+			}, nil
+		}
+		if resp := s.validateReadings(req.TransactionId, patch.Apply(point)); resp != nil {
+			return resp, nil
+		}
+	}
+
+	var batch []types.SensorData
+	if req.Operation == pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH {
+		batch = make([]types.SensorData, len(req.Batch))
+		for i, data := range req.Batch {
+			batch[i] = protoToSensorData(data)
+		}
+	}
 
 	//store the transaction state in the prepared transactions for now
 	s.preparedTxns[req.TransactionId] = &TransactionState{
 		TransactionID: req.TransactionId,
+		Operation:     req.Operation,
 		SensorData:    sensorData,
+		Batch:         batch,
+		Patch:         patch,
 		PreparedAt:    time.Now(),
 	}
 
-	log.Printf("Prepared transaction %s for sensor %s", req.TransactionId, sensorData.SensorID)
+	switch req.Operation {
+	case pb.TransactionOperation_TRANSACTION_OPERATION_DELETE_ALL:
+		logging.Debugf("Prepared transaction %s to delete all data", req.TransactionId)
+	case pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH:
+		logging.Debugf("Prepared transaction %s for a batch of %d readings", req.TransactionId, len(batch))
+	case pb.TransactionOperation_TRANSACTION_OPERATION_PATCH:
+		logging.Debugf("Prepared transaction %s to patch sensor %s", req.TransactionId, patch.SensorID)
+	default:
+		logging.Debugf("Prepared transaction %s for sensor %s", req.TransactionId, sensorData.SensorID)
+	}
 
 	return &pb.PrepareResponse{
 		Success:       true,
@@ -200,6 +676,47 @@ func (s *DatabaseService) PrepareTransaction(ctx context.Context, req *pb.Transa
 	}, nil
 }
 
+// validatePrepare runs the validator on the readings an add or batch transaction would store and returns the no-vote
+// if one fails, nil otherwise; patches are validated once the point they change is known
+func (s *DatabaseService) validatePrepare(req *pb.TransactionRequest) *pb.PrepareResponse {
+	if s.validator == nil {
+		return nil
+	}
+
+	switch req.Operation {
+	case pb.TransactionOperation_TRANSACTION_OPERATION_ADD:
+		return s.validateReadings(req.TransactionId, protoToSensorData(req.SensorData))
+	case pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH:
+		readings := make([]types.SensorData, len(req.Batch))
+		for i, data := range req.Batch {
+			readings[i] = protoToSensorData(data)
+		}
+		return s.validateReadings(req.TransactionId, readings...)
+	default:
+		return nil
+	}
+}
+
+// validateReadings returns a rejected no-vote naming the first reading that fails the validator, nil if all pass
+func (s *DatabaseService) validateReadings(transactionID string, readings ...types.SensorData) *pb.PrepareResponse {
+	if s.validator == nil {
+		return nil
+	}
+
+	for _, reading := range readings {
+		if err := s.validator(reading); err != nil {
+			logging.Debugf("Rejected transaction %s: reading of sensor %s failed validation: %v", transactionID, reading.SensorID, err)
+			return &pb.PrepareResponse{
+				Success:       false,
+				Message:       fmt.Sprintf("Reading of sensor %s rejected: %v", reading.SensorID, err),
+				TransactionId: transactionID,
+				Rejected:      true,
+			}
+		}
+	}
+	return nil
+}
+
 // CommitTransaction implements the commit phase of Two-Phase Commit
 func (s *DatabaseService) CommitTransaction(ctx context.Context, req *pb.TransactionId) (*pb.OperationResponse, error) {
 	if req.TransactionId == "" {
@@ -221,17 +738,60 @@ func (s *DatabaseService) CommitTransaction(ctx context.Context, req *pb.Transac
 		}, nil
 	}
 
-	//the actual commit of the data is done here
-	s.addDataPointInternal(txnState.SensorData)
+	//the actual commit of the data is done here; with sync commits it is logged to disk before it is applied
+	logAdd := func(readings []types.SensorData) error {
+		return s.logCommit(commitRecord{Operation: commitOpAdd, Readings: readings})
+	}
+	var affected int64
+	var err error
+	switch txnState.Operation {
+	case pb.TransactionOperation_TRANSACTION_OPERATION_DELETE_ALL:
+		if err = s.logSequenced(commitRecord{Operation: commitOpDeleteAll}); err == nil {
+			var removed int
+			removed, err = s.deleteAllInternal()
+			affected = int64(removed)
+		}
+	case pb.TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH:
+		err = s.storeReadings(txnState.Batch, logAdd)
+		affected = int64(len(txnState.Batch))
+	case pb.TransactionOperation_TRANSACTION_OPERATION_PATCH:
+		//the point may have been evicted since the prepare, then nothing is affected
+		if err = s.logSequenced(commitRecord{Operation: commitOpPatch, Patch: &txnState.Patch}); err == nil {
+			var patched bool
+			patched, err = s.storage.Patch(txnState.Patch)
+			if patched {
+				affected = 1
+			}
+		}
+	default:
+		err = s.storeReadings([]types.SensorData{txnState.SensorData}, logAdd)
+		affected = 1
+	}
+
+	//a failed store leaves the transaction prepared, so the coordinator can retry the commit
+	if err != nil {
+		logging.Warnf("Failed to commit transaction %s: %v", req.TransactionId, err)
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Transaction %s not committed: %v", req.TransactionId, err),
+		}, nil
+	}
 
 	//after that, we need to remove from prepared transactions
 	delete(s.preparedTxns, req.TransactionId)
 
-	log.Printf("Committed transaction %s for sensor %s", req.TransactionId, txnState.SensorData.SensorID)
+	//sequences of replayed writes may arrive after newer ones, the applied sequence never goes back
+	if req.Sequence > s.appliedSeq.Load() {
+		s.appliedSeq.Store(req.Sequence)
+	}
+
+	logging.Debugf("Committed transaction %s (%s, %d points affected)", req.TransactionId, txnState.Operation, affected)
 
 	return &pb.OperationResponse{
-		Success: true,
-		Message: "Transaction committed successfully",
+		Success:         true,
+		Message:         "Transaction committed successfully",
+		PointsAffected:  affected,
+		AppliedSequence: s.appliedSeq.Load(),
 	}, nil
 }
 
@@ -259,7 +819,7 @@ func (s *DatabaseService) AbortTransaction(ctx context.Context, req *pb.Transact
 	//remove from the prepared transactions (the data is discarded)
 	delete(s.preparedTxns, req.TransactionId)
 
-	log.Printf("Aborted transaction %s for sensor %s", req.TransactionId, txnState.SensorData.SensorID)
+	logging.Debugf("Aborted transaction %s (%s)", req.TransactionId, txnState.Operation)
 
 	return &pb.OperationResponse{
 		Success: true,
@@ -269,18 +829,7 @@ func (s *DatabaseService) AbortTransaction(ctx context.Context, req *pb.Transact
 
 // GetAllSensorData returns all stored sensor data.
 func (s *DatabaseService) GetAllSensorData(ctx context.Context, req *pb.EmptyRequest) (*pb.SensorDataList, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	result := &pb.SensorDataList{
-		Data: make([]*pb.SensorDataRequest, len(s.data)),
-	}
-
-	for i, data := range s.data {
-		result.Data[i] = sensorDataToProto(data)
-	}
-
-	return result, nil
+	return sensorDataListResponse(s.storage.GetAll())
 }
 
 // GetSensorDataBySensorId returns data for a specific sensor.
@@ -289,18 +838,36 @@ func (s *DatabaseService) GetSensorDataBySensorId(ctx context.Context, req *pb.S
 		return &pb.SensorDataList{}, nil
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return sensorDataListResponse(s.storage.GetBySensor(req.SensorId))
+}
 
-	var result []*pb.SensorDataRequest
-	for _, data := range s.data {
-		if data.SensorID == req.SensorId {
-			result = append(result, sensorDataToProto(data))
-		}
+// GetSensorDataByPrefix returns data for all sensors whose ID starts with the given prefix.
+func (s *DatabaseService) GetSensorDataByPrefix(ctx context.Context, req *pb.SensorPrefixRequest) (*pb.SensorDataList, error) {
+	return sensorDataListResponse(s.storage.GetByPrefix(req.Prefix))
+}
+
+// MaxSensorIdsPerRequest caps the number of sensor IDs in a single bulk query
+const MaxSensorIdsPerRequest = 100
+
+// GetSensorDataByIds returns the data of several sensors in one pass, grouped by sensor ID.
+func (s *DatabaseService) GetSensorDataByIds(ctx context.Context, req *pb.SensorIdsRequest) (*pb.SensorDataGroups, error) {
+	if len(req.SensorIds) > MaxSensorIdsPerRequest {
+		return nil, status.Errorf(codes.InvalidArgument, "too many sensor IDs: %d (max %d)", len(req.SensorIds), MaxSensorIdsPerRequest)
+	}
+
+	found, err := s.storage.GetBySensors(req.SensorIds)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error reading data: %v", err)
+	}
+
+	//every requested ID gets a group, even if there is no data for it
+	groups := make(map[string]*pb.SensorDataList, len(req.SensorIds))
+	for _, sensorID := range req.SensorIds {
+		groups[sensorID] = &pb.SensorDataList{Data: sensorDataListToProto(found[sensorID])}
 	}
 
-	return &pb.SensorDataList{
-		Data: result,
+	return &pb.SensorDataGroups{
+		Groups: groups,
 	}, nil
 }
 
@@ -313,19 +880,17 @@ func (s *DatabaseService) UpdateSensorData(ctx context.Context, req *pb.SensorDa
 		}, nil
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	updated := false
-	timestamp := req.Timestamp.AsTime()
-
-	for i, data := range s.data {
-		if data.SensorID == req.SensorId && data.Timestamp.Equal(timestamp) {
-			s.data[i].Value = req.Value
-			s.data[i].Unit = req.Unit
-			updated = true
-			break
-		}
+	updated, err := s.storage.Update(types.SensorData{
+		SensorID:  req.SensorId,
+		Timestamp: timestampFromProto(req.Timestamp),
+		Value:     req.Value,
+		Unit:      req.Unit,
+	})
+	if err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("error updating data: %v", err),
+		}, nil
 	}
 
 	if !updated {
@@ -341,6 +906,47 @@ func (s *DatabaseService) UpdateSensorData(ctx context.Context, req *pb.SensorDa
 	}, nil
 }
 
+// PatchSensorData changes only the fields set in the patch of the point matching SensorID and Timestamp.
+func (s *DatabaseService) PatchSensorData(ctx context.Context, req *pb.SensorDataPatch) (*pb.OperationResponse, error) {
+	patch := protoToSensorDataPatch(req)
+	if err := patch.Validate(); err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid patch: %v", err),
+		}, nil
+	}
+
+	patched, err := s.storage.Patch(patch)
+	if err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("error patching data: %v", err),
+		}, nil
+	}
+
+	if !patched {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: "Data not found",
+		}, nil
+	}
+
+	return &pb.OperationResponse{
+		Success:        true,
+		Message:        "Data patched successfully",
+		PointsAffected: 1,
+	}, nil
+}
+
+// storedPoint returns the point of the sensor with exactly the timestamp and whether it is stored
+func (s *DatabaseService) storedPoint(sensorID string, timestamp time.Time) (types.SensorData, bool, error) {
+	points, err := s.storage.GetBySensorRange(sensorID, timestamp, timestamp.Add(time.Nanosecond))
+	if err != nil || len(points) == 0 {
+		return types.SensorData{}, false, err
+	}
+	return points[0], true, nil
+}
+
 // DeleteSensorData deletes all data for a specific sensor.
 func (s *DatabaseService) DeleteSensorData(ctx context.Context, req *pb.SensorIdRequest) (*pb.OperationResponse, error) {
 	if req.SensorId == "" {
@@ -350,22 +956,72 @@ func (s *DatabaseService) DeleteSensorData(ctx context.Context, req *pb.SensorId
 		}, nil
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	removed, err := s.storage.Delete(req.SensorId)
+	if err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("error deleting data: %v", err),
+		}, nil
+	}
+
+	return &pb.OperationResponse{
+		Success:        true,
+		Message:        "Deleted data for sensor",
+		PointsAffected: int64(removed),
+	}, nil
+}
 
-	initialLen := len(s.data)
-	newData := make([]types.SensorData, 0, initialLen)
+// DeleteAllSensorData removes all stored data and drops pending prepared transactions (direct path, non-2PC).
+func (s *DatabaseService) DeleteAllSensorData(ctx context.Context, req *pb.EmptyRequest) (*pb.OperationResponse, error) {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
 
-	for _, data := range s.data {
-		if data.SensorID != req.SensorId {
-			newData = append(newData, data)
-		}
+	removed, err := s.deleteAllInternal()
+	if err != nil {
+		return &pb.OperationResponse{
+			Success: false,
+			Message: fmt.Sprintf("error deleting data: %v", err),
+		}, nil
 	}
 
-	s.data = newData
+	//a full reset also discards writes that were prepared but not committed yet
+	dropped := len(s.preparedTxns)
+	s.preparedTxns = make(map[string]*TransactionState)
+
+	log.Printf("Deleted all data: %d points removed, %d prepared transactions dropped", removed, dropped)
 
 	return &pb.OperationResponse{
-		Success: true,
-		Message: "Deleted data for sensor",
+		Success:        true,
+		Message:        "Deleted all data",
+		PointsAffected: int64(removed),
+	}, nil
+}
+
+// deleteAllInternal clears the store, returning the number of removed points
+func (s *DatabaseService) deleteAllInternal() (int, error) {
+	return s.storage.DeleteAll()
+}
+
+// GetAppliedSequence returns the highest commit sequence this database has applied
+func (s *DatabaseService) GetAppliedSequence(ctx context.Context, req *pb.EmptyRequest) (*pb.SequenceResponse, error) {
+	return &pb.SequenceResponse{AppliedSequence: s.appliedSeq.Load()}, nil
+}
+
+// FlushSnapshot implements the admin RPC that forces a snapshot of the store to disk.
+func (s *DatabaseService) FlushSnapshot(ctx context.Context, req *pb.EmptyRequest) (*pb.FlushResponse, error) {
+	written, path, err := s.Flush()
+	if err != nil {
+		return &pb.FlushResponse{
+			Success:  false,
+			Message:  err.Error(),
+			FilePath: path,
+		}, nil
+	}
+
+	return &pb.FlushResponse{
+		Success:       true,
+		Message:       "Data flushed successfully",
+		PointsWritten: int64(written),
+		FilePath:      path,
 	}, nil
 }
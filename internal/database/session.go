@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// ErrSequenceNotApplied is returned by a read session when no reachable replica has applied the requested sequence yet
+var ErrSequenceNotApplied = errors.New("no replica has applied the requested sequence yet")
+
+// ReadSession reads from the replicas like the 2PC client, but only from those that have applied at least the
+// commit sequence of an earlier write, so that a client always sees its own writes
+type ReadSession struct {
+	tpc         *TwoPhaseCommitClient
+	minSequence uint64
+}
+
+// ReadAtLeast returns a read session that only reads from replicas that applied the given commit sequence, as returned
+// by AddDataPointWithSequence. Sequence 0 places no restriction
+func (tpc *TwoPhaseCommitClient) ReadAtLeast(sequence uint64) *ReadSession {
+	return &ReadSession{tpc: tpc, minSequence: sequence}
+}
+
+// nextSequence assigns the commit sequence of a transaction. Sequences are increasing and based on the wall clock,
+// so they keep increasing across restarts of the coordinator
+func (tpc *TwoPhaseCommitClient) nextSequence() uint64 {
+	for {
+		last := tpc.sequence.Load()
+		next := max(last+1, uint64(time.Now().UnixNano()))
+		if tpc.sequence.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// recordApplied remembers the sequence a replica reported as applied
+func (tpc *TwoPhaseCommitClient) recordApplied(replica int, sequence uint64) {
+	for {
+		known := tpc.applied[replica].Load()
+		if sequence <= known || tpc.applied[replica].CompareAndSwap(known, sequence) {
+			return
+		}
+	}
+}
+
+// hasApplied reports whether a replica applied the sequence, asking the replica if the last known sequence is older
+func (tpc *TwoPhaseCommitClient) hasApplied(replica int, sequence uint64) (bool, error) {
+	if tpc.applied[replica].Load() >= sequence {
+		return true, nil
+	}
+
+	applied, err := tpc.clients[replica].AppliedSequence()
+	tpc.breakers[replica].record(!isReplicaFailure(err))
+	if err != nil {
+		return false, err
+	}
+	tpc.recordApplied(replica, applied)
+	return applied >= sequence, nil
+}
+
+// GetAllDataPoints returns all stored sensor data from a replica that applied the session's sequence
+func (s *ReadSession) GetAllDataPoints() ([]types.SensorData, error) {
+	return readFromReplicas(s.tpc, s.minSequence, (*Client).GetAllDataPoints)
+}
+
+// GetDataPointBySensorId returns data for a specific sensor from a replica that applied the session's sequence
+func (s *ReadSession) GetDataPointBySensorId(sensorID string) ([]types.SensorData, error) {
+	return readFromReplicas(s.tpc, s.minSequence, func(client *Client) ([]types.SensorData, error) {
+		return client.GetDataPointBySensorId(sensorID)
+	})
+}
+
+// GetDataPointsByPrefix returns data for all sensors whose ID starts with the prefix from a replica that applied the
+// session's sequence
+func (s *ReadSession) GetDataPointsByPrefix(prefix string) ([]types.SensorData, error) {
+	return readFromReplicas(s.tpc, s.minSequence, func(client *Client) ([]types.SensorData, error) {
+		return client.GetDataPointsByPrefix(prefix)
+	})
+}
+
+// GetDataPointsByIds returns the data of several sensors grouped by sensor ID from a replica that applied the
+// session's sequence
+func (s *ReadSession) GetDataPointsByIds(sensorIDs []string) (map[string][]types.SensorData, error) {
+	return readFromReplicas(s.tpc, s.minSequence, func(client *Client) (map[string][]types.SensorData, error) {
+		return client.GetDataPointsByIds(sensorIDs)
+	})
+}
+
+// errNotApplied wraps ErrSequenceNotApplied for a single replica
+func errNotApplied(address string, sequence uint64) error {
+	return fmt.Errorf("database %s has not applied sequence %d: %w", address, sequence, ErrSequenceNotApplied)
+}
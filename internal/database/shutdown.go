@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned for a transaction started after the 2PC client began to drain
+var ErrClientClosed = errors.New("2PC client is shutting down")
+
+// drainState tracks the transactions in progress, so DrainAndClose can wait for them before closing the connections
+type drainState struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// beginTransaction registers a transaction in progress; it reports false once the client is draining
+func (tpc *TwoPhaseCommitClient) beginTransaction() bool {
+	tpc.drain.mu.Lock()
+	defer tpc.drain.mu.Unlock()
+
+	if tpc.drain.draining {
+		return false
+	}
+	tpc.drain.inFlight.Add(1)
+	return true
+}
+
+// endTransaction marks a transaction registered by beginTransaction as finished
+func (tpc *TwoPhaseCommitClient) endTransaction() {
+	tpc.drain.inFlight.Done()
+}
+
+// DrainAndClose refuses new transactions with ErrClientClosed, waits up to timeout (0 = no limit) for the ones in
+// progress to commit or abort and then closes the client. The connections are closed even if the timeout expires,
+// the transactions still running then fail on the closed connections and the error reports that they were cut off
+func (tpc *TwoPhaseCommitClient) DrainAndClose(timeout time.Duration) error {
+	tpc.drain.mu.Lock()
+	tpc.drain.draining = true
+	tpc.drain.mu.Unlock()
+
+	var drainErr error
+	if !waitTimeout(&tpc.drain.inFlight, timeout) {
+		drainErr = fmt.Errorf("transactions still in progress after %v", timeout)
+	}
+	return errors.Join(drainErr, tpc.Close())
+}
+
+// waitTimeout waits for the wait group up to timeout (0 = no limit) and reports whether it finished in time
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if timeout <= 0 {
+		<-done
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// SnapshotFormat selects the serialization of the on-disk snapshot
+type SnapshotFormat string
+
+// supported snapshot formats; JSON is human readable, gob and binary are faster and smaller
+const (
+	SnapshotJSON   SnapshotFormat = "json"
+	SnapshotGob    SnapshotFormat = "gob"
+	SnapshotBinary SnapshotFormat = "binary"
+)
+
+// magic bytes written in front of the non-JSON formats so the loader can detect them
+var (
+	gobMagic    = []byte("SDG1")
+	binaryMagic = []byte("SDB3")
+	binaryV2    = []byte("SDB2") //binary snapshots written before the store sequence was recorded, still loaded
+	binaryV1    = []byte("SDB1") //binary snapshots written before the ingestion time was recorded, still loaded
+	gzipMagic   = []byte{0x1f, 0x8b}
+)
+
+// ParseSnapshotFormat converts a flag value into a SnapshotFormat
+func ParseSnapshotFormat(value string) (SnapshotFormat, error) {
+	switch format := SnapshotFormat(value); format {
+	case SnapshotJSON, SnapshotGob, SnapshotBinary:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown snapshot format %q (expected json, gob or binary)", value)
+	}
+}
+
+// WithSnapshotFormat sets the format used when writing snapshots, optionally wrapped in gzip.
+// Loading always detects the format of the existing file, so switching formats keeps old snapshots readable
+func WithSnapshotFormat(format SnapshotFormat, compress bool) ServiceOption {
+	return func(s *DatabaseService) {
+		s.snapshotFormat = format
+		s.snapshotGzip = compress
+	}
+}
+
+// encodeSnapshot serializes the data in the given format, wrapped in gzip if compress is set
+func encodeSnapshot(data []types.SensorData, format SnapshotFormat, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	var err error
+	switch format {
+	case SnapshotGob:
+		if _, err = w.Write(gobMagic); err == nil {
+			err = gob.NewEncoder(w).Encode(data)
+		}
+	case SnapshotBinary:
+		err = encodeBinarySnapshot(w, data)
+	default:
+		err = json.NewEncoder(w).Encode(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s snapshot: %w", format, err)
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("error compressing snapshot: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeSnapshot detects the format (gzip, gob, binary or JSON) from the leading bytes and deserializes the data
+func decodeSnapshot(raw []byte) ([]types.SensorData, error) {
+	if bytes.HasPrefix(raw, gzipMagic) {
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("error opening compressed snapshot: %w", err)
+		}
+		defer gz.Close()
+
+		raw, err = io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing snapshot: %w", err)
+		}
+	}
+
+	var data []types.SensorData
+	switch {
+	case bytes.HasPrefix(raw, gobMagic):
+		if err := gob.NewDecoder(bytes.NewReader(raw[len(gobMagic):])).Decode(&data); err != nil {
+			return nil, fmt.Errorf("error parsing gob snapshot: %w", err)
+		}
+	case bytes.HasPrefix(raw, binaryMagic), bytes.HasPrefix(raw, binaryV2), bytes.HasPrefix(raw, binaryV1):
+		version := 3
+		if bytes.HasPrefix(raw, binaryV2) {
+			version = 2
+		} else if bytes.HasPrefix(raw, binaryV1) {
+			version = 1
+		}
+		var err error
+		data, err = decodeBinarySnapshot(raw[len(binaryMagic):], version)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing binary snapshot: %w", err)
+		}
+	default:
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("error parsing snapshot: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
+// encodeBinarySnapshot writes the magic, the point count and then every point as length-prefixed sensor ID, unix
+// nano timestamp, value bits, length-prefixed unit, unix nano ingestion time (0 if unset) and store sequence (big
+// endian)
+func encodeBinarySnapshot(w io.Writer, data []types.SensorData) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(binaryMagic)
+
+	var scratch [8]byte
+	binary.BigEndian.PutUint32(scratch[:4], uint32(len(data)))
+	bw.Write(scratch[:4])
+
+	for _, point := range data {
+		if len(point.SensorID) > math.MaxUint16 || len(point.Unit) > math.MaxUint16 {
+			return fmt.Errorf("sensor ID or unit of %q too long for the binary format", point.SensorID)
+		}
+
+		binary.BigEndian.PutUint16(scratch[:2], uint16(len(point.SensorID)))
+		bw.Write(scratch[:2])
+		bw.WriteString(point.SensorID)
+
+		binary.BigEndian.PutUint64(scratch[:], uint64(point.Timestamp.UnixNano()))
+		bw.Write(scratch[:])
+
+		binary.BigEndian.PutUint64(scratch[:], math.Float64bits(point.Value))
+		bw.Write(scratch[:])
+
+		binary.BigEndian.PutUint16(scratch[:2], uint16(len(point.Unit)))
+		bw.Write(scratch[:2])
+		bw.WriteString(point.Unit)
+
+		binary.BigEndian.PutUint64(scratch[:], uint64(unixNanoOrZero(point.IngestedAt)))
+		bw.Write(scratch[:])
+
+		binary.BigEndian.PutUint64(scratch[:], point.Sequence)
+		bw.Write(scratch[:])
+	}
+
+	//bufio keeps the first write error, so checking Flush is enough
+	return bw.Flush()
+}
+
+// decodeBinarySnapshot reads the points written by encodeBinarySnapshot (without the magic); points of a version 1
+// snapshot have no ingestion time and those of version 1 and 2 no store sequence
+func decodeBinarySnapshot(raw []byte, version int) ([]types.SensorData, error) {
+	r := bytes.NewReader(raw)
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	//every point takes at least 20 bytes, 8 more per recorded time or sequence, dont trust a count that cannot fit in
+	//the remaining data
+	minPointSize := int64(20 + 8*(version-1))
+	if int64(count)*minPointSize > int64(r.Len()) {
+		return nil, errors.New("point count exceeds snapshot size")
+	}
+
+	readString := func() (string, error) {
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return "", err
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	data := make([]types.SensorData, count)
+	for i := range data {
+		sensorID, err := readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var nanos int64
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &nanos); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+
+		unit, err := readString()
+		if err != nil {
+			return nil, err
+		}
+
+		data[i] = types.SensorData{
+			SensorID:  sensorID,
+			Timestamp: time.Unix(0, nanos),
+			Value:     math.Float64frombits(bits),
+			Unit:      unit,
+		}
+
+		if version >= 2 {
+			var ingestedNanos int64
+			if err := binary.Read(r, binary.BigEndian, &ingestedNanos); err != nil {
+				return nil, err
+			}
+			data[i].IngestedAt = timeFromUnixNano(ingestedNanos)
+		}
+		if version >= 3 {
+			if err := binary.Read(r, binary.BigEndian, &data[i].Sequence); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// unixNanoOrZero encodes a time for the binary formats, the zero time as 0
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// timeFromUnixNano decodes a time written by unixNanoOrZero
+func timeFromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// Storage holds the sensor data of a database. Implementations must be safe for concurrent use and every call is
+// atomic, e.g. a reader never sees part of a batch passed to Add and a failed Add stores nothing. Returned slices
+// belong to the caller
+type Storage interface {
+	// Add appends the readings in order
+	Add(readings []types.SensorData) error
+	// Upsert stores the readings in order like Add, but a reading with the sensor ID and timestamp of a stored point
+	// replaces value and unit of that point instead of being appended
+	Upsert(readings []types.SensorData) error
+	// GetAll returns all points in insertion order
+	GetAll() ([]types.SensorData, error)
+	// GetBySensor returns the points of one sensor in insertion order; a backend indexed by time may order them by
+	// timestamp instead, which is the same as long as the readings of a sensor are stored in time order
+	GetBySensor(sensorID string) ([]types.SensorData, error)
+	// GetBySensorRange returns the points of one sensor with from <= timestamp < to, ordered like GetBySensor
+	GetBySensorRange(sensorID string, from, to time.Time) ([]types.SensorData, error)
+	// GetByPrefix returns the points of all sensors whose ID starts with prefix in insertion order
+	GetByPrefix(prefix string) ([]types.SensorData, error)
+	// GetBySensors returns the points of several sensors grouped by sensor ID, sensors without points are left out
+	GetBySensors(sensorIDs []string) (map[string][]types.SensorData, error)
+	// Update replaces value and unit of the point with the same sensor ID and timestamp, reporting whether it exists
+	Update(data types.SensorData) (bool, error)
+	// Patch applies a partial update to the point with the sensor ID and timestamp of the patch, reporting whether it
+	// exists
+	Patch(patch types.SensorDataPatch) (bool, error)
+	// Delete removes all points of a sensor and returns how many were removed
+	Delete(sensorID string) (int, error)
+	// DeleteAll removes all points and returns how many were removed
+	DeleteAll() (int, error)
+	// Replace swaps the whole content, e.g. for a loaded snapshot
+	Replace(data []types.SensorData) error
+	// Count returns the number of stored points
+	Count() (int, error)
+}
+
+// MemoryStorage is the in-memory Storage: a slice in insertion order that keeps at most limit points, evicting the
+// oldest ones first. Two indexes avoid scanning the slice: the positions of the points of every sensor, for the
+// sensor and prefix queries, and the position of the point with a sensor ID and timestamp, for upserts and updates.
+// Positions count every point ever stored, so evicting from the front does not move the positions of the others
+type MemoryStorage struct {
+	mu          sync.RWMutex
+	data        []types.SensorData
+	limit       int
+	evicted     int              // number of points removed from the front, the position of data[0]
+	sensorIndex map[string][]int // sensor_id -> positions of its points, ascending
+	pointIndex  map[pointKey]pointEntry
+}
+
+// pointEntry is the position of the first point with a sensor ID and timestamp and the number of such points, which
+// is more than one only if Add stored the same reading again
+type pointEntry struct {
+	position int
+	count    int
+}
+
+// pointKey identifies a point by sensor ID and timestamp, the key of Upsert, Update and Patch
+type pointKey struct {
+	sensorID  string
+	timestamp int64 // UnixNano, so times that are Equal in different locations have the same key
+}
+
+func keyOf(sensorID string, timestamp time.Time) pointKey {
+	return pointKey{sensorID: sensorID, timestamp: timestamp.UnixNano()}
+}
+
+// MemoryStorageFactory creates an empty in-memory storage holding at most limit points
+func MemoryStorageFactory(limit int) *MemoryStorage {
+	return &MemoryStorage{
+		data:        make([]types.SensorData, 0, limit),
+		limit:       limit,
+		sensorIndex: make(map[string][]int),
+		pointIndex:  make(map[pointKey]pointEntry),
+	}
+}
+
+// Add appends all readings under a single write lock and applies the eviction once afterwards
+func (m *MemoryStorage) Add(readings []types.SensorData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, reading := range readings {
+		m.appendPoint(reading)
+	}
+
+	m.evict()
+	return nil
+}
+
+// Upsert stores all readings under a single write lock, finding an existing point through the index
+func (m *MemoryStorage) Upsert(readings []types.SensorData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, reading := range readings {
+		if i := m.indexOf(reading.SensorID, reading.Timestamp); i >= 0 {
+			m.data[i].Value = reading.Value
+			m.data[i].Unit = reading.Unit
+			continue
+		}
+		m.appendPoint(reading)
+	}
+
+	m.evict()
+	return nil
+}
+
+// GetAll returns a copy of all stored points
+func (m *MemoryStorage) GetAll() ([]types.SensorData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]types.SensorData, len(m.data))
+	copy(result, m.data)
+	return result, nil
+}
+
+// GetBySensor returns the points of one sensor
+func (m *MemoryStorage) GetBySensor(sensorID string) ([]types.SensorData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.pointsAt(m.sensorIndex[sensorID]), nil
+}
+
+// GetBySensorRange returns the points of one sensor within [from, to)
+func (m *MemoryStorage) GetBySensorRange(sensorID string, from, to time.Time) ([]types.SensorData, error) {
+	points, _ := m.GetBySensor(sensorID)
+
+	var result []types.SensorData
+	for _, data := range points {
+		if !data.Timestamp.Before(from) && data.Timestamp.Before(to) {
+			result = append(result, data)
+		}
+	}
+	return result, nil
+}
+
+// GetByPrefix returns the points of all sensors whose ID starts with prefix, reading only the positions of the
+// matching sensors
+func (m *MemoryStorage) GetByPrefix(prefix string) ([]types.SensorData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var positions []int
+	for sensorID, sensorPositions := range m.sensorIndex {
+		if strings.HasPrefix(sensorID, prefix) {
+			positions = append(positions, sensorPositions...)
+		}
+	}
+
+	//the positions of several sensors are interleaved, sorting them restores the insertion order
+	slices.Sort(positions)
+	return m.pointsAt(positions), nil
+}
+
+// GetBySensors returns the points of several sensors from their positions
+func (m *MemoryStorage) GetBySensors(sensorIDs []string) (map[string][]types.SensorData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make(map[string][]types.SensorData)
+	for _, sensorID := range sensorIDs {
+		if _, ok := groups[sensorID]; ok {
+			continue
+		}
+		if positions := m.sensorIndex[sensorID]; len(positions) > 0 {
+			groups[sensorID] = m.pointsAt(positions)
+		}
+	}
+	return groups, nil
+}
+
+// Update replaces value and unit of the first point matching sensor ID and timestamp
+func (m *MemoryStorage) Update(update types.SensorData) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexOf(update.SensorID, update.Timestamp)
+	if i < 0 {
+		return false, nil
+	}
+	m.data[i].Value = update.Value
+	m.data[i].Unit = update.Unit
+	return true, nil
+}
+
+// Patch applies the patch to the first point matching sensor ID and timestamp
+func (m *MemoryStorage) Patch(patch types.SensorDataPatch) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.indexOf(patch.SensorID, patch.Timestamp)
+	if i < 0 {
+		return false, nil
+	}
+	m.data[i] = patch.Apply(m.data[i])
+	return true, nil
+}
+
+// Delete removes all points of a sensor
+func (m *MemoryStorage) Delete(sensorID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := len(m.sensorIndex[sensorID])
+	if removed == 0 {
+		return 0, nil
+	}
+
+	newData := make([]types.SensorData, 0, m.limit)
+	for _, data := range m.data {
+		if data.SensorID != sensorID {
+			newData = append(newData, data)
+		}
+	}
+
+	//the points behind the removed ones moved, so the positions are counted anew
+	m.reindex(newData)
+	return removed, nil
+}
+
+// DeleteAll clears the store and the indexes
+func (m *MemoryStorage) DeleteAll() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := len(m.data)
+	m.reindex(make([]types.SensorData, 0, m.limit))
+	return removed, nil
+}
+
+// Replace swaps the content, keeping only the newest points if there are more than the limit
+func (m *MemoryStorage) Replace(data []types.SensorData) error {
+	if len(data) > m.limit {
+		data = data[len(data)-m.limit:]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reindex(append(make([]types.SensorData, 0, m.limit), data...))
+	return nil
+}
+
+// Count returns the number of stored points
+func (m *MemoryStorage) Count() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.data), nil
+}
+
+// appendPoint appends a point and adds it to the indexes, the caller must hold the write lock
+func (m *MemoryStorage) appendPoint(data types.SensorData) {
+	position := m.evicted + len(m.data)
+	m.data = append(m.data, data)
+	m.sensorIndex[data.SensorID] = append(m.sensorIndex[data.SensorID], position)
+
+	//Add may store a sensor ID and timestamp twice, the index keeps the first point like a scan would find it
+	key := keyOf(data.SensorID, data.Timestamp)
+	entry, exists := m.pointIndex[key]
+	if !exists {
+		entry.position = position
+	}
+	entry.count++
+	m.pointIndex[key] = entry
+}
+
+// reindex makes data the content and builds both indexes for it, the caller must hold the write lock
+func (m *MemoryStorage) reindex(data []types.SensorData) {
+	m.data = data[:0]
+	m.evicted = 0
+	m.sensorIndex = make(map[string][]int)
+	m.pointIndex = make(map[pointKey]pointEntry)
+	for _, point := range data {
+		m.appendPoint(point)
+	}
+}
+
+// pointsAt returns a copy of the points at the positions, the caller must hold the lock
+func (m *MemoryStorage) pointsAt(positions []int) []types.SensorData {
+	if len(positions) == 0 {
+		return nil
+	}
+
+	result := make([]types.SensorData, len(positions))
+	for i, position := range positions {
+		result[i] = m.data[position-m.evicted]
+	}
+	return result
+}
+
+// indexOf returns the slice index of the first point with the sensor ID and timestamp, -1 if there is none; the caller
+// must hold the lock
+func (m *MemoryStorage) indexOf(sensorID string, timestamp time.Time) int {
+	entry, ok := m.pointIndex[keyOf(sensorID, timestamp)]
+	if !ok {
+		return -1
+	}
+	return entry.position - m.evicted
+}
+
+// evict removes the oldest points following FIFO once the limit is exceeded, the caller must hold the write lock
+func (m *MemoryStorage) evict() {
+	if len(m.data) <= m.limit {
+		return
+	}
+	evicted := len(m.data) - m.limit
+	for _, data := range m.data[:evicted] {
+		m.unindex(data)
+	}
+	m.data = m.data[evicted:]
+	m.evicted += evicted
+}
+
+// unindex removes the oldest point of a sensor from both indexes, the caller must hold the write lock
+func (m *MemoryStorage) unindex(data types.SensorData) {
+	positions := m.sensorIndex[data.SensorID][1:]
+	if len(positions) == 0 {
+		delete(m.sensorIndex, data.SensorID)
+	} else {
+		m.sensorIndex[data.SensorID] = positions
+	}
+
+	key := keyOf(data.SensorID, data.Timestamp)
+	entry := m.pointIndex[key]
+	if entry.count == 1 {
+		delete(m.pointIndex, key)
+		return
+	}
+
+	//the oldest point is always the first of its key, a later one with the same sensor ID and timestamp takes its place
+	entry.count--
+	for _, later := range positions {
+		if keyOf(data.SensorID, m.data[later-m.evicted].Timestamp) == key {
+			entry.position = later
+			break
+		}
+	}
+	m.pointIndex[key] = entry
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// jsonRPCTimeout bounds a single RPC called through the JSON adapter, like the timeout of the gRPC client
+const jsonRPCTimeout = 5 * time.Second
+
+// RegisterJSONHandlers exposes every RPC of the service as JSON over HTTP: POST /rpc/<Method> with the request
+// message as JSON (protobuf JSON mapping, e.g. {"sensorId": "temp-1", "value": 21.5}) calls the service in-process
+// and answers with the response message as JSON. An empty body is the empty request message
+func RegisterJSONHandlers(server *http.Server, service *DatabaseService) {
+	//the generated handlers decode the request with the given function, so the JSON decoding can be plugged in
+	methods := make(map[string]func(context.Context, func(any) error) (any, error))
+	for _, method := range pb.DatabaseService_ServiceDesc.Methods {
+		handler := method.Handler
+		methods[method.MethodName] = func(ctx context.Context, decode func(any) error) (any, error) {
+			return handler(service, ctx, decode, nil)
+		}
+	}
+
+	server.RegisterHandler(
+		http.POST,
+		"/rpc/*",
+		func(req *http.Request) *http.Response {
+			name := strings.TrimPrefix(req.Path, "/rpc/")
+			call, ok := methods[name]
+			if !ok {
+				return http.CreateErrorResponse(http.StatusNotFound, "unknown_method", fmt.Sprintf("Unknown RPC %q", name))
+			}
+
+			body := req.Body
+			if len(body) == 0 {
+				body = []byte("{}")
+			}
+
+			var decodeErr error
+			decode := func(msg any) error {
+				decodeErr = protojson.Unmarshal(body, msg.(proto.Message))
+				return decodeErr
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), jsonRPCTimeout)
+			defer cancel()
+
+			resp, err := call(ctx, decode)
+			if decodeErr != nil {
+				return http.CreateErrorResponse(http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid %s request: %v", name, decodeErr))
+			}
+			if err != nil {
+				logging.Warnf("JSON call of %s failed: %v", name, err)
+				return http.CreateErrorResponse(rpcErrorStatus(err), "rpc_failed", fmt.Sprintf("%s failed: %v", name, err))
+			}
+
+			//unset fields are written as well, so e.g. "success": false shows up in the response
+			jsonData, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(resp.(proto.Message))
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Error marshaling %s response: %v", name, err))
+			}
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+}
+
+// rpcErrorStatus maps the gRPC status of a failed call to an HTTP status code
+func rpcErrorStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted, codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusServerError
+	}
+}
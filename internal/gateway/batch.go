@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBatchLatency is the forward latency up to which the batch size grows if BatchLatency is not set
+const DefaultBatchLatency = 100 * time.Millisecond
+
+// batchController adapts how many queued messages a worker forwards in one request (AIMD): every forward the server
+// answered within the target latency grows the batch by one message, a slower one or a failed one (e.g. 503)
+// halves it, so the batch is large while the server keeps up and shrinks quickly once it pushes back
+type batchController struct {
+	mu      sync.Mutex
+	size    int
+	min     int
+	max     int
+	latency time.Duration //target latency of one forward
+}
+
+// batchControllerFactory creates a controller that starts at the smallest batch
+func batchControllerFactory(minSize, maxSize int, latency time.Duration) *batchController {
+	if latency <= 0 {
+		latency = DefaultBatchLatency
+	}
+	return &batchController{size: minSize, min: minSize, max: maxSize, latency: latency}
+}
+
+// current returns the number of messages the next batch may hold
+func (c *batchController) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// succeeded records a forward the server accepted after rtt
+func (c *batchController) succeeded(rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rtt > c.latency {
+		c.size = max(c.min, c.size/2)
+		return
+	}
+	c.size = min(c.max, c.size+1)
+}
+
+// failed records a forward the server did not accept because it was overloaded or unreachable
+func (c *batchController) failed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = max(c.min, c.size/2)
+}
+
+// popBatch waits for a message and returns up to limit queued messages, highest tier first; it returns false once
+// the queue is closed
+func (q *forwardQueue) popBatch(limit int) ([]forwardItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+
+	batch := make([]forwardItem, 0, min(limit, q.size))
+	for len(batch) < limit && q.size > 0 {
+		_, highest := q.tierRange()
+		batch = append(batch, q.tiers[highest][0])
+		q.remove(highest)
+	}
+	return batch, true
+}
@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+)
+
+// Config holds everything needed to run the gateway, as read from a YAML file by LoadConfig
+type Config struct {
+	ServerURL    string        `yaml:"serverUrl"`    //HTTP server the readings are forwarded to
+	ServerSocket string        `yaml:"serverSocket"` //Unix domain socket of the server, overrides ServerURL if set
+	MQTTBrokers  []string      `yaml:"mqttBrokers"`  //host:port or tcp://, ssl:// or tls:// URL of the brokers, the first one is preferred
+	MQTTTimeout  time.Duration `yaml:"mqttTimeout"`  //how long to wait for the broker to acknowledge a connect or subscribe
+
+	MQTTUsername string `yaml:"mqttUsername"` //empty connects anonymously
+	MQTTPassword string `yaml:"mqttPassword"`
+	MQTTCAFile   string `yaml:"mqttCaFile"`   //PEM CA the broker certificate is checked against, required for ssl:// and tls:// brokers
+	MQTTCertFile string `yaml:"mqttCertFile"` //PEM client certificate for brokers that require one, with mqttKeyFile
+	MQTTKeyFile  string `yaml:"mqttKeyFile"`
+
+	TopicTemplate string `yaml:"topicTemplate"` //topic the sensors publish to, e.g. site/{type}/{id}/telemetry; sensors/{type}/{id} if empty
+	TopicFilter   string `yaml:"topicFilter"`   //subscription filter, derived from topicTemplate if empty
+
+	ForwardQueueSize int            `yaml:"forwardQueueSize"` //messages buffered for forwarding, 0 forwards every message at once
+	ForwardWorkers   int            `yaml:"forwardWorkers"`   //forwards running at once when the queue is used
+	Priorities       map[string]int `yaml:"priorities"`       //priority tier per sensor type, higher is forwarded first and dropped last
+
+	BatchMin     int           `yaml:"batchMin"`     //fewest queued messages forwarded in one request
+	BatchMax     int           `yaml:"batchMax"`     //most queued messages forwarded in one request, 0 forwards every message on its own
+	BatchLatency time.Duration `yaml:"batchLatency"` //forward latency up to which the batch grows, above it or on 503 it halves
+
+	DeadLetterFile    string `yaml:"deadLetterFile"`    //JSONL file for readings that could not be forwarded, empty disables it
+	DeadLetterMaxSize int64  `yaml:"deadLetterMaxSize"` //size in bytes at which the dead-letter file is rotated to <file>.1
+}
+
+// DefaultConfig returns the configuration the gateway binary uses when neither a config file nor flags are given
+func DefaultConfig() Config {
+	return Config{
+		ServerURL:   "http://localhost:8080",
+		MQTTBrokers: []string{"localhost:1883"},
+		MQTTTimeout: DefaultMQTTTimeout,
+
+		ForwardWorkers: DefaultForwardWorkers,
+		BatchMin:       1,
+		BatchLatency:   DefaultBatchLatency,
+
+		DeadLetterMaxSize: DefaultDeadLetterMaxSize,
+	}
+}
+
+// LoadConfig reads a YAML config file; settings missing from the file keep their DefaultConfig value and unknown
+// settings are rejected so that a typo does not go unnoticed
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Validate checks that the config describes a runnable gateway
+func (c Config) Validate() error {
+	if c.ServerSocket == "" {
+		if !strings.HasPrefix(c.ServerURL, "http://") {
+			return fmt.Errorf("serverUrl %q must start with http:// (or set serverSocket)", c.ServerURL)
+		}
+	}
+	if len(c.MQTTBrokers) == 0 {
+		return fmt.Errorf("at least one MQTT broker is required")
+	}
+	if err := c.mqttSecurity().Validate(c.MQTTBrokers); err != nil {
+		return err
+	}
+	if _, err := c.topic(); err != nil {
+		return err
+	}
+	if c.MQTTTimeout <= 0 {
+		return fmt.Errorf("mqttTimeout must be positive, got %v", c.MQTTTimeout)
+	}
+	if c.ForwardQueueSize < 0 {
+		return fmt.Errorf("forwardQueueSize must not be negative, got %d", c.ForwardQueueSize)
+	}
+	if c.ForwardQueueSize > 0 && c.ForwardWorkers <= 0 {
+		return fmt.Errorf("forwardWorkers must be positive with a forward queue, got %d", c.ForwardWorkers)
+	}
+	if len(c.Priorities) > 0 && c.ForwardQueueSize == 0 {
+		return fmt.Errorf("priorities need a forward queue (forwardQueueSize > 0)")
+	}
+	if c.BatchMax < 0 {
+		return fmt.Errorf("batchMax must not be negative, got %d", c.BatchMax)
+	}
+	if c.BatchMax > 0 {
+		if c.ForwardQueueSize == 0 {
+			return fmt.Errorf("batching needs a forward queue (forwardQueueSize > 0)")
+		}
+		if c.BatchMin < 1 || c.BatchMin > c.BatchMax {
+			return fmt.Errorf("batchMin must be between 1 and batchMax (%d), got %d", c.BatchMax, c.BatchMin)
+		}
+		if c.BatchLatency <= 0 {
+			return fmt.Errorf("batchLatency must be positive, got %v", c.BatchLatency)
+		}
+	}
+	if c.DeadLetterFile != "" && c.DeadLetterMaxSize <= 0 {
+		return fmt.Errorf("deadLetterMaxSize must be positive with a dead-letter file, got %d", c.DeadLetterMaxSize)
+	}
+	return nil
+}
+
+// mqttSecurity returns the credentials and TLS settings for the brokers
+func (c Config) mqttSecurity() mqttconfig.Security {
+	return mqttconfig.Security{
+		Username: c.MQTTUsername,
+		Password: c.MQTTPassword,
+		CAFile:   c.MQTTCAFile,
+		CertFile: c.MQTTCertFile,
+		KeyFile:  c.MQTTKeyFile,
+	}
+}
+
+// topic parses the topic template and checks that the filter, if set, receives every topic of it
+func (c Config) topic() (mqttconfig.TopicTemplate, error) {
+	var topic mqttconfig.TopicTemplate
+	if c.TopicTemplate != "" {
+		var err error
+		if topic, err = mqttconfig.ParseTopicTemplate(c.TopicTemplate); err != nil {
+			return topic, err
+		}
+	}
+	if c.TopicFilter != "" {
+		if err := topic.CheckFilter(c.TopicFilter); err != nil {
+			return topic, err
+		}
+	}
+	return topic, nil
+}
+
+// SetServerAddress replaces host and port of ServerURL and clears ServerSocket, keeping the rest of the URL; an empty
+// host or port keeps the one of ServerURL, so e.g. -server-port changes only the port of the serverUrl of a config file
+func (c *Config) SetServerAddress(host, port string) error {
+	serverURL, err := url.Parse(c.ServerURL)
+	if err != nil {
+		return fmt.Errorf("invalid serverUrl %q: %w", c.ServerURL, err)
+	}
+	if serverURL.Scheme == "" {
+		serverURL.Scheme = "http"
+	}
+	if host == "" {
+		host = serverURL.Hostname()
+	}
+	if port == "" {
+		port = serverURL.Port()
+	}
+
+	if port == "" {
+		serverURL.Host = strings.TrimSuffix(net.JoinHostPort(host, ""), ":")
+	} else {
+		serverURL.Host = net.JoinHostPort(host, port)
+	}
+	c.ServerURL = serverURL.String()
+	c.ServerSocket = ""
+	return nil
+}
+
+// serverURL returns the URL the readings are forwarded to
+func (c Config) serverURL() string {
+	if c.ServerSocket != "" {
+		return http.UnixSocketURL(c.ServerSocket)
+	}
+	return c.ServerURL
+}
+
+// String returns the effective settings in one line, e.g. for the startup log
+func (c Config) String() string {
+	settings := fmt.Sprintf("server=%s brokers=%s mqttTimeout=%v", c.serverURL(), strings.Join(c.MQTTBrokers, ","), c.MQTTTimeout)
+	if c.MQTTUsername != "" {
+		settings += fmt.Sprintf(" mqttUser=%s", c.MQTTUsername) //never the password
+	}
+	if c.MQTTCAFile != "" {
+		settings += fmt.Sprintf(" mqttCa=%s", c.MQTTCAFile)
+	}
+	if c.TopicTemplate != "" || c.TopicFilter != "" {
+		topic, _ := c.topic()
+		filter := c.TopicFilter
+		if filter == "" {
+			filter = topic.Filter()
+		}
+		settings += fmt.Sprintf(" topic=%s filter=%s", topic, filter)
+	}
+	if c.ForwardQueueSize > 0 {
+		settings += fmt.Sprintf(" forwardQueue=%d workers=%d priorities=%v", c.ForwardQueueSize, c.ForwardWorkers, c.Priorities)
+	}
+	if c.BatchMax > 0 {
+		settings += fmt.Sprintf(" batch=%d-%d batchLatency=%v", c.BatchMin, c.BatchMax, c.BatchLatency)
+	}
+	if c.DeadLetterFile != "" {
+		settings += fmt.Sprintf(" deadLetters=%s maxSize=%d", c.DeadLetterFile, c.DeadLetterMaxSize)
+	}
+	return settings
+}
+
+// ConfigGatewayFactory validates the config and creates a gateway from it
+func ConfigGatewayFactory(config Config) (*Gateway, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid gateway config: %w", err)
+	}
+
+	topic, _ := config.topic() //checked by Validate
+
+	g := GatewayFactory(config.serverURL(), config.MQTTBrokers[0])
+	g.BackupBrokerURLs = config.MQTTBrokers[1:]
+	g.MQTTSecurity = config.mqttSecurity()
+	g.MQTTTimeout = config.MQTTTimeout
+	g.Topic = topic
+	g.TopicFilter = config.TopicFilter
+	g.ForwardQueueSize = config.ForwardQueueSize
+	g.ForwardWorkers = config.ForwardWorkers
+	g.Priorities = config.Priorities
+	g.BatchMin = config.BatchMin
+	g.BatchMax = config.BatchMax
+	g.BatchLatency = config.BatchLatency
+	g.DeadLetterPath = config.DeadLetterFile
+	g.DeadLetterMaxSize = config.DeadLetterMaxSize
+	return g, nil
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// DefaultDeadLetterMaxSize is the size in bytes at which the dead-letter file is rotated if DeadLetterMaxSize is not set
+const DefaultDeadLetterMaxSize = 10 * 1024 * 1024
+
+// DeadLetter is a reading the gateway failed to forward, one JSON line in the dead-letter file
+type DeadLetter struct {
+	Time    time.Time        `json:"time"` //when the gateway gave up on the reading
+	Topic   string           `json:"topic"`
+	Reason  string           `json:"reason"`
+	Reading types.SensorData `json:"reading"`
+	//the server may have stored the reading although the forward failed, replaying it could store it twice
+	PossiblyStored bool `json:"possiblyStored,omitempty"`
+}
+
+// deadLetterLog appends dead letters to a JSONL file. Once a write would grow the file beyond maxSize, the file is
+// renamed to <path>.1, replacing an older one, and a new file is started, so at most about twice maxSize is kept
+type deadLetterLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File //nil once closed
+	size    int64
+}
+
+// openDeadLetterLog opens the dead-letter file for appending, creating it if needed
+func openDeadLetterLog(path string, maxSize int64) (*deadLetterLog, error) {
+	l := &deadLetterLog{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at path for appending and takes over its current size
+func (l *deadLetterLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening dead-letter file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading dead-letter file: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// write appends the dead letters as JSON lines in one write, rotating the file first if they do not fit anymore
+func (l *deadLetterLog) write(letters []DeadLetter) error {
+	var lines []byte
+	for _, letter := range letters {
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return fmt.Errorf("error marshaling dead letter: %w", err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return errors.New("dead-letter file already closed")
+	}
+	if l.size > 0 && l.size+int64(len(lines)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(lines)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing dead-letter file: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the full file to <path>.1 and starts a new one
+func (l *deadLetterLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("error closing dead-letter file: %w", err)
+	}
+	l.file = nil
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("error rotating dead-letter file: %w", err)
+	}
+	return l.open()
+}
+
+// close closes the file, later writes fail
+func (l *deadLetterLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
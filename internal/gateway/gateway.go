@@ -0,0 +1,552 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// SensorTopic is the topic filter the gateway subscribes to by default, matching the mqttconfig.DefaultTopicTemplate
+// sensors/{type}/{id}
+const SensorTopic = "sensors/+/+"
+
+// DefaultMQTTTimeout is the default time the gateway waits for the broker to acknowledge a connect or subscribe
+const DefaultMQTTTimeout = 10 * time.Second
+
+// DefaultErrorBufferSize is how many failures the channel returned by Errors holds before further ones are dropped
+const DefaultErrorBufferSize = 100
+
+// ErrMalformedMessage and ErrForwardFailed classify the failures sent on the channel returned by Errors
+var (
+	ErrMalformedMessage = errors.New("malformed message")
+	ErrForwardFailed    = errors.New("forward failed")
+)
+
+// MQTTClientFactory creates an MQTT client from its options, like mqtt.NewClient
+type MQTTClientFactory func(*mqtt.ClientOptions) mqtt.Client
+
+// Gateway represents the IoT Gateway that receives data via MQTT and forwards via HTTP
+type Gateway struct {
+	ServerURL         string                   // HTTP server URL to forward data to
+	MQTTBrokerURL     string                   // MQTT broker URL
+	BackupBrokerURLs  []string                 // Further brokers the MQTT client fails over to, in order
+	MQTTSecurity      mqttconfig.Security      // Credentials and TLS settings for the brokers, anonymous plain TCP if zero
+	Topic             mqttconfig.TopicTemplate // Topic the sensors publish to, the sensor type is read from it; sensors/{type}/{id} if zero
+	TopicFilter       string                   // Subscription filter, Topic with + for its placeholders if empty; must match every topic of Topic
+	Client            *http.HttpClient         // HTTP client for forwarding data, Start creates one keeping a connection per forward worker if nil
+	MQTTClient        mqtt.Client              // MQTT client for receiving sensor data, created by Start
+	NewMQTTClient     MQTTClientFactory        // Creates the MQTT client from the options Start builds, mqtt.NewClient by default
+	StopChan          chan struct{}            // Closed when Stop begins, no new forwards are started afterwards
+	WaitGroup         sync.WaitGroup           // Tracks in-flight forwards so Stop can drain them
+	MessageCount      int64                    // Count of processed messages
+	MQTTTimeout       time.Duration            // How long to wait for the broker to acknowledge a connect or subscribe
+	ForwardQueueSize  int                      // Messages buffered for forwarding, 0 forwards every message at once on its own goroutine
+	ForwardWorkers    int                      // Forwards running at once when the queue is used, DefaultForwardWorkers if 0
+	Priorities        map[string]int           // Priority tier per sensor type (topic segment), higher is forwarded first; unlisted types are tier 0
+	DroppedCount      int64                    // Count of messages dropped because the forward queue was full
+	DeadLetterPath    string                   // JSONL file the readings that could not be forwarded are appended to, empty disables it
+	DeadLetterMaxSize int64                    // Size in bytes at which the dead-letter file is rotated, DefaultDeadLetterMaxSize if 0
+	DeadLetteredCount int64                    // Count of readings written to the dead-letter file
+	BatchMin          int                      // Fewest queued messages forwarded in one request when batching, 1 if 0
+	BatchMax          int                      // Most queued messages forwarded in one request, 0 forwards every message on its own
+	BatchLatency      time.Duration            // Forward latency up to which the batch grows, DefaultBatchLatency if 0
+	queue             *forwardQueue            // Set by Start if ForwardQueueSize is positive
+	batches           *batchController         // Set by Start if the queue is used and BatchMax is positive
+	deadLetters       *deadLetterLog           // Set by Start if DeadLetterPath is set
+	failures          chan error               // Parse and forward failures returned by Errors, dropped while it is full
+	mutex             sync.Mutex               // Protects the counts and the WaitGroup against a concurrent Stop
+}
+
+// GatewayFactory creates a new IoT Gateway
+func GatewayFactory(serverURL, mqttBrokerURL string) *Gateway {
+	return &Gateway{
+		ServerURL:     serverURL,
+		MQTTBrokerURL: mqttBrokerURL,
+		StopChan:      make(chan struct{}),
+		MessageCount:  0,
+		MQTTTimeout:   DefaultMQTTTimeout,
+		NewMQTTClient: mqtt.NewClient,
+		failures:      make(chan error, DefaultErrorBufferSize),
+	}
+}
+
+// Start starts the IoT Gateway and returns once it is connected to the broker and subscribed to the sensor topics
+func (g *Gateway) Start() error {
+	log.Printf("Starting IoT Gateway")
+	log.Printf("HTTP Server: %s", g.ServerURL)
+	if err := g.Topic.CheckFilter(g.subscriptionFilter()); err != nil {
+		return fmt.Errorf("invalid MQTT topic settings: %w", err)
+	}
+	if err := g.openDeadLetters(); err != nil {
+		return err
+	}
+	if g.Client == nil {
+		//every worker forwards on a persistent connection of its own instead of opening one per message
+		g.Client = http.HttpClientFactory(5*time.Second, http.WithConnectionPool(g.forwardWorkers()))
+	}
+	g.startForwardQueue()
+
+	log.Printf("MQTT Broker: %s", g.MQTTBrokerURL)
+
+	opts := mqtt.NewClientOptions()
+	if err := g.MQTTSecurity.Apply(opts, append([]string{g.MQTTBrokerURL}, g.BackupBrokerURLs...)); err != nil {
+		return fmt.Errorf("invalid MQTT settings: %w", err)
+	}
+	opts.SetClientID("iot-gateway")
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(true)
+	opts.SetKeepAlive(60 * time.Second)
+	opts.SetPingTimeout(10 * time.Second)
+
+	// Connection handlers
+	subscribed := make(chan error, 1)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Println("Gateway connected to MQTT broker")
+
+		err := g.subscribeToTopics(client)
+		select {
+		case subscribed <- err: //the first connect, Start returns the error
+		default:
+			//a reconnect runs on the client's goroutine, so a failed subscribe is only logged; the broker delivers
+			//nothing until the next reconnect
+			if err != nil {
+				log.Printf("Gateway is connected but receives no sensor data: %v", err)
+			}
+		}
+	})
+
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("Gateway lost connection to MQTT broker: %v", err)
+	})
+
+	g.MQTTClient = g.NewMQTTClient(opts)
+
+	//connect to MQTT broker, the connect handler subscribes once the broker acknowledged it
+	if err := g.connect(); err != nil {
+		return err
+	}
+	if err := <-subscribed; err != nil {
+		return err
+	}
+
+	log.Println("Gateway started successfully")
+	return nil
+}
+
+// openDeadLetters opens the dead-letter file if DeadLetterPath is set
+func (g *Gateway) openDeadLetters() error {
+	if g.DeadLetterPath == "" {
+		return nil
+	}
+
+	maxSize := g.DeadLetterMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultDeadLetterMaxSize
+	}
+	deadLetters, err := openDeadLetterLog(g.DeadLetterPath, maxSize)
+	if err != nil {
+		return err
+	}
+	log.Printf("Dead letters: %s (rotated at %d bytes)", g.DeadLetterPath, maxSize)
+	g.deadLetters = deadLetters
+	return nil
+}
+
+// deadLetter records the readings of a message the gateway gave up on in the dead-letter file, if there is one
+func (g *Gateway) deadLetter(topic string, readings []types.SensorData, reason string, possiblyStored bool) {
+	if g.deadLetters == nil {
+		return
+	}
+
+	now := time.Now()
+	letters := make([]DeadLetter, len(readings))
+	for i, reading := range readings {
+		letters[i] = DeadLetter{Time: now, Topic: topic, Reason: reason, Reading: reading, PossiblyStored: possiblyStored}
+	}
+	if err := g.deadLetters.write(letters); err != nil {
+		logging.Warnf("Error writing %d reading(s) from topic %s to the dead-letter file: %v", len(readings), topic, err)
+		return
+	}
+
+	g.mutex.Lock()
+	g.DeadLetteredCount += int64(len(readings))
+	g.mutex.Unlock()
+}
+
+// startForwardQueue creates the forward queue and starts its workers if ForwardQueueSize is positive
+func (g *Gateway) startForwardQueue() {
+	if g.ForwardQueueSize <= 0 {
+		return
+	}
+
+	workers := g.forwardWorkers()
+	log.Printf("Forward queue: %d messages, %d workers, priorities %v", g.ForwardQueueSize, workers, g.Priorities)
+
+	g.queue = forwardQueueFactory(g.ForwardQueueSize)
+	if g.BatchMax > 0 {
+		batchMin := max(g.BatchMin, 1)
+		g.batches = batchControllerFactory(batchMin, max(g.BatchMax, batchMin), g.BatchLatency)
+		log.Printf("Forward batches: %d to %d messages, growing up to %v latency", batchMin, max(g.BatchMax, batchMin), g.batches.latency)
+	}
+
+	g.WaitGroup.Add(workers)
+	for range workers {
+		go func() {
+			defer g.WaitGroup.Done()
+			for {
+				if g.batches != nil {
+					items, ok := g.queue.popBatch(g.batches.current())
+					if !ok {
+						return
+					}
+					g.forwardBatch(items)
+					continue
+				}
+
+				item, ok := g.queue.pop()
+				if !ok {
+					return
+				}
+				g.forward(item.topic, item.readings)
+			}
+		}()
+	}
+}
+
+// forwardWorkers returns the number of forwards the queue runs at once
+func (g *Gateway) forwardWorkers() int {
+	if g.ForwardWorkers <= 0 {
+		return DefaultForwardWorkers
+	}
+	return g.ForwardWorkers
+}
+
+// connect connects the MQTT client, giving up if the broker does not acknowledge within MQTTTimeout
+func (g *Gateway) connect() error {
+	token := g.MQTTClient.Connect()
+	if !token.WaitTimeout(g.MQTTTimeout) {
+		return fmt.Errorf("failed to connect to MQTT broker: no acknowledgement within %v", g.MQTTTimeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	return nil
+}
+
+// subscriptionFilter returns the topic filter the gateway subscribes to, see TopicFilter
+func (g *Gateway) subscriptionFilter() string {
+	if g.TopicFilter != "" {
+		return g.TopicFilter
+	}
+	return g.Topic.Filter()
+}
+
+// sensorType returns the sensor type of a topic of the gateway's Topic template, or "" for any other topic, e.g. one
+// matched by a wider TopicFilter
+func (g *Gateway) sensorType(topic string) string {
+	sensorType, _, _ := g.Topic.Parse(topic)
+	return sensorType
+}
+
+// subscribeToTopics subscribes to all sensor topics
+func (g *Gateway) subscribeToTopics(client mqtt.Client) error {
+	//subscribe to all sensor topics using wildcard
+	topic := g.subscriptionFilter()
+
+	token := client.Subscribe(topic, 0, g.messageHandler)
+	if !token.WaitTimeout(g.MQTTTimeout) {
+		return fmt.Errorf("subscribe to topic %s not acknowledged within %v", topic, g.MQTTTimeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to topic %s: %w", topic, token.Error())
+	}
+
+	log.Printf("Successfully subscribed to topic: %s", topic)
+	return nil
+}
+
+// messageHandler handles incoming MQTT messages
+func (g *Gateway) messageHandler(client mqtt.Client, msg mqtt.Message) {
+	logging.Debugf("Received message from topic %s", msg.Topic())
+
+	//a message carries either a single reading or a burst of readings as a JSON array
+	readings, err := types.DecodeSensorDataList(msg.Payload())
+	if err != nil {
+		logging.Warnf("Error parsing sensor data from topic %s: %v", msg.Topic(), err)
+		g.reportError(fmt.Errorf("%w from topic %s: %w", ErrMalformedMessage, msg.Topic(), err))
+		return
+	}
+	if len(readings) == 0 {
+		log.Printf("Ignoring empty burst from topic %s", msg.Topic())
+		return
+	}
+
+	//messages still delivered after Stop began are dropped; registering under the mutex keeps
+	//the WaitGroup from growing while Stop waits on it
+	g.mutex.Lock()
+	select {
+	case <-g.StopChan:
+		g.mutex.Unlock()
+		log.Printf("Gateway stopping, dropping message from topic %s", msg.Topic())
+		g.deadLetter(msg.Topic(), readings, "gateway stopping", false)
+		return
+	default:
+	}
+
+	//with a queue the workers forward the message; under backpressure the lowest tier is dropped first. Pushing
+	//under the mutex keeps Stop from closing the queue in between, so a drop here always means the queue was full
+	if g.queue != nil {
+		item := forwardItem{topic: msg.Topic(), readings: readings}
+		dropped := g.queue.push(item, g.Priorities[g.sensorType(msg.Topic())])
+		if dropped != nil {
+			g.DroppedCount++
+		}
+		g.mutex.Unlock()
+
+		if dropped != nil {
+			logging.Warnf("Forward queue full, dropping message from topic %s", dropped.topic)
+			g.deadLetter(dropped.topic, dropped.readings, "forward queue full", false)
+		}
+		return
+	}
+
+	g.WaitGroup.Add(1)
+	g.mutex.Unlock()
+
+	//forward data to HTTP server
+	go func() {
+		defer g.WaitGroup.Done()
+
+		//a forward that has not started yet when Stop begins is not attempted anymore
+		select {
+		case <-g.StopChan:
+			log.Printf("Gateway stopping, dropping message from topic %s", msg.Topic())
+			g.deadLetter(msg.Topic(), readings, "gateway stopping", false)
+			return
+		default:
+		}
+
+		g.forward(msg.Topic(), readings)
+	}()
+}
+
+// forward forwards the readings of one message and counts it if the server accepted it
+func (g *Gateway) forward(topic string, readings []types.SensorData) {
+	sensorID := readings[0].SensorID
+	startTime := time.Now()
+	if err := g.forwardData(readings); err != nil {
+		g.forwardFailed(topic, readings, err)
+		return
+	}
+
+	rtt := time.Since(startTime)
+	logging.Debugf("Successfully forwarded %d reading(s) from %s (RTT: %v)", len(readings), sensorID, rtt)
+	g.countForwarded(1)
+}
+
+// forwardBatch forwards the readings of several queued messages in one request and adapts the batch size to the
+// server's latency. Since the server stores a request all or nothing, a batch it rejects is forwarded again message by
+// message, so one invalid reading does not take the others with it
+func (g *Gateway) forwardBatch(items []forwardItem) {
+	var readings []types.SensorData
+	for _, item := range items {
+		readings = append(readings, item.readings...)
+	}
+
+	startTime := time.Now()
+	err := g.forwardData(readings)
+	rtt := time.Since(startTime)
+
+	var statusErr *forwardStatusError
+	switch {
+	case err == nil:
+		g.batches.succeeded(rtt)
+		logging.Debugf("Successfully forwarded %d message(s) with %d reading(s) (RTT: %v)", len(items), len(readings), rtt)
+		g.countForwarded(len(items))
+	case errors.As(err, &statusErr) && statusErr.rejected() && len(items) > 1:
+		logging.Debugf("Server rejected a batch of %d messages, forwarding them one by one: %v", len(items), err)
+		for _, item := range items {
+			g.forward(item.topic, item.readings)
+		}
+	default:
+		if statusErr == nil || !statusErr.rejected() {
+			g.batches.failed()
+		}
+		for _, item := range items {
+			g.forwardFailed(item.topic, item.readings, err)
+		}
+	}
+}
+
+// forwardFailed logs, reports and dead-letters the readings of a message the server did not accept
+func (g *Gateway) forwardFailed(topic string, readings []types.SensorData, err error) {
+	sensorID := readings[0].SensorID
+	logging.Warnf("Error forwarding data from sensor %s: %v", sensorID, err)
+	g.reportError(fmt.Errorf("%w for %d reading(s) of sensor %s from topic %s: %w", ErrForwardFailed, len(readings), sensorID, topic, err))
+	g.deadLetter(topic, readings, err.Error(), possiblyStored(err))
+}
+
+// possiblyStored reports whether the server may have stored the readings of a failed forward: it answers a commit
+// that reached only some databases with a 500, and a response lost after the request was sent tells nothing. A 4xx
+// and a 503 are only sent when nothing was stored, and after ErrConnect the request never arrived
+func possiblyStored(err error) bool {
+	var statusErr *forwardStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 && statusErr.StatusCode != http.StatusServiceUnavailable
+	}
+	return errors.Is(err, http.ErrReadBody) || errors.Is(err, http.ErrParse)
+}
+
+// countForwarded counts messages the server accepted
+func (g *Gateway) countForwarded(messages int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for range messages {
+		g.MessageCount++
+		if g.MessageCount%100 == 0 {
+			log.Printf("Processed %d messages", g.MessageCount)
+		}
+	}
+}
+
+// forwardStatusError is returned by forwardData when the server answered with another status than 200
+type forwardStatusError struct {
+	StatusCode int
+	StatusText string
+}
+
+func (e *forwardStatusError) Error() string {
+	return fmt.Sprintf("server returned non-OK status: %d %s", e.StatusCode, e.StatusText)
+}
+
+// rejected reports whether the server refused the readings themselves (4xx) rather than being unable to store them
+func (e *forwardStatusError) rejected() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// forwardData forwards sensor data to the HTTP server; bursts are forwarded as a single JSON array request
+func (g *Gateway) forwardData(readings []types.SensorData) error {
+	var payload any = readings
+	if len(readings) == 1 {
+		payload = readings[0]
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling data to JSON: %w", err)
+	}
+
+	resp, err := g.Client.PostJSON(g.ServerURL+"/data", jsonData)
+	if err != nil {
+		return fmt.Errorf("error sending data to server: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &forwardStatusError{StatusCode: resp.StatusCode, StatusText: resp.StatusText}
+	}
+
+	return nil
+}
+
+// Stop stops the IoT Gateway
+func (g *Gateway) Stop() {
+	log.Println("Stopping IoT Gateway...")
+
+	//stop new messages from arriving before draining
+	if g.MQTTClient != nil && g.MQTTClient.IsConnected() {
+		if token := g.MQTTClient.Unsubscribe(g.subscriptionFilter()); token.WaitTimeout(g.MQTTTimeout) && token.Error() != nil {
+			log.Printf("Failed to unsubscribe from topic %s: %v", g.subscriptionFilter(), token.Error())
+		}
+	}
+
+	//signal all goroutines to stop, messages that were already queued are dropped from now on
+	g.mutex.Lock()
+	close(g.StopChan)
+	g.mutex.Unlock()
+	if g.queue != nil {
+		if pending := g.queue.close(); len(pending) > 0 {
+			log.Printf("Gateway stopping, dropping %d queued messages", len(pending))
+			for _, item := range pending {
+				g.deadLetter(item.topic, item.readings, "gateway stopping", false)
+			}
+		}
+	}
+
+	//wait for the in-flight forwards to complete
+	g.WaitGroup.Wait()
+	if g.Client != nil {
+		g.Client.CloseIdleConnections()
+	}
+
+	if g.deadLetters != nil {
+		if err := g.deadLetters.close(); err != nil {
+			log.Printf("Error closing dead-letter file: %v", err)
+		}
+	}
+
+	//disconn from MQTT broker
+	if g.MQTTClient != nil && g.MQTTClient.IsConnected() {
+		g.MQTTClient.Disconnect(250)
+		log.Println("Disconnected from MQTT broker")
+	}
+
+	g.mutex.Lock()
+	finalCount := g.MessageCount
+	g.mutex.Unlock()
+
+	log.Printf("IoT Gateway stopped. Total messages processed: %d", finalCount)
+}
+
+// Errors returns a channel of the messages that could not be parsed (ErrMalformedMessage) and the forwards that failed
+// (ErrForwardFailed), e.g. to count or alert on them. It is never closed; while nobody drains it, failures beyond
+// DefaultErrorBufferSize are dropped instead of blocking the message handler
+func (g *Gateway) Errors() <-chan error {
+	return g.failures
+}
+
+// reportError sends a failure to the channel returned by Errors, or drops it if the channel is full
+func (g *Gateway) reportError(err error) {
+	select {
+	case g.failures <- err:
+	default:
+	}
+}
+
+// GetDroppedCount returns the number of messages dropped because the forward queue was full (thread-safe)
+func (g *Gateway) GetDroppedCount() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.DroppedCount
+}
+
+// GetDeadLetteredCount returns the number of readings written to the dead-letter file (thread-safe)
+func (g *Gateway) GetDeadLetteredCount() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.DeadLetteredCount
+}
+
+// GetBatchSize returns the number of queued messages the next batch may hold, 0 if batching is off (thread-safe)
+func (g *Gateway) GetBatchSize() int {
+	if g.batches == nil {
+		return 0
+	}
+	return g.batches.current()
+}
+
+// GetMessageCount returns the current message count (thread-safe)
+func (g *Gateway) GetMessageCount() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.MessageCount
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"sync"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// DefaultForwardWorkers is the number of forwards the queue runs at once if ForwardWorkers is not set
+const DefaultForwardWorkers = 4
+
+// forwardItem is one MQTT message waiting in the forward queue
+type forwardItem struct {
+	topic    string
+	readings []types.SensorData
+}
+
+// forwardQueue is a bounded queue with one FIFO per priority tier: the highest tier is forwarded first, and when the
+// queue is full the oldest message of the lowest tier is dropped to make room, unless the new message is of an even
+// lower tier, then the new message is dropped
+type forwardQueue struct {
+	mu       sync.Mutex
+	ready    *sync.Cond
+	tiers    map[int][]forwardItem
+	size     int
+	capacity int
+	closed   bool
+}
+
+// forwardQueueFactory creates a queue holding at most capacity messages
+func forwardQueueFactory(capacity int) *forwardQueue {
+	q := &forwardQueue{tiers: make(map[int][]forwardItem), capacity: capacity}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues a message of the given tier and returns the message dropped to make room, if any; after close every
+// message is dropped
+func (q *forwardQueue) push(item forwardItem, tier int) (dropped *forwardItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return &item
+	}
+
+	if q.size == q.capacity {
+		lowest, _ := q.tierRange()
+		if tier < lowest {
+			return &item
+		}
+
+		//the oldest message of the lowest tier is the least useful one: stale and not critical
+		oldest := q.tiers[lowest][0]
+		q.remove(lowest)
+		dropped = &oldest
+	}
+
+	q.tiers[tier] = append(q.tiers[tier], item)
+	q.size++
+	q.ready.Signal()
+	return dropped
+}
+
+// pop waits for a message and returns the oldest one of the highest tier; it returns false once the queue is closed
+func (q *forwardQueue) pop() (forwardItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	if q.closed {
+		return forwardItem{}, false
+	}
+
+	_, highest := q.tierRange()
+	item := q.tiers[highest][0]
+	q.remove(highest)
+	return item, true
+}
+
+// close wakes up all waiting pops and returns the messages that were still queued, which are dropped
+func (q *forwardQueue) close() []forwardItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	pending := make([]forwardItem, 0, q.size)
+	for _, items := range q.tiers {
+		pending = append(pending, items...)
+	}
+	q.tiers = make(map[int][]forwardItem)
+	q.size = 0
+	q.ready.Broadcast()
+	return pending
+}
+
+// len returns the number of queued messages
+func (q *forwardQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// tierRange returns the lowest and highest tier that hold a message; the queue must not be empty
+func (q *forwardQueue) tierRange() (lowest, highest int) {
+	first := true
+	for tier := range q.tiers {
+		if first || tier < lowest {
+			lowest = tier
+		}
+		if first || tier > highest {
+			highest = tier
+		}
+		first = false
+	}
+	return lowest, highest
+}
+
+// remove removes the oldest message of a tier, dropping the tier once it is empty
+func (q *forwardQueue) remove(tier int) {
+	if len(q.tiers[tier]) == 1 {
+		delete(q.tiers, tier)
+	} else {
+		q.tiers[tier] = q.tiers[tier][1:]
+	}
+	q.size--
+}
@@ -0,0 +1,130 @@
+package sensor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Seasonality is a periodic swing of the value, e.g. temperature rising during the "day": the offset starts at
+// -Amplitude ("night"), peaks at +Amplitude after half the period and is back at -Amplitude after the full period
+type Seasonality struct {
+	Period    time.Duration `yaml:"period"`
+	Amplitude float64       `yaml:"amplitude"` //in the unit of the sensor
+}
+
+// Anomaly is a scheduled window in which the value jumps by Magnitude and then recovers linearly until the window ends
+type Anomaly struct {
+	Start     time.Duration `yaml:"start"` //offset from the start of the simulation
+	Duration  time.Duration `yaml:"duration"`
+	Magnitude float64       `yaml:"magnitude"` //in the unit of the sensor, negative for a sudden drop
+}
+
+// Profile shapes the values of a sensor on top of its drifting base value: the seasonality shifts the base before
+// the noise is added, the anomalies are added after it. The zero value changes nothing
+type Profile struct {
+	Seasonality *Seasonality `yaml:"seasonality"`
+	Anomalies   []Anomaly    `yaml:"anomalies"`
+}
+
+// SeasonalOffset returns the seasonality offset at the given time since the start of the simulation
+func (p *Profile) SeasonalOffset(elapsed time.Duration) float64 {
+	if p == nil || p.Seasonality == nil {
+		return 0
+	}
+	phase := 2 * math.Pi * float64(elapsed%p.Seasonality.Period) / float64(p.Seasonality.Period)
+	return -p.Seasonality.Amplitude * math.Cos(phase)
+}
+
+// AnomalyOffset returns the summed offset of all anomaly windows active at the given time since the start of the
+// simulation
+func (p *Profile) AnomalyOffset(elapsed time.Duration) float64 {
+	if p == nil {
+		return 0
+	}
+
+	offset := 0.0
+	for _, anomaly := range p.Anomalies {
+		if anomaly.active(elapsed) {
+			//full magnitude at the start of the window, back to normal at its end
+			recovered := float64(elapsed-anomaly.Start) / float64(anomaly.Duration)
+			offset += anomaly.Magnitude * (1 - recovered)
+		}
+	}
+	return offset
+}
+
+// ActiveAnomaly returns the index of the first anomaly window active at the given time, -1 if there is none
+func (p *Profile) ActiveAnomaly(elapsed time.Duration) int {
+	if p == nil {
+		return -1
+	}
+	for i, anomaly := range p.Anomalies {
+		if anomaly.active(elapsed) {
+			return i
+		}
+	}
+	return -1
+}
+
+// active reports whether the window covers the given time since the start of the simulation
+func (a Anomaly) active(elapsed time.Duration) bool {
+	return elapsed >= a.Start && elapsed < a.Start+a.Duration
+}
+
+// Validate checks that the seasonality has a period and every anomaly window a start and length that make sense
+func (p Profile) Validate() error {
+	if p.Seasonality != nil && p.Seasonality.Period <= 0 {
+		return fmt.Errorf("seasonality period must be positive, got %v", p.Seasonality.Period)
+	}
+	for i, anomaly := range p.Anomalies {
+		if anomaly.Start < 0 {
+			return fmt.Errorf("anomaly %d: start must not be negative, got %v", i, anomaly.Start)
+		}
+		if anomaly.Duration <= 0 {
+			return fmt.Errorf("anomaly %d: duration must be positive, got %v", i, anomaly.Duration)
+		}
+	}
+	return nil
+}
+
+// LoadProfiles reads the value profiles from a YAML file, keyed by sensor ID (e.g. temp-1) or by sensor type (e.g.
+// temp) for all instances of the type without their own profile. Unknown settings are rejected so that a typo does
+// not go unnoticed
+func LoadProfiles(path string) (map[string]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile file: %w", err)
+	}
+
+	profiles := make(map[string]Profile)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&profiles); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing profile file %s: %w", path, err)
+	}
+
+	for key, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", key, err)
+		}
+	}
+	return profiles, nil
+}
+
+// profileFor returns the profile of a sensor: its own one if configured, else the one of its type, else nil
+func profileFor(profiles map[string]Profile, sensorType, sensorID string) *Profile {
+	if profile, ok := profiles[sensorID]; ok {
+		return &profile
+	}
+	if profile, ok := profiles[sensorType]; ok {
+		return &profile
+	}
+	return nil
+}
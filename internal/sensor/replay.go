@@ -0,0 +1,180 @@
+package sensor
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultReplayConnectTimeout is how long the source database of a replay gets to answer before the replay fails
+const DefaultReplayConnectTimeout = 5 * time.Second
+
+// Replayer reads the readings stored in a database and publishes them again through MQTT, one message per reading
+// on the topic of its sensor, so stored data runs through the gateway and server once more, e.g. for soak tests
+type Replayer struct {
+	BrokerURL      string                   //host:port or tcp://, ssl:// or tls:// URL of the broker
+	MQTTSecurity   mqttconfig.Security      //credentials and TLS settings for the broker, anonymous plain TCP if zero
+	Topic          mqttconfig.TopicTemplate //topic the readings are published to, sensors/{type}/{id} if zero
+	DatabaseAddr   string                   //address of the database the readings are read from
+	Prefix         string                   //replay only sensor IDs starting with the prefix, all if empty
+	TimeRange      types.TimeRange          //replay only readings within the range, open if zero
+	Rate           float64                  //readings published per second, 0 = as fast as the broker acknowledges them
+	ConnectTimeout time.Duration            //how long the source database gets to answer before the replay fails
+	MQTTTimeout    time.Duration            //how long to wait for the broker to acknowledge a connect or publish
+	NewMQTTClient  func(*mqtt.ClientOptions) mqtt.Client
+}
+
+// ReplayResult counts the readings of a finished replay
+type ReplayResult struct {
+	Read      int //readings read from the database within the prefix and time range
+	Published int //readings acknowledged by the broker
+	Failed    int //readings whose publish failed or timed out, they are not retried
+}
+
+// NewReplayer creates a replayer of all readings of a database at the highest possible rate
+func NewReplayer(brokerURL, databaseAddr string) *Replayer {
+	return &Replayer{
+		BrokerURL:      brokerURL,
+		DatabaseAddr:   databaseAddr,
+		ConnectTimeout: DefaultReplayConnectTimeout,
+		MQTTTimeout:    DefaultMQTTTimeout,
+		NewMQTTClient:  mqtt.NewClient,
+	}
+}
+
+// Run reads the readings from the database and publishes them in the order of their timestamps until all are
+// published or stop is closed. It fails if the database is not reachable or the broker does not accept the connection
+func (r *Replayer) Run(stop <-chan struct{}) (ReplayResult, error) {
+	var result ReplayResult
+
+	//a broker that cannot work is reported before the database is read
+	if err := r.MQTTSecurity.Validate([]string{r.BrokerURL}); err != nil {
+		return result, fmt.Errorf("invalid MQTT settings: %w", err)
+	}
+
+	readings, err := r.readReadings()
+	if err != nil {
+		return result, err
+	}
+	result.Read = len(readings)
+	log.Printf("Replaying %d readings from %s", len(readings), r.DatabaseAddr)
+
+	client, err := r.connect()
+	if err != nil {
+		return result, err
+	}
+	defer client.Disconnect(250)
+
+	var pace <-chan time.Time
+	if r.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.Rate))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	//one simulator per sensor, only used for its topic and publishData
+	simulators := make(map[string]*SensorSimulator)
+	for _, reading := range readings {
+		if pace != nil {
+			select {
+			case <-stop:
+				return result, nil
+			case <-pace:
+			}
+		} else {
+			select {
+			case <-stop:
+				return result, nil
+			default:
+			}
+		}
+
+		simulator, ok := simulators[reading.SensorID]
+		if !ok {
+			simulator = &SensorSimulator{
+				SensorType:  types.Sensor{ID: replaySensorType(reading.SensorID), Unit: reading.Unit},
+				SensorID:    reading.SensorID,
+				MQTTClient:  client,
+				Topic:       r.Topic,
+				MQTTTimeout: r.MQTTTimeout,
+			}
+			simulators[reading.SensorID] = simulator
+		}
+
+		//the database sets the ingestion time again, a sensor never sends one
+		reading.IngestedAt = time.Time{}
+		if err := simulator.publishData([]types.SensorData{reading}); err != nil {
+			logging.Warnf("Error replaying reading of sensor %s: %v", reading.SensorID, err)
+			result.Failed++
+			continue
+		}
+		result.Published++
+	}
+	return result, nil
+}
+
+// readReadings checks that the database is reachable and returns its readings within the prefix and time range,
+// ordered by timestamp
+func (r *Replayer) readReadings() ([]types.SensorData, error) {
+	client, err := database.ClientFactory(r.DatabaseAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database %s: %w", r.DatabaseAddr, err)
+	}
+	defer client.Close()
+
+	if err := client.Ping(r.ConnectTimeout); err != nil {
+		return nil, fmt.Errorf("database %s: %w", r.DatabaseAddr, err)
+	}
+
+	var readings []types.SensorData
+	if r.Prefix != "" {
+		readings, err = client.GetDataPointsByPrefix(r.Prefix)
+	} else {
+		readings, err = client.GetAllDataPoints()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read readings from database %s: %w", r.DatabaseAddr, err)
+	}
+
+	readings = r.TimeRange.Filter(readings)
+	slices.SortStableFunc(readings, func(a, b types.SensorData) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return readings, nil
+}
+
+// connect connects the replay's MQTT client to the broker
+func (r *Replayer) connect() (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions()
+	if err := r.MQTTSecurity.Apply(opts, []string{r.BrokerURL}); err != nil {
+		return nil, fmt.Errorf("invalid MQTT settings: %w", err)
+	}
+	opts.SetClientID(fmt.Sprintf("sensor-replay-%d", time.Now().UnixNano()))
+	opts.SetCleanSession(true)
+
+	client := r.NewMQTTClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(r.MQTTTimeout) {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: no acknowledgement within %v", r.MQTTTimeout)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	return client, nil
+}
+
+// replaySensorType returns the sensor type of a sensor ID as the simulator names them, e.g. temp for temp-1
+func replaySensorType(sensorID string) string {
+	if i := strings.LastIndex(sensorID, "-"); i > 0 {
+		return sensorID[:i]
+	}
+	return sensorID
+}
@@ -0,0 +1,493 @@
+package sensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultMQTTTimeout is how long a sensor waits for the broker to acknowledge a connect, subscribe or publish
+const DefaultMQTTTimeout = 10 * time.Second
+
+// maxBacklog is the number of readings a sensor keeps for the next tick when publishes time out, older ones are dropped
+const maxBacklog = 100
+
+// errPublishTimeout is returned by publishData when the broker does not acknowledge a publish in time
+var errPublishTimeout = errors.New("publish not acknowledged in time")
+
+// SensorSimulator represents a single sensor that publishes data to MQTT
+type SensorSimulator struct {
+	SensorType  types.Sensor
+	SensorID    string
+	MQTTClient  mqtt.Client
+	Topic       mqttconfig.TopicTemplate //topic the readings are published to, sensors/{type}/{id} if zero
+	Burst       int                      //number of readings published per tick (1 = single object)
+	Jitter      float64                  //fraction of the interval each tick may deviate by (0 = lockstep ticks)
+	Rand        *rand.Rand
+	StopChan    chan struct{}
+	IntervalCh  chan time.Duration //new publish intervals received on the control topic, applied by the Start loop
+	MQTTTimeout time.Duration      //how long to wait for the broker to acknowledge a subscribe or publish
+	Backlog     []types.SensorData //readings whose publish timed out, sent again with the next tick
+	Profile     *Profile           //seasonality and anomalies added to the generated values, nil for none
+	WaitGroup   *sync.WaitGroup
+	stats       simulatorStats
+
+	startedAt     time.Time //start of the simulation, the time the profile is evaluated against
+	activeAnomaly int       //index of the anomaly window active at the last tick, -1 for none
+}
+
+// SensorManager manages multiple sensor simulators
+type SensorManager struct {
+	BrokerURL      string                   //host:port or tcp://, ssl:// or tls:// URL of the broker
+	MQTTSecurity   mqttconfig.Security      //credentials and TLS settings for the broker, anonymous plain TCP if zero
+	Topic          mqttconfig.TopicTemplate //topic every sensor publishes to, sensors/{type}/{id} if zero
+	Sensors        []types.Sensor
+	SensorsPerType int
+	Duration       int
+	Burst          int
+	Jitter         float64
+	Seed           int64
+	Control        bool               //subscribe every sensor to control/<sensorID>/interval
+	MQTTTimeout    time.Duration      //how long to wait for the broker to acknowledge a connect, subscribe or publish
+	Precision      int                //decimal places for all sensors, overriding the per-type precision; negative keeps the per-type one
+	StatsInterval  time.Duration      //how often to log the publish statistics of all sensors, 0 = only once on Stop
+	Profiles       map[string]Profile //value profiles by sensor ID or sensor type, see LoadProfiles
+	NewMQTTClient  func(*mqtt.ClientOptions) mqtt.Client
+	Simulators     []*SensorSimulator
+	WaitGroup      sync.WaitGroup
+	stopStats      chan struct{}
+	stopOnce       sync.Once
+}
+
+// decimals returns n as the precision of a sensor type
+func decimals(n int) *int {
+	return &n
+}
+
+var sensors = []types.Sensor{
+	{
+		ID:                     "temp",
+		Name:                   "Temperature Sensor",
+		MinValue:               -40.0,
+		MaxValue:               130.0,
+		Unit:                   "°C",
+		NoiseLevel:             0.05,
+		DataGenerationInterval: 1000,
+		Precision:              decimals(1),
+	},
+	{
+		ID:                     "humid",
+		Name:                   "Humidity Sensor",
+		MinValue:               30.0,
+		MaxValue:               80.0,
+		Unit:                   "%",
+		NoiseLevel:             0.05,
+		DataGenerationInterval: 500,
+		Precision:              decimals(1),
+	},
+	{
+		ID:                     "press",
+		Name:                   "Pressure Sensor",
+		MinValue:               980.0,
+		MaxValue:               1020.0,
+		Unit:                   "hPa",
+		NoiseLevel:             0.01,
+		DataGenerationInterval: 2000,
+		Precision:              decimals(1),
+	},
+	{
+		ID:                     "light",
+		Name:                   "Light Sensor",
+		MinValue:               0.0,
+		MaxValue:               1000.0,
+		Unit:                   "cd",
+		NoiseLevel:             0.10,
+		DataGenerationInterval: 1500,
+		Precision:              decimals(0),
+	},
+}
+
+// NewSensorManager creates a new sensor manager; every simulator gets its own RNG derived from the seed.
+// With control enabled the publish interval of every sensor can be changed at runtime over MQTT
+func NewSensorManager(brokerURL string, sensorsPerType, duration, burst int, jitter float64, seed int64, control bool) *SensorManager {
+	if burst < 1 {
+		burst = 1
+	}
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+
+	return &SensorManager{
+		BrokerURL:      brokerURL,
+		Sensors:        sensors,
+		SensorsPerType: sensorsPerType,
+		Duration:       duration,
+		Burst:          burst,
+		Jitter:         jitter,
+		Seed:           seed,
+		Control:        control,
+		MQTTTimeout:    DefaultMQTTTimeout,
+		Precision:      -1,
+		NewMQTTClient:  mqtt.NewClient,
+		Simulators:     make([]*SensorSimulator, 0),
+		stopStats:      make(chan struct{}),
+	}
+}
+
+// Start starts all sensor simulators
+func (sm *SensorManager) Start() error {
+	log.Printf("Starting sensor manager with %d sensor types, %d instances each",
+		len(sm.Sensors), sm.SensorsPerType)
+
+	//create sensor simulators
+	for _, sensorType := range sm.Sensors {
+		for i := 0; i < sm.SensorsPerType; i++ {
+			sensorID := fmt.Sprintf("%s-%d", sensorType.ID, i+1)
+			simulator, err := sm.createSensorSimulator(sensorType, sensorID)
+			if err != nil {
+				return fmt.Errorf("failed to create sensor %s: %w", sensorID, err)
+			}
+			sm.Simulators = append(sm.Simulators, simulator)
+		}
+	}
+
+	//start all simulators
+	for _, simulator := range sm.Simulators {
+		sm.WaitGroup.Add(1)
+		go simulator.Start(&sm.WaitGroup)
+	}
+
+	if sm.StatsInterval > 0 {
+		go sm.reportStats()
+	}
+
+	return nil
+}
+
+// Stop stops all sensor simulators, calling it again has no effect
+func (sm *SensorManager) Stop() {
+	sm.stopOnce.Do(func() {
+		log.Println("Stopping all sensor simulators...")
+
+		close(sm.stopStats)
+		for _, simulator := range sm.Simulators {
+			close(simulator.StopChan)
+		}
+
+		sm.WaitGroup.Wait()
+
+		//disconn MQTT clients
+		for _, simulator := range sm.Simulators {
+			if simulator.MQTTClient.IsConnected() {
+				simulator.MQTTClient.Disconnect(250)
+			}
+		}
+
+		sm.LogStats()
+		log.Println("All sensor simulators stopped")
+	})
+}
+
+// createSensorSimulator creates and connects a sensor simulator to MQTT
+func (sm *SensorManager) createSensorSimulator(sensorType types.Sensor, sensorID string) (*SensorSimulator, error) {
+	//the simulator index keeps the per-sensor streams distinct but reproducible for a given seed
+	rng := rand.New(rand.NewSource(sm.Seed + int64(len(sm.Simulators))))
+
+	if sm.Precision >= 0 {
+		sensorType.Precision = decimals(sm.Precision)
+	}
+
+	simulator := &SensorSimulator{
+		SensorType:  sensorType,
+		SensorID:    sensorID,
+		Topic:       sm.Topic,
+		Burst:       sm.Burst,
+		Jitter:      sm.Jitter,
+		Rand:        rng,
+		StopChan:    make(chan struct{}),
+		IntervalCh:  make(chan time.Duration, 1),
+		MQTTTimeout: sm.MQTTTimeout,
+		Profile:     profileFor(sm.Profiles, sensorType.ID, sensorID),
+	}
+
+	opts := mqtt.NewClientOptions()
+	if err := sm.MQTTSecurity.Apply(opts, []string{sm.BrokerURL}); err != nil {
+		return nil, fmt.Errorf("invalid MQTT settings: %w", err)
+	}
+	opts.SetClientID(fmt.Sprintf("sensor-%s", sensorID))
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Printf("Sensor %s connected to MQTT broker", sensorID)
+
+		//subscribe on every (re)connect, the session is not kept by the broker
+		if sm.Control {
+			simulator.subscribeToControl(client)
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("Sensor %s lost connection to MQTT broker: %v", sensorID, err)
+	})
+
+	client := sm.NewMQTTClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(sm.MQTTTimeout) {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: no acknowledgement within %v", sm.MQTTTimeout)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	simulator.MQTTClient = client
+	return simulator, nil
+}
+
+// controlTopic returns the topic on which a new publish interval in milliseconds can be sent to the sensor
+func controlTopic(sensorID string) string {
+	return fmt.Sprintf("control/%s/interval", sensorID)
+}
+
+// subscribeToControl subscribes the simulator to its control topic
+func (s *SensorSimulator) subscribeToControl(client mqtt.Client) {
+	topic := controlTopic(s.SensorID)
+
+	token := client.Subscribe(topic, 0, s.controlHandler)
+	if !token.WaitTimeout(s.MQTTTimeout) {
+		log.Printf("Subscribe to control topic %s timed out after %v, interval changes are ignored until the next reconnect", topic, s.MQTTTimeout)
+		return
+	}
+
+	if token.Error() != nil {
+		log.Printf("Failed to subscribe to control topic %s: %v", topic, token.Error())
+	} else {
+		log.Printf("Sensor %s listening for interval changes on %s", s.SensorID, topic)
+	}
+}
+
+// controlHandler parses a new interval in milliseconds and hands it to the Start loop, replacing a pending one
+func (s *SensorSimulator) controlHandler(client mqtt.Client, msg mqtt.Message) {
+	millis, err := strconv.Atoi(strings.TrimSpace(string(msg.Payload())))
+	if err != nil || millis <= 0 {
+		log.Printf("Ignoring invalid interval %q for sensor %s", msg.Payload(), s.SensorID)
+		return
+	}
+
+	//only the latest interval matters, so drop one that was not applied yet
+	select {
+	case <-s.IntervalCh:
+	default:
+	}
+	s.IntervalCh <- time.Duration(millis) * time.Millisecond
+}
+
+// Start starts the sensor simulation
+func (s *SensorSimulator) Start(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	interval := time.Duration(s.SensorType.DataGenerationInterval) * time.Millisecond
+	s.startedAt = time.Now()
+	s.activeAnomaly = -1
+
+	//init with base value
+	baseValue := s.SensorType.MinValue + s.Rand.Float64()*(s.SensorType.MaxValue-s.SensorType.MinValue)
+
+	//offset the start so that instances of the same type do not publish in lockstep
+	if s.Jitter > 0 {
+		select {
+		case <-s.StopChan:
+			return
+		case <-time.After(time.Duration(s.Rand.Int63n(int64(interval)))):
+		}
+	}
+
+	timer := time.NewTimer(s.nextTick(interval))
+	defer timer.Stop()
+
+	log.Printf("Started sensor simulation for %s (%s)", s.SensorID, s.SensorType.Name)
+
+	for {
+		select {
+		case <-s.StopChan:
+			log.Printf("Stopping sensor %s", s.SensorID)
+			return
+		case newInterval := <-s.IntervalCh:
+			//the timer is only touched from this loop, so replacing it cannot race with a tick
+			log.Printf("Sensor %s interval changed from %v to %v", s.SensorID, interval, newInterval)
+			interval = newInterval
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.nextTick(interval))
+		case <-timer.C:
+			timer.Reset(s.nextTick(interval))
+
+			//readings whose publish timed out go out together with the new ones
+			readings := append(s.Backlog, s.generateReadings(baseValue, interval)...)
+			s.Backlog = nil
+
+			//publish to MQTT
+			if err := s.publishData(readings); err != nil {
+				logging.Warnf("Error publishing data from sensor %s: %v", s.SensorID, err)
+				s.stats.errors.Add(1)
+				if errors.Is(err, errPublishTimeout) {
+					s.stats.timeouts.Add(1)
+					s.keepBacklog(readings)
+				} else {
+					s.stats.dropped.Add(int64(len(readings)))
+				}
+			} else {
+				s.stats.messages.Add(1)
+				s.stats.published.Add(int64(len(readings)))
+			}
+
+			//apply drift for next reading
+			baseValue = s.applyDrift(baseValue)
+		}
+	}
+}
+
+// keepBacklog keeps readings for the next tick, at most maxBacklog of them (the newest)
+func (s *SensorSimulator) keepBacklog(readings []types.SensorData) {
+	if len(readings) > maxBacklog {
+		log.Printf("Sensor %s dropping %d unpublished readings", s.SensorID, len(readings)-maxBacklog)
+		s.stats.dropped.Add(int64(len(readings) - maxBacklog))
+		readings = readings[len(readings)-maxBacklog:]
+	}
+	s.Backlog = readings
+}
+
+// nextTick returns the delay until the next tick, randomly shifted by up to Jitter*interval in either direction
+func (s *SensorSimulator) nextTick(interval time.Duration) time.Duration {
+	if s.Jitter <= 0 {
+		return interval
+	}
+
+	offset := (s.Rand.Float64()*2 - 1) * s.Jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+// generateReadings generates the readings for one tick; in burst mode the timestamps are spread evenly across the tick interval
+func (s *SensorSimulator) generateReadings(baseValue float64, interval time.Duration) []types.SensorData {
+	now := time.Now()
+	spacing := interval / time.Duration(s.Burst)
+
+	s.logAnomalyWindow(now.Sub(s.startedAt))
+
+	readings := make([]types.SensorData, s.Burst)
+	for i := range readings {
+		timestamp := now.Add(-time.Duration(s.Burst-1-i) * spacing)
+		readings[i] = s.SensorType.Round(types.SensorData{
+			SensorID:  s.SensorID,
+			Timestamp: timestamp,
+			Value:     s.generateSensorValue(baseValue, timestamp.Sub(s.startedAt)),
+			Unit:      s.SensorType.Unit,
+		})
+	}
+
+	return readings
+}
+
+// logAnomalyWindow logs when the simulation enters or leaves an anomaly window of the profile
+func (s *SensorSimulator) logAnomalyWindow(elapsed time.Duration) {
+	active := s.Profile.ActiveAnomaly(elapsed)
+	if active == s.activeAnomaly {
+		return
+	}
+
+	if s.activeAnomaly >= 0 {
+		log.Printf("Sensor %s anomaly window %d ended", s.SensorID, s.activeAnomaly)
+	}
+	if active >= 0 {
+		anomaly := s.Profile.Anomalies[active]
+		log.Printf("Sensor %s anomaly window %d active: %+.2f %s for %v", s.SensorID, active, anomaly.Magnitude, s.SensorType.Unit, anomaly.Duration)
+	}
+	s.activeAnomaly = active
+}
+
+// generateSensorValue generates a sensor value at the given time since the start of the simulation, stacking the
+// seasonality of the profile, noise and the scheduled anomalies
+func (s *SensorSimulator) generateSensorValue(baseValue float64, elapsed time.Duration) float64 {
+	baseValue += s.Profile.SeasonalOffset(elapsed)
+	noise := (s.Rand.Float64()*2 - 1) * s.SensorType.NoiseLevel * baseValue
+	value := baseValue + noise + s.Profile.AnomalyOffset(elapsed)
+
+	//ensure value is within sensor range
+	if value < s.SensorType.MinValue {
+		value = s.SensorType.MinValue
+	} else if value > s.SensorType.MaxValue {
+		value = s.SensorType.MaxValue
+	}
+
+	return value
+}
+
+// applyDrift applies random drift to the base value
+func (s *SensorSimulator) applyDrift(baseValue float64) float64 {
+	driftRange := (s.SensorType.MaxValue - s.SensorType.MinValue) * 0.001
+	drift := (s.Rand.Float64()*2 - 1) * driftRange
+
+	newValue := baseValue + drift
+
+	//wnsure the value stays within range
+	if newValue < s.SensorType.MinValue {
+		newValue = s.SensorType.MinValue
+	} else if newValue > s.SensorType.MaxValue {
+		newValue = s.SensorType.MaxValue
+	}
+
+	return newValue
+}
+
+// publishData publishes sensor data to MQTT topic; a single reading is sent as an object, a burst as a JSON array
+func (s *SensorSimulator) publishData(readings []types.SensorData) error {
+	topic, err := s.Topic.Render(s.SensorType.ID, s.SensorID)
+	if err != nil {
+		return err
+	}
+
+	var payload any = readings
+	if len(readings) == 1 {
+		payload = readings[0]
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sensor data: %w", err)
+	}
+
+	//publish topci to MQTT
+	token := s.MQTTClient.Publish(topic, 0, false, jsonData)
+	if !token.WaitTimeout(s.MQTTTimeout) {
+		return fmt.Errorf("%w: topic %s, waited %v", errPublishTimeout, topic, s.MQTTTimeout)
+	}
+
+	if token.Error() != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, token.Error())
+	}
+
+	if len(readings) == 1 {
+		logging.Debugf("Published data from %s: %.2f %s to topic %s",
+			s.SensorID, readings[0].Value, readings[0].Unit, topic)
+	} else {
+		logging.Debugf("Published burst of %d readings from %s to topic %s",
+			len(readings), s.SensorID, topic)
+	}
+
+	return nil
+}
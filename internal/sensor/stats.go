@@ -0,0 +1,87 @@
+package sensor
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// simulatorStats counts the publishes of one simulator; it is written by the Start loop and read by the reporting
+type simulatorStats struct {
+	messages  atomic.Int64 //acknowledged MQTT messages
+	published atomic.Int64 //readings in acknowledged messages, more than messages in burst mode
+	errors    atomic.Int64 //failed publishes, including timeouts
+	timeouts  atomic.Int64 //publishes not acknowledged in time, their readings are kept for the next tick
+	dropped   atomic.Int64 //readings given up on, after a failed publish or when the backlog was full
+}
+
+// SensorStats is a snapshot of the publish statistics of one sensor
+type SensorStats struct {
+	SensorID  string
+	Messages  int64
+	Published int64
+	Errors    int64
+	Timeouts  int64
+	Dropped   int64
+}
+
+// add adds the counters of other, e.g. to compute the totals of all sensors
+func (s *SensorStats) add(other SensorStats) {
+	s.Messages += other.Messages
+	s.Published += other.Published
+	s.Errors += other.Errors
+	s.Timeouts += other.Timeouts
+	s.Dropped += other.Dropped
+}
+
+// Stats returns the statistics of every sensor in the order the sensors were created
+func (sm *SensorManager) Stats() []SensorStats {
+	result := make([]SensorStats, len(sm.Simulators))
+	for i, simulator := range sm.Simulators {
+		result[i] = SensorStats{
+			SensorID:  simulator.SensorID,
+			Messages:  simulator.stats.messages.Load(),
+			Published: simulator.stats.published.Load(),
+			Errors:    simulator.stats.errors.Load(),
+			Timeouts:  simulator.stats.timeouts.Load(),
+			Dropped:   simulator.stats.dropped.Load(),
+		}
+	}
+	return result
+}
+
+// TotalStats returns the statistics of all sensors added up, with an empty SensorID
+func (sm *SensorManager) TotalStats() SensorStats {
+	var total SensorStats
+	for _, stats := range sm.Stats() {
+		total.add(stats)
+	}
+	return total
+}
+
+// LogStats logs the totals and one line per sensor
+func (sm *SensorManager) LogStats() {
+	total := sm.TotalStats()
+	log.Printf("Sensor stats: %d sensors, %d readings in %d messages, %d errors (%d timeouts), %d readings dropped",
+		len(sm.Simulators), total.Published, total.Messages, total.Errors, total.Timeouts, total.Dropped)
+
+	for _, stats := range sm.Stats() {
+		log.Printf("  %s: %d readings in %d messages, %d errors (%d timeouts), %d dropped",
+			stats.SensorID, stats.Published, stats.Messages, stats.Errors, stats.Timeouts, stats.Dropped)
+	}
+}
+
+// reportStats logs the statistics every StatsInterval until Stop is called
+func (sm *SensorManager) reportStats() {
+	ticker := time.NewTicker(sm.StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.stopStats:
+			return
+		case <-ticker.C:
+			sm.LogStats()
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// Config holds everything needed to run the HTTP server with its 2PC coordinator
+type Config struct {
+	Host                 string
+	Port                 int
+	SocketPath           string   //listen on a Unix domain socket instead of host:port if set
+	DatabaseAddresses    []string //one address per replica
+	AdminUser            string
+	AdminPassword        string        //empty disables the admin endpoints
+	DryRun               bool          //prepare 2PC writes on all databases, then always abort
+	CompressionThreshold int           //minimum response size that is gzipped, 0 disables compression
+	WriteTimeout         time.Duration //time a client gets to read one response before its connection is dropped
+	ServerHeader         string        //Server header of every response, empty omits it
+	ListenBacklog        int           //length of the accept queue of the listener, 0 = system default
+	ReusePort            bool          //set SO_REUSEPORT so several servers can listen on the same port
+	MaxRequestsPerConn   int           //requests served on one persistent connection before it is closed, 0 = unlimited
+	BreakerThreshold     int           //consecutive failed calls after which a database is skipped, 0 disables the breaker
+	BreakerCooldown      time.Duration
+	ReadStrategy         database.ReadStrategy //how reads are spread across the databases
+	ReadWeights          []int                 //read weight per database in address order, used by the weighted strategy
+	TrailingSlash        http.TrailingSlash    //how a path differing from a route only by a trailing slash is served, strict by default
+	MaxTransactions      int                   //2PC transactions running at once, 0 = unlimited
+	BusyPolicy           database.BusyPolicy   //whether a write beyond MaxTransactions waits or is refused with 503
+	BusyWait             time.Duration         //longest wait for a free slot with the block policy, then the write is refused with 503
+	ReadCacheTTL         time.Duration         //how long GET /data/{sensorId} results are cached, 0 disables the cache
+	ReadCacheSize        int                   //maximum number of sensors in the read cache
+	DegradedWrites       bool                  //keep accepting writes on the reachable databases when one is down
+	ReconcileInterval    time.Duration         //how often writes missed by a database are replayed in degraded mode
+	ReadyWait            time.Duration         //how long a write waits for a reconnecting database, 0 disables waiting
+	RouteListing         bool                  //serve GET /_routes listing all registered handlers, for development
+	DBConnectTimeout     time.Duration         //how long the startup waits for unreachable databases, 0 = not checked
+	DBConnectBackoff     time.Duration         //wait after the first failed connection attempt, doubled per attempt
+	DBConnectMaxBackoff  time.Duration         //upper bound of the wait between two attempts
+	DBConnectFailFast    bool                  //fail the startup on the first unreachable database, DBConnectTimeout is ignored
+	ShutdownTimeout      time.Duration         //time requests and then transactions get to finish on shutdown, 0 = no limit
+	KeepaliveInterval    time.Duration         //idle time after which a database connection is pinged, 0 disables the pings
+	KeepaliveTimeout     time.Duration         //time a ping may take before the connection is dropped
+	ValueFormat          types.ValueFormat     //how the values and timestamps of returned readings are written as JSON
+	IdempotencyTTL       time.Duration         //how long the response to a POST /data with an Idempotency-Key is kept, 0 disables the keys
+	IdempotencyMaxKeys   int                   //upper bound of remembered keys, the oldest one is forgotten first
+	LeaseTTL             time.Duration         //coordinate writes only while holding the coordinator lease for this long, 0 = always coordinate
+	LeaseHolder          string                //ID of this server in the coordinator lease, host:port plus a random suffix if empty
+}
+
+// DefaultConfig returns the configuration the server binary uses when no flags are given
+func DefaultConfig() Config {
+	return Config{
+		Host:                 "0.0.0.0",
+		Port:                 8080,
+		DatabaseAddresses:    []string{"localhost:50051", "localhost:50052"},
+		AdminUser:            "admin",
+		CompressionThreshold: 1024,
+		WriteTimeout:         http.DefaultWriteTimeout,
+		ServerHeader:         http.DefaultServerHeader,
+		BreakerThreshold:     5,
+		BreakerCooldown:      10 * time.Second,
+		ReconcileInterval:    5 * time.Second,
+		DBConnectBackoff:     100 * time.Millisecond,
+		DBConnectMaxBackoff:  5 * time.Second,
+		ShutdownTimeout:      10 * time.Second,
+		KeepaliveInterval:    database.DefaultKeepaliveInterval,
+		KeepaliveTimeout:     database.DefaultKeepaliveTimeout,
+		ValueFormat:          types.DefaultValueFormat,
+		IdempotencyTTL:       10 * time.Minute,
+		IdempotencyMaxKeys:   10000,
+		ReadCacheSize:        1000,
+		BusyWait:             database.DefaultBusyWait,
+	}
+}
+
+// App is the HTTP server wired to the 2PC client
+type App struct {
+	config    Config
+	server    *http.Server
+	tpcClient *database.TwoPhaseCommitClient
+}
+
+// AppFactory connects to the databases and registers all handlers, the server is not started yet
+func AppFactory(config Config) (*App, error) {
+	tpcOptions := []database.TwoPhaseCommitOption{
+		database.WithCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		database.WithReadStrategy(config.ReadStrategy),
+		database.WithReplicaWeights(config.ReadWeights...),
+		database.WithReadyWait(config.ReadyWait),
+		database.WithClientOptions(database.WithKeepalive(config.KeepaliveInterval, config.KeepaliveTimeout, true)),
+	}
+	if config.DegradedWrites {
+		log.Println("Degraded writes enabled: writes continue on the reachable databases if one is down")
+		tpcOptions = append(tpcOptions, database.WithDegradedWrites(config.ReconcileInterval))
+	}
+	if config.DBConnectFailFast {
+		tpcOptions = append(tpcOptions, database.WithConnectRetry(0, 0, 0))
+	} else if config.DBConnectTimeout > 0 {
+		log.Printf("Waiting up to %v for the databases to come online", config.DBConnectTimeout)
+		tpcOptions = append(tpcOptions, database.WithConnectRetry(config.DBConnectTimeout, config.DBConnectBackoff, config.DBConnectMaxBackoff))
+	}
+	if config.MaxTransactions > 0 {
+		log.Printf("At most %d 2PC transactions run at once (busy policy %s)", config.MaxTransactions, config.BusyPolicy)
+		tpcOptions = append(tpcOptions, database.WithMaxConcurrentTransactions(config.MaxTransactions, config.BusyPolicy), database.WithBusyWait(config.BusyWait))
+	}
+	if config.ReadCacheTTL > 0 && config.ReadCacheSize > 0 {
+		log.Printf("Caching reads of up to %d sensors for %v", config.ReadCacheSize, config.ReadCacheTTL)
+		tpcOptions = append(tpcOptions, database.WithReadCache(config.ReadCacheTTL, config.ReadCacheSize))
+	}
+	if config.LeaseTTL > 0 {
+		holder := config.LeaseHolder
+		if holder == "" {
+			holder = defaultLeaseHolder(config)
+		}
+		log.Printf("Coordinating writes only while holding the coordinator lease (%v) as %s", config.LeaseTTL, holder)
+		tpcOptions = append(tpcOptions, database.WithCoordinatorLease(holder, config.LeaseTTL))
+	}
+	if config.DryRun {
+		log.Println("Running in dry-run mode: 2PC writes are prepared and then aborted")
+		tpcOptions = append(tpcOptions, database.WithDryRun())
+	}
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(config.DatabaseAddresses, tpcOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database services: %w", err)
+	}
+
+	serverOptions := []http.ServerOption{http.WithServerHeader(config.ServerHeader)}
+	if config.ListenBacklog > 0 {
+		serverOptions = append(serverOptions, http.WithListenBacklog(config.ListenBacklog))
+	}
+	if config.ReusePort {
+		serverOptions = append(serverOptions, http.WithReusePort())
+	}
+	if config.MaxRequestsPerConn > 0 {
+		serverOptions = append(serverOptions, http.WithMaxRequestsPerConn(config.MaxRequestsPerConn))
+	}
+	if config.TrailingSlash != http.TrailingSlashStrict {
+		serverOptions = append(serverOptions, http.WithTrailingSlash(config.TrailingSlash))
+	}
+
+	server := http.ServerFactory(config.Host, config.Port, serverOptions...)
+	if config.SocketPath != "" {
+		server = http.UnixSocketServerFactory(config.SocketPath, serverOptions...)
+	}
+	server.CompressionThreshold = config.CompressionThreshold
+	server.WriteTimeout = config.WriteTimeout
+
+	if !config.ValueFormat.IsDefault() {
+		log.Printf("Writing reading values with %s", config.ValueFormat)
+	}
+	registerHandlers(server, tpcClient, config.ValueFormat, idempotencyStoreFactory(config.IdempotencyMaxKeys, config.IdempotencyTTL))
+
+	if config.AdminPassword != "" {
+		registerAdminHandlers(server, tpcClient, config.AdminUser, config.AdminPassword)
+	} else {
+		log.Println("Admin endpoints disabled (no admin password set)")
+	}
+
+	if config.RouteListing {
+		registerRouteListing(server)
+	}
+
+	return &App{
+		config:    config,
+		server:    server,
+		tpcClient: tpcClient,
+	}, nil
+}
+
+// Start starts listening for requests in the background
+func (a *App) Start() error {
+	if err := a.server.Start(); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the server and closes the database connections, waiting for every request and transaction to finish
+func (a *App) Stop() {
+	a.Shutdown(0)
+}
+
+// Shutdown stops the app in order: the server stops accepting connections and gets up to timeout (0 = no limit) to
+// answer the requests in progress, then the 2PC transactions still running, e.g. of a request that outlived the
+// timeout, get up to timeout to finish before the database connections are closed
+func (a *App) Shutdown(timeout time.Duration) error {
+	serverErr := a.server.Shutdown(timeout)
+	if serverErr != nil {
+		serverErr = fmt.Errorf("error stopping server: %w", serverErr)
+	}
+
+	drainErr := a.tpcClient.DrainAndClose(timeout)
+	if drainErr != nil {
+		drainErr = fmt.Errorf("error closing database connections: %w", drainErr)
+	}
+	return errors.Join(serverErr, drainErr)
+}
+
+// Server returns the underlying HTTP server, e.g. to register additional handlers before Start
+func (a *App) Server() *http.Server {
+	return a.server
+}
+
+// defaultLeaseHolder returns an ID for the coordinator lease that names the server and stays unique even for servers
+// sharing a port with ReusePort
+func defaultLeaseHolder(config Config) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = config.Host
+	}
+	address := fmt.Sprintf("%s:%d", host, config.Port)
+	if config.SocketPath != "" {
+		address = host + ":" + config.SocketPath
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return address + "#" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// plainSensorData has the fields of types.SensorData without its UnmarshalJSON
+type plainSensorData types.SensorData
+
+// strictSensorData decodes a reading like types.SensorData.UnmarshalJSON, with an RFC 3339 or epoch millis
+// timestamp, but as a plain struct, so that the decoder of decodeStrict still sees and checks its fields
+type strictSensorData struct {
+	*plainSensorData
+	Timestamp types.FlexibleTime `json:"timestamp"`
+}
+
+// decodeStrict decodes a JSON request body into v. Unlike json.Unmarshal it rejects fields v does not have and data
+// after the JSON value, and its errors name the offending field, e.g. `field "value": expected number, got string`
+func decodeStrict(body []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	//types.SensorData decodes itself, which would hide its fields from DisallowUnknownFields
+	reading, isReading := v.(*types.SensorData)
+	var strict strictSensorData
+	if isReading {
+		strict = strictSensorData{plainSensorData: (*plainSensorData)(reading), Timestamp: types.FlexibleTime{Time: reading.Timestamp}}
+		v = &strict
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		return describeDecodeError(err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("unexpected data after the JSON value")
+	}
+	if isReading {
+		reading.Timestamp = strict.Timestamp.Time
+	}
+	return nil
+}
+
+// describeDecodeError rewrites the errors of encoding/json so they name the field and the expected type
+func describeDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Errorf("expected %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)
+		}
+		return fmt.Errorf("field %q: expected %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &timeErr):
+		//time.Time decodes itself, so encoding/json does not know the field
+		return fmt.Errorf("invalid time %q, expected RFC 3339 like 2025-06-01T12:00:00Z", timeErr.Value)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("incomplete JSON")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		//encoding/json has no error type for unknown fields
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		return err
+	}
+}
+
+// jsonTypeName returns the JSON type a Go type is decoded from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	default:
+		return t.String()
+	}
+}
+
+// decodeSensorDataStrict decodes a single reading or a JSON array of readings like types.DecodeSensorDataList, but
+// with decodeStrict; an error in an array names the index of the reading
+func decodeSensorDataStrict(body []byte) ([]types.SensorData, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		var single types.SensorData
+		if err := decodeStrict(trimmed, &single); err != nil {
+			return nil, err
+		}
+		return []types.SensorData{single}, nil
+	}
+
+	var elements []json.RawMessage
+	if err := decodeStrict(trimmed, &elements); err != nil {
+		return nil, err
+	}
+	readings := make([]types.SensorData, len(elements))
+	for i, element := range elements {
+		if err := decodeStrict(element, &readings[i]); err != nil {
+			return nil, fmt.Errorf("reading %d: %w", i, err)
+		}
+	}
+	return readings, nil
+}
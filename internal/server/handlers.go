@@ -0,0 +1,838 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// SessionSequenceHeader carries the commit sequence of a write in the response to POST /data; sending it back on a
+// GET makes the read wait for a database that applied the write (read-your-writes)
+const SessionSequenceHeader = "X-Session-Sequence"
+
+// dataReader is implemented by the 2PC client and by its read sessions
+type dataReader interface {
+	GetAllDataPoints() ([]types.SensorData, error)
+	GetDataPointBySensorId(sensorID string) ([]types.SensorData, error)
+	GetDataPointsByPrefix(prefix string) ([]types.SensorData, error)
+	GetDataPointsByIds(sensorIDs []string) (map[string][]types.SensorData, error)
+}
+
+// registerHandlers registers all HTTP handlers for the server; the values of returned readings are written in format
+// and POST /data remembers idempotency keys in idempotency unless it is nil
+func registerHandlers(server *http.Server, tpcClient *database.TwoPhaseCommitClient, format types.ValueFormat, idempotency *idempotencyStore) {
+	//wakes the long polls of GET /data/poll whenever a write was stored
+	notifier := storeNotifierFactory()
+	feed := pollFeedFactory(func() ([]types.SensorData, error) {
+		return tpcClient.GetAllDataPointsFrom(pollReplica)
+	})
+
+	//for HTTP POST requests to add sensor data using 2PC; a request with an Idempotency-Key is stored only once
+	server.RegisterHandler(
+		http.POST,
+		"/data",
+		func(req *http.Request) *http.Response {
+			var resp *http.Response
+			key := req.Header(IdempotencyKeyHeader)
+			if key == "" || idempotency == nil {
+				resp = postData(tpcClient, req)
+			} else {
+				resp = idempotency.do(key, req, func() *http.Response {
+					return postData(tpcClient, req)
+				})
+			}
+			if resp.StatusCode == http.StatusOK {
+				notifier.notify()
+			}
+			return resp
+		},
+	)
+
+	//for HTTP POST requests uploading CSV files of historical readings as multipart/form-data
+	server.RegisterHandler(
+		http.POST,
+		"/data/import",
+		func(req *http.Request) *http.Response {
+			resp := importCSV(tpcClient, req)
+			if resp.StatusCode == http.StatusOK {
+				notifier.notify()
+			}
+			return resp
+		},
+	)
+
+	//for HTTP GET requests waiting for readings stored after ?after=, a fallback for clients that cannot stream
+	server.RegisterHandler(
+		http.GET,
+		"/data/poll",
+		func(req *http.Request) *http.Response {
+			format, errResp := requestFormat(req, format)
+			if errResp != nil {
+				return errResp
+			}
+			return pollData(feed, notifier, server.Closing(), req, format)
+		},
+	)
+
+	//for HTTP GET requests to retrieve all sensor data (optionally filtered by sensor ID prefix)
+	server.RegisterHandler(
+		http.GET,
+		"/data",
+		func(req *http.Request) *http.Response {
+			reader, errResp := sessionReader(tpcClient, req)
+			if errResp != nil {
+				return errResp
+			}
+
+			//GET /data?fields=sensorId,value returns only these keys of every reading
+			var fields []string
+			if spec, ok := req.Query["fields"]; ok {
+				var err error
+				if fields, err = types.ParseSensorDataFields(spec); err != nil {
+					return http.CreateErrorResponse(http.StatusBadRequest, "invalid_fields", fmt.Sprintf("Invalid fields: %v", err))
+				}
+			}
+
+			//GET /data?from=...&to=...&by=ingestedAt only returns the readings stored in that time range
+			timeRange, errResp := parseTimeRange(req)
+			if errResp != nil {
+				return errResp
+			}
+
+			//GET /data?timestamps=millis writes the times as epoch milliseconds
+			format, errResp := requestFormat(req, format)
+			if errResp != nil {
+				return errResp
+			}
+
+			//GET /data?ids=temp-1,humid-1 returns the data grouped by sensor ID
+			if ids, ok := req.Query["ids"]; ok {
+				return getDataByIds(reader, ids, fields, timeRange, format)
+			}
+
+			var allData []types.SensorData
+			var err error
+
+			//GET /data?prefix=temp- returns all sensors whose ID starts with the prefix
+			if prefix, ok := req.Query["prefix"]; ok {
+				allData, err = reader.GetDataPointsByPrefix(prefix)
+			} else {
+				allData, err = reader.GetAllDataPoints()
+			}
+			if err != nil {
+				logging.Warnf("Error retrieving data: %v", err)
+				return retrievalErrorResponse(err)
+			}
+			if timeRange != nil {
+				allData = timeRange.Filter(allData)
+			}
+
+			projected := projectSensorData(allData, fields, format)
+
+			//without a Range header the readings are streamed one by one instead of being marshaled as a whole
+			if req.Header("Range") == "" {
+				resp := http.NewStreamingResponse(http.StatusOK, "application/json", jsonArrayStream(projected))
+				resp.SetHeader("Accept-Ranges", "bytes")
+				return resp
+			}
+
+			jsonData, err := json.Marshal(projected)
+			if err != nil {
+				logging.Warnf("Error marshaling data to JSON: %v", err)
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+			}
+
+			//large exports can be fetched or resumed in pieces with a Range header
+			return http.ServeContent(req, "application/json", jsonData)
+		},
+	)
+
+	//for HTTP DELETE requests to wipe all sensor data on both databases using 2PC
+	server.RegisterHandler(
+		http.DELETE,
+		"/data",
+		func(req *http.Request) *http.Response {
+			//refuse to wipe the store unless explicitly confirmed
+			if req.Query["confirm"] != "true" {
+				return http.CreateErrorResponse(http.StatusBadRequest, "confirmation_required", "Deleting all data requires ?confirm=true")
+			}
+
+			removed, err := tpcClient.DeleteAllWithTwoPhaseCommit()
+			if err != nil {
+				logging.Warnf("Error deleting all data with 2PC: %v", err)
+				return storageErrorResponse(err, fmt.Sprintf("Error deleting data: %v", err))
+			}
+
+			jsonData, err := json.Marshal(map[string]int64{"deleted": removed})
+			if err != nil {
+				logging.Warnf("Error marshaling data to JSON: %v", err)
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+			}
+
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+
+	//for HTTP GET requests to retrieve data for a specific sensor
+	server.RegisterHandler(
+		http.GET,
+		"/data/*",
+		func(req *http.Request) *http.Response {
+			//extract sensor ID from path
+			path := req.Path
+			if path == "/data/" {
+				return http.CreateErrorResponse(http.StatusBadRequest, "missing_sensor_id", "Missing sensor ID")
+			}
+
+			sensorID := path[6:] //remove "/data/" from the req path
+
+			reader, errResp := sessionReader(tpcClient, req)
+			if errResp != nil {
+				return errResp
+			}
+
+			timeRange, errResp := parseTimeRange(req)
+			if errResp != nil {
+				return errResp
+			}
+			format, errResp := requestFormat(req, format)
+			if errResp != nil {
+				return errResp
+			}
+
+			sensorData, err := reader.GetDataPointBySensorId(sensorID)
+			if err != nil {
+				logging.Warnf("Error retrieving data for sensor %s: %v", sensorID, err)
+				return retrievalErrorResponse(err)
+			}
+
+			if len(sensorData) == 0 {
+				return http.CreateErrorResponse(http.StatusNotFound, "not_found", fmt.Sprintf("No data found for sensor %s", sensorID))
+			}
+			if timeRange != nil {
+				sensorData = timeRange.Filter(sensorData)
+			}
+
+			//GET /data/{sensorId}?unit=°F converts the values for the response, the stored readings keep their unit
+			if unit := req.Query["unit"]; unit != "" {
+				converted := make([]types.SensorData, len(sensorData))
+				for i, reading := range sensorData {
+					if converted[i], err = reading.ConvertedTo(unit); err != nil {
+						return http.CreateErrorResponse(http.StatusBadRequest, "incompatible_unit", fmt.Sprintf("Cannot convert the readings of sensor %s: %v", sensorID, err))
+					}
+				}
+				sensorData = converted
+			}
+
+			jsonData, err := json.Marshal(projectSensorData(sensorData, nil, format))
+			if err != nil {
+				logging.Warnf("Error marshaling data to JSON: %v", err)
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+			}
+
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+
+	//for HTTP PATCH requests changing only some fields of one reading, identified by sensor ID and timestamp, using 2PC
+	server.RegisterHandler(
+		http.PATCH,
+		"/data/*",
+		func(req *http.Request) *http.Response {
+			if req.Path == "/data/" {
+				return http.CreateErrorResponse(http.StatusBadRequest, "missing_sensor_id", "Missing sensor ID")
+			}
+
+			var patch types.SensorDataPatch
+			if err := decodeStrict(req.Body, &patch); err != nil {
+				logging.Warnf("Error parsing patch: %v", err)
+				return http.CreateErrorResponse(http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON: %v", err))
+			}
+			patch.SensorID = req.Path[6:] //the path names the sensor, a sensorId in the body is ignored
+
+			if err := patch.Validate(); err != nil {
+				return invalidSensorDataResponse(err)
+			}
+			if patch.Empty() {
+				return http.CreateErrorResponse(http.StatusBadRequest, "empty_patch", "The patch changes no field, expected value or unit")
+			}
+
+			if _, err := tpcClient.PatchDataPointWithTwoPhaseCommit(patch); err != nil {
+				if errors.Is(err, database.ErrDataNotFound) {
+					return http.CreateErrorResponse(http.StatusNotFound, "not_found", fmt.Sprintf("No data found for sensor %s at %s", patch.SensorID, patch.Timestamp.Format(time.RFC3339Nano)))
+				}
+				logging.Warnf("Error patching data with 2PC: %v", err)
+				return storageErrorResponse(err, fmt.Sprintf("Error patching data: %v", err))
+			}
+
+			logging.Debugf("Patched data of sensor %s at %s using 2PC", patch.SensorID, patch.Timestamp)
+
+			resp := http.NewResponse(http.StatusOK)
+			resp.SetBodyString("Data patched successfully using Two-Phase Commit")
+			return resp
+		},
+	)
+
+	//for HTTP GET requests to the root path (for browser access)
+	server.RegisterHandler(
+		http.GET,
+		"/",
+		func(req *http.Request) *http.Response {
+			html := `
+				<!DOCTYPE html>
+				<html>
+				<head>
+					<title>IoT Data Viewer - Redundant Storage</title>
+					<style>
+						body { font-family: Arial, sans-serif; margin: 0; padding: 20px; }
+						h1 { color: #333; }
+						.info { background-color: #e8f4fd; padding: 10px; border-radius: 5px; margin-bottom: 20px; }
+						table { border-collapse: collapse; width: 100%; }
+						th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+						th { background-color: #f2f2f2; }
+						tr:nth-child(even) { background-color: #f9f9f9; }
+					</style>
+					<script>
+						// Fetch data every x seconds
+						function fetchData() {
+							fetch('/data')
+								.then(response => response.json())
+								.then(data => {
+									const tableBody = document.getElementById('dataTable').getElementsByTagName('tbody')[0];
+									tableBody.innerHTML = '';
+									
+									// Sort by timestamp (newest first)
+									data.sort((a, b) => new Date(b.timestamp) - new Date(a.timestamp));
+									
+									data.forEach(item => {
+										const row = tableBody.insertRow();
+										row.insertCell(0).textContent = item.sensorId;
+										row.insertCell(1).textContent = new Date(item.timestamp).toLocaleString();
+										row.insertCell(2).textContent = item.value + ' ' + item.unit;
+									});
+								})
+								.catch(error => console.error('Error fetching data:', error));
+						}
+						
+						// Initial fetch and setup interval
+						document.addEventListener('DOMContentLoaded', () => {
+							fetchData();
+							setInterval(fetchData, 1000);
+						});
+					</script>
+				</head>
+				<body>
+					<h1>IoT Sensor Data - Redundant Storage</h1>
+					<div class="info">
+						<strong>Two-Phase Commit:</strong> Data is stored redundantly across two database servers for high availability.
+					</div>
+					<table id="dataTable">
+						<thead>
+							<tr>
+								<th>Sensor ID</th>
+								<th>Timestamp</th>
+								<th>Value</th>
+							</tr>
+						</thead>
+						<tbody>
+							<!-- Data will be inserted here by JavaScript -->
+						</tbody>
+					</table>
+				</body>
+				</html>
+			`
+			return http.CreateHTMLResponse(http.StatusOK, []byte(html))
+		},
+	)
+
+	//connection level metrics of the HTTP server and the replica state of the 2PC client, e.g. to watch capacity during load tests
+	server.RegisterHandler(
+		http.GET,
+		"/metrics",
+		func(req *http.Request) *http.Response {
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"connections": server.ConnStats(),
+				"databases":   tpcClient.Stats(),
+			})
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Error marshaling metrics: %v", err))
+			}
+
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+
+	//handler for performance testing of the 2PC interface
+	server.RegisterHandler(
+		http.GET,
+		"/performance/2pc",
+		func(req *http.Request) *http.Response {
+			iterations := 10_000 //smaller number for 2PC becuase it's mad expensive
+			min, max, avg, err := tpcClient.RunTwoPhaseCommitPerformanceTest(iterations)
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "performance_test_failed", fmt.Sprintf("2PC performance test failed: %v", err))
+			}
+
+			result := map[string]interface{}{
+				"iterations": iterations,
+				"min_rtt":    min.String(),
+				"max_rtt":    max.String(),
+				"avg_rtt":    avg.String(),
+				"protocol":   "Two-Phase Commit",
+			}
+
+			jsonData, err := json.Marshal(result)
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Error marshaling results: %v", err))
+			}
+
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+}
+
+// sessionReader returns the reader for a GET request: a read session if the request carries a session sequence,
+// otherwise the 2PC client itself. An invalid sequence yields an error response
+func sessionReader(tpcClient *database.TwoPhaseCommitClient, req *http.Request) (dataReader, *http.Response) {
+	value := req.Header(SessionSequenceHeader)
+	if value == "" {
+		return tpcClient, nil
+	}
+
+	sequence, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return nil, http.CreateErrorResponse(http.StatusBadRequest, "invalid_sequence", fmt.Sprintf("Invalid %s header: %q", SessionSequenceHeader, value))
+	}
+	return tpcClient.ReadAtLeast(sequence), nil
+}
+
+// overloadRetryAfter is the Retry-After sent when a write was refused because a database is at capacity or too many
+// transactions are running
+const overloadRetryAfter = 1 * time.Second
+
+// storageErrorResponse maps a failed 2PC write to an error response: a database at capacity is temporary and answered
+// with 503 and a Retry-After header so clients back off, as is a write to a standby server that does not hold the
+// coordinator lease; a write arriving during shutdown is a 503 as well, data a database refused in its validation is
+// a 422 and everything else is a 500
+func storageErrorResponse(err error, message string) *http.Response {
+	if errors.Is(err, database.ErrCapacityFull) {
+		resp := http.CreateErrorResponse(http.StatusServiceUnavailable, "overloaded", message)
+		resp.SetHeader("Retry-After", strconv.Itoa(int(overloadRetryAfter.Seconds())))
+		return resp
+	}
+	if errors.Is(err, database.ErrTooBusy) {
+		resp := http.CreateErrorResponse(http.StatusServiceUnavailable, "too_busy", message)
+		resp.SetHeader("Retry-After", strconv.Itoa(int(overloadRetryAfter.Seconds())))
+		return resp
+	}
+	if errors.Is(err, database.ErrNotLeader) {
+		resp := http.CreateErrorResponse(http.StatusServiceUnavailable, "not_leader", message)
+		resp.SetHeader("Retry-After", strconv.Itoa(int(overloadRetryAfter.Seconds())))
+		return resp
+	}
+	if errors.Is(err, database.ErrClientClosed) {
+		return http.CreateErrorResponse(http.StatusServiceUnavailable, "shutting_down", message)
+	}
+	if errors.Is(err, database.ErrRejected) {
+		return http.CreateErrorResponse(http.StatusUnprocessable, "rejected", message)
+	}
+	return http.CreateErrorResponse(http.StatusServerError, "storage_failed", message)
+}
+
+// invalidSensorDataResponse maps a reading rejected by types.SensorData.Validate to a 400 response
+func invalidSensorDataResponse(err error) *http.Response {
+	if errors.Is(err, types.ErrMissingSensorID) {
+		return http.CreateErrorResponse(http.StatusBadRequest, "missing_sensor_id", "Missing sensorId")
+	}
+	return http.CreateErrorResponse(http.StatusBadRequest, "invalid_sensor_data", fmt.Sprintf("Invalid sensor data: %v", err))
+}
+
+// retrievalErrorResponse maps a failed read to an error response; a session sequence that no database applied yet
+// is temporary, so the client may retry
+func retrievalErrorResponse(err error) *http.Response {
+	if errors.Is(err, database.ErrSequenceNotApplied) {
+		return http.CreateErrorResponse(http.StatusServiceUnavailable, "sequence_not_applied", fmt.Sprintf("Error retrieving data: %v", err))
+	}
+	return http.CreateErrorResponse(http.StatusServerError, "retrieval_failed", fmt.Sprintf("Error retrieving data: %v", err))
+}
+
+// projectSensorData returns the readings to marshal: the readings themselves, or their reduced views if fields were
+// requested with ?fields=, with the values and times written in format
+func projectSensorData(list []types.SensorData, fields []string, format types.ValueFormat) any {
+	if fields == nil {
+		if format.IsDefault() {
+			return list
+		}
+		return types.FormatSensorDataList(list, format)
+	}
+
+	views := types.ProjectSensorDataList(list, fields)
+	if !format.IsDefault() {
+		for _, view := range views {
+			if value, ok := view["value"].(float64); ok {
+				view["value"] = types.FormattedValue{Value: value, Format: format}
+			}
+			for _, field := range []string{"timestamp", "ingestedAt"} {
+				if t, ok := view[field].(time.Time); ok {
+					view[field] = types.FormattedTime{Time: t, EpochMillis: format.EpochMillis}
+				}
+			}
+		}
+	}
+	return views
+}
+
+// jsonArrayStream returns a body stream writing a slice as a JSON array one element at a time, giving the same bytes
+// as json.Marshal of the whole slice
+func jsonArrayStream(list any) http.BodyStream {
+	return func(w io.Writer) error {
+		items := reflect.ValueOf(list)
+		if items.IsNil() {
+			_, err := io.WriteString(w, "null")
+			return err
+		}
+
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		for i := range items.Len() {
+			element, err := json.Marshal(items.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				element = append([]byte{','}, element...)
+			}
+			if _, err := w.Write(element); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "]")
+		return err
+	}
+}
+
+// requestFormat returns the format of the configured one the response is written in: ?timestamps=millis writes the
+// times as epoch milliseconds, ?timestamps=rfc3339 as RFC 3339 strings, whatever the server writes by default
+func requestFormat(req *http.Request, format types.ValueFormat) (types.ValueFormat, *http.Response) {
+	switch value, ok := req.Query["timestamps"]; {
+	case !ok:
+	case value == "millis":
+		format.EpochMillis = true
+	case value == "rfc3339":
+		format.EpochMillis = false
+	default:
+		return format, http.CreateErrorResponse(http.StatusBadRequest, "invalid_timestamps", fmt.Sprintf("Invalid timestamps %q, expected millis or rfc3339", value))
+	}
+	return format, nil
+}
+
+// parseTimeRange reads the optional ?from= and ?to= bounds (RFC 3339) and ?by=, the time they apply to: the sensor's
+// timestamp (default) or ingestedAt, when the database stored the reading. It returns nil if no bound is given
+func parseTimeRange(req *http.Request) (*types.TimeRange, *http.Response) {
+	field, err := types.ParseTimeField(req.Query["by"])
+	if err != nil {
+		return nil, http.CreateErrorResponse(http.StatusBadRequest, "invalid_time_range", fmt.Sprintf("Invalid by: %v", err))
+	}
+
+	timeRange := &types.TimeRange{Field: field}
+	for name, bound := range map[string]*time.Time{"from": &timeRange.From, "to": &timeRange.To} {
+		value, ok := req.Query[name]
+		if !ok {
+			continue
+		}
+		if *bound, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return nil, http.CreateErrorResponse(http.StatusBadRequest, "invalid_time_range", fmt.Sprintf("Invalid %s: %v", name, err))
+		}
+	}
+
+	if timeRange.From.IsZero() && timeRange.To.IsZero() {
+		return nil, nil
+	}
+	return timeRange, nil
+}
+
+// getDataByIds handles GET /data?ids=... with a comma separated list of sensor IDs, optionally restricted to a time
+// range and projected to fields, with the values written in format
+func getDataByIds(reader dataReader, ids string, fields []string, timeRange *types.TimeRange, format types.ValueFormat) *http.Response {
+	var sensorIDs []string
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			sensorIDs = append(sensorIDs, id)
+		}
+	}
+
+	if len(sensorIDs) == 0 {
+		return http.CreateErrorResponse(http.StatusBadRequest, "missing_sensor_ids", "Missing sensor IDs")
+	}
+	if len(sensorIDs) > database.MaxSensorIdsPerRequest {
+		return http.CreateErrorResponse(http.StatusBadRequest, "too_many_sensor_ids", fmt.Sprintf("Too many sensor IDs: %d (max %d)", len(sensorIDs), database.MaxSensorIdsPerRequest))
+	}
+
+	groups, err := reader.GetDataPointsByIds(sensorIDs)
+	if err != nil {
+		logging.Warnf("Error retrieving data for %d sensors: %v", len(sensorIDs), err)
+		return retrievalErrorResponse(err)
+	}
+
+	projected := make(map[string]any, len(groups))
+	for sensorID, list := range groups {
+		if timeRange != nil {
+			list = timeRange.Filter(list)
+		}
+		projected[sensorID] = projectSensorData(list, fields, format)
+	}
+
+	jsonData, err := json.Marshal(projected)
+	if err != nil {
+		logging.Warnf("Error marshaling data to JSON: %v", err)
+		return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+	}
+
+	return http.CreateJSONResponse(http.StatusOK, jsonData)
+}
+
+// importBatchSize is the number of CSV rows stored per 2PC transaction during an import
+const importBatchSize = 500
+
+// postData handles POST /data: the body holds a single reading or a JSON array of readings, which are validated and
+// stored together in one 2PC transaction
+func postData(tpcClient *database.TwoPhaseCommitClient, req *http.Request) *http.Response {
+	//the body holds either a single reading or a burst of readings as a JSON array
+	readings, err := decodeSensorDataStrict(req.Body)
+	if err != nil {
+		logging.Warnf("Error parsing sensor data: %v", err)
+		return http.CreateErrorResponse(http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON: %v", err))
+	}
+
+	if len(readings) == 0 {
+		return http.CreateErrorResponse(http.StatusBadRequest, "empty_data", "Empty sensor data array")
+	}
+
+	//validate the data received before storing anything, a missing timestamp becomes the current time
+	for i, reading := range readings {
+		readings[i], err = types.NewSensorData(reading.SensorID, reading.Value, types.WithUnit(reading.Unit), types.WithTimestamp(reading.Timestamp))
+		if err != nil {
+			return invalidSensorDataResponse(err)
+		}
+	}
+
+	//POST /data?dryrun=true only checks that all databases are reachable and vote yes
+	dryRun := req.Query["dryrun"] == "true" || tpcClient.DryRun()
+
+	//store the data using Two-Phase Commit across both databases, all readings in one transaction, so a failed request
+	//stored none of them and can be retried as a whole
+	var sequence uint64
+	if dryRun {
+		err = tpcClient.DryRunBatchTwoPhaseCommit(req.Context(), readings)
+	} else {
+		sequence, err = tpcClient.AddDataPointsWithSequenceContext(req.Context(), readings)
+	}
+	if err != nil {
+		logging.Warnf("Error storing data with 2PC: %v", err)
+		return storageErrorResponse(err, fmt.Sprintf("Error storing data: %v", err))
+	}
+
+	if dryRun {
+		logging.Debugf("Dry run for %d data points succeeded, nothing was stored", len(readings))
+	} else {
+		for _, sensorData := range readings {
+			logging.Debugf(
+				"Stored data from sensor %s: %.2f %s using 2PC",
+				sensorData.SensorID,
+				sensorData.Value,
+				sensorData.Unit,
+			)
+		}
+	}
+
+	resp := http.NewResponse(http.StatusOK)
+	if dryRun {
+		resp.SetBodyString(fmt.Sprintf("Dry run: all databases voted yes for %d data points, nothing was stored", len(readings)))
+	} else if len(readings) == 1 {
+		resp.SetBodyString("Data stored successfully using Two-Phase Commit")
+	} else {
+		resp.SetBodyString(fmt.Sprintf("%d data points stored successfully using Two-Phase Commit", len(readings)))
+	}
+	if sequence > 0 {
+		resp.SetHeader(SessionSequenceHeader, strconv.FormatUint(sequence, 10))
+	}
+	return resp
+}
+
+// importCSV handles POST /data/import: every uploaded file is parsed as CSV (sensorId,timestamp,value,unit)
+// before anything is stored, then the rows are stored in batches using 2PC
+func importCSV(tpcClient *database.TwoPhaseCommitClient, req *http.Request) *http.Response {
+	files, err := req.MultipartFiles()
+	if err != nil {
+		return http.CreateErrorResponse(http.StatusBadRequest, "invalid_upload", fmt.Sprintf("Invalid upload: %v", err))
+	}
+	if len(files) == 0 {
+		return http.CreateErrorResponse(http.StatusBadRequest, "missing_file", "No CSV file uploaded")
+	}
+
+	var readings []types.SensorData
+	for _, file := range files {
+		rows, err := types.DecodeSensorDataCSV(bytes.NewReader(file.Content))
+		if err != nil {
+			return http.CreateErrorResponse(http.StatusBadRequest, "invalid_csv", fmt.Sprintf("Invalid CSV in %s: %v", file.FileName, err))
+		}
+		readings = append(readings, rows...)
+	}
+
+	now := time.Now()
+	for i := range readings {
+		if readings[i].Timestamp.IsZero() {
+			readings[i].Timestamp = now
+		}
+	}
+
+	imported := 0
+	for start := 0; start < len(readings); start += importBatchSize {
+		batch := readings[start:min(start+importBatchSize, len(readings))]
+		if err := tpcClient.AddDataPointsWithTwoPhaseCommitContext(req.Context(), batch); err != nil {
+			logging.Warnf("Error importing CSV batch with 2PC: %v", err)
+			return storageErrorResponse(err, fmt.Sprintf("Error storing data after %d of %d rows: %v", imported, len(readings), err))
+		}
+		imported += len(batch)
+	}
+
+	log.Printf("Imported %d readings from %d CSV file(s) using 2PC", imported, len(files))
+
+	jsonData, err := json.Marshal(map[string]int{"imported": imported, "files": len(files)})
+	if err != nil {
+		return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+	}
+
+	return http.CreateJSONResponse(http.StatusOK, jsonData)
+}
+
+// registerRouteListing registers GET /_routes, which lists every registered handler as JSON; the list is built per
+// request, so handlers registered after this one are listed as well
+func registerRouteListing(server *http.Server) {
+	server.RegisterHandler(
+		http.GET,
+		"/_routes",
+		func(req *http.Request) *http.Response {
+			jsonData, err := json.Marshal(server.Routes())
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+			}
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		},
+	)
+}
+
+// registerAdminHandlers registers the operator endpoints, all guarded by basic auth
+func registerAdminHandlers(server *http.Server, tpcClient *database.TwoPhaseCommitClient, adminUser, adminPassword string) {
+	//force every database to write its snapshot to disk, e.g. before maintenance
+	server.RegisterHandler(
+		http.POST,
+		"/admin/flush",
+		http.BasicAuth(adminUser, adminPassword, func(req *http.Request) *http.Response {
+			results := tpcClient.FlushAll()
+
+			statusCode := http.StatusOK
+			for _, result := range results {
+				if !result.Success {
+					statusCode = http.StatusServerError
+				}
+			}
+
+			jsonData, err := json.Marshal(map[string]interface{}{
+				"replicas": results,
+			})
+			if err != nil {
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Error marshaling results: %v", err))
+			}
+
+			return http.CreateJSONResponse(statusCode, jsonData)
+		}),
+	)
+
+	//manual 2PC: run phase 1 only and leave the transaction prepared, e.g. to observe a half-committed state
+	server.RegisterHandler(
+		http.POST,
+		"/admin/txn/prepare",
+		http.BasicAuth(adminUser, adminPassword, func(req *http.Request) *http.Response {
+			var received types.SensorData
+			if err := decodeStrict(req.Body, &received); err != nil {
+				return http.CreateErrorResponse(http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON: %v", err))
+			}
+			sensorData, err := types.NewSensorData(received.SensorID, received.Value, types.WithUnit(received.Unit), types.WithTimestamp(received.Timestamp))
+			if err != nil {
+				return invalidSensorDataResponse(err)
+			}
+
+			transactionID, votes := tpcClient.ManualPrepare(sensorData)
+
+			prepared := true
+			for _, vote := range votes {
+				prepared = prepared && vote.Success
+			}
+
+			return replicaOutcomesResponse(http.StatusOK, map[string]interface{}{
+				"transactionId": transactionID,
+				"prepared":      prepared,
+				"replicas":      votes,
+			})
+		}),
+	)
+
+	//manual 2PC: POST /admin/txn/{id}/commit and POST /admin/txn/{id}/abort run phase 2 of a prepared transaction
+	server.RegisterHandler(
+		http.POST,
+		"/admin/txn/*",
+		http.BasicAuth(adminUser, adminPassword, func(req *http.Request) *http.Response {
+			transactionID, action, ok := strings.Cut(strings.TrimPrefix(req.Path, "/admin/txn/"), "/")
+			if !ok || transactionID == "" {
+				return http.CreateErrorResponse(http.StatusNotFound, "not_found", fmt.Sprintf("No handler for %s %s", req.Method, req.Path))
+			}
+
+			var outcomes []database.ReplicaOutcome
+			switch action {
+			case "commit":
+				outcomes = tpcClient.ManualCommit(transactionID)
+			case "abort":
+				outcomes = tpcClient.ManualAbort(transactionID)
+			default:
+				return http.CreateErrorResponse(http.StatusNotFound, "unknown_action", fmt.Sprintf("Unknown transaction action %q (expected commit or abort)", action))
+			}
+
+			statusCode := http.StatusOK
+			for _, outcome := range outcomes {
+				if !outcome.Success {
+					statusCode = http.StatusServerError
+				}
+			}
+
+			return replicaOutcomesResponse(statusCode, map[string]interface{}{
+				"transactionId": transactionID,
+				"action":        action,
+				"replicas":      outcomes,
+			})
+		}),
+	)
+}
+
+// replicaOutcomesResponse marshals the result of a manual 2PC phase into a JSON response
+func replicaOutcomesResponse(statusCode int, result map[string]interface{}) *http.Response {
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Error marshaling results: %v", err))
+	}
+
+	return http.CreateJSONResponse(statusCode, jsonData)
+}
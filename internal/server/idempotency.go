@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/sha256"
+	"maps"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+)
+
+// IdempotencyKeyHeader lets a client retry a POST /data safely: a repeat with the same key gets the response of the
+// first request instead of storing the readings again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set to true on a response that was replayed for a repeated idempotency key
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// idempotencyEntry is the outcome of the first request with a key; done is closed once response is set, or once the
+// request stored nothing and the key was released
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	response    *http.Response
+	expires     time.Time
+}
+
+// idempotencyStore remembers the responses of requests that may have stored data by idempotency key for ttl. At most
+// maxKeys keys are kept, the oldest one is evicted first
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	order   []string //keys in insertion order, used for expiry and eviction
+	maxKeys int
+	ttl     time.Duration
+}
+
+// idempotencyStoreFactory creates an empty store, nil if ttl or maxKeys is not positive, which disables the keys
+func idempotencyStoreFactory(maxKeys int, ttl time.Duration) *idempotencyStore {
+	if maxKeys <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &idempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+		maxKeys: maxKeys,
+		ttl:     ttl,
+	}
+}
+
+// do runs handle once per key: a repeat gets a copy of the first response, a repeat while the first request is still
+// running waits for it, and a repeat after a request that stored nothing runs handle again. Reusing a key for a
+// different request is rejected
+func (s *idempotencyStore) do(key string, req *http.Request, handle func() *http.Response) *http.Response {
+	fingerprint := requestFingerprint(req)
+
+	for {
+		s.mu.Lock()
+		s.expire()
+		entry, ok := s.entries[key]
+		if !ok {
+			entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+			s.add(key, entry)
+			s.mu.Unlock()
+			return s.run(key, entry, handle)
+		}
+		s.mu.Unlock()
+
+		if entry.fingerprint != fingerprint {
+			return http.CreateErrorResponse(http.StatusUnprocessable, "idempotency_key_reused", "The idempotency key was already used for a different request")
+		}
+
+		<-entry.done
+		if entry.response != nil {
+			return replayResponse(entry.response)
+		}
+		//the first request stored nothing and released the key, so this one is a fresh attempt
+	}
+}
+
+// run calls handle for the first request with a key and keeps the response unless it shows that nothing was stored. A
+// 500 is kept as well, the commit may have reached a database, so a retry must not store the readings a second time
+func (s *idempotencyStore) run(key string, entry *idempotencyEntry, handle func() *http.Response) *http.Response {
+	resp := handle()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !nothingStored(resp) {
+		//keep our own copy, the server may still compress the response it gets back
+		entry.response = copyResponse(resp)
+		entry.expires = time.Now().Add(s.ttl)
+	} else if s.entries[key] == entry {
+		//a retry must get the chance to try again
+		s.remove(key)
+	}
+	close(entry.done)
+	return resp
+}
+
+// nothingStored reports whether a response guarantees that the request stored nothing: a 4xx was refused before the
+// commit and a 503 was refused by an overloaded database, a standby server or the shutdown
+func nothingStored(resp *http.Response) bool {
+	return (resp.StatusCode >= 400 && resp.StatusCode < 500) || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// add stores a new key, evicting the oldest keys when the store is full; the caller must hold the lock
+func (s *idempotencyStore) add(key string, entry *idempotencyEntry) {
+	for len(s.order) >= s.maxKeys {
+		s.remove(s.order[0])
+	}
+	s.entries[key] = entry
+	s.order = append(s.order, key)
+}
+
+// expire drops the keys whose response is older than the ttl; all keys live equally long, so they expire in
+// insertion order. Keys of requests still running have no expiry yet and stop the scan. The caller must hold the lock
+func (s *idempotencyStore) expire() {
+	now := time.Now()
+	for len(s.order) > 0 {
+		entry := s.entries[s.order[0]]
+		if entry.response == nil || now.Before(entry.expires) {
+			return
+		}
+		s.remove(s.order[0])
+	}
+}
+
+// remove drops a key, the caller must hold the lock
+func (s *idempotencyStore) remove(key string) {
+	delete(s.entries, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// requestFingerprint identifies what a request asks for, so a key reused for other readings is noticed
+func requestFingerprint(req *http.Request) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(req.RawQuery))
+	h.Write([]byte{0})
+	h.Write(req.Body)
+
+	var fingerprint [sha256.Size]byte
+	copy(fingerprint[:], h.Sum(nil))
+	return fingerprint
+}
+
+// copyResponse copies a response including its headers, the body is never modified in place
+func copyResponse(resp *http.Response) *http.Response {
+	copied := *resp
+	copied.Headers = maps.Clone(resp.Headers)
+	copied.MultiHeaders = maps.Clone(resp.MultiHeaders)
+	return &copied
+}
+
+// replayResponse returns a copy of a remembered response marked as replayed
+func replayResponse(resp *http.Response) *http.Response {
+	replayed := copyResponse(resp)
+	replayed.SetHeader(IdempotentReplayHeader, "true")
+	return replayed
+}
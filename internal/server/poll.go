@@ -0,0 +1,179 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second //how long GET /data/poll waits without ?timeout=
+	maxPollTimeout     = 2 * time.Minute
+	//writes through other servers or directly to the databases do not wake a poll, it looks for them this often
+	pollRecheckInterval = time.Second
+)
+
+// storeNotifier wakes the long polls waiting for new data when a write through this server was stored
+type storeNotifier struct {
+	mutex  sync.Mutex
+	stored chan struct{} //closed and replaced by every notify
+}
+
+// storeNotifierFactory creates a notifier nobody waits on yet
+func storeNotifierFactory() *storeNotifier {
+	return &storeNotifier{stored: make(chan struct{})}
+}
+
+// wait returns a channel that is closed by the next notify; take it before looking for data, so a write in between
+// is not missed
+func (n *storeNotifier) wait() <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.stored
+}
+
+// notify wakes everyone waiting
+func (n *storeNotifier) notify() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	close(n.stored)
+	n.stored = make(chan struct{})
+}
+
+// pollReplica is the database the long polls read from; store sequences only agree within one replica, so every
+// poll reads from the same one, the first database like the default read strategy
+const pollReplica = 0
+
+// pollFetch is one read of all data shared by the polls waiting for it; data must not be modified
+type pollFetch struct {
+	done chan struct{} //closed once data and err are set
+	data []types.SensorData
+	err  error
+}
+
+// pollFeed shares the reads of the waiting polls: at most one read runs at a time and all polls asking meanwhile
+// share the next one, which starts after they asked and so sees every write stored before
+type pollFeed struct {
+	mutex   sync.Mutex
+	read    func() ([]types.SensorData, error)
+	running *pollFetch //the read in progress or the last one
+	queued  *pollFetch //the read starting once running is done
+}
+
+// pollFeedFactory creates a feed that reads with read
+func pollFeedFactory(read func() ([]types.SensorData, error)) *pollFeed {
+	return &pollFeed{read: read}
+}
+
+// fetch returns all data read after the call began, sharing the read with the other polls asking at the same time
+func (f *pollFeed) fetch() ([]types.SensorData, error) {
+	f.mutex.Lock()
+	fetch := f.queued
+	if fetch == nil {
+		fetch = &pollFetch{done: make(chan struct{})}
+		f.queued = fetch
+		go f.run(fetch, f.running)
+	}
+	f.mutex.Unlock()
+
+	<-fetch.done
+	return fetch.data, fetch.err
+}
+
+// run reads for a queued fetch once the previous read is done
+func (f *pollFeed) run(fetch, previous *pollFetch) {
+	if previous != nil {
+		<-previous.done
+	}
+	f.mutex.Lock()
+	f.queued = nil
+	f.running = fetch
+	f.mutex.Unlock()
+
+	fetch.data, fetch.err = f.read()
+	close(fetch.done)
+}
+
+// pollData answers GET /data/poll?after=<sequence>&timeout=<duration>: it returns the readings the poll replica stored
+// with a store sequence above after as soon as there are any, or 204 once the timeout passed or the server is shutting
+// down. Without after only readings stored from now on are returned. Readings are sorted by sequence, so the sequence
+// of the last one is the after of the next poll
+func pollData(feed *pollFeed, notifier *storeNotifier, closing <-chan struct{}, req *http.Request, format types.ValueFormat) *http.Response {
+	var after uint64
+	value, hasAfter := req.Query["after"]
+	if hasAfter {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return http.CreateErrorResponse(http.StatusBadRequest, "invalid_after", fmt.Sprintf("Invalid after %q, expected a store sequence like 42", value))
+		}
+	}
+
+	timeout := defaultPollTimeout
+	if value, ok := req.Query["timeout"]; ok {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 || timeout > maxPollTimeout {
+			return http.CreateErrorResponse(http.StatusBadRequest, "invalid_timeout", fmt.Sprintf("Invalid timeout %q, expected a duration up to %v like 30s", value, maxPollTimeout))
+		}
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for first := true; ; first = false {
+		stored := notifier.wait()
+
+		allData, err := feed.fetch()
+		if err != nil {
+			logging.Warnf("Error retrieving data for a poll: %v", err)
+			return retrievalErrorResponse(err)
+		}
+		if first && !hasAfter {
+			//start after everything the replica stored so far
+			for _, d := range allData {
+				after = max(after, d.Sequence)
+			}
+		} else if newer := storedAfter(allData, after); len(newer) > 0 {
+			jsonData, err := json.Marshal(projectSensorData(newer, nil, format))
+			if err != nil {
+				logging.Warnf("Error marshaling data to JSON: %v", err)
+				return http.CreateErrorResponse(http.StatusServerError, "internal_error", fmt.Sprintf("Server error: %v", err))
+			}
+			return http.CreateJSONResponse(http.StatusOK, jsonData)
+		}
+
+		//all polls recheck at the same moments, so their reads are shared as well
+		recheck := time.NewTimer(time.Until(time.Now().Truncate(pollRecheckInterval).Add(pollRecheckInterval)))
+		select {
+		case <-stored:
+		case <-recheck.C:
+		case <-deadline.C:
+			recheck.Stop()
+			return http.NewResponse(http.StatusNoContent)
+		case <-closing:
+			recheck.Stop()
+			return http.NewResponse(http.StatusNoContent)
+		}
+		recheck.Stop()
+	}
+}
+
+// storedAfter returns the readings with a store sequence above after sorted by it, without changing data
+func storedAfter(data []types.SensorData, after uint64) []types.SensorData {
+	var newer []types.SensorData
+	for _, d := range data {
+		if d.Sequence > after {
+			newer = append(newer, d)
+		}
+	}
+	slices.SortStableFunc(newer, func(a, b types.SensorData) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
+	return newer
+}
@@ -22,6 +22,59 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Operation applied when a transaction is committed
+type TransactionOperation int32
+
+const (
+	TransactionOperation_TRANSACTION_OPERATION_ADD        TransactionOperation = 0 // add sensor_data
+	TransactionOperation_TRANSACTION_OPERATION_DELETE_ALL TransactionOperation = 1 // remove all stored data, sensor_data is ignored
+	TransactionOperation_TRANSACTION_OPERATION_ADD_BATCH  TransactionOperation = 2 // add every reading in batch, sensor_data is ignored
+	TransactionOperation_TRANSACTION_OPERATION_PATCH      TransactionOperation = 3 // apply patch to a stored point, sensor_data is ignored
+)
+
+// Enum value maps for TransactionOperation.
+var (
+	TransactionOperation_name = map[int32]string{
+		0: "TRANSACTION_OPERATION_ADD",
+		1: "TRANSACTION_OPERATION_DELETE_ALL",
+		2: "TRANSACTION_OPERATION_ADD_BATCH",
+		3: "TRANSACTION_OPERATION_PATCH",
+	}
+	TransactionOperation_value = map[string]int32{
+		"TRANSACTION_OPERATION_ADD":        0,
+		"TRANSACTION_OPERATION_DELETE_ALL": 1,
+		"TRANSACTION_OPERATION_ADD_BATCH":  2,
+		"TRANSACTION_OPERATION_PATCH":      3,
+	}
+)
+
+func (x TransactionOperation) Enum() *TransactionOperation {
+	p := new(TransactionOperation)
+	*p = x
+	return p
+}
+
+func (x TransactionOperation) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TransactionOperation) Descriptor() protoreflect.EnumDescriptor {
+	return file_pkg_rpc_database_proto_enumTypes[0].Descriptor()
+}
+
+func (TransactionOperation) Type() protoreflect.EnumType {
+	return &file_pkg_rpc_database_proto_enumTypes[0]
+}
+
+func (x TransactionOperation) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TransactionOperation.Descriptor instead.
+func (TransactionOperation) EnumDescriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{0}
+}
+
 // Message for sensor data
 type SensorDataRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -29,6 +82,8 @@ type SensorDataRequest struct {
 	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	Value         float64                `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"`
 	Unit          string                 `protobuf:"bytes,4,opt,name=unit,proto3" json:"unit,omitempty"`
+	IngestedAt    *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=ingested_at,json=ingestedAt,proto3" json:"ingested_at,omitempty"` // set by the database when storing, ignored in requests
+	Sequence      uint64                 `protobuf:"varint,6,opt,name=sequence,proto3" json:"sequence,omitempty"`                      // store sequence assigned by the database when storing, ignored in requests
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -91,18 +146,103 @@ func (x *SensorDataRequest) GetUnit() string {
 	return ""
 }
 
-// response for all the operations
-type OperationResponse struct {
+func (x *SensorDataRequest) GetIngestedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.IngestedAt
+	}
+	return nil
+}
+
+func (x *SensorDataRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+// a partial update of the point with sensor_id and timestamp, fields that are not set keep their stored value
+type SensorDataPatch struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
-	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value         *float64               `protobuf:"fixed64,3,opt,name=value,proto3,oneof" json:"value,omitempty"`
+	Unit          *string                `protobuf:"bytes,4,opt,name=unit,proto3,oneof" json:"unit,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
+func (x *SensorDataPatch) Reset() {
+	*x = SensorDataPatch{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SensorDataPatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SensorDataPatch) ProtoMessage() {}
+
+func (x *SensorDataPatch) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SensorDataPatch.ProtoReflect.Descriptor instead.
+func (*SensorDataPatch) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SensorDataPatch) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *SensorDataPatch) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *SensorDataPatch) GetValue() float64 {
+	if x != nil && x.Value != nil {
+		return *x.Value
+	}
+	return 0
+}
+
+func (x *SensorDataPatch) GetUnit() string {
+	if x != nil && x.Unit != nil {
+		return *x.Unit
+	}
+	return ""
+}
+
+// response for all the operations
+type OperationResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Success         bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message         string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	PointsAffected  int64                  `protobuf:"varint,3,opt,name=points_affected,json=pointsAffected,proto3" json:"points_affected,omitempty"`
+	AppliedSequence uint64                 `protobuf:"varint,4,opt,name=applied_sequence,json=appliedSequence,proto3" json:"applied_sequence,omitempty"` // highest commit sequence applied by the database (commit only)
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
 func (x *OperationResponse) Reset() {
 	*x = OperationResponse{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[1]
+	mi := &file_pkg_rpc_database_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -114,7 +254,7 @@ func (x *OperationResponse) String() string {
 func (*OperationResponse) ProtoMessage() {}
 
 func (x *OperationResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[1]
+	mi := &file_pkg_rpc_database_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -127,7 +267,7 @@ func (x *OperationResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use OperationResponse.ProtoReflect.Descriptor instead.
 func (*OperationResponse) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{1}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *OperationResponse) GetSuccess() bool {
@@ -144,6 +284,20 @@ func (x *OperationResponse) GetMessage() string {
 	return ""
 }
 
+func (x *OperationResponse) GetPointsAffected() int64 {
+	if x != nil {
+		return x.PointsAffected
+	}
+	return 0
+}
+
+func (x *OperationResponse) GetAppliedSequence() uint64 {
+	if x != nil {
+		return x.AppliedSequence
+	}
+	return 0
+}
+
 // a collection of sensor data points
 type SensorDataList struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -154,7 +308,7 @@ type SensorDataList struct {
 
 func (x *SensorDataList) Reset() {
 	*x = SensorDataList{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[2]
+	mi := &file_pkg_rpc_database_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -166,7 +320,7 @@ func (x *SensorDataList) String() string {
 func (*SensorDataList) ProtoMessage() {}
 
 func (x *SensorDataList) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[2]
+	mi := &file_pkg_rpc_database_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -179,7 +333,7 @@ func (x *SensorDataList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SensorDataList.ProtoReflect.Descriptor instead.
 func (*SensorDataList) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{2}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *SensorDataList) GetData() []*SensorDataRequest {
@@ -198,7 +352,7 @@ type EmptyRequest struct {
 
 func (x *EmptyRequest) Reset() {
 	*x = EmptyRequest{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[3]
+	mi := &file_pkg_rpc_database_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -210,7 +364,7 @@ func (x *EmptyRequest) String() string {
 func (*EmptyRequest) ProtoMessage() {}
 
 func (x *EmptyRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[3]
+	mi := &file_pkg_rpc_database_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -223,7 +377,7 @@ func (x *EmptyRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use EmptyRequest.ProtoReflect.Descriptor instead.
 func (*EmptyRequest) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{3}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{4}
 }
 
 // a request but with sensor ID included
@@ -236,7 +390,7 @@ type SensorIdRequest struct {
 
 func (x *SensorIdRequest) Reset() {
 	*x = SensorIdRequest{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[4]
+	mi := &file_pkg_rpc_database_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -248,7 +402,7 @@ func (x *SensorIdRequest) String() string {
 func (*SensorIdRequest) ProtoMessage() {}
 
 func (x *SensorIdRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[4]
+	mi := &file_pkg_rpc_database_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -261,7 +415,7 @@ func (x *SensorIdRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SensorIdRequest.ProtoReflect.Descriptor instead.
 func (*SensorIdRequest) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{4}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *SensorIdRequest) GetSensorId() string {
@@ -271,19 +425,157 @@ func (x *SensorIdRequest) GetSensorId() string {
 	return ""
 }
 
+// a request for all sensors whose ID starts with the prefix
+type SensorPrefixRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SensorPrefixRequest) Reset() {
+	*x = SensorPrefixRequest{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SensorPrefixRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SensorPrefixRequest) ProtoMessage() {}
+
+func (x *SensorPrefixRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SensorPrefixRequest.ProtoReflect.Descriptor instead.
+func (*SensorPrefixRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SensorPrefixRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+// a request for several specific sensors at once
+type SensorIdsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SensorIds     []string               `protobuf:"bytes,1,rep,name=sensor_ids,json=sensorIds,proto3" json:"sensor_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SensorIdsRequest) Reset() {
+	*x = SensorIdsRequest{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SensorIdsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SensorIdsRequest) ProtoMessage() {}
+
+func (x *SensorIdsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SensorIdsRequest.ProtoReflect.Descriptor instead.
+func (*SensorIdsRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SensorIdsRequest) GetSensorIds() []string {
+	if x != nil {
+		return x.SensorIds
+	}
+	return nil
+}
+
+// data grouped by sensor ID, every requested ID has a (possibly empty) group
+type SensorDataGroups struct {
+	state         protoimpl.MessageState     `protogen:"open.v1"`
+	Groups        map[string]*SensorDataList `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SensorDataGroups) Reset() {
+	*x = SensorDataGroups{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SensorDataGroups) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SensorDataGroups) ProtoMessage() {}
+
+func (x *SensorDataGroups) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SensorDataGroups.ProtoReflect.Descriptor instead.
+func (*SensorDataGroups) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SensorDataGroups) GetGroups() map[string]*SensorDataList {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
 // additions for 3.5
 // Transaction request containing both transaction ID and sensor data
 type TransactionRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	TransactionId string                 `protobuf:"bytes,1,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
 	SensorData    *SensorDataRequest     `protobuf:"bytes,2,opt,name=sensor_data,json=sensorData,proto3" json:"sensor_data,omitempty"`
+	Operation     TransactionOperation   `protobuf:"varint,3,opt,name=operation,proto3,enum=database.TransactionOperation" json:"operation,omitempty"`
+	Batch         []*SensorDataRequest   `protobuf:"bytes,4,rep,name=batch,proto3" json:"batch,omitempty"` // readings of an ADD_BATCH transaction
+	Patch         *SensorDataPatch       `protobuf:"bytes,5,opt,name=patch,proto3" json:"patch,omitempty"` // partial update of a PATCH transaction
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *TransactionRequest) Reset() {
 	*x = TransactionRequest{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[5]
+	mi := &file_pkg_rpc_database_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -295,7 +587,7 @@ func (x *TransactionRequest) String() string {
 func (*TransactionRequest) ProtoMessage() {}
 
 func (x *TransactionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[5]
+	mi := &file_pkg_rpc_database_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -308,7 +600,7 @@ func (x *TransactionRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TransactionRequest.ProtoReflect.Descriptor instead.
 func (*TransactionRequest) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{5}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *TransactionRequest) GetTransactionId() string {
@@ -325,19 +617,43 @@ func (x *TransactionRequest) GetSensorData() *SensorDataRequest {
 	return nil
 }
 
+func (x *TransactionRequest) GetOperation() TransactionOperation {
+	if x != nil {
+		return x.Operation
+	}
+	return TransactionOperation_TRANSACTION_OPERATION_ADD
+}
+
+func (x *TransactionRequest) GetBatch() []*SensorDataRequest {
+	if x != nil {
+		return x.Batch
+	}
+	return nil
+}
+
+func (x *TransactionRequest) GetPatch() *SensorDataPatch {
+	if x != nil {
+		return x.Patch
+	}
+	return nil
+}
+
 // Response for prepare phase with success/failure status
 type PrepareResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
 	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
 	TransactionId string                 `protobuf:"bytes,3,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	Overloaded    bool                   `protobuf:"varint,4,opt,name=overloaded,proto3" json:"overloaded,omitempty"`             // the no-vote is temporary, the database is at capacity
+	NotFound      bool                   `protobuf:"varint,5,opt,name=not_found,json=notFound,proto3" json:"not_found,omitempty"` // the no-vote is because the point to patch is not stored
+	Rejected      bool                   `protobuf:"varint,6,opt,name=rejected,proto3" json:"rejected,omitempty"`                 // the no-vote is because the data failed the validation of the database, see message
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PrepareResponse) Reset() {
 	*x = PrepareResponse{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[6]
+	mi := &file_pkg_rpc_database_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -349,7 +665,7 @@ func (x *PrepareResponse) String() string {
 func (*PrepareResponse) ProtoMessage() {}
 
 func (x *PrepareResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[6]
+	mi := &file_pkg_rpc_database_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -362,7 +678,7 @@ func (x *PrepareResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PrepareResponse.ProtoReflect.Descriptor instead.
 func (*PrepareResponse) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{6}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *PrepareResponse) GetSuccess() bool {
@@ -386,17 +702,39 @@ func (x *PrepareResponse) GetTransactionId() string {
 	return ""
 }
 
+func (x *PrepareResponse) GetOverloaded() bool {
+	if x != nil {
+		return x.Overloaded
+	}
+	return false
+}
+
+func (x *PrepareResponse) GetNotFound() bool {
+	if x != nil {
+		return x.NotFound
+	}
+	return false
+}
+
+func (x *PrepareResponse) GetRejected() bool {
+	if x != nil {
+		return x.Rejected
+	}
+	return false
+}
+
 // Transaction ID message for commit/abort operations
 type TransactionId struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	TransactionId string                 `protobuf:"bytes,1,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,2,opt,name=sequence,proto3" json:"sequence,omitempty"` // commit sequence assigned by the coordinator, 0 if none (commit only)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *TransactionId) Reset() {
 	*x = TransactionId{}
-	mi := &file_pkg_rpc_database_proto_msgTypes[7]
+	mi := &file_pkg_rpc_database_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -408,7 +746,7 @@ func (x *TransactionId) String() string {
 func (*TransactionId) ProtoMessage() {}
 
 func (x *TransactionId) ProtoReflect() protoreflect.Message {
-	mi := &file_pkg_rpc_database_proto_msgTypes[7]
+	mi := &file_pkg_rpc_database_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -421,7 +759,7 @@ func (x *TransactionId) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TransactionId.ProtoReflect.Descriptor instead.
 func (*TransactionId) Descriptor() ([]byte, []int) {
-	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{7}
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *TransactionId) GetTransactionId() string {
@@ -431,43 +769,336 @@ func (x *TransactionId) GetTransactionId() string {
 	return ""
 }
 
+func (x *TransactionId) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+// Highest commit sequence a database has applied
+type SequenceResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	AppliedSequence uint64                 `protobuf:"varint,1,opt,name=applied_sequence,json=appliedSequence,proto3" json:"applied_sequence,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *SequenceResponse) Reset() {
+	*x = SequenceResponse{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SequenceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SequenceResponse) ProtoMessage() {}
+
+func (x *SequenceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SequenceResponse.ProtoReflect.Descriptor instead.
+func (*SequenceResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SequenceResponse) GetAppliedSequence() uint64 {
+	if x != nil {
+		return x.AppliedSequence
+	}
+	return 0
+}
+
+// Response for a manual flush with the number of points written and the snapshot file
+type FlushResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	PointsWritten int64                  `protobuf:"varint,3,opt,name=points_written,json=pointsWritten,proto3" json:"points_written,omitempty"`
+	FilePath      string                 `protobuf:"bytes,4,opt,name=file_path,json=filePath,proto3" json:"file_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FlushResponse) Reset() {
+	*x = FlushResponse{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlushResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlushResponse) ProtoMessage() {}
+
+func (x *FlushResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlushResponse.ProtoReflect.Descriptor instead.
+func (*FlushResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *FlushResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *FlushResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *FlushResponse) GetPointsWritten() int64 {
+	if x != nil {
+		return x.PointsWritten
+	}
+	return 0
+}
+
+func (x *FlushResponse) GetFilePath() string {
+	if x != nil {
+		return x.FilePath
+	}
+	return ""
+}
+
+// Request to acquire, renew or release the coordinator lease
+type LeaseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Holder        string                 `protobuf:"bytes,1,opt,name=holder,proto3" json:"holder,omitempty"`             // ID of the coordinator asking
+	TtlMs         int64                  `protobuf:"varint,2,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"` // how long the lease is granted for, ignored on release
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LeaseRequest) Reset() {
+	*x = LeaseRequest{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LeaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaseRequest) ProtoMessage() {}
+
+func (x *LeaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaseRequest.ProtoReflect.Descriptor instead.
+func (*LeaseRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *LeaseRequest) GetHolder() string {
+	if x != nil {
+		return x.Holder
+	}
+	return ""
+}
+
+func (x *LeaseRequest) GetTtlMs() int64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+// Current state of the coordinator lease after a request
+type LeaseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Granted       bool                   `protobuf:"varint,1,opt,name=granted,proto3" json:"granted,omitempty"`                            // the lease is (still) held by the asking coordinator; for a release, it was given up
+	Holder        string                 `protobuf:"bytes,2,opt,name=holder,proto3" json:"holder,omitempty"`                               // holder of the lease after the request, empty if free
+	RemainingMs   int64                  `protobuf:"varint,3,opt,name=remaining_ms,json=remainingMs,proto3" json:"remaining_ms,omitempty"` // time until the lease expires unless renewed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LeaseResponse) Reset() {
+	*x = LeaseResponse{}
+	mi := &file_pkg_rpc_database_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LeaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaseResponse) ProtoMessage() {}
+
+func (x *LeaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_rpc_database_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaseResponse.ProtoReflect.Descriptor instead.
+func (*LeaseResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_rpc_database_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *LeaseResponse) GetGranted() bool {
+	if x != nil {
+		return x.Granted
+	}
+	return false
+}
+
+func (x *LeaseResponse) GetHolder() string {
+	if x != nil {
+		return x.Holder
+	}
+	return ""
+}
+
+func (x *LeaseResponse) GetRemainingMs() int64 {
+	if x != nil {
+		return x.RemainingMs
+	}
+	return 0
+}
+
 var File_pkg_rpc_database_proto protoreflect.FileDescriptor
 
 const file_pkg_rpc_database_proto_rawDesc = "" +
 	"\n" +
-	"\x16pkg/rpc/database.proto\x12\bdatabase\x1a\x1fgoogle/protobuf/timestamp.proto\"\x94\x01\n" +
+	"\x16pkg/rpc/database.proto\x12\bdatabase\x1a\x1fgoogle/protobuf/timestamp.proto\"\xed\x01\n" +
 	"\x11SensorDataRequest\x12\x1b\n" +
 	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x128\n" +
 	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x14\n" +
 	"\x05value\x18\x03 \x01(\x01R\x05value\x12\x12\n" +
-	"\x04unit\x18\x04 \x01(\tR\x04unit\"G\n" +
+	"\x04unit\x18\x04 \x01(\tR\x04unit\x12;\n" +
+	"\vingested_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"ingestedAt\x12\x1a\n" +
+	"\bsequence\x18\x06 \x01(\x04R\bsequence\"\xaf\x01\n" +
+	"\x0fSensorDataPatch\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x128\n" +
+	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x19\n" +
+	"\x05value\x18\x03 \x01(\x01H\x00R\x05value\x88\x01\x01\x12\x17\n" +
+	"\x04unit\x18\x04 \x01(\tH\x01R\x04unit\x88\x01\x01B\b\n" +
+	"\x06_valueB\a\n" +
+	"\x05_unit\"\x9b\x01\n" +
 	"\x11OperationResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\"A\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12'\n" +
+	"\x0fpoints_affected\x18\x03 \x01(\x03R\x0epointsAffected\x12)\n" +
+	"\x10applied_sequence\x18\x04 \x01(\x04R\x0fappliedSequence\"A\n" +
 	"\x0eSensorDataList\x12/\n" +
 	"\x04data\x18\x01 \x03(\v2\x1b.database.SensorDataRequestR\x04data\"\x0e\n" +
 	"\fEmptyRequest\".\n" +
 	"\x0fSensorIdRequest\x12\x1b\n" +
-	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\"y\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\"-\n" +
+	"\x13SensorPrefixRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\"1\n" +
+	"\x10SensorIdsRequest\x12\x1d\n" +
+	"\n" +
+	"sensor_ids\x18\x01 \x03(\tR\tsensorIds\"\xa7\x01\n" +
+	"\x10SensorDataGroups\x12>\n" +
+	"\x06groups\x18\x01 \x03(\v2&.database.SensorDataGroups.GroupsEntryR\x06groups\x1aS\n" +
+	"\vGroupsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12.\n" +
+	"\x05value\x18\x02 \x01(\v2\x18.database.SensorDataListR\x05value:\x028\x01\"\x9b\x02\n" +
 	"\x12TransactionRequest\x12%\n" +
 	"\x0etransaction_id\x18\x01 \x01(\tR\rtransactionId\x12<\n" +
 	"\vsensor_data\x18\x02 \x01(\v2\x1b.database.SensorDataRequestR\n" +
-	"sensorData\"l\n" +
+	"sensorData\x12<\n" +
+	"\toperation\x18\x03 \x01(\x0e2\x1e.database.TransactionOperationR\toperation\x121\n" +
+	"\x05batch\x18\x04 \x03(\v2\x1b.database.SensorDataRequestR\x05batch\x12/\n" +
+	"\x05patch\x18\x05 \x01(\v2\x19.database.SensorDataPatchR\x05patch\"\xc5\x01\n" +
 	"\x0fPrepareResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\tR\amessage\x12%\n" +
-	"\x0etransaction_id\x18\x03 \x01(\tR\rtransactionId\"6\n" +
+	"\x0etransaction_id\x18\x03 \x01(\tR\rtransactionId\x12\x1e\n" +
+	"\n" +
+	"overloaded\x18\x04 \x01(\bR\n" +
+	"overloaded\x12\x1b\n" +
+	"\tnot_found\x18\x05 \x01(\bR\bnotFound\x12\x1a\n" +
+	"\brejected\x18\x06 \x01(\bR\brejected\"R\n" +
 	"\rTransactionId\x12%\n" +
-	"\x0etransaction_id\x18\x01 \x01(\tR\rtransactionId2\xf3\x04\n" +
+	"\x0etransaction_id\x18\x01 \x01(\tR\rtransactionId\x12\x1a\n" +
+	"\bsequence\x18\x02 \x01(\x04R\bsequence\"=\n" +
+	"\x10SequenceResponse\x12)\n" +
+	"\x10applied_sequence\x18\x01 \x01(\x04R\x0fappliedSequence\"\x87\x01\n" +
+	"\rFlushResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12%\n" +
+	"\x0epoints_written\x18\x03 \x01(\x03R\rpointsWritten\x12\x1b\n" +
+	"\tfile_path\x18\x04 \x01(\tR\bfilePath\"=\n" +
+	"\fLeaseRequest\x12\x16\n" +
+	"\x06holder\x18\x01 \x01(\tR\x06holder\x12\x15\n" +
+	"\x06ttl_ms\x18\x02 \x01(\x03R\x05ttlMs\"d\n" +
+	"\rLeaseResponse\x12\x18\n" +
+	"\agranted\x18\x01 \x01(\bR\agranted\x12\x16\n" +
+	"\x06holder\x18\x02 \x01(\tR\x06holder\x12!\n" +
+	"\fremaining_ms\x18\x03 \x01(\x03R\vremainingMs*\xa1\x01\n" +
+	"\x14TransactionOperation\x12\x1d\n" +
+	"\x19TRANSACTION_OPERATION_ADD\x10\x00\x12$\n" +
+	" TRANSACTION_OPERATION_DELETE_ALL\x10\x01\x12#\n" +
+	"\x1fTRANSACTION_OPERATION_ADD_BATCH\x10\x02\x12\x1f\n" +
+	"\x1bTRANSACTION_OPERATION_PATCH\x10\x032\xb8\t\n" +
 	"\x0fDatabaseService\x12L\n" +
 	"\x10CreateSensorData\x12\x1b.database.SensorDataRequest\x1a\x1b.database.OperationResponse\x12D\n" +
 	"\x10GetAllSensorData\x12\x16.database.EmptyRequest\x1a\x18.database.SensorDataList\x12N\n" +
-	"\x17GetSensorDataBySensorId\x12\x19.database.SensorIdRequest\x1a\x18.database.SensorDataList\x12L\n" +
-	"\x10UpdateSensorData\x12\x1b.database.SensorDataRequest\x1a\x1b.database.OperationResponse\x12J\n" +
-	"\x10DeleteSensorData\x12\x19.database.SensorIdRequest\x1a\x1b.database.OperationResponse\x12M\n" +
+	"\x17GetSensorDataBySensorId\x12\x19.database.SensorIdRequest\x1a\x18.database.SensorDataList\x12P\n" +
+	"\x15GetSensorDataByPrefix\x12\x1d.database.SensorPrefixRequest\x1a\x18.database.SensorDataList\x12L\n" +
+	"\x12GetSensorDataByIds\x12\x1a.database.SensorIdsRequest\x1a\x1a.database.SensorDataGroups\x12L\n" +
+	"\x10UpdateSensorData\x12\x1b.database.SensorDataRequest\x1a\x1b.database.OperationResponse\x12I\n" +
+	"\x0fPatchSensorData\x12\x19.database.SensorDataPatch\x1a\x1b.database.OperationResponse\x12J\n" +
+	"\x10DeleteSensorData\x12\x19.database.SensorIdRequest\x1a\x1b.database.OperationResponse\x12J\n" +
+	"\x13DeleteAllSensorData\x12\x16.database.EmptyRequest\x1a\x1b.database.OperationResponse\x12M\n" +
 	"\x12PrepareTransaction\x12\x1c.database.TransactionRequest\x1a\x19.database.PrepareResponse\x12I\n" +
 	"\x11CommitTransaction\x12\x17.database.TransactionId\x1a\x1b.database.OperationResponse\x12H\n" +
-	"\x10AbortTransaction\x12\x17.database.TransactionId\x1a\x1b.database.OperationResponseB\x13Z\x11pkg/generated/rpcb\x06proto3"
+	"\x10AbortTransaction\x12\x17.database.TransactionId\x1a\x1b.database.OperationResponse\x12@\n" +
+	"\rFlushSnapshot\x12\x16.database.EmptyRequest\x1a\x17.database.FlushResponse\x12H\n" +
+	"\x12GetAppliedSequence\x12\x16.database.EmptyRequest\x1a\x1a.database.SequenceResponse\x12?\n" +
+	"\fAcquireLease\x12\x16.database.LeaseRequest\x1a\x17.database.LeaseResponse\x12?\n" +
+	"\fReleaseLease\x12\x16.database.LeaseRequest\x1a\x17.database.LeaseResponseB\x13Z\x11pkg/generated/rpcb\x06proto3"
 
 var (
 	file_pkg_rpc_database_proto_rawDescOnce sync.Once
@@ -481,43 +1112,77 @@ func file_pkg_rpc_database_proto_rawDescGZIP() []byte {
 	return file_pkg_rpc_database_proto_rawDescData
 }
 
-var file_pkg_rpc_database_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_pkg_rpc_database_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_pkg_rpc_database_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_pkg_rpc_database_proto_goTypes = []any{
-	(*SensorDataRequest)(nil),     // 0: database.SensorDataRequest
-	(*OperationResponse)(nil),     // 1: database.OperationResponse
-	(*SensorDataList)(nil),        // 2: database.SensorDataList
-	(*EmptyRequest)(nil),          // 3: database.EmptyRequest
-	(*SensorIdRequest)(nil),       // 4: database.SensorIdRequest
-	(*TransactionRequest)(nil),    // 5: database.TransactionRequest
-	(*PrepareResponse)(nil),       // 6: database.PrepareResponse
-	(*TransactionId)(nil),         // 7: database.TransactionId
-	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+	(TransactionOperation)(0),     // 0: database.TransactionOperation
+	(*SensorDataRequest)(nil),     // 1: database.SensorDataRequest
+	(*SensorDataPatch)(nil),       // 2: database.SensorDataPatch
+	(*OperationResponse)(nil),     // 3: database.OperationResponse
+	(*SensorDataList)(nil),        // 4: database.SensorDataList
+	(*EmptyRequest)(nil),          // 5: database.EmptyRequest
+	(*SensorIdRequest)(nil),       // 6: database.SensorIdRequest
+	(*SensorPrefixRequest)(nil),   // 7: database.SensorPrefixRequest
+	(*SensorIdsRequest)(nil),      // 8: database.SensorIdsRequest
+	(*SensorDataGroups)(nil),      // 9: database.SensorDataGroups
+	(*TransactionRequest)(nil),    // 10: database.TransactionRequest
+	(*PrepareResponse)(nil),       // 11: database.PrepareResponse
+	(*TransactionId)(nil),         // 12: database.TransactionId
+	(*SequenceResponse)(nil),      // 13: database.SequenceResponse
+	(*FlushResponse)(nil),         // 14: database.FlushResponse
+	(*LeaseRequest)(nil),          // 15: database.LeaseRequest
+	(*LeaseResponse)(nil),         // 16: database.LeaseResponse
+	nil,                           // 17: database.SensorDataGroups.GroupsEntry
+	(*timestamppb.Timestamp)(nil), // 18: google.protobuf.Timestamp
 }
 var file_pkg_rpc_database_proto_depIdxs = []int32{
-	8,  // 0: database.SensorDataRequest.timestamp:type_name -> google.protobuf.Timestamp
-	0,  // 1: database.SensorDataList.data:type_name -> database.SensorDataRequest
-	0,  // 2: database.TransactionRequest.sensor_data:type_name -> database.SensorDataRequest
-	0,  // 3: database.DatabaseService.CreateSensorData:input_type -> database.SensorDataRequest
-	3,  // 4: database.DatabaseService.GetAllSensorData:input_type -> database.EmptyRequest
-	4,  // 5: database.DatabaseService.GetSensorDataBySensorId:input_type -> database.SensorIdRequest
-	0,  // 6: database.DatabaseService.UpdateSensorData:input_type -> database.SensorDataRequest
-	4,  // 7: database.DatabaseService.DeleteSensorData:input_type -> database.SensorIdRequest
-	5,  // 8: database.DatabaseService.PrepareTransaction:input_type -> database.TransactionRequest
-	7,  // 9: database.DatabaseService.CommitTransaction:input_type -> database.TransactionId
-	7,  // 10: database.DatabaseService.AbortTransaction:input_type -> database.TransactionId
-	1,  // 11: database.DatabaseService.CreateSensorData:output_type -> database.OperationResponse
-	2,  // 12: database.DatabaseService.GetAllSensorData:output_type -> database.SensorDataList
-	2,  // 13: database.DatabaseService.GetSensorDataBySensorId:output_type -> database.SensorDataList
-	1,  // 14: database.DatabaseService.UpdateSensorData:output_type -> database.OperationResponse
-	1,  // 15: database.DatabaseService.DeleteSensorData:output_type -> database.OperationResponse
-	6,  // 16: database.DatabaseService.PrepareTransaction:output_type -> database.PrepareResponse
-	1,  // 17: database.DatabaseService.CommitTransaction:output_type -> database.OperationResponse
-	1,  // 18: database.DatabaseService.AbortTransaction:output_type -> database.OperationResponse
-	11, // [11:19] is the sub-list for method output_type
-	3,  // [3:11] is the sub-list for method input_type
-	3,  // [3:3] is the sub-list for extension type_name
-	3,  // [3:3] is the sub-list for extension extendee
-	0,  // [0:3] is the sub-list for field type_name
+	18, // 0: database.SensorDataRequest.timestamp:type_name -> google.protobuf.Timestamp
+	18, // 1: database.SensorDataRequest.ingested_at:type_name -> google.protobuf.Timestamp
+	18, // 2: database.SensorDataPatch.timestamp:type_name -> google.protobuf.Timestamp
+	1,  // 3: database.SensorDataList.data:type_name -> database.SensorDataRequest
+	17, // 4: database.SensorDataGroups.groups:type_name -> database.SensorDataGroups.GroupsEntry
+	1,  // 5: database.TransactionRequest.sensor_data:type_name -> database.SensorDataRequest
+	0,  // 6: database.TransactionRequest.operation:type_name -> database.TransactionOperation
+	1,  // 7: database.TransactionRequest.batch:type_name -> database.SensorDataRequest
+	2,  // 8: database.TransactionRequest.patch:type_name -> database.SensorDataPatch
+	4,  // 9: database.SensorDataGroups.GroupsEntry.value:type_name -> database.SensorDataList
+	1,  // 10: database.DatabaseService.CreateSensorData:input_type -> database.SensorDataRequest
+	5,  // 11: database.DatabaseService.GetAllSensorData:input_type -> database.EmptyRequest
+	6,  // 12: database.DatabaseService.GetSensorDataBySensorId:input_type -> database.SensorIdRequest
+	7,  // 13: database.DatabaseService.GetSensorDataByPrefix:input_type -> database.SensorPrefixRequest
+	8,  // 14: database.DatabaseService.GetSensorDataByIds:input_type -> database.SensorIdsRequest
+	1,  // 15: database.DatabaseService.UpdateSensorData:input_type -> database.SensorDataRequest
+	2,  // 16: database.DatabaseService.PatchSensorData:input_type -> database.SensorDataPatch
+	6,  // 17: database.DatabaseService.DeleteSensorData:input_type -> database.SensorIdRequest
+	5,  // 18: database.DatabaseService.DeleteAllSensorData:input_type -> database.EmptyRequest
+	10, // 19: database.DatabaseService.PrepareTransaction:input_type -> database.TransactionRequest
+	12, // 20: database.DatabaseService.CommitTransaction:input_type -> database.TransactionId
+	12, // 21: database.DatabaseService.AbortTransaction:input_type -> database.TransactionId
+	5,  // 22: database.DatabaseService.FlushSnapshot:input_type -> database.EmptyRequest
+	5,  // 23: database.DatabaseService.GetAppliedSequence:input_type -> database.EmptyRequest
+	15, // 24: database.DatabaseService.AcquireLease:input_type -> database.LeaseRequest
+	15, // 25: database.DatabaseService.ReleaseLease:input_type -> database.LeaseRequest
+	3,  // 26: database.DatabaseService.CreateSensorData:output_type -> database.OperationResponse
+	4,  // 27: database.DatabaseService.GetAllSensorData:output_type -> database.SensorDataList
+	4,  // 28: database.DatabaseService.GetSensorDataBySensorId:output_type -> database.SensorDataList
+	4,  // 29: database.DatabaseService.GetSensorDataByPrefix:output_type -> database.SensorDataList
+	9,  // 30: database.DatabaseService.GetSensorDataByIds:output_type -> database.SensorDataGroups
+	3,  // 31: database.DatabaseService.UpdateSensorData:output_type -> database.OperationResponse
+	3,  // 32: database.DatabaseService.PatchSensorData:output_type -> database.OperationResponse
+	3,  // 33: database.DatabaseService.DeleteSensorData:output_type -> database.OperationResponse
+	3,  // 34: database.DatabaseService.DeleteAllSensorData:output_type -> database.OperationResponse
+	11, // 35: database.DatabaseService.PrepareTransaction:output_type -> database.PrepareResponse
+	3,  // 36: database.DatabaseService.CommitTransaction:output_type -> database.OperationResponse
+	3,  // 37: database.DatabaseService.AbortTransaction:output_type -> database.OperationResponse
+	14, // 38: database.DatabaseService.FlushSnapshot:output_type -> database.FlushResponse
+	13, // 39: database.DatabaseService.GetAppliedSequence:output_type -> database.SequenceResponse
+	16, // 40: database.DatabaseService.AcquireLease:output_type -> database.LeaseResponse
+	16, // 41: database.DatabaseService.ReleaseLease:output_type -> database.LeaseResponse
+	26, // [26:42] is the sub-list for method output_type
+	10, // [10:26] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_pkg_rpc_database_proto_init() }
@@ -525,18 +1190,20 @@ func file_pkg_rpc_database_proto_init() {
 	if File_pkg_rpc_database_proto != nil {
 		return
 	}
+	file_pkg_rpc_database_proto_msgTypes[1].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_rpc_database_proto_rawDesc), len(file_pkg_rpc_database_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   8,
+			NumEnums:      1,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_pkg_rpc_database_proto_goTypes,
 		DependencyIndexes: file_pkg_rpc_database_proto_depIdxs,
+		EnumInfos:         file_pkg_rpc_database_proto_enumTypes,
 		MessageInfos:      file_pkg_rpc_database_proto_msgTypes,
 	}.Build()
 	File_pkg_rpc_database_proto = out.File
@@ -22,11 +22,19 @@ const (
 	DatabaseService_CreateSensorData_FullMethodName        = "/database.DatabaseService/CreateSensorData"
 	DatabaseService_GetAllSensorData_FullMethodName        = "/database.DatabaseService/GetAllSensorData"
 	DatabaseService_GetSensorDataBySensorId_FullMethodName = "/database.DatabaseService/GetSensorDataBySensorId"
+	DatabaseService_GetSensorDataByPrefix_FullMethodName   = "/database.DatabaseService/GetSensorDataByPrefix"
+	DatabaseService_GetSensorDataByIds_FullMethodName      = "/database.DatabaseService/GetSensorDataByIds"
 	DatabaseService_UpdateSensorData_FullMethodName        = "/database.DatabaseService/UpdateSensorData"
+	DatabaseService_PatchSensorData_FullMethodName         = "/database.DatabaseService/PatchSensorData"
 	DatabaseService_DeleteSensorData_FullMethodName        = "/database.DatabaseService/DeleteSensorData"
+	DatabaseService_DeleteAllSensorData_FullMethodName     = "/database.DatabaseService/DeleteAllSensorData"
 	DatabaseService_PrepareTransaction_FullMethodName      = "/database.DatabaseService/PrepareTransaction"
 	DatabaseService_CommitTransaction_FullMethodName       = "/database.DatabaseService/CommitTransaction"
 	DatabaseService_AbortTransaction_FullMethodName        = "/database.DatabaseService/AbortTransaction"
+	DatabaseService_FlushSnapshot_FullMethodName           = "/database.DatabaseService/FlushSnapshot"
+	DatabaseService_GetAppliedSequence_FullMethodName      = "/database.DatabaseService/GetAppliedSequence"
+	DatabaseService_AcquireLease_FullMethodName            = "/database.DatabaseService/AcquireLease"
+	DatabaseService_ReleaseLease_FullMethodName            = "/database.DatabaseService/ReleaseLease"
 )
 
 // DatabaseServiceClient is the client API for DatabaseService service.
@@ -38,14 +46,25 @@ type DatabaseServiceClient interface {
 	// read operations
 	GetAllSensorData(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*SensorDataList, error)
 	GetSensorDataBySensorId(ctx context.Context, in *SensorIdRequest, opts ...grpc.CallOption) (*SensorDataList, error)
+	GetSensorDataByPrefix(ctx context.Context, in *SensorPrefixRequest, opts ...grpc.CallOption) (*SensorDataList, error)
+	GetSensorDataByIds(ctx context.Context, in *SensorIdsRequest, opts ...grpc.CallOption) (*SensorDataGroups, error)
 	// update operation (idk if we will ever update the data, but lets define it for now)
 	UpdateSensorData(ctx context.Context, in *SensorDataRequest, opts ...grpc.CallOption) (*OperationResponse, error)
-	// delete operation
+	PatchSensorData(ctx context.Context, in *SensorDataPatch, opts ...grpc.CallOption) (*OperationResponse, error)
+	// delete operations
 	DeleteSensorData(ctx context.Context, in *SensorIdRequest, opts ...grpc.CallOption) (*OperationResponse, error)
+	DeleteAllSensorData(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*OperationResponse, error)
 	// for the two phase commit operations
 	PrepareTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*PrepareResponse, error)
 	CommitTransaction(ctx context.Context, in *TransactionId, opts ...grpc.CallOption) (*OperationResponse, error)
 	AbortTransaction(ctx context.Context, in *TransactionId, opts ...grpc.CallOption) (*OperationResponse, error)
+	// admin operation to force a snapshot of the in-memory data to disk
+	FlushSnapshot(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*FlushResponse, error)
+	// highest commit sequence applied by this database, used to route read-your-writes reads
+	GetAppliedSequence(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*SequenceResponse, error)
+	// coordinator lease, so only one of several servers coordinates writes at a time
+	AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
+	ReleaseLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
 }
 
 type databaseServiceClient struct {
@@ -86,6 +105,26 @@ func (c *databaseServiceClient) GetSensorDataBySensorId(ctx context.Context, in
 	return out, nil
 }
 
+func (c *databaseServiceClient) GetSensorDataByPrefix(ctx context.Context, in *SensorPrefixRequest, opts ...grpc.CallOption) (*SensorDataList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SensorDataList)
+	err := c.cc.Invoke(ctx, DatabaseService_GetSensorDataByPrefix_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseServiceClient) GetSensorDataByIds(ctx context.Context, in *SensorIdsRequest, opts ...grpc.CallOption) (*SensorDataGroups, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SensorDataGroups)
+	err := c.cc.Invoke(ctx, DatabaseService_GetSensorDataByIds_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *databaseServiceClient) UpdateSensorData(ctx context.Context, in *SensorDataRequest, opts ...grpc.CallOption) (*OperationResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(OperationResponse)
@@ -96,6 +135,16 @@ func (c *databaseServiceClient) UpdateSensorData(ctx context.Context, in *Sensor
 	return out, nil
 }
 
+func (c *databaseServiceClient) PatchSensorData(ctx context.Context, in *SensorDataPatch, opts ...grpc.CallOption) (*OperationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OperationResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_PatchSensorData_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *databaseServiceClient) DeleteSensorData(ctx context.Context, in *SensorIdRequest, opts ...grpc.CallOption) (*OperationResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(OperationResponse)
@@ -106,6 +155,16 @@ func (c *databaseServiceClient) DeleteSensorData(ctx context.Context, in *Sensor
 	return out, nil
 }
 
+func (c *databaseServiceClient) DeleteAllSensorData(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*OperationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OperationResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_DeleteAllSensorData_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *databaseServiceClient) PrepareTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*PrepareResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(PrepareResponse)
@@ -136,6 +195,46 @@ func (c *databaseServiceClient) AbortTransaction(ctx context.Context, in *Transa
 	return out, nil
 }
 
+func (c *databaseServiceClient) FlushSnapshot(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*FlushResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FlushResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_FlushSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseServiceClient) GetAppliedSequence(ctx context.Context, in *EmptyRequest, opts ...grpc.CallOption) (*SequenceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SequenceResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_GetAppliedSequence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseServiceClient) AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_AcquireLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *databaseServiceClient) ReleaseLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, DatabaseService_ReleaseLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DatabaseServiceServer is the server API for DatabaseService service.
 // All implementations must embed UnimplementedDatabaseServiceServer
 // for forward compatibility.
@@ -145,14 +244,25 @@ type DatabaseServiceServer interface {
 	// read operations
 	GetAllSensorData(context.Context, *EmptyRequest) (*SensorDataList, error)
 	GetSensorDataBySensorId(context.Context, *SensorIdRequest) (*SensorDataList, error)
+	GetSensorDataByPrefix(context.Context, *SensorPrefixRequest) (*SensorDataList, error)
+	GetSensorDataByIds(context.Context, *SensorIdsRequest) (*SensorDataGroups, error)
 	// update operation (idk if we will ever update the data, but lets define it for now)
 	UpdateSensorData(context.Context, *SensorDataRequest) (*OperationResponse, error)
-	// delete operation
+	PatchSensorData(context.Context, *SensorDataPatch) (*OperationResponse, error)
+	// delete operations
 	DeleteSensorData(context.Context, *SensorIdRequest) (*OperationResponse, error)
+	DeleteAllSensorData(context.Context, *EmptyRequest) (*OperationResponse, error)
 	// for the two phase commit operations
 	PrepareTransaction(context.Context, *TransactionRequest) (*PrepareResponse, error)
 	CommitTransaction(context.Context, *TransactionId) (*OperationResponse, error)
 	AbortTransaction(context.Context, *TransactionId) (*OperationResponse, error)
+	// admin operation to force a snapshot of the in-memory data to disk
+	FlushSnapshot(context.Context, *EmptyRequest) (*FlushResponse, error)
+	// highest commit sequence applied by this database, used to route read-your-writes reads
+	GetAppliedSequence(context.Context, *EmptyRequest) (*SequenceResponse, error)
+	// coordinator lease, so only one of several servers coordinates writes at a time
+	AcquireLease(context.Context, *LeaseRequest) (*LeaseResponse, error)
+	ReleaseLease(context.Context, *LeaseRequest) (*LeaseResponse, error)
 	mustEmbedUnimplementedDatabaseServiceServer()
 }
 
@@ -172,12 +282,24 @@ func (UnimplementedDatabaseServiceServer) GetAllSensorData(context.Context, *Emp
 func (UnimplementedDatabaseServiceServer) GetSensorDataBySensorId(context.Context, *SensorIdRequest) (*SensorDataList, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetSensorDataBySensorId not implemented")
 }
+func (UnimplementedDatabaseServiceServer) GetSensorDataByPrefix(context.Context, *SensorPrefixRequest) (*SensorDataList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSensorDataByPrefix not implemented")
+}
+func (UnimplementedDatabaseServiceServer) GetSensorDataByIds(context.Context, *SensorIdsRequest) (*SensorDataGroups, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSensorDataByIds not implemented")
+}
 func (UnimplementedDatabaseServiceServer) UpdateSensorData(context.Context, *SensorDataRequest) (*OperationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateSensorData not implemented")
 }
+func (UnimplementedDatabaseServiceServer) PatchSensorData(context.Context, *SensorDataPatch) (*OperationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PatchSensorData not implemented")
+}
 func (UnimplementedDatabaseServiceServer) DeleteSensorData(context.Context, *SensorIdRequest) (*OperationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteSensorData not implemented")
 }
+func (UnimplementedDatabaseServiceServer) DeleteAllSensorData(context.Context, *EmptyRequest) (*OperationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAllSensorData not implemented")
+}
 func (UnimplementedDatabaseServiceServer) PrepareTransaction(context.Context, *TransactionRequest) (*PrepareResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PrepareTransaction not implemented")
 }
@@ -187,6 +309,18 @@ func (UnimplementedDatabaseServiceServer) CommitTransaction(context.Context, *Tr
 func (UnimplementedDatabaseServiceServer) AbortTransaction(context.Context, *TransactionId) (*OperationResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AbortTransaction not implemented")
 }
+func (UnimplementedDatabaseServiceServer) FlushSnapshot(context.Context, *EmptyRequest) (*FlushResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FlushSnapshot not implemented")
+}
+func (UnimplementedDatabaseServiceServer) GetAppliedSequence(context.Context, *EmptyRequest) (*SequenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAppliedSequence not implemented")
+}
+func (UnimplementedDatabaseServiceServer) AcquireLease(context.Context, *LeaseRequest) (*LeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcquireLease not implemented")
+}
+func (UnimplementedDatabaseServiceServer) ReleaseLease(context.Context, *LeaseRequest) (*LeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseLease not implemented")
+}
 func (UnimplementedDatabaseServiceServer) mustEmbedUnimplementedDatabaseServiceServer() {}
 func (UnimplementedDatabaseServiceServer) testEmbeddedByValue()                         {}
 
@@ -262,6 +396,42 @@ func _DatabaseService_GetSensorDataBySensorId_Handler(srv interface{}, ctx conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DatabaseService_GetSensorDataByPrefix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SensorPrefixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).GetSensorDataByPrefix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_GetSensorDataByPrefix_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).GetSensorDataByPrefix(ctx, req.(*SensorPrefixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseService_GetSensorDataByIds_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SensorIdsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).GetSensorDataByIds(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_GetSensorDataByIds_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).GetSensorDataByIds(ctx, req.(*SensorIdsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DatabaseService_UpdateSensorData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SensorDataRequest)
 	if err := dec(in); err != nil {
@@ -280,6 +450,24 @@ func _DatabaseService_UpdateSensorData_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DatabaseService_PatchSensorData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SensorDataPatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).PatchSensorData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_PatchSensorData_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).PatchSensorData(ctx, req.(*SensorDataPatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DatabaseService_DeleteSensorData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SensorIdRequest)
 	if err := dec(in); err != nil {
@@ -298,6 +486,24 @@ func _DatabaseService_DeleteSensorData_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DatabaseService_DeleteAllSensorData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmptyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).DeleteAllSensorData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_DeleteAllSensorData_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).DeleteAllSensorData(ctx, req.(*EmptyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DatabaseService_PrepareTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(TransactionRequest)
 	if err := dec(in); err != nil {
@@ -352,6 +558,78 @@ func _DatabaseService_AbortTransaction_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DatabaseService_FlushSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmptyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).FlushSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_FlushSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).FlushSnapshot(ctx, req.(*EmptyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseService_GetAppliedSequence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmptyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).GetAppliedSequence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_GetAppliedSequence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).GetAppliedSequence(ctx, req.(*EmptyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseService_AcquireLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).AcquireLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_AcquireLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).AcquireLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DatabaseService_ReleaseLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DatabaseServiceServer).ReleaseLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DatabaseService_ReleaseLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DatabaseServiceServer).ReleaseLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DatabaseService_ServiceDesc is the grpc.ServiceDesc for DatabaseService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -371,14 +649,30 @@ var DatabaseService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetSensorDataBySensorId",
 			Handler:    _DatabaseService_GetSensorDataBySensorId_Handler,
 		},
+		{
+			MethodName: "GetSensorDataByPrefix",
+			Handler:    _DatabaseService_GetSensorDataByPrefix_Handler,
+		},
+		{
+			MethodName: "GetSensorDataByIds",
+			Handler:    _DatabaseService_GetSensorDataByIds_Handler,
+		},
 		{
 			MethodName: "UpdateSensorData",
 			Handler:    _DatabaseService_UpdateSensorData_Handler,
 		},
+		{
+			MethodName: "PatchSensorData",
+			Handler:    _DatabaseService_PatchSensorData_Handler,
+		},
 		{
 			MethodName: "DeleteSensorData",
 			Handler:    _DatabaseService_DeleteSensorData_Handler,
 		},
+		{
+			MethodName: "DeleteAllSensorData",
+			Handler:    _DatabaseService_DeleteAllSensorData_Handler,
+		},
 		{
 			MethodName: "PrepareTransaction",
 			Handler:    _DatabaseService_PrepareTransaction_Handler,
@@ -391,6 +685,22 @@ var DatabaseService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AbortTransaction",
 			Handler:    _DatabaseService_AbortTransaction_Handler,
 		},
+		{
+			MethodName: "FlushSnapshot",
+			Handler:    _DatabaseService_FlushSnapshot_Handler,
+		},
+		{
+			MethodName: "GetAppliedSequence",
+			Handler:    _DatabaseService_GetAppliedSequence_Handler,
+		},
+		{
+			MethodName: "AcquireLease",
+			Handler:    _DatabaseService_AcquireLease_Handler,
+		},
+		{
+			MethodName: "ReleaseLease",
+			Handler:    _DatabaseService_ReleaseLease_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/rpc/database.proto",
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientOption configures an HttpClient
+type ClientOption func(*HttpClient)
+
+// WithResponseCache enables caching of GET responses that carry Cache-Control: max-age, keyed by URL.
+// At most maxEntries responses are kept, the oldest one is evicted first
+func WithResponseCache(maxEntries int) ClientOption {
+	return func(c *HttpClient) {
+		if maxEntries > 0 {
+			c.cache = newResponseCache(maxEntries)
+		}
+	}
+}
+
+// cacheEntry is a cached response together with its expiry
+type cacheEntry struct {
+	response *Response
+	expires  time.Time
+}
+
+// responseCache is a size capped, FIFO evicted store of fresh responses
+type responseCache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	order      []string //keys in insertion order, used for eviction
+	maxEntries int
+}
+
+// newResponseCache creates an empty cache holding at most maxEntries responses
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{
+		entries:    make(map[string]cacheEntry),
+		maxEntries: maxEntries,
+	}
+}
+
+// get returns a copy of the cached response for the key if it is still fresh
+func (rc *responseCache) get(key string) (*Response, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		rc.remove(key)
+		return nil, false
+	}
+
+	cached := cloneResponse(entry.response)
+	cached.FromCache = true
+	return cached, true
+}
+
+// put stores a response for maxAge, evicting the oldest entries when the cache is full
+func (rc *responseCache) put(key string, resp *Response, maxAge time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if _, ok := rc.entries[key]; ok {
+		rc.remove(key)
+	}
+	for len(rc.order) >= rc.maxEntries {
+		rc.remove(rc.order[0])
+	}
+
+	//keep our own copy, the caller is free to modify the response it got back
+	rc.entries[key] = cacheEntry{response: cloneResponse(resp), expires: time.Now().Add(maxAge)}
+	rc.order = append(rc.order, key)
+}
+
+// cloneResponse returns a deep copy of a response, so the cache and its callers never share headers or body
+func cloneResponse(resp *Response) *Response {
+	clone := *resp
+	clone.Headers = maps.Clone(resp.Headers)
+	if resp.MultiHeaders != nil {
+		clone.MultiHeaders = make(map[string][]string, len(resp.MultiHeaders))
+		for key, values := range resp.MultiHeaders {
+			clone.MultiHeaders[key] = slices.Clone(values)
+		}
+	}
+	clone.Body = bytes.Clone(resp.Body)
+	return &clone
+}
+
+// remove drops a key, the caller must hold the lock
+func (rc *responseCache) remove(key string) {
+	delete(rc.entries, key)
+	for i, k := range rc.order {
+		if k == key {
+			rc.order = append(rc.order[:i], rc.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// cacheMaxAge returns how long a response may be cached according to its Cache-Control header.
+// Only successful responses with a positive max-age and without no-store or no-cache are cacheable
+func cacheMaxAge(resp *Response) (time.Duration, bool) {
+	if resp.StatusCode != StatusOK {
+		return 0, false
+	}
+
+	var maxAge time.Duration
+	for _, directive := range strings.Split(resp.Header("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				return 0, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return maxAge, maxAge > 0
+}
+
+// bypassesCache reports whether the request headers ask for a fresh response (Cache-Control: no-cache or no-store)
+// or only for part of it (Range), in which case the cache is neither read nor written
+func bypassesCache(headers map[string]string) bool {
+	for key, value := range headers {
+		if strings.EqualFold(key, "Range") {
+			return true
+		}
+		if strings.EqualFold(key, "Cache-Control") {
+			value = strings.ToLower(value)
+			if strings.Contains(value, "no-cache") || strings.Contains(value, "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,34 +2,81 @@ package http
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 )
 
+// ErrConnect is returned by the client when no connection to the server could be opened or it broke while the
+// request was sent, so the server did not get the request; retrying is safe
+var ErrConnect = errors.New("connection failed")
+
+// ErrTimeout is returned by the client when the timeout passed, together with ErrConnect or ErrReadBody for the step
+// that timed out. After ErrReadBody the server may have processed the request
+var ErrTimeout = errors.New("timed out")
+
+// ErrReadBody is returned by the client when the request was sent but the response could not be read completely,
+// e.g. because the server closed the connection before sending its Content-Length bytes of body
+var ErrReadBody = errors.New("response not read completely")
+
+// ErrParse is returned by the client when the server answered with something that is not an HTTP response
+var ErrParse = errors.New("malformed response")
+
 // HttpClient represents an HTTP client
 type HttpClient struct {
 	Timeout time.Duration
+	cache   *responseCache                                                      //nil unless enabled with WithResponseCache
+	pool    *connPool                                                           //nil unless enabled with WithConnectionPool
+	dial    func(network, addr string, timeout time.Duration) (net.Conn, error) //net.DialTimeout unless set with WithDialer
 }
 
 // NewClient creates a new HTTP client with the specified timeout
-func HttpClientFactory(timeout time.Duration) *HttpClient {
-	return &HttpClient{
+func HttpClientFactory(timeout time.Duration, opts ...ClientOption) *HttpClient {
+	c := &HttpClient{
 		Timeout: timeout,
+		dial:    net.DialTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithDialer opens the connections with dial instead of net.DialTimeout, e.g. to go through a proxy or to test the
+// client against a fake connection
+func WithDialer(dial func(network, addr string, timeout time.Duration) (net.Conn, error)) ClientOption {
+	return func(c *HttpClient) {
+		c.dial = dial
 	}
 }
 
+// clientError wraps err in kind and also in ErrTimeout if it is a timeout, so errors.Is finds both and errors.As
+// still finds the cause, e.g. a *net.OpError
+func clientError(kind error, context string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w (%w) %s: %w", kind, ErrTimeout, context, err)
+	}
+	return fmt.Errorf("%w %s: %w", kind, context, err)
+}
+
 // Get sends an HTTP GET request to the specified URL
 func (c *HttpClient) Get(url string) (*Response, error) {
-	return c.sendRequest(GET, url, nil, "")
+	return c.sendRequest(GET, url, nil, "", nil)
 }
 
 // Post sends an HTTP POST request with the specified body and content type
 func (c *HttpClient) Post(url string, body []byte, contentType string) (*Response, error) {
-	return c.sendRequest(POST, url, body, contentType)
+	return c.sendRequest(POST, url, body, contentType, nil)
 }
 
 // PostJSON is a convenience method for sending JSON data
@@ -37,39 +84,64 @@ func (c *HttpClient) PostJSON(url string, jsonData []byte) (*Response, error) {
 	return c.Post(url, jsonData, "application/json")
 }
 
-// sendRequest sends an HTTP request with the specified method, URL, body, and content type
-func (c *HttpClient) sendRequest(method, url string, body []byte, contentType string) (*Response, error) {
-	host, port, path, err := parseURL(url)
-	if err != nil {
-		return nil, err
+// Do sends an HTTP request with an arbitrary method and additional headers (a Content-Type header is used for the body)
+func (c *HttpClient) Do(method, url string, body []byte, headers map[string]string) (*Response, error) {
+	contentType := ""
+	extraHeaders := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if strings.EqualFold(key, "Content-Type") {
+			contentType = value
+			continue
+		}
+		extraHeaders[key] = value
 	}
 
-	//connect to our server
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.DialTimeout("tcp", addr, c.Timeout)
-	if err != nil {
-		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
-	}
+	return c.sendRequest(method, url, body, contentType, extraHeaders)
+}
 
-	defer conn.Close()
+// sendRequest sends an HTTP request with the specified method, URL, body, content type and extra headers
+func (c *HttpClient) sendRequest(method, url string, body []byte, contentType string, headers map[string]string) (*Response, error) {
+	//only plain GETs are served from and stored in the cache
+	useCache := c.cache != nil && method == GET && !bypassesCache(headers)
+	if useCache {
+		if cached, ok := c.cache.get(url); ok {
+			return cached, nil
+		}
+	}
 
-	//set connection timeout
-	err = conn.SetDeadline(time.Now().Add(c.Timeout))
+	network, addr, host, path, err := parseURL(url)
 	if err != nil {
-		return nil, fmt.Errorf("error setting connection deadline: %w", err)
+		return nil, err
 	}
 
 	var reqBuf bytes.Buffer
 	reqBuf.WriteString(fmt.Sprintf("%s %s HTTP/1.1\r\n", method, path))
-	reqBuf.WriteString(fmt.Sprintf("Host: %s\r\n", host))
+
+	//a caller supplied Host header (e.g. for virtual hosts) replaces the one derived from the URL
+	hostOverridden := false
+	for key := range headers {
+		if strings.EqualFold(key, "Host") {
+			hostOverridden = true
+		}
+	}
+	if !hostOverridden {
+		reqBuf.WriteString(fmt.Sprintf("Host: %s\r\n", host))
+	}
 
 	if body != nil && len(body) > 0 {
 		reqBuf.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(body)))
 		reqBuf.WriteString(fmt.Sprintf("Content-Type: %s\r\n", contentType))
 	}
 
-	//additional headers
-	reqBuf.WriteString("Connection: close\r\n")
+	//caller supplied headers
+	for key, value := range headers {
+		reqBuf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+
+	//additional headers, HTTP/1.1 keeps the connection open without one
+	if c.pool == nil {
+		reqBuf.WriteString("Connection: close\r\n")
+	}
 	reqBuf.WriteString("\r\n")
 
 	//add the body if present
@@ -78,60 +150,101 @@ func (c *HttpClient) sendRequest(method, url string, body []byte, contentType st
 	}
 
 	start := time.Now() //for RTT measurement
-	_, err = conn.Write(reqBuf.Bytes())
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+	var rawResponse []byte
+	if c.pool != nil {
+		rawResponse, err = c.pooledRoundTrip(network, addr, method, reqBuf.Bytes())
+	} else {
+		rawResponse, err = c.roundTrip(network, addr, reqBuf.Bytes())
 	}
-
-	rawResponse, err := io.ReadAll(conn)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
+		return nil, err
 	}
 
 	//calc RTT
 	rtt := time.Since(start)
-	log.Printf("Request completed in %v", rtt)
+	logging.Debugf("Request completed in %v", rtt)
 
-	resp, err := parseResponse(rawResponse)
+	resp, err := parseResponse(method, rawResponse)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing response: %w", err)
+		return nil, err
+	}
+
+	if useCache {
+		if maxAge, ok := cacheMaxAge(resp); ok {
+			c.cache.put(url, resp, maxAge)
+		}
 	}
 
 	return resp, nil
 }
 
-// parseURL extracts host, port, and path from a URL
-func parseURL(url string) (host string, port int, path string, err error) {
-	port = 80
+// roundTrip sends the request on a new connection and reads the response until the server closes it
+func (c *HttpClient) roundTrip(network, addr string, request []byte) ([]byte, error) {
+	//connect to our server
+	conn, err := c.dial(network, addr, c.Timeout)
+	if err != nil {
+		return nil, clientError(ErrConnect, "connecting to "+addr, err)
+	}
+
+	defer conn.Close()
 
-	if !strings.HasPrefix(url, "http://") {
-		url = "http://" + url
+	//set connection timeout
+	err = conn.SetDeadline(time.Now().Add(c.Timeout))
+	if err != nil {
+		return nil, clientError(ErrConnect, "setting the deadline of the connection to "+addr, err)
 	}
 
-	url = strings.TrimPrefix(url, "http://")
+	//the request is one write, a server does not act on the part of it that arrived before the write failed
+	_, err = conn.Write(request)
+	if err != nil {
+		return nil, clientError(ErrConnect, "sending the request to "+addr, err)
+	}
 
-	//split into host+port and path
-	parts := strings.SplitN(url, "/", 2)
-	hostPort := parts[0]
+	rawResponse, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, clientError(ErrReadBody, "reading the response from "+addr, err)
+	}
+	return rawResponse, nil
+}
 
-	if len(parts) > 1 {
-		path = "/" + parts[1]
-	} else {
-		path = "/"
+// UnixSocketURL returns the base URL for a server listening on the Unix domain socket at socketPath.
+// The socket path is escaped so that a request path can simply be appended, e.g. UnixSocketURL(p) + "/data"
+func UnixSocketURL(socketPath string) string {
+	return "unix://" + url.PathEscape(socketPath)
+}
+
+// parseURL extracts the network and address to dial, the host and the path from a URL.
+// Besides http:// URLs it supports unix://<escaped socket path>/<path> as built by UnixSocketURL
+func parseURL(rawURL string) (network, addr, host, path string, err error) {
+	if socketURL, ok := strings.CutPrefix(rawURL, "unix://"); ok {
+		escapedSocket, reqPath, _ := strings.Cut(socketURL, "/")
+		socketPath, err := url.PathUnescape(escapedSocket)
+		if err != nil || socketPath == "" {
+			return "", "", "", "", fmt.Errorf("invalid unix socket URL: %s", rawURL)
+		}
+		return "unix", socketPath, "localhost", "/" + reqPath, nil
 	}
 
-	//check if host contains a port
-	hostParts := strings.SplitN(hostPort, ":", 2)
-	host = hostParts[0]
+	rawURL = strings.TrimPrefix(rawURL, "http://")
 
-	if len(hostParts) > 1 {
-		port, err = parsePort(hostParts[1])
+	//split into host+port and path
+	hostPort, reqPath, _ := strings.Cut(rawURL, "/")
+	path = "/" + reqPath
+
+	//check if host contains a port (SplitHostPort also handles bracketed IPv6 hosts)
+	port := 80
+	host = hostPort
+	if h, p, splitErr := net.SplitHostPort(hostPort); splitErr == nil {
+		host = h
+		port, err = parsePort(p)
 		if err != nil {
-			return "", 0, "", err
+			return "", "", "", "", err
 		}
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
 	}
 
-	return host, port, path, nil
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(port)), host, path, nil
 }
 
 // parsePort converts a port string to an integer
@@ -144,12 +257,21 @@ func parsePort(portStr string) (int, error) {
 	return port, nil
 }
 
-// parseResponse parses a raw HTTP response
-func parseResponse(rawResponse []byte) (*Response, error) {
+// parseResponse parses a raw HTTP response to a request with method; its errors wrap ErrParse, or ErrReadBody for a
+// body shorter than its Content-Length. A response that never has a body, e.g. to HEAD, keeps its Content-Length but
+// gets an empty body
+func parseResponse(method string, rawResponse []byte) (*Response, error) {
 	//split into header and body
 	parts := bytes.SplitN(rawResponse, []byte("\r\n\r\n"), 2)
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid response format")
+		switch {
+		case len(rawResponse) == 0:
+			return nil, fmt.Errorf("%w: connection closed without a response", ErrReadBody)
+		case bytes.HasPrefix(rawResponse, []byte("HTTP/")):
+			return nil, fmt.Errorf("%w: connection closed in the headers", ErrReadBody)
+		default:
+			return nil, fmt.Errorf("%w: no end of the headers", ErrParse)
+		}
 	}
 
 	headerBytes := parts[0]
@@ -158,21 +280,21 @@ func parseResponse(rawResponse []byte) (*Response, error) {
 	//parse the headers
 	headerLines := bytes.Split(headerBytes, []byte("\r\n"))
 	if len(headerLines) == 0 {
-		return nil, fmt.Errorf("invalid response headers")
+		return nil, fmt.Errorf("%w: no headers", ErrParse)
 	}
 
 	//now parse the status line
 	statusLine := string(headerLines[0])
 	statusParts := strings.SplitN(statusLine, " ", 3)
 	if len(statusParts) < 3 {
-		return nil, fmt.Errorf("invalid status line: %s", statusLine)
+		return nil, fmt.Errorf("%w: invalid status line %q", ErrParse, statusLine)
 	}
 
 	//extract status code and text
 	statusCode := 0
 	_, err := fmt.Sscanf(statusParts[1], "%d", &statusCode)
 	if err != nil {
-		return nil, fmt.Errorf("invalid status code: %s", statusParts[1])
+		return nil, fmt.Errorf("%w: invalid status code %q", ErrParse, statusParts[1])
 	}
 	statusText := statusParts[2]
 
@@ -194,10 +316,16 @@ func parseResponse(rawResponse []byte) (*Response, error) {
 
 		key := strings.TrimSpace(line[:colonIdx])
 		value := strings.TrimSpace(line[colonIdx+1:])
-		resp.Headers[key] = value
 
 		//check for special headers
 		keyLower := strings.ToLower(key)
+		if keyLower == "set-cookie" {
+			//every cookie comes on its own line, they would overwrite each other in Headers
+			resp.AddHeader(key, value)
+			continue
+		}
+		resp.Headers[key] = value
+
 		if keyLower == "content-type" {
 			resp.ContentType = value
 		} else if keyLower == "content-length" {
@@ -209,5 +337,20 @@ func parseResponse(rawResponse []byte) (*Response, error) {
 		}
 	}
 
+	if bodyless(method, statusCode) {
+		resp.Body = nil
+		return resp, nil
+	}
+
+	if strings.EqualFold(resp.Header("Transfer-Encoding"), "chunked") {
+		if resp.Body, err = decodeChunked(resp.Body); err != nil {
+			return nil, err
+		}
+		resp.ContentLength = len(resp.Body)
+	}
+
+	if len(resp.Body) < resp.ContentLength {
+		return nil, fmt.Errorf("%w: connection closed after %d of %d body bytes", ErrReadBody, len(resp.Body), resp.ContentLength)
+	}
 	return resp, nil
 }
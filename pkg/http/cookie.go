@@ -0,0 +1,131 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// SameSite is the SameSite attribute of a cookie
+type SameSite string
+
+const (
+	SameSiteDefault SameSite = ""       //no attribute, the browser decides (Lax in current browsers)
+	SameSiteLax     SameSite = "Lax"    //sent on same-site requests and top-level navigations
+	SameSiteStrict  SameSite = "Strict" //only sent on same-site requests
+	SameSiteNone    SameSite = "None"   //sent on cross-site requests too, browsers require Secure with it
+)
+
+// CookieOptions are the attributes of a cookie set with Response.SetCookie; the zero value sets a session cookie
+// without any attribute
+type CookieOptions struct {
+	Path     string   //URL path the cookie is sent for, e.g. "/"; empty omits the attribute
+	MaxAge   int      //lifetime in seconds; 0 omits the attribute (session cookie), negative deletes the cookie
+	HttpOnly bool     //hide the cookie from JavaScript
+	Secure   bool     //only send the cookie over HTTPS
+	SameSite SameSite //cross-site behaviour; SameSiteDefault omits the attribute
+}
+
+// Cookies parses the Cookie header ("a=1; b=2") into a map from name to value. Surrounding double quotes are
+// removed from values; if a name occurs more than once, the first value wins
+func (r *Request) Cookies() map[string]string {
+	cookies := make(map[string]string)
+	for _, pair := range strings.Split(r.Header("Cookie"), ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if name == "" || !validCookieName(name) {
+			continue
+		}
+		if _, ok := cookies[name]; ok {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies[name] = value
+	}
+	return cookies
+}
+
+// SetCookie adds a Set-Cookie header; several cookies can be set on one response. A cookie with an invalid name is
+// dropped, invalid bytes are dropped from the value and a value with a space or comma is quoted
+func (r *Response) SetCookie(name, value string, opts CookieOptions) {
+	if !validCookieName(name) {
+		logging.Warnf("Dropping cookie with invalid name %q", name)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString("=")
+	b.WriteString(sanitizeCookieValue(value))
+
+	if opts.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(sanitizeCookiePath(opts.Path))
+	}
+	if opts.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(opts.MaxAge))
+	} else if opts.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if opts.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if opts.Secure {
+		b.WriteString("; Secure")
+	}
+	if opts.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=")
+		b.WriteString(string(opts.SameSite))
+	}
+
+	r.AddHeader("Set-Cookie", b.String())
+}
+
+// validCookieName reports whether name is a token as defined in RFC 7230, the only names RFC 6265 allows
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeCookieValue drops the bytes RFC 6265 does not allow in a cookie value; a value with a space or comma is
+// quoted, which browsers accept although the RFC does not allow these bytes
+func sanitizeCookieValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 0x20 && c < 0x7f) && c != '"' && c != ';' && c != '\\' {
+			b.WriteByte(c)
+		}
+	}
+
+	sanitized := b.String()
+	if strings.ContainsAny(sanitized, " ,") {
+		return `"` + sanitized + `"`
+	}
+	return sanitized
+}
+
+// sanitizeCookiePath drops the bytes that would end the attribute or the header line
+func sanitizeCookiePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c >= 0x20 && c < 0x7f && c != ';' {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
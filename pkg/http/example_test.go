@@ -0,0 +1,38 @@
+package http_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+)
+
+// ExampleHandlerFunc shows a handler written in the net/http style next to the same handler returning a Response
+func ExampleHandlerFunc() {
+	server := http.ServerFactory("127.0.0.1", 8080)
+
+	server.RegisterHandlerFunc(http.GET, "/hello", func(w *http.ResponseWriter, req *http.Request) {
+		name := req.Query["name"]
+		if name == "" {
+			http.Error(w, http.StatusBadRequest, "missing_name", "Missing name")
+			return
+		}
+		http.WriteJSON(w, http.StatusOK, map[string]string{"greeting": "hello " + name})
+	})
+
+	//the same handler without the adapter
+	server.RegisterHandler(http.GET, "/hello-manual", func(req *http.Request) *http.Response {
+		name := req.Query["name"]
+		if name == "" {
+			return http.CreateErrorResponse(http.StatusBadRequest, "missing_name", "Missing name")
+		}
+		body, _ := json.Marshal(map[string]string{"greeting": "hello " + name})
+		return http.CreateJSONResponse(http.StatusOK, body)
+	})
+
+	resp := http.AdaptHandlerFunc(func(w *http.ResponseWriter, req *http.Request) {
+		http.WriteJSON(w, http.StatusOK, map[string]string{"greeting": "hello " + req.Query["name"]})
+	})(&http.Request{Query: map[string]string{"name": "alice"}})
+	fmt.Println(resp.StatusCode, resp.ContentType, string(resp.Body))
+	// Output: 200 application/json {"greeting":"hello alice"}
+}
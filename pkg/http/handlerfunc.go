@@ -0,0 +1,111 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// HandlerFunc is a handler in the style of net/http: instead of returning a Response it writes the status, headers
+// and body to a ResponseWriter. AdaptHandlerFunc turns it into a RequestHandler
+type HandlerFunc func(w *ResponseWriter, req *Request)
+
+// ResponseWriter collects the response written by a HandlerFunc. Unlike in net/http nothing is sent before the
+// handler returns, so headers may still be set after the body was written
+type ResponseWriter struct {
+	resp        *Response
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+// AdaptHandlerFunc wraps a HandlerFunc into a RequestHandler, so it can be registered and used with middleware like
+// BasicAuth. A handler that writes nothing answers with an empty 200
+func AdaptHandlerFunc(handler HandlerFunc) RequestHandler {
+	return func(req *Request) *Response {
+		w := &ResponseWriter{resp: NewResponse(StatusOK)}
+		handler(w, req)
+		return w.response()
+	}
+}
+
+// RegisterHandlerFunc registers a HandlerFunc like RegisterHandler registers a RequestHandler
+func (s *Server) RegisterHandlerFunc(method, path string, handler HandlerFunc) {
+	s.RegisterHandler(method, path, AdaptHandlerFunc(handler))
+}
+
+// WriteHeader sets the status code; like in net/http only the first call counts, and writing the body without
+// calling it first means 200
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		logging.Warnf("Ignoring superfluous WriteHeader(%d), the status is already %d", statusCode, w.resp.StatusCode)
+		return
+	}
+	w.wroteHeader = true
+
+	status := NewResponse(statusCode)
+	w.resp.StatusCode = status.StatusCode
+	w.resp.StatusText = status.StatusText
+}
+
+// Write appends to the body
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(p)
+}
+
+// SetHeader sets a header value, see Response.SetHeader
+func (w *ResponseWriter) SetHeader(key, value string) {
+	w.resp.SetHeader(key, value)
+}
+
+// AddHeader adds a header that may occur several times, see Response.AddHeader
+func (w *ResponseWriter) AddHeader(key, value string) {
+	w.resp.AddHeader(key, value)
+}
+
+// SetContentType sets the content type of the body, text/plain if the handler writes a body without setting one
+func (w *ResponseWriter) SetContentType(contentType string) {
+	w.resp.SetContentType(contentType)
+}
+
+// SetCookie adds a Set-Cookie header, see Response.SetCookie
+func (w *ResponseWriter) SetCookie(name, value string, opts CookieOptions) {
+	w.resp.SetCookie(name, value, opts)
+}
+
+// Status returns the status code written so far, 200 if WriteHeader was not called
+func (w *ResponseWriter) Status() int {
+	return w.resp.StatusCode
+}
+
+// response returns the collected response, built like the Create*Response helpers build theirs
+func (w *ResponseWriter) response() *Response {
+	if w.body.Len() > 0 {
+		if _, ok := w.resp.Headers["Content-Type"]; !ok {
+			w.resp.SetContentType("text/plain")
+		}
+		w.resp.SetBody(w.body.Bytes())
+	}
+	return w.resp
+}
+
+// WriteJSON writes v as a JSON body with the status code, giving the same response as CreateJSONResponse. If v
+// cannot be marshaled nothing is written and the error is returned
+func WriteJSON(w *ResponseWriter, statusCode int, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.SetContentType("application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(body)
+	return err
+}
+
+// Error writes an error response in the shape of CreateErrorResponse: {"error":{"code":...,"message":...}}
+func Error(w *ResponseWriter, statusCode int, code, message string) {
+	//a struct of strings always marshals
+	WriteJSON(w, statusCode, ErrorBody{Error: ErrorDetail{Code: code, Message: message}})
+}
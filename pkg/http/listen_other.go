@@ -0,0 +1,19 @@
+//go:build !unix
+
+package http
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// reusePortControl fails, SO_REUSEPORT is only supported on Unix systems
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this system")
+}
+
+// setListenBacklog fails, the backlog can only be changed on Unix systems
+func setListenBacklog(listener net.Listener, backlog int) error {
+	return errors.New("changing the listen backlog is not supported on this system")
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package http
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortControl sets SO_REUSEPORT on the socket before it is bound, so several listeners can share a port and the
+// kernel spreads the connections across them
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setListenBacklog changes the length of the accept queue of a listening socket; listen(2) on a socket that already
+// listens only updates its backlog. The kernel caps it at its own maximum, e.g. net.core.somaxconn on Linux
+func setListenBacklog(listener net.Listener, backlog int) error {
+	raw, err := listener.(syscall.Conn).SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"crypto/subtle"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// BasicAuth wraps a handler so that it is only executed for requests carrying the expected basic-auth credentials
+func BasicAuth(username, password string, next RequestHandler) RequestHandler {
+	return func(req *Request) *Response {
+		user, pass, ok := req.BasicAuth()
+
+		//constant time comparison so the credentials cant be guessed via response timing
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+
+		if !ok || !userMatch || !passMatch {
+			logging.Warnf("Rejected unauthorized request for %s %s", req.Method, req.Path)
+			resp := NewResponse(StatusUnauthorized)
+			resp.SetHeader("WWW-Authenticate", `Basic realm="admin"`)
+			resp.SetBodyString("Unauthorized")
+			return resp
+		}
+
+		return next(req)
+	}
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+)
+
+// MultipartFile is a file part of a multipart/form-data request body
+type MultipartFile struct {
+	FieldName   string //name of the form field
+	FileName    string //file name sent by the client
+	ContentType string
+	Content     []byte
+}
+
+// MultipartFiles parses a multipart/form-data body and returns its file parts in order; plain form fields are skipped
+func (r *Request) MultipartFiles() ([]MultipartFile, error) {
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = r.Header("Content-Type")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type: %w", err)
+	}
+	if mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("expected multipart/form-data, got %s", mediaType)
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("missing multipart boundary")
+	}
+
+	var files []MultipartFile
+	reader := multipart.NewReader(bytes.NewReader(r.Body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading multipart body: %w", err)
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		content, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", part.FileName(), err)
+		}
+
+		files = append(files, MultipartFile{
+			FieldName:   part.FormName(),
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Content:     content,
+		})
+	}
+}
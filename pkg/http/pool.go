@@ -0,0 +1,270 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPoolIdleTimeout is how long a pooled connection may be idle before it is closed instead of reused, shorter
+// than the DefaultReadTimeout after which our server closes an idle connection itself
+const DefaultPoolIdleTimeout = 20 * time.Second
+
+// connPool keeps the idle persistent connections of a client per address
+type connPool struct {
+	size int //idle connections kept per address
+
+	mu   sync.Mutex
+	idle map[string][]*pooledConn
+}
+
+// pooledConn is a persistent connection together with the reader buffering the responses read from it
+type pooledConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+}
+
+// WithConnectionPool sends the requests over persistent connections and keeps up to size idle ones per server open
+// for the next requests, instead of opening a connection for every request. size should match the number of requests
+// the caller runs at once: more than size still run at once, each on a connection of its own, but those that find
+// no free slot afterwards are closed. Connections idle for DefaultPoolIdleTimeout or announced as closing by the
+// server are not reused; a request failing on a reused connection the server closed meanwhile is sent again on a new
+// one
+func WithConnectionPool(size int) ClientOption {
+	return func(c *HttpClient) {
+		if size <= 0 {
+			return
+		}
+		c.pool = &connPool{size: size, idle: make(map[string][]*pooledConn)}
+	}
+}
+
+// CloseIdleConnections closes the connections kept by WithConnectionPool, requests running meanwhile keep theirs
+func (c *HttpClient) CloseIdleConnections() {
+	if c.pool == nil {
+		return
+	}
+
+	c.pool.mu.Lock()
+	idle := c.pool.idle
+	c.pool.idle = make(map[string][]*pooledConn)
+	c.pool.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+// get returns the most recently used idle connection to addr, nil if there is none that is fresh enough
+func (p *connPool) get(addr string) *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[addr]
+	for len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.idle[addr] = conns
+		if time.Since(conn.idleSince) < DefaultPoolIdleTimeout {
+			return conn
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// put keeps the connection for the next request to addr, or closes it if size connections are kept already
+func (p *connPool) put(addr string, conn *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[addr]) >= p.size {
+		conn.Close()
+		return
+	}
+	conn.idleSince = time.Now()
+	p.idle[addr] = append(p.idle[addr], conn)
+}
+
+// pooledRoundTrip sends the request on an idle connection to addr or a new one and returns the raw response. A
+// reused connection that fails before any byte of the response arrived was most likely closed by the server while it
+// was idle, so the request did not reach a handler and is sent once more on a new connection
+func (c *HttpClient) pooledRoundTrip(network, addr, method string, request []byte) ([]byte, error) {
+	if conn := c.pool.get(addr); conn != nil {
+		raw, reusable, err := c.exchange(conn, addr, method, request)
+		if err == nil {
+			c.release(addr, conn, reusable)
+			return raw, nil
+		}
+		conn.Close()
+		if len(raw) > 0 || errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+	}
+
+	netConn, err := c.dial(network, addr, c.Timeout)
+	if err != nil {
+		return nil, clientError(ErrConnect, "connecting to "+addr, err)
+	}
+	conn := &pooledConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+
+	raw, reusable, err := c.exchange(conn, addr, method, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.release(addr, conn, reusable)
+	return raw, nil
+}
+
+// release returns the connection to the pool if another request may be sent on it, else closes it
+func (c *HttpClient) release(addr string, conn *pooledConn, reusable bool) {
+	if !reusable {
+		conn.Close()
+		return
+	}
+	c.pool.put(addr, conn)
+}
+
+// exchange sends the request on the connection and reads one response from it; reusable reports whether the
+// connection can carry another request afterwards. On an error raw holds what was read of the response
+func (c *HttpClient) exchange(conn *pooledConn, addr, method string, request []byte) (raw []byte, reusable bool, err error) {
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return nil, false, clientError(ErrConnect, "setting the deadline of the connection to "+addr, err)
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, false, clientError(ErrConnect, "sending the request to "+addr, err)
+	}
+
+	var response bytes.Buffer
+	reusable, err = readResponseFrom(conn.reader, method, &response)
+	if err != nil {
+		return response.Bytes(), false, clientError(ErrReadBody, "reading the response from "+addr, err)
+	}
+	return response.Bytes(), reusable, nil
+}
+
+// readResponseFrom copies one response from the reader to out, using its framing to find the end instead of waiting
+// for the connection to close. reusable reports whether the server keeps the connection open and the end of the
+// body was known; a response without Content-Length and chunked encoding is read until the connection closes
+func readResponseFrom(reader *bufio.Reader, method string, out *bytes.Buffer) (reusable bool, err error) {
+	statusCode := 0
+	contentLength := -1
+	chunked, closing := false, false
+
+	for first := true; ; first = false {
+		line, err := reader.ReadString('\n')
+		out.WriteString(line)
+		if err != nil {
+			//a connection closed before the first byte is io.EOF, which lets the caller retry on a new one
+			if out.Len() > 0 {
+				err = unexpectedEOF(err)
+			}
+			return false, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if first {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				statusCode, _ = strconv.Atoi(fields[1])
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "content-length":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				contentLength = n
+			}
+		case "transfer-encoding":
+			chunked = strings.EqualFold(value, "chunked")
+		case "connection":
+			closing = strings.Contains(strings.ToLower(value), "close")
+		}
+	}
+
+	if bodyless(method, statusCode) {
+		return !closing, nil
+	}
+
+	switch {
+	case chunked:
+		if err := copyChunked(reader, out); err != nil {
+			return false, err
+		}
+	case contentLength >= 0:
+		if _, err := io.CopyN(out, reader, int64(contentLength)); err != nil {
+			return false, unexpectedEOF(err)
+		}
+	default:
+		_, err := io.Copy(out, reader)
+		return false, err
+	}
+	return !closing, nil
+}
+
+// copyChunked copies a chunked body including the last chunk and the trailers to out, parseResponse decodes it
+func copyChunked(reader *bufio.Reader, out *bytes.Buffer) error {
+	for {
+		line, err := reader.ReadString('\n')
+		out.WriteString(line)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		sizeField, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("%w: invalid chunk size %q", ErrParse, strings.TrimSpace(line))
+		}
+
+		if size == 0 {
+			//trailers up to the empty line that ends the body
+			for {
+				line, err := reader.ReadString('\n')
+				out.WriteString(line)
+				if err != nil {
+					return unexpectedEOF(err)
+				}
+				if strings.TrimRight(line, "\r\n") == "" {
+					return nil
+				}
+			}
+		}
+
+		//the chunk and its CRLF
+		if _, err := io.CopyN(out, reader, size+2); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for a connection closed in the middle of a response
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// bodyless reports whether a response never has a body, whatever its headers say: responses to HEAD and 1xx, 204 and
+// 304 responses
+func bodyless(method string, statusCode int) bool {
+	return method == "HEAD" || statusCode >= 100 && statusCode < 200 || statusCode == StatusNoContent || statusCode == StatusNotModified
+}
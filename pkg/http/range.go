@@ -0,0 +1,90 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errUnsatisfiableRange is returned by parseRange when the range lies outside the content
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// ServeContent creates a response for content that honors a single "Range: bytes=..." request header.
+// It returns 206 with Content-Range for a satisfiable range, 416 for an unsatisfiable one and the full content otherwise
+func ServeContent(req *Request, contentType string, content []byte) *Response {
+	rangeHeader := req.Header("Range")
+	if rangeHeader == "" {
+		resp := NewResponse(StatusOK)
+		resp.SetContentType(contentType)
+		resp.SetHeader("Accept-Ranges", "bytes")
+		resp.SetBody(content)
+		return resp
+	}
+
+	start, end, err := parseRange(rangeHeader, len(content))
+	if errors.Is(err, errUnsatisfiableRange) {
+		resp := NewResponse(StatusRangeNotSatisfiable)
+		resp.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", len(content)))
+		return resp
+	}
+	if err != nil {
+		//a malformed or multi range header is ignored, as allowed by RFC 9110
+		resp := NewResponse(StatusOK)
+		resp.SetContentType(contentType)
+		resp.SetHeader("Accept-Ranges", "bytes")
+		resp.SetBody(content)
+		return resp
+	}
+
+	resp := NewResponse(StatusPartialContent)
+	resp.SetContentType(contentType)
+	resp.SetHeader("Accept-Ranges", "bytes")
+	resp.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+	resp.SetBody(content[start : end+1])
+	return resp
+}
+
+// parseRange parses a single byte range ("bytes=0-99", "bytes=100-" or "bytes=-100") against the content size
+// and returns the inclusive start and end offsets
+func parseRange(header string, size int) (start, end int, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+
+	//suffix range: the last N bytes
+	if first == "" {
+		suffix, err := strconv.Atoi(last)
+		if err != nil || suffix < 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		return max(size-suffix, 0), size - 1, nil
+	}
+
+	start, err = strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+
+	end = size - 1
+	if last != "" {
+		end, err = strconv.Atoi(last)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+	}
+
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	return start, min(end, size-1), nil
+}
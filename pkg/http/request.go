@@ -3,84 +3,167 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 )
 
 // as defined in the question, we need to support GET and POST requests for both the server and the sender
 const (
-	GET  = "GET"
-	POST = "POST"
+	GET    = "GET"
+	POST   = "POST"
+	DELETE = "DELETE"
+	PATCH  = "PATCH"
 )
 
 // define HTTP status codes that match the widely recognized status codes
 const (
-	StatusOK          = 200
-	StatusBadRequest  = 400
-	StatusForbidden   = 401
-	StatusNotFound    = 404
-	StatusServerError = 500
+	StatusOK                  = 200
+	StatusNoContent           = 204
+	StatusPartialContent      = 206
+	StatusNotModified         = 304
+	StatusPermanentRedirect   = 308
+	StatusBadRequest          = 400
+	StatusForbidden           = 401
+	StatusUnauthorized        = 401
+	StatusNotFound            = 404
+	StatusRequestTimeout      = 408
+	StatusRangeNotSatisfiable = 416
+	StatusUnprocessable       = 422
+	StatusHeaderTooLarge      = 431
+	StatusServerError         = 500
+	StatusServiceUnavailable  = 503
+)
+
+// ErrConnectionClosed is returned by ParseRequest when the peer closed the connection without sending anything
+var ErrConnectionClosed = errors.New("connection closed before a request was sent")
+
+// ErrHeaderTooLarge is returned by ParseRequest when the request line or a header line is longer than
+// MaxHeaderLineLength or the request has more than MaxHeaderCount headers; the server answers with 431
+var ErrHeaderTooLarge = errors.New("request header fields too large")
+
+// ErrIncompleteRequest is returned by ParseRequest when the request ends before its headers or its Content-Length
+// bytes of body are complete, e.g. because the client under-delivered the body or sent it without the blank line that
+// ends the headers. If the read deadline passed while waiting for the rest, the error also wraps ErrRequestTimeout
+var ErrIncompleteRequest = errors.New("incomplete request")
+
+// ErrRequestTimeout is returned by ParseRequest when the read deadline passed in the middle of a request; the server
+// answers with 408. A deadline passing before the first byte of a request is not one, that is an idle connection
+var ErrRequestTimeout = errors.New("request not complete before the read deadline")
+
+// Limits of the header section, so a client cannot make the server buffer an unbounded line or header map
+const (
+	MaxHeaderLineLength = 8 << 10 //bytes per line including the line ending, also applies to the request line
+	MaxHeaderCount      = 100
 )
 
 // Request represents a typical HTTP request
 type Request struct {
 	Method      string
-	Path        string
+	Path        string            //path without the query string
+	RawQuery    string            //query string without the leading '?'
+	Query       map[string]string //decoded query parameters (first value wins)
 	Version     string
 	Headers     map[string]string
 	Body        []byte
 	ContentType string
 	ContentLen  int
+	RemoteAddr  string //address of the direct peer, set by the server
+
+	ctx context.Context //carries the span of the request, set by the server
 }
 
-// ParseRequest parses an HTTP request from a connection
+// Context returns the context of the request, which carries its trace span if tracing is enabled. Handlers pass it on
+// to the calls they make, so their spans become children of the request span
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ParseRequest parses a single HTTP request from a connection. Bytes read past the request are discarded, so for a
+// persistent connection with pipelined requests use Server.ServeConn, which keeps one reader per connection
 func ParseRequest(conn net.Conn) (*Request, error) {
-	reader := bufio.NewReader(conn)
+	return readRequest(bufio.NewReader(conn))
+}
+
+// readRequest parses a single HTTP request; on a persistent connection the same reader is used for every request
+// so that bytes of a pipelined request buffered while reading the previous one are not lost
+func readRequest(reader *bufio.Reader) (*Request, error) {
 	req := &Request{
 		Headers: make(map[string]string),
+		Query:   make(map[string]string),
 	}
 
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader)
+	if err == io.EOF && line == "" {
+		return nil, ErrConnectionClosed
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error reading request line: %w", err)
 	}
 
-	log.Printf("Request line: %s", line)
+	logging.Debugf("Request line: %s", line)
 
 	//parse the request line (Method, Path, Version)
 	parts := strings.Split(strings.TrimSpace(line), " ")
 	if len(parts) != 3 {
-		return nil, errors.New("invalid request line format")
+		//typically the body of the previous request that was sent without a Content-Length
+		return nil, fmt.Errorf("invalid request line format: %q", abbreviate(line))
 	}
 	req.Method = parts[0]
 	req.Path = parts[1]
 	req.Version = parts[2]
 
+	//split off the query string so handlers are matched on the path alone
+	if path, rawQuery, found := strings.Cut(req.Path, "?"); found {
+		req.Path = path
+		req.RawQuery = rawQuery
+
+		values, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string: %w", err)
+		}
+		for key, vals := range values {
+			req.Query[key] = vals[0]
+		}
+	}
+
 	//read the headers now
-	for {
-		line, err := reader.ReadString('\n')
+	for count := 0; ; count++ {
+		line, err := readLine(reader)
 		if err != nil {
-			return nil, fmt.Errorf("error reading header: %w", err)
+			return nil, incompleteError(err, "headers")
 		}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			//an empty line indicates end of headers
+		if strings.TrimSpace(line) == "" {
+			//an empty line indicates end of headers, the body starts right after its line ending
 			break
 		}
+		if count == MaxHeaderCount {
+			return nil, fmt.Errorf("%w: more than %d headers", ErrHeaderTooLarge, MaxHeaderCount)
+		}
 
 		//split header by first colon
 		colonIdx := strings.Index(line, ":")
 		if colonIdx == -1 {
-			return nil, errors.New("invalid header format")
+			return nil, fmt.Errorf("invalid header format: %q (body sent before the blank line ending the headers?)", abbreviate(line))
 		}
 
 		key := strings.TrimSpace(line[:colonIdx])
+		if !validHeaderName(key) {
+			return nil, fmt.Errorf("invalid header name %q (body sent before the blank line ending the headers?)", abbreviate(key))
+		}
 		value := strings.TrimSpace(line[colonIdx+1:])
 		req.Headers[key] = value
 
@@ -90,27 +173,108 @@ func ParseRequest(conn net.Conn) (*Request, error) {
 			req.ContentType = value
 		} else if keyLower == "content-length" {
 			contentLen, err := strconv.Atoi(value)
-			if err != nil {
-				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			if err != nil || contentLen < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
 			}
 			req.ContentLen = contentLen
 		}
 	}
 
-	//read body if Content-Length is set and method is POST
-	if req.Method == POST && req.ContentLen > 0 {
-		body := make([]byte, req.ContentLen)
-		_, err := io.ReadFull(reader, body)
+	//read the body whenever Content-Length is set, otherwise the next request on a persistent connection would start inside it.
+	//the buffer grows with the bytes that arrive, so a client announcing more than it sends costs no memory up front;
+	//a client that stops sending is stopped by the read deadline the server set for the request
+	if req.ContentLen > 0 {
+		var body bytes.Buffer
+		read, err := io.CopyN(&body, reader, int64(req.ContentLen))
 		if err != nil {
-			return nil, fmt.Errorf("error reading request body: %w", err)
+			return nil, incompleteError(err, fmt.Sprintf("body after %d of %d bytes", read, req.ContentLen))
 		}
-		req.Body = body
-		log.Printf("Read request body of length %d", len(req.Body))
+		req.Body = body.Bytes()
+		logging.Debugf("Read request body of length %d", len(req.Body))
 	}
 
 	return req, nil
 }
 
+// incompleteError describes a request that ended in the given part; a passed read deadline wraps ErrRequestTimeout
+func incompleteError(err error, part string) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %w: %s", ErrIncompleteRequest, ErrRequestTimeout, part)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: connection ended in the %s", ErrIncompleteRequest, part)
+	}
+	return fmt.Errorf("error reading %s: %w", part, err)
+}
+
+// validHeaderName reports whether name is a token as RFC 9110 requires of field names; a line of a body sent before
+// the end of the headers, e.g. {"sensorId":"temp-1"}, has a colon but no valid name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// abbreviate shortens a line quoted in an error message
+func abbreviate(line string) string {
+	const maxLength = 64
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) > maxLength {
+		return line[:maxLength] + "..."
+	}
+	return line
+}
+
+// KeepAlive reports whether the connection may be reused after the response: HTTP/1.1 keeps connections open
+// unless the client sends Connection: close, HTTP/1.0 closes them unless the client sends Connection: keep-alive
+func (r *Request) KeepAlive() bool {
+	connection := strings.ToLower(r.Header("Connection"))
+
+	switch r.Version {
+	case "HTTP/1.1":
+		return !strings.Contains(connection, "close")
+	case "HTTP/1.0":
+		return strings.Contains(connection, "keep-alive")
+	default:
+		return false
+	}
+}
+
+// readLine reads a single line and strips its line ending; both "\r\n" and a bare "\n" are accepted. A line longer
+// than MaxHeaderLineLength is an ErrHeaderTooLarge, the rest of it is not read
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		//ReadSlice returns at most one buffer of the line at a time, so the line is never buffered beyond the limit
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxHeaderLineLength {
+			return "", fmt.Errorf("%w: line longer than %d bytes", ErrHeaderTooLarge, MaxHeaderLineLength)
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return string(line), err //the partial line tells an empty connection apart from a truncated request
+		}
+		break
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
 // ReadBodyFrom reads the request body from a reader (used for testing)
 func (r *Request) ReadBodyFrom(reader io.Reader) error {
 	if r.ContentLen <= 0 {
@@ -126,11 +290,66 @@ func (r *Request) ReadBodyFrom(reader io.Reader) error {
 	return nil
 }
 
+// BasicAuth returns the username and password from the Authorization header if it uses the Basic scheme
+func (r *Request) BasicAuth() (username, password string, ok bool) {
+	auth := r.Header("Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
+
+// Header returns the value of a header using a case-insensitive lookup of the name
+func (r *Request) Header(name string) string {
+	if value, ok := r.Headers[name]; ok {
+		return value
+	}
+
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// ClientIP returns the IP of the client. If trustProxy is set, the left-most entry of X-Forwarded-For
+// is used, otherwise (or if the header is missing) the socket address of the direct peer is used
+func (r *Request) ClientIP(trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		//no port in the address, use it as is
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // String returns a string representation of the request (for logging purposes just like .ToString() in c#)
 func (r *Request) String() string {
 	var buf bytes.Buffer
 
-	buf.WriteString(fmt.Sprintf("%s %s %s\r\n", r.Method, r.Path, r.Version))
+	target := r.Path
+	if r.RawQuery != "" {
+		target += "?" + r.RawQuery
+	}
+	buf.WriteString(fmt.Sprintf("%s %s %s\r\n", r.Method, target, r.Version))
 
 	for key, value := range r.Headers {
 		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
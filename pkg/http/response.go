@@ -2,8 +2,12 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,17 +16,31 @@ type Response struct {
 	StatusCode    int
 	StatusText    string
 	Headers       map[string]string
+	MultiHeaders  map[string][]string //headers sent once per value, e.g. Set-Cookie; added with AddHeader
 	Body          []byte
 	ContentType   string
 	ContentLength int
+	FromCache     bool       //set by the client when the response was served from its cache
+	Version       string     //protocol version of the status line, HTTP/1.1 if empty (the server echoes the request's version)
+	Stream        BodyStream //writes the body to the connection instead of Body, see NewStreamingResponse
 }
 
 // Common HTTP status texts
 var statusTexts = map[int]string{
-	StatusOK:          "OK",
-	StatusBadRequest:  "Bad Request",
-	StatusNotFound:    "Not Found",
-	StatusServerError: "Internal Server Error",
+	StatusOK:                  "OK",
+	StatusNoContent:           "No Content",
+	StatusPartialContent:      "Partial Content",
+	StatusNotModified:         "Not Modified",
+	StatusPermanentRedirect:   "Permanent Redirect",
+	StatusBadRequest:          "Bad Request",
+	StatusUnauthorized:        "Unauthorized",
+	StatusNotFound:            "Not Found",
+	StatusRequestTimeout:      "Request Timeout",
+	StatusRangeNotSatisfiable: "Range Not Satisfiable",
+	StatusUnprocessable:       "Unprocessable Entity",
+	StatusHeaderTooLarge:      "Request Header Fields Too Large",
+	StatusServerError:         "Internal Server Error",
+	StatusServiceUnavailable:  "Service Unavailable",
 }
 
 // NewResponse creates a new response with default headers
@@ -53,35 +71,188 @@ func (r *Response) SetBody(body []byte) {
 	r.Headers["Content-Length"] = fmt.Sprintf("%d", r.ContentLength)
 }
 
+// Compress gzips the body if it is at least minSize bytes and got smaller, and updates Content-Length
+// to the compressed size. A streamed body of unknown size is always gzipped while it is written. It returns whether
+// the body was compressed
+func (r *Response) Compress(minSize int) (bool, error) {
+	if _, ok := r.Headers["Content-Encoding"]; ok {
+		return false, nil //already encoded by the handler
+	}
+	if _, ok := r.Headers["Content-Range"]; ok {
+		return false, nil //byte ranges refer to the uncompressed content
+	}
+	if r.Stream != nil && r.StatusCode != StatusNoContent && r.StatusCode != StatusNotModified {
+		r.compressStream()
+		return true, nil
+	}
+	if len(r.Body) < minSize || len(r.Body) == 0 {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(r.Body); err != nil {
+		return false, fmt.Errorf("error compressing body: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return false, fmt.Errorf("error compressing body: %w", err)
+	}
+
+	//small bodies can grow because of the gzip header, send those as they are
+	if buf.Len() >= len(r.Body) {
+		return false, nil
+	}
+
+	//SetBody recomputes Content-Length from the compressed bytes that are actually sent
+	r.SetBody(buf.Bytes())
+	r.Headers["Content-Encoding"] = "gzip"
+	r.Headers["Vary"] = "Accept-Encoding"
+	return true, nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value allows a gzip encoded response: gzip, or * if gzip is
+// not listed, must have a q-value above 0 and at least the one of identity, the unencoded body
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, identityQ, wildcardQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := qValue(params)
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "identity":
+			identityQ = q
+		case "*":
+			wildcardQ = q
+		}
+	}
+
+	//codings that are not listed get the q-value of *; identity is acceptable unless it is refused
+	if gzipQ < 0 {
+		gzipQ = max(wildcardQ, 0)
+	}
+	if identityQ < 0 {
+		identityQ = 1
+		if wildcardQ >= 0 {
+			identityQ = wildcardQ
+		}
+	}
+	return gzipQ > 0 && gzipQ >= identityQ
+}
+
+// qValue returns the q parameter of a coding's parameters, e.g. " q=0.5" or "level=1;Q=0"; a coding without one has
+// q=1, one with an invalid q-value is treated as refused
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
 // SetBodyString sets the response body from a string
 func (r *Response) SetBodyString(body string) {
 	r.SetBody([]byte(body))
 }
 
+// Header returns the value of a response header, matched case-insensitively
+func (r *Response) Header(name string) string {
+	if value, ok := r.Headers[name]; ok {
+		return value
+	}
+
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
 // SetHeader sets a header value
 func (r *Response) SetHeader(key, value string) {
 	r.Headers[key] = value
 }
 
+// AddHeader adds a header that may occur several times, each value is written on its own header line. It is meant
+// for headers whose values cannot be joined with commas, like Set-Cookie
+func (r *Response) AddHeader(key, value string) {
+	if r.MultiHeaders == nil {
+		r.MultiHeaders = make(map[string][]string)
+	}
+	r.MultiHeaders[key] = append(r.MultiHeaders[key], value)
+}
+
+// HeaderValues returns all values of a header, matched case-insensitively: the one set with SetHeader followed by
+// the ones added with AddHeader
+func (r *Response) HeaderValues(name string) []string {
+	var values []string
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			values = append(values, value)
+		}
+	}
+	for key, multi := range r.MultiHeaders {
+		if strings.EqualFold(key, name) {
+			values = append(values, multi...)
+		}
+	}
+	return values
+}
+
+// writeHeaders writes one line per header, and one line per value of a repeated header
+func (r *Response) writeHeaders(buf *bytes.Buffer) {
+	for key, value := range r.Headers {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+	for key, values := range r.MultiHeaders {
+		for _, value := range values {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+		}
+	}
+}
+
+// version returns the protocol version used in the status line
+func (r *Response) version() string {
+	if r.Version == "" {
+		return "HTTP/1.1"
+	}
+	return r.Version
+}
+
 // Write sends the response to the connection
 func (r *Response) Write(conn net.Conn) error {
 	var buf bytes.Buffer
 
 	//write status line
-	buf.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", r.StatusCode, r.StatusText))
+	buf.WriteString(fmt.Sprintf("%s %d %s\r\n", r.version(), r.StatusCode, r.StatusText))
 
-	//add server and date headers if not present
-	if _, ok := r.Headers["Server"]; !ok {
-		r.Headers["Server"] = "IoT-Server/1.0"
-	}
+	//add a date header if not present, the Server header is the server's choice (see WithServerHeader)
 	if _, ok := r.Headers["Date"]; !ok {
 		r.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
 	}
 
-	//write headers
-	for key, value := range r.Headers {
-		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	//always frame the body with its actual length (0 for an empty body) so that a client knows where it ends,
+	//the body may also have been replaced (e.g. compressed) after SetBody. 204 and 304 never carry a body
+	if r.StatusCode == StatusNoContent || r.StatusCode == StatusNotModified {
+		delete(r.Headers, "Content-Length")
+		r.Body = nil
+		r.Stream = nil
+	} else if r.Stream != nil {
+		return r.writeStream(conn, &buf)
+	} else {
+		r.Headers["Content-Length"] = fmt.Sprintf("%d", len(r.Body))
 	}
+
+	//write headers
+	r.writeHeaders(&buf)
 	buf.WriteString("\r\n")
 
 	//write body if present
@@ -97,15 +268,15 @@ func (r *Response) Write(conn net.Conn) error {
 func (r *Response) String() string {
 	var buf bytes.Buffer
 
-	buf.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", r.StatusCode, r.StatusText))
+	buf.WriteString(fmt.Sprintf("%s %d %s\r\n", r.version(), r.StatusCode, r.StatusText))
 
-	for key, value := range r.Headers {
-		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
-	}
+	r.writeHeaders(&buf)
 
 	buf.WriteString("\r\n")
 
-	if r.Body != nil && len(r.Body) > 0 {
+	if r.Stream != nil {
+		buf.WriteString("[streamed body]")
+	} else if r.Body != nil && len(r.Body) > 0 {
 		buf.Write(r.Body)
 	}
 
@@ -135,3 +306,21 @@ func CreateTextResponse(statusCode int, body []byte) *Response {
 	response.SetBody(body)
 	return response
 }
+
+// ErrorDetail describes an error in the body of an error response
+type ErrorDetail struct {
+	Code    string `json:"code"`    //machine-readable error code, e.g. "invalid_json"
+	Message string `json:"message"` //human-readable description
+}
+
+// ErrorBody is the JSON body of responses created by CreateErrorResponse: {"error":{"code":...,"message":...}}
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// CreateErrorResponse creates a JSON error response, giving clients the same error shape on every endpoint
+func CreateErrorResponse(statusCode int, code, message string) *Response {
+	//a struct of strings always marshals
+	body, _ := json.Marshal(ErrorBody{Error: ErrorDetail{Code: code, Message: message}})
+	return CreateJSONResponse(statusCode, body)
+}
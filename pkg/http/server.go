@@ -1,44 +1,413 @@
 package http
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
 )
 
 // RequestHandler defines a function that handles HTTP requests
 type RequestHandler func(*Request) *Response
 
+// DefaultWriteTimeout is the write deadline of a response if Server.WriteTimeout is not set, so a client that stops
+// reading cannot block its connection's goroutine forever
+const DefaultWriteTimeout = 30 * time.Second
+
+// DefaultReadTimeout is the read deadline of a request if Server.ReadTimeout is not set, so a client that stops
+// sending in the middle of a request, e.g. short of its Content-Length, cannot block its connection's goroutine forever.
+// It is also how long an idle persistent connection is kept open
+const DefaultReadTimeout = 30 * time.Second
+
+// DefaultServerHeader is the Server header of the responses of a server created without WithServerHeader
+const DefaultServerHeader = "IoT-Server/1.0"
+
 // Server represents an HTTP server
 type Server struct {
-	Host     string                    //URL for the server to be hosted at; like http://localhost
-	Port     int                       //the PORT for the server to be hosted at; 8080 for example
-	Handlers map[string]RequestHandler //all the handlers that are supported by this server, for example POST or GET
-	listener net.Listener              //represents our TCP listener
-	wg       sync.WaitGroup
-	running  bool
-	mutex    sync.Mutex
+	Host                 string                               //URL for the server to be hosted at; like http://localhost
+	Port                 int                                  //the PORT for the server to be hosted at; 8080 for example
+	Handlers             map[string]RequestHandler            //all the handlers that are supported by this server, for example POST or GET
+	HostHandlers         map[string]map[string]RequestHandler //handlers per virtual host (lowercase, without port), preferred over Handlers
+	SocketPath           string                               //path of a Unix domain socket to listen on instead of Host:Port
+	CompressionThreshold int                                  //bodies of at least this many bytes are gzipped for clients that accept it; 0 disables compression
+	WriteTimeout         time.Duration                        //time a client gets to read one response before the connection is dropped; DefaultWriteTimeout if 0
+	ReadTimeout          time.Duration                        //time a client gets to send one complete request, answered with 408 if exceeded; DefaultReadTimeout if 0
+	serverHeader         string                               //Server header of the responses that set none, omitted if empty
+	listenBacklog        int                                  //length of the accept queue, the system default if 0
+	reusePort            bool                                 //set SO_REUSEPORT so several servers can listen on the same port
+	maxRequestsPerConn   int                                  //requests served on one connection before it is closed, unlimited if 0
+	trailingSlash        TrailingSlash                        //how a path differing from a registered one by a trailing slash is routed
+	caseSensitiveMethods bool                                 //match methods as sent instead of uppercasing them
+	listener             net.Listener                         //represents our TCP listener
+	connStats            connCounters
+	wg                   sync.WaitGroup
+	running              bool
+	mutex                sync.Mutex
+	conns                map[net.Conn]struct{} //open connections, so Stop can wake up idle persistent connections
+	closing              bool                  //set by Stop, no further requests are read once it is true
+	closingCh            chan struct{}         //closed when closing is set, see Closing
+	connMu               sync.Mutex            //guards conns and closing; separate from mutex which Stop holds while waiting
+	handlersMu           sync.RWMutex          //guards Handlers and HostHandlers; read locked during dispatch, write locked during registration
+}
+
+// ConnStats holds connection level counters of a server, independent of the requests sent over the connections
+type ConnStats struct {
+	Active       int64 `json:"active"`       //connections currently being handled
+	Accepted     int64 `json:"accepted"`     //connections accepted since the server was created
+	AcceptErrors int64 `json:"acceptErrors"` //failed accepts while the server was running
+	ParseErrors  int64 `json:"parseErrors"`  //connections whose request could not be parsed
+	Pipelined    int64 `json:"pipelined"`    //requests that had already arrived before the previous response was sent
+}
+
+// connCounters are the atomically updated counters behind ConnStats
+type connCounters struct {
+	active       atomic.Int64
+	accepted     atomic.Int64
+	acceptErrors atomic.Int64
+	parseErrors  atomic.Int64
+	pipelined    atomic.Int64
+}
+
+// ConnStats returns a snapshot of the connection counters
+func (s *Server) ConnStats() ConnStats {
+	return ConnStats{
+		Active:       s.connStats.active.Load(),
+		Accepted:     s.connStats.accepted.Load(),
+		AcceptErrors: s.connStats.acceptErrors.Load(),
+		ParseErrors:  s.connStats.parseErrors.Load(),
+		Pipelined:    s.connStats.pipelined.Load(),
+	}
+}
+
+// ServerOption configures a Server
+type ServerOption func(*Server)
+
+// WithServerHeader sets the Server header of the responses instead of DefaultServerHeader; an empty value omits the
+// header, so the responses do not reveal the implementation. A handler that sets the header itself keeps its value
+func WithServerHeader(value string) ServerOption {
+	return func(s *Server) {
+		s.serverHeader = value
+	}
+}
+
+// WithListenBacklog sets the length of the queue of connections the kernel has accepted but the server has not
+// yet; connections beyond it are refused or dropped under load. The kernel caps it, e.g. at net.core.somaxconn on Linux.
+// Only supported on Unix systems, Start fails elsewhere
+func WithListenBacklog(backlog int) ServerOption {
+	return func(s *Server) {
+		s.listenBacklog = backlog
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the listening socket, so several servers, e.g. one per process, can listen on
+// the same port and the kernel spreads the connections across them. Only supported on Unix systems and ignored for
+// Unix domain sockets
+func WithReusePort() ServerOption {
+	return func(s *Server) {
+		s.reusePort = true
+	}
+}
+
+// WithMaxRequestsPerConn closes a persistent connection after it served max requests, e.g. so clients behind a load
+// balancer reconnect now and then and get spread across the servers again. The last response carries Connection: close
+func WithMaxRequestsPerConn(max int) ServerOption {
+	return func(s *Server) {
+		s.maxRequestsPerConn = max
+	}
+}
+
+// TrailingSlash selects how a request is routed whose path only differs from a registered one by a trailing slash
+type TrailingSlash int
+
+const (
+	TrailingSlashStrict   TrailingSlash = iota //"/data/" and "/data" are different paths, the default
+	TrailingSlashRedirect                      //answered with 308 Permanent Redirect to the registered path
+	TrailingSlashMerge                         //served by the handler of the registered path
+)
+
+// String returns the name of the mode as accepted by ParseTrailingSlash
+func (m TrailingSlash) String() string {
+	switch m {
+	case TrailingSlashStrict:
+		return "strict"
+	case TrailingSlashRedirect:
+		return "redirect"
+	case TrailingSlashMerge:
+		return "merge"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTrailingSlash returns the mode with the given name ("strict", "redirect" or "merge")
+func ParseTrailingSlash(name string) (TrailingSlash, error) {
+	for _, mode := range []TrailingSlash{TrailingSlashStrict, TrailingSlashRedirect, TrailingSlashMerge} {
+		if strings.EqualFold(name, mode.String()) {
+			return mode, nil
+		}
+	}
+	return TrailingSlashStrict, fmt.Errorf("unknown trailing slash mode %q (expected strict, redirect or merge)", name)
+}
+
+// WithTrailingSlash sets how a request for "/data/" is routed if only "/data" is registered, or the other way round.
+// Only requests that find no handler under their own path are affected, so a path registered with and without the
+// slash keeps both handlers. TrailingSlashRedirect keeps the query string and the method, as 308 requires clients to
+// resend the body; TrailingSlashMerge hands the handler the registered path in req.Path
+func WithTrailingSlash(mode TrailingSlash) ServerOption {
+	return func(s *Server) {
+		s.trailingSlash = mode
+	}
+}
+
+// WithCaseSensitiveMethods matches the method of a request exactly as it was sent, as methods are case-sensitive by
+// the HTTP spec, so "get" gets a 404 from a GET handler. By default methods are uppercased when a handler is
+// registered and before a request is dispatched
+func WithCaseSensitiveMethods() ServerOption {
+	return func(s *Server) {
+		s.caseSensitiveMethods = true
+	}
 }
 
 // ServerFactory creates a new HTTP server instance
-func ServerFactory(host string, port int) *Server {
-	return &Server{
-		Host:     host,
-		Port:     port,
-		Handlers: make(map[string]RequestHandler), //just alloc the space for now
+func ServerFactory(host string, port int, opts ...ServerOption) *Server {
+	s := &Server{
+		Host:         host,
+		Port:         port,
+		Handlers:     make(map[string]RequestHandler), //just alloc the space for now
+		serverHeader: DefaultServerHeader,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// UnixSocketServerFactory creates a new HTTP server instance that listens on a Unix domain socket
+func UnixSocketServerFactory(socketPath string, opts ...ServerOption) *Server {
+	s := &Server{
+		SocketPath:   socketPath,
+		Handlers:     make(map[string]RequestHandler),
+		serverHeader: DefaultServerHeader,
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
-// RegisterHandler registers a handler for a specific HTTP method and path
+// RegisterHandler registers a handler for a specific HTTP method and path. It is safe to call while the server is
+// running; requests that are already being dispatched keep the handler they found, later ones see the new one
 func (s *Server) RegisterHandler(method, path string, handler RequestHandler) {
+	method = s.routeMethod(method)
 	key := method + " " + path
+	s.handlersMu.Lock()
+	if s.Handlers == nil {
+		s.Handlers = make(map[string]RequestHandler)
+	}
 	s.Handlers[key] = handler
+	s.handlersMu.Unlock()
 	log.Printf("Registered handler for %s %s", method, path)
 }
 
-// Start starts the HTTP server
+// RegisterHandlerForHost registers a handler for a specific HTTP method and path that is only used for requests
+// whose Host header matches host; such handlers take precedence over the ones registered with RegisterHandler.
+// Like RegisterHandler, it is safe to call while the server is running
+func (s *Server) RegisterHandlerForHost(host, method, path string, handler RequestHandler) {
+	host = normalizeHost(host)
+	method = s.routeMethod(method)
+	s.handlersMu.Lock()
+	if s.HostHandlers == nil {
+		s.HostHandlers = make(map[string]map[string]RequestHandler)
+	}
+	if s.HostHandlers[host] == nil {
+		s.HostHandlers[host] = make(map[string]RequestHandler)
+	}
+
+	s.HostHandlers[host][method+" "+path] = handler
+	s.handlersMu.Unlock()
+	log.Printf("Registered handler for %s %s on host %s", method, path, host)
+}
+
+// RouteKind tells how a registered path is matched, see findHandler for the precedence
+type RouteKind string
+
+const (
+	RouteExact    RouteKind = "exact"    //the path has to match exactly, e.g. "/data"
+	RoutePrefix   RouteKind = "prefix"   //any path below the prefix, e.g. "/data/*"
+	RouteWildcard RouteKind = "wildcard" //any path of the method, "*"
+)
+
+// RouteInfo describes one registered handler
+type RouteInfo struct {
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Host   string    `json:"host,omitempty"` //virtual host of a handler registered with RegisterHandlerForHost
+	Kind   RouteKind `json:"kind"`
+}
+
+// Routes returns all registered handlers sorted by host, path and method, host agnostic ones first
+func (s *Server) Routes() []RouteInfo {
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+
+	var routes []RouteInfo
+	addRoutes := func(host string, handlers map[string]RequestHandler) {
+		for key := range handlers {
+			method, path, _ := strings.Cut(key, " ")
+			routes = append(routes, RouteInfo{Method: method, Path: path, Host: host, Kind: routeKind(path)})
+		}
+	}
+	addRoutes("", s.Handlers)
+	for host, handlers := range s.HostHandlers {
+		addRoutes(host, handlers)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return routes
+}
+
+// routeKind classifies a registered path
+func routeKind(path string) RouteKind {
+	switch {
+	case path == "*":
+		return RouteWildcard
+	case strings.HasSuffix(path, "/*"):
+		return RoutePrefix
+	default:
+		return RouteExact
+	}
+}
+
+// findHandler looks up the handler for a request: host specific handlers first, then the host agnostic ones,
+// each trying the exact path, then the longest matching prefix pattern ("/data/*") and finally the wildcard handler of the method
+func (s *Server) findHandler(req *Request) (RequestHandler, bool) {
+	//the handler is only looked up under the lock, it runs after the lock is released
+	s.handlersMu.RLock()
+	defer s.handlersMu.RUnlock()
+
+	handlerSets := []map[string]RequestHandler{s.Handlers}
+	if hostHandlers, ok := s.HostHandlers[normalizeHost(req.Header("Host"))]; ok {
+		handlerSets = []map[string]RequestHandler{hostHandlers, s.Handlers}
+	}
+
+	for _, handlers := range handlerSets {
+		if handler, ok := handlers[req.Method+" "+req.Path]; ok {
+			return handler, true
+		}
+		if handler, ok := findPrefixHandler(handlers, req.Method, req.Path); ok {
+			return handler, true
+		}
+		if handler, ok := handlers[req.Method+" *"]; ok {
+			return handler, true
+		}
+	}
+	return nil, false
+}
+
+// findPrefixHandler returns the handler registered as "<prefix>/*" with the longest prefix of path
+func findPrefixHandler(handlers map[string]RequestHandler, method, path string) (RequestHandler, bool) {
+	var best RequestHandler
+	bestLength := -1
+
+	for key, handler := range handlers {
+		pattern, ok := strings.CutPrefix(key, method+" ")
+		if !ok || !strings.HasSuffix(pattern, "/*") {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(pattern, "*")
+		if strings.HasPrefix(path, prefix) && len(prefix) > bestLength {
+			best, bestLength = handler, len(prefix)
+		}
+	}
+
+	return best, best != nil
+}
+
+// routeMethod returns the method as handlers are registered and looked up under, uppercased unless
+// WithCaseSensitiveMethods is set
+func (s *Server) routeMethod(method string) string {
+	if s.caseSensitiveMethods {
+		return method
+	}
+	return strings.ToUpper(method)
+}
+
+// trailingSlashHandler looks up the handler of a request that found none under its own path with the trailing slash
+// added or removed, as configured by WithTrailingSlash. For TrailingSlashRedirect the returned handler answers with
+// the redirect to that path, for TrailingSlashMerge req.Path is set to it
+func (s *Server) trailingSlashHandler(req *Request) (RequestHandler, bool) {
+	if s.trailingSlash == TrailingSlashStrict || req.Path == "" || req.Path == "/" {
+		return nil, false
+	}
+
+	alternate := req.Path + "/"
+	if strings.HasSuffix(req.Path, "/") {
+		alternate = strings.TrimSuffix(req.Path, "/")
+	}
+	alternateReq := *req
+	alternateReq.Path = alternate
+	handler, ok := s.findHandler(&alternateReq)
+	if !ok {
+		return nil, false
+	}
+
+	if s.trailingSlash == TrailingSlashMerge {
+		req.Path = alternate
+		return handler, true
+	}
+
+	location := alternate
+	if req.RawQuery != "" {
+		location += "?" + req.RawQuery
+	}
+	return func(*Request) *Response {
+		resp := NewResponse(StatusPermanentRedirect)
+		resp.SetHeader("Location", location)
+		resp.SetBodyString("Moved to " + location)
+		return resp
+	}, true
+}
+
+// normalizeHost lowercases a host and strips the port, so "Example.com:8080" matches "example.com"
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+}
+
+// Start starts the HTTP server, also again after Shutdown
 func (s *Server) Start() error {
 	s.mutex.Lock()
 	if s.running {
@@ -48,24 +417,83 @@ func (s *Server) Start() error {
 	s.running = true
 	s.mutex.Unlock()
 
-	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	//a server started again after Shutdown serves requests again, Closing returns a new channel
+	s.connMu.Lock()
+	if s.closing {
+		s.closing = false
+		s.closingCh = nil
+	}
+	s.connMu.Unlock()
+
+	network, addr := "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	if s.SocketPath != "" {
+		network, addr = "unix", s.SocketPath
+
+		//remove a stale socket left behind by a crashed server, but never any other kind of file
+		if info, err := os.Lstat(s.SocketPath); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				s.running = false
+				return fmt.Errorf("error starting server: %s exists and is not a socket", s.SocketPath)
+			}
+			if err := os.Remove(s.SocketPath); err != nil {
+				s.running = false
+				return fmt.Errorf("error removing stale socket %s: %w", s.SocketPath, err)
+			}
+		}
+	}
+
+	//SO_REUSEADDR is already set by the net package on Unix systems, so a restarted server can bind its port while
+	//connections of the previous one are still in TIME_WAIT
+	var config net.ListenConfig
+	if s.reusePort && network == "tcp" {
+		config.Control = reusePortControl
+	}
+
 	var err error
-	s.listener, err = net.Listen("tcp", addr)
+	s.listener, err = config.Listen(context.Background(), network, addr)
 	if err != nil {
 		s.running = false
 		return fmt.Errorf("error starting server on %s: %w", addr, err)
 	}
 
+	if s.listenBacklog > 0 {
+		if err := setListenBacklog(s.listener, s.listenBacklog); err != nil {
+			s.listener.Close()
+			s.running = false
+			return fmt.Errorf("error setting listen backlog of %s: %w", addr, err)
+		}
+	}
+
 	log.Printf("Server started on %s", addr)
 
-	//accept connections in a goroutine
-	go s.acceptConnections()
+	//accept connections in a goroutine, Shutdown waits for it like for the connections, so no connection can be
+	//added to the wait group once it waits
+	s.wg.Add(1)
+	go s.acceptConnections(s.listener)
 
 	return nil
 }
 
-// Stop stops the HTTP server
+// Closing returns a channel that is closed when Shutdown begins. Handlers that block, e.g. long polls, should return
+// once it is closed, otherwise Shutdown waits for them
+func (s *Server) Closing() <-chan struct{} {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.closingCh == nil {
+		s.closingCh = make(chan struct{})
+	}
+	return s.closingCh
+}
+
+// Stop stops the HTTP server and waits for all connections to finish
 func (s *Server) Stop() error {
+	return s.Shutdown(0)
+}
+
+// Shutdown stops accepting connections and waits up to timeout (0 = no limit) for the requests in progress to be
+// answered. Connections still busy after the timeout are closed; their handlers keep running in the background, so
+// whatever they use must stay safe to call after Shutdown returned with an error
+func (s *Server) Shutdown(timeout time.Duration) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -76,72 +504,298 @@ func (s *Server) Stop() error {
 	err := s.listener.Close()
 	s.running = false
 
+	//idle persistent connections would block until their read deadline, so make their pending reads return now
+	s.connMu.Lock()
+	s.closing = true
+	if s.closingCh == nil {
+		s.closingCh = make(chan struct{})
+	}
+	close(s.closingCh)
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.connMu.Unlock()
+
 	//wait for all connections to finish
-	s.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case <-done:
+	case <-expired:
+		s.connMu.Lock()
+		busy := len(s.conns)
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connMu.Unlock()
+		err = errors.Join(err, fmt.Errorf("%d connections still busy after %v, closed", busy, timeout))
+	}
+
+	if s.SocketPath != "" {
+		if removeErr := os.Remove(s.SocketPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Printf("Error removing socket %s: %v", s.SocketPath, removeErr)
+		}
+	}
 	log.Printf("Server stopped")
 
 	return err
 }
 
-// acceptConnections accepts new connections and handles them
-func (s *Server) acceptConnections() {
+// acceptConnections accepts new connections and handles them until the listener is closed
+func (s *Server) acceptConnections(listener net.Listener) {
+	defer s.wg.Done()
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			//check if the server is shutting down
-			s.mutex.Lock()
-			running := s.running
-			s.mutex.Unlock()
-
-			//the server has shut down so we dont have any errors to print
-			if !running {
-				break
+			//the server has shut down so we dont have any errors to print; Shutdown holds mutex while it waits for
+			//this loop, so the closed listener tells us instead of running
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
 
+			s.connStats.acceptErrors.Add(1)
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
+		s.connStats.accepted.Add(1)
+
 		//handle each connection in a separate goroutine
 		s.wg.Add(1)
 		go func(c net.Conn) {
 			defer s.wg.Done()
-			defer c.Close()
-
-			s.handleConnection(c)
+			s.ServeConn(c)
 		}(conn)
 	}
 }
 
-// handleConnection processes an individual HTTP connection
+// trackConn adds or removes a connection from the set of open connections
+func (s *Server) trackConn(conn net.Conn, add bool) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		return
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+}
+
+// ServeConn serves the requests of an already established connection like those of an accepted one and closes it
+// afterwards; it returns once the connection is done
+func (s *Server) ServeConn(conn net.Conn) {
+	s.connStats.active.Add(1)
+	s.trackConn(conn, true)
+	defer s.connStats.active.Add(-1)
+	defer s.trackConn(conn, false)
+	defer conn.Close()
+
+	s.handleConnection(conn)
+}
+
+// isClosing reports whether Stop or Shutdown was called
+func (s *Server) isClosing() bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.closing
+}
+
+// setConnectionHeader sets Connection to keep-alive or close, replacing a Connection header of the handler in any case
+func setConnectionHeader(resp *Response, keepAlive bool) {
+	for key := range resp.Headers {
+		if strings.EqualFold(key, "Connection") {
+			delete(resp.Headers, key)
+		}
+	}
+	if keepAlive {
+		resp.SetHeader("Connection", "keep-alive")
+	} else {
+		resp.SetHeader("Connection", "close")
+	}
+}
+
+// handleConnection processes the requests of an HTTP connection; the connection is kept open for further requests
+// as long as the client asks for it (see Request.KeepAlive), the handler does not answer with Connection: close, the
+// limit of WithMaxRequestsPerConn is not reached and the server is not shutting down
 func (s *Server) handleConnection(conn net.Conn) {
-	//set a read timeout
-	err := conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-	if err != nil {
-		log.Printf("Error setting read deadline: %v", err)
+	reader := bufio.NewReader(conn)
+
+	served := 0
+	for first := true; ; first = false {
+		//set a read timeout covering the whole request including its body, unless Stop already woke up the idle connections
+		readTimeout := s.ReadTimeout
+		if readTimeout <= 0 {
+			readTimeout = DefaultReadTimeout
+		}
+		s.connMu.Lock()
+		closing := s.closing
+		var err error
+		if !closing {
+			err = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		s.connMu.Unlock()
+		if closing {
+			return
+		}
+		if err != nil {
+			logging.Warnf("Error setting read deadline: %v", err)
+			return
+		}
+
+		//parse the request
+		req, err := readRequest(reader)
+		if errors.Is(err, ErrConnectionClosed) {
+			return
+		}
+		if !first && errors.Is(err, os.ErrDeadlineExceeded) {
+			//an idle persistent connection timed out or the server is shutting down
+			return
+		}
+		if (errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, ErrRequestTimeout)) && s.isClosing() {
+			//Stop cut the read short, the client did nothing wrong and gets no answer on the closed connection
+			return
+		}
+		if err != nil {
+			s.connStats.parseErrors.Add(1)
+			logging.Warnf("Error parsing request: %v", err)
+			statusCode := StatusBadRequest
+			if errors.Is(err, ErrHeaderTooLarge) {
+				statusCode = StatusHeaderTooLarge
+			} else if errors.Is(err, ErrRequestTimeout) {
+				statusCode = StatusRequestTimeout
+			}
+			resp := NewResponse(statusCode)
+			resp.SetBodyString(fmt.Sprintf("Bad request: %v", err))
+			resp.SetHeader("Connection", "close")
+			s.writeResponse(conn, resp)
+			return
+		}
+		if remoteAddr := conn.RemoteAddr(); remoteAddr != nil {
+			req.RemoteAddr = remoteAddr.String()
+		}
+
+		logging.Debugf("Received request: %s %s", req.Method, req.Path)
+
+		//bytes of the next request already buffered mean the client pipelined it; it is served after this
+		//response, so responses go out in request order
+		if reader.Buffered() > 0 {
+			s.connStats.pipelined.Add(1)
+		}
+
+		span := s.startRequestSpan(req)
+		resp := s.serveRequest(req)
+
+		//answer in the version of the request so that HTTP/1.0 clients do not get HTTP/1.1 semantics
+		resp.Version = "HTTP/1.1"
+		if req.Version == "HTTP/1.0" {
+			resp.Version = "HTTP/1.0"
+		}
+
+		//every response tells the client whether it may send another request on the connection, so it never reuses
+		//one the server is about to close: after Connection: close from the client or the handler, on the last
+		//request allowed per connection, once the server is shutting down and after a body whose end only the
+		//close marks
+		served++
+		keepAlive := req.KeepAlive() && !strings.EqualFold(resp.Header("Connection"), "close") &&
+			(s.maxRequestsPerConn <= 0 || served < s.maxRequestsPerConn) && !s.isClosing() && !resp.closeDelimited()
+		setConnectionHeader(resp, keepAlive)
+
+		err = s.writeResponse(conn, resp)
+		endRequestSpan(span, resp, err)
+		if err != nil || !keepAlive {
+			return
+		}
+	}
+}
+
+// startRequestSpan starts the server span of a request, continuing the trace of the client if it sent a traceparent
+// header, and stores it in the context of the request. While tracing is disabled it returns a no-op span
+func (s *Server) startRequestSpan(req *Request) trace.Span {
+	if !tracing.Enabled() {
+		return noop.Span{}
+	}
+
+	ctx := tracing.Extract(context.Background(), req.Header)
+	ctx, span := tracing.Start(ctx, req.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLPath(req.Path),
+			semconv.NetworkProtocolVersion(strings.TrimPrefix(req.Version, "HTTP/")),
+			semconv.ClientAddress(req.ClientIP(false)),
+		),
+	)
+	req.ctx = ctx
+	return span
+}
+
+// endRequestSpan records the status of the response and ends the span; server errors and failed writes mark it failed
+func endRequestSpan(span trace.Span, resp *Response, writeErr error) {
+	if !span.IsRecording() {
 		return
 	}
 
-	//parse the request
-	req, err := ParseRequest(conn)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if writeErr != nil {
+		span.SetStatus(codes.Error, writeErr.Error())
+	} else if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, strconv.Itoa(resp.StatusCode))
+	}
+	span.End()
+}
+
+// writeResponse writes a response within the write timeout; a client that does not read it in time is treated like
+// a dropped connection. The error is logged, the caller only has to close the connection
+func (s *Server) writeResponse(conn net.Conn, resp *Response) error {
+	timeout := s.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		logging.Warnf("Error setting write deadline: %v", err)
+		return err
+	}
+
+	if _, ok := resp.Headers["Server"]; !ok && s.serverHeader != "" {
+		resp.Headers["Server"] = s.serverHeader
+	}
+
+	//a streamed body may take longer than the timeout as a whole, the client only has to keep reading
+	if resp.Stream != nil {
+		conn = &deadlineConn{Conn: conn, timeout: timeout}
+	}
+
+	err := resp.Write(conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		logging.Debugf("Client %s did not read the response within %v, dropping the connection", conn.RemoteAddr(), timeout)
+		return err
+	}
 	if err != nil {
-		log.Printf("Error parsing request: %v", err)
-		resp := NewResponse(StatusBadRequest)
-		resp.SetBodyString(fmt.Sprintf("Bad request: %v", err))
-		resp.Write(conn)
-		return
+		logging.Warnf("Error writing response: %v", err)
 	}
+	return err
+}
 
-	log.Printf("Received request: %s %s", req.Method, req.Path)
+// serveRequest runs the handler of a request and compresses its response if the client accepts it
+func (s *Server) serveRequest(req *Request) *Response {
+	if !s.caseSensitiveMethods {
+		req.Method = strings.ToUpper(req.Method)
+	}
 
 	//find and execute the handler
-	handlerKey := fmt.Sprintf("%s %s", req.Method, req.Path)
-	handler, ok := s.Handlers[handlerKey]
-
-	//try a wildcard handler if specific handler not found
+	handler, ok := s.findHandler(req)
 	if !ok {
-		handlerKey = fmt.Sprintf("%s *", req.Method)
-		handler, ok = s.Handlers[handlerKey]
+		handler, ok = s.trailingSlashHandler(req)
 	}
 
 	var resp *Response
@@ -153,8 +807,12 @@ func (s *Server) handleConnection(conn net.Conn) {
 		resp.SetBodyString(fmt.Sprintf("No handler for %s %s", req.Method, req.Path))
 	}
 
-	err = resp.Write(conn)
-	if err != nil {
-		log.Printf("Error writing response: %v", err)
+	//compress after the handler so that Content-Length matches the bytes on the wire
+	if s.CompressionThreshold > 0 && acceptsGzip(req.Header("Accept-Encoding")) {
+		if _, err := resp.Compress(s.CompressionThreshold); err != nil {
+			logging.Warnf("Error compressing response: %v", err)
+		}
 	}
+
+	return resp
 }
@@ -0,0 +1,159 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// streamChunkSize is the amount of streamed body collected before it is sent as one chunk
+const streamChunkSize = 32 * 1024
+
+// BodyStream writes the body of a streaming response; an error after the first bytes were sent cannot be reported
+// to the client any more, the connection is closed instead so it sees an incomplete body
+type BodyStream func(w io.Writer) error
+
+// NewStreamingResponse creates a response whose body is written by stream straight to the connection after the
+// headers, instead of being collected in Body first, e.g. for large exports. The length is not known up front, so
+// HTTP/1.1 clients get the body with chunked transfer encoding and HTTP/1.0 clients get it delimited by closing the
+// connection. A streamed body is gzipped whenever the client accepts it and compression is enabled, byte ranges are
+// not supported
+func NewStreamingResponse(statusCode int, contentType string, stream BodyStream) *Response {
+	resp := NewResponse(statusCode)
+	resp.SetContentType(contentType)
+	resp.Stream = stream
+	return resp
+}
+
+// closeDelimited reports whether the end of the body is marked by closing the connection, which is the case for a
+// streamed body sent to an HTTP/1.0 client
+func (r *Response) closeDelimited() bool {
+	return r.Stream != nil && r.version() == "HTTP/1.0"
+}
+
+// compressStream gzips the streamed body while it is written
+func (r *Response) compressStream() {
+	stream := r.Stream
+	r.Stream = func(w io.Writer) error {
+		writer := gzip.NewWriter(w)
+		if err := stream(writer); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+	r.Headers["Content-Encoding"] = "gzip"
+	r.Headers["Vary"] = "Accept-Encoding"
+}
+
+// writeStream sends the status line and headers and then the body written by Stream, chunked for HTTP/1.1
+func (r *Response) writeStream(conn net.Conn, head *bytes.Buffer) error {
+	delete(r.Headers, "Content-Length")
+	chunked := !r.closeDelimited()
+	if chunked {
+		r.Headers["Transfer-Encoding"] = "chunked"
+	}
+	r.writeHeaders(head)
+	head.WriteString("\r\n")
+
+	out := bufio.NewWriter(conn)
+	out.Write(head.Bytes())
+
+	//the body is collected into chunks of streamChunkSize, so many small writes of the handler do not each become a
+	//chunk of their own
+	var body io.Writer = out
+	var chunks *chunkedWriter
+	if chunked {
+		chunks = &chunkedWriter{w: out}
+		body = chunks
+	}
+	buffered := bufio.NewWriterSize(body, streamChunkSize)
+
+	if err := r.Stream(buffered); err != nil {
+		return fmt.Errorf("error streaming body: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if chunks != nil {
+		if err := chunks.Close(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// chunkedWriter writes every Write as one chunk of the chunked transfer encoding
+type chunkedWriter struct {
+	w *bufio.Writer
+}
+
+// Write sends p as one chunk, an empty p is skipped since it would end the body
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	fmt.Fprintf(c.w, "%x\r\n", len(p))
+	c.w.Write(p)
+	_, err := c.w.WriteString("\r\n")
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the last, empty chunk that ends the body
+func (c *chunkedWriter) Close() error {
+	_, err := c.w.WriteString("0\r\n\r\n")
+	return err
+}
+
+// deadlineConn renews the write deadline before every write, so a streamed body gets the write timeout per chunk
+// instead of for the whole response
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Write renews the deadline and writes p
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// decodeChunked returns the body of a response sent with chunked transfer encoding; trailers are ignored. A body
+// that ends before the last chunk wraps ErrReadBody, a malformed one ErrParse
+func decodeChunked(body []byte) ([]byte, error) {
+	var decoded bytes.Buffer
+	for {
+		line, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("%w: connection closed in the chunked body after %d bytes", ErrReadBody, decoded.Len())
+		}
+
+		//chunk extensions after ";" carry nothing we use
+		sizeField, _, _ := bytes.Cut(line, []byte(";"))
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(sizeField)), 16, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: invalid chunk size %q", ErrParse, line)
+		}
+		if size == 0 {
+			return decoded.Bytes(), nil
+		}
+
+		if int64(len(rest)) < size+2 {
+			return nil, fmt.Errorf("%w: connection closed in the chunked body after %d bytes", ErrReadBody, decoded.Len())
+		}
+		if !bytes.HasPrefix(rest[size:], []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: chunk of %d bytes not followed by CRLF", ErrParse, size)
+		}
+		decoded.Write(rest[:size])
+		body = rest[size+2:]
+	}
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a log line; lines below the global level are dropped
+type Level int32
+
+// The levels leave gaps like log/slog, so the zero value is LevelInfo, the default
+const (
+	LevelDebug Level = -4 //per-request and per-message lines of the hot paths
+	LevelInfo  Level = 0  //startup, shutdown and other rare events
+	LevelWarn  Level = 4  //failed requests and other problems the process recovers from
+	LevelError Level = 8  //failures that need attention
+)
+
+// level is the global level, read on every log call
+var level atomic.Int32
+
+// SetLevel sets the global level, e.g. from the -log-level flag at startup
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// CurrentLevel returns the global level
+func CurrentLevel() Level {
+	return Level(level.Load())
+}
+
+// Enabled reports whether lines of the given level are written, e.g. to skip building an expensive message
+func Enabled(l Level) bool {
+	return l >= CurrentLevel()
+}
+
+// ParseLevel parses debug, info, warn (or warning) and error, ignoring case
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", value)
+}
+
+// String returns the name ParseLevel accepts for the level
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "debug"
+	case l <= LevelInfo:
+		return "info"
+	case l <= LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// Debugf logs a line at LevelDebug through the standard logger
+func Debugf(format string, args ...any) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof logs a line at LevelInfo through the standard logger
+func Infof(format string, args ...any) {
+	logf(LevelInfo, format, args...)
+}
+
+// Warnf logs a line at LevelWarn through the standard logger
+func Warnf(format string, args ...any) {
+	logf(LevelWarn, format, args...)
+}
+
+// Errorf logs a line at LevelError through the standard logger
+func Errorf(format string, args ...any) {
+	logf(LevelError, format, args...)
+}
+
+// logf writes the line if the level is enabled; going through the standard logger keeps its flags and output, so
+// log.SetOutput applies to leveled and plain log lines alike
+func logf(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	log.Output(3, fmt.Sprintf(format, args...))
+}
+
+// levelFlag is the flag.Value of -log-level, setting the global level as soon as the flag is parsed
+type levelFlag struct{}
+
+func (levelFlag) String() string {
+	return CurrentLevel().String()
+}
+
+func (levelFlag) Set(value string) error {
+	l, err := ParseLevel(value)
+	if err != nil {
+		return err
+	}
+	SetLevel(l)
+	return nil
+}
+
+// RegisterFlags adds -log-level and its shorthands -v (debug) and -q (warn) to the flag set; the last one given wins
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(levelFlag{}, "log-level", "Minimum level of the log lines written: debug, info, warn or error")
+	fs.BoolFunc("v", "Verbose logging, same as -log-level debug", setLevelIf(LevelDebug))
+	fs.BoolFunc("q", "Quiet logging, same as -log-level warn (drops the per-request lines)", setLevelIf(LevelWarn))
+}
+
+// setLevelIf returns the handler of a boolean shorthand flag, which only changes the level when it is true
+func setLevelIf(l Level) func(string) error {
+	return func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			SetLevel(l)
+		}
+		return nil
+	}
+}
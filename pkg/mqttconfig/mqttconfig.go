@@ -0,0 +1,148 @@
+package mqttconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// PasswordEnv is the environment variable the password is read from if a username but no password is set, so the
+// password does not have to appear on the command line
+const PasswordEnv = "MQTT_PASSWORD"
+
+// Security holds the credentials and TLS settings a client needs for a secured broker; the zero value connects
+// anonymously over plain TCP
+type Security struct {
+	Username string //empty connects anonymously
+	Password string //PasswordEnv if empty
+	CAFile   string //PEM file of the CA the broker's certificate is checked against, required for ssl:// and tls:// brokers
+	CertFile string //PEM client certificate for brokers that require one, together with KeyFile
+	KeyFile  string
+}
+
+// RegisterFlags adds -mqtt-username, -mqtt-password, -mqtt-ca-file, -mqtt-cert-file and -mqtt-key-file to the flag
+// set, stored in s
+func (s *Security) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.Username, "mqtt-username", s.Username, "Username for the MQTT broker (empty = anonymous)")
+	fs.StringVar(&s.Password, "mqtt-password", s.Password, "Password for the MQTT broker (empty = $"+PasswordEnv+")")
+	fs.StringVar(&s.CAFile, "mqtt-ca-file", s.CAFile, "PEM file of the CA the certificate of an ssl:// or tls:// broker is checked against")
+	fs.StringVar(&s.CertFile, "mqtt-cert-file", s.CertFile, "PEM client certificate for brokers that require one (with -mqtt-key-file)")
+	fs.StringVar(&s.KeyFile, "mqtt-key-file", s.KeyFile, "PEM private key of the client certificate")
+}
+
+// BrokerURL returns the URL of a broker given as host:port (plain TCP) or as tcp://, ssl:// or tls:// URL
+func BrokerURL(broker string) string {
+	if strings.Contains(broker, "://") {
+		return broker
+	}
+	return "tcp://" + broker
+}
+
+// Validate checks the brokers and that the settings are complete for them, e.g. that a TLS broker has a CA file
+func (s Security) Validate(brokers []string) error {
+	usesTLS := false
+	for _, broker := range brokers {
+		secure, err := isTLS(broker)
+		if err != nil {
+			return err
+		}
+		usesTLS = usesTLS || secure
+	}
+
+	if s.Password != "" && s.Username == "" {
+		return errors.New("an MQTT password needs a username")
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return errors.New("an MQTT client certificate needs both a cert file and a key file")
+	}
+	if !usesTLS {
+		if s.CAFile != "" || s.CertFile != "" {
+			return errors.New("MQTT TLS files are set but no broker uses ssl:// or tls://")
+		}
+		return nil
+	}
+	if s.CAFile == "" {
+		return errors.New("an ssl:// or tls:// MQTT broker needs a CA file to verify its certificate")
+	}
+	return nil
+}
+
+// Apply validates the settings and adds the brokers, the credentials and, for TLS brokers, the TLS configuration to
+// the client options
+func (s Security) Apply(opts *mqtt.ClientOptions, brokers []string) error {
+	if err := s.Validate(brokers); err != nil {
+		return err
+	}
+
+	for _, broker := range brokers {
+		opts.AddBroker(BrokerURL(broker))
+	}
+	if s.Username != "" {
+		password := s.Password
+		if password == "" {
+			password = os.Getenv(PasswordEnv)
+		}
+		opts.SetUsername(s.Username)
+		opts.SetPassword(password)
+	}
+
+	if s.CAFile != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return nil
+}
+
+// tlsConfig loads the CA and the client certificate
+func (s Security) tlsConfig() (*tls.Config, error) {
+	caPEM, err := os.ReadFile(s.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading MQTT CA file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("MQTT CA file %s holds no PEM certificate", s.CAFile)
+	}
+
+	config := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading MQTT client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// isTLS checks that a broker is host:port or a tcp://, ssl:// or tls:// URL with host and port and reports whether
+// it uses TLS
+func isTLS(broker string) (bool, error) {
+	parsed, err := url.Parse(BrokerURL(broker))
+	if err != nil {
+		return false, fmt.Errorf("MQTT broker %q is not a valid URL: %w", broker, err)
+	}
+	if _, _, err := net.SplitHostPort(parsed.Host); err != nil {
+		return false, fmt.Errorf("MQTT broker %q is not host:port: %w", broker, err)
+	}
+
+	switch parsed.Scheme {
+	case "tcp":
+		return false, nil
+	case "ssl", "tls":
+		return true, nil
+	default:
+		return false, fmt.Errorf("MQTT broker %q: unsupported scheme %q, expected tcp, ssl or tls", broker, parsed.Scheme)
+	}
+}
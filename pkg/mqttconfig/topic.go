@@ -0,0 +1,165 @@
+package mqttconfig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultTopicTemplate is the topic sensors publish to unless a template is configured
+const DefaultTopicTemplate = "sensors/{type}/{id}"
+
+// placeholders a topic template may contain, each as a whole topic level
+const (
+	typePlaceholder = "{type}"
+	idPlaceholder   = "{id}"
+)
+
+// defaultTopicLevels are the levels of DefaultTopicTemplate, used by the zero TopicTemplate
+var defaultTopicLevels = strings.Split(DefaultTopicTemplate, "/")
+
+// TopicTemplate describes the topic of a sensor, e.g. site/{type}/{id}/telemetry: {type} stands for the sensor type
+// and {id} for the sensor ID, every other level is literal. Sensors render their topic from it, the gateway derives
+// its subscription filter from it and parses the type and ID back out of the topics it receives. The zero value is
+// DefaultTopicTemplate
+type TopicTemplate struct {
+	levels []string //the template split at "/", nil for DefaultTopicTemplate
+}
+
+// ParseTopicTemplate parses a template; {type} and {id} may each appear at most once and must fill a whole topic
+// level, literal levels must not contain the MQTT wildcards + and #
+func ParseTopicTemplate(template string) (TopicTemplate, error) {
+	if template == "" {
+		return TopicTemplate{}, errors.New("empty topic template")
+	}
+
+	levels := strings.Split(template, "/")
+	seen := make(map[string]bool)
+	for _, level := range levels {
+		switch {
+		case level == typePlaceholder || level == idPlaceholder:
+			if seen[level] {
+				return TopicTemplate{}, fmt.Errorf("topic template %q contains %s twice", template, level)
+			}
+			seen[level] = true
+		case strings.ContainsAny(level, "{}"):
+			return TopicTemplate{}, fmt.Errorf("topic template %q: %q is not a placeholder, expected %s or %s as a whole level", template, level, typePlaceholder, idPlaceholder)
+		case strings.ContainsAny(level, "+#"):
+			return TopicTemplate{}, fmt.Errorf("topic template %q: level %q contains an MQTT wildcard", template, level)
+		}
+	}
+
+	return TopicTemplate{levels: levels}, nil
+}
+
+// topicLevels returns the levels of the template, those of DefaultTopicTemplate for the zero value
+func (t TopicTemplate) topicLevels() []string {
+	if t.levels == nil {
+		return defaultTopicLevels
+	}
+	return t.levels
+}
+
+// String returns the template as it was parsed
+func (t TopicTemplate) String() string {
+	return strings.Join(t.topicLevels(), "/")
+}
+
+// Render returns the topic of a sensor; the type and ID must not be empty or contain "/", "+" or "#", since the
+// topic could not be parsed back otherwise
+func (t TopicTemplate) Render(sensorType, sensorID string) (string, error) {
+	levels := t.topicLevels()
+	topic := make([]string, len(levels))
+	for i, level := range levels {
+		var value string
+		switch level {
+		case typePlaceholder:
+			value = sensorType
+		case idPlaceholder:
+			value = sensorID
+		default:
+			topic[i] = level
+			continue
+		}
+		if value == "" || strings.ContainsAny(value, "/+#") {
+			return "", fmt.Errorf("%q cannot fill %s of topic template %q, it must be non-empty without /, + or #", value, level, t)
+		}
+		topic[i] = value
+	}
+	return strings.Join(topic, "/"), nil
+}
+
+// Parse returns the sensor type and ID of a topic rendered from the template; ok is false if the topic does not fit
+// the template. A placeholder missing from the template yields an empty value
+func (t TopicTemplate) Parse(topic string) (sensorType, sensorID string, ok bool) {
+	levels := t.topicLevels()
+	parts := strings.Split(topic, "/")
+	if len(parts) != len(levels) {
+		return "", "", false
+	}
+
+	for i, level := range levels {
+		switch level {
+		case typePlaceholder:
+			sensorType = parts[i]
+		case idPlaceholder:
+			sensorID = parts[i]
+		default:
+			if parts[i] != level {
+				return "", "", false
+			}
+			continue
+		}
+		if parts[i] == "" {
+			return "", "", false
+		}
+	}
+	return sensorType, sensorID, true
+}
+
+// Filter returns the subscription filter matching every topic of the template: the placeholders become +
+func (t TopicTemplate) Filter() string {
+	levels := t.topicLevels()
+	filter := make([]string, len(levels))
+	for i, level := range levels {
+		if level == typePlaceholder || level == idPlaceholder {
+			filter[i] = "+"
+		} else {
+			filter[i] = level
+		}
+	}
+	return strings.Join(filter, "/")
+}
+
+// CheckFilter checks that filter is a valid MQTT subscription filter that matches every topic the template renders,
+// so a gateway subscribed with it receives every sensor publishing with the template
+func (t TopicTemplate) CheckFilter(filter string) error {
+	if filter == "" {
+		return errors.New("empty topic filter")
+	}
+
+	levels := t.topicLevels()
+	parts := strings.Split(filter, "/")
+	for i, part := range parts {
+		if part == "#" {
+			if i != len(parts)-1 {
+				return fmt.Errorf("topic filter %q: # must be the last level", filter)
+			}
+			return nil
+		}
+		if part != "+" && strings.ContainsAny(part, "+#") {
+			return fmt.Errorf("topic filter %q: a wildcard must fill a whole level", filter)
+		}
+		if i >= len(levels) {
+			return fmt.Errorf("topic filter %q has more levels than topic template %q", filter, t)
+		}
+		placeholder := levels[i] == typePlaceholder || levels[i] == idPlaceholder
+		if part != "+" && (placeholder || part != levels[i]) {
+			return fmt.Errorf("topic filter %q does not match level %d (%s) of topic template %q", filter, i+1, levels[i], t)
+		}
+	}
+	if len(parts) < len(levels) {
+		return fmt.Errorf("topic filter %q has fewer levels than topic template %q", filter, t)
+	}
+	return nil
+}
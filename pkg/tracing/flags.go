@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// Config holds the command line settings of tracing; without an endpoint tracing stays disabled
+type Config struct {
+	Endpoint string //host:port of an OTLP gRPC collector, e.g. localhost:4317
+	TLS      bool   //connect to the collector with TLS instead of plain text
+}
+
+// RegisterFlags adds -otlp-endpoint and -otlp-tls to the flag set, stored in c
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Endpoint, "otlp-endpoint", c.Endpoint, "host:port of an OTLP gRPC collector the trace spans are sent to, e.g. localhost:4317 (empty = tracing disabled)")
+	fs.BoolVar(&c.TLS, "otlp-tls", c.TLS, "Connect to the OTLP collector with TLS instead of plain text")
+}
+
+// Init enables tracing with an OTLP exporter if an endpoint is set, otherwise it does nothing
+func (c *Config) Init(ctx context.Context, serviceName string) error {
+	if c.Endpoint == "" {
+		return nil
+	}
+
+	exporter, err := OTLPExporter(ctx, c.Endpoint, c.TLS)
+	if err != nil {
+		return fmt.Errorf("error creating OTLP exporter: %w", err)
+	}
+	Init(serviceName, exporter)
+
+	log.Printf("Sending trace spans to %s", c.Endpoint)
+	return nil
+}
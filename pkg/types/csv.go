@@ -0,0 +1,88 @@
+package types
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVError reports the line of a CSV import that could not be parsed
+type CSVError struct {
+	Line int
+	Err  error
+}
+
+func (e *CSVError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeSensorDataCSV parses CSV rows of the form sensorId,timestamp,value,unit with an RFC 3339 timestamp.
+// A header row starting with "sensorId" is skipped; an empty timestamp is left zero. Malformed rows return a *CSVError
+func DecodeSensorDataCSV(r io.Reader) ([]SensorData, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var readings []SensorData
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return readings, nil
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &CSVError{Line: parseErr.StartLine, Err: parseErr.Err}
+			}
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if line == 1 && strings.EqualFold(record[0], "sensorId") {
+			continue
+		}
+
+		sensorData, err := parseSensorDataRecord(record)
+		if err != nil {
+			return nil, &CSVError{Line: line, Err: err}
+		}
+		readings = append(readings, sensorData)
+	}
+}
+
+// parseSensorDataRecord converts a single CSV record into SensorData
+func parseSensorDataRecord(record []string) (SensorData, error) {
+	sensorData := SensorData{
+		SensorID: strings.TrimSpace(record[0]),
+		Unit:     strings.TrimSpace(record[3]),
+	}
+	if sensorData.SensorID == "" {
+		return SensorData{}, errors.New("missing sensorId")
+	}
+
+	if timestamp := strings.TrimSpace(record[1]); timestamp != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return SensorData{}, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		sensorData.Timestamp = parsed
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+	if err != nil {
+		return SensorData{}, fmt.Errorf("invalid value %q", record[2])
+	}
+	sensorData.Value = value
+
+	return sensorData, nil
+}
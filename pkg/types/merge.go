@@ -0,0 +1,74 @@
+package types
+
+import (
+	"cmp"
+	"slices"
+)
+
+// sensorDataKey identifies a reading independent of the replica that stored it: its sensor and the instant of its
+// timestamp, so the same instant in another location or without a monotonic clock reading is the same reading
+type sensorDataKey struct {
+	sensorID string
+	seconds  int64
+	nanos    int
+}
+
+// key returns the identity of the reading
+func (d SensorData) key() sensorDataKey {
+	return sensorDataKey{sensorID: d.SensorID, seconds: d.Timestamp.Unix(), nanos: d.Timestamp.Nanosecond()}
+}
+
+// MergeSensorData returns the union of both lists with one reading per sensor ID and timestamp, sorted by sensor ID
+// and timestamp. A reading found more than once, in either list, is taken from its first occurrence in a, then in b,
+// so the values of a win where the lists disagree
+func MergeSensorData(a, b []SensorData) []SensorData {
+	seen := make(map[sensorDataKey]bool, len(a)+len(b))
+	merged := make([]SensorData, 0, len(a)+len(b))
+	for _, list := range [][]SensorData{a, b} {
+		for _, d := range list {
+			if key := d.key(); !seen[key] {
+				seen[key] = true
+				merged = append(merged, d)
+			}
+		}
+	}
+
+	sortSensorData(merged)
+	return merged
+}
+
+// DiffSensorData returns the readings of a without a reading of the same sensor ID and timestamp in b, and those of b
+// without one in a, each without duplicates and sorted like MergeSensorData. Readings present in both lists are not
+// compared further, a differing value or unit is no difference here
+func DiffSensorData(a, b []SensorData) (onlyA, onlyB []SensorData) {
+	return missingFrom(a, b), missingFrom(b, a)
+}
+
+// missingFrom returns the readings of list whose identity does not occur in other
+func missingFrom(list, other []SensorData) []SensorData {
+	present := make(map[sensorDataKey]bool, len(other)+len(list))
+	for _, d := range other {
+		present[d.key()] = true
+	}
+
+	missing := make([]SensorData, 0)
+	for _, d := range list {
+		if key := d.key(); !present[key] {
+			present[key] = true //a duplicate within list is reported once
+			missing = append(missing, d)
+		}
+	}
+
+	sortSensorData(missing)
+	return missing
+}
+
+// sortSensorData orders readings by sensor ID, then timestamp
+func sortSensorData(list []SensorData) {
+	slices.SortFunc(list, func(x, y SensorData) int {
+		if c := cmp.Compare(x.SensorID, y.SensorID); c != 0 {
+			return c
+		}
+		return x.Timestamp.Compare(y.Timestamp)
+	})
+}
@@ -9,4 +9,13 @@ type Sensor struct {
 	Unit                   string  //unit of measurement used in the sensor
 	NoiseLevel             float64 //how much noise to add to base value (percentage)
 	DataGenerationInterval int     //data generation interval in milliseconds
+	Precision              *int    //decimal places readings are rounded to, nil keeps full precision
+}
+
+// Round rounds the value of a reading to the precision of the sensor, unchanged if the sensor has none
+func (s Sensor) Round(data SensorData) SensorData {
+	if s.Precision == nil {
+		return data
+	}
+	return data.Rounded(*s.Precision)
 }
@@ -1,11 +1,273 @@
 package types
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
 
 // SensorData represents the data received from sensors
 type SensorData struct {
 	SensorID  string    `json:"sensorId"`
-	Timestamp time.Time `json:"timestamp"`
+	Timestamp time.Time `json:"timestamp"` //the sensor's clock, may be skewed or replayed
 	Value     float64   `json:"value"`
 	Unit      string    `json:"unit"`
+
+	//IngestedAt is when a database stored the reading, set by the database itself and never taken from a request;
+	//zero for readings that were not stored yet
+	IngestedAt time.Time `json:"ingestedAt,omitzero"`
+	//Sequence numbers the writes a database stored: 1 for its first write, one more for each further one, shared by
+	//the readings of one write. Replicas that stored the same writes in the same order agree on it, so gaps and
+	//repeats show missed or replayed writes; zero for readings that were not stored yet
+	Sequence uint64 `json:"sequence,omitzero"`
+}
+
+// ErrMissingSensorID is returned by Validate for a reading without a sensor ID
+var ErrMissingSensorID = errors.New("missing sensorId")
+
+// SensorDataOption configures a reading built with NewSensorData
+type SensorDataOption func(*SensorData)
+
+// WithUnit sets the unit of the reading
+func WithUnit(unit string) SensorDataOption {
+	return func(d *SensorData) {
+		d.Unit = unit
+	}
+}
+
+// WithTimestamp sets the timestamp of the reading; a zero timestamp keeps the default, the time of construction
+func WithTimestamp(timestamp time.Time) SensorDataOption {
+	return func(d *SensorData) {
+		if !timestamp.IsZero() {
+			d.Timestamp = timestamp
+		}
+	}
+}
+
+// NewSensorData builds a validated reading; its timestamp is the current time unless set with WithTimestamp
+func NewSensorData(sensorID string, value float64, opts ...SensorDataOption) (SensorData, error) {
+	d := SensorData{SensorID: sensorID, Timestamp: time.Now(), Value: value}
+	for _, opt := range opts {
+		opt(&d)
+	}
+
+	if err := d.Validate(); err != nil {
+		return SensorData{}, err
+	}
+	return d, nil
+}
+
+// Validate checks that the reading can be stored: it needs a sensor ID, a timestamp and a finite value
+func (d SensorData) Validate() error {
+	if d.SensorID == "" {
+		return ErrMissingSensorID
+	}
+	if d.Timestamp.IsZero() {
+		return fmt.Errorf("missing timestamp for sensor %s", d.SensorID)
+	}
+	if math.IsNaN(d.Value) || math.IsInf(d.Value, 0) {
+		return fmt.Errorf("value of sensor %s is not a finite number: %v", d.SensorID, d.Value)
+	}
+	return nil
+}
+
+// Equal reports whether both readings hold the same data. Timestamps are compared with time.Time.Equal,
+// so the same instant in another location or without a monotonic clock reading (e.g. after a round trip) is equal.
+// IngestedAt and Sequence are not compared: every replica records its own time and order of storing the same reading
+func (d SensorData) Equal(other SensorData) bool {
+	return d.SensorID == other.SensorID &&
+		d.Timestamp.Equal(other.Timestamp) &&
+		d.Value == other.Value &&
+		d.Unit == other.Unit
+}
+
+// Rounded returns a copy of the reading with its value rounded half away from zero to the given number of decimal
+// places; a negative number keeps the full precision
+func (d SensorData) Rounded(decimals int) SensorData {
+	if decimals < 0 {
+		return d
+	}
+
+	scale := math.Pow(10, float64(decimals))
+	d.Value = math.Round(d.Value*scale) / scale
+	return d
+}
+
+// SensorDataPatch is a partial update of the stored point with SensorID and Timestamp: only the fields that are not
+// nil change, the others keep their stored value
+type SensorDataPatch struct {
+	SensorID  string    `json:"sensorId"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     *float64  `json:"value,omitempty"`
+	Unit      *string   `json:"unit,omitempty"`
+}
+
+// Empty reports whether the patch changes no field
+func (p SensorDataPatch) Empty() bool {
+	return p.Value == nil && p.Unit == nil
+}
+
+// Validate checks that the patch addresses a point and changes it to a storable reading
+func (p SensorDataPatch) Validate() error {
+	if p.SensorID == "" {
+		return ErrMissingSensorID
+	}
+	if p.Timestamp.IsZero() {
+		return fmt.Errorf("missing timestamp for sensor %s", p.SensorID)
+	}
+	if p.Value != nil && (math.IsNaN(*p.Value) || math.IsInf(*p.Value, 0)) {
+		return fmt.Errorf("value of sensor %s is not a finite number: %v", p.SensorID, *p.Value)
+	}
+	return nil
+}
+
+// Apply returns the reading with the fields of the patch set; sensor ID and timestamp are never changed
+func (p SensorDataPatch) Apply(d SensorData) SensorData {
+	if p.Value != nil {
+		d.Value = *p.Value
+	}
+	if p.Unit != nil {
+		d.Unit = *p.Unit
+	}
+	return d
+}
+
+// TimeField selects which time of a reading a query or ordering uses
+type TimeField string
+
+const (
+	TimeFieldTimestamp  TimeField = "timestamp"  //the sensor's clock
+	TimeFieldIngestedAt TimeField = "ingestedAt" //when the database stored the reading, independent of the sensor's clock
+)
+
+// ParseTimeField converts a query parameter into a TimeField; an empty value selects the sensor timestamp
+func ParseTimeField(value string) (TimeField, error) {
+	switch TimeField(value) {
+	case "", TimeFieldTimestamp:
+		return TimeFieldTimestamp, nil
+	case TimeFieldIngestedAt:
+		return TimeFieldIngestedAt, nil
+	default:
+		return "", fmt.Errorf("unknown time field %q (expected timestamp or ingestedAt)", value)
+	}
+}
+
+// Time returns the selected time of the reading
+func (d SensorData) Time(field TimeField) time.Time {
+	if field == TimeFieldIngestedAt {
+		return d.IngestedAt
+	}
+	return d.Timestamp
+}
+
+// TimeRange selects the readings whose time, as chosen by Field, lies within [From, To); a zero bound is open
+type TimeRange struct {
+	Field    TimeField
+	From, To time.Time
+}
+
+// Contains reports whether the reading lies within the range
+func (r TimeRange) Contains(d SensorData) bool {
+	t := d.Time(r.Field)
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// Filter returns the readings within the range in their original order
+func (r TimeRange) Filter(list []SensorData) []SensorData {
+	filtered := make([]SensorData, 0, len(list))
+	for _, d := range list {
+		if r.Contains(d) {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+// SensorDataFields are the JSON keys of SensorData, the field names ParseSensorDataFields accepts
+var SensorDataFields = []string{"sensorId", "timestamp", "value", "unit", "ingestedAt", "sequence"}
+
+// ParseSensorDataFields parses a comma separated list of JSON keys of SensorData, e.g. "sensorId,value", for a
+// projection. Duplicates are dropped; an unknown or empty field name is an error
+func ParseSensorDataFields(spec string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("empty field name in %q", spec)
+		}
+		if !isSensorDataField(field) {
+			return nil, fmt.Errorf("unknown field %q (expected %s)", field, strings.Join(SensorDataFields, ", "))
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+func isSensorDataField(field string) bool {
+	for _, known := range SensorDataFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Project returns a reduced view of the reading holding only the given JSON keys, which must come from
+// ParseSensorDataFields; it marshals to the same values as the full reading
+func (d SensorData) Project(fields []string) map[string]any {
+	view := make(map[string]any, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "sensorId":
+			view[field] = d.SensorID
+		case "timestamp":
+			view[field] = d.Timestamp
+		case "value":
+			view[field] = d.Value
+		case "unit":
+			view[field] = d.Unit
+		case "ingestedAt":
+			view[field] = d.IngestedAt
+		case "sequence":
+			view[field] = d.Sequence
+		}
+	}
+	return view
+}
+
+// ProjectSensorDataList returns the reduced view of every reading, see Project
+func ProjectSensorDataList(list []SensorData, fields []string) []map[string]any {
+	views := make([]map[string]any, len(list))
+	for i, d := range list {
+		views[i] = d.Project(fields)
+	}
+	return views
+}
+
+// DecodeSensorDataList decodes a JSON payload that holds either a single SensorData object or an array of them
+func DecodeSensorDataList(payload []byte) ([]SensorData, error) {
+	trimmed := bytes.TrimSpace(payload)
+
+	//a burst of readings arrives as a JSON array, a single reading as a plain object
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var list []SensorData
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	var single SensorData
+	if err := json.Unmarshal(trimmed, &single); err != nil {
+		return nil, err
+	}
+	return []SensorData{single}, nil
 }
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FlexibleTime is a reading time decoded from either an RFC 3339 string, as Go writes time.Time, or a JSON number of
+// milliseconds since the Unix epoch, as many IoT tools send it; it is always written as RFC 3339
+type FlexibleTime struct {
+	time.Time
+}
+
+// UnmarshalJSON decodes an RFC 3339 string or epoch milliseconds; null keeps the time unchanged like time.Time does
+func (t *FlexibleTime) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == '"' || string(trimmed) == "null" {
+		return t.Time.UnmarshalJSON(trimmed)
+	}
+
+	millis, err := strconv.ParseInt(string(trimmed), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s, expected RFC 3339 or whole epoch milliseconds", trimmed)
+	}
+	t.Time = time.UnixMilli(millis).UTC()
+	return nil
+}
+
+// FormattedTime is a reading time that is marshaled as RFC 3339 or, if EpochMillis is set, as epoch milliseconds
+type FormattedTime struct {
+	Time        time.Time
+	EpochMillis bool
+}
+
+// IsZero reports whether the time is zero, so omitzero leaves out a zero time in either format
+func (t FormattedTime) IsZero() bool {
+	return t.Time.IsZero()
+}
+
+// MarshalJSON writes the time in its format; epoch milliseconds drop anything below a millisecond
+func (t FormattedTime) MarshalJSON() ([]byte, error) {
+	if t.EpochMillis {
+		return strconv.AppendInt(nil, t.Time.UnixMilli(), 10), nil
+	}
+	return json.Marshal(t.Time)
+}
+
+// UnmarshalJSON decodes a reading whose timestamp is either an RFC 3339 string or epoch milliseconds, see
+// FlexibleTime; both decode to the same instant
+func (d *SensorData) UnmarshalJSON(data []byte) error {
+	type plain SensorData //without this method, so the fields are decoded by encoding/json
+	wire := struct {
+		*plain
+		Timestamp FlexibleTime `json:"timestamp"`
+	}{plain: (*plain)(d), Timestamp: FlexibleTime{d.Timestamp}}
+
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	d.Timestamp = wire.Timestamp.Time
+	return nil
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ErrIncompatibleUnits is returned by ConvertUnit when the units measure different quantities or one of them is
+// unknown
+var ErrIncompatibleUnits = errors.New("incompatible units")
+
+// unitDefinition places a unit on the scale of the base unit of its quantity: base = value*scale + offset
+type unitDefinition struct {
+	quantity string
+	scale    float64
+	offset   float64
+}
+
+// units are the units ConvertUnit knows, the base units are K and Pa
+var units = map[string]unitDefinition{
+	"K":    {quantity: "temperature", scale: 1},
+	"°C":   {quantity: "temperature", scale: 1, offset: 273.15},
+	"°F":   {quantity: "temperature", scale: 5.0 / 9.0, offset: 273.15 - 32*5.0/9.0},
+	"Pa":   {quantity: "pressure", scale: 1},
+	"hPa":  {quantity: "pressure", scale: 100},
+	"kPa":  {quantity: "pressure", scale: 1000},
+	"mbar": {quantity: "pressure", scale: 100},
+	"bar":  {quantity: "pressure", scale: 100000},
+}
+
+// convertedDecimals is the precision converted values are rounded to, so e.g. 21.5 °C becomes 70.7 °F and not
+// 70.69999999999999 °F
+const convertedDecimals = 9
+
+// ConvertUnit converts a value between two units of the same quantity, e.g. °C to °F or hPa to kPa. A value that is
+// already in the target unit is returned as it is, whether the unit is known or not
+func ConvertUnit(value float64, from, to string) (float64, error) {
+	if from == to {
+		return value, nil
+	}
+
+	source, ok := units[from]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown unit %q", ErrIncompatibleUnits, from)
+	}
+	target, ok := units[to]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown unit %q (known: %s)", ErrIncompatibleUnits, to, strings.Join(KnownUnits(), ", "))
+	}
+	if source.quantity != target.quantity {
+		return 0, fmt.Errorf("%w: cannot convert %s (%s) to %s (%s)", ErrIncompatibleUnits, from, source.quantity, to, target.quantity)
+	}
+
+	base := value*source.scale + source.offset
+	converted := (base - target.offset) / target.scale
+	factor := math.Pow10(convertedDecimals)
+	return math.Round(converted*factor) / factor, nil
+}
+
+// KnownUnits returns the units ConvertUnit can convert between, sorted by name
+func KnownUnits() []string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ConvertedTo returns a copy of the reading with its value converted to the given unit
+func (d SensorData) ConvertedTo(unit string) (SensorData, error) {
+	value, err := ConvertUnit(d.Value, d.Unit, unit)
+	if err != nil {
+		return SensorData{}, err
+	}
+	d.Value = value
+	d.Unit = unit
+	return d, nil
+}
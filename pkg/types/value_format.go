@@ -0,0 +1,100 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ValueFormat controls how the values and timestamps of readings are written as JSON
+type ValueFormat struct {
+	//Precision is the number of digits after the decimal point; negative writes the shortest representation that
+	//parses back to the same float64, as encoding/json does
+	Precision int
+	//AsString writes the value as a JSON string, e.g. "23.10", for clients that parse every JSON number as a float64
+	//and would lose digits of large or exact values
+	AsString bool
+	//EpochMillis writes timestamp and ingestedAt as milliseconds since the Unix epoch instead of RFC 3339 strings
+	EpochMillis bool
+}
+
+// DefaultValueFormat writes values and timestamps like encoding/json
+var DefaultValueFormat = ValueFormat{Precision: -1}
+
+// IsDefault reports whether the format writes values and timestamps like encoding/json
+func (f ValueFormat) IsDefault() bool {
+	return f.Precision < 0 && !f.AsString && !f.EpochMillis
+}
+
+// String returns the format for logging, e.g. "2 decimals as string, epoch millis timestamps"
+func (f ValueFormat) String() string {
+	s := "shortest"
+	if f.Precision >= 0 {
+		s = fmt.Sprintf("%d decimals", f.Precision)
+	}
+	if f.AsString {
+		s += " as string"
+	}
+	if f.EpochMillis {
+		s += ", epoch millis timestamps"
+	}
+	return s
+}
+
+// FormattedValue is a reading value that is marshaled with its format
+type FormattedValue struct {
+	Value  float64
+	Format ValueFormat
+}
+
+// MarshalJSON writes the value with its precision, quoted if the format asks for a string
+func (v FormattedValue) MarshalJSON() ([]byte, error) {
+	var number []byte
+	if v.Format.Precision < 0 {
+		var err error
+		if number, err = json.Marshal(v.Value); err != nil {
+			return nil, err
+		}
+	} else {
+		number = strconv.AppendFloat(nil, v.Value, 'f', v.Format.Precision, 64)
+	}
+
+	if v.Format.AsString {
+		return strconv.AppendQuote(nil, string(number)), nil
+	}
+	return number, nil
+}
+
+// FormattedSensorData is a reading that is marshaled like SensorData, but with its value and times in the given format
+type FormattedSensorData struct {
+	SensorData
+	Format ValueFormat
+}
+
+// MarshalJSON writes the reading with the same keys and order as SensorData
+func (d FormattedSensorData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		SensorID   string         `json:"sensorId"`
+		Timestamp  FormattedTime  `json:"timestamp"`
+		Value      FormattedValue `json:"value"`
+		Unit       string         `json:"unit"`
+		IngestedAt FormattedTime  `json:"ingestedAt,omitzero"`
+		Sequence   uint64         `json:"sequence,omitzero"`
+	}{
+		d.SensorID,
+		FormattedTime{d.Timestamp, d.Format.EpochMillis},
+		FormattedValue{d.Value, d.Format},
+		d.Unit,
+		FormattedTime{d.IngestedAt, d.Format.EpochMillis},
+		d.Sequence,
+	})
+}
+
+// FormatSensorDataList returns the readings to marshal with their values and times in the given format
+func FormatSensorDataList(list []SensorData, format ValueFormat) []FormattedSensorData {
+	formatted := make([]FormattedSensorData, len(list))
+	for i, d := range list {
+		formatted[i] = FormattedSensorData{SensorData: d, Format: format}
+	}
+	return formatted
+}
@@ -1,31 +1,36 @@
 package functional
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
 )
 
 // Test2PCSuccessfulTransaction tests successful 2PC transaction where both databases commit
 func Test2PCSuccessfulTransaction(t *testing.T) {
-	client1, err := database.ClientFactory("localhost:50051")
+	requireExternalDatabases(t)
+
+	client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database1: %v", err)
 	}
 	defer client1.Close()
 
-	client2, err := database.ClientFactory("localhost:50052")
+	client2, err := database.ClientFactory(externalDatabaseAddresses[1])
 	if err != nil {
 		t.Fatalf("Failed to connect to database2: %v", err)
 	}
 	defer client2.Close()
 
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -62,14 +67,11 @@ func Test2PCSuccessfulTransaction(t *testing.T) {
 
 	//verify data consistency between databases
 	if len(data1) > 0 && len(data2) > 0 {
-		if data1[0].SensorID != data2[0].SensorID {
-			t.Errorf("SensorID mismatch: db1=%s, db2=%s", data1[0].SensorID, data2[0].SensorID)
-		}
-		if data1[0].Value != data2[0].Value {
-			t.Errorf("Value mismatch: db1=%.2f, db2=%.2f", data1[0].Value, data2[0].Value)
+		if !data1[0].Equal(data2[0]) {
+			t.Errorf("Data mismatch: db1=%+v, db2=%+v", data1[0], data2[0])
 		}
-		if data1[0].Unit != data2[0].Unit {
-			t.Errorf("Unit mismatch: db1=%s, db2=%s", data1[0].Unit, data2[0].Unit)
+		if !data1[0].Equal(testData) {
+			t.Errorf("Stored data differs from the sent data: stored=%+v, sent=%+v", data1[0], testData)
 		}
 	}
 
@@ -78,9 +80,11 @@ func Test2PCSuccessfulTransaction(t *testing.T) {
 
 // Test2PCFailedTransaction tests failed 2PC transaction by simulating database failure
 func Test2PCFailedTransaction(t *testing.T) {
+	requireExternalDatabases(t)
+
 	//here we'll connect to one working and one non-existent database to simulate failure
 
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:99999"})
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{externalDatabaseAddresses[0], "localhost:99999"})
 	if err == nil {
 		defer tpcClient.Close()
 
@@ -101,7 +105,7 @@ func Test2PCFailedTransaction(t *testing.T) {
 		}
 
 		//verify that no data was committed to the working database
-		client1, err := database.ClientFactory("localhost:50051")
+		client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 		if err != nil {
 			t.Fatalf("Failed to connect to database1: %v", err)
 		}
@@ -124,19 +128,21 @@ func Test2PCFailedTransaction(t *testing.T) {
 
 // Test2PCDataConsistency tests data consistency between both databases after multiple transactions
 func Test2PCDataConsistency(t *testing.T) {
-	client1, err := database.ClientFactory("localhost:50051")
+	requireExternalDatabases(t)
+
+	client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database1: %v", err)
 	}
 	defer client1.Close()
 
-	client2, err := database.ClientFactory("localhost:50052")
+	client2, err := database.ClientFactory(externalDatabaseAddresses[1])
 	if err != nil {
 		t.Fatalf("Failed to connect to database2: %v", err)
 	}
 	defer client2.Close()
 
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -172,21 +178,17 @@ func Test2PCDataConsistency(t *testing.T) {
 		time.Sleep(10 * time.Millisecond)
 	}
 
-	//verify data consistency by comparing all data in both databases
-	allData1, err := client1.GetAllDataPoints()
+	//verify data consistency by comparing our test data in both databases
+	testData1, err := client1.GetDataPointsByPrefix("2pc-consistency-")
 	if err != nil {
-		t.Fatalf("Failed to get all data from database1: %v", err)
+		t.Fatalf("Failed to get test data from database1: %v", err)
 	}
 
-	allData2, err := client2.GetAllDataPoints()
+	testData2, err := client2.GetDataPointsByPrefix("2pc-consistency-")
 	if err != nil {
-		t.Fatalf("Failed to get all data from database2: %v", err)
+		t.Fatalf("Failed to get test data from database2: %v", err)
 	}
 
-	//filter to incl only our test data
-	testData1 := filterTestData(allData1, "2pc-consistency-")
-	testData2 := filterTestData(allData2, "2pc-consistency-")
-
 	if len(testData1) != len(testData2) {
 		t.Errorf("Data count mismatch: db1=%d, db2=%d", len(testData1), len(testData2))
 	}
@@ -197,13 +199,8 @@ func Test2PCDataConsistency(t *testing.T) {
 
 	//verify each record matches between databases
 	for i := 0; i < len(testData1) && i < len(testData2); i++ {
-		if testData1[i].SensorID != testData2[i].SensorID {
-			t.Errorf("SensorID mismatch at index %d: db1=%s, db2=%s",
-				i, testData1[i].SensorID, testData2[i].SensorID)
-		}
-		if testData1[i].Value != testData2[i].Value {
-			t.Errorf("Value mismatch at index %d: db1=%.2f, db2=%.2f",
-				i, testData1[i].Value, testData2[i].Value)
+		if !testData1[i].Equal(testData2[i]) {
+			t.Errorf("Data mismatch at index %d: db1=%+v, db2=%+v", i, testData1[i], testData2[i])
 		}
 	}
 
@@ -212,14 +209,16 @@ func Test2PCDataConsistency(t *testing.T) {
 
 // Test2PCTransactionIDUniqueness tests that transaction IDs are unique
 func Test2PCTransactionIDUniqueness(t *testing.T) {
+	requireExternalDatabases(t)
+
 	//create multiple 2PC clients to simulate concurrent coordinators
-	tpcClient1, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	tpcClient1, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client1: %v", err)
 	}
 	defer tpcClient1.Close()
 
-	tpcClient2, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	tpcClient2, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client2: %v", err)
 	}
@@ -269,7 +268,7 @@ func Test2PCTransactionIDUniqueness(t *testing.T) {
 	}
 
 	//verify both transactions succeeded
-	client1, err := database.ClientFactory("localhost:50051")
+	client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -290,7 +289,9 @@ func Test2PCTransactionIDUniqueness(t *testing.T) {
 
 // Test2PCConcurrentTransactions tests handling of multiple concurrent transactions
 func Test2PCConcurrentTransactions(t *testing.T) {
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	requireExternalDatabases(t)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -330,32 +331,29 @@ func Test2PCConcurrentTransactions(t *testing.T) {
 	}
 
 	//verify all successful transactions are in both databases
-	client1, err := database.ClientFactory("localhost:50051")
+	client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database1: %v", err)
 	}
 	defer client1.Close()
 
-	client2, err := database.ClientFactory("localhost:50052")
+	client2, err := database.ClientFactory(externalDatabaseAddresses[1])
 	if err != nil {
 		t.Fatalf("Failed to connect to database2: %v", err)
 	}
 	defer client2.Close()
 
-	allData1, err := client1.GetAllDataPoints()
+	//fetch only our test data
+	concurrentData1, err := client1.GetDataPointsByPrefix("2pc-concurrent-")
 	if err != nil {
-		t.Fatalf("Failed to get all data from database1: %v", err)
+		t.Fatalf("Failed to get test data from database1: %v", err)
 	}
 
-	allData2, err := client2.GetAllDataPoints()
+	concurrentData2, err := client2.GetDataPointsByPrefix("2pc-concurrent-")
 	if err != nil {
-		t.Fatalf("Failed to get all data from database2: %v", err)
+		t.Fatalf("Failed to get test data from database2: %v", err)
 	}
 
-	//filter to include only our test data
-	concurrentData1 := filterTestData(allData1, "2pc-concurrent-")
-	concurrentData2 := filterTestData(allData2, "2pc-concurrent-")
-
 	expectedSuccess := numConcurrentTransactions - errorCount
 	if len(concurrentData1) != expectedSuccess {
 		t.Errorf("Expected %d successful transactions in db1, got %d", expectedSuccess, len(concurrentData1))
@@ -374,13 +372,198 @@ func Test2PCConcurrentTransactions(t *testing.T) {
 		expectedSuccess, numConcurrentTransactions)
 }
 
-// Helper function to filter test data by sensor ID prefix
-func filterTestData(data []types.SensorData, prefix string) []types.SensorData {
-	var filtered []types.SensorData
-	for _, d := range data {
-		if len(d.SensorID) >= len(prefix) && d.SensorID[:len(prefix)] == prefix {
-			filtered = append(filtered, d)
+// Test2PCDryRun tests that a dry run validates the voting of all databases without storing anything
+func Test2PCDryRun(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithDryRun())
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	testData := types.SensorData{SensorID: "2pc-dry-run", Timestamp: time.Now(), Value: 1.0, Unit: "test"}
+
+	//the abort ending every dry run is no problem worth a warning
+	logs := captureLogs(t)
+	logging.SetLevel(logging.LevelWarn)
+	err = tpcClient.AddDataPointWithTwoPhaseCommit(testData)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warnings for a successful dry run, got %q", logs.String())
+	}
+
+	//a rejected prepare must still be reported in a dry run
+	err = tpcClient.DryRunTwoPhaseCommit(types.SensorData{Timestamp: time.Now()})
+	if err == nil {
+		t.Errorf("Expected a dry run with a missing sensor ID to fail")
+	}
+
+	for _, addr := range []string{addr1, addr2} {
+		client, err := database.ClientFactory(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect to %s: %v", addr, err)
+		}
+		defer client.Close()
+
+		data, err := client.GetAllDataPoints()
+		if err != nil {
+			t.Fatalf("Failed to get data from %s: %v", addr, err)
+		}
+		if len(data) != 0 {
+			t.Errorf("Expected no data on %s after a dry run, got %d points", addr, len(data))
+		}
+	}
+}
+
+// Test2PCBatchConsistency tests that a multi-point batch is committed all-or-nothing and identically on every replica,
+// with the data limit applied once after the whole batch was appended
+func Test2PCBatchConsistency(t *testing.T) {
+	const limit = 10
+	addr1, _ := startTestDatabase(t, limit)
+	addr2, _ := startTestDatabase(t, limit)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	var replicas []*database.Client
+	for _, addr := range []string{addr1, addr2} {
+		client, err := database.ClientFactory(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect to database %s: %v", addr, err)
+		}
+		defer client.Close()
+		replicas = append(replicas, client)
+	}
+	stored := func(replica *database.Client) []types.SensorData {
+		t.Helper()
+		data, err := replica.GetAllDataPoints()
+		if err != nil {
+			t.Fatalf("Failed to read replica: %v", err)
+		}
+		return data
+	}
+
+	start := time.Now()
+	newBatch := func(prefix string, n int) []types.SensorData {
+		batch := make([]types.SensorData, n)
+		for i := range batch {
+			batch[i] = types.SensorData{
+				SensorID:  fmt.Sprintf("%s-%d", prefix, i),
+				Timestamp: start.Add(time.Duration(i) * time.Second),
+				Value:     float64(i),
+				Unit:      "°C",
+			}
+		}
+		return batch
+	}
+
+	if err := tpcClient.AddDataPointsWithTwoPhaseCommit(newBatch("2pc-batch-old", 4)); err != nil {
+		t.Fatalf("First batch failed: %v", err)
+	}
+
+	//4 old + 8 new points exceed the limit by 2, so only the 2 oldest points are evicted
+	batch := newBatch("2pc-batch-new", 8)
+	if err := tpcClient.AddDataPointsWithTwoPhaseCommit(batch); err != nil {
+		t.Fatalf("Second batch failed: %v", err)
+	}
+
+	expected := append(newBatch("2pc-batch-old", 4)[2:], batch...)
+	for i, replica := range replicas {
+		data := stored(replica)
+		if len(data) != len(expected) {
+			t.Fatalf("Replica %d: expected %d points, got %d", i+1, len(expected), len(data))
+		}
+		for j := range expected {
+			if !data[j].Equal(expected[j]) {
+				t.Errorf("Replica %d: mismatch at index %d: expected %+v, got %+v", i+1, j, expected[j], data[j])
+			}
+		}
+	}
+
+	//one invalid reading makes the replicas vote no, nothing of the batch may be stored
+	invalid := newBatch("2pc-batch-invalid", 3)
+	invalid[1].SensorID = ""
+	if err := tpcClient.AddDataPointsWithTwoPhaseCommit(invalid); err == nil {
+		t.Fatalf("Expected a batch with an invalid reading to fail")
+	}
+	for i, replica := range replicas {
+		if data := stored(replica); len(data) != len(expected) || !data[0].Equal(expected[0]) {
+			t.Errorf("Replica %d: the failed batch changed the store (%d points)", i+1, len(data))
+		}
+	}
+
+	log.Println("2PC batch consistency test passed")
+}
+
+// Test2PCPrepareValidator tests that a database whose validator rejects a reading votes no, so the coordinator aborts
+// the transaction and neither database stores anything, even the one without a validator
+func Test2PCPrepareValidator(t *testing.T) {
+	inRange := func(data types.SensorData) error {
+		if data.Value < -50 || data.Value > 100 {
+			return fmt.Errorf("value %v outside of the expected range [-50, 100]", data.Value)
+		}
+		return nil
+	}
+	addr1, service1 := startTestDatabase(t, 100, database.WithPrepareValidator(inRange))
+	addr2, service2 := startTestDatabase(t, 100)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	start := time.Now().Add(-time.Hour)
+	reading := func(sensorID string, value float64) types.SensorData {
+		return types.SensorData{SensorID: sensorID, Timestamp: start, Value: value, Unit: "°C"}
+	}
+	stored := func() [][]types.SensorData {
+		t.Helper()
+		var data [][]types.SensorData
+		for _, service := range []*database.DatabaseService{service1, service2} {
+			points, err := service.Snapshot()
+			if err != nil {
+				t.Fatalf("Failed to read database: %v", err)
+			}
+			data = append(data, points)
+		}
+		return data
+	}
+	expectRejected := func(operation string, err error) {
+		t.Helper()
+		if !errors.Is(err, database.ErrRejected) {
+			t.Errorf("%s: expected ErrRejected, got %v", operation, err)
+		} else if !strings.Contains(err.Error(), "outside of the expected range") {
+			t.Errorf("%s: expected the reason of the validator in the error, got %v", operation, err)
+		}
+	}
+
+	expectRejected("add", tpcClient.AddDataPointWithTwoPhaseCommit(reading("validated-1", 150)))
+	expectRejected("batch", tpcClient.AddDataPointsWithTwoPhaseCommit([]types.SensorData{reading("validated-2", 20), reading("validated-3", -80)}))
+	for i, data := range stored() {
+		if len(data) != 0 {
+			t.Fatalf("Expected database %d to store nothing after the rejected writes, got %v", i, data)
+		}
+	}
+
+	//readings in range pass, and a patch is validated with the value it would store
+	if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading("validated-1", 42)); err != nil {
+		t.Fatalf("Expected a reading in range to be stored, got %v", err)
+	}
+	tooHot := 250.0
+	_, err = tpcClient.PatchDataPointWithTwoPhaseCommit(types.SensorDataPatch{SensorID: "validated-1", Timestamp: start, Value: &tooHot})
+	expectRejected("patch", err)
+
+	for i, data := range stored() {
+		if len(data) != 1 || data[0].Value != 42 {
+			t.Errorf("Expected database %d to keep only the reading in range, got %v", i, data)
 		}
 	}
-	return filtered
 }
@@ -0,0 +1,500 @@
+package functional
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestFlushAllWritesSnapshots tests that a flush fanned out by the coordinator updates every replica's snapshot file
+func TestFlushAllWritesSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "db1.json")
+	file2 := filepath.Join(dir, "db2.json")
+
+	addr1, _ := startTestDatabase(t, 1000, database.WithDataFile(file1))
+	addr2, _ := startTestDatabase(t, 1000, database.WithDataFile(file2))
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	for i := range 3 {
+		err = tpcClient.AddDataPointWithTwoPhaseCommit(types.SensorData{
+			SensorID:  "flush-test",
+			Timestamp: time.Now(),
+			Value:     float64(i),
+			Unit:      "°C",
+		})
+		if err != nil {
+			t.Fatalf("2PC transaction failed: %v", err)
+		}
+	}
+
+	results := tpcClient.FlushAll()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 flush results, got %d", len(results))
+	}
+
+	for i, file := range []string{file1, file2} {
+		if !results[i].Success {
+			t.Errorf("Flush failed on replica %s: %s", results[i].Address, results[i].Message)
+		}
+		if results[i].PointsWritten != 3 {
+			t.Errorf("Expected 3 points written on replica %d, got %d", i, results[i].PointsWritten)
+		}
+		if results[i].FilePath != file {
+			t.Errorf("Expected file path %s, got %s", file, results[i].FilePath)
+		}
+
+		jsonData, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Snapshot file %s was not written: %v", file, err)
+		}
+
+		var snapshot []types.SensorData
+		if err := json.Unmarshal(jsonData, &snapshot); err != nil {
+			t.Fatalf("Failed to parse snapshot %s: %v", file, err)
+		}
+		if len(snapshot) != 3 {
+			t.Errorf("Expected 3 points in snapshot %s, got %d", file, len(snapshot))
+		}
+	}
+
+	log.Println("Flush fan-out test passed")
+}
+
+// TestBasicAuthMiddleware tests that the admin middleware rejects missing or wrong credentials
+func TestBasicAuthMiddleware(t *testing.T) {
+	server := http.ServerFactory("localhost", 8085)
+	server.RegisterHandler(
+		http.POST,
+		"/admin/flush",
+		http.BasicAuth("admin", "secret", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte("flushed"))
+		}),
+	)
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "localhost:8085", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	url := "http://localhost:8085/admin/flush"
+
+	resp, err := client.Do(http.POST, url, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without credentials, got %d", resp.StatusCode)
+	}
+	if resp.Headers["WWW-Authenticate"] == "" {
+		t.Errorf("Expected a WWW-Authenticate challenge header")
+	}
+
+	wrong := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong"))
+	resp, err = client.Do(http.POST, url, nil, map[string]string{"Authorization": wrong})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with wrong credentials, got %d", resp.StatusCode)
+	}
+
+	right := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret"))
+	resp, err = client.Do(http.POST, url, nil, map[string]string{"Authorization": right})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 with valid credentials, got %d", resp.StatusCode)
+	}
+
+	log.Println("Basic auth middleware test passed")
+}
+
+// TestSnapshotFormatsRoundTrip tests that every snapshot format survives a flush and reload, including a format switch,
+// and that the store sequence continues after the reload
+func TestSnapshotFormatsRoundTrip(t *testing.T) {
+	formats := []database.SnapshotFormat{database.SnapshotJSON, database.SnapshotGob, database.SnapshotBinary}
+	timestamp := time.Unix(1_700_000_000, 123456789)
+
+	for _, format := range formats {
+		for _, compress := range []bool{false, true} {
+			file := filepath.Join(t.TempDir(), "snapshot")
+
+			service := newTestService(t, 100, database.WithDataFile(file), database.WithSnapshotFormat(format, compress))
+			for i := range 5 {
+				service.CreateSensorData(context.Background(), &pb.SensorDataRequest{
+					SensorId:  fmt.Sprintf("snapshot-%d", i),
+					Timestamp: timestamppb.New(timestamp.Add(time.Duration(i) * time.Second)),
+					Value:     float64(i) + 0.25,
+					Unit:      "°C",
+				})
+			}
+			service.Stop()
+
+			//the reloading service writes JSON, but must detect the format of the existing file
+			reloaded := newTestService(t, 100, database.WithDataFile(file))
+			reloaded.CreateSensorData(context.Background(), &pb.SensorDataRequest{SensorId: "snapshot-5", Timestamp: timestamppb.New(timestamp), Value: 5, Unit: "°C"})
+			resp, err := reloaded.GetAllSensorData(context.Background(), &pb.EmptyRequest{})
+			reloaded.Stop()
+			if err != nil {
+				t.Fatalf("%s (gzip %v): failed to read reloaded data: %v", format, compress, err)
+			}
+
+			if len(resp.Data) != 6 {
+				t.Fatalf("%s (gzip %v): expected 5 reloaded points and a new one, got %d", format, compress, len(resp.Data))
+			}
+			last := resp.Data[4]
+			if last.SensorId != "snapshot-4" || last.Value != 4.25 || last.Unit != "°C" ||
+				!last.Timestamp.AsTime().Equal(timestamp.Add(4*time.Second)) {
+				t.Errorf("%s (gzip %v): reloaded point does not match, got %v", format, compress, last)
+			}
+			if last.IngestedAt == nil || last.IngestedAt.AsTime().IsZero() {
+				t.Errorf("%s (gzip %v): expected the ingestion time to survive, got %v", format, compress, last)
+			}
+			if last.Sequence != 5 || resp.Data[5].Sequence != 6 {
+				t.Errorf("%s (gzip %v): expected store sequence 5 to survive and the next write to get 6, got %d and %d", format, compress, last.Sequence, resp.Data[5].Sequence)
+			}
+		}
+	}
+
+	if _, err := database.ParseSnapshotFormat("xml"); err == nil {
+		t.Errorf("Expected an error for an unknown snapshot format")
+	}
+}
+
+// TestSyncCommitSurvivesCrash tests that with sync commits every acknowledged 2PC commit is on disk right away: a
+// database started from the files of one that never stopped, as after a crash, holds all of them
+func TestSyncCommitSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "db1.json")
+	file2 := filepath.Join(dir, "db2.json")
+	asyncFile := filepath.Join(dir, "async.json")
+
+	addr1, _ := startTestDatabase(t, 1000, database.WithDataFile(file1), database.WithSyncCommit())
+	addr2, _ := startTestDatabase(t, 1000, database.WithDataFile(file2), database.WithSyncCommit())
+	asyncAddr, _ := startTestDatabase(t, 1000, database.WithDataFile(asyncFile))
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2, asyncAddr})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	timestamp := time.Unix(1_700_000_000, 123456789)
+	reading := func(i int) types.SensorData {
+		return types.SensorData{SensorID: "sync-commit", Timestamp: timestamp.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "°C"}
+	}
+
+	//a snapshot in the middle empties the commit log, the commits after it have to come from the log again
+	if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(0)); err != nil {
+		t.Fatalf("2PC add failed: %v", err)
+	}
+	for _, result := range tpcClient.FlushAll() {
+		if !result.Success {
+			t.Fatalf("Flush failed on %s: %s", result.Address, result.Message)
+		}
+	}
+	if err := tpcClient.AddDataPointsWithTwoPhaseCommit([]types.SensorData{reading(1), reading(2), reading(3)}); err != nil {
+		t.Fatalf("2PC batch failed: %v", err)
+	}
+	value := 42.5
+	if _, err := tpcClient.PatchDataPointWithTwoPhaseCommit(types.SensorDataPatch{SensorID: "sync-commit", Timestamp: reading(2).Timestamp, Value: &value}); err != nil {
+		t.Fatalf("2PC patch failed: %v", err)
+	}
+
+	//the running databases are not stopped, so nothing is flushed on the way out
+	for _, file := range []string{file1, file2} {
+		reloaded := newTestService(t, 1000, database.WithDataFile(file))
+		points, err := reloaded.Snapshot()
+		reloaded.Stop()
+		if err != nil {
+			t.Fatalf("Failed to read reloaded data: %v", err)
+		}
+
+		expected := []types.SensorData{reading(0), reading(1), reading(2), reading(3)}
+		expected[2].Value = value
+		if len(points) != len(expected) {
+			t.Fatalf("%s: expected %d points after the crash, got %d", filepath.Base(file), len(expected), len(points))
+		}
+		for i := range expected {
+			if !points[i].Equal(expected[i]) {
+				t.Errorf("%s: point %d: expected %v, got %v", filepath.Base(file), i, expected[i], points[i])
+			}
+		}
+		if points[3].Sequence != 2 || points[3].IngestedAt.IsZero() {
+			t.Errorf("%s: expected the batch to keep store sequence 2 and its ingestion time, got %d and %v", filepath.Base(file), points[3].Sequence, points[3].IngestedAt)
+		}
+	}
+
+	//without sync commits only the snapshot survives
+	reloaded := newTestService(t, 1000, database.WithDataFile(asyncFile))
+	points, err := reloaded.Snapshot()
+	reloaded.Stop()
+	if err != nil {
+		t.Fatalf("Failed to read reloaded data: %v", err)
+	}
+	if len(points) != 1 {
+		t.Errorf("Expected only the flushed point without sync commits, got %d", len(points))
+	}
+}
+
+// TestSyncCommitLogReplay tests the replay of the commit log in the corner cases: a crash between writing the
+// snapshot and emptying the log must not store a commit twice or let a logged delete-all remove later readings, a log
+// that cannot be replayed stops the start and stays, and clearing the store leaves no logged commits behind
+func TestSyncCommitLogReplay(t *testing.T) {
+	timestamp := time.Unix(1_700_000_000, 0)
+	reading := func(i int) types.SensorData {
+		return types.SensorData{SensorID: "commit-log", Timestamp: timestamp.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "°C"}
+	}
+	value := 42.5
+
+	//every scenario commits through 2PC and leaves the log of its commits behind, as a crash right after the snapshot
+	//was written but before the log was emptied would
+	scenarios := []struct {
+		name     string
+		commit   func(t *testing.T, tpcClient *database.TwoPhaseCommitClient)
+		expected []types.SensorData
+	}{
+		{
+			name: "adds and patch",
+			commit: func(t *testing.T, tpcClient *database.TwoPhaseCommitClient) {
+				if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(0)); err != nil {
+					t.Fatalf("2PC add failed: %v", err)
+				}
+				if err := tpcClient.AddDataPointsWithTwoPhaseCommit([]types.SensorData{reading(1), reading(2)}); err != nil {
+					t.Fatalf("2PC batch failed: %v", err)
+				}
+				if _, err := tpcClient.PatchDataPointWithTwoPhaseCommit(types.SensorDataPatch{SensorID: "commit-log", Timestamp: reading(1).Timestamp, Value: &value}); err != nil {
+					t.Fatalf("2PC patch failed: %v", err)
+				}
+			},
+			expected: []types.SensorData{reading(0), {SensorID: "commit-log", Timestamp: reading(1).Timestamp, Value: value, Unit: "°C"}, reading(2)},
+		},
+		{
+			name: "delete-all before adds",
+			commit: func(t *testing.T, tpcClient *database.TwoPhaseCommitClient) {
+				if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(0)); err != nil {
+					t.Fatalf("2PC add failed: %v", err)
+				}
+				if _, err := tpcClient.DeleteAllWithTwoPhaseCommit(); err != nil {
+					t.Fatalf("2PC delete-all failed: %v", err)
+				}
+				if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(1)); err != nil {
+					t.Fatalf("2PC add failed: %v", err)
+				}
+			},
+			expected: []types.SensorData{reading(1)},
+		},
+	}
+
+	//a database with sync commits, 2PC needs a second one next to it
+	startLogged := func(t *testing.T) (string, *database.DatabaseService, *database.TwoPhaseCommitClient) {
+		file := filepath.Join(t.TempDir(), "db.json")
+		addr, service := startTestDatabase(t, 1000, database.WithDataFile(file), database.WithSyncCommit())
+		other, _ := startTestDatabase(t, 1000)
+		tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr, other})
+		if err != nil {
+			t.Fatalf("Failed to create 2PC client: %v", err)
+		}
+		t.Cleanup(func() { tpcClient.Close() })
+		return file, service, tpcClient
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			file, service, tpcClient := startLogged(t)
+			scenario.commit(t, tpcClient)
+
+			logFile := file + ".log"
+			logged, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatalf("Failed to read commit log: %v", err)
+			}
+			if _, _, err := service.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if err := os.WriteFile(logFile, logged, 0o644); err != nil {
+				t.Fatalf("Failed to put the commit log back: %v", err)
+			}
+
+			reloaded := newTestService(t, 1000, database.WithDataFile(file), database.WithSyncCommit())
+			defer reloaded.Stop()
+			points, err := reloaded.Snapshot()
+			if err != nil {
+				t.Fatalf("Failed to read reloaded data: %v", err)
+			}
+			if len(points) != len(scenario.expected) {
+				t.Fatalf("Expected %d points after the replay, got %d: %v", len(scenario.expected), len(points), points)
+			}
+			for i := range scenario.expected {
+				if !points[i].Equal(scenario.expected[i]) {
+					t.Errorf("Point %d: expected %v, got %v", i, scenario.expected[i], points[i])
+				}
+			}
+		})
+	}
+
+	t.Run("unreadable log", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "db.json")
+		logFile := file + ".log"
+		broken := []byte("{\"op\":\"add\",\"readings\":[{\"sensorId\":\"commit-log\"}]}\nnot json\n")
+		if err := os.WriteFile(logFile, broken, 0o644); err != nil {
+			t.Fatalf("Failed to write commit log: %v", err)
+		}
+
+		if _, err := database.DatabaseServiceFactory(1000, database.WithDataFile(file), database.WithSyncCommit()); err == nil {
+			t.Fatalf("Expected the start to fail on a commit log that cannot be replayed")
+		}
+		if kept, err := os.ReadFile(logFile); err != nil || string(kept) != string(broken) {
+			t.Errorf("Expected the commit log to stay untouched, got %q (%v)", kept, err)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		file, service, tpcClient := startLogged(t)
+		for i := range 3 {
+			if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(i)); err != nil {
+				t.Fatalf("2PC add failed: %v", err)
+			}
+		}
+		if err := service.Clear(); err != nil {
+			t.Fatalf("Clear failed: %v", err)
+		}
+		//the store sequence starts over, the commit after the clear must not be mistaken for one the snapshot holds
+		if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading(3)); err != nil {
+			t.Fatalf("2PC add failed: %v", err)
+		}
+
+		reloaded := newTestService(t, 1000, database.WithDataFile(file), database.WithSyncCommit())
+		defer reloaded.Stop()
+		points, err := reloaded.Snapshot()
+		if err != nil {
+			t.Fatalf("Failed to read reloaded data: %v", err)
+		}
+		if len(points) != 1 || !points[0].Equal(reading(3)) {
+			t.Errorf("Expected only the point committed after the clear, got %v", points)
+		}
+	})
+}
+
+// TestManualTransactionEndpoints tests driving 2PC by hand over HTTP: prepare then commit, and prepare then abort
+func TestManualTransactionEndpoints(t *testing.T) {
+	addr1, service1 := startTestDatabase(t, 100)
+	addr2, service2 := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8093
+		config.DatabaseAddresses = []string{addr1, addr2}
+		config.AdminPassword = "secret"
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	auth := map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")),
+		"Content-Type":  "application/json",
+	}
+	baseURL := "http://localhost:8093/admin/txn/"
+
+	storedPoints := func(service *database.DatabaseService) int {
+		resp, err := service.GetAllSensorData(context.Background(), &pb.EmptyRequest{})
+		if err != nil {
+			t.Fatalf("Failed to read database: %v", err)
+		}
+		return len(resp.Data)
+	}
+
+	prepare := func(sensorID string) string {
+		body := []byte(fmt.Sprintf(`{"sensorId":%q,"value":1.5,"unit":"test"}`, sensorID))
+		resp, err := client.Do(http.POST, baseURL+"prepare", body, auth)
+		if err != nil {
+			t.Fatalf("Failed to send prepare: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200 for prepare, got %d: %s", resp.StatusCode, resp.Body)
+		}
+
+		var result struct {
+			TransactionID string                    `json:"transactionId"`
+			Prepared      bool                      `json:"prepared"`
+			Replicas      []database.ReplicaOutcome `json:"replicas"`
+		}
+		if err := json.Unmarshal(resp.Body, &result); err != nil {
+			t.Fatalf("Failed to parse prepare response: %v", err)
+		}
+		if !result.Prepared || len(result.Replicas) != 2 || result.TransactionID == "" {
+			t.Fatalf("Expected both replicas to vote yes, got %+v", result)
+		}
+		return result.TransactionID
+	}
+
+	//prepare -> commit: nothing is visible until the commit
+	transactionID := prepare("manual-commit")
+	if storedPoints(service1) != 0 || storedPoints(service2) != 0 {
+		t.Errorf("Expected no data to be stored while the transaction is only prepared")
+	}
+
+	resp, err := client.Do(http.POST, baseURL+transactionID+"/commit", nil, auth)
+	if err != nil {
+		t.Fatalf("Failed to send commit: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 for commit, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	if storedPoints(service1) != 1 || storedPoints(service2) != 1 {
+		t.Errorf("Expected the committed point on both replicas")
+	}
+
+	//prepare -> abort: nothing is stored
+	transactionID = prepare("manual-abort")
+	resp, err = client.Do(http.POST, baseURL+transactionID+"/abort", nil, auth)
+	if err != nil {
+		t.Fatalf("Failed to send abort: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 for abort, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	if storedPoints(service1) != 1 || storedPoints(service2) != 1 {
+		t.Errorf("Expected the aborted point not to be stored")
+	}
+
+	//committing an aborted transaction fails on every replica
+	resp, err = client.Do(http.POST, baseURL+transactionID+"/commit", nil, auth)
+	if err != nil {
+		t.Fatalf("Failed to send commit: %v", err)
+	}
+	if resp.StatusCode != http.StatusServerError {
+		t.Errorf("Expected status 500 for committing an aborted transaction, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Do(http.POST, baseURL+transactionID+"/commit", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to send commit: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without credentials, got %d", resp.StatusCode)
+	}
+}
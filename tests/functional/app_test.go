@@ -0,0 +1,1202 @@
+package functional
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestAppInProcess tests the full server app (HTTP server, 2PC coordinator and two replicas) without any external process
+func TestAppInProcess(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8090
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	body := []byte(`{"sensorId":"app-1","value":21.5,"unit":"°C"}`)
+	resp, err := client.Do(http.POST, "http://localhost:8090/data", body, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	sequence := resp.Header(server.SessionSequenceHeader)
+	if sequence == "" {
+		t.Fatalf("Expected a %s header on the write", server.SessionSequenceHeader)
+	}
+
+	//sending the sequence back reads from a database that applied the write
+	resp, err = client.Do(http.GET, "http://localhost:8090/data/app-1", nil, map[string]string{server.SessionSequenceHeader: sequence})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	var data []types.SensorData
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(data) != 1 || data[0].Value != 21.5 {
+		t.Errorf("Expected the stored point to be returned, got %v", data)
+	}
+
+	resp, err = client.Do(http.GET, "http://localhost:8090/data", nil, map[string]string{server.SessionSequenceHeader: "not-a-number"})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid session sequence, got %d", resp.StatusCode)
+	}
+
+	log.Println("In-process app test passed")
+}
+
+// TestCSVImport tests uploading a CSV file to /data/import, including the 400 for a malformed row
+func TestCSVImport(t *testing.T) {
+	addr1, service1 := startTestDatabase(t, 100)
+	addr2, service2 := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8094
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	upload := func(csv string) *http.Response {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "readings.csv")
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		part.Write([]byte(csv))
+		writer.Close()
+
+		resp, err := client.Do(http.POST, "http://localhost:8094/data/import", body.Bytes(), map[string]string{"Content-Type": writer.FormDataContentType()})
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	resp := upload("sensorId,timestamp,value,unit\n" +
+		"import-1,2025-01-01T00:00:00Z,1.5,°C\n" +
+		"import-1,2025-01-01T00:01:00Z,2.5,°C\n" +
+		"import-2,,60,%\n")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	var result map[string]int
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if result["imported"] != 3 {
+		t.Errorf("Expected 3 imported rows, got %v", result)
+	}
+
+	for _, service := range []*database.DatabaseService{service1, service2} {
+		data, err := service.GetAllSensorData(context.Background(), &pb.EmptyRequest{})
+		if err != nil {
+			t.Fatalf("Failed to read database: %v", err)
+		}
+		if len(data.Data) != 3 {
+			t.Errorf("Expected 3 points on every replica, got %d", len(data.Data))
+		}
+	}
+
+	resp = upload("import-3,2025-01-01T00:00:00Z,1.5,°C\nimport-3,yesterday,2.5,°C\n")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed row, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(resp.Body), "line 2") {
+		t.Errorf("Expected the error to name line 2, got %q", resp.Body)
+	}
+}
+
+// TestErrorResponses tests that handler errors are returned as {"error":{"code":...,"message":...}} JSON bodies
+func TestErrorResponses(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8097
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		statusCode int
+		code       string
+	}{
+		{http.POST, "/data", `{"sensorId":`, http.StatusBadRequest, "invalid_json"},
+		{http.POST, "/data", `{"value":1}`, http.StatusBadRequest, "missing_sensor_id"},
+		{http.GET, "/data/error-missing", "", http.StatusNotFound, "not_found"},
+		{http.DELETE, "/data", "", http.StatusBadRequest, "confirmation_required"},
+	}
+
+	client := http.HttpClientFactory(5 * time.Second)
+	for _, tt := range tests {
+		resp, err := client.Do(tt.method, "http://localhost:8097"+tt.path, []byte(tt.body), nil)
+		if err != nil {
+			t.Fatalf("%s %s: failed to send request: %v", tt.method, tt.path, err)
+		}
+		if resp.StatusCode != tt.statusCode {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.statusCode, resp.StatusCode)
+		}
+		if contentType := resp.Header("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s %s: expected Content-Type application/json, got %q", tt.method, tt.path, contentType)
+		}
+
+		//decode strictly so that any extra or renamed field breaks the test
+		var errorBody http.ErrorBody
+		decoder := json.NewDecoder(bytes.NewReader(resp.Body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&errorBody); err != nil {
+			t.Errorf("%s %s: body %q is not an error object: %v", tt.method, tt.path, resp.Body, err)
+			continue
+		}
+		if errorBody.Error.Code != tt.code {
+			t.Errorf("%s %s: expected code %q, got %q", tt.method, tt.path, tt.code, errorBody.Error.Code)
+		}
+		if errorBody.Error.Message == "" {
+			t.Errorf("%s %s: expected a message", tt.method, tt.path)
+		}
+	}
+}
+
+// TestOverloadedWrite tests that a write refused by a database at capacity is answered with 503 and Retry-After
+func TestOverloadedWrite(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100, database.WithMaxPreparedTransactions(1))
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8098
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	//a transaction left prepared fills the second database
+	dbClient, err := database.ClientFactory(addr2)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbClient.Close()
+	if resp, err := dbClient.PrepareTransaction("overload-txn", types.SensorData{SensorID: "overload-0", Timestamp: time.Now()}); err != nil || !resp.Success {
+		t.Fatalf("Failed to prepare the blocking transaction: %v %v", resp, err)
+	}
+
+	client := http.HttpClientFactory(5 * time.Second)
+	post := func() *http.Response {
+		t.Helper()
+		resp, err := client.PostJSON("http://localhost:8098/data", []byte(`{"sensorId":"overload-1","value":1,"unit":"test"}`))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	resp := post()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 while the database is at capacity, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	if retryAfter := resp.Header("Retry-After"); retryAfter == "" {
+		t.Errorf("Expected a Retry-After header on the 503")
+	}
+	var errorBody http.ErrorBody
+	if err := json.Unmarshal(resp.Body, &errorBody); err != nil || errorBody.Error.Code != "overloaded" {
+		t.Errorf("Expected error code overloaded, got %q (%v)", resp.Body, err)
+	}
+
+	//once the capacity is free again the same write succeeds
+	if err := dbClient.AbortTransaction("overload-txn"); err != nil {
+		t.Fatalf("Failed to abort the blocking transaction: %v", err)
+	}
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 after the capacity was freed, got %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+// TestUpsertWrite tests that posting a reading with the same sensor ID and timestamp twice to replicas in upsert mode
+// leaves a single point with the latest value on every replica, while the default mode keeps both
+func TestUpsertWrite(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100, database.WithUpsert())
+	addr2, _ := startTestDatabase(t, 100, database.WithUpsert())
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8099
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	for _, body := range []string{
+		`{"sensorId":"upsert-1","timestamp":"2025-06-01T12:00:00Z","value":1,"unit":"°C"}`,
+		`{"sensorId":"upsert-1","timestamp":"2025-06-01T12:00:00Z","value":2,"unit":"°F"}`,
+		`{"sensorId":"upsert-1","timestamp":"2025-06-01T12:00:01Z","value":3,"unit":"°C"}`,
+	} {
+		resp, err := client.PostJSON("http://localhost:8099/data", []byte(body))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	//the 2PC commit went through upsert on both replicas, not only on the one the server reads from
+	for _, addr := range []string{addr1, addr2} {
+		dbClient, err := database.ClientFactory(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer dbClient.Close()
+
+		data, err := dbClient.GetDataPointBySensorId("upsert-1")
+		if err != nil {
+			t.Fatalf("Failed to read from %s: %v", addr, err)
+		}
+		if len(data) != 2 {
+			t.Fatalf("Expected 2 points on %s, got %v", addr, data)
+		}
+		if data[0].Value != 2 || data[0].Unit != "°F" || data[1].Value != 3 {
+			t.Errorf("Expected the re-sent reading to replace the first one on %s, got %v", addr, data)
+		}
+	}
+
+	//without upsert the same reading is stored twice
+	plain, _ := startTestDatabase(t, 100)
+	dbClient, err := database.ClientFactory(plain)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbClient.Close()
+	reading := types.SensorData{SensorID: "upsert-2", Timestamp: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), Value: 1, Unit: "°C"}
+	for range 2 {
+		if err := dbClient.AddDataPoint(reading); err != nil {
+			t.Fatalf("Failed to store point: %v", err)
+		}
+	}
+	if data, err := dbClient.GetDataPointBySensorId("upsert-2"); err != nil || len(data) != 2 {
+		t.Errorf("Expected 2 points without upsert, got %v (%v)", data, err)
+	}
+}
+
+// TestFieldsProjection tests that GET /data?fields= returns only the requested keys and rejects unknown fields
+func TestFieldsProjection(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8100
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8100/data", []byte(`{"sensorId":"fields-1","value":21.5,"unit":"°C"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	for _, url := range []string{
+		"http://localhost:8100/data?fields=sensorId,value",
+		"http://localhost:8100/data?fields=value,sensorId,value",
+	} {
+		resp, err = client.Get(url)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", url, resp.StatusCode, resp.Body)
+		}
+
+		var views []map[string]any
+		if err := json.Unmarshal(resp.Body, &views); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(views) != 1 || len(views[0]) != 2 || views[0]["sensorId"] != "fields-1" || views[0]["value"] != 21.5 {
+			t.Errorf("Expected only sensorId and value for %s, got %s", url, resp.Body)
+		}
+	}
+
+	//the grouped query is projected as well
+	resp, err = client.Get("http://localhost:8100/data?ids=fields-1&fields=unit")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var groups map[string][]map[string]any
+	if err := json.Unmarshal(resp.Body, &groups); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if views := groups["fields-1"]; len(views) != 1 || len(views[0]) != 1 || views[0]["unit"] != "°C" {
+		t.Errorf("Expected only the unit in the group, got %s", resp.Body)
+	}
+
+	for _, fields := range []string{"sensorId,color", "SensorID", "", "value,"} {
+		resp, err = client.Get("http://localhost:8100/data?fields=" + fields)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(resp.Body), "invalid_fields") {
+			t.Errorf("Expected 400 invalid_fields for fields=%q, got %d: %s", fields, resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// TestPatchData tests that PATCH /data/<sensorId> changes only the given fields of one reading on every replica
+func TestPatchData(t *testing.T) {
+	addr1, db1 := startTestDatabase(t, 100)
+	addr2, db2 := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8105
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8105/data", []byte(`{"sensorId":"patch-1","timestamp":"2025-06-01T12:00:00Z","value":21.5,"unit":"°C"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	//only the value is patched, the unit has to be preserved
+	resp, err = client.Do(http.PATCH, "http://localhost:8105/data/patch-1", []byte(`{"timestamp":"2025-06-01T12:00:00Z","value":23}`), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	for i, db := range []*database.DatabaseService{db1, db2} {
+		list, err := db.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "patch-1"})
+		if err != nil {
+			t.Fatalf("Failed to read database %d: %v", i, err)
+		}
+		if len(list.Data) != 1 || list.Data[0].Value != 23 || list.Data[0].Unit != "°C" {
+			t.Errorf("Expected value 23 and unit °C on database %d, got %v", i, list.Data)
+		}
+	}
+
+	//the direct RPC patches a single database
+	dbClient, err := database.ClientFactory(addr1)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbClient.Close()
+	unit := "K"
+	patch := types.SensorDataPatch{SensorID: "patch-1", Timestamp: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), Unit: &unit}
+	if err := dbClient.PatchDataPoint(patch); err != nil {
+		t.Fatalf("Failed to patch data point: %v", err)
+	}
+	data, err := dbClient.GetDataPointBySensorId("patch-1")
+	if err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if len(data) != 1 || data[0].Value != 23 || data[0].Unit != "K" {
+		t.Errorf("Expected value 23 and unit K after the direct patch, got %v", data)
+	}
+
+	tests := []struct {
+		path     string
+		body     string
+		expected int
+		code     string
+	}{
+		{"/data/patch-1", `{"timestamp":"2025-06-01T12:00:01Z","value":1}`, http.StatusNotFound, "not_found"},
+		{"/data/patch-2", `{"timestamp":"2025-06-01T12:00:00Z","value":1}`, http.StatusNotFound, "not_found"},
+		{"/data/patch-1", `{"value":1}`, http.StatusBadRequest, "invalid_sensor_data"},
+		{"/data/patch-1", `{"timestamp":"2025-06-01T12:00:00Z"}`, http.StatusBadRequest, "empty_patch"},
+		{"/data/patch-1", `{"timestamp":`, http.StatusBadRequest, "invalid_json"},
+	}
+	for _, test := range tests {
+		resp, err := client.Do(http.PATCH, "http://localhost:8105"+test.path, []byte(test.body), map[string]string{"Content-Type": "application/json"})
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != test.expected || !strings.Contains(string(resp.Body), test.code) {
+			t.Errorf("PATCH %s %s: expected %d %s, got %d: %s", test.path, test.body, test.expected, test.code, resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// TestRouteListing tests Server.Routes and the GET /_routes endpoint of the app
+func TestRouteListing(t *testing.T) {
+	bare := http.ServerFactory("localhost", 0)
+	noop := func(*http.Request) *http.Response { return http.NewResponse(http.StatusOK) }
+	bare.RegisterHandler(http.GET, "/data", noop)
+	bare.RegisterHandler(http.GET, "/data/*", noop)
+	bare.RegisterHandler(http.POST, "*", noop)
+	bare.RegisterHandlerForHost("Example.com", http.GET, "/data", noop)
+
+	expected := []http.RouteInfo{
+		{Method: http.POST, Path: "*", Kind: http.RouteWildcard},
+		{Method: http.GET, Path: "/data", Kind: http.RouteExact},
+		{Method: http.GET, Path: "/data/*", Kind: http.RoutePrefix},
+		{Method: http.GET, Path: "/data", Host: "example.com", Kind: http.RouteExact},
+	}
+	routes := bare.Routes()
+	if len(routes) != len(expected) {
+		t.Fatalf("Expected %d routes, got %+v", len(expected), routes)
+	}
+	for i := range expected {
+		if routes[i] != expected[i] {
+			t.Errorf("Route %d: expected %+v, got %+v", i, expected[i], routes[i])
+		}
+	}
+
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	app := startTestApp(t, func(config *server.Config) {
+		config.Port = 8107
+		config.DatabaseAddresses = []string{addr1, addr2}
+		config.RouteListing = true
+	})
+
+	//a handler registered after the listing shows up as well
+	app.Server().RegisterHandler(http.GET, "/late", noop)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.Get("http://localhost:8107/_routes")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	var listed []http.RouteInfo
+	if err := json.Unmarshal(resp.Body, &listed); err != nil {
+		t.Fatalf("Failed to decode routes: %v", err)
+	}
+	for _, want := range []http.RouteInfo{
+		{Method: http.POST, Path: "/data", Kind: http.RouteExact},
+		{Method: http.PATCH, Path: "/data/*", Kind: http.RoutePrefix},
+		{Method: http.GET, Path: "/_routes", Kind: http.RouteExact},
+		{Method: http.GET, Path: "/late", Kind: http.RouteExact},
+	} {
+		found := false
+		for _, route := range listed {
+			found = found || route == want
+		}
+		if !found {
+			t.Errorf("Expected %+v in the listing, got %s", want, resp.Body)
+		}
+	}
+
+	//without the flag the endpoint is not registered
+	config := server.DefaultConfig()
+	config.DatabaseAddresses = []string{addr1, addr2}
+	plain, err := server.AppFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	defer plain.Stop()
+	for _, route := range plain.Server().Routes() {
+		if route.Path == "/_routes" {
+			t.Errorf("Expected no /_routes without RouteListing")
+		}
+	}
+}
+
+// blockingStorage is a Storage whose Add waits until release is closed, to hold a 2PC commit in progress
+type blockingStorage struct {
+	database.Storage
+	entered chan struct{} //closed by the first Add
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *blockingStorage) Add(readings []types.SensorData) error {
+	s.once.Do(func() { close(s.entered) })
+	<-s.release
+	return s.Storage.Add(readings)
+}
+
+// TestShutdownDuringTransaction tests that shutting down the app while a request is in the middle of a 2PC commit
+// lets the transaction finish before the connections are closed, and that a shutdown whose timeout expires closes
+// them anyway without a panic
+func TestShutdownDuringTransaction(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		port    int
+		timeout time.Duration
+	}{
+		{"drained", 8109, 5 * time.Second},
+		{"timeout", 8110, 100 * time.Millisecond},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			storage := &blockingStorage{Storage: database.MemoryStorageFactory(100), entered: make(chan struct{}), release: make(chan struct{})}
+			addr1, db1 := startTestDatabase(t, 100)
+			addr2, db2 := startTestDatabase(t, 100, database.WithStorage(storage))
+
+			app := startTestApp(t, func(config *server.Config) {
+				config.Port = test.port
+				config.DatabaseAddresses = []string{addr1, addr2}
+			})
+
+			responses := make(chan *http.Response, 1)
+			go func() {
+				client := http.HttpClientFactory(10 * time.Second)
+				resp, err := client.PostJSON(fmt.Sprintf("http://localhost:%d/data", test.port), []byte(`{"sensorId":"shutdown-1","value":1,"unit":"test"}`))
+				if err != nil {
+					resp = nil
+				}
+				responses <- resp
+			}()
+
+			//the request is now committing on the second database
+			select {
+			case <-storage.entered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("The write never reached the commit")
+			}
+
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- app.Shutdown(test.timeout) }()
+
+			if test.timeout > time.Second {
+				//the commit finishes while the shutdown waits for it
+				time.Sleep(100 * time.Millisecond)
+				close(storage.release)
+				if err := <-shutdown; err != nil {
+					t.Errorf("Expected a clean shutdown, got %v", err)
+				}
+				if resp := <-responses; resp == nil || resp.StatusCode != http.StatusOK {
+					t.Errorf("Expected the request in progress to be answered, got %v", resp)
+				}
+				for i, db := range []*database.DatabaseService{db1, db2} {
+					list, err := db.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "shutdown-1"})
+					if err != nil || len(list.Data) != 1 {
+						t.Errorf("Expected the reading committed on database %d, got %v (%v)", i+1, list, err)
+					}
+				}
+
+				//a drained client refuses new transactions instead of using its closed connections
+				tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+				if err != nil {
+					t.Fatalf("Failed to create 2PC client: %v", err)
+				}
+				if err := tpc.DrainAndClose(time.Second); err != nil {
+					t.Errorf("Expected an idle client to drain cleanly, got %v", err)
+				}
+				if err := tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "shutdown-2", Timestamp: time.Now(), Value: 1, Unit: "test"}); !errors.Is(err, database.ErrClientClosed) {
+					t.Errorf("Expected ErrClientClosed after draining, got %v", err)
+				}
+				return
+			}
+
+			//the commit outlives both timeouts, so the connections are closed under the running transaction
+			if err := <-shutdown; err == nil {
+				t.Errorf("Expected the shutdown to report the cut off request")
+			}
+			close(storage.release)
+			<-responses
+		})
+	}
+}
+
+// TestValueFormat tests that the server writes reading values with the configured precision, also in projections,
+// and that the string format quotes the exact digits
+func TestValueFormat(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8112
+		config.DatabaseAddresses = []string{addr1, addr2}
+		config.ValueFormat = types.ValueFormat{Precision: 2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8112/data", []byte(`{"sensorId":"format-1","timestamp":"2025-06-01T12:00:00Z","value":23.1,"unit":"°C"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to store reading: %v %v", resp, err)
+	}
+
+	for _, path := range []string{"/data/format-1", "/data?prefix=format-", "/data?ids=format-1", "/data?fields=sensorId,value"} {
+		resp, err := client.Get("http://localhost:8112" + path)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(resp.Body), `"value":23.10`) {
+			t.Errorf("GET %s: expected the value with 2 decimals, got %d: %s", path, resp.StatusCode, resp.Body)
+		}
+	}
+
+	reading := types.SensorData{SensorID: "format-2", Timestamp: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), Value: 23.1, Unit: "°C"}
+	tests := []struct {
+		format   types.ValueFormat
+		expected string
+	}{
+		{types.DefaultValueFormat, `"value":23.1,`},
+		{types.ValueFormat{Precision: 0}, `"value":23,`},
+		{types.ValueFormat{Precision: 2, AsString: true}, `"value":"23.10",`},
+		{types.ValueFormat{Precision: -1, AsString: true}, `"value":"23.1",`},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(types.FormattedSensorData{SensorData: reading, Format: test.format})
+		if err != nil {
+			t.Fatalf("Failed to marshal with %s: %v", test.format, err)
+		}
+		plain, _ := json.Marshal(reading)
+		if !strings.Contains(string(data), test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.format, test.expected, data)
+		}
+		if test.format.IsDefault() && string(data) != string(plain) {
+			t.Errorf("Expected the default format to marshal like SensorData, got %s instead of %s", data, plain)
+		}
+	}
+}
+
+// TestTimestampFormats tests that readings posted with an RFC 3339 and with an epoch millis timestamp are stored
+// identically, and that ?timestamps= selects how the times of a response are written
+func TestTimestampFormats(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8118
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	for _, body := range []string{
+		`{"sensorId":"ts-rfc","timestamp":"2025-06-01T12:00:00.123Z","value":21.5,"unit":"°C"}`,
+		`{"sensorId":"ts-millis","timestamp":1748779200123,"value":21.5,"unit":"°C"}`,
+	} {
+		resp, err := client.PostJSON("http://localhost:8118/data", []byte(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to store %s: %v %v", body, resp, err)
+		}
+	}
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := client.Get("http://localhost:8118" + path)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, got %d: %s", path, http.StatusOK, resp.StatusCode, resp.Body)
+		}
+		return string(resp.Body)
+	}
+
+	var stored []types.SensorData
+	for _, sensorID := range []string{"ts-rfc", "ts-millis"} {
+		var readings []types.SensorData
+		if err := json.Unmarshal([]byte(get("/data/"+sensorID)), &readings); err != nil || len(readings) != 1 {
+			t.Fatalf("Expected one reading of %s, got %+v (%v)", sensorID, readings, err)
+		}
+		stored = append(stored, readings[0])
+	}
+	if !stored[0].Timestamp.Equal(stored[1].Timestamp) || !stored[0].Timestamp.Equal(time.UnixMilli(1748779200123)) {
+		t.Errorf("Expected both readings stored at the same instant, got %v and %v", stored[0].Timestamp, stored[1].Timestamp)
+	}
+
+	if body := get("/data/ts-millis"); !strings.Contains(body, `"timestamp":"2025-06-01T12:00:00.123Z"`) {
+		t.Errorf("Expected RFC 3339 timestamps by default, got %s", body)
+	}
+	for _, path := range []string{"/data/ts-rfc?timestamps=millis", "/data?ids=ts-rfc&timestamps=millis", "/data?fields=sensorId,timestamp&timestamps=millis"} {
+		if body := get(path); !strings.Contains(body, `"timestamp":1748779200123`) || strings.Contains(body, `"ingestedAt":"`) {
+			t.Errorf("GET %s: expected the times as epoch millis, got %s", path, body)
+		}
+	}
+
+	resp, err := client.Get("http://localhost:8118/data?timestamps=unix")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(resp.Body), "invalid_timestamps") {
+		t.Errorf("Expected 400 invalid_timestamps for an unknown format, got %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+// TestIdempotencyKey tests that a POST /data repeated with the same Idempotency-Key is stored only once
+func TestIdempotencyKey(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8113
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	post := func(key, body string) *http.Response {
+		t.Helper()
+		headers := map[string]string{"Content-Type": "application/json"}
+		if key != "" {
+			headers[server.IdempotencyKeyHeader] = key
+		}
+		resp, err := client.Do(http.POST, "http://localhost:8113/data", []byte(body), headers)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+	count := func(sensorID string) int {
+		t.Helper()
+		resp, err := client.Get("http://localhost:8113/data/" + sensorID)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var readings []types.SensorData
+		if err := json.Unmarshal(resp.Body, &readings); err != nil {
+			t.Fatalf("Failed to parse readings: %v: %s", err, resp.Body)
+		}
+		return len(readings)
+	}
+
+	body := `{"sensorId":"idem-1","value":21.5,"unit":"°C"}`
+	first := post("retry-1", body)
+	if first.StatusCode != http.StatusOK || first.Header(server.IdempotentReplayHeader) != "" {
+		t.Fatalf("Expected the first request to be stored, got %d %q: %s", first.StatusCode, first.Header(server.IdempotentReplayHeader), first.Body)
+	}
+	repeat := post("retry-1", body)
+	if repeat.StatusCode != http.StatusOK || repeat.Header(server.IdempotentReplayHeader) != "true" {
+		t.Fatalf("Expected the repeat to be replayed, got %d %q: %s", repeat.StatusCode, repeat.Header(server.IdempotentReplayHeader), repeat.Body)
+	}
+	if string(repeat.Body) != string(first.Body) || repeat.Header(server.SessionSequenceHeader) != first.Header(server.SessionSequenceHeader) {
+		t.Errorf("Expected the original response, got %q (sequence %s) instead of %q (sequence %s)",
+			repeat.Body, repeat.Header(server.SessionSequenceHeader), first.Body, first.Header(server.SessionSequenceHeader))
+	}
+	if n := count("idem-1"); n != 1 {
+		t.Errorf("Expected 1 stored point after the repeat, got %d", n)
+	}
+
+	//the key belongs to the first request, other readings under it are rejected instead of silently dropped
+	if resp := post("retry-1", `{"sensorId":"idem-1","value":22.5,"unit":"°C"}`); resp.StatusCode != http.StatusUnprocessable {
+		t.Errorf("Expected status %d for a reused key, got %d: %s", http.StatusUnprocessable, resp.StatusCode, resp.Body)
+	}
+
+	//a rejected request stored nothing, so it does not use up its key
+	if resp := post("retry-2", `{"sensorId":"","value":1}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an invalid reading, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp := post("retry-2", `{"sensorId":"","value":1}`); resp.Header(server.IdempotentReplayHeader) != "" {
+		t.Errorf("Expected a rejected request not to be replayed")
+	}
+
+	//without a key every request is stored
+	post("", body)
+	post("", body)
+	if n := count("idem-1"); n != 3 {
+		t.Errorf("Expected 3 stored points after two requests without a key, got %d", n)
+	}
+}
+
+// failingCommitService is a database that prepares transactions but fails every commit
+type failingCommitService struct {
+	*database.DatabaseService
+}
+
+// CommitTransaction fails without applying the transaction
+func (s *failingCommitService) CommitTransaction(ctx context.Context, req *pb.TransactionId) (*pb.OperationResponse, error) {
+	return nil, status.Error(codes.Internal, "commit failed")
+}
+
+// TestIdempotencyKeyAfterPartialCommit tests that a POST /data whose commit reached only one replica keeps its key: the
+// 500 is replayed for a retry instead of storing the readings on the healthy replica a second time
+func TestIdempotencyKeyAfterPartialCommit(t *testing.T) {
+	addr1, healthy := startTestDatabase(t, 100)
+	failing := &failingCommitService{DatabaseService: newTestService(t, 100)}
+	addr2 := serveTestDatabase(t, failing, failing.Stop)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8132
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	headers := map[string]string{"Content-Type": "application/json", server.IdempotencyKeyHeader: "partial-1"}
+	body := []byte(`{"sensorId":"partial-1","value":1,"unit":"°C"}`)
+
+	first, err := client.Do(http.POST, "http://localhost:8132/data", body, headers)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if first.StatusCode != http.StatusServerError {
+		t.Fatalf("Expected status %d for a partial commit, got %d: %s", http.StatusServerError, first.StatusCode, first.Body)
+	}
+
+	repeat, err := client.Do(http.POST, "http://localhost:8132/data", body, headers)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if repeat.StatusCode != http.StatusServerError || repeat.Header(server.IdempotentReplayHeader) != "true" {
+		t.Errorf("Expected the 500 to be replayed, got %d %q: %s", repeat.StatusCode, repeat.Header(server.IdempotentReplayHeader), repeat.Body)
+	}
+
+	stored, err := healthy.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "partial-1"})
+	if err != nil {
+		t.Fatalf("Failed to read the healthy replica: %v", err)
+	}
+	if n := len(stored.Data); n != 1 {
+		t.Errorf("Expected 1 point on the healthy replica after the retry, got %d", n)
+	}
+}
+
+// TestStrictDecoding tests that request bodies with unknown fields, values of the wrong type or trailing data are
+// rejected with a 400 naming the problem, and that nothing of such a request is stored
+func TestStrictDecoding(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8115
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		body    string
+		message string
+	}{
+		{http.POST, "/data", `{"sensorId":"strict-1","value":1,"colour":"red"}`, `unknown field \"colour\"`},
+		{http.POST, "/data", `{"sensorId":"strict-1","value":"21.5"}`, `field \"value\": expected number, got string`},
+		{http.POST, "/data", `{"sensorId":7,"value":1}`, `field \"sensorId\": expected string, got number`},
+		{http.POST, "/data", `[{"sensorId":"strict-1","value":1},{"sensorId":"strict-1","value":true}]`, `reading 1: field \"value\": expected number, got bool`},
+		{http.POST, "/data", `{"sensorId":"strict-1","value":1} {"sensorId":"strict-1","value":2}`, "unexpected data after the JSON value"},
+		{http.POST, "/data", `{"sensorId":"strict-1","value":1,"timestamp":"yesterday"}`, `invalid time \"yesterday\"`},
+		{http.POST, "/data", `{"sensorId":"strict-1","value":1,"timestamp":1.5}`, `invalid timestamp 1.5`},
+		{http.POST, "/data", `{"sensorId":"strict-1","value":1,"timestamp":1748779200000,"colour":"red"}`, `unknown field \"colour\"`},
+		{http.PATCH, "/data/strict-1", `{"timestamp":"2025-06-01T12:00:00Z","value":"x"}`, `field \"value\": expected number, got string`},
+	}
+
+	client := http.HttpClientFactory(5 * time.Second)
+	for _, tt := range tests {
+		resp, err := client.Do(tt.method, "http://localhost:8115"+tt.path, []byte(tt.body), map[string]string{"Content-Type": "application/json"})
+		if err != nil {
+			t.Fatalf("%s %s: failed to send request: %v", tt.method, tt.path, err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(resp.Body), "invalid_json") || !strings.Contains(string(resp.Body), tt.message) {
+			t.Errorf("%s %s %s: expected 400 invalid_json mentioning %s, got %d: %s", tt.method, tt.path, tt.body, tt.message, resp.StatusCode, resp.Body)
+		}
+	}
+
+	//a rejected burst is rejected as a whole, so not even its valid first reading was stored
+	resp, err := client.Get("http://localhost:8115/data/strict-1")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected nothing stored for strict-1, got %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+// TestAtomicBurst tests that a burst posted to /data is stored in a single transaction: a reading one database votes
+// no for fails the whole request and none of the readings before it are stored, so a retry cannot duplicate them
+func TestAtomicBurst(t *testing.T) {
+	positive := func(data types.SensorData) error {
+		if data.Value < 0 {
+			return fmt.Errorf("negative value %v", data.Value)
+		}
+		return nil
+	}
+	addr1, service1 := startTestDatabase(t, 100, database.WithPrepareValidator(positive))
+	addr2, service2 := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8130
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := client.Post("http://localhost:8130"+path, []byte(body), "application/json")
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+	burst := `[{"sensorId":"burst-1","value":1},{"sensorId":"burst-2","value":2},{"sensorId":"burst-3","value":-3}]`
+
+	for _, path := range []string{"/data?dryrun=true", "/data"} {
+		if resp := post(path, burst); resp.StatusCode != http.StatusUnprocessable {
+			t.Errorf("POST %s: expected 422 for a burst with a rejected reading, got %d: %s", path, resp.StatusCode, resp.Body)
+		}
+	}
+	for i, service := range []*database.DatabaseService{service1, service2} {
+		points, err := service.Snapshot()
+		if err != nil {
+			t.Fatalf("Failed to read database %d: %v", i, err)
+		}
+		if len(points) != 0 {
+			t.Errorf("Expected database %d to store nothing of the rejected burst, got %v", i, points)
+		}
+	}
+
+	//a valid burst is one commit, so all of its readings share one store sequence
+	resp := post("/data", strings.Replace(burst, `"value":-3`, `"value":3`, 1))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for a valid burst, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	points, err := service2.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to read database: %v", err)
+	}
+	if len(points) != 3 || points[0].Sequence != points[2].Sequence {
+		t.Errorf("Expected the 3 readings stored with one store sequence, got %v", points)
+	}
+}
+
+// countingReadService is a database that counts how often all of its data is read
+type countingReadService struct {
+	*database.DatabaseService
+	reads atomic.Int64
+}
+
+// GetAllSensorData counts the read and returns all data
+func (s *countingReadService) GetAllSensorData(ctx context.Context, req *pb.EmptyRequest) (*pb.SensorDataList, error) {
+	s.reads.Add(1)
+	return s.DatabaseService.GetAllSensorData(ctx, req)
+}
+
+// TestLongPoll tests that GET /data/poll returns the readings stored after the given store sequence of the first
+// database, that a write wakes the polls with reads shared between them, that it answers 204 once its timeout passed
+// and returns at once when the app shuts down
+func TestLongPoll(t *testing.T) {
+	counting := &countingReadService{DatabaseService: newTestService(t, 100)}
+	addr1 := serveTestDatabase(t, counting, counting.Stop)
+	addr2, _ := startTestDatabase(t, 100)
+
+	app := startTestApp(t, func(config *server.Config) {
+		config.Port = 8116
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+	client := http.HttpClientFactory(10 * time.Second)
+
+	post := func(sensorID string) {
+		t.Helper()
+		resp, err := client.PostJSON("http://localhost:8116/data", []byte(fmt.Sprintf(`{"sensorId":%q,"value":1,"unit":"test"}`, sensorID)))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to post %s: %v %v", sensorID, err, resp)
+		}
+	}
+	type pollResult struct {
+		resp *http.Response
+		err  error
+	}
+	poll := func(query string) <-chan pollResult {
+		result := make(chan pollResult, 1)
+		go func() {
+			resp, err := client.Get("http://localhost:8116/data/poll?" + query)
+			result <- pollResult{resp, err}
+		}()
+		return result
+	}
+	decode := func(result pollResult) []types.SensorData {
+		t.Helper()
+		if result.err != nil || result.resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %v %v", result.err, result.resp)
+		}
+		var readings []types.SensorData
+		if err := json.Unmarshal(result.resp.Body, &readings); err != nil {
+			t.Fatalf("Failed to decode poll response %s: %v", result.resp.Body, err)
+		}
+		return readings
+	}
+
+	//readings stored before a poll without after are not returned
+	post("poll-old")
+
+	polled := poll("timeout=10s")
+	time.Sleep(200 * time.Millisecond)
+	posted := time.Now()
+	post("poll-new")
+
+	var readings []types.SensorData
+	select {
+	case result := <-polled:
+		//the recheck would find the reading within a second too, the write itself has to wake the poll earlier
+		if elapsed := time.Since(posted); elapsed > 500*time.Millisecond {
+			t.Errorf("Expected the write to wake the poll, it returned %v after the write", elapsed)
+		}
+		readings = decode(result)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Poll did not return after a write")
+	}
+	if len(readings) != 1 || readings[0].SensorID != "poll-new" || readings[0].Sequence == 0 {
+		t.Fatalf("Expected only poll-new with its sequence, got %+v", readings)
+	}
+
+	//after=0 returns everything the first database stored in the order it stored it
+	all := decode(<-poll("after=0&timeout=1s"))
+	if len(all) != 2 || all[0].SensorID != "poll-old" || all[1].SensorID != "poll-new" || all[1].Sequence != readings[0].Sequence {
+		t.Errorf("Expected poll-old and then poll-new, got %+v", all)
+	}
+
+	//nothing is newer than the returned reading, so the next poll times out
+	next := strconv.FormatUint(readings[0].Sequence, 10)
+	if result := <-poll("after=" + next + "&timeout=200ms"); result.err != nil || result.resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 after the timeout, got %v %v", result.err, result.resp)
+	}
+
+	for _, query := range []string{"after=yesterday", "after=-1", "timeout=-1s", "timeout=1h"} {
+		if result := <-poll(query); result.err != nil || result.resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v %v", query, result.err, result.resp)
+		}
+	}
+
+	//the polls woken by one write share their reads instead of each reading all data
+	const pollers = 20
+	waiting := make([]<-chan pollResult, pollers)
+	for i := range waiting {
+		waiting[i] = poll("after=" + next + "&timeout=10s")
+	}
+	time.Sleep(300 * time.Millisecond)
+	before := counting.reads.Load()
+	post("poll-shared")
+	for _, polled := range waiting {
+		got := decode(<-polled)
+		if len(got) != 1 || got[0].SensorID != "poll-shared" {
+			t.Fatalf("Expected only poll-shared, got %+v", got)
+		}
+		next = strconv.FormatUint(got[0].Sequence, 10)
+	}
+	if reads := counting.reads.Load() - before; reads >= pollers/2 {
+		t.Errorf("Expected the %d polls to share their reads, the first database was read %d times", pollers, reads)
+	}
+
+	//a shutdown does not wait for the poll's timeout
+	polled = poll("after=" + next + "&timeout=1m")
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	app.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop waited %v for the poll", elapsed)
+	}
+	if result := <-polled; result.err != nil || result.resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 on shutdown, got %v %v", result.err, result.resp)
+	}
+}
+
+// TestCoordinatorLease tests two servers sharing the databases: only the one holding the coordinator lease accepts
+// writes, the standby serves reads and refuses writes with 503 until the leader stops and it takes the lease over
+func TestCoordinatorLease(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	//the databases grant the lease to one holder at a time
+	dbClient, err := database.ClientFactory(addr1)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbClient.Close()
+	if resp, err := dbClient.AcquireLease("holder-1", time.Second); err != nil || !resp.Granted {
+		t.Fatalf("Expected the free lease to be granted, got %v (%v)", resp, err)
+	}
+	if resp, err := dbClient.AcquireLease("holder-2", time.Second); err != nil || resp.Granted || resp.Holder != "holder-1" {
+		t.Errorf("Expected the lease held by holder-1 to be refused, got %v (%v)", resp, err)
+	}
+	if resp, err := dbClient.ReleaseLease("holder-1"); err != nil || !resp.Granted {
+		t.Fatalf("Expected the lease to be released, got %v (%v)", resp, err)
+	}
+	//a lease that is not renewed expires, e.g. when its holder crashed
+	if resp, err := dbClient.AcquireLease("holder-2", 50*time.Millisecond); err != nil || !resp.Granted {
+		t.Fatalf("Expected the released lease to be granted, got %v (%v)", resp, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if resp, err := dbClient.AcquireLease("holder-1", 50*time.Millisecond); err != nil || !resp.Granted {
+		t.Fatalf("Expected the expired lease to be granted, got %v (%v)", resp, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := func(port int, holder string) *server.App {
+		t.Helper()
+		return startTestApp(t, func(config *server.Config) {
+			config.Port = port
+			config.DatabaseAddresses = []string{addr1, addr2}
+			config.LeaseTTL = 600 * time.Millisecond
+			config.LeaseHolder = holder
+		})
+	}
+
+	//the first server takes the free lease
+	leader := start(8125, "server-a")
+	start(8126, "server-b")
+
+	client := http.HttpClientFactory(5 * time.Second)
+	post := func(port int, sensorID string) *http.Response {
+		t.Helper()
+		resp, err := client.PostJSON(fmt.Sprintf("http://localhost:%d/data", port), []byte(`{"sensorId":"`+sensorID+`","value":1,"unit":"test"}`))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	if resp := post(8125, "lease-1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the leader to accept the write, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	resp := post(8126, "lease-2")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 from the standby, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	var errorBody http.ErrorBody
+	if err := json.Unmarshal(resp.Body, &errorBody); err != nil || errorBody.Error.Code != "not_leader" || !strings.Contains(errorBody.Error.Message, "server-a") {
+		t.Errorf("Expected error code not_leader naming server-a, got %q (%v)", resp.Body, err)
+	}
+
+	//the standby still serves reads, including what the leader wrote
+	resp, err = client.Get("http://localhost:8126/data/lease-1")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var data []types.SensorData
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(resp.Body, &data) != nil || len(data) != 1 {
+		t.Errorf("Expected the standby to return the reading written through the leader, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	//a stopped leader releases the lease and the standby takes over
+	leader.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := post(8126, "lease-2")
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || time.Now().After(deadline) {
+			t.Fatalf("Expected the standby to take over the writes, got %d: %s", resp.StatusCode, resp.Body)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
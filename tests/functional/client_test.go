@@ -0,0 +1,1370 @@
+package functional
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestDetailedOperations tests that the detailed client methods return the raw database responses
+func TestDetailedOperations(t *testing.T) {
+	addr, _ := startTestDatabase(t, 100)
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	point, err := types.NewSensorData("detailed-1", 1.0, types.WithUnit("test"))
+	if err != nil {
+		t.Fatalf("Failed to build reading: %v", err)
+	}
+
+	resp, err := client.AddDataPointDetailed(point)
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected add to succeed, got message %q", resp.Message)
+	}
+
+	point.Value = 2.0
+	resp, err = client.UpdateDataPointDetailed(point)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected update to succeed, got message %q", resp.Message)
+	}
+
+	//an update of a missing point is not a transport error, only an unsuccessful response
+	missing := types.SensorData{SensorID: "detailed-missing", Timestamp: time.Now(), Value: 1.0, Unit: "test"}
+	resp, err = client.UpdateDataPointDetailed(missing)
+	if err != nil {
+		t.Fatalf("Update of missing point returned a transport error: %v", err)
+	}
+	if resp.Success || resp.Message == "" {
+		t.Errorf("Expected an unsuccessful response with a message, got %+v", resp)
+	}
+	if err := client.UpdateDataPoint(missing); err == nil {
+		t.Errorf("Expected UpdateDataPoint to return an error for a missing point")
+	}
+
+	resp, err = client.DeleteDataPointDetailed("")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if resp.Success {
+		t.Errorf("Expected delete without sensor ID to fail")
+	}
+
+	if err := client.DeleteDataPoint("detailed-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	data, err := client.GetDataPointBySensorId("detailed-1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected no data after delete, got %d points", len(data))
+	}
+}
+
+// TestTwoPhaseCommitBreakdown tests that the detailed 2PC add reports timings for every replica and phase
+func TestTwoPhaseCommitBreakdown(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	breakdown, err := tpcClient.AddDataPointWithTwoPhaseCommitDetailed(types.SensorData{
+		SensorID:  "breakdown-1",
+		Timestamp: time.Now(),
+		Value:     1.0,
+		Unit:      "test",
+	})
+	if err != nil {
+		t.Fatalf("2PC transaction failed: %v", err)
+	}
+
+	if len(breakdown.Prepare) != 2 || len(breakdown.Commit) != 2 {
+		t.Fatalf("Expected timings for 2 replicas, got %d prepare and %d commit", len(breakdown.Prepare), len(breakdown.Commit))
+	}
+
+	var replicaTime time.Duration
+	for i := range 2 {
+		if breakdown.Prepare[i] <= 0 || breakdown.Commit[i] <= 0 {
+			t.Errorf("Expected positive timings for replica %d, got prepare %v commit %v", i, breakdown.Prepare[i], breakdown.Commit[i])
+		}
+		replicaTime += breakdown.Prepare[i] + breakdown.Commit[i]
+	}
+
+	if breakdown.Coordination < 0 || replicaTime+breakdown.Coordination != breakdown.Total {
+		t.Errorf("Expected replica time %v plus coordination %v to add up to total %v",
+			replicaTime, breakdown.Coordination, breakdown.Total)
+	}
+
+	if slowest := breakdown.SlowestReplica(); slowest < 0 || slowest > 1 {
+		t.Errorf("Expected slowest replica index 0 or 1, got %d", slowest)
+	}
+}
+
+// TestTwoPhaseCommitPerformanceRun tests that the 2PC performance run averages over its successful iterations and
+// fails if none succeeded instead of reporting empty results
+func TestTwoPhaseCommitPerformanceRun(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 1000)
+	addr2, _ := startTestDatabase(t, 1000)
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	min, max, avg, err := tpcClient.RunTwoPhaseCommitPerformanceTest(5)
+	if err != nil {
+		t.Fatalf("Performance run failed: %v", err)
+	}
+	if min <= 0 || avg < min || avg > max {
+		t.Errorf("Expected min <= avg <= max, got min %v, avg %v, max %v", min, avg, max)
+	}
+
+	failing := &failingPrepareService{DatabaseService: newTestService(t, 100)}
+	failingAddr := serveTestDatabase(t, failing, failing.Stop)
+	failingClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, failingAddr})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer failingClient.Close()
+
+	if _, _, _, err := failingClient.RunTwoPhaseCommitPerformanceTest(3); err == nil {
+		t.Errorf("Expected an error when every iteration fails")
+	}
+}
+
+// TestCircuitBreaker tests that a dead replica opens its breaker, is skipped while open and closes again once it is back
+func TestCircuitBreaker(t *testing.T) {
+	healthyAddr, _ := startTestDatabase(t, 100)
+
+	//reserve a free port and release it again so nothing answers there for now
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	deadAddr := lis.Addr().String()
+	lis.Close()
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(
+		[]string{healthyAddr, deadAddr},
+		database.WithCircuitBreaker(2, 300*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	point, err := types.NewSensorData("breaker-1", 1.0, types.WithUnit("test"))
+	if err != nil {
+		t.Fatalf("Failed to build reading: %v", err)
+	}
+
+	for range 2 {
+		if err := tpcClient.AddDataPointWithTwoPhaseCommit(point); err == nil {
+			t.Fatalf("Expected the transaction to fail while a replica is down")
+		}
+	}
+
+	stats := tpcClient.Stats()
+	if state := stats.Replicas[1].BreakerState; state != "open" {
+		t.Fatalf("Expected the dead replica's breaker to be open, got %s", state)
+	}
+	if state := stats.Replicas[0].BreakerState; state != "closed" {
+		t.Errorf("Expected the healthy replica's breaker to stay closed, got %s", state)
+	}
+
+	if err := tpcClient.AddDataPointWithTwoPhaseCommit(point); err == nil {
+		t.Fatalf("Expected the transaction to fail while the breaker is open")
+	}
+	if failures := tpcClient.Stats().Replicas[1].ConsecutiveFailures; failures != 2 {
+		t.Errorf("Expected the open breaker to skip the replica (2 failures), got %d", failures)
+	}
+
+	startTestDatabaseOn(t, deadAddr, 100)
+
+	//the breaker probes again after the cooldown, the reconnect may take a few attempts
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		time.Sleep(350 * time.Millisecond)
+		if tpcClient.AddDataPointWithTwoPhaseCommit(point) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Replica did not recover, stats: %+v", tpcClient.Stats())
+		}
+	}
+
+	if state := tpcClient.Stats().Replicas[1].BreakerState; state != "closed" {
+		t.Errorf("Expected the breaker to be closed after recovery, got %s", state)
+	}
+}
+
+// failingPrepareService is a database whose prepares fail with Unavailable after delay, counting them
+type failingPrepareService struct {
+	*database.DatabaseService
+	delay    time.Duration
+	prepares atomic.Int64
+}
+
+// PrepareTransaction fails like an unreachable database after delay
+func (s *failingPrepareService) PrepareTransaction(ctx context.Context, req *pb.TransactionRequest) (*pb.PrepareResponse, error) {
+	s.prepares.Add(1)
+	time.Sleep(s.delay)
+	return nil, status.Error(codes.Unavailable, "prepare failed")
+}
+
+// TestCircuitBreakerProbe tests that the aborts following failed prepares do not reset the breaker of a failing
+// replica and that a half-open breaker lets a single probe through while concurrent transactions fail fast
+func TestCircuitBreakerProbe(t *testing.T) {
+	healthyAddr, _ := startTestDatabase(t, 100)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for test database: %v", err)
+	}
+	failing := &failingPrepareService{DatabaseService: newTestService(t, 100), delay: 200 * time.Millisecond}
+	grpcServer := grpc.NewServer(database.KeepaliveEnforcement(database.DefaultKeepaliveMinInterval))
+	pb.RegisterDatabaseServiceServer(grpcServer, failing)
+	go grpcServer.Serve(lis)
+	t.Cleanup(func() {
+		grpcServer.Stop()
+		failing.Stop()
+	})
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(
+		[]string{healthyAddr, lis.Addr().String()},
+		database.WithCircuitBreaker(2, 300*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	point := types.SensorData{SensorID: "probe-1", Timestamp: time.Now(), Value: 1, Unit: "test"}
+
+	//the replica answers the abort of every failed transaction with not found, which is no sign of health
+	for range 2 {
+		if err := tpcClient.AddDataPointWithTwoPhaseCommit(point); err == nil {
+			t.Fatalf("Expected the transaction to fail while prepares fail")
+		}
+	}
+	if state := tpcClient.Stats().Replicas[1].BreakerState; state != "open" {
+		t.Fatalf("Expected two failed prepares to open the breaker despite the aborts, got %s", state)
+	}
+
+	time.Sleep(350 * time.Millisecond)
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tpcClient.AddDataPointWithTwoPhaseCommit(point)
+		}()
+	}
+	wg.Wait()
+
+	if prepares := failing.prepares.Load(); prepares != 3 {
+		t.Errorf("Expected a single probe after the cooldown (3 prepares), got %d", prepares)
+	}
+	if state := tpcClient.Stats().Replicas[1].BreakerState; state != "open" {
+		t.Errorf("Expected the failed probe to reopen the breaker, got %s", state)
+	}
+}
+
+// TestTimestampPrecisionRoundTrip tests that a precise timestamp in a non-UTC zone survives a store/fetch cycle
+func TestTimestampPrecisionRoundTrip(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	timestamp := time.Date(2025, 6, 1, 12, 30, 45, 123456789, time.FixedZone("CEST", 2*60*60))
+	point := types.SensorData{SensorID: "precise-1", Timestamp: timestamp, Value: 1.0, Unit: "test"}
+
+	if err := tpcClient.AddDataPointWithTwoPhaseCommit(point); err != nil {
+		t.Fatalf("2PC transaction failed: %v", err)
+	}
+
+	stored, err := tpcClient.GetDataPointBySensorId("precise-1")
+	if err != nil {
+		t.Fatalf("Failed to fetch data: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("Expected 1 stored point, got %d", len(stored))
+	}
+
+	if !stored[0].Equal(point) {
+		t.Errorf("Expected the fetched point to equal the stored one, sent %+v, got %+v", point, stored[0])
+	}
+	if stored[0].Timestamp.Nanosecond() != 123456789 {
+		t.Errorf("Expected the nanoseconds to survive, got %d", stored[0].Timestamp.Nanosecond())
+	}
+	if stored[0].Timestamp.Location() != time.UTC {
+		t.Errorf("Expected fetched timestamps to be in UTC, got %s", stored[0].Timestamp.Location())
+	}
+}
+
+// TestReadDistribution tests that the read strategies spread reads over the replicas according to their weights
+// and skip a replica whose circuit breaker is open
+func TestReadDistribution(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	const reads = 2000
+
+	readCounts := func(tpcClient *database.TwoPhaseCommitClient) []int64 {
+		t.Helper()
+		for range reads {
+			if _, err := tpcClient.GetDataPointBySensorId("read-1"); err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+		}
+		stats := tpcClient.Stats()
+		return []int64{stats.Replicas[0].Reads, stats.Replicas[1].Reads}
+	}
+
+	//weighted: replica 2 should serve about three quarters of the reads
+	weighted, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2},
+		database.WithReadStrategy(database.ReadWeighted), database.WithReplicaWeights(1, 3))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer weighted.Close()
+
+	counts := readCounts(weighted)
+	if share := float64(counts[1]) / reads; share < 0.70 || share > 0.80 {
+		t.Errorf("Expected replica 2 to serve about 75%% of the reads, got %.1f%% (%v)", share*100, counts)
+	}
+
+	//round-robin: both replicas serve exactly half
+	roundRobin, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2},
+		database.WithReadStrategy(database.ReadRoundRobin))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer roundRobin.Close()
+
+	counts = readCounts(roundRobin)
+	if counts[0] != reads/2 || counts[1] != reads/2 {
+		t.Errorf("Expected round-robin to split the reads evenly, got %v", counts)
+	}
+
+	//a replica with an open breaker is skipped although it has the higher weight
+	deadListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve address: %v", err)
+	}
+	deadAddr := deadListener.Addr().String()
+	deadListener.Close()
+
+	skipping, err := database.TwoPhaseCommitClientFactory([]string{addr1, deadAddr},
+		database.WithReadStrategy(database.ReadWeighted), database.WithReplicaWeights(1, 100),
+		database.WithCircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer skipping.Close()
+
+	counts = readCounts(skipping)
+	if counts[0] != reads || counts[1] != 0 {
+		t.Errorf("Expected all reads on the live replica, got %v", counts)
+	}
+	if state := skipping.Stats().Replicas[1].BreakerState; state != "open" {
+		t.Errorf("Expected the dead replica's breaker to be open, got %s", state)
+	}
+}
+
+// TestDegradedWrites tests that with degraded writes enabled, writes continue on the surviving replica while the other
+// one is down and are replayed in order once it is back, while the default mode keeps rejecting them
+func TestDegradedWrites(t *testing.T) {
+	survivorAddr, _ := startTestDatabase(t, 100)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	downAddr := lis.Addr().String()
+	lis.Close()
+
+	strict, err := database.TwoPhaseCommitClientFactory([]string{survivorAddr, downAddr})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer strict.Close()
+
+	degraded, err := database.TwoPhaseCommitClientFactory(
+		[]string{survivorAddr, downAddr},
+		database.WithCircuitBreaker(1, 200*time.Millisecond),
+		database.WithDegradedWrites(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer degraded.Close()
+
+	if err := strict.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "degraded-strict", Timestamp: time.Now(), Value: 1, Unit: "test"}); err == nil {
+		t.Fatalf("Expected the default mode to reject writes while a replica is down")
+	}
+
+	//quorum lost: the writes are committed on the survivor and queued for the other replica
+	points := make([]types.SensorData, 3)
+	for i := range points {
+		points[i] = types.SensorData{SensorID: "degraded-1", Timestamp: time.Now(), Value: float64(i), Unit: "test"}
+		if err := degraded.AddDataPointWithTwoPhaseCommit(points[i]); err != nil {
+			t.Fatalf("Expected write %d to succeed in degraded mode: %v", i, err)
+		}
+	}
+
+	stats := degraded.Stats()
+	if !stats.Degraded || !degraded.Degraded() {
+		t.Errorf("Expected the coordinator to report degraded mode")
+	}
+	if pending := stats.Replicas[1].PendingWrites; pending != 3 {
+		t.Errorf("Expected 3 pending writes for the down replica, got %d", pending)
+	}
+
+	//a validation failure on a reachable replica still aborts instead of degrading
+	if err := degraded.AddDataPointWithTwoPhaseCommit(types.SensorData{Timestamp: time.Now()}); err == nil {
+		t.Errorf("Expected an invalid reading to be rejected in degraded mode")
+	}
+
+	//quorum returns: the reconciler replays the writes once the replica is reachable again
+	startTestDatabaseOn(t, downAddr, 100)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for degraded.Degraded() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Replica did not catch up, stats: %+v", degraded.Stats())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	recovered, err := database.ClientFactory(downAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect to the recovered replica: %v", err)
+	}
+	defer recovered.Close()
+
+	data, err := recovered.GetDataPointBySensorId("degraded-1")
+	if err != nil {
+		t.Fatalf("Failed to read the recovered replica: %v", err)
+	}
+	if len(data) != len(points) {
+		t.Fatalf("Expected %d replayed points, got %d", len(points), len(data))
+	}
+	for i := range points {
+		if !data[i].Equal(points[i]) {
+			t.Errorf("Replayed point %d out of order or changed: expected %+v, got %+v", i, points[i], data[i])
+		}
+	}
+
+	//back to normal: new writes reach both replicas again
+	if err := degraded.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "degraded-2", Timestamp: time.Now(), Value: 1, Unit: "test"}); err != nil {
+		t.Fatalf("Write after recovery failed: %v", err)
+	}
+	if data, err := recovered.GetDataPointBySensorId("degraded-2"); err != nil || len(data) != 1 {
+		t.Errorf("Expected the write after recovery on both replicas, got %d points (%v)", len(data), err)
+	}
+	if degraded.Degraded() {
+		t.Errorf("Expected the coordinator to leave degraded mode")
+	}
+}
+
+// TestReadYourWrites tests that a read session only reads from replicas that applied the session's write
+func TestReadYourWrites(t *testing.T) {
+	staleAddr, _ := startTestDatabase(t, 100)
+	freshAddr, _ := startTestDatabase(t, 100)
+	otherAddr, _ := startTestDatabase(t, 100)
+
+	//the writer does not know the stale replica, so only the reader's second replica gets the write
+	writer, err := database.TwoPhaseCommitClientFactory([]string{freshAddr, otherAddr})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer writer.Close()
+
+	reader, err := database.TwoPhaseCommitClientFactory([]string{staleAddr, freshAddr}, database.WithReadStrategy(database.ReadFirst))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer reader.Close()
+
+	sequence, err := writer.AddDataPointWithSequence(types.SensorData{SensorID: "session-1", Timestamp: time.Now(), Value: 1, Unit: "test"})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if sequence == 0 {
+		t.Fatalf("Expected a commit sequence for the write")
+	}
+
+	next, err := writer.AddDataPointWithSequence(types.SensorData{SensorID: "session-2", Timestamp: time.Now(), Value: 2, Unit: "test"})
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if next <= sequence {
+		t.Errorf("Expected increasing commit sequences, got %d after %d", next, sequence)
+	}
+
+	//without a session the first replica serves the read and misses the write
+	data, err := reader.GetDataPointBySensorId("session-1")
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("Expected the stale replica to serve the plain read, got %d points", len(data))
+	}
+
+	//with the session the stale replica is skipped
+	data, err = reader.ReadAtLeast(sequence).GetDataPointBySensorId("session-1")
+	if err != nil {
+		t.Fatalf("Session read failed: %v", err)
+	}
+	if len(data) != 1 {
+		t.Errorf("Expected the session read to see its write, got %d points", len(data))
+	}
+	if reads := reader.Stats().Replicas[1].Reads; reads != 1 {
+		t.Errorf("Expected the fresh replica to serve the session read, got %d reads", reads)
+	}
+
+	//a sequence no replica applied yet is reported instead of returning stale data
+	if _, err := reader.ReadAtLeast(next + 1).GetAllDataPoints(); !errors.Is(err, database.ErrSequenceNotApplied) {
+		t.Errorf("Expected ErrSequenceNotApplied, got %v", err)
+	}
+}
+
+// TestReconnectAfterRestart tests that the coordinator recovers from a database restart without operator intervention
+func TestReconnectAfterRestart(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _, stop := startStoppableTestDatabase(t, "127.0.0.1:0", 100)
+
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithReadyWait(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	reading := func(i int) types.SensorData {
+		return types.SensorData{SensorID: "restart-1", Timestamp: time.Now(), Value: float64(i), Unit: "test"}
+	}
+	if err := tpc.AddDataPointWithTwoPhaseCommit(reading(0)); err != nil {
+		t.Fatalf("Write before the restart failed: %v", err)
+	}
+
+	//a write while the database is down fails and leaves the connection in a failed state
+	stop()
+	if err := tpc.AddDataPointWithTwoPhaseCommit(reading(1)); err == nil {
+		t.Fatalf("Expected the write to fail while a database is down")
+	}
+	if state := tpc.Stats().Replicas[1].ConnectionState; state == "READY" {
+		t.Errorf("Expected the connection of the stopped database not to be ready")
+	}
+
+	//the restarted database is used by the next write, without waiting for the reconnect backoff
+	_, restarted := startTestDatabaseOn(t, addr2, 100)
+	start := time.Now()
+	if err := tpc.AddDataPointWithTwoPhaseCommit(reading(2)); err != nil {
+		t.Fatalf("Write after the restart failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the write to reconnect right away, took %v", elapsed)
+	}
+	if state := tpc.Stats().Replicas[1].ConnectionState; state != "READY" {
+		t.Errorf("Expected the connection to be ready again, got %s", state)
+	}
+
+	data, err := restarted.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "restart-1"})
+	if err != nil || len(data.Data) != 1 {
+		t.Errorf("Expected the write after the restart on the restarted database, got %v (%v)", data, err)
+	}
+}
+
+// TestConnectRetry tests that the 2PC client factory waits for a database that comes up late, and that it gives up
+// after the connect timeout or right away in fail-fast mode
+func TestConnectRetry(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	//reserve a free address for the late database
+	addr2, _, stop := startStoppableTestDatabase(t, "127.0.0.1:0", 100)
+	stop()
+
+	start := time.Now()
+	if _, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithConnectRetry(0, 0, 0)); err == nil {
+		t.Errorf("Expected fail-fast to fail while a database is down")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected fail-fast to give up right away, took %v", elapsed)
+	}
+
+	if _, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithConnectRetry(200*time.Millisecond, 50*time.Millisecond, 100*time.Millisecond)); err == nil {
+		t.Errorf("Expected the factory to fail once the connect timeout has passed")
+	}
+
+	type result struct {
+		tpc *database.TwoPhaseCommitClient
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithConnectRetry(5*time.Second, 50*time.Millisecond, 200*time.Millisecond))
+		done <- result{tpc, err}
+	}()
+
+	//the database comes up while the factory is retrying
+	time.Sleep(300 * time.Millisecond)
+	startTestDatabaseOn(t, addr2, 100)
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("Expected the factory to connect to the late database, got %v", res.err)
+	}
+	defer res.tpc.Close()
+
+	if err := res.tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "late-1", Timestamp: time.Now(), Value: 1, Unit: "test"}); err != nil {
+		t.Errorf("Write after connecting failed: %v", err)
+	}
+}
+
+// TestStoreSequence tests that every database numbers the writes it stored without gaps or repeats under concurrent 2PC
+// commits, in the order it stored them, and that both replicas agree on the numbers when writes arrive one by one
+func TestStoreSequence(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 1000)
+	addr2, _ := startTestDatabase(t, 1000)
+
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	replicaData := func(addr string) []types.SensorData {
+		t.Helper()
+		client, err := database.ClientFactory(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect to %s: %v", addr, err)
+		}
+		defer client.Close()
+		data, err := client.GetAllDataPoints()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", addr, err)
+		}
+		return data
+	}
+
+	//every writer stores single readings and bursts of 3, so a sequence covers all readings of one write
+	const writers, writesPerWriter = 8, 10
+	var wg sync.WaitGroup
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writesPerWriter {
+				sensorID := fmt.Sprintf("seq-%d-%d", w, i)
+				var err error
+				if i%2 == 0 {
+					err = tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: sensorID, Timestamp: base, Value: 1, Unit: "test"})
+				} else {
+					burst := make([]types.SensorData, 3)
+					for j := range burst {
+						burst[j] = types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(j) * time.Second), Value: 1, Unit: "test"}
+					}
+					err = tpc.AddDataPointsWithTwoPhaseCommit(burst)
+				}
+				if err != nil {
+					t.Errorf("Write %s failed: %v", sensorID, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, addr := range []string{addr1, addr2} {
+		data := replicaData(addr)
+		sensorOf := make(map[uint64]string)
+		var ingestedAt []time.Time
+		for _, point := range data {
+			if sensorID, ok := sensorOf[point.Sequence]; ok && sensorID != point.SensorID {
+				t.Errorf("%s: sequence %d shared by the writes of %s and %s", addr, point.Sequence, sensorID, point.SensorID)
+			}
+			if _, ok := sensorOf[point.Sequence]; !ok {
+				sensorOf[point.Sequence] = point.SensorID
+				ingestedAt = append(ingestedAt, point.IngestedAt)
+			}
+		}
+		for sequence := uint64(1); sequence <= writers*writesPerWriter; sequence++ {
+			if _, ok := sensorOf[sequence]; !ok {
+				t.Errorf("%s: sequence %d missing", addr, sequence)
+			}
+		}
+		if len(sensorOf) != writers*writesPerWriter {
+			t.Errorf("%s: expected %d sequences, got %d", addr, writers*writesPerWriter, len(sensorOf))
+		}
+
+		//storage order is insertion order, the sequences must follow it
+		for i := 1; i < len(data); i++ {
+			if data[i].Sequence < data[i-1].Sequence {
+				t.Errorf("%s: sequence %d stored after %d", addr, data[i].Sequence, data[i-1].Sequence)
+			}
+		}
+		for i := 1; i < len(ingestedAt); i++ {
+			if ingestedAt[i].Before(ingestedAt[i-1]) {
+				t.Errorf("%s: write %d ingested before write %d", addr, i+1, i)
+			}
+		}
+	}
+
+	//writes sent one after the other reach both replicas in the same order
+	for i := range 5 {
+		if err := tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: fmt.Sprintf("seq-ordered-%d", i), Timestamp: base, Value: 1, Unit: "test"}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	sequences := func(addr string) map[string]uint64 {
+		result := make(map[string]uint64)
+		for _, point := range replicaData(addr) {
+			if strings.HasPrefix(point.SensorID, "seq-ordered-") {
+				result[point.SensorID] = point.Sequence
+			}
+		}
+		return result
+	}
+	first, second := sequences(addr1), sequences(addr2)
+	if len(first) != 5 || !maps.Equal(first, second) {
+		t.Errorf("Expected both replicas to agree on the sequences, got %v and %v", first, second)
+	}
+	if first["seq-ordered-0"] != writers*writesPerWriter+1 || first["seq-ordered-4"] != writers*writesPerWriter+5 {
+		t.Errorf("Expected the sequences to continue after the concurrent writes, got %v", first)
+	}
+}
+
+// TestDuplicateDatabaseAddresses tests that the 2PC client factory rejects two addresses of the same database, also
+// when they are written differently
+func TestDuplicateDatabaseAddresses(t *testing.T) {
+	addr, _ := startTestDatabase(t, 100)
+	_, port, _ := net.SplitHostPort(addr)
+
+	duplicates := [][]string{
+		{addr, addr},
+		{"localhost:" + port, "127.0.0.1:" + port},
+		{"DB-HOST:50051", "db-host:50051"},
+		{"db-1:50051", "db-2:50051", "db-1:50051"},
+	}
+	for _, addresses := range duplicates {
+		if _, err := database.TwoPhaseCommitClientFactory(addresses); !errors.Is(err, database.ErrDuplicateAddress) {
+			t.Errorf("%v: expected ErrDuplicateAddress, got %v", addresses, err)
+		}
+	}
+
+	//different ports of one host are different databases
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	if err != nil {
+		t.Fatalf("Expected distinct addresses to be accepted, got %v", err)
+	}
+	tpc.Close()
+}
+
+// blackholeProxy forwards TCP connections to a target until cut: then it refuses new connections and silently drops
+// everything sent over the existing ones without closing them, like a database host that died without a FIN or RST
+type blackholeProxy struct {
+	listener net.Listener
+	target   string
+	cut      atomic.Bool
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+// startBlackholeProxy starts a proxy to target on a free local port, closed when the test ends
+func startBlackholeProxy(t *testing.T, target string) *blackholeProxy {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for proxy: %v", err)
+	}
+	proxy := &blackholeProxy{listener: listener, target: target}
+	t.Cleanup(proxy.close)
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			proxy.mu.Lock()
+			proxy.conns = append(proxy.conns, client, server)
+			proxy.mu.Unlock()
+
+			go proxy.forward(client, server)
+			go proxy.forward(server, client)
+		}
+	}()
+	return proxy
+}
+
+// forward copies from src to dst until the proxy is cut, afterwards it keeps reading but drops the data
+func (p *blackholeProxy) forward(src, dst net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		if !p.cut.Load() {
+			dst.Write(buf[:n])
+		}
+	}
+}
+
+// blackhole cuts the proxy
+func (p *blackholeProxy) blackhole() {
+	p.cut.Store(true)
+	p.listener.Close()
+}
+
+// close closes the listener and every forwarded connection
+func (p *blackholeProxy) close() {
+	p.listener.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// TestKeepaliveDetectsDeadDatabase tests that the keepalive pings notice a database that vanished behind a half-open
+// connection, so the next write fails right away instead of stalling until its timeout. gRPC pings at most every
+// 10s, so with a short ping timeout this test still takes a little over 10s
+func TestKeepaliveDetectsDeadDatabase(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+	proxy := startBlackholeProxy(t, addr2)
+
+	tpc, err := database.TwoPhaseCommitClientFactory(
+		[]string{addr1, proxy.listener.Addr().String()},
+		database.WithClientOptions(database.WithKeepalive(database.DefaultKeepaliveInterval, 200*time.Millisecond, true)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	if err := tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "keepalive-1", Timestamp: time.Now(), Value: 1, Unit: "test"}); err != nil {
+		t.Fatalf("Write before the database died failed: %v", err)
+	}
+
+	proxy.blackhole()
+	start := time.Now()
+
+	//the idle connection is pinged after the interval and dropped once the ping timed out
+	deadline := start.Add(database.DefaultKeepaliveInterval + 3*time.Second)
+	for tpc.Stats().Replicas[1].ConnectionState == "READY" {
+		if time.Now().After(deadline) {
+			t.Fatalf("The connection to the dead database was still READY after %v", time.Since(start))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Logf("Dead database detected after %v", time.Since(start))
+
+	writeStart := time.Now()
+	if err := tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "keepalive-1", Timestamp: time.Now(), Value: 2, Unit: "test"}); err == nil {
+		t.Errorf("Expected the write to fail while a database is dead")
+	}
+	if elapsed := time.Since(writeStart); elapsed > time.Second {
+		t.Errorf("Expected the write to fail right away on the dropped connection, took %v", elapsed)
+	}
+}
+
+// TestServiceSnapshotRestore tests that a database restored from a Snapshot holds exactly the saved points, including
+// the sensor index and the store sequence, and that Clear resets it to a new database
+func TestServiceSnapshotRestore(t *testing.T) {
+	addr, service := startTestDatabase(t, 1000)
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	defer client.Close()
+
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	write := func(sensorID string, offset int) {
+		t.Helper()
+		if err := client.AddDataPoint(types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(offset) * time.Second), Value: 1, Unit: "test"}); err != nil {
+			t.Fatalf("Write %s failed: %v", sensorID, err)
+		}
+	}
+
+	write("fixture-a", 0)
+	write("fixture-a", 1)
+	saved, err := service.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("Expected 2 points in the snapshot, got %d", len(saved))
+	}
+
+	write("fixture-b", 0)
+	if err := service.Restore(saved); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	restored, err := client.GetAllDataPoints()
+	if err != nil {
+		t.Fatalf("Failed to read the restored data: %v", err)
+	}
+	if len(restored) != len(saved) {
+		t.Fatalf("Expected %d restored points, got %d", len(saved), len(restored))
+	}
+	for i := range saved {
+		if !restored[i].Equal(saved[i]) || restored[i].Sequence != saved[i].Sequence {
+			t.Errorf("Point %d: expected %+v, got %+v", i, saved[i], restored[i])
+		}
+	}
+	if points, err := client.GetDataPointBySensorId("fixture-b"); err != nil || len(points) != 0 {
+		t.Errorf("Expected no points of a sensor written after the snapshot, got %d (%v)", len(points), err)
+	}
+
+	//the store sequence continues after the restored points, not after the discarded write
+	write("fixture-c", 0)
+	points, err := client.GetDataPointBySensorId("fixture-c")
+	if err != nil || len(points) != 1 {
+		t.Fatalf("Expected the point written after Restore, got %d (%v)", len(points), err)
+	}
+	if points[0].Sequence != 3 {
+		t.Errorf("Expected store sequence 3 after restoring 2 writes, got %d", points[0].Sequence)
+	}
+
+	if err := service.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if all, err := client.GetAllDataPoints(); err != nil || len(all) != 0 {
+		t.Errorf("Expected an empty database after Clear, got %d points (%v)", len(all), err)
+	}
+	write("fixture-a", 5)
+	points, err = client.GetDataPointBySensorId("fixture-a")
+	if err != nil || len(points) != 1 {
+		t.Fatalf("Expected only the point written after Clear, got %d (%v)", len(points), err)
+	}
+	if points[0].Sequence != 1 {
+		t.Errorf("Expected store sequence 1 after Clear, got %d", points[0].Sequence)
+	}
+}
+
+// slowPrepareService is a database whose prepares take delay, counting how many of them run at once
+type slowPrepareService struct {
+	*database.DatabaseService
+	delay   time.Duration
+	running atomic.Int64
+	peak    atomic.Int64
+}
+
+// PrepareTransaction records the number of prepares running at once and prepares after delay
+func (s *slowPrepareService) PrepareTransaction(ctx context.Context, req *pb.TransactionRequest) (*pb.PrepareResponse, error) {
+	running := s.running.Add(1)
+	defer s.running.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if running <= peak || s.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+
+	time.Sleep(s.delay)
+	return s.DatabaseService.PrepareTransaction(ctx, req)
+}
+
+// startSlowPrepareDatabase runs a slowPrepareService on a random local port
+func startSlowPrepareDatabase(t *testing.T, delay time.Duration) (string, *slowPrepareService) {
+	t.Helper()
+
+	service := &slowPrepareService{DatabaseService: newTestService(t, 1000), delay: delay}
+	return serveTestDatabase(t, service, service.Stop), service
+}
+
+// serveTestDatabase runs a wrapped database service on a random local port and calls stop when the test ends
+func serveTestDatabase(t *testing.T, service pb.DatabaseServiceServer, stop func()) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen for test database: %v", err)
+	}
+	grpcServer := grpc.NewServer(database.KeepaliveEnforcement(database.DefaultKeepaliveMinInterval))
+	pb.RegisterDatabaseServiceServer(grpcServer, service)
+	go grpcServer.Serve(lis)
+	t.Cleanup(func() {
+		grpcServer.Stop()
+		stop()
+	})
+
+	return lis.Addr().String()
+}
+
+// TestMaxConcurrentTransactions tests that a burst of writes never runs more 2PC transactions at once than the limit:
+// with the block policy every write waits for its turn and succeeds, with the reject policy the writes beyond the
+// limit fail at once with ErrTooBusy
+func TestMaxConcurrentTransactions(t *testing.T) {
+	const limit, burst = 3, 12
+
+	for _, policy := range []database.BusyPolicy{database.BusyBlock, database.BusyReject} {
+		slowAddr, slow := startSlowPrepareDatabase(t, 30*time.Millisecond)
+		addr, _ := startTestDatabase(t, 1000)
+
+		tpc, err := database.TwoPhaseCommitClientFactory([]string{slowAddr, addr}, database.WithMaxConcurrentTransactions(limit, policy))
+		if err != nil {
+			t.Fatalf("Failed to create 2PC client: %v", err)
+		}
+
+		//every transaction prepares on the slow database first, so its concurrent prepares are the running transactions
+		var wg sync.WaitGroup
+		var succeeded, rejected atomic.Int64
+		var inFlightPeak atomic.Int64
+		for i := range burst {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: fmt.Sprintf("busy-%s-%d", policy, i), Timestamp: time.Now(), Value: 1, Unit: "test"})
+				switch {
+				case err == nil:
+					succeeded.Add(1)
+				case errors.Is(err, database.ErrTooBusy):
+					rejected.Add(1)
+				default:
+					t.Errorf("%s: unexpected error: %v", policy, err)
+				}
+				inFlight := tpc.Stats().InFlight
+				for {
+					peak := inFlightPeak.Load()
+					if inFlight <= peak || inFlightPeak.CompareAndSwap(peak, inFlight) {
+						break
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if peak := slow.peak.Load(); peak > limit {
+			t.Errorf("%s: %d transactions ran at once, the limit is %d", policy, peak, limit)
+		}
+		if peak := inFlightPeak.Load(); peak > limit {
+			t.Errorf("%s: Stats reported %d transactions in flight, the limit is %d", policy, peak, limit)
+		}
+
+		stats := tpc.Stats()
+		if stats.InFlight != 0 || stats.MaxConcurrent != limit || stats.Rejected != rejected.Load() {
+			t.Errorf("%s: expected 0 in flight, limit %d and %d rejected in Stats, got %+v", policy, limit, rejected.Load(), stats)
+		}
+
+		switch policy {
+		case database.BusyBlock:
+			if succeeded.Load() != burst {
+				t.Errorf("block: expected all %d writes to succeed, got %d", burst, succeeded.Load())
+			}
+		case database.BusyReject:
+			if rejected.Load() == 0 || succeeded.Load() < limit {
+				t.Errorf("reject: expected some writes to be rejected and at least %d to succeed, got %d rejected and %d succeeded", limit, rejected.Load(), succeeded.Load())
+			}
+		}
+		tpc.Close()
+	}
+}
+
+// TestBlockedTransactionContext tests that a write waiting for a free transaction slot gives up when its context is done
+func TestBlockedTransactionContext(t *testing.T) {
+	slowAddr, _ := startSlowPrepareDatabase(t, 300*time.Millisecond)
+	addr, _ := startTestDatabase(t, 1000)
+
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{slowAddr, addr}, database.WithMaxConcurrentTransactions(1, database.BusyBlock))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "blocking-1", Timestamp: time.Now(), Value: 1, Unit: "test"})
+	}()
+	for tpc.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = tpc.AddDataPointWithSequenceContext(ctx, types.SensorData{SensorID: "blocked-1", Timestamp: time.Now(), Value: 1, Unit: "test"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the blocked write to fail with its context, got %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Expected the running write to succeed, got %v", err)
+	}
+}
+
+// TestBlockedTransactionWait tests that a write waiting for a free transaction slot is refused with ErrTooBusy once
+// the busy wait passed, while the running write still succeeds
+func TestBlockedTransactionWait(t *testing.T) {
+	slowAddr, _ := startSlowPrepareDatabase(t, 500*time.Millisecond)
+	addr, _ := startTestDatabase(t, 1000)
+
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{slowAddr, addr},
+		database.WithMaxConcurrentTransactions(1, database.BusyBlock), database.WithBusyWait(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "waiting-1", Timestamp: time.Now(), Value: 1, Unit: "test"})
+	}()
+	for tpc.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	err = tpc.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "waiting-2", Timestamp: time.Now(), Value: 1, Unit: "test"})
+	if !errors.Is(err, database.ErrTooBusy) {
+		t.Errorf("Expected the blocked write to fail with ErrTooBusy after the busy wait, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the blocked write to give up after 50ms, it waited %v", elapsed)
+	}
+	if rejected := tpc.Stats().Rejected; rejected != 1 {
+		t.Errorf("Expected 1 rejected transaction in Stats, got %d", rejected)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Expected the running write to succeed, got %v", err)
+	}
+}
+
+// replicaReads returns the number of reads the replicas of a 2PC client served
+func replicaReads(tpc *database.TwoPhaseCommitClient) int64 {
+	var reads int64
+	for _, replica := range tpc.Stats().Replicas {
+		reads += replica.Reads
+	}
+	return reads
+}
+
+// TestReadCache tests that repeated reads of a sensor are answered from the cache without asking a replica, that a
+// write through the coordinator invalidates the sensor at once and that a write bypassing it shows after the TTL
+func TestReadCache(t *testing.T) {
+	addr1, service1 := startTestDatabase(t, 1000)
+	addr2, service2 := startTestDatabase(t, 1000)
+
+	const ttl = 200 * time.Millisecond
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithReadCache(ttl, 10))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	start := time.Now().Add(-time.Hour)
+	reading := func(sensorID string, i int) types.SensorData {
+		return types.SensorData{SensorID: sensorID, Timestamp: start.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "test"}
+	}
+	read := func(sensorID string, expected int) {
+		t.Helper()
+		data, err := tpc.GetDataPointBySensorId(sensorID)
+		if err != nil {
+			t.Fatalf("Failed to read sensor %s: %v", sensorID, err)
+		}
+		if len(data) != expected {
+			t.Fatalf("Expected %d readings of sensor %s, got %d", expected, sensorID, len(data))
+		}
+	}
+
+	for _, sensorID := range []string{"cached-1", "cached-2"} {
+		if err := tpc.AddDataPointWithTwoPhaseCommit(reading(sensorID, 0)); err != nil {
+			t.Fatalf("Failed to write sensor %s: %v", sensorID, err)
+		}
+	}
+
+	read("cached-1", 1)
+	read("cached-2", 1)
+	if reads := replicaReads(tpc); reads != 2 {
+		t.Fatalf("Expected the first reads to reach the replicas, got %d replica reads", reads)
+	}
+
+	//a hit must not ask a replica
+	read("cached-1", 1)
+	read("cached-2", 1)
+	if stats := tpc.Stats(); replicaReads(tpc) != 2 || stats.CacheHits != 2 || stats.CacheMisses != 2 || stats.CacheEntries != 2 {
+		t.Fatalf("Expected 2 hits without replica reads, got %d replica reads and %+v", replicaReads(tpc), stats)
+	}
+
+	//a write invalidates only its own sensor
+	if err := tpc.AddDataPointWithTwoPhaseCommit(reading("cached-1", 1)); err != nil {
+		t.Fatalf("Failed to write sensor cached-1: %v", err)
+	}
+	read("cached-1", 2)
+	read("cached-2", 1)
+	if reads := replicaReads(tpc); reads != 3 {
+		t.Fatalf("Expected only the written sensor to be read again, got %d replica reads", reads)
+	}
+
+	//a write that bypasses the coordinator is seen once the entry expired
+	for _, service := range []*database.DatabaseService{service1, service2} {
+		if err := service.Restore([]types.SensorData{reading("cached-2", 0), reading("cached-2", 1)}); err != nil {
+			t.Fatalf("Failed to restore database: %v", err)
+		}
+	}
+	read("cached-2", 1)
+	time.Sleep(ttl)
+	read("cached-2", 2)
+
+	//deleting everything clears the cache
+	if _, err := tpc.DeleteAllWithTwoPhaseCommit(); err != nil {
+		t.Fatalf("Failed to delete all data: %v", err)
+	}
+	if entries := tpc.Stats().CacheEntries; entries != 0 {
+		t.Fatalf("Expected an empty cache after deleting all data, got %d entries", entries)
+	}
+	read("cached-2", 0)
+}
+
+// TestReadCacheEviction tests that a full cache drops the least recently read sensor
+func TestReadCacheEviction(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 1000)
+	addr2, _ := startTestDatabase(t, 1000)
+
+	tpc, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2}, database.WithReadCache(time.Minute, 2))
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpc.Close()
+
+	for _, sensorID := range []string{"evict-1", "evict-2", "evict-1", "evict-3"} {
+		if _, err := tpc.GetDataPointBySensorId(sensorID); err != nil {
+			t.Fatalf("Failed to read sensor %s: %v", sensorID, err)
+		}
+	}
+
+	//evict-2 was the least recently read when evict-3 came in
+	before := replicaReads(tpc)
+	for _, sensorID := range []string{"evict-1", "evict-3", "evict-2"} {
+		if _, err := tpc.GetDataPointBySensorId(sensorID); err != nil {
+			t.Fatalf("Failed to read sensor %s: %v", sensorID, err)
+		}
+	}
+	if reads := replicaReads(tpc) - before; reads != 1 {
+		t.Fatalf("Expected only the evicted sensor to be read again, got %d replica reads", reads)
+	}
+	if entries := tpc.Stats().CacheEntries; entries != 2 {
+		t.Fatalf("Expected the cache to hold 2 sensors, got %d", entries)
+	}
+}
+
+// TestPreparedTransactionLimit tests that a flood of prepares that are never committed is refused as overloaded
+// beyond the limit of the database instead of growing its prepared transactions without bound, and that a slot is
+// free again after an abort or once a transaction expired, without waiting for the cleanup
+func TestPreparedTransactionLimit(t *testing.T) {
+	const limit = 50
+	addr, _ := startTestDatabase(t, 1000, database.WithMaxPreparedTransactions(limit), database.WithPreparedTransactionTimeout(2*time.Second))
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	prepare := func(txnID string) (prepared, overloaded bool) {
+		t.Helper()
+		resp, err := client.PrepareTransaction(txnID, types.SensorData{SensorID: "flood-" + txnID, Timestamp: time.Now(), Value: 1, Unit: "test"})
+		if err != nil {
+			t.Errorf("Prepare %s failed: %v", txnID, err)
+			return false, false
+		}
+		if !resp.Success && !resp.Overloaded {
+			t.Errorf("Prepare %s got a no-vote that is not overloaded: %s", txnID, resp.Message)
+		}
+		return resp.Success, resp.Overloaded
+	}
+
+	var prepared, overloaded atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 4 * limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, full := prepare(fmt.Sprintf("flood-%d", i))
+			if ok {
+				prepared.Add(1)
+			}
+			if full {
+				overloaded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if prepared.Load() != limit || overloaded.Load() != 3*limit {
+		t.Fatalf("Expected %d prepared and %d overloaded transactions, got %d and %d", limit, 3*limit, prepared.Load(), overloaded.Load())
+	}
+
+	//an abort frees exactly one slot
+	var abortID string
+	for i := range 4 * limit {
+		if err := client.AbortTransaction(fmt.Sprintf("flood-%d", i)); err == nil {
+			abortID = fmt.Sprintf("flood-%d", i)
+			break
+		}
+	}
+	if abortID == "" {
+		t.Fatalf("Failed to abort any of the prepared transactions")
+	}
+	if ok, _ := prepare("after-abort"); !ok {
+		t.Errorf("Expected a prepare to succeed after an abort")
+	}
+	if _, full := prepare("after-abort-full"); !full {
+		t.Errorf("Expected the limit to be reached again after the freed slot was taken")
+	}
+
+	//expired transactions free their slots on the next prepare, long before the cleanup runs
+	time.Sleep(2200 * time.Millisecond)
+	for i := range limit {
+		if ok, _ := prepare(fmt.Sprintf("after-expiry-%d", i)); !ok {
+			t.Fatalf("Expected prepare %d to succeed after the transactions expired", i)
+		}
+	}
+	if _, full := prepare("after-expiry-full"); !full {
+		t.Errorf("Expected the limit to be enforced again after the expired transactions were replaced")
+	}
+}
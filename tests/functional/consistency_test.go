@@ -0,0 +1,83 @@
+package functional
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestConsistencyCheck tests that the consistency check accepts replicas written with 2PC and reports sensors that
+// were changed on one replica only
+func TestConsistencyCheck(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+	addresses := []string{addr1, addr2}
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(addresses)
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, sensorID := range []string{"cc-1", "cc-2", "cc-3", "other-1"} {
+		reading := types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "test"}
+		if err := tpcClient.AddDataPointWithTwoPhaseCommit(reading); err != nil {
+			t.Fatalf("2PC write failed: %v", err)
+		}
+	}
+
+	report, err := database.CheckConsistency(addresses, "")
+	if err != nil {
+		t.Fatalf("Consistency check failed: %v", err)
+	}
+	if !report.Consistent() || report.Sensors != 4 || report.Counts[0] != 4 || report.Counts[1] != 4 {
+		t.Fatalf("Expected 4 consistent sensors with 4 points each, got %s", report)
+	}
+
+	//cc-2 gets an extra point on the second replica and cc-3 the same number of points with another value
+	client2, err := database.ClientFactory(addr2)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client2.Close()
+	if err := client2.AddDataPoint(types.SensorData{SensorID: "cc-2", Timestamp: base, Value: 9, Unit: "test"}); err != nil {
+		t.Fatalf("Failed to add data point: %v", err)
+	}
+	if err := client2.UpdateDataPoint(types.SensorData{SensorID: "cc-3", Timestamp: base.Add(2 * time.Second), Value: 9, Unit: "test"}); err != nil {
+		t.Fatalf("Failed to update data point: %v", err)
+	}
+
+	report, err = database.CheckConsistency(addresses, "")
+	if err != nil {
+		t.Fatalf("Consistency check failed: %v", err)
+	}
+	if report.Consistent() || len(report.Diverging) != 2 {
+		t.Fatalf("Expected 2 diverging sensors, got %s", report)
+	}
+	if d := report.Diverging[0]; d.SensorID != "cc-2" || d.Counts[0] != 1 || d.Counts[1] != 2 {
+		t.Errorf("Expected cc-2 with 1 / 2 points, got %+v", d)
+	}
+	if d := report.Diverging[1]; d.SensorID != "cc-3" || d.Counts[0] != 1 || d.Counts[1] != 1 {
+		t.Errorf("Expected cc-3 with 1 / 1 points, got %+v", d)
+	}
+	if summary := report.String(); !strings.Contains(summary, "2 of 4 sensors diverge") || !strings.Contains(summary, "cc-2: 1 / 2 points") {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+
+	//the prefix query restricts the check to the matching sensors
+	report, err = database.CheckConsistency(addresses, "other-")
+	if err != nil {
+		t.Fatalf("Consistency check failed: %v", err)
+	}
+	if !report.Consistent() || report.Sensors != 1 {
+		t.Errorf("Expected other-1 to be consistent, got %s", report)
+	}
+
+	if _, err := database.CheckConsistency([]string{addr1, "localhost:1"}, ""); err == nil {
+		t.Errorf("Expected an error for an unreachable replica")
+	}
+}
@@ -0,0 +1,108 @@
+package functional
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// Test2PCDeleteAll tests that a 2PC delete-all empties both replicas and reports the removed points
+func Test2PCDeleteAll(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	for i := range 5 {
+		err = tpcClient.AddDataPointWithTwoPhaseCommit(types.SensorData{
+			SensorID:  fmt.Sprintf("delete-all-%d", i%2),
+			Timestamp: time.Now(),
+			Value:     float64(i),
+			Unit:      "test",
+		})
+		if err != nil {
+			t.Fatalf("2PC transaction failed: %v", err)
+		}
+	}
+
+	removed, err := tpcClient.DeleteAllWithTwoPhaseCommit()
+	if err != nil {
+		t.Fatalf("2PC delete all failed: %v", err)
+	}
+	if removed != 5 {
+		t.Errorf("Expected 5 removed points, got %d", removed)
+	}
+
+	for _, addr := range []string{addr1, addr2} {
+		client, err := database.ClientFactory(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect to %s: %v", addr, err)
+		}
+		defer client.Close()
+
+		data, err := client.GetAllDataPoints()
+		if err != nil {
+			t.Fatalf("Failed to get data from %s: %v", addr, err)
+		}
+		if len(data) != 0 {
+			t.Errorf("Expected %s to be empty, got %d points", addr, len(data))
+		}
+
+		//the sensor index must be cleared as well
+		data, err = client.GetDataPointsByPrefix("delete-all-")
+		if err != nil {
+			t.Fatalf("Prefix query failed on %s: %v", addr, err)
+		}
+		if len(data) != 0 {
+			t.Errorf("Expected no indexed sensors on %s, got %d points", addr, len(data))
+		}
+	}
+}
+
+// TestDeleteAllDropsPreparedTransactions tests that the direct delete-all also discards pending prepared writes
+func TestDeleteAllDropsPreparedTransactions(t *testing.T) {
+	addr, _ := startTestDatabase(t, 100)
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	point := types.SensorData{SensorID: "delete-all-direct", Timestamp: time.Now(), Value: 1.0, Unit: "test"}
+	if err := client.AddDataPoint(point); err != nil {
+		t.Fatalf("Failed to add data point: %v", err)
+	}
+
+	resp, err := client.PrepareTransaction("txn_pending", point)
+	if err != nil || !resp.Success {
+		t.Fatalf("Failed to prepare transaction: %v %v", err, resp)
+	}
+
+	removed, err := client.DeleteAllDataPoints()
+	if err != nil {
+		t.Fatalf("Delete all failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 removed point, got %d", removed)
+	}
+
+	if err := client.CommitTransaction("txn_pending"); err == nil {
+		t.Errorf("Expected commit of a dropped transaction to fail")
+	}
+
+	data, err := client.GetAllDataPoints()
+	if err != nil {
+		t.Fatalf("Failed to get data: %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected empty store, got %d points", len(data))
+	}
+}
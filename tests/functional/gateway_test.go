@@ -0,0 +1,927 @@
+package functional
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/gateway"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/sensor"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// fakeMQTTClient is an in-memory mqtt.Client that hands messages straight to the subscribed handler
+type fakeMQTTClient struct {
+	mu            sync.Mutex
+	handler       mqtt.MessageHandler
+	subscribed    string        //filter of the last subscribe
+	calls         []string      //Unsubscribe and Disconnect calls in order
+	unsubscribed  chan struct{} //closed on Unsubscribe
+	hangSubscribe bool          //never acknowledge a subscribe, like a broker that accepted the connection but stalls
+	publishErr    error         //error every publish fails with, nil acknowledges them
+	published     [][]byte      //payloads of the acknowledged publishes in order
+	topics        []string      //topics of the acknowledged publishes in order
+
+	//connect handler of the options passed to newClient, called on Connect
+	onConnect mqtt.OnConnectHandler
+}
+
+// newClient is an MQTT client factory returning the fake, which calls the connect handler of the options on Connect
+func (f *fakeMQTTClient) newClient(opts *mqtt.ClientOptions) mqtt.Client {
+	f.onConnect = opts.OnConnect
+	return f
+}
+
+func (f *fakeMQTTClient) IsConnected() bool      { return true }
+func (f *fakeMQTTClient) IsConnectionOpen() bool { return true }
+func (f *fakeMQTTClient) Connect() mqtt.Token {
+	if f.onConnect != nil {
+		go f.onConnect(f) //the broker connection calls the handler on a goroutine of its own
+	}
+	return &mqtt.DummyToken{}
+}
+func (f *fakeMQTTClient) Disconnect(quiesce uint) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "disconnect")
+}
+func (f *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if f.publishErr != nil {
+		return &failedToken{err: f.publishErr}
+	}
+	if data, ok := payload.([]byte); ok {
+		f.mu.Lock()
+		f.published = append(f.published, data)
+		f.topics = append(f.topics, topic)
+		f.mu.Unlock()
+	}
+	return &mqtt.DummyToken{}
+}
+func (f *fakeMQTTClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = callback
+	f.subscribed = topic
+	if f.hangSubscribe {
+		return &pendingToken{}
+	}
+	return &mqtt.DummyToken{}
+}
+func (f *fakeMQTTClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+func (f *fakeMQTTClient) Unsubscribe(topics ...string) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "unsubscribe")
+	close(f.unsubscribed)
+	return &mqtt.DummyToken{}
+}
+func (f *fakeMQTTClient) AddRoute(topic string, callback mqtt.MessageHandler) {}
+func (f *fakeMQTTClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.ClientOptionsReader{}
+}
+
+// deliver hands a message to the subscribed handler, like the broker connection would
+func (f *fakeMQTTClient) deliver(payload string) {
+	f.deliverTo("sensors/temperature/temp-1", payload)
+}
+
+// deliverTo hands a message published on the given topic to the subscribed handler
+func (f *fakeMQTTClient) deliverTo(topic, payload string) {
+	f.mu.Lock()
+	handler := f.handler
+	f.mu.Unlock()
+	handler(f, &fakeMessage{topic: topic, payload: []byte(payload)})
+}
+
+// pendingToken is an mqtt.Token that never completes
+type pendingToken struct{}
+
+func (p *pendingToken) Wait() bool { select {} }
+func (p *pendingToken) WaitTimeout(d time.Duration) bool {
+	time.Sleep(d)
+	return false
+}
+func (p *pendingToken) Done() <-chan struct{} { return nil }
+func (p *pendingToken) Error() error          { return nil }
+
+// failedToken is an mqtt.Token that completed with an error
+type failedToken struct {
+	err error
+}
+
+func (f *failedToken) Wait() bool                       { return true }
+func (f *failedToken) WaitTimeout(d time.Duration) bool { return true }
+func (f *failedToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (f *failedToken) Error() error { return f.err }
+
+// fakeMessage is a minimal mqtt.Message
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 0 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              {}
+
+// TestGatewayGracefulStop tests that Stop unsubscribes first, drains in-flight forwards and drops messages arriving afterwards
+func TestGatewayGracefulStop(t *testing.T) {
+	var received atomic.Int32
+	arrived := make(chan struct{}, 8)
+	release := make(chan struct{})
+	server := http.ServerFactory("127.0.0.1", 8092)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		received.Add(1)
+		arrived <- struct{}{}
+		<-release //keep the forwards in flight while Stop runs
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8092", readyTimeout)
+
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw := gateway.GatewayFactory("http://127.0.0.1:8092", "unused:1883")
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+
+	payload := `{"sensorId":"temp-1","value":21.5,"unit":"°C"}`
+	for range 3 {
+		fake.deliver(payload)
+	}
+
+	//wait until all three forwards reached the server
+	for i := range 3 {
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Forwards did not reach the server, got %d", i)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		gw.Stop()
+		close(stopped)
+	}()
+
+	//messages still queued in the client after Stop began must not be forwarded
+	<-fake.unsubscribed
+	<-gw.StopChan
+	for range 5 {
+		fake.deliver(payload)
+	}
+
+	//Stop waits for the forwards in flight
+	select {
+	case <-stopped:
+		t.Fatalf("Stop returned before the in-flight forwards completed")
+	default:
+	}
+	close(release)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not return")
+	}
+
+	if got := received.Load(); got != 3 {
+		t.Errorf("Expected no forwards after Stop began (3 in total), got %d", got)
+	}
+	if got := gw.GetMessageCount(); got != 3 {
+		t.Errorf("Expected the 3 in-flight forwards to be drained, got %d", got)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.calls) != 2 || fake.calls[0] != "unsubscribe" || fake.calls[1] != "disconnect" {
+		t.Errorf("Expected unsubscribe before disconnect, got %v", fake.calls)
+	}
+}
+
+// TestGatewaySubscribeTimeout tests that Start fails instead of hanging when the broker never acknowledges the subscribe
+func TestGatewaySubscribeTimeout(t *testing.T) {
+	gw := gateway.GatewayFactory("http://127.0.0.1:1", "unused:1883")
+	gw.NewMQTTClient = (&fakeMQTTClient{hangSubscribe: true}).newClient
+	gw.MQTTTimeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() { done <- gw.Start() }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "subscribe") {
+			t.Errorf("Expected Start to fail when the subscribe is never acknowledged, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start still blocked long after the MQTT timeout")
+	}
+}
+
+// TestGatewayConfigServerAddress tests that -server-host and -server-port replace only their part of the server URL
+func TestGatewayConfigServerAddress(t *testing.T) {
+	tests := []struct {
+		serverURL  string
+		host, port string
+		expected   string
+	}{
+		{"http://server.example:9000/api", "", "8081", "http://server.example:8081/api"},
+		{"http://server.example:9000/api", "other.example", "", "http://other.example:9000/api"},
+		{"http://server.example", "", "8081", "http://server.example:8081"},
+		{"http://[::1]:9000", "", "8081", "http://[::1]:8081"},
+		{"http://localhost:9000", "::1", "", "http://[::1]:9000"},
+	}
+
+	for _, tt := range tests {
+		config := gateway.DefaultConfig()
+		config.ServerURL = tt.serverURL
+		config.ServerSocket = "/tmp/server.sock"
+		if err := config.SetServerAddress(tt.host, tt.port); err != nil {
+			t.Errorf("SetServerAddress(%q, %q) on %s failed: %v", tt.host, tt.port, tt.serverURL, err)
+			continue
+		}
+		if config.ServerURL != tt.expected || config.ServerSocket != "" {
+			t.Errorf("SetServerAddress(%q, %q) on %s: expected %s without socket, got %s and socket %q", tt.host, tt.port, tt.serverURL, tt.expected, config.ServerURL, config.ServerSocket)
+		}
+	}
+}
+
+// TestGatewayConfig tests loading, validating and applying a gateway config file
+func TestGatewayConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(content string) string {
+		t.Helper()
+		path := filepath.Join(dir, "gateway.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	config, err := gateway.LoadConfig(writeConfig("serverUrl: http://server:9090\nmqttBrokers: [broker-1:1883, broker-2:1883]\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.MQTTTimeout != gateway.DefaultMQTTTimeout {
+		t.Errorf("Expected settings missing from the file to keep their default, got mqttTimeout %v", config.MQTTTimeout)
+	}
+
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway from config: %v", err)
+	}
+	if gw.ServerURL != "http://server:9090" || gw.MQTTBrokerURL != "broker-1:1883" ||
+		len(gw.BackupBrokerURLs) != 1 || gw.BackupBrokerURLs[0] != "broker-2:1883" {
+		t.Errorf("Gateway does not match the config: %+v", gw)
+	}
+
+	config, err = gateway.LoadConfig(writeConfig("serverSocket: /tmp/iot.sock\nmqttTimeout: 250ms\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if gw, err := gateway.ConfigGatewayFactory(config); err != nil || gw.ServerURL != http.UnixSocketURL("/tmp/iot.sock") || gw.MQTTTimeout != 250*time.Millisecond {
+		t.Errorf("Expected the socket and timeout from the config, got %+v (%v)", gw, err)
+	}
+
+	//a typo must not silently fall back to the default
+	if _, err := gateway.LoadConfig(writeConfig("mqttBroker: [broker:1883]\n")); err == nil {
+		t.Errorf("Expected an unknown setting to be rejected")
+	}
+
+	invalid := []gateway.Config{
+		{ServerURL: "server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second},
+		{ServerURL: "http://server:8080", MQTTTimeout: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker"}, MQTTTimeout: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, Priorities: map[string]int{"pressure": 1}},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, ForwardQueueSize: -1},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, DeadLetterFile: "dead.jsonl"},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, BatchMin: 1, BatchMax: 10, BatchLatency: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, ForwardQueueSize: 10, ForwardWorkers: 1, BatchMin: 20, BatchMax: 10, BatchLatency: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"tls://broker:8883"}, MQTTTimeout: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"ws://broker:1883"}, MQTTTimeout: time.Second},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, MQTTPassword: "secret"},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"broker:1883"}, MQTTTimeout: time.Second, MQTTCAFile: "ca.pem"},
+		{ServerURL: "http://server:8080", MQTTBrokers: []string{"ssl://broker:8883"}, MQTTTimeout: time.Second, MQTTCAFile: "ca.pem", MQTTCertFile: "client.pem"},
+	}
+	for _, config := range invalid {
+		if _, err := gateway.ConfigGatewayFactory(config); err == nil {
+			t.Errorf("Expected config %+v to be rejected", config)
+		}
+	}
+}
+
+// TestGatewayPriorityQueue tests that a full forward queue forwards the highest priority tier first and drops the
+// lowest tier first
+func TestGatewayPriorityQueue(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded []string
+	release := make(chan struct{})
+	server := http.ServerFactory("127.0.0.1", 8104)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		var reading struct {
+			SensorID string `json:"sensorId"`
+		}
+		json.Unmarshal(req.Body, &reading)
+		mu.Lock()
+		forwarded = append(forwarded, reading.SensorID)
+		mu.Unlock()
+
+		<-release //the first forward blocks the only worker until the queue is filled
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8104", readyTimeout)
+
+	config, err := gateway.LoadConfig(writeGatewayConfig(t, "serverUrl: http://127.0.0.1:8104\n"+
+		"forwardQueueSize: 3\nforwardWorkers: 1\npriorities: {pressure: 10, light: -5}\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+
+	deliver := func(sensorType, sensorID string) {
+		fake.deliverTo("sensors/"+sensorType+"/"+sensorID, fmt.Sprintf(`{"sensorId":%q,"value":1,"unit":"test"}`, sensorID))
+	}
+
+	deliver("temperature", "temp-1")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		started := len(forwarded) == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("First forward did not reach the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//the queue fills up with light readings, which then make room for more critical ones
+	deliver("light", "light-1")
+	deliver("light", "light-2")
+	deliver("light", "light-3")
+	deliver("pressure", "pressure-1")
+	deliver("pressure", "pressure-2")
+	deliver("temperature", "temp-2")
+	deliver("light", "light-4") //lower than everything queued, dropped itself
+
+	if dropped := gw.GetDroppedCount(); dropped != 4 {
+		t.Errorf("Expected 4 dropped light readings, got %d", dropped)
+	}
+
+	close(release)
+	deadline = time.Now().Add(5 * time.Second)
+	for gw.GetMessageCount() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Queued forwards did not complete, got %d", gw.GetMessageCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gw.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"temp-1", "pressure-1", "pressure-2", "temp-2"}
+	if fmt.Sprint(forwarded) != fmt.Sprint(expected) {
+		t.Errorf("Expected forwards in priority order %v, got %v", expected, forwarded)
+	}
+}
+
+func TestGatewayConcurrentForwards(t *testing.T) {
+	const workers = 4
+	const rounds = 2
+
+	//every forward waits until all workers have one at the server, which only happens if they do not wait for each
+	//other's connections
+	var mu sync.Mutex
+	arrived, inFlight, maxInFlight, stalled := 0, 0, 0, 0
+	barriers := make([]chan struct{}, rounds+1)
+	for i := range barriers {
+		barriers[i] = make(chan struct{})
+	}
+	server := http.ServerFactory("127.0.0.1", 8128)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		mu.Lock()
+		barrier := barriers[min(arrived/workers, rounds)]
+		arrived++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		if arrived%workers == 0 {
+			close(barrier)
+		}
+		mu.Unlock()
+
+		select {
+		case <-barrier:
+		case <-time.After(2 * time.Second):
+			mu.Lock()
+			stalled++
+			mu.Unlock()
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8128", readyTimeout)
+
+	config, err := gateway.LoadConfig(writeGatewayConfig(t, fmt.Sprintf("serverUrl: http://127.0.0.1:8128\n"+
+		"forwardQueueSize: 100\nforwardWorkers: %d\n", workers)))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+	defer gw.Stop()
+
+	var acceptedFirstRound int64
+	for round := range rounds {
+		for i := range workers {
+			sensorID := fmt.Sprintf("temp-%d-%d", round, i)
+			fake.deliverTo("sensors/temperature/"+sensorID, fmt.Sprintf(`{"sensorId":%q,"value":1,"unit":"test"}`, sensorID))
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for gw.GetMessageCount() < int64((round+1)*workers) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Round %d: forwards did not complete, got %d", round, gw.GetMessageCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if round == 0 {
+			acceptedFirstRound = server.ConnStats().Accepted
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stalled > 0 || maxInFlight != workers {
+		t.Errorf("Expected %d forwards at the server at once, got at most %d (%d waited for the others in vain)", workers, maxInFlight, stalled)
+	}
+
+	//the later rounds reuse the connections of the first
+	if accepted := server.ConnStats().Accepted; accepted != acceptedFirstRound {
+		t.Errorf("Expected the workers to keep their connections, the server accepted %d new ones", accepted-acceptedFirstRound)
+	}
+}
+
+// writeGatewayConfig writes a gateway config file into a temporary directory and returns its path
+func writeGatewayConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestGatewayDeadLetters tests that readings the server rejects for good end up in the dead-letter file with the
+// reason, that those of a failed commit are marked as possibly stored, and that the file is rotated once it reaches its
+// maximum size
+func TestGatewayDeadLetters(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8111)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		if strings.Contains(string(req.Body), "bad-") {
+			return http.CreateErrorResponse(http.StatusBadRequest, "invalid_sensor_data", "rejected")
+		}
+		if strings.Contains(string(req.Body), "partial-") {
+			return http.CreateErrorResponse(http.StatusServerError, "storage_failed", "only 1 of 2 databases committed")
+		}
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8111", readyTimeout)
+
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	config, err := gateway.LoadConfig(writeGatewayConfig(t, "serverUrl: http://127.0.0.1:8111\n"+
+		"deadLetterFile: "+path+"\ndeadLetterMaxSize: 1000\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+
+	waitForDeadLetters := func(count int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for gw.GetDeadLetteredCount() < count {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d dead letters, got %d", count, gw.GetDeadLetteredCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	fake.deliver(`{"sensorId":"good-1","value":1,"unit":"test"}`)
+	fake.deliver(`[{"sensorId":"bad-1","value":1,"unit":"test"},{"sensorId":"bad-1","value":2,"unit":"test"}]`)
+	waitForDeadLetters(2)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per rejected reading, got %q", data)
+	}
+	var letter gateway.DeadLetter
+	if err := json.Unmarshal([]byte(lines[1]), &letter); err != nil {
+		t.Fatalf("Failed to decode dead letter %q: %v", lines[1], err)
+	}
+	if letter.Reading.SensorID != "bad-1" || letter.Reading.Value != 2 || letter.Topic != "sensors/temperature/temp-1" ||
+		!strings.Contains(letter.Reason, "400") || letter.Time.IsZero() {
+		t.Errorf("Dead letter does not describe the rejected reading: %+v", letter)
+	}
+	if letter.PossiblyStored {
+		t.Errorf("Expected a rejected reading not to be marked as possibly stored: %+v", letter)
+	}
+
+	//a 500 may come from a commit that reached some databases, so its readings are marked as possibly stored
+	fake.deliver(`{"sensorId":"partial-1","value":1,"unit":"test"}`)
+	waitForDeadLetters(3)
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &letter); err != nil {
+		t.Fatalf("Failed to decode dead letter %q: %v", lines[len(lines)-1], err)
+	}
+	if letter.Reading.SensorID != "partial-1" || !letter.PossiblyStored {
+		t.Errorf("Expected the reading of the failed commit to be marked as possibly stored: %+v", letter)
+	}
+
+	//every line is about 200 bytes, so a few more rejections exceed the 1000 bytes and rotate the file
+	for i := range 5 {
+		fake.deliver(fmt.Sprintf(`{"sensorId":"bad-%d","value":1,"unit":"test"}`, i+2))
+	}
+	waitForDeadLetters(8)
+	gw.Stop()
+
+	rotated, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Expected the full dead-letter file to be rotated: %v", err)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read dead-letter file: %v", err)
+	}
+	if len(rotated) > 1000 || len(current) > 1000 {
+		t.Errorf("Expected both files to stay within the maximum size, got %d and %d bytes", len(rotated), len(current))
+	}
+	if total := strings.Count(string(rotated), "\n") + strings.Count(string(current), "\n"); total != 8 {
+		t.Errorf("Expected all 8 dead letters across both files, got %d", total)
+	}
+	if gw.GetMessageCount() != 1 {
+		t.Errorf("Expected only the good reading to be forwarded, got %d", gw.GetMessageCount())
+	}
+}
+
+// TestGatewayErrors tests that malformed messages and failed forwards are reported on the errors channel, and that a
+// full channel drops further failures instead of blocking the message handler
+func TestGatewayErrors(t *testing.T) {
+	//nothing listens on port 1, so every forward fails
+	gw := gateway.GatewayFactory("http://127.0.0.1:1", "unused:1883")
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+	defer gw.Stop()
+
+	nextError := func() error {
+		t.Helper()
+		select {
+		case err := <-gw.Errors():
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No error reported")
+			return nil
+		}
+	}
+
+	fake.deliver(`{"sensorId":`)
+	if err := nextError(); !errors.Is(err, gateway.ErrMalformedMessage) || !strings.Contains(err.Error(), "sensors/temperature/temp-1") {
+		t.Errorf("Expected a malformed message error naming the topic, got %v", err)
+	}
+
+	fake.deliver(`{"sensorId":"temp-1","value":21.5,"unit":"°C"}`)
+	if err := nextError(); !errors.Is(err, gateway.ErrForwardFailed) || !strings.Contains(err.Error(), "temp-1") {
+		t.Errorf("Expected a forward error naming the sensor, got %v", err)
+	}
+
+	//nobody drains the channel now; the handler runs synchronously, so a blocking send would hang here
+	delivered := make(chan struct{})
+	go func() {
+		for range gateway.DefaultErrorBufferSize + 10 {
+			fake.deliver(`not json`)
+		}
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Message handler blocked on a full errors channel")
+	}
+	if got := len(gw.Errors()); got != gateway.DefaultErrorBufferSize {
+		t.Errorf("Expected a full channel of %d errors, got %d", gateway.DefaultErrorBufferSize, got)
+	}
+}
+
+// TestGatewayAdaptiveBatch tests that queued messages are forwarded in batches that grow while the server answers
+// quickly and shrink back to the minimum once it slows down or answers 503, and that a rejected batch is forwarded
+// again message by message
+func TestGatewayAdaptiveBatch(t *testing.T) {
+	var requests atomic.Int32
+	var slow atomic.Bool
+	server := http.ServerFactory("127.0.0.1", 8117)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		count := requests.Add(1)
+		if strings.Contains(string(req.Body), "bad-") {
+			return http.CreateErrorResponse(http.StatusBadRequest, "invalid_sensor_data", "rejected")
+		}
+		if strings.Contains(string(req.Body), "partial-") {
+			return http.CreateErrorResponse(http.StatusServerError, "storage_failed", "only 1 of 2 databases committed")
+		}
+		if slow.Load() {
+			if count%2 == 0 {
+				return http.CreateErrorResponse(http.StatusServiceUnavailable, "overloaded", "busy")
+			}
+			time.Sleep(80 * time.Millisecond)
+		}
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8117", readyTimeout)
+
+	config, err := gateway.LoadConfig(writeGatewayConfig(t, "serverUrl: http://127.0.0.1:8117\n"+
+		"forwardQueueSize: 1000\nforwardWorkers: 1\nbatchMin: 1\nbatchMax: 32\nbatchLatency: 50ms\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+	defer gw.Stop()
+
+	if size := gw.GetBatchSize(); size != 1 {
+		t.Fatalf("Expected batches to start at the minimum of 1, got %d", size)
+	}
+
+	//a fast server: the batch grows, and the batch holding the one bad reading is split up
+	for i := range 200 {
+		sensorID := fmt.Sprintf("temp-%d", i)
+		if i == 100 {
+			sensorID = "bad-1"
+		}
+		fake.deliver(fmt.Sprintf(`{"sensorId":%q,"value":1,"unit":"test"}`, sensorID))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for gw.GetMessageCount() < 199 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all good messages forwarded, got %d", gw.GetMessageCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size := gw.GetBatchSize(); size < 8 {
+		t.Errorf("Expected the batch to grow while the server is fast, got %d", size)
+	}
+	if got := requests.Load(); got >= 100 {
+		t.Errorf("Expected far fewer requests than messages, got %d", got)
+	}
+
+	//a slow server that also answers 503: every forward halves the batch until it is back at the minimum
+	slow.Store(true)
+	deadline = time.Now().Add(10 * time.Second)
+	for gw.GetBatchSize() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the batch to shrink to 1, got %d", gw.GetBatchSize())
+		}
+		sent := requests.Load()
+		fake.deliver(`{"sensorId":"temp-slow","value":1,"unit":"test"}`)
+		for requests.Load() == sent && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond) //let the gateway see the answer
+	}
+}
+
+// TestMQTTTopicTemplate tests rendering and parsing a custom topic template round-trip, the compatibility check of
+// subscription filters, and that the sensors publish to and the gateway subscribes to the configured topic
+func TestMQTTTopicTemplate(t *testing.T) {
+	topic, err := mqttconfig.ParseTopicTemplate("site/{type}/{id}/telemetry")
+	if err != nil {
+		t.Fatalf("Failed to parse topic template: %v", err)
+	}
+	rendered, err := topic.Render("temperature", "temp-1")
+	if err != nil || rendered != "site/temperature/temp-1/telemetry" {
+		t.Fatalf("Expected site/temperature/temp-1/telemetry, got %q (%v)", rendered, err)
+	}
+	if sensorType, sensorID, ok := topic.Parse(rendered); !ok || sensorType != "temperature" || sensorID != "temp-1" {
+		t.Errorf("Expected temperature and temp-1 parsed back from %s, got %q, %q, %v", rendered, sensorType, sensorID, ok)
+	}
+	if filter := topic.Filter(); filter != "site/+/+/telemetry" {
+		t.Errorf("Expected the filter site/+/+/telemetry, got %s", filter)
+	}
+	for _, other := range []string{"sensors/temperature/temp-1", "site/temperature/temp-1/status", "site/temperature/temp-1/telemetry/raw", "site//temp-1/telemetry"} {
+		if _, _, ok := topic.Parse(other); ok {
+			t.Errorf("Expected %s not to fit the template", other)
+		}
+	}
+	if _, err := topic.Render("temperature", "temp/1"); err == nil {
+		t.Errorf("Expected an ID containing / to be rejected, it could not be parsed back")
+	}
+
+	//the zero value is the topic scheme used before templates existed
+	var defaultTopic mqttconfig.TopicTemplate
+	if rendered, _ := defaultTopic.Render("light", "light-2"); rendered != "sensors/light/light-2" || defaultTopic.Filter() != gateway.SensorTopic {
+		t.Errorf("Expected the zero template to be %s, got %s with filter %s", mqttconfig.DefaultTopicTemplate, rendered, defaultTopic.Filter())
+	}
+
+	for _, template := range []string{"", "site/{type}/{type}", "site/{kind}/{id}", "site/t-{type}/{id}", "site/+/{id}", "site/#"} {
+		if _, err := mqttconfig.ParseTopicTemplate(template); err == nil {
+			t.Errorf("Expected topic template %q to be rejected", template)
+		}
+	}
+
+	filters := map[string]bool{
+		"site/+/+/telemetry":           true,
+		"site/+/+/+":                   true,
+		"site/#":                       true,
+		"#":                            true,
+		"site/temperature/+/telemetry": false, //misses every other type
+		"sensors/+/+":                  false,
+		"site/+/+":                     false,
+		"site/+/+/telemetry/+":         false,
+		"site/#/telemetry":             false,
+		"site/+/t+/telemetry":          false,
+		"":                             false,
+	}
+	for filter, compatible := range filters {
+		if err := topic.CheckFilter(filter); (err == nil) != compatible {
+			t.Errorf("Filter %q: expected compatible %v, got %v", filter, compatible, err)
+		}
+	}
+
+	//the sensors publish to the template
+	client := &fakeMQTTClient{}
+	manager := sensor.NewSensorManager("unused:1883", 1, 0, 1, 0, 1, false)
+	manager.Sensors = []types.Sensor{{ID: "temperature", Name: "Temperature Sensor", MinValue: 0, MaxValue: 100, Unit: "test", DataGenerationInterval: 10}}
+	manager.Topic = topic
+	manager.NewMQTTClient = func(*mqtt.ClientOptions) mqtt.Client { return client }
+	if err := manager.Start(); err != nil {
+		t.Fatalf("Failed to start sensor manager: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	manager.Stop()
+	client.mu.Lock()
+	published := slices.Clone(client.topics)
+	client.mu.Unlock()
+	if len(published) == 0 {
+		t.Fatalf("Expected published readings")
+	}
+	for _, topic := range published {
+		if topic != "site/temperature/temperature-1/telemetry" {
+			t.Errorf("Expected readings published to site/temperature/temperature-1/telemetry, got %s", topic)
+		}
+	}
+
+	//the gateway subscribes to the filter derived from the template and forwards what the sensors publish
+	var forwarded atomic.Int64
+	server := http.ServerFactory("127.0.0.1", 8124)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		forwarded.Add(1)
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	waitForTCP(t, "127.0.0.1:8124", readyTimeout)
+
+	config, err := gateway.LoadConfig(writeGatewayConfig(t, "serverUrl: http://127.0.0.1:8124\ntopicTemplate: site/{type}/{id}/telemetry\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gw, err := gateway.ConfigGatewayFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create gateway: %v", err)
+	}
+	fake := &fakeMQTTClient{unsubscribed: make(chan struct{})}
+	gw.NewMQTTClient = fake.newClient
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway: %v", err)
+	}
+	fake.mu.Lock()
+	subscribed := fake.subscribed
+	fake.mu.Unlock()
+	if subscribed != "site/+/+/telemetry" {
+		t.Errorf("Expected the gateway to subscribe to site/+/+/telemetry, got %s", subscribed)
+	}
+	fake.deliverTo(published[0], `{"sensorId":"temperature-1","value":21.5,"unit":"test"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for forwarded.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Reading published to %s was not forwarded", published[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gw.Stop()
+
+	//a filter that misses topics of the template is rejected before subscribing
+	config.TopicFilter = "sensors/+/+"
+	if _, err := gateway.ConfigGatewayFactory(config); err == nil {
+		t.Errorf("Expected a config with an incompatible filter to be rejected")
+	}
+	gw = gateway.GatewayFactory("http://127.0.0.1:8124", "unused:1883")
+	gw.Topic = topic
+	gw.TopicFilter = "site/temperature/+/telemetry"
+	gw.NewMQTTClient = (&fakeMQTTClient{unsubscribed: make(chan struct{})}).newClient
+	if err := gw.Start(); err == nil {
+		gw.Stop()
+		t.Errorf("Expected Start to fail with an incompatible filter")
+	}
+}
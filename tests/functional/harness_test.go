@@ -0,0 +1,219 @@
+package functional
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
+)
+
+// readyTimeout is how long the tests wait for a server they started to accept connections
+const readyTimeout = 5 * time.Second
+
+// externalDatabaseAddresses are the databases some tests expect to be started outside the test process, e.g. with
+// make start-dual-db; DB_ADDRS replaces them, e.g. to run the 2PC tests against more replicas
+var externalDatabaseAddresses = databaseAddressesFromEnv()
+
+// databaseAddressesFromEnv returns the comma separated addresses in DB_ADDRS, or the default addresses of the server
+// if it is not set
+func databaseAddressesFromEnv() []string {
+	var addresses []string
+	for _, address := range strings.Split(os.Getenv("DB_ADDRS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return server.DefaultConfig().DatabaseAddresses
+	}
+	return addresses
+}
+
+// externalDatabases is set by TestMain if all external databases accept connections
+var externalDatabases bool
+
+// externalSkipReason tells why the tests that need the external databases are skipped
+var externalSkipReason string
+
+// TestMain checks once whether the external databases are running, so the tests that need them can be skipped with
+// a clear message instead of failing on connection errors. Fewer than two addresses in DB_ADDRS only skip those
+// tests, since 2PC needs two replicas; all other tests start their databases in process
+func TestMain(m *testing.M) {
+	externalDatabases, externalSkipReason = checkExternalDatabases()
+	if !externalDatabases {
+		log.Printf("%s, the tests that need them are skipped", externalSkipReason)
+	}
+	if externalDatabases && os.Getenv("CLEAR_EXTERNAL_DBS") == "1" {
+		clearExternalDatabases()
+	}
+	os.Exit(m.Run())
+}
+
+// checkExternalDatabases reports whether there are at least two external databases and all of them accept
+// connections, and if not, why
+func checkExternalDatabases() (bool, string) {
+	if len(externalDatabaseAddresses) < 2 {
+		return false, fmt.Sprintf("DB_ADDRS needs at least two database addresses for 2PC, got %v", externalDatabaseAddresses)
+	}
+	for _, addr := range externalDatabaseAddresses {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false, fmt.Sprintf("External database %s is not running (start it with make start-dual-db): %v", addr, err)
+		}
+		conn.Close()
+	}
+	return true, ""
+}
+
+// clearExternalDatabases deletes all data of the external databases, so the tests that use them do not see the data of
+// earlier runs and do not depend on the order they run in. TestMain only calls it with CLEAR_EXTERNAL_DBS=1, the
+// external databases may hold data that is not ours to delete
+func clearExternalDatabases() {
+	for _, addr := range externalDatabaseAddresses {
+		client, err := database.ClientFactory(addr)
+		if err != nil {
+			log.Fatalf("Failed to connect to external database %s: %v", addr, err)
+		}
+		removed, err := client.DeleteAllDataPoints()
+		client.Close()
+		if err != nil {
+			log.Fatalf("Failed to clear external database %s: %v", addr, err)
+		}
+		if removed > 0 {
+			log.Printf("Cleared %d points left in external database %s by earlier runs", removed, addr)
+		}
+	}
+}
+
+// requireExternalDatabases skips the test if the external databases are not running
+func requireExternalDatabases(t *testing.T) {
+	t.Helper()
+	if !externalDatabases {
+		t.Skip(externalSkipReason)
+	}
+}
+
+// waitForTCP dials addr until a connection succeeds, failing the test after timeout
+func waitForTCP(t *testing.T, addr string, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Until(deadline))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not accept connections within %v: %v", addr, timeout, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForHTTP sends GET requests to url until one is answered with a 2xx status, failing the test after readyTimeout
+func waitForHTTP(t *testing.T, url string) {
+	t.Helper()
+
+	client := http.HttpClientFactory(time.Second)
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		resp, err := client.Get(url)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				t.Fatalf("%s answered %d instead of 2xx within %v", url, resp.StatusCode, readyTimeout)
+			}
+			t.Fatalf("%s was not reachable within %v: %v", url, readyTimeout, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startTestApp starts the server app on localhost with the settings of server.DefaultConfig changed by mutate, which
+// sets at least the port and the databases. It returns once the app answers and stops it when the test ends, a test
+// may also stop it earlier
+func startTestApp(t *testing.T, mutate func(*server.Config)) *server.App {
+	t.Helper()
+
+	config := server.DefaultConfig()
+	config.Host = "localhost"
+	mutate(&config)
+
+	app, err := server.AppFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatalf("Failed to start app: %v", err)
+	}
+	t.Cleanup(app.Stop)
+
+	waitForHTTP(t, fmt.Sprintf("http://localhost:%d/", config.Port))
+	return app
+}
+
+// startTestDatabase runs a database service in-process on a random local port and returns its address
+func startTestDatabase(t *testing.T, limit int, opts ...database.ServiceOption) (string, *database.DatabaseService) {
+	t.Helper()
+	return startTestDatabaseOn(t, "127.0.0.1:0", limit, opts...)
+}
+
+// startTestDatabaseOn starts an in-process database on a fixed address, e.g. to bring a replica back up
+func startTestDatabaseOn(t *testing.T, addr string, limit int, opts ...database.ServiceOption) (string, *database.DatabaseService) {
+	t.Helper()
+	addr, service, _ := startStoppableTestDatabase(t, addr, limit, opts...)
+	return addr, service
+}
+
+// newTestService creates a database service, failing the test if it cannot start
+func newTestService(t testing.TB, limit int, opts ...database.ServiceOption) *database.DatabaseService {
+	t.Helper()
+	service, err := database.DatabaseServiceFactory(limit, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create database service: %v", err)
+	}
+	return service
+}
+
+// startStoppableTestDatabase starts an in-process database like startTestDatabaseOn and also returns a function that
+// stops it before the test ends, e.g. to simulate a crash
+func startStoppableTestDatabase(t *testing.T, addr string, limit int, opts ...database.ServiceOption) (string, *database.DatabaseService, func()) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen for test database: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(database.KeepaliveEnforcement(database.DefaultKeepaliveMinInterval), grpc.UnaryInterceptor(tracing.UnaryServerInterceptor()))
+	service := newTestService(t, limit, opts...)
+	pb.RegisterDatabaseServiceServer(grpcServer, service)
+
+	go grpcServer.Serve(lis)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			grpcServer.Stop()
+			service.Stop()
+		})
+	}
+	t.Cleanup(stop)
+
+	return lis.Addr().String(), service, stop
+}
@@ -15,7 +15,9 @@ import (
 
 // TestHTTPServerWithRedundantStorage tests the HTTP server with 2PC redundant storage
 func TestHTTPServerWithRedundantStorage(t *testing.T) {
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	requireExternalDatabases(t)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -30,8 +32,7 @@ func TestHTTPServerWithRedundantStorage(t *testing.T) {
 	}
 	defer server.Stop()
 
-	//wait for server to start
-	time.Sleep(100 * time.Millisecond)
+	waitForTCP(t, "localhost:8082", readyTimeout)
 
 	client := http.HttpClientFactory(5 * time.Second)
 	testData := types.SensorData{
@@ -65,14 +66,8 @@ func TestHTTPServerWithRedundantStorage(t *testing.T) {
 	if len(storedData) != 1 {
 		t.Errorf("Expected 1 stored data point, got %d", len(storedData))
 	} else {
-		if storedData[0].SensorID != testData.SensorID {
-			t.Errorf("Expected sensor ID %s, got %s", testData.SensorID, storedData[0].SensorID)
-		}
-		if storedData[0].Value != testData.Value {
-			t.Errorf("Expected value %.1f, got %.1f", testData.Value, storedData[0].Value)
-		}
-		if storedData[0].Unit != testData.Unit {
-			t.Errorf("Expected unit %s, got %s", testData.Unit, storedData[0].Unit)
+		if !storedData[0].Equal(testData) {
+			t.Errorf("Expected stored data %+v, got %+v", testData, storedData[0])
 		}
 	}
 
@@ -81,7 +76,9 @@ func TestHTTPServerWithRedundantStorage(t *testing.T) {
 
 // TestHTTPGetWithRedundantStorage tests GET requests with redundant storage
 func TestHTTPGetWithRedundantStorage(t *testing.T) {
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	requireExternalDatabases(t)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -96,8 +93,7 @@ func TestHTTPGetWithRedundantStorage(t *testing.T) {
 	}
 	defer server.Stop()
 
-	//wait for server to start
-	time.Sleep(100 * time.Millisecond)
+	waitForTCP(t, "localhost:8083", readyTimeout)
 
 	testDataSet := []types.SensorData{
 		{
@@ -123,7 +119,7 @@ func TestHTTPGetWithRedundantStorage(t *testing.T) {
 
 	client := http.HttpClientFactory(5 * time.Second)
 
-	resp, err := client.Get("http://localhost:8083/data")
+	resp, err := client.Get("http://localhost:8083/data?prefix=http-get-test-")
 	if err != nil {
 		t.Fatalf("Failed to send GET request: %v", err)
 	}
@@ -132,14 +128,12 @@ func TestHTTPGetWithRedundantStorage(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 
-	var allData []types.SensorData
-	err = json.Unmarshal(resp.Body, &allData)
+	var testData []types.SensorData
+	err = json.Unmarshal(resp.Body, &testData)
 	if err != nil {
 		t.Errorf("Failed to parse GET response: %v", err)
 	}
 
-	//filter to incl our test data
-	testData := filterTestData2(allData, "http-get-test-")
 	if len(testData) < len(testDataSet) {
 		t.Errorf("Expected at least %d data points, got %d", len(testDataSet), len(testData))
 	}
@@ -168,20 +162,11 @@ func TestHTTPGetWithRedundantStorage(t *testing.T) {
 	log.Println("HTTP GET with redundant storage test passed")
 }
 
-// Helper function to filter test data by sensor ID prefix
-func filterTestData2(data []types.SensorData, prefix string) []types.SensorData {
-	var filtered []types.SensorData
-	for _, d := range data {
-		if len(d.SensorID) >= len(prefix) && d.SensorID[:len(prefix)] == prefix {
-			filtered = append(filtered, d)
-		}
-	}
-	return filtered
-}
-
 // TestHTTPDataConsistencyAfterMultiplePosts tests data consistency with multiple HTTP POST requests
 func TestHTTPDataConsistencyAfterMultiplePosts(t *testing.T) {
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	requireExternalDatabases(t)
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory(externalDatabaseAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
@@ -196,7 +181,7 @@ func TestHTTPDataConsistencyAfterMultiplePosts(t *testing.T) {
 	}
 	defer server.Stop()
 
-	time.Sleep(100 * time.Millisecond)
+	waitForTCP(t, "localhost:8084", readyTimeout)
 
 	client := http.HttpClientFactory(5 * time.Second)
 
@@ -224,32 +209,29 @@ func TestHTTPDataConsistencyAfterMultiplePosts(t *testing.T) {
 	}
 
 	//verify data consistency by checking both databases directly
-	client1, err := database.ClientFactory("localhost:50051")
+	client1, err := database.ClientFactory(externalDatabaseAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database1: %v", err)
 	}
 	defer client1.Close()
 
-	client2, err := database.ClientFactory("localhost:50052")
+	client2, err := database.ClientFactory(externalDatabaseAddresses[1])
 	if err != nil {
 		t.Fatalf("Failed to connect to database2: %v", err)
 	}
 	defer client2.Close()
 
-	//get all data from both databases
-	allData1, err := client1.GetAllDataPoints()
+	//get our test data from both databases
+	testData1, err := client1.GetDataPointsByPrefix("http-consistency-")
 	if err != nil {
 		t.Fatalf("Failed to get data from database1: %v", err)
 	}
 
-	allData2, err := client2.GetAllDataPoints()
+	testData2, err := client2.GetDataPointsByPrefix("http-consistency-")
 	if err != nil {
 		t.Fatalf("Failed to get data from database2: %v", err)
 	}
 
-	testData1 := filterTestData2(allData1, "http-consistency-")
-	testData2 := filterTestData2(allData2, "http-consistency-")
-
 	//now verify consistency
 	if len(testData1) != len(testData2) {
 		t.Errorf("Data count mismatch: db1=%d, db2=%d", len(testData1), len(testData2))
@@ -260,13 +242,8 @@ func TestHTTPDataConsistencyAfterMultiplePosts(t *testing.T) {
 	}
 
 	for i := 0; i < len(testData1) && i < len(testData2); i++ {
-		if testData1[i].SensorID != testData2[i].SensorID {
-			t.Errorf("SensorID mismatch at index %d: db1=%s, db2=%s",
-				i, testData1[i].SensorID, testData2[i].SensorID)
-		}
-		if testData1[i].Value != testData2[i].Value {
-			t.Errorf("Value mismatch at index %d: db1=%.2f, db2=%.2f",
-				i, testData1[i].Value, testData2[i].Value)
+		if !testData1[i].Equal(testData2[i]) {
+			t.Errorf("Data mismatch at index %d: db1=%+v, db2=%+v", i, testData1[i], testData2[i])
 		}
 	}
 
@@ -316,7 +293,14 @@ func register2PCHandlers(server *http.Server, tpcClient *database.TwoPhaseCommit
 		http.GET,
 		"/data",
 		func(req *http.Request) *http.Response {
-			allData, err := tpcClient.GetAllDataPoints()
+			var allData []types.SensorData
+			var err error
+
+			if prefix, ok := req.Query["prefix"]; ok {
+				allData, err = tpcClient.GetDataPointsByPrefix(prefix)
+			} else {
+				allData, err = tpcClient.GetAllDataPoints()
+			}
 			if err != nil {
 				resp := http.NewResponse(http.StatusServerError)
 				resp.SetBodyString(fmt.Sprintf("Error retrieving data: %v", err))
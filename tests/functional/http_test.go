@@ -1,14 +1,27 @@
 package functional
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
 	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
 )
 
@@ -38,8 +51,7 @@ func TestHTTPServerAndClient(t *testing.T) {
 	}
 	defer server.Stop()
 
-	//wait for server to start
-	time.Sleep(100 * time.Millisecond)
+	waitForTCP(t, "localhost:8081", readyTimeout)
 
 	client := http.HttpClientFactory(5 * time.Second)
 	testData := types.SensorData{
@@ -144,6 +156,8 @@ type MockConn struct {
 	readData []byte
 	readPos  int
 	written  []byte
+	readErr  error //returned instead of io.EOF once readData is read, if set
+	writeErr error //returned by every write, if set
 }
 
 // MockConnFactory creates a new mock connection with the given read data
@@ -157,7 +171,10 @@ func MockConnFactory(readData []byte) *MockConn {
 // Read reads data from the mock connection
 func (m *MockConn) Read(b []byte) (n int, err error) {
 	if m.readPos >= len(m.readData) {
-		return 0, fmt.Errorf("end of data")
+		if m.readErr != nil {
+			return 0, m.readErr
+		}
+		return 0, io.EOF
 	}
 
 	n = copy(b, m.readData[m.readPos:])
@@ -167,6 +184,9 @@ func (m *MockConn) Read(b []byte) (n int, err error) {
 
 // Write writes data to the mock connection
 func (m *MockConn) Write(b []byte) (n int, err error) {
+	if m.writeErr != nil {
+		return 0, m.writeErr
+	}
 	m.written = append(m.written, b...)
 	return len(b), nil
 }
@@ -213,3 +233,1838 @@ func (a *mockAddr) Network() string {
 func (a *mockAddr) String() string {
 	return "127.0.0.1:12345"
 }
+
+// TestRequestParsingLFLineEndings tests that requests using bare \n line endings parse like \r\n ones
+func TestRequestParsingLFLineEndings(t *testing.T) {
+	for _, ending := range []string{"\n", "\r\n"} {
+		requestStr := "POST /data?dryrun=true HTTP/1.1" + ending +
+			"Host: localhost:8080" + ending +
+			"Content-Type: application/json" + ending +
+			"Content-Length: 12" + ending +
+			ending +
+			"\n{\"a\":\"b\"}\r\n"
+
+		req, err := http.ParseRequest(MockConnFactory([]byte(requestStr)))
+		if err != nil {
+			t.Fatalf("%q: failed to parse request: %v", ending, err)
+		}
+
+		if req.Method != "POST" || req.Path != "/data" || req.Version != "HTTP/1.1" || req.Query["dryrun"] != "true" {
+			t.Errorf("%q: unexpected request line, got %s %s %s (query %v)", ending, req.Method, req.Path, req.Version, req.Query)
+		}
+		if req.Header("Host") != "localhost:8080" || req.ContentType != "application/json" {
+			t.Errorf("%q: unexpected headers %v", ending, req.Headers)
+		}
+
+		//the body starts right after the blank line and keeps its own line endings
+		if string(req.Body) != "\n{\"a\":\"b\"}\r\n" {
+			t.Errorf("%q: unexpected body %q", ending, string(req.Body))
+		}
+	}
+}
+
+// TestMultipartFilesParsing tests extracting file parts from a multipart/form-data request read from a connection
+func TestMultipartFilesParsing(t *testing.T) {
+	body := "--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"comment\"\r\n" +
+		"\r\n" +
+		"not a file\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"readings.csv\"\r\n" +
+		"Content-Type: text/csv\r\n" +
+		"\r\n" +
+		"sensorId,timestamp,value,unit\ntemp-1,2025-01-01T00:00:00Z,21.5,°C\r\n" +
+		"--XyZ--\r\n"
+	requestStr := "POST /data/import HTTP/1.1\r\n" +
+		"Content-Type: multipart/form-data; boundary=XyZ\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+		"\r\n" + body
+
+	req, err := http.ParseRequest(MockConnFactory([]byte(requestStr)))
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	files, err := req.MultipartFiles()
+	if err != nil {
+		t.Fatalf("Failed to parse multipart body: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file part, got %d", len(files))
+	}
+
+	file := files[0]
+	if file.FieldName != "file" || file.FileName != "readings.csv" || file.ContentType != "text/csv" {
+		t.Errorf("Unexpected file part metadata: %+v", file)
+	}
+
+	readings, err := types.DecodeSensorDataCSV(bytes.NewReader(file.Content))
+	if err != nil {
+		t.Fatalf("Failed to decode CSV: %v", err)
+	}
+	if len(readings) != 1 || readings[0].SensorID != "temp-1" || readings[0].Value != 21.5 || readings[0].Unit != "°C" {
+		t.Errorf("Unexpected readings: %+v", readings)
+	}
+
+	_, err = types.DecodeSensorDataCSV(strings.NewReader("temp-1,,1.0,°C\ntemp-2,,oops,°C\n"))
+	var csvErr *types.CSVError
+	if !errors.As(err, &csvErr) || csvErr.Line != 2 {
+		t.Errorf("Expected a CSV error on line 2, got %v", err)
+	}
+}
+
+// TestClientIP tests the X-Forwarded-For aware client address in trusted and untrusted mode
+func TestClientIP(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8086)
+	server.RegisterHandler(http.GET, "/ip", func(req *http.Request) *http.Response {
+		body := fmt.Sprintf("%s|%s", req.ClientIP(false), req.ClientIP(true))
+		return http.CreateTextResponse(http.StatusOK, []byte(body))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8086", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{"no header", nil, "127.0.0.1|127.0.0.1"},
+		{"spoofed single", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "127.0.0.1|203.0.113.7"},
+		{"spoofed chain", map[string]string{"X-Forwarded-For": " 203.0.113.7 , 10.0.0.1"}, "127.0.0.1|203.0.113.7"},
+		{"empty header", map[string]string{"X-Forwarded-For": ""}, "127.0.0.1|127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Do(http.GET, "http://127.0.0.1:8086/ip", nil, tt.headers)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if string(resp.Body) != tt.expected {
+				t.Errorf("Expected untrusted|trusted %q, got %q", tt.expected, string(resp.Body))
+			}
+		})
+	}
+}
+
+// TestGzipContentLength tests that a compressed response announces the compressed size in Content-Length and that
+// the q-values of Accept-Encoding decide whether a response is compressed
+func TestGzipContentLength(t *testing.T) {
+	original := []byte(strings.Repeat(`{"sensorId":"temp-1","value":21.5,"unit":"°C"},`, 200))
+
+	server := http.ServerFactory("127.0.0.1", 8087)
+	server.CompressionThreshold = 512
+	server.RegisterHandler(http.GET, "/big", func(req *http.Request) *http.Response {
+		return http.CreateJSONResponse(http.StatusOK, original)
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8087", readyTimeout)
+
+	//read the response off the wire until the server closes the connection, so the body length does not depend on the
+	//Content-Length it is checked against
+	conn, err := net.Dial("tcp", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("GET /big HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip, deflate\r\nConnection: close\r\n\r\n")); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	head, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		t.Fatalf("Response without end of headers: %q", raw)
+	}
+	if !bytes.Contains(head, []byte("Content-Encoding: gzip")) {
+		t.Fatalf("Expected a gzip encoded response, got headers %s", head)
+	}
+	if header := fmt.Sprintf("Content-Length: %d", len(body)); !slices.Contains(strings.Split(string(head), "\r\n"), header) {
+		t.Errorf("Expected %q for the %d bytes on the wire, got headers %s", header, len(body), head)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress body: %v", err)
+	}
+	if !bytes.Equal(decompressed, original) {
+		t.Errorf("Decompressed body does not match the original")
+	}
+	if len(body) >= len(decompressed) {
+		t.Errorf("Expected the %d compressed bytes to be fewer than the %d decompressed ones", len(body), len(decompressed))
+	}
+
+	//only a gzip or * coding with a q-value above 0 and at least the one of identity gets a compressed body
+	client := http.HttpClientFactory(5 * time.Second)
+	tests := []struct {
+		acceptEncoding string
+		compressed     bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP;q=0.8", false},
+		{"gzip;q=0.8, identity;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; Q=0", false},
+		{"gzip;q=0.0000", false},
+		{"gzip;level=1;q=0", false},
+		{"gzip;q=abc", false},
+		{"deflate, *", true},
+		{"*;q=0", false},
+		{"*;q=0, gzip", true},
+		{"gzip;q=0.5, *;q=1", false},
+		{"identity;q=0, gzip;q=0.1", true},
+		{"deflate", false},
+	}
+	for _, tt := range tests {
+		headers := map[string]string{}
+		if tt.acceptEncoding != "" {
+			headers["Accept-Encoding"] = tt.acceptEncoding
+		}
+		resp, err := client.Do(http.GET, "http://127.0.0.1:8087/big", nil, headers)
+		if err != nil {
+			t.Fatalf("Accept-Encoding %q: failed to send request: %v", tt.acceptEncoding, err)
+		}
+		if compressed := resp.Headers["Content-Encoding"] == "gzip"; compressed != tt.compressed {
+			t.Errorf("Accept-Encoding %q: expected compressed %v, got headers %v", tt.acceptEncoding, tt.compressed, resp.Headers)
+		}
+		if !tt.compressed && !bytes.Equal(resp.Body, original) {
+			t.Errorf("Accept-Encoding %q: expected the plain body of %d bytes, got %d", tt.acceptEncoding, len(original), len(resp.Body))
+		}
+	}
+}
+
+// TestHTTPOverUnixSocket tests posting to the server over a Unix domain socket and the socket cleanup on Stop
+func TestHTTPOverUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "iot.sock")
+
+	server := http.UnixSocketServerFactory(socketPath)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		return http.CreateJSONResponse(http.StatusOK, req.Body)
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	client := http.HttpClientFactory(5 * time.Second)
+	payload := []byte(`{"sensorId":"unix-1","value":1.5}`)
+
+	resp, err := client.PostJSON(http.UnixSocketURL(socketPath)+"/data", payload)
+	if err != nil {
+		t.Fatalf("Failed to post over the unix socket: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if !bytes.Equal(resp.Body, payload) {
+		t.Errorf("Expected echoed body %s, got %s", payload, resp.Body)
+	}
+
+	server.Stop()
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed on Stop, got %v", err)
+	}
+}
+
+// TestVirtualHostRouting tests that host specific handlers are preferred and host agnostic handlers are the fallback
+func TestVirtualHostRouting(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8088)
+	server.RegisterHandler(http.GET, "/tenant", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("default"))
+	})
+	server.RegisterHandlerForHost("alpha.example", http.GET, "/tenant", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("alpha"))
+	})
+	server.RegisterHandlerForHost("Beta.Example", http.GET, "/tenant", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("beta"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8088", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"alpha.example", "alpha"},
+		{"beta.example:8088", "beta"},
+		{"gamma.example", "default"},
+	}
+
+	for _, tt := range tests {
+		resp, err := client.Do(http.GET, "http://127.0.0.1:8088/tenant", nil, map[string]string{"Host": tt.host})
+		if err != nil {
+			t.Fatalf("Failed to send request for host %s: %v", tt.host, err)
+		}
+		if string(resp.Body) != tt.expected {
+			t.Errorf("Host %s: expected %q, got %q", tt.host, tt.expected, string(resp.Body))
+		}
+	}
+}
+
+// TestConcurrentHandlerRegistration tests that handlers can be registered while the server dispatches requests;
+// run with -race to catch unsynchronized access to the handler maps
+func TestConcurrentHandlerRegistration(t *testing.T) {
+	const handlers = 50
+
+	server := http.ServerFactory("127.0.0.1", 8101)
+	server.RegisterHandler(http.GET, "/static", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("static"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8101", readyTimeout)
+
+	//every registration is logged, which would flood the test output
+	previousLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(previousLogOutput)
+
+	//the handlers are registered over and over until the clients are done, so registration and dispatch overlap
+	done := make(chan struct{})
+	registered := make(chan struct{})
+	go func() {
+		defer close(registered)
+		for round := 0; ; round++ {
+			i := round % handlers
+			body := []byte(strconv.Itoa(i))
+			server.RegisterHandler(http.GET, fmt.Sprintf("/dynamic/%d", i), func(req *http.Request) *http.Response {
+				return http.CreateTextResponse(http.StatusOK, body)
+			})
+			server.RegisterHandlerForHost("tenant.example", http.GET, fmt.Sprintf("/dynamic/%d", i), func(req *http.Request) *http.Response {
+				return http.CreateTextResponse(http.StatusOK, []byte("tenant"))
+			})
+
+			select {
+			case <-done:
+				if round >= handlers {
+					return
+				}
+			case <-time.After(100 * time.Microsecond):
+			}
+		}
+	}()
+
+	//requests for handlers that are not registered yet may get a 404, but never a wrong body or a crash
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := http.HttpClientFactory(5 * time.Second)
+			for i := range handlers {
+				resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:8101/dynamic/%d", i))
+				if err != nil {
+					t.Errorf("Failed to send request: %v", err)
+					return
+				}
+				if resp.StatusCode == http.StatusOK && string(resp.Body) != strconv.Itoa(i) {
+					t.Errorf("Handler %d: got %q", i, resp.Body)
+				}
+
+				resp, err = client.Get("http://127.0.0.1:8101/static")
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Errorf("Static handler failed during registration: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-registered
+
+	//once registration is done, every dynamic handler is served
+	client := http.HttpClientFactory(5 * time.Second)
+	for i := range handlers {
+		resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:8101/dynamic/%d", i))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || string(resp.Body) != strconv.Itoa(i) {
+			t.Errorf("Handler %d: expected %q, got %d %q", i, strconv.Itoa(i), resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// TestRequestCookies tests parsing a Cookie header with several cookies
+func TestRequestCookies(t *testing.T) {
+	requestStr := "GET /dashboard HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Cookie: session=abc123; theme=\"dark mode\";  empty=; lang=de; session=ignored; =nameless\r\n" +
+		"\r\n"
+
+	req, err := http.ParseRequest(MockConnFactory([]byte(requestStr)))
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+
+	expected := map[string]string{"session": "abc123", "theme": "dark mode", "empty": "", "lang": "de"}
+	cookies := req.Cookies()
+	if len(cookies) != len(expected) {
+		t.Errorf("Expected %d cookies, got %v", len(expected), cookies)
+	}
+	for name, value := range expected {
+		if got, ok := cookies[name]; !ok || got != value {
+			t.Errorf("Cookie %s: expected %q, got %q (present: %v)", name, value, got, ok)
+		}
+	}
+
+	req, err = http.ParseRequest(MockConnFactory([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	if cookies := req.Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookies without a Cookie header, got %v", cookies)
+	}
+}
+
+// TestSetCookie tests the Set-Cookie serialization including the attributes and several cookies on one response
+func TestSetCookie(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		opts     http.CookieOptions
+		expected string
+	}{
+		{"session", "abc123", http.CookieOptions{}, "session=abc123"},
+		{"session", "abc123", http.CookieOptions{Path: "/", MaxAge: 3600, HttpOnly: true, Secure: true, SameSite: http.SameSiteStrict},
+			"session=abc123; Path=/; Max-Age=3600; HttpOnly; Secure; SameSite=Strict"},
+		{"session", "", http.CookieOptions{Path: "/", MaxAge: -1}, "session=; Path=/; Max-Age=0"},
+		{"theme", "dark mode", http.CookieOptions{SameSite: http.SameSiteLax}, `theme="dark mode"; SameSite=Lax`},
+		{"inject", "a;b\r\nX: y", http.CookieOptions{Path: "/a;b"}, `inject="abX: y"; Path=/ab`},
+	}
+
+	for _, tt := range tests {
+		resp := http.NewResponse(http.StatusOK)
+		resp.SetCookie(tt.name, tt.value, tt.opts)
+		if values := resp.HeaderValues("Set-Cookie"); len(values) != 1 || values[0] != tt.expected {
+			t.Errorf("SetCookie(%q, %q, %+v): expected %q, got %q", tt.name, tt.value, tt.opts, tt.expected, values)
+		}
+	}
+
+	resp := http.NewResponse(http.StatusOK)
+	resp.SetCookie("bad name", "x", http.CookieOptions{})
+	if values := resp.HeaderValues("Set-Cookie"); len(values) != 0 {
+		t.Errorf("Expected a cookie with an invalid name to be dropped, got %q", values)
+	}
+
+	//every cookie is written on its own header line
+	resp.SetCookie("a", "1", http.CookieOptions{})
+	resp.SetCookie("b", "2", http.CookieOptions{HttpOnly: true})
+	conn := MockConnFactory(nil)
+	if err := resp.Write(conn); err != nil {
+		t.Fatalf("Failed to write response: %v", err)
+	}
+	written := string(conn.written)
+	if !strings.Contains(written, "Set-Cookie: a=1\r\n") || !strings.Contains(written, "Set-Cookie: b=2; HttpOnly\r\n") {
+		t.Errorf("Expected two Set-Cookie lines, got %q", written)
+	}
+}
+
+// TestCookiesRoundTrip tests that the client keeps every Set-Cookie of a response and the server reads cookies back
+func TestCookiesRoundTrip(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8102)
+	server.RegisterHandler(http.POST, "/login", func(req *http.Request) *http.Response {
+		resp := http.CreateTextResponse(http.StatusOK, []byte("ok"))
+		resp.SetCookie("session", "abc123", http.CookieOptions{Path: "/", HttpOnly: true})
+		resp.SetCookie("lang", "de", http.CookieOptions{MaxAge: 60})
+		return resp
+	})
+	server.RegisterHandler(http.GET, "/whoami", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte(req.Cookies()["session"]))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8102", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.Post("http://127.0.0.1:8102/login", nil, "text/plain")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	values := resp.HeaderValues("Set-Cookie")
+	if len(values) != 2 {
+		t.Fatalf("Expected 2 Set-Cookie headers, got %q", values)
+	}
+
+	resp, err = client.Do(http.GET, "http://127.0.0.1:8102/whoami", nil, map[string]string{"Cookie": "lang=de; session=abc123"})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if string(resp.Body) != "abc123" {
+		t.Errorf("Expected the session cookie to be read back, got %q", resp.Body)
+	}
+}
+
+// TestHeaderLimits tests that an oversized header line or too many headers are rejected without reading them
+func TestHeaderLimits(t *testing.T) {
+	giantLine := "GET / HTTP/1.1\r\nX-Giant: " + strings.Repeat("a", 16<<20) //no line ending
+	_, err := http.ParseRequest(MockConnFactory([]byte(giantLine)))
+	if !errors.Is(err, http.ErrHeaderTooLarge) {
+		t.Errorf("Expected ErrHeaderTooLarge for a giant header line, got %v", err)
+	}
+
+	_, err = http.ParseRequest(MockConnFactory([]byte("GET /" + strings.Repeat("a", http.MaxHeaderLineLength) + " HTTP/1.1\r\n\r\n")))
+	if !errors.Is(err, http.ErrHeaderTooLarge) {
+		t.Errorf("Expected ErrHeaderTooLarge for a giant request line, got %v", err)
+	}
+
+	var headers strings.Builder
+	for i := range http.MaxHeaderCount + 1 {
+		fmt.Fprintf(&headers, "X-Header-%d: %d\r\n", i, i)
+	}
+	_, err = http.ParseRequest(MockConnFactory([]byte("GET / HTTP/1.1\r\n" + headers.String() + "\r\n")))
+	if !errors.Is(err, http.ErrHeaderTooLarge) {
+		t.Errorf("Expected ErrHeaderTooLarge for %d headers, got %v", http.MaxHeaderCount+1, err)
+	}
+
+	//a line right at the limit is still accepted
+	longValue := strings.Repeat("b", http.MaxHeaderLineLength-len("X-Long: \r\n"))
+	req, err := http.ParseRequest(MockConnFactory([]byte("GET / HTTP/1.1\r\nX-Long: " + longValue + "\r\n\r\n")))
+	if err != nil || req.Header("X-Long") != longValue {
+		t.Errorf("Expected a header line at the limit to be accepted, got %v", err)
+	}
+
+	server := http.ServerFactory("127.0.0.1", 8103)
+	server.RegisterHandler(http.GET, "/", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8103", readyTimeout)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8103")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	//the server stops reading after the limit, so the write of the rest may fail once it closes the connection
+	go conn.Write([]byte(giantLine))
+
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 431 ") {
+		t.Errorf("Expected 431 for a giant header line, got %q", statusLine)
+	}
+}
+
+// stalledWriteConn is a MockConn whose Write blocks like a client that stopped reading, until the write deadline
+type stalledWriteConn struct {
+	*MockConn
+	mu            sync.Mutex
+	writeDeadline time.Time
+	closed        bool
+}
+
+func (c *stalledWriteConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *stalledWriteConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if deadline.IsZero() {
+		select {} //without a deadline the write never returns, like a full socket buffer
+	}
+	time.Sleep(time.Until(deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (c *stalledWriteConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// TestWriteDeadline tests that a response the client does not read is given up after the write timeout and the
+// connection is dropped, logged at debug level only
+func TestWriteDeadline(t *testing.T) {
+	buf := captureLogs(t)
+	logging.SetLevel(logging.LevelInfo)
+
+	server := http.ServerFactory("127.0.0.1", 0)
+	server.WriteTimeout = 100 * time.Millisecond
+	server.RegisterHandler(http.GET, "/data", func(req *http.Request) *http.Response {
+		return http.CreateJSONResponse(http.StatusOK, bytes.Repeat([]byte("x"), 1<<20))
+	})
+
+	conn := &stalledWriteConn{MockConn: MockConnFactory([]byte("GET /data HTTP/1.1\r\nHost: localhost\r\n\r\n"))}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ServeConn still blocked on the write long after the write timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed < server.WriteTimeout {
+		t.Errorf("Expected the write to be given up after %v, took %v", server.WriteTimeout, elapsed)
+	}
+	conn.mu.Lock()
+	closed := conn.closed
+	conn.mu.Unlock()
+	if !closed {
+		t.Errorf("Expected the connection to be closed after the write deadline")
+	}
+	if strings.Contains(buf.String(), "Error writing response") {
+		t.Errorf("Expected a write deadline to be treated as a dropped connection, got %q", buf.String())
+	}
+}
+
+// TestEmptyResponseContentLength tests that responses without a body are framed with Content-Length: 0
+func TestEmptyResponseContentLength(t *testing.T) {
+	mockConn := MockConnFactory(nil)
+	if err := http.NewResponse(http.StatusNotFound).Write(mockConn); err != nil {
+		t.Fatalf("Failed to write response: %v", err)
+	}
+
+	raw := string(mockConn.written)
+	if !strings.Contains(raw, "Content-Length: 0\r\n") {
+		t.Errorf("Expected an empty 404 response to include Content-Length: 0, got %q", raw)
+	}
+	if !strings.HasSuffix(raw, "\r\n\r\n") {
+		t.Errorf("Expected the response to end after the headers, got %q", raw)
+	}
+
+	//204 must not carry a Content-Length or a body
+	mockConn = MockConnFactory(nil)
+	resp := http.NewResponse(http.StatusNoContent)
+	resp.SetBodyString("ignored")
+	if err := resp.Write(mockConn); err != nil {
+		t.Fatalf("Failed to write response: %v", err)
+	}
+	raw = string(mockConn.written)
+	if strings.Contains(raw, "Content-Length") || strings.Contains(raw, "ignored") {
+		t.Errorf("Expected a 204 response without Content-Length and body, got %q", raw)
+	}
+}
+
+// TestRangeRequests tests partial content responses for satisfiable, suffix and unsatisfiable byte ranges
+func TestRangeRequests(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+
+	server := http.ServerFactory("127.0.0.1", 8089)
+	server.RegisterHandler(http.GET, "/export", func(req *http.Request) *http.Response {
+		return http.ServeContent(req, "text/plain", content)
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8089", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	tests := []struct {
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"bytes=15-", http.StatusPartialContent, "fghij", "bytes 15-19/20"},
+		{"bytes=-3", http.StatusPartialContent, "hij", "bytes 17-19/20"},
+		{"bytes=18-100", http.StatusPartialContent, "ij", "bytes 18-19/20"},
+		{"bytes=20-25", http.StatusRangeNotSatisfiable, "", "bytes */20"},
+		{"bytes=0-1,4-5", http.StatusOK, string(content), ""},
+	}
+
+	for _, tt := range tests {
+		resp, err := client.Do(http.GET, "http://127.0.0.1:8089/export", nil, map[string]string{"Range": tt.rangeHeader})
+		if err != nil {
+			t.Fatalf("Failed to send request for %s: %v", tt.rangeHeader, err)
+		}
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.rangeHeader, tt.status, resp.StatusCode)
+		}
+		if string(resp.Body) != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.rangeHeader, tt.body, string(resp.Body))
+		}
+		if resp.Headers["Content-Range"] != tt.contentRange {
+			t.Errorf("%s: expected Content-Range %q, got %q", tt.rangeHeader, tt.contentRange, resp.Headers["Content-Range"])
+		}
+		if resp.ContentLength != len(tt.body) {
+			t.Errorf("%s: expected Content-Length %d, got %d", tt.rangeHeader, len(tt.body), resp.ContentLength)
+		}
+	}
+}
+
+// TestClientResponseCache tests that the client serves max-age responses from its cache and honors no-store and bypasses
+func TestClientResponseCache(t *testing.T) {
+	hits := map[string]int{}
+	var mu sync.Mutex
+	countingHandler := func(cacheControl string) http.RequestHandler {
+		return func(req *http.Request) *http.Response {
+			mu.Lock()
+			hits[req.Path]++
+			mu.Unlock()
+
+			resp := http.CreateTextResponse(http.StatusOK, []byte("payload"))
+			resp.SetHeader("Cache-Control", cacheControl)
+			return resp
+		}
+	}
+
+	server := http.ServerFactory("127.0.0.1", 8091)
+	server.RegisterHandler(http.GET, "/cached", countingHandler("public, max-age=60"))
+	server.RegisterHandler(http.GET, "/uncached", countingHandler("no-store"))
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8091", readyTimeout)
+
+	client := http.HttpClientFactory(5*time.Second, http.WithResponseCache(10))
+	url := "http://127.0.0.1:8091/cached"
+
+	first, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	second, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	if first.FromCache || !second.FromCache {
+		t.Errorf("Expected only the second response to come from the cache (first %v, second %v)", first.FromCache, second.FromCache)
+	}
+	if string(second.Body) != "payload" {
+		t.Errorf("Expected the cached body, got %q", string(second.Body))
+	}
+
+	//the cache keeps its own copy of headers and body, changing a returned response does not change later ones
+	for _, resp := range []*http.Response{first, second} {
+		resp.Body[0] = 'X'
+		resp.Headers["Cache-Control"] = "changed"
+	}
+	third, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if !third.FromCache || string(third.Body) != "payload" || third.Header("Cache-Control") != "public, max-age=60" {
+		t.Errorf("Expected the unchanged cached response, got %q with headers %v", third.Body, third.Headers)
+	}
+
+	//a per-request no-cache goes to the server again
+	bypass, err := client.Do(http.GET, url, nil, map[string]string{"Cache-Control": "no-cache"})
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if bypass.FromCache {
+		t.Errorf("Expected the no-cache request to bypass the cache")
+	}
+
+	for range 2 {
+		if _, err := client.Get("http://127.0.0.1:8091/uncached"); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/cached"] != 2 {
+		t.Errorf("Expected 2 server hits for /cached, got %d", hits["/cached"])
+	}
+	if hits["/uncached"] != 2 {
+		t.Errorf("Expected no-store responses not to be cached, got %d server hits", hits["/uncached"])
+	}
+}
+
+// TestConnectionStats tests that the active connection count follows open connections and parse errors are counted
+func TestConnectionStats(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8095)
+	server.RegisterHandler(http.GET, "/ping", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("pong"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8095", readyTimeout)
+
+	waitFor := func(description string, condition func(http.ConnStats) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition(server.ConnStats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s, stats: %+v", description, server.ConnStats())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	//the readiness probe is the first accepted connection
+	waitFor("the readiness probe to finish", func(s http.ConnStats) bool { return s.Active == 0 && s.Accepted == 1 })
+
+	//idle connections stay active until they are closed
+	conns := make([]net.Conn, 3)
+	for i := range conns {
+		conns[i], err = net.Dial("tcp", "127.0.0.1:8095")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+	}
+	waitFor("3 active connections", func(s http.ConnStats) bool { return s.Active == 3 && s.Accepted == 4 })
+
+	//garbage is a parse error, closing without a request is not
+	conns[0].Write([]byte("garbage\r\n\r\n"))
+	for _, conn := range conns {
+		conn.Close()
+	}
+	waitFor("all connections to finish", func(s http.ConnStats) bool { return s.Active == 0 })
+
+	stats := server.ConnStats()
+	if stats.ParseErrors != 1 {
+		t.Errorf("Expected 1 parse error, got %d", stats.ParseErrors)
+	}
+
+	client := http.HttpClientFactory(5 * time.Second)
+	if _, err := client.Get("http://127.0.0.1:8095/ping"); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	waitFor("the request connection to finish", func(s http.ConnStats) bool { return s.Active == 0 && s.Accepted == 5 })
+}
+
+// readRawResponse reads one response from a raw connection, returning its status line, headers (lowercase keys)
+// and body; the body is framed by Content-Length so the connection can be reused afterwards
+func readRawResponse(t *testing.T, reader *bufio.Reader) (string, map[string]string, string) {
+	t.Helper()
+
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read status line: %v", err)
+	}
+
+	headers := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read header: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, ":")
+		headers[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+
+	length, err := strconv.Atoi(headers["content-length"])
+	if err != nil {
+		t.Fatalf("Invalid Content-Length %q: %v", headers["content-length"], err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+
+	return strings.TrimRight(statusLine, "\r\n"), headers, string(body)
+}
+
+// TestHTTP10Clients tests that HTTP/1.0 requests are answered with HTTP/1.0 and that their connections are closed
+// unless the client asks for keep-alive, while HTTP/1.1 connections stay open by default
+func TestHTTP10Clients(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8096)
+	server.RegisterHandler(http.GET, "/ping", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("pong"))
+	})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "127.0.0.1:8096", readyTimeout)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", "127.0.0.1:8096")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	//plain HTTP/1.0: answered in HTTP/1.0, then the server closes the connection
+	conn, reader := dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /ping HTTP/1.0\r\n\r\n"))
+
+	status, headers, body := readRawResponse(t, reader)
+	if status != "HTTP/1.0 200 OK" {
+		t.Errorf("Expected status line 'HTTP/1.0 200 OK', got %q", status)
+	}
+	if headers["connection"] != "close" {
+		t.Errorf("Expected Connection: close, got %q", headers["connection"])
+	}
+	if body != "pong" {
+		t.Errorf("Expected body 'pong', got %q", body)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the server to close the connection, got %v", err)
+	}
+
+	//HTTP/1.0 with keep-alive: the connection is reused for a second request
+	conn, reader = dial()
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("GET /ping HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+
+		status, headers, body := readRawResponse(t, reader)
+		if status != "HTTP/1.0 200 OK" || body != "pong" {
+			t.Fatalf("Request %d: unexpected response %q with body %q", i+1, status, body)
+		}
+		if headers["connection"] != "keep-alive" {
+			t.Errorf("Request %d: expected Connection: keep-alive, got %q", i+1, headers["connection"])
+		}
+	}
+
+	//HTTP/1.1 keeps the connection open by default and closes it on Connection: close
+	conn, reader = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"))
+	status, headers, _ = readRawResponse(t, reader)
+	if status != "HTTP/1.1 200 OK" || headers["connection"] == "close" {
+		t.Errorf("Expected a persistent HTTP/1.1 response, got %q with Connection %q", status, headers["connection"])
+	}
+
+	conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: close\r\n\r\n"))
+	status, headers, _ = readRawResponse(t, reader)
+	if status != "HTTP/1.1 200 OK" || headers["connection"] != "close" {
+		t.Errorf("Expected the second HTTP/1.1 response to close, got %q with Connection %q", status, headers["connection"])
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the server to close the connection, got %v", err)
+	}
+}
+
+// TestPipelinedRequests tests that requests pipelined on one connection are all served, with responses in request order
+func TestPipelinedRequests(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 0)
+	server.RegisterHandler(http.GET, "/echo/*", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte(req.Path))
+	})
+	server.RegisterHandler(http.POST, "/echo/*", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, req.Body)
+	})
+
+	//both requests arrive in one read, before the first response is written
+	pipelined := "GET /echo/first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /echo/second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nsecond" +
+		"GET /echo/third HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"
+	mockConn := MockConnFactory([]byte(pipelined))
+	server.ServeConn(mockConn)
+
+	reader := bufio.NewReader(bytes.NewReader(mockConn.written))
+	for _, expected := range []string{"/echo/first", "second", "/echo/third"} {
+		status, _, body := readRawResponse(t, reader)
+		if !strings.Contains(status, "200") {
+			t.Errorf("Expected 200 for %s, got %q", expected, status)
+		}
+		if body != expected {
+			t.Errorf("Expected responses in request order, got body %q instead of %q", body, expected)
+		}
+	}
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		t.Errorf("Expected exactly three responses, got trailing %q", rest)
+	}
+
+	if stats := server.ConnStats(); stats.Pipelined != 2 || stats.ParseErrors != 0 || stats.Active != 0 {
+		t.Errorf("Expected 2 pipelined requests and no parse errors, got %+v", stats)
+	}
+}
+
+// TestServerHeader tests the default Server header, a custom one, that an empty one omits the header and that a
+// handler setting the header itself keeps its value
+func TestServerHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []http.ServerOption
+		path     string
+		expected string //the header line, empty = no Server header at all
+	}{
+		{"default", nil, "/plain", "Server: " + http.DefaultServerHeader + "\r\n"},
+		{"custom", []http.ServerOption{http.WithServerHeader("sensors")}, "/plain", "Server: sensors\r\n"},
+		{"omitted", []http.ServerOption{http.WithServerHeader("")}, "/plain", ""},
+		{"set by handler", []http.ServerOption{http.WithServerHeader("")}, "/own", "Server: handler\r\n"},
+	}
+
+	for _, tt := range tests {
+		server := http.ServerFactory("127.0.0.1", 0, tt.opts...)
+		server.RegisterHandler(http.GET, "/plain", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+		})
+		server.RegisterHandler(http.GET, "/own", func(req *http.Request) *http.Response {
+			resp := http.CreateTextResponse(http.StatusOK, []byte("ok"))
+			resp.SetHeader("Server", "handler")
+			return resp
+		})
+
+		mockConn := MockConnFactory([]byte("GET " + tt.path + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		server.ServeConn(mockConn)
+
+		raw := string(mockConn.written)
+		if tt.expected == "" && strings.Contains(raw, "Server:") {
+			t.Errorf("%s: expected no Server header, got %q", tt.name, raw)
+		}
+		if tt.expected != "" && !strings.Contains(raw, tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, raw)
+		}
+	}
+}
+
+// TestIncompleteRequests tests that a request whose body falls short of its Content-Length or that sends its body
+// before the end of the headers fails at once with a clear error, and that a client that stops sending in the middle
+// of a request is answered with 408 once the read timeout passed instead of blocking its connection
+func TestIncompleteRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected error
+		message  string
+	}{
+		{"truncated body", "POST /data HTTP/1.1\r\nContent-Length: 100\r\n\r\nshort", http.ErrIncompleteRequest, "after 5 of 100 bytes"},
+		{"truncated headers", "POST /data HTTP/1.1\r\nContent-Len", http.ErrIncompleteRequest, "ended in the headers"},
+		{"body before blank line", "POST /data HTTP/1.1\r\nContent-Length: 18\r\n{\"sensorId\":\"x\"}\r\n\r\n", nil, "invalid header name"},
+		{"negative length", "POST /data HTTP/1.1\r\nContent-Length: -5\r\n\r\n", nil, "invalid Content-Length"},
+	}
+
+	for _, tt := range tests {
+		done := make(chan error, 1)
+		go func() {
+			_, err := http.ParseRequest(MockConnFactory([]byte(tt.raw)))
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil || (tt.expected != nil && !errors.Is(err, tt.expected)) || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("%s: expected an error mentioning %q, got %v", tt.name, tt.message, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: parsing hangs", tt.name)
+		}
+	}
+
+	//the client announces 100 bytes, sends 5 and then waits
+	server := http.ServerFactory("127.0.0.1", 0)
+	server.ReadTimeout = 200 * time.Millisecond
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	served := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(served)
+	}()
+
+	start := time.Now()
+	if _, err := clientConn.Write([]byte("POST /data HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\nshort")); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	response, err := io.ReadAll(clientConn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the request to time out after the read timeout, took %v", elapsed)
+	}
+	if !strings.HasPrefix(string(response), "HTTP/1.1 408") || !strings.Contains(string(response), "Connection: close") {
+		t.Errorf("Expected 408 and a closed connection, got %q", response)
+	}
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Errorf("Connection still served after the timeout")
+	}
+}
+
+// TestClientErrors tests that every failure of the client wraps the typed error of the step that failed, plus
+// ErrTimeout for timeouts, that it keeps the cause for errors.As and that responses without a body are no error
+func TestClientErrors(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	reset := &net.OpError{Op: "write", Net: "tcp", Err: syscall.ECONNRESET}
+
+	tests := []struct {
+		name     string
+		dialErr  error
+		conn     *MockConn
+		expected []error
+		absent   []error
+	}{
+		{"connection refused", refused, nil, []error{http.ErrConnect, syscall.ECONNREFUSED}, []error{http.ErrTimeout}},
+		{"connect timeout", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, nil, []error{http.ErrConnect, http.ErrTimeout}, nil},
+		{"write failed", nil, &MockConn{writeErr: reset}, []error{http.ErrConnect, syscall.ECONNRESET}, []error{http.ErrTimeout, http.ErrReadBody}},
+		{"read timeout", nil, &MockConn{readData: []byte("HTTP/1.1 200 OK\r\n"), readErr: os.ErrDeadlineExceeded}, []error{http.ErrReadBody, http.ErrTimeout}, []error{http.ErrConnect}},
+		{"closed without response", nil, &MockConn{}, []error{http.ErrReadBody}, []error{http.ErrParse, http.ErrTimeout}},
+		{"closed in the headers", nil, &MockConn{readData: []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n")}, []error{http.ErrReadBody}, []error{http.ErrParse}},
+		{"truncated body", nil, &MockConn{readData: []byte("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc")}, []error{http.ErrReadBody}, []error{http.ErrParse}},
+		{"not HTTP", nil, &MockConn{readData: []byte("SSH-2.0-OpenSSH_9.6\r\n")}, []error{http.ErrParse}, []error{http.ErrReadBody}},
+		{"invalid status code", nil, &MockConn{readData: []byte("HTTP/1.1 abc OK\r\n\r\n")}, []error{http.ErrParse}, []error{http.ErrReadBody}},
+	}
+
+	for _, tt := range tests {
+		client := http.HttpClientFactory(time.Second, http.WithDialer(func(network, addr string, timeout time.Duration) (net.Conn, error) {
+			if tt.dialErr != nil {
+				return nil, tt.dialErr
+			}
+			return tt.conn, nil
+		}))
+
+		_, err := client.Get("http://fake:8080/data")
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		for _, expected := range tt.expected {
+			if !errors.Is(err, expected) {
+				t.Errorf("%s: expected %v to wrap %v", tt.name, err, expected)
+			}
+		}
+		for _, absent := range tt.absent {
+			if errors.Is(err, absent) {
+				t.Errorf("%s: expected %v not to wrap %v", tt.name, err, absent)
+			}
+		}
+	}
+
+	//the cause stays reachable with errors.As
+	client := http.HttpClientFactory(time.Second, http.WithDialer(func(string, string, time.Duration) (net.Conn, error) {
+		return nil, refused
+	}))
+	_, err := client.Get("http://fake:8080/data")
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		t.Errorf("Expected the *net.OpError of the dial, got %v", err)
+	}
+
+	//a complete response is no error
+	conn := MockConnFactory([]byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabc"))
+	client = http.HttpClientFactory(time.Second, http.WithDialer(func(string, string, time.Duration) (net.Conn, error) {
+		return conn, nil
+	}))
+	if resp, err := client.Get("http://fake:8080/data"); err != nil || string(resp.Body) != "abc" {
+		t.Errorf("Expected the response body abc, got %v (%v)", resp, err)
+	}
+	if !strings.HasPrefix(string(conn.written), "GET /data HTTP/1.1\r\n") {
+		t.Errorf("Expected the request on the fake connection, got %q", conn.written)
+	}
+
+	//responses to HEAD and 1xx, 204 and 304 responses have no body, a Content-Length does not make them truncated
+	bodyless := []struct {
+		method   string
+		response string
+	}{
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 42\r\n\r\n"},
+		{http.GET, "HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n"},
+		{http.GET, "HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n"},
+	}
+	for _, tt := range bodyless {
+		client := http.HttpClientFactory(time.Second, http.WithDialer(func(string, string, time.Duration) (net.Conn, error) {
+			return MockConnFactory([]byte(tt.response)), nil
+		}))
+		resp, err := client.Do(tt.method, "http://fake:8080/data", nil, nil)
+		if err != nil {
+			t.Errorf("%s %q: expected no error, got %v", tt.method, tt.response, err)
+			continue
+		}
+		if len(resp.Body) != 0 || resp.ContentLength == 0 {
+			t.Errorf("%s %q: expected an empty body and the Content-Length, got %q and %d", tt.method, tt.response, resp.Body, resp.ContentLength)
+		}
+	}
+}
+
+// TestListenerOptions tests that a stopped server can be restarted on its port at once although connections of the
+// first one are still in TIME_WAIT, that a stopped server can be started again, that servers with WithReusePort share
+// a port that is refused without it, and that a server with a listen backlog serves requests
+func TestListenerOptions(t *testing.T) {
+	newServer := func(port int, name string, opts ...http.ServerOption) *http.Server {
+		server := http.ServerFactory("127.0.0.1", port, opts...)
+		server.RegisterHandler(http.GET, "/name", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte(name))
+		})
+		return server
+	}
+	client := http.HttpClientFactory(5 * time.Second)
+
+	//restart: the first server closes the connections it served, which leaves them in TIME_WAIT on its port
+	first := newServer(8119, "first", http.WithListenBacklog(1))
+	if err := first.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get("http://127.0.0.1:8119/name")
+		if err != nil {
+			t.Fatalf("Request to the first server failed: %v", err)
+		}
+		if string(resp.Body) != "first" {
+			t.Errorf("Expected body first, got %q", resp.Body)
+		}
+	}
+	first.Stop()
+
+	second := newServer(8119, "second")
+	if err := second.Start(); err != nil {
+		t.Fatalf("Expected the restarted server to bind its port at once, got %v", err)
+	}
+	resp, err := client.Get("http://127.0.0.1:8119/name")
+	if err != nil {
+		t.Fatalf("Request to the restarted server failed: %v", err)
+	}
+	if string(resp.Body) != "second" {
+		t.Errorf("Expected body second, got %q", resp.Body)
+	}
+	second.Stop()
+
+	//the stopped first server can be started again and serves requests instead of closing every connection at once
+	if err := first.Start(); err != nil {
+		t.Fatalf("Failed to start the first server again: %v", err)
+	}
+	select {
+	case <-first.Closing():
+		t.Errorf("Expected Closing to be open after the server was started again")
+	default:
+	}
+	resp, err = client.Get("http://127.0.0.1:8119/name")
+	if err != nil {
+		t.Fatalf("Request to the started again server failed: %v", err)
+	}
+	if string(resp.Body) != "first" {
+		t.Errorf("Expected body first, got %q", resp.Body)
+	}
+	first.Stop()
+
+	//without SO_REUSEPORT the port is taken
+	plain := newServer(8120, "plain")
+	if err := plain.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	if err := newServer(8120, "other").Start(); err == nil {
+		t.Errorf("Expected a second server on the same port to fail without WithReusePort")
+	}
+	plain.Stop()
+
+	a := newServer(8121, "a", http.WithReusePort())
+	b := newServer(8121, "b", http.WithReusePort())
+	if err := a.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer a.Stop()
+	if err := b.Start(); err != nil {
+		t.Fatalf("Expected a second server with WithReusePort to share the port, got %v", err)
+	}
+
+	//both keep serving once the other one stops
+	b.Stop()
+	resp, err = client.Get("http://127.0.0.1:8121/name")
+	if err != nil {
+		t.Fatalf("Request after stopping one of the servers failed: %v", err)
+	}
+	if string(resp.Body) != "a" {
+		t.Errorf("Expected the remaining server to answer, got %q", resp.Body)
+	}
+}
+
+// TestHandlerFunc tests that handlers written in the net/http style produce the same responses as handlers building
+// them with NewResponse and SetBody
+func TestHandlerFunc(t *testing.T) {
+	type reading struct {
+		SensorID string  `json:"sensorId"`
+		Value    float64 `json:"value"`
+	}
+
+	tests := []struct {
+		name   string
+		newer  http.HandlerFunc
+		manual http.RequestHandler
+	}{
+		{
+			"json",
+			func(w *http.ResponseWriter, req *http.Request) {
+				if err := http.WriteJSON(w, http.StatusOK, reading{"temp-1", 21.5}); err != nil {
+					t.Errorf("Failed to write JSON: %v", err)
+				}
+			},
+			func(req *http.Request) *http.Response {
+				body, _ := json.Marshal(reading{"temp-1", 21.5})
+				resp := http.NewResponse(http.StatusOK)
+				resp.SetContentType("application/json")
+				resp.SetBody(body)
+				return resp
+			},
+		},
+		{
+			"error",
+			func(w *http.ResponseWriter, req *http.Request) {
+				http.Error(w, http.StatusNotFound, "not_found", "No data found for sensor temp-2")
+			},
+			func(req *http.Request) *http.Response {
+				return http.CreateErrorResponse(http.StatusNotFound, "not_found", "No data found for sensor temp-2")
+			},
+		},
+		{
+			"text with headers",
+			func(w *http.ResponseWriter, req *http.Request) {
+				w.SetHeader("Cache-Control", "no-store")
+				w.SetCookie("session", "abc", http.CookieOptions{Path: "/", HttpOnly: true})
+				w.WriteHeader(http.StatusUnprocessable)
+				fmt.Fprintf(w, "hello %s", req.Query["name"])
+				w.WriteHeader(http.StatusOK) //ignored, the status was written
+			},
+			func(req *http.Request) *http.Response {
+				resp := http.NewResponse(http.StatusUnprocessable)
+				resp.SetHeader("Cache-Control", "no-store")
+				resp.SetCookie("session", "abc", http.CookieOptions{Path: "/", HttpOnly: true})
+				resp.SetContentType("text/plain")
+				resp.SetBodyString("hello " + req.Query["name"])
+				return resp
+			},
+		},
+		{
+			"nothing written",
+			func(w *http.ResponseWriter, req *http.Request) {},
+			func(req *http.Request) *http.Response {
+				return http.NewResponse(http.StatusOK)
+			},
+		},
+		{
+			"status only",
+			func(w *http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			func(req *http.Request) *http.Response {
+				return http.NewResponse(http.StatusNoContent)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		server := http.ServerFactory("127.0.0.1", 0)
+		server.RegisterHandlerFunc(http.GET, "/new", tt.newer)
+		server.RegisterHandler(http.GET, "/manual", tt.manual)
+
+		//the Date header differs between two responses and the headers come in map order, everything else must be
+		//byte for byte the same
+		serve := func(path string) string {
+			mockConn := MockConnFactory([]byte("GET " + path + "?name=alice HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+			server.ServeConn(mockConn)
+			head, body, _ := strings.Cut(string(mockConn.written), "\r\n\r\n")
+			lines := strings.Split(head, "\r\n")
+			headers := slices.DeleteFunc(lines[1:], func(line string) bool { return strings.HasPrefix(line, "Date: ") })
+			slices.Sort(headers)
+			return fmt.Sprintf("%s\n%s\n\n%s", lines[0], strings.Join(headers, "\n"), body)
+		}
+
+		if newer, manual := serve("/new"), serve("/manual"); newer != manual {
+			t.Errorf("%s: expected the same response as the manual handler\n got %s\nwant %s", tt.name, newer, manual)
+		}
+	}
+
+	//the adapted handler also works with the middleware of the package
+	handler := http.BasicAuth("admin", "secret", http.AdaptHandlerFunc(func(w *http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	if resp := handler(&http.Request{Headers: map[string]string{}}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the middleware to reject the request, got %d", resp.StatusCode)
+	}
+}
+
+// TestConnectionHeader tests that every response tells the client whether the connection stays open: keep-alive
+// until the last request allowed per connection, close on that one, when the handler asks for it and when the server
+// shuts down during the request
+func TestConnectionHeader(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8123, http.WithMaxRequestsPerConn(2))
+	server.RegisterHandler(http.GET, "/ping", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("pong"))
+	})
+	server.RegisterHandler(http.GET, "/bye", func(req *http.Request) *http.Response {
+		resp := http.CreateTextResponse(http.StatusOK, []byte("bye"))
+		resp.SetHeader("connection", "Close")
+		return resp
+	})
+
+	//three pipelined requests, only two are served
+	request := "GET /ping HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	mockConn := MockConnFactory([]byte(request + request + request))
+	server.ServeConn(mockConn)
+
+	reader := bufio.NewReader(bytes.NewReader(mockConn.written))
+	for i, expected := range []string{"keep-alive", "close"} {
+		status, headers, _ := readRawResponse(t, reader)
+		if !strings.Contains(status, "200") || headers["connection"] != expected {
+			t.Errorf("Request %d: expected 200 with Connection: %s, got %q with %q", i+1, expected, status, headers["connection"])
+		}
+	}
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		t.Errorf("Expected the connection to close after 2 requests, got trailing %q", rest)
+	}
+
+	//a handler closing the connection gets exactly one Connection header
+	mockConn = MockConnFactory([]byte("GET /bye HTTP/1.1\r\nHost: localhost\r\n\r\n" + request))
+	server.ServeConn(mockConn)
+	if raw := string(mockConn.written); strings.Count(strings.ToLower(raw), "connection:") != 1 || !strings.Contains(raw, "Connection: close\r\n") {
+		t.Errorf("Expected a single Connection: close and no second response, got %q", raw)
+	}
+
+	//a request in progress when the server shuts down is answered with close
+	started, release := make(chan struct{}), make(chan struct{})
+	server.RegisterHandler(http.GET, "/slow", func(req *http.Request) *http.Response {
+		close(started)
+		<-release
+		return http.CreateTextResponse(http.StatusOK, []byte("done"))
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	waitForTCP(t, "127.0.0.1:8123", readyTimeout)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8123")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(5 * time.Second) }()
+	<-server.Closing()
+	close(release)
+
+	reader = bufio.NewReader(conn)
+	status, headers, body := readRawResponse(t, reader)
+	if !strings.Contains(status, "200") || body != "done" || headers["connection"] != "close" {
+		t.Errorf("Expected the request to finish with Connection: close, got %q with %q and body %q", status, headers["connection"], body)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the server to close the connection, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+// TestShutdownDuringRequestRead tests that a request still being read when the server shuts down is neither
+// answered nor counted as a parse error, the connection is just closed
+func TestShutdownDuringRequestRead(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8129)
+	server.RegisterHandler(http.POST, "/data", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	//Start returns once the server listens; no probe connection, so the only connection the server sees is ours
+	conn, err := net.Dial("tcp", "127.0.0.1:8129")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	//the headers are complete, the body is not
+	if _, err := conn.Write([]byte("POST /data HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\nshort")); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	deadline := time.Now().Add(readyTimeout)
+	for server.ConnStats().Active == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := server.ConnStats(); stats.Accepted != 1 || stats.Active != 1 {
+		t.Fatalf("Expected the request's connection to be served, got %d accepted and %d active", stats.Accepted, stats.Active)
+	}
+
+	if err := server.Shutdown(5 * time.Second); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	//closing with the unread rest of the request may reset the connection, either way nothing must have been sent
+	if response, _ := io.ReadAll(conn); len(response) != 0 {
+		t.Errorf("Expected the connection to be closed without a response, got %q", response)
+	}
+	if stats := server.ConnStats(); stats.ParseErrors != 0 {
+		t.Errorf("Expected no parse errors, got %d", stats.ParseErrors)
+	}
+}
+
+// TestShutdownWhileAccepting tests that Shutdown waits for the connections accepted while it closes the listener:
+// clients keep connecting during the shutdown, and once it returned no connection is accepted anymore and none is
+// still open. Run with -race, a connection added to the wait group while Shutdown waits on it is a data race
+func TestShutdownWhileAccepting(t *testing.T) {
+	server := http.ServerFactory("127.0.0.1", 8133)
+	server.RegisterHandler(http.GET, "/", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("ok"))
+	})
+
+	for round := range 500 {
+		if err := server.Start(); err != nil {
+			t.Fatalf("Round %d: failed to start server: %v", round, err)
+		}
+
+		stop := make(chan struct{})
+		var clients sync.WaitGroup
+		for range 4 {
+			clients.Add(1)
+			go func() {
+				defer clients.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					conn, err := net.Dial("tcp", "127.0.0.1:8133")
+					if err != nil {
+						continue
+					}
+					conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+					conn.Close()
+				}
+			}()
+		}
+
+		//shut down once the clients are connecting
+		accepted := server.ConnStats().Accepted
+		deadline := time.Now().Add(readyTimeout)
+		for server.ConnStats().Accepted < accepted+5 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		err := server.Shutdown(5 * time.Second)
+		stats := server.ConnStats()
+		close(stop)
+		clients.Wait()
+		if err != nil {
+			t.Fatalf("Round %d: shutdown failed: %v", round, err)
+		}
+		if stats.Active != 0 {
+			t.Fatalf("Round %d: expected no open connections after the shutdown, got %d", round, stats.Active)
+		}
+		if after := server.ConnStats().Accepted; after != stats.Accepted {
+			t.Fatalf("Round %d: %d connections were accepted after the shutdown returned", round, after-stats.Accepted)
+		}
+	}
+}
+
+// TestStreamingResponse tests that a streamed body is sent with chunked transfer encoding to HTTP/1.1 clients, so
+// the connection can be reused after it, delimited by closing the connection for HTTP/1.0 clients, gzipped while it
+// is written, and that a stream failing in the middle leaves the client with an incomplete body
+func TestStreamingResponse(t *testing.T) {
+	//a few MiB written line by line, far more writes than chunks
+	var expected strings.Builder
+	for i := range 100_000 {
+		fmt.Fprintf(&expected, "line %06d of the streamed body\n", i)
+	}
+
+	server := http.ServerFactory("127.0.0.1", 8127)
+	server.RegisterHandler(http.GET, "/stream", func(req *http.Request) *http.Response {
+		return http.NewStreamingResponse(http.StatusOK, "text/plain", func(w io.Writer) error {
+			for line := range strings.Lines(expected.String()) {
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	server.RegisterHandler(http.GET, "/broken", func(req *http.Request) *http.Response {
+		return http.NewStreamingResponse(http.StatusOK, "text/plain", func(w io.Writer) error {
+			io.WriteString(w, strings.Repeat("x", 100_000))
+			return errors.New("source failed")
+		})
+	})
+	server.RegisterHandler(http.GET, "/ping", func(req *http.Request) *http.Response {
+		return http.CreateTextResponse(http.StatusOK, []byte("pong"))
+	})
+	server.CompressionThreshold = 1024 //only applies to clients sending Accept-Encoding: gzip
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+	waitForTCP(t, "127.0.0.1:8127", readyTimeout)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", "127.0.0.1:8127")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+	readHeaders := func(reader *bufio.Reader) (string, map[string]string) {
+		t.Helper()
+		statusLine, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read status line: %v", err)
+		}
+		headers := make(map[string]string)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read header: %v", err)
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				return strings.TrimRight(statusLine, "\r\n"), headers
+			}
+			key, value, _ := strings.Cut(line, ":")
+			headers[strings.ToLower(key)] = strings.TrimSpace(value)
+		}
+	}
+	//readChunks reads a chunked body up to and including the last chunk and returns it with the number of chunks
+	readChunks := func(reader *bufio.Reader) ([]byte, int, error) {
+		var body []byte
+		for chunks := 0; ; chunks++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return body, chunks, err
+			}
+			if !strings.HasSuffix(line, "\r\n") {
+				return body, chunks, fmt.Errorf("chunk size line %q not ended by CRLF", line)
+			}
+			size, err := strconv.ParseInt(strings.TrimSuffix(line, "\r\n"), 16, 64)
+			if err != nil {
+				return body, chunks, fmt.Errorf("invalid chunk size line %q", line)
+			}
+			chunk := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return body, chunks, err
+			}
+			if string(chunk[size:]) != "\r\n" {
+				return body, chunks, fmt.Errorf("chunk of %d bytes not followed by CRLF", size)
+			}
+			if size == 0 {
+				return body, chunks, nil
+			}
+			body = append(body, chunk[:size]...)
+		}
+	}
+
+	//HTTP/1.1: chunked without Content-Length, and the connection serves the next request after the last chunk
+	conn, reader := dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	status, headers := readHeaders(reader)
+	if status != "HTTP/1.1 200 OK" || headers["transfer-encoding"] != "chunked" || headers["content-length"] != "" {
+		t.Fatalf("Expected a chunked 200 without Content-Length, got %q with %v", status, headers)
+	}
+	if headers["connection"] != "keep-alive" {
+		t.Errorf("Expected the connection to stay open after a chunked body, got Connection: %q", headers["connection"])
+	}
+	body, chunks, err := readChunks(reader)
+	if err != nil {
+		t.Fatalf("Failed to read the chunked body: %v", err)
+	}
+	if string(body) != expected.String() {
+		t.Errorf("Expected the streamed body of %d bytes, got %d bytes", expected.Len(), len(body))
+	}
+	if lines := strings.Count(expected.String(), "\n"); chunks < 2 || chunks >= lines {
+		t.Errorf("Expected the %d writes collected into a few chunks, got %d", lines, chunks)
+	}
+	conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if status, _, body := readRawResponse(t, reader); status != "HTTP/1.1 200 OK" || body != "pong" {
+		t.Errorf("Expected the next request on the connection to be answered, got %q with body %q", status, body)
+	}
+
+	//HTTP/1.0 knows no chunks, the body ends when the server closes the connection
+	conn, reader = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /stream HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	status, headers = readHeaders(reader)
+	if status != "HTTP/1.0 200 OK" || headers["transfer-encoding"] != "" || headers["connection"] != "close" {
+		t.Errorf("Expected an unframed 200 with Connection: close, got %q with %v", status, headers)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || string(rest) != expected.String() {
+		t.Errorf("Expected the streamed body up to the close, got %d bytes (%v)", len(rest), err)
+	}
+
+	//the client decodes the chunks
+	client := http.HttpClientFactory(10 * time.Second)
+	resp, err := client.Get("http://127.0.0.1:8127/stream")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if string(resp.Body) != expected.String() {
+		t.Errorf("Expected the client to return the streamed body of %d bytes, got %d bytes", expected.Len(), len(resp.Body))
+	}
+
+	//a client accepting gzip gets the stream gzipped on the fly
+	conn, reader = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	if _, headers := readHeaders(reader); headers["content-encoding"] != "gzip" || headers["transfer-encoding"] != "chunked" {
+		t.Fatalf("Expected a gzipped chunked body, got %v", headers)
+	}
+	compressed, _, err := readChunks(reader)
+	if err != nil {
+		t.Fatalf("Failed to read the chunked body: %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Body is not gzipped: %v", err)
+	}
+	if plain, err := io.ReadAll(gz); err != nil || string(plain) != expected.String() {
+		t.Errorf("Expected the gzipped body to hold the stream, got %d bytes (%v)", len(plain), err)
+	}
+	if len(compressed) >= expected.Len() {
+		t.Errorf("Expected the gzipped body to be smaller than %d bytes, got %d", expected.Len(), len(compressed))
+	}
+
+	//a failing stream cannot change the status any more, the missing last chunk and the close tell the client
+	conn, reader = dial()
+	defer conn.Close()
+	conn.Write([]byte("GET /broken HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	readHeaders(reader)
+	if _, _, err := readChunks(reader); err == nil {
+		t.Errorf("Expected the body of a failed stream to end without the last chunk")
+	}
+	if _, err := client.Get("http://127.0.0.1:8127/broken"); !errors.Is(err, http.ErrReadBody) {
+		t.Errorf("Expected ErrReadBody for a failed stream, got %v", err)
+	}
+}
+
+// TestRouteNormalization tests that methods match regardless of case and that a trailing slash is routed as
+// configured with WithTrailingSlash, while WithCaseSensitiveMethods and the strict default match exactly
+func TestRouteNormalization(t *testing.T) {
+	start := func(port int, opts ...http.ServerOption) {
+		server := http.ServerFactory("127.0.0.1", port, opts...)
+		server.RegisterHandler(http.GET, "/data", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte("data "+req.Method+" "+req.Path))
+		})
+		server.RegisterHandler("put", "/data", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte("put "+req.Method))
+		})
+		server.RegisterHandler(http.POST, "/items/", func(req *http.Request) *http.Response {
+			return http.CreateTextResponse(http.StatusOK, []byte("items "+string(req.Body)))
+		})
+		if err := server.Start(); err != nil {
+			t.Fatalf("Failed to start server: %v", err)
+		}
+		t.Cleanup(func() { server.Stop() })
+		waitForTCP(t, fmt.Sprintf("127.0.0.1:%d", port), readyTimeout)
+	}
+	start(8129)
+	start(8130, http.WithTrailingSlash(http.TrailingSlashRedirect))
+	start(8131, http.WithTrailingSlash(http.TrailingSlashMerge), http.WithCaseSensitiveMethods())
+
+	client := http.HttpClientFactory(5 * time.Second)
+	tests := []struct {
+		name     string
+		port     int
+		method   string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"lowercase method", 8129, "get", "/data", http.StatusOK, "data GET /data", ""},
+		{"mixed case method", 8129, "Get", "/data", http.StatusOK, "data GET /data", ""},
+		{"lowercase registration", 8129, "PUT", "/data", http.StatusOK, "put PUT", ""},
+		{"strict trailing slash", 8129, http.GET, "/data/", http.StatusNotFound, "", ""},
+		{"strict missing slash", 8129, http.POST, "/items", http.StatusNotFound, "", ""},
+		{"redirect to without slash", 8130, http.GET, "/data/?limit=5", http.StatusPermanentRedirect, "", "/data?limit=5"},
+		{"redirect to with slash", 8130, http.POST, "/items", http.StatusPermanentRedirect, "", "/items/"},
+		{"redirect keeps exact match", 8130, http.GET, "/data", http.StatusOK, "data GET /data", ""},
+		{"redirect without any match", 8130, http.GET, "/other/", http.StatusNotFound, "", ""},
+		{"merge without slash", 8131, http.GET, "/data/", http.StatusOK, "data GET /data", ""},
+		{"merge with slash", 8131, http.POST, "/items", http.StatusOK, "items payload", ""},
+		{"case sensitive method", 8131, "get", "/data", http.StatusNotFound, "", ""},
+		{"case sensitive registration", 8131, "PUT", "/data", http.StatusNotFound, "", ""},
+		{"case sensitive exact method", 8131, "put", "/data", http.StatusOK, "put put", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Do(tt.method, fmt.Sprintf("http://127.0.0.1:%d%s", tt.port, tt.path), []byte("payload"), nil)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, resp.StatusCode, resp.Body)
+			}
+			if tt.body != "" && string(resp.Body) != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, resp.Body)
+			}
+			if location := resp.Header("Location"); location != tt.location {
+				t.Errorf("Expected Location %q, got %q", tt.location, location)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package functional
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"strings"
+	"testing"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/logging"
+)
+
+// captureLogs redirects the standard logger into a buffer and restores the output and the level after the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previousOutput := log.Writer()
+	previousLevel := logging.CurrentLevel()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(previousOutput)
+		logging.SetLevel(previousLevel)
+	})
+	return &buf
+}
+
+// TestLogLevels tests that lines below the global level are suppressed
+func TestLogLevels(t *testing.T) {
+	buf := captureLogs(t)
+
+	logging.SetLevel(logging.LevelInfo)
+	logging.Debugf("debug line")
+	logging.Infof("info line")
+	if strings.Contains(buf.String(), "debug line") {
+		t.Errorf("Debug line written at info level: %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "info line") {
+		t.Errorf("Info line missing at info level: %q", buf.String())
+	}
+
+	buf.Reset()
+	logging.SetLevel(logging.LevelWarn)
+	logging.Infof("info line")
+	logging.Warnf("warn line")
+	if strings.Contains(buf.String(), "info line") {
+		t.Errorf("Info line written at warn level: %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "warn line") {
+		t.Errorf("Warn line missing at warn level: %q", buf.String())
+	}
+
+	buf.Reset()
+	logging.SetLevel(logging.LevelDebug)
+	logging.Debugf("debug line")
+	if !strings.Contains(buf.String(), "debug line") {
+		t.Errorf("Debug line missing at debug level: %q", buf.String())
+	}
+}
+
+// TestHotPathLoggingSuppressed tests that the per-request lines of the HTTP parser are debug lines
+func TestHotPathLoggingSuppressed(t *testing.T) {
+	buf := captureLogs(t)
+	requestStr := "GET /data HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	logging.SetLevel(logging.LevelInfo)
+	if _, err := http.ParseRequest(MockConnFactory([]byte(requestStr))); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Parsing a request logged at info level: %q", buf.String())
+	}
+
+	logging.SetLevel(logging.LevelDebug)
+	if _, err := http.ParseRequest(MockConnFactory([]byte(requestStr))); err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Request line") {
+		t.Errorf("Expected the request line at debug level, got %q", buf.String())
+	}
+}
+
+// TestParseLevel tests the level names accepted by -log-level
+func TestParseLevel(t *testing.T) {
+	tests := map[string]logging.Level{
+		"debug":   logging.LevelDebug,
+		"INFO":    logging.LevelInfo,
+		"warn":    logging.LevelWarn,
+		"warning": logging.LevelWarn,
+		" error ": logging.LevelError,
+	}
+	for value, expected := range tests {
+		level, err := logging.ParseLevel(value)
+		if err != nil {
+			t.Errorf("ParseLevel(%q) failed: %v", value, err)
+			continue
+		}
+		if level != expected {
+			t.Errorf("ParseLevel(%q) = %v, expected %v", value, level, expected)
+		}
+		if parsed, _ := logging.ParseLevel(level.String()); parsed != level {
+			t.Errorf("Level %v does not round-trip through String", level)
+		}
+	}
+
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+}
+
+// TestLogLevelFlags tests -log-level and the -v and -q shorthands
+func TestLogLevelFlags(t *testing.T) {
+	captureLogs(t)
+
+	tests := []struct {
+		args     []string
+		expected logging.Level
+	}{
+		{[]string{"-log-level", "debug"}, logging.LevelDebug},
+		{[]string{"-log-level=error"}, logging.LevelError},
+		{[]string{"-q"}, logging.LevelWarn},
+		{[]string{"-v"}, logging.LevelDebug},
+		{[]string{"-v=false"}, logging.LevelInfo},
+		{[]string{"-q", "-v"}, logging.LevelDebug},
+	}
+	for _, test := range tests {
+		logging.SetLevel(logging.LevelInfo)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		logging.RegisterFlags(fs)
+		if err := fs.Parse(test.args); err != nil {
+			t.Errorf("Parsing %v failed: %v", test.args, err)
+			continue
+		}
+		if level := logging.CurrentLevel(); level != test.expected {
+			t.Errorf("%v: level %v, expected %v", test.args, level, test.expected)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	logging.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-log-level", "loud"}); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+}
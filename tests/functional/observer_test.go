@@ -0,0 +1,82 @@
+package functional
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestDataStoredObserver tests that observers fire for direct creates and 2PC commits, but not for aborted transactions
+func TestDataStoredObserver(t *testing.T) {
+	addr, service := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	stored := make(chan types.SensorData, 10)
+	if err := service.OnDataStored(func(data types.SensorData) {
+		stored <- data
+	}); err != nil {
+		t.Fatalf("Failed to register observer: %v", err)
+	}
+
+	expect := func(sensorID string) {
+		t.Helper()
+		select {
+		case data := <-stored:
+			if data.SensorID != sensorID {
+				t.Errorf("Expected a notification for %s, got %s", sensorID, data.SensorID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No notification for %s", sensorID)
+		}
+	}
+
+	_, err := service.CreateSensorData(context.Background(), &pb.SensorDataRequest{
+		SensorId:  "observed-create",
+		Timestamp: timestamppb.Now(),
+		Value:     1.0,
+		Unit:      "test",
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	expect("observed-create")
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr, addr2})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	//nothing is stored by prepare and abort
+	transactionID, _ := tpcClient.ManualPrepare(types.SensorData{SensorID: "observed-abort", Timestamp: time.Now()})
+	tpcClient.ManualAbort(transactionID)
+
+	err = tpcClient.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "observed-commit", Timestamp: time.Now(), Value: 2.0, Unit: "test"})
+	if err != nil {
+		t.Fatalf("2PC transaction failed: %v", err)
+	}
+	expect("observed-commit")
+
+	select {
+	case data := <-stored:
+		t.Errorf("Unexpected notification for %s", data.SensorID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestDataStoredObserverAfterStop tests that a stopped service refuses new observers instead of leaking their goroutine
+func TestDataStoredObserverAfterStop(t *testing.T) {
+	_, service, stop := startStoppableTestDatabase(t, "127.0.0.1:0", 100)
+	stop()
+
+	if err := service.OnDataStored(func(types.SensorData) {}); !errors.Is(err, database.ErrServiceStopped) {
+		t.Errorf("Expected ErrServiceStopped after Stop, got %v", err)
+	}
+}
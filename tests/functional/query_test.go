@@ -0,0 +1,303 @@
+package functional
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestPrefixQuery tests the prefix query RPC including the empty result and FIFO eviction
+func TestPrefixQuery(t *testing.T) {
+	addr, _ := startTestDatabase(t, 3)
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	for _, sensorID := range []string{"temp-1", "temp-2", "humid-1"} {
+		err = client.AddDataPoint(types.SensorData{SensorID: sensorID, Timestamp: time.Now(), Value: 1.0, Unit: "test"})
+		if err != nil {
+			t.Fatalf("Failed to add data point: %v", err)
+		}
+	}
+
+	data, err := client.GetDataPointsByPrefix("temp-")
+	if err != nil {
+		t.Fatalf("Prefix query failed: %v", err)
+	}
+	if len(data) != 2 {
+		t.Errorf("Expected 2 points for prefix temp-, got %d", len(data))
+	}
+
+	data, err = client.GetDataPointsByPrefix("press-")
+	if err != nil {
+		t.Fatalf("Prefix query failed: %v", err)
+	}
+	if data == nil || len(data) != 0 {
+		t.Errorf("Expected an empty, non-nil list for an unknown prefix, got %v", data)
+	}
+
+	//the limit is 3, so this evicts temp-1
+	err = client.AddDataPoint(types.SensorData{SensorID: "press-1", Timestamp: time.Now(), Value: 1.0, Unit: "test"})
+	if err != nil {
+		t.Fatalf("Failed to add data point: %v", err)
+	}
+
+	data, err = client.GetDataPointsByPrefix("temp-")
+	if err != nil {
+		t.Fatalf("Prefix query failed: %v", err)
+	}
+	if len(data) != 1 || data[0].SensorID != "temp-2" {
+		t.Errorf("Expected only temp-2 after eviction, got %v", data)
+	}
+
+	log.Println("Prefix query test passed")
+}
+
+// TestBulkIdsQuery tests the grouped query for several sensor IDs including empty groups and the ID cap
+func TestBulkIdsQuery(t *testing.T) {
+	addr, _ := startTestDatabase(t, 100)
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	for _, sensorID := range []string{"bulk-temp-1", "bulk-temp-1", "bulk-humid-1", "bulk-press-1"} {
+		err = client.AddDataPoint(types.SensorData{SensorID: sensorID, Timestamp: time.Now(), Value: 1.0, Unit: "test"})
+		if err != nil {
+			t.Fatalf("Failed to add data point: %v", err)
+		}
+	}
+
+	groups, err := client.GetDataPointsByIds([]string{"bulk-temp-1", "bulk-humid-1", "bulk-missing"})
+	if err != nil {
+		t.Fatalf("Bulk query failed: %v", err)
+	}
+
+	if len(groups) != 3 {
+		t.Errorf("Expected 3 groups, got %d", len(groups))
+	}
+	if len(groups["bulk-temp-1"]) != 2 || len(groups["bulk-humid-1"]) != 1 {
+		t.Errorf("Unexpected group sizes: temp %d, humid %d", len(groups["bulk-temp-1"]), len(groups["bulk-humid-1"]))
+	}
+	if group, ok := groups["bulk-missing"]; !ok || len(group) != 0 {
+		t.Errorf("Expected an empty group for an unknown ID, got %v (present: %v)", group, ok)
+	}
+
+	tooMany := make([]string, database.MaxSensorIdsPerRequest+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("bulk-%d", i)
+	}
+	if _, err := client.GetDataPointsByIds(tooMany); err == nil {
+		t.Errorf("Expected an error for more than %d IDs", database.MaxSensorIdsPerRequest)
+	}
+}
+
+// TestJSONAdapter tests calling the RPCs of a database as JSON over HTTP
+func TestJSONAdapter(t *testing.T) {
+	_, service := startTestDatabase(t, 100)
+
+	server := http.ServerFactory("localhost", 8106)
+	database.RegisterJSONHandlers(server, service)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start JSON adapter: %v", err)
+	}
+	defer server.Stop()
+
+	waitForTCP(t, "localhost:8106", readyTimeout)
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8106/rpc/CreateSensorData", []byte(`{"sensorId":"json-1","timestamp":"2025-06-01T12:00:00Z","value":21.5,"unit":"°C"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	var created struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(resp.Body, &created); err != nil || !created.Success {
+		t.Fatalf("Expected a successful OperationResponse, got %s (%v)", resp.Body, err)
+	}
+
+	resp, err = client.PostJSON("http://localhost:8106/rpc/GetSensorDataBySensorId", []byte(`{"sensorId":"json-1"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var list struct {
+		Data []struct {
+			SensorID  string  `json:"sensorId"`
+			Timestamp string  `json:"timestamp"`
+			Value     float64 `json:"value"`
+			Unit      string  `json:"unit"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &list); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", resp.Body, err)
+	}
+	if len(list.Data) != 1 || list.Data[0].Value != 21.5 || list.Data[0].Unit != "°C" || list.Data[0].Timestamp != "2025-06-01T12:00:00Z" {
+		t.Errorf("Expected the stored reading, got %s", resp.Body)
+	}
+
+	//an empty body is the empty request
+	resp, err = client.PostJSON("http://localhost:8106/rpc/GetAllSensorData", nil)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(resp.Body), "json-1") {
+		t.Errorf("Expected all data for an empty body, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	tests := []struct {
+		path     string
+		body     string
+		expected int
+		code     string
+	}{
+		{"/rpc/NoSuchMethod", `{}`, http.StatusNotFound, "unknown_method"},
+		{"/rpc/CreateSensorData", `{"sensorId":`, http.StatusBadRequest, "invalid_json"},
+		{"/rpc/CreateSensorData", `{"noSuchField":1}`, http.StatusBadRequest, "invalid_json"},
+	}
+	for _, test := range tests {
+		resp, err := client.PostJSON("http://localhost:8106"+test.path, []byte(test.body))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != test.expected || !strings.Contains(string(resp.Body), test.code) {
+			t.Errorf("POST %s %s: expected %d %s, got %d: %s", test.path, test.body, test.expected, test.code, resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// TestIngestedAt tests that the database stamps every reading with its own ingestion time and that the HTTP queries
+// can filter by it instead of by the sensor timestamp
+func TestIngestedAt(t *testing.T) {
+	addr1, service := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8108
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	//the sensor timestamp lies in the past, and the ingestion time sent along has to be ignored
+	before := time.Now()
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8108/data", []byte(`{"sensorId":"ingest-1","timestamp":"2025-06-01T12:00:00Z","value":21.5,"unit":"°C","ingestedAt":"2000-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+	after := time.Now()
+
+	list, err := service.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "ingest-1"})
+	if err != nil {
+		t.Fatalf("Failed to read database: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].IngestedAt == nil {
+		t.Fatalf("Expected one reading with an ingestion time, got %v", list.Data)
+	}
+	if ingestedAt := list.Data[0].IngestedAt.AsTime(); ingestedAt.Before(before) || ingestedAt.After(after) {
+		t.Errorf("Expected the ingestion time between %v and %v, got %v", before, after, ingestedAt)
+	}
+
+	from := before.UTC().Format(time.RFC3339Nano)
+	tests := []struct {
+		query    string
+		expected int
+		count    int
+	}{
+		{"?from=" + from, http.StatusOK, 0},               //the sensor timestamp is older
+		{"?by=ingestedAt&from=" + from, http.StatusOK, 1}, //but the reading arrived after before
+		{"?by=ingestedAt&to=2025-06-01T12:00:00Z", http.StatusOK, 0},
+		{"?by=arrival&from=" + from, http.StatusBadRequest, 0},
+		{"?from=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		resp, err := client.Get("http://localhost:8108/data/ingest-1" + test.query)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode != test.expected {
+			t.Errorf("GET %s: expected status %d, got %d: %s", test.query, test.expected, resp.StatusCode, resp.Body)
+			continue
+		}
+		if test.expected != http.StatusOK {
+			continue
+		}
+		var data []types.SensorData
+		if err := json.Unmarshal(resp.Body, &data); err != nil {
+			t.Fatalf("GET %s: failed to decode response %s: %v", test.query, resp.Body, err)
+		}
+		if len(data) != test.count {
+			t.Errorf("GET %s: expected %d readings, got %s", test.query, test.count, resp.Body)
+		}
+	}
+}
+
+// TestUnitConversionQuery tests that GET /data/{sensorId}?unit= converts the returned values without touching the
+// stored readings and rejects a unit of another quantity
+func TestUnitConversionQuery(t *testing.T) {
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8114
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.PostJSON("http://localhost:8114/data", []byte(`{"sensorId":"unit-1","value":21.5,"unit":"°C"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to store reading: %v %v", resp, err)
+	}
+
+	get := func(query string) (*http.Response, []types.SensorData) {
+		t.Helper()
+		resp, err := client.Get("http://localhost:8114/data/unit-1" + query)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var readings []types.SensorData
+		if resp.StatusCode == http.StatusOK {
+			if err := json.Unmarshal(resp.Body, &readings); err != nil {
+				t.Fatalf("Failed to parse readings: %v: %s", err, resp.Body)
+			}
+		}
+		return resp, readings
+	}
+
+	resp, readings := get("?unit=%C2%B0F")
+	if resp.StatusCode != http.StatusOK || len(readings) != 1 || readings[0].Value != 70.7 || readings[0].Unit != "°F" {
+		t.Errorf("Expected the reading as 70.7 °F, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	resp, _ = get("?unit=hPa")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(resp.Body), "incompatible_unit") {
+		t.Errorf("Expected 400 incompatible_unit for °C to hPa, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	//the conversion is only for the response, the stored reading keeps its unit
+	resp, readings = get("")
+	if resp.StatusCode != http.StatusOK || len(readings) != 1 || readings[0].Value != 21.5 || readings[0].Unit != "°C" {
+		t.Errorf("Expected the stored reading unchanged, got %d: %s", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,353 @@
+package functional
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestDecodeSensorDataList tests decoding of both single-object and burst (array) payloads
+func TestDecodeSensorDataList(t *testing.T) {
+	single := []byte(`{"sensorId":"temp-1","timestamp":"2025-06-01T12:00:00Z","value":21.5,"unit":"°C"}`)
+
+	readings, err := types.DecodeSensorDataList(single)
+	if err != nil {
+		t.Fatalf("Failed to decode single object: %v", err)
+	}
+	if len(readings) != 1 {
+		t.Fatalf("Expected 1 reading, got %d", len(readings))
+	}
+	if readings[0].SensorID != "temp-1" || readings[0].Value != 21.5 {
+		t.Errorf("Unexpected reading decoded: %+v", readings[0])
+	}
+
+	burst := []byte(` [
+		{"sensorId":"temp-1","timestamp":"2025-06-01T12:00:00Z","value":21.5,"unit":"°C"},
+		{"sensorId":"temp-1","timestamp":"2025-06-01T12:00:00.5Z","value":21.7,"unit":"°C"}
+	]`)
+
+	readings, err = types.DecodeSensorDataList(burst)
+	if err != nil {
+		t.Fatalf("Failed to decode array: %v", err)
+	}
+	if len(readings) != 2 {
+		t.Fatalf("Expected 2 readings, got %d", len(readings))
+	}
+	if !readings[0].Timestamp.Before(readings[1].Timestamp) {
+		t.Errorf("Expected burst timestamps to be preserved in order")
+	}
+
+	_, err = types.DecodeSensorDataList([]byte(`{"sensorId":`))
+	if err == nil {
+		t.Errorf("Expected an error for malformed JSON")
+	}
+
+	log.Println("Sensor data list decoding test passed")
+}
+
+// TestSensorDataTimestampFormats tests that a reading decodes to the same values with an RFC 3339 and with an epoch
+// milliseconds timestamp, and that both formats marshal back to the instant they were decoded from
+func TestSensorDataTimestampFormats(t *testing.T) {
+	rfc3339 := []byte(`{"sensorId":"temp-1","timestamp":"2025-06-01T12:00:00.123Z","value":21.5,"unit":"°C"}`)
+	millis := []byte(`{"sensorId":"temp-1","timestamp":1748779200123,"value":21.5,"unit":"°C"}`)
+
+	var fromRFC3339, fromMillis types.SensorData
+	if err := json.Unmarshal(rfc3339, &fromRFC3339); err != nil {
+		t.Fatalf("Failed to decode RFC 3339 timestamp: %v", err)
+	}
+	if err := json.Unmarshal(millis, &fromMillis); err != nil {
+		t.Fatalf("Failed to decode epoch millis timestamp: %v", err)
+	}
+	if fromRFC3339 != fromMillis {
+		t.Errorf("Expected identical readings, got %+v and %+v", fromRFC3339, fromMillis)
+	}
+
+	//bursts go through the same decoding
+	burst, err := types.DecodeSensorDataList([]byte(`[` + string(rfc3339) + `,` + string(millis) + `]`))
+	if err != nil || len(burst) != 2 || burst[0] != burst[1] {
+		t.Errorf("Expected a burst of two identical readings, got %+v (%v)", burst, err)
+	}
+
+	for _, format := range []types.ValueFormat{types.DefaultValueFormat, {Precision: -1, EpochMillis: true}} {
+		data, err := json.Marshal(types.FormattedSensorData{SensorData: fromMillis, Format: format})
+		if err != nil {
+			t.Fatalf("%s: failed to marshal: %v", format, err)
+		}
+		var decoded types.SensorData
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != fromRFC3339 {
+			t.Errorf("%s: expected %s to decode to %+v, got %+v (%v)", format, data, fromRFC3339, decoded, err)
+		}
+		if format.EpochMillis && !strings.Contains(string(data), `"timestamp":1748779200123,`) {
+			t.Errorf("Expected the timestamp as epoch millis, got %s", data)
+		}
+	}
+
+	//a missing timestamp stays zero, so the server still fills in the time of receipt
+	var withoutTimestamp types.SensorData
+	if err := json.Unmarshal([]byte(`{"sensorId":"temp-1","value":1}`), &withoutTimestamp); err != nil || !withoutTimestamp.Timestamp.IsZero() {
+		t.Errorf("Expected a zero timestamp, got %v (%v)", withoutTimestamp.Timestamp, err)
+	}
+
+	for _, invalid := range []string{`1748779200123.5`, `"yesterday"`, `true`} {
+		var reading types.SensorData
+		if err := json.Unmarshal([]byte(`{"sensorId":"temp-1","timestamp":`+invalid+`,"value":1}`), &reading); err == nil {
+			t.Errorf("Expected timestamp %s to be rejected", invalid)
+		}
+	}
+}
+
+// TestSensorDataEqual tests that equality compares all fields and treats the same instant as equal regardless of
+// location and monotonic clock reading
+func TestSensorDataEqual(t *testing.T) {
+	now := time.Now() //carries a monotonic clock reading
+	reading := types.SensorData{SensorID: "temp-1", Timestamp: now, Value: 21.5, Unit: "°C"}
+
+	//a JSON round trip drops the monotonic reading, == on time.Time would report a difference here
+	jsonData, err := json.Marshal(reading)
+	if err != nil {
+		t.Fatalf("Failed to marshal reading: %v", err)
+	}
+	var roundTripped types.SensorData
+	if err := json.Unmarshal(jsonData, &roundTripped); err != nil {
+		t.Fatalf("Failed to unmarshal reading: %v", err)
+	}
+	if roundTripped == reading {
+		t.Fatalf("Expected == to see a difference after the round trip, the test no longer covers the subtlety")
+	}
+	if !reading.Equal(roundTripped) {
+		t.Errorf("Expected readings to be equal after a JSON round trip")
+	}
+
+	inUTC := reading
+	inUTC.Timestamp = now.UTC()
+	if !reading.Equal(inUTC) {
+		t.Errorf("Expected the same instant in UTC to be equal")
+	}
+
+	changes := map[string]func(*types.SensorData){
+		"sensor ID": func(d *types.SensorData) { d.SensorID = "temp-2" },
+		"timestamp": func(d *types.SensorData) { d.Timestamp = d.Timestamp.Add(time.Nanosecond) },
+		"value":     func(d *types.SensorData) { d.Value = 21.6 },
+		"unit":      func(d *types.SensorData) { d.Unit = "K" },
+	}
+	for field, change := range changes {
+		other := reading
+		change(&other)
+		if reading.Equal(other) {
+			t.Errorf("Expected readings with a different %s not to be equal", field)
+		}
+	}
+}
+
+// TestSensorDataRounded tests rounding of reading values to a number of decimal places
+func TestSensorDataRounded(t *testing.T) {
+	tests := []struct {
+		value    float64
+		decimals int
+		expected float64
+	}{
+		{21.4567, 1, 21.5},
+		{21.4567, 2, 21.46},
+		{21.4567, 0, 21},
+		{-3.25, 1, -3.3}, //half away from zero
+		{998.95, 0, 999},
+		{0.1 + 0.2, 2, 0.3},
+		{21.4567, -1, 21.4567}, //negative keeps full precision
+	}
+
+	for _, tt := range tests {
+		reading := types.SensorData{SensorID: "temp-1", Timestamp: time.Now(), Value: tt.value, Unit: "°C"}
+		rounded := reading.Rounded(tt.decimals)
+		if rounded.Value != tt.expected {
+			t.Errorf("Rounded(%v, %d): expected %v, got %v", tt.value, tt.decimals, tt.expected, rounded.Value)
+		}
+		if rounded.SensorID != reading.SensorID || !rounded.Timestamp.Equal(reading.Timestamp) || rounded.Unit != reading.Unit {
+			t.Errorf("Rounded(%v, %d) changed more than the value: %+v", tt.value, tt.decimals, rounded)
+		}
+		if reading.Value != tt.value {
+			t.Errorf("Rounded(%v, %d) modified the original reading", tt.value, tt.decimals)
+		}
+	}
+
+	//the rounded value also marshals without a long tail of decimals
+	jsonData, err := json.Marshal(types.SensorData{Value: 21.456789}.Rounded(1))
+	if err != nil {
+		t.Fatalf("Failed to marshal reading: %v", err)
+	}
+	if !strings.Contains(string(jsonData), `"value":21.5,`) {
+		t.Errorf("Expected the JSON to contain the rounded value, got %s", jsonData)
+	}
+}
+
+// TestNewSensorData tests the defaults, options and validation of the SensorData constructor
+func TestNewSensorData(t *testing.T) {
+	before := time.Now()
+	reading, err := types.NewSensorData("temp-1", 21.5)
+	if err != nil {
+		t.Fatalf("Failed to build reading: %v", err)
+	}
+	if reading.SensorID != "temp-1" || reading.Value != 21.5 || reading.Unit != "" {
+		t.Errorf("Unexpected reading: %+v", reading)
+	}
+	if reading.Timestamp.Before(before) || reading.Timestamp.After(time.Now()) {
+		t.Errorf("Expected the timestamp to default to now, got %v", reading.Timestamp)
+	}
+
+	timestamp := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	reading, err = types.NewSensorData("temp-1", 21.5, types.WithUnit("°C"), types.WithTimestamp(timestamp))
+	if err != nil {
+		t.Fatalf("Failed to build reading: %v", err)
+	}
+	if reading.Unit != "°C" || !reading.Timestamp.Equal(timestamp) {
+		t.Errorf("Expected the unit and timestamp of the options, got %+v", reading)
+	}
+
+	//a zero timestamp keeps the default, like a reading posted without one
+	reading, err = types.NewSensorData("temp-1", 21.5, types.WithTimestamp(time.Time{}))
+	if err != nil || reading.Timestamp.IsZero() {
+		t.Errorf("Expected a zero timestamp to default to now, got %+v (%v)", reading, err)
+	}
+
+	if _, err := types.NewSensorData("", 1); !errors.Is(err, types.ErrMissingSensorID) {
+		t.Errorf("Expected ErrMissingSensorID, got %v", err)
+	}
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := types.NewSensorData("temp-1", value); err == nil {
+			t.Errorf("Expected value %v to be rejected", value)
+		}
+	}
+
+	if err := (types.SensorData{SensorID: "temp-1", Value: 1}).Validate(); err == nil {
+		t.Errorf("Expected a reading without a timestamp to be invalid")
+	}
+}
+
+// TestConvertUnit tests the conversions between units of the same quantity and the rejection of all others
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		expected float64
+	}{
+		{21.5, "°C", "°F", 70.7},
+		{70.7, "°F", "°C", 21.5},
+		{-40, "°C", "°F", -40},
+		{0, "°C", "K", 273.15},
+		{1013.2, "hPa", "kPa", 101.32},
+		{101.32, "kPa", "hPa", 1013.2},
+		{1013.2, "hPa", "mbar", 1013.2},
+		{55, "%", "%", 55}, //the same unit needs no conversion, even if it is not known
+	}
+	for _, tt := range tests {
+		converted, err := types.ConvertUnit(tt.value, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("ConvertUnit(%v, %s, %s) failed: %v", tt.value, tt.from, tt.to, err)
+			continue
+		}
+		if converted != tt.expected {
+			t.Errorf("ConvertUnit(%v, %s, %s): expected %v, got %v", tt.value, tt.from, tt.to, tt.expected, converted)
+		}
+	}
+
+	for _, pair := range [][2]string{{"°C", "hPa"}, {"%", "°F"}, {"°C", "furlong"}} {
+		if _, err := types.ConvertUnit(1, pair[0], pair[1]); !errors.Is(err, types.ErrIncompatibleUnits) {
+			t.Errorf("ConvertUnit(1, %s, %s): expected ErrIncompatibleUnits, got %v", pair[0], pair[1], err)
+		}
+	}
+
+	reading := types.SensorData{SensorID: "temp-1", Timestamp: time.Now(), Value: 21.5, Unit: "°C"}
+	converted, err := reading.ConvertedTo("°F")
+	if err != nil || converted.Value != 70.7 || converted.Unit != "°F" || !converted.Timestamp.Equal(reading.Timestamp) {
+		t.Errorf("Expected the reading in °F, got %+v, %v", converted, err)
+	}
+	if reading.Value != 21.5 || reading.Unit != "°C" {
+		t.Errorf("ConvertedTo modified the original reading: %+v", reading)
+	}
+}
+
+// TestMergeSensorData tests the union of two replicas' readings for overlapping, disjoint and empty lists
+func TestMergeSensorData(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(sensorID string, minute int, value float64) types.SensorData {
+		return types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(minute) * time.Minute), Value: value, Unit: "°C"}
+	}
+
+	//the same instant in another location is the same reading, the value of a wins
+	shifted := reading("temp-1", 1, 99)
+	shifted.Timestamp = shifted.Timestamp.In(time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name     string
+		a, b     []types.SensorData
+		expected []types.SensorData
+	}{
+		{"overlap", []types.SensorData{reading("temp-2", 0, 1), reading("temp-1", 1, 2)}, []types.SensorData{shifted, reading("temp-1", 0, 3)},
+			[]types.SensorData{reading("temp-1", 0, 3), reading("temp-1", 1, 2), reading("temp-2", 0, 1)}},
+		{"disjoint", []types.SensorData{reading("temp-1", 2, 1)}, []types.SensorData{reading("temp-1", 1, 2)},
+			[]types.SensorData{reading("temp-1", 1, 2), reading("temp-1", 2, 1)}},
+		{"duplicates within a list", []types.SensorData{reading("temp-1", 0, 1), reading("temp-1", 0, 2)}, nil,
+			[]types.SensorData{reading("temp-1", 0, 1)}},
+		{"one empty", nil, []types.SensorData{reading("temp-1", 0, 1)}, []types.SensorData{reading("temp-1", 0, 1)}},
+		{"both empty", nil, []types.SensorData{}, []types.SensorData{}},
+	}
+
+	for _, tt := range tests {
+		merged := types.MergeSensorData(tt.a, tt.b)
+		if merged == nil || !slices.EqualFunc(merged, tt.expected, types.SensorData.Equal) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, merged)
+		}
+	}
+}
+
+// TestDiffSensorData tests finding the readings only one of two replicas holds
+func TestDiffSensorData(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(sensorID string, minute int, value float64) types.SensorData {
+		return types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(minute) * time.Minute), Value: value, Unit: "°C"}
+	}
+
+	tests := []struct {
+		name         string
+		a, b         []types.SensorData
+		onlyA, onlyB []types.SensorData
+	}{
+		//a differing value of the same reading is no difference
+		{"overlap", []types.SensorData{reading("temp-2", 0, 1), reading("temp-1", 0, 2), reading("temp-1", 1, 3)}, []types.SensorData{reading("temp-1", 1, 4), reading("temp-3", 0, 5)},
+			[]types.SensorData{reading("temp-1", 0, 2), reading("temp-2", 0, 1)}, []types.SensorData{reading("temp-3", 0, 5)}},
+		{"disjoint", []types.SensorData{reading("temp-1", 0, 1), reading("temp-1", 0, 1)}, []types.SensorData{reading("temp-1", 1, 2)},
+			[]types.SensorData{reading("temp-1", 0, 1)}, []types.SensorData{reading("temp-1", 1, 2)}},
+		{"identical", []types.SensorData{reading("temp-1", 0, 1)}, []types.SensorData{reading("temp-1", 0, 1)},
+			[]types.SensorData{}, []types.SensorData{}},
+		{"one empty", []types.SensorData{reading("temp-1", 0, 1)}, nil, []types.SensorData{reading("temp-1", 0, 1)}, []types.SensorData{}},
+		{"both empty", nil, nil, []types.SensorData{}, []types.SensorData{}},
+	}
+
+	for _, tt := range tests {
+		onlyA, onlyB := types.DiffSensorData(tt.a, tt.b)
+		if !slices.EqualFunc(onlyA, tt.onlyA, types.SensorData.Equal) || !slices.EqualFunc(onlyB, tt.onlyB, types.SensorData.Equal) {
+			t.Errorf("%s: expected %v and %v, got %v and %v", tt.name, tt.onlyA, tt.onlyB, onlyA, onlyB)
+		}
+	}
+}
+
+// TestSensorRound tests that readings are rounded to the precision of their sensor type, and kept as they are if the
+// sensor type has none
+func TestSensorRound(t *testing.T) {
+	reading := types.SensorData{SensorID: "custom-1", Timestamp: time.Now(), Value: 21.4567, Unit: "test"}
+
+	if rounded := (types.Sensor{ID: "custom"}).Round(reading); rounded.Value != 21.4567 {
+		t.Errorf("Expected a sensor without precision to keep 21.4567, got %v", rounded.Value)
+	}
+
+	precision := 0
+	if rounded := (types.Sensor{ID: "custom", Precision: &precision}).Round(reading); rounded.Value != 21 {
+		t.Errorf("Expected a sensor with precision 0 to round to 21, got %v", rounded.Value)
+	}
+}
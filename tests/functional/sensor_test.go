@@ -0,0 +1,341 @@
+package functional
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/sensor"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/mqttconfig"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestSensorStats tests the publish statistics of the sensor manager after a short simulated run against a fake broker
+func TestSensorStats(t *testing.T) {
+	run := func(t *testing.T, client *fakeMQTTClient) []sensor.SensorStats {
+		t.Helper()
+		manager := sensor.NewSensorManager("unused:1883", 2, 0, 3, 0, 1, false)
+		manager.Sensors = []types.Sensor{{ID: "stats", Name: "Stats Sensor", MinValue: 0, MaxValue: 100, Unit: "test", DataGenerationInterval: 10}}
+		manager.NewMQTTClient = func(*mqtt.ClientOptions) mqtt.Client { return client }
+
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Failed to start sensor manager: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		manager.Stop()
+		manager.Stop() //e.g. the run duration ended and a signal arrived, a second Stop must not panic
+
+		stats := manager.Stats()
+		if len(stats) != 2 || stats[0].SensorID != "stats-1" || stats[1].SensorID != "stats-2" {
+			t.Fatalf("Expected stats for stats-1 and stats-2, got %+v", stats)
+		}
+
+		var sum sensor.SensorStats
+		for _, s := range stats {
+			sum.Messages += s.Messages
+			sum.Errors += s.Errors
+		}
+		if total := manager.TotalStats(); total.Messages != sum.Messages || total.Errors != sum.Errors {
+			t.Errorf("Expected the totals %+v to add up the sensors %+v", total, stats)
+		}
+		return stats
+	}
+
+	t.Run("Published", func(t *testing.T) {
+		for _, stats := range run(t, &fakeMQTTClient{}) {
+			//a 10ms interval gives about 20 ticks, leave plenty of room for a slow machine
+			if stats.Messages < 5 {
+				t.Errorf("%s: expected at least 5 messages, got %d", stats.SensorID, stats.Messages)
+			}
+			if stats.Published != 3*stats.Messages {
+				t.Errorf("%s: expected 3 readings per burst message, got %d readings in %d messages", stats.SensorID, stats.Published, stats.Messages)
+			}
+			if stats.Errors != 0 || stats.Dropped != 0 {
+				t.Errorf("%s: expected no errors or drops, got %+v", stats.SensorID, stats)
+			}
+		}
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		for _, stats := range run(t, &fakeMQTTClient{publishErr: errors.New("broker rejected publish")}) {
+			if stats.Errors < 5 || stats.Messages != 0 || stats.Published != 0 {
+				t.Errorf("%s: expected only failed publishes, got %+v", stats.SensorID, stats)
+			}
+			//a rejected publish is not retried, so all of its readings are dropped
+			if stats.Dropped != 3*stats.Errors || stats.Timeouts != 0 {
+				t.Errorf("%s: expected 3 dropped readings per error and no timeouts, got %+v", stats.SensorID, stats)
+			}
+		}
+	})
+}
+
+// TestSensorMQTTSecurity tests that the sensor manager connects with the configured credentials and CA, and that an
+// incomplete TLS setup is rejected before connecting
+func TestSensorMQTTSecurity(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	start := func(broker string, security mqttconfig.Security) (*mqtt.ClientOptions, error) {
+		t.Helper()
+		var options *mqtt.ClientOptions
+		manager := sensor.NewSensorManager(broker, 1, 0, 1, 0, 1, false)
+		manager.Sensors = []types.Sensor{{ID: "secure", Name: "Secure Sensor", MinValue: 0, MaxValue: 100, Unit: "test", DataGenerationInterval: 1000}}
+		manager.MQTTSecurity = security
+		manager.NewMQTTClient = func(opts *mqtt.ClientOptions) mqtt.Client {
+			options = opts
+			return &fakeMQTTClient{}
+		}
+		err := manager.Start()
+		manager.Stop()
+		return options, err
+	}
+
+	options, err := start("tls://broker:8883", mqttconfig.Security{Username: "sensor", Password: "secret", CAFile: caFile})
+	if err != nil {
+		t.Fatalf("Failed to start sensor manager: %v", err)
+	}
+	if len(options.Servers) != 1 || options.Servers[0].Scheme != "tls" || options.Servers[0].Host != "broker:8883" {
+		t.Errorf("Expected the tls:// broker, got %v", options.Servers)
+	}
+	if options.Username != "sensor" || options.Password != "secret" {
+		t.Errorf("Expected the configured credentials, got %q/%q", options.Username, options.Password)
+	}
+	if options.TLSConfig == nil || options.TLSConfig.RootCAs == nil || !options.TLSConfig.RootCAs.Equal(rootsOf(t, der)) {
+		t.Errorf("Expected the CA file as the only root, got %+v", options.TLSConfig)
+	}
+
+	//the password may come from the environment so it does not show up in the process list
+	t.Setenv(mqttconfig.PasswordEnv, "from-env")
+	options, err = start("broker:1883", mqttconfig.Security{Username: "sensor"})
+	if err != nil {
+		t.Fatalf("Failed to start sensor manager: %v", err)
+	}
+	if options.Servers[0].Scheme != "tcp" || options.Password != "from-env" || options.TLSConfig != nil {
+		t.Errorf("Expected plain TCP with the password from the environment, got %v %q %+v", options.Servers, options.Password, options.TLSConfig)
+	}
+
+	if _, err := start("ssl://broker:8883", mqttconfig.Security{}); err == nil {
+		t.Errorf("Expected a TLS broker without a CA file to be rejected")
+	}
+}
+
+// rootsOf returns a pool holding only the given certificate
+func rootsOf(t *testing.T, der []byte) *x509.CertPool {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+// TestSensorProfile tests the seasonality and anomaly windows of a value profile and how the simulator applies them
+func TestSensorProfile(t *testing.T) {
+	profile := &sensor.Profile{
+		Seasonality: &sensor.Seasonality{Period: 4 * time.Hour, Amplitude: 10},
+		Anomalies:   []sensor.Anomaly{{Start: time.Minute, Duration: time.Minute, Magnitude: 20}},
+	}
+
+	seasonal := map[time.Duration]float64{0: -10, time.Hour: 0, 2 * time.Hour: 10, 4 * time.Hour: -10}
+	for elapsed, expected := range seasonal {
+		if offset := profile.SeasonalOffset(elapsed); math.Abs(offset-expected) > 1e-9 {
+			t.Errorf("Seasonal offset after %v: expected %v, got %v", elapsed, expected, offset)
+		}
+	}
+
+	anomaly := map[time.Duration]float64{59 * time.Second: 0, time.Minute: 20, 90 * time.Second: 10, 2 * time.Minute: 0}
+	for elapsed, expected := range anomaly {
+		if offset := profile.AnomalyOffset(elapsed); math.Abs(offset-expected) > 1e-9 {
+			t.Errorf("Anomaly offset after %v: expected %v, got %v", elapsed, expected, offset)
+		}
+	}
+	if profile.ActiveAnomaly(90*time.Second) != 0 || profile.ActiveAnomaly(2*time.Minute) != -1 {
+		t.Errorf("Expected the anomaly window to cover [1m, 2m)")
+	}
+
+	var none *sensor.Profile
+	if none.SeasonalOffset(time.Hour) != 0 || none.AnomalyOffset(time.Hour) != 0 || none.ActiveAnomaly(time.Hour) != -1 {
+		t.Errorf("Expected a missing profile to change nothing")
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		dir := t.TempDir()
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("Failed to write profile file: %v", err)
+			}
+			return path
+		}
+
+		profiles, err := sensor.LoadProfiles(write("valid.yaml", "temp:\n  seasonality:\n    period: 10m\n    amplitude: 8\ntemp-1:\n  anomalies:\n    - start: 2m\n      duration: 30s\n      magnitude: 25\n"))
+		if err != nil {
+			t.Fatalf("Failed to load profiles: %v", err)
+		}
+		if seasonality := profiles["temp"].Seasonality; seasonality == nil || seasonality.Period != 10*time.Minute || seasonality.Amplitude != 8 {
+			t.Errorf("Unexpected seasonality for temp: %+v", profiles["temp"])
+		}
+		if anomalies := profiles["temp-1"].Anomalies; len(anomalies) != 1 || anomalies[0] != (sensor.Anomaly{Start: 2 * time.Minute, Duration: 30 * time.Second, Magnitude: 25}) {
+			t.Errorf("Unexpected anomalies for temp-1: %+v", anomalies)
+		}
+
+		for name, content := range map[string]string{
+			"unknown.yaml":  "temp:\n  amplitude: 8\n",
+			"period.yaml":   "temp:\n  seasonality:\n    amplitude: 8\n",
+			"duration.yaml": "temp:\n  anomalies:\n    - start: 1m\n      magnitude: 5\n",
+		} {
+			if _, err := sensor.LoadProfiles(write(name, content)); err == nil {
+				t.Errorf("Expected %s to be rejected", name)
+			}
+		}
+	})
+
+	t.Run("Simulation", func(t *testing.T) {
+		logs := captureLogs(t)
+		client := &fakeMQTTClient{}
+		manager := sensor.NewSensorManager("unused:1883", 2, 0, 1, 0, 1, false)
+		manager.Sensors = []types.Sensor{{ID: "prof", Name: "Profile Sensor", MinValue: 0, MaxValue: 100, Unit: "test", DataGenerationInterval: 10}}
+		manager.NewMQTTClient = func(*mqtt.ClientOptions) mqtt.Client { return client }
+		//the sensor's own profile wins over the one of its type
+		manager.Profiles = map[string]sensor.Profile{
+			"prof":   {Anomalies: []sensor.Anomaly{{Duration: time.Hour, Magnitude: -1000}}},
+			"prof-1": {Anomalies: []sensor.Anomaly{{Duration: time.Hour, Magnitude: 1000}}},
+		}
+
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Failed to start sensor manager: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		manager.Stop()
+
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		if len(client.published) == 0 {
+			t.Fatalf("Expected published readings")
+		}
+		for _, payload := range client.published {
+			var reading types.SensorData
+			if err := json.Unmarshal(payload, &reading); err != nil {
+				t.Fatalf("Failed to decode reading %s: %v", payload, err)
+			}
+			//the anomaly pushes the value far out of range, so it is clamped to the range of the sensor
+			if expected := map[string]float64{"prof-1": 100, "prof-2": 0}[reading.SensorID]; reading.Value != expected {
+				t.Errorf("Expected %s to read %v during its anomaly, got %v", reading.SensorID, expected, reading.Value)
+			}
+		}
+
+		if !strings.Contains(logs.String(), "Sensor prof-1 anomaly window 0 active") {
+			t.Errorf("Expected the active anomaly window to be logged, got %q", logs.String())
+		}
+	})
+}
+
+// TestSensorReplay tests that the readings stored in a database are published again in timestamp order, restricted to
+// the prefix and time range, and that an unreachable database fails the replay
+func TestSensorReplay(t *testing.T) {
+	addr, _ := startTestDatabase(t, 100)
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	//stored out of order, the replay follows the timestamps
+	for _, reading := range []types.SensorData{
+		{SensorID: "replay-2", Timestamp: base.Add(2 * time.Second), Value: 3, Unit: "test"},
+		{SensorID: "replay-1", Timestamp: base, Value: 1, Unit: "test"},
+		{SensorID: "replay-1", Timestamp: base.Add(time.Second), Value: 2, Unit: "test"},
+		{SensorID: "replay-1", Timestamp: base.Add(time.Hour), Value: 4, Unit: "test"},
+		{SensorID: "other-1", Timestamp: base, Value: 5, Unit: "test"},
+	} {
+		if err := client.AddDataPoint(reading); err != nil {
+			t.Fatalf("Failed to add data point: %v", err)
+		}
+	}
+
+	fake := &fakeMQTTClient{}
+	replayer := sensor.NewReplayer("unused:1883", addr)
+	replayer.Prefix = "replay-"
+	replayer.TimeRange = types.TimeRange{To: base.Add(time.Minute)}
+	replayer.Rate = 1000
+	replayer.NewMQTTClient = func(*mqtt.ClientOptions) mqtt.Client { return fake }
+
+	result, err := replayer.Run(make(chan struct{}))
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if result.Read != 3 || result.Published != 3 || result.Failed != 0 {
+		t.Errorf("Expected 3 of 3 readings replayed, got %+v", result)
+	}
+
+	var values []float64
+	for _, payload := range fake.published {
+		var reading types.SensorData
+		if err := json.Unmarshal(payload, &reading); err != nil {
+			t.Fatalf("Expected a single reading per message, got %s: %v", payload, err)
+		}
+		if !reading.IngestedAt.IsZero() {
+			t.Errorf("Expected the replayed reading without an ingestion time, got %s", payload)
+		}
+		values = append(values, reading.Value)
+	}
+	if len(values) != 3 || values[0] != 1 || values[1] != 2 || values[2] != 3 {
+		t.Errorf("Expected the values 1, 2, 3 in timestamp order, got %v", values)
+	}
+
+	//nothing listens on the address, the replay must fail before connecting to the broker
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	unreachable := lis.Addr().String()
+	lis.Close()
+
+	replayer = sensor.NewReplayer("unused:1883", unreachable)
+	replayer.ConnectTimeout = 500 * time.Millisecond
+	replayer.NewMQTTClient = func(*mqtt.ClientOptions) mqtt.Client {
+		t.Error("Expected no broker connection for an unreachable database")
+		return &fakeMQTTClient{}
+	}
+	if _, err := replayer.Run(make(chan struct{})); err == nil || !strings.Contains(err.Error(), unreachable) {
+		t.Errorf("Expected an error naming the unreachable database, got %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package functional
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestMemoryStorage runs the Storage contract against the in-memory backend
+func TestMemoryStorage(t *testing.T) {
+	testStorageContract(t, func(limit int) database.Storage {
+		return database.MemoryStorageFactory(limit)
+	})
+}
+
+// TestMemoryStorageDuplicatePoints tests that the in-memory backend finds the oldest of several points Add stored with
+// the same sensor ID and timestamp, also once the first of them was evicted
+func TestMemoryStorageDuplicatePoints(t *testing.T) {
+	storage := database.MemoryStorageFactory(3)
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := types.SensorData{SensorID: "dup", Timestamp: at, Value: 1, Unit: "test"}
+	second := types.SensorData{SensorID: "dup", Timestamp: at, Value: 2, Unit: "test"}
+	other := types.SensorData{SensorID: "other", Timestamp: at, Value: 3, Unit: "test"}
+	mustStorage(t, storage.Add([]types.SensorData{first, other, second}))
+
+	updated := first
+	updated.Value = 10
+	if !mustRead(storage.Update(updated))(t) {
+		t.Fatalf("Expected the update of a stored point to succeed")
+	}
+	if points := mustRead(storage.GetBySensor("dup"))(t); len(points) != 2 || points[0].Value != 10 || points[1].Value != 2 {
+		t.Errorf("Expected only the first duplicate updated, got %v", points)
+	}
+
+	//evicting the first duplicate leaves the second one to be found
+	mustStorage(t, storage.Add([]types.SensorData{{SensorID: "other", Timestamp: at.Add(time.Second), Value: 4, Unit: "test"}}))
+	updated.Value = 20
+	if !mustRead(storage.Update(updated))(t) {
+		t.Fatalf("Expected the update of the remaining duplicate to succeed")
+	}
+	if points := mustRead(storage.GetBySensor("dup"))(t); len(points) != 1 || points[0].Value != 20 {
+		t.Errorf("Expected the remaining duplicate updated, got %v", points)
+	}
+
+	//once all of them are evicted the point is gone
+	mustStorage(t, storage.Add([]types.SensorData{{SensorID: "other", Timestamp: at.Add(2 * time.Second), Value: 5, Unit: "test"}}))
+	mustStorage(t, storage.Add([]types.SensorData{{SensorID: "other", Timestamp: at.Add(3 * time.Second), Value: 6, Unit: "test"}}))
+	if mustRead(storage.Update(updated))(t) {
+		t.Errorf("Expected the update of an evicted point to fail")
+	}
+}
+
+// TestBoltStorage runs the Storage contract against the bolt backend
+func TestBoltStorage(t *testing.T) {
+	testStorageContract(t, func(limit int) database.Storage {
+		return openBoltStorage(t, filepath.Join(t.TempDir(), "data.bolt"), limit)
+	})
+}
+
+// TestBoltStorageRestart tests that the data of the bolt backend survives closing and reopening the file, e.g. a
+// restart of the database, including the insertion order and the size limit
+func TestBoltStorageRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bolt")
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	storage := openBoltStorage(t, path, 3)
+	for i := range 3 {
+		point := types.SensorData{SensorID: fmt.Sprintf("restart-%d", i%2), Timestamp: base.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "test", IngestedAt: base.Add(time.Hour), Sequence: uint64(i + 1)}
+		mustStorage(t, storage.Add([]types.SensorData{point}))
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Failed to close bolt storage: %v", err)
+	}
+
+	reopened := openBoltStorage(t, path, 3)
+	all := mustRead(reopened.GetAll())(t)
+	if len(all) != 3 || all[0].Value != 0 || all[2].Value != 2 {
+		t.Fatalf("Expected the 3 points in insertion order after reopening, got %v", all)
+	}
+	if !all[1].Timestamp.Equal(base.Add(time.Second)) {
+		t.Errorf("Expected the timestamp to survive, got %v", all[1].Timestamp)
+	}
+	if !all[1].IngestedAt.Equal(base.Add(time.Hour)) {
+		t.Errorf("Expected the ingestion time to survive, got %v", all[1].IngestedAt)
+	}
+	if all[1].Sequence != 2 {
+		t.Errorf("Expected the store sequence to survive, got %d", all[1].Sequence)
+	}
+
+	//the eviction continues with the oldest point written before the restart
+	mustStorage(t, reopened.Add([]types.SensorData{{SensorID: "restart-1", Timestamp: base.Add(3 * time.Second), Value: 3, Unit: "test"}}))
+	if got := mustRead(reopened.GetBySensor("restart-0"))(t); len(got) != 1 || got[0].Value != 2 {
+		t.Errorf("Expected only the newer point of restart-0 after the eviction, got %v", got)
+	}
+}
+
+// TestBoltStorageRange tests the per-sensor time range queries of the bolt backend, including points stored out of
+// time order, which the time index returns sorted
+func TestBoltStorageRange(t *testing.T) {
+	storage := openBoltStorage(t, filepath.Join(t.TempDir(), "data.bolt"), 100)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, minute := range []int{5, 1, 3, 0, 4, 2} {
+		point := types.SensorData{SensorID: "range-1", Timestamp: base.Add(time.Duration(minute) * time.Minute), Value: float64(minute), Unit: "test"}
+		other := types.SensorData{SensorID: "range-10", Timestamp: point.Timestamp, Value: -1, Unit: "test"}
+		mustStorage(t, storage.Add([]types.SensorData{point, other}))
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []float64
+	}{
+		{"inner range", base.Add(time.Minute), base.Add(4 * time.Minute), []float64{1, 2, 3}},
+		{"from is inclusive", base, base.Add(time.Minute), []float64{0}},
+		{"between points", base.Add(30 * time.Second), base.Add(59 * time.Second), nil},
+		{"everything", base.Add(-time.Hour), base.Add(time.Hour), []float64{0, 1, 2, 3, 4, 5}},
+		{"before 1970", time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), base, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustRead(storage.GetBySensorRange("range-1", tt.from, tt.to))(t)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d points, got %v", len(tt.want), got)
+			}
+			for i := range tt.want {
+				if got[i].SensorID != "range-1" || got[i].Value != tt.want[i] {
+					t.Errorf("Point %d: expected value %v of range-1, got %+v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+
+	if got := mustRead(storage.GetBySensorRange("unknown", base, base.Add(time.Hour)))(t); len(got) != 0 {
+		t.Errorf("Expected no points for an unknown sensor, got %v", got)
+	}
+}
+
+// TestTwoPhaseCommitWithBoltStorage tests that 2PC commits on replicas using the bolt backend are written to their
+// bolt files, so a replica restarted on the same file serves them again
+func TestTwoPhaseCommitWithBoltStorage(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "replica-0.bolt"), filepath.Join(dir, "replica-1.bolt")}
+
+	//replica 0 is started by hand so it can be stopped before the end of the test
+	first, err := database.BoltStorageFactory(paths[0], 100)
+	if err != nil {
+		t.Fatalf("Failed to open bolt storage: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(database.KeepaliveEnforcement(database.DefaultKeepaliveMinInterval))
+	service := newTestService(t, 100, database.WithStorage(first))
+	pb.RegisterDatabaseServiceServer(grpcServer, service)
+	go grpcServer.Serve(lis)
+
+	second, _ := startTestDatabase(t, 100, database.WithStorage(openBoltStorage(t, paths[1], 100)))
+
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{lis.Addr().String(), second})
+	if err != nil {
+		t.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	base := time.Now().Add(-time.Minute)
+	batch := []types.SensorData{
+		{SensorID: "bolt-2pc-1", Timestamp: base, Value: 1, Unit: "test"},
+		{SensorID: "bolt-2pc-1", Timestamp: base.Add(time.Second), Value: 2, Unit: "test"},
+	}
+	if err := tpcClient.AddDataPointsWithTwoPhaseCommit(batch); err != nil {
+		t.Fatalf("Failed to commit batch: %v", err)
+	}
+	if err := tpcClient.AddDataPointWithTwoPhaseCommit(types.SensorData{SensorID: "bolt-2pc-2", Timestamp: base, Value: 3, Unit: "test"}); err != nil {
+		t.Fatalf("Failed to commit point: %v", err)
+	}
+
+	//restart replica 0 on its bolt file, there is no snapshot that could bring the data back
+	grpcServer.Stop()
+	service.Stop()
+	if err := first.Close(); err != nil {
+		t.Fatalf("Failed to close bolt storage: %v", err)
+	}
+	addr, _ := startTestDatabase(t, 100, database.WithStorage(openBoltStorage(t, paths[0], 100)))
+
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to restarted replica: %v", err)
+	}
+	defer client.Close()
+
+	all, err := client.GetAllDataPoints()
+	if err != nil {
+		t.Fatalf("Failed to read from restarted replica: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 committed points after the restart, got %v", all)
+	}
+	points, err := client.GetDataPointBySensorId("bolt-2pc-1")
+	if err != nil {
+		t.Fatalf("Failed to read from restarted replica: %v", err)
+	}
+	if len(points) != 2 || points[0].Value != 1 || points[1].Value != 2 {
+		t.Errorf("Expected the committed batch in time order, got %v", points)
+	}
+}
+
+// openBoltStorage opens a bolt storage that is closed at the end of the test
+func openBoltStorage(t *testing.T, path string, limit int) *database.BoltStorage {
+	t.Helper()
+	storage, err := database.BoltStorageFactory(path, limit)
+	if err != nil {
+		t.Fatalf("Failed to open bolt storage: %v", err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	return storage
+}
+
+// mustStorage fails the test on an error returned by a Storage write
+func mustStorage(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Storage call failed: %v", err)
+	}
+}
+
+// mustRead returns the result of a Storage read, failing the test on an error: mustRead(storage.GetAll())(t)
+func mustRead[T any](result T, err error) func(*testing.T) T {
+	return func(t *testing.T) T {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Storage read failed: %v", err)
+		}
+		return result
+	}
+}
+
+// testStorageContract checks the behavior every Storage backend has to provide; newStorage creates an empty backend
+// that keeps at most limit points
+func testStorageContract(t *testing.T, newStorage func(limit int) database.Storage) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	point := func(sensorID string, i int) types.SensorData {
+		return types.SensorData{SensorID: sensorID, Timestamp: base.Add(time.Duration(i) * time.Second), Value: float64(i), Unit: "test"}
+	}
+	expectPoints := func(t *testing.T, what string, got []types.SensorData, want ...types.SensorData) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d points, got %d: %v", what, len(want), len(got), got)
+		}
+		for i := range want {
+			if !got[i].Equal(want[i]) {
+				t.Errorf("%s: point %d expected %+v, got %+v", what, i, want[i], got[i])
+			}
+		}
+	}
+
+	t.Run("AddAndGet", func(t *testing.T) {
+		storage := newStorage(100)
+		a0, b0, a1, c0 := point("a", 0), point("b", 0), point("a", 1), point("ca", 0)
+		mustStorage(t, storage.Add([]types.SensorData{a0, b0}))
+		mustStorage(t, storage.Add([]types.SensorData{a1, c0}))
+
+		if count := mustRead(storage.Count())(t); count != 4 {
+			t.Errorf("Expected 4 points, got %d", count)
+		}
+		expectPoints(t, "GetAll", mustRead(storage.GetAll())(t), a0, b0, a1, c0)
+		expectPoints(t, "GetBySensor", mustRead(storage.GetBySensor("a"))(t), a0, a1)
+		expectPoints(t, "GetBySensor unknown", mustRead(storage.GetBySensor("x"))(t))
+		expectPoints(t, "GetBySensorRange", mustRead(storage.GetBySensorRange("a", base, base.Add(time.Second)))(t), a0)
+		expectPoints(t, "GetBySensorRange open end", mustRead(storage.GetBySensorRange("a", base.Add(time.Second), base.Add(time.Hour)))(t), a1)
+		expectPoints(t, "GetByPrefix", mustRead(storage.GetByPrefix("c"))(t), c0)
+		expectPoints(t, "GetByPrefix empty", mustRead(storage.GetByPrefix(""))(t), a0, b0, a1, c0)
+
+		groups := mustRead(storage.GetBySensors([]string{"a", "b", "x", "a"}))(t)
+		expectPoints(t, "GetBySensors a", groups["a"], a0, a1)
+		expectPoints(t, "GetBySensors b", groups["b"], b0)
+		if _, ok := groups["x"]; ok {
+			t.Errorf("Expected no group for a sensor without points")
+		}
+
+		//returned slices are copies
+		all := mustRead(storage.GetAll())(t)
+		all[0].Value = 99
+		expectPoints(t, "GetAll after modifying a result", mustRead(storage.GetAll())(t)[:1], a0)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		storage := newStorage(3)
+		var points []types.SensorData
+		for i := range 5 {
+			points = append(points, point(fmt.Sprintf("s%d", i%2), i))
+		}
+		mustStorage(t, storage.Add(points[:2]))
+		mustStorage(t, storage.Add(points[2:]))
+
+		expectPoints(t, "GetAll", mustRead(storage.GetAll())(t), points[2:]...)
+		expectPoints(t, "GetBySensor of partly evicted sensor", mustRead(storage.GetBySensor("s1"))(t), points[3])
+		expectPoints(t, "GetByPrefix across sensors", mustRead(storage.GetByPrefix("s"))(t), points[2:]...)
+
+		//deleting a sensor moves the points behind it, queries and eviction still find the right ones
+		mustRead(storage.Delete("s1"))(t)
+		later := []types.SensorData{point("s1", 5), point("s0", 6)}
+		mustStorage(t, storage.Add(later))
+		expectPoints(t, "GetByPrefix after delete", mustRead(storage.GetByPrefix("s"))(t), points[4], later[0], later[1])
+		expectPoints(t, "GetBySensors after delete", mustRead(storage.GetBySensors([]string{"s0"}))(t)["s0"], points[4], later[1])
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		storage := newStorage(100)
+		a0, a1, b0 := point("a", 0), point("a", 1), point("b", 0)
+		mustStorage(t, storage.Add([]types.SensorData{a0, a1, b0}))
+
+		updated := a1
+		updated.Value, updated.Unit = 42, "new"
+		if !mustRead(storage.Update(updated))(t) {
+			t.Errorf("Expected the update of an existing point to succeed")
+		}
+		if mustRead(storage.Update(point("a", 7)))(t) {
+			t.Errorf("Expected the update of a missing point to fail")
+		}
+		expectPoints(t, "GetBySensor after update", mustRead(storage.GetBySensor("a"))(t), a0, updated)
+
+		if removed := mustRead(storage.Delete("a"))(t); removed != 2 {
+			t.Errorf("Expected 2 points deleted, got %d", removed)
+		}
+		if removed := mustRead(storage.Delete("a"))(t); removed != 0 {
+			t.Errorf("Expected nothing left to delete, got %d", removed)
+		}
+		expectPoints(t, "GetAll after delete", mustRead(storage.GetAll())(t), b0)
+
+		if removed := mustRead(storage.DeleteAll())(t); removed != 1 {
+			t.Errorf("Expected 1 point deleted, got %d", removed)
+		}
+		if count := mustRead(storage.Count())(t); count != 0 {
+			t.Errorf("Expected an empty storage, got %d points", count)
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		storage := newStorage(100)
+		a0, a1 := point("a", 0), point("a", 1)
+		mustStorage(t, storage.Add([]types.SensorData{a0, a1}))
+
+		//only the set fields change
+		value := 42.0
+		if !mustRead(storage.Patch(types.SensorDataPatch{SensorID: "a", Timestamp: a1.Timestamp, Value: &value}))(t) {
+			t.Errorf("Expected the patch of an existing point to succeed")
+		}
+		patched := a1
+		patched.Value = value
+		expectPoints(t, "GetBySensor after value patch", mustRead(storage.GetBySensor("a"))(t), a0, patched)
+
+		unit := "new"
+		mustRead(storage.Patch(types.SensorDataPatch{SensorID: "a", Timestamp: a1.Timestamp, Unit: &unit}))(t)
+		patched.Unit = unit
+		expectPoints(t, "GetBySensor after unit patch", mustRead(storage.GetBySensor("a"))(t), a0, patched)
+
+		if mustRead(storage.Patch(types.SensorDataPatch{SensorID: "a", Timestamp: point("a", 7).Timestamp, Value: &value}))(t) {
+			t.Errorf("Expected the patch of a missing point to fail")
+		}
+	})
+
+	t.Run("Upsert", func(t *testing.T) {
+		storage := newStorage(3)
+		a0, a1, b0 := point("a", 0), point("a", 1), point("b", 0)
+		mustStorage(t, storage.Add([]types.SensorData{a0, a1}))
+
+		//a reading of a stored point replaces it in place, a new one is appended, also within one batch
+		replaced := a0
+		replaced.Value, replaced.Unit = 42, "new"
+		again := replaced
+		again.Value = 43
+		mustStorage(t, storage.Upsert([]types.SensorData{replaced, b0, again}))
+		expectPoints(t, "GetAll", mustRead(storage.GetAll())(t), again, a1, b0)
+
+		//the limit still applies to appended readings
+		a2 := point("a", 2)
+		mustStorage(t, storage.Upsert([]types.SensorData{a2}))
+		expectPoints(t, "GetAll after eviction", mustRead(storage.GetAll())(t), a1, b0, a2)
+		expectPoints(t, "GetBySensor after eviction", mustRead(storage.GetBySensor("a"))(t), a1, a2)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		storage := newStorage(2)
+		mustStorage(t, storage.Add([]types.SensorData{point("old", 0)}))
+
+		//only the newest points fitting the limit are kept
+		mustStorage(t, storage.Replace([]types.SensorData{point("a", 0), point("b", 1), point("a", 2)}))
+		expectPoints(t, "GetAll", mustRead(storage.GetAll())(t), point("b", 1), point("a", 2))
+		expectPoints(t, "GetBySensor", mustRead(storage.GetBySensor("old"))(t))
+		expectPoints(t, "GetBySensor", mustRead(storage.GetBySensor("a"))(t), point("a", 2))
+	})
+
+	t.Run("AtomicBatches", func(t *testing.T) {
+		storage := newStorage(100_000)
+		const batches, batchSize = 200, 10
+
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for i := range batches {
+				batch := make([]types.SensorData, batchSize)
+				for j := range batch {
+					batch[j] = point("batch", i*batchSize+j)
+				}
+				if err := storage.Add(batch); err != nil {
+					t.Errorf("Failed to add batch %d: %v", i, err)
+					return
+				}
+			}
+		}()
+
+		//read until the writer is done, a writer that stops early or hangs must not keep the reader spinning
+		var partial atomic.Int64
+		timeout := time.After(10 * time.Second)
+		for reading := true; reading; {
+			select {
+			case <-written:
+				reading = false
+			case <-timeout:
+				t.Fatalf("Writer did not finish, %d of %d points stored", mustRead(storage.Count())(t), batches*batchSize)
+			default:
+			}
+			if n := len(mustRead(storage.GetBySensor("batch"))(t)); n%batchSize != 0 {
+				partial.Add(1)
+			}
+		}
+
+		if partial.Load() > 0 {
+			t.Errorf("Readers saw %d partial batches", partial.Load())
+		}
+	})
+}
+
+// countingStorage wraps a Storage and counts the calls, to check that the service uses the backend it was given
+type countingStorage struct {
+	database.Storage
+	adds atomic.Int64
+}
+
+func (c *countingStorage) Add(readings []types.SensorData) error {
+	c.adds.Add(1)
+	return c.Storage.Add(readings)
+}
+
+// TestServiceWithStorage tests that a DatabaseService stores its data in the backend passed with WithStorage
+func TestServiceWithStorage(t *testing.T) {
+	storage := &countingStorage{Storage: database.MemoryStorageFactory(10)}
+	service := newTestService(t, 1, database.WithStorage(storage))
+	defer service.Stop()
+
+	for i := range 3 {
+		req := &pb.SensorDataRequest{SensorId: "backend-1", Value: float64(i), Unit: "test"}
+		if resp, err := service.CreateSensorData(context.Background(), req); err != nil || !resp.Success {
+			t.Fatalf("Failed to store point %d: %v %v", i, resp, err)
+		}
+	}
+
+	if adds := storage.adds.Load(); adds != 3 {
+		t.Errorf("Expected 3 Add calls on the backend, got %d", adds)
+	}
+
+	//the size limit of the factory only applies to the default backend
+	list, err := service.GetSensorDataBySensorId(context.Background(), &pb.SensorIdRequest{SensorId: "backend-1"})
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if len(list.Data) != 3 {
+		t.Errorf("Expected all 3 points from the backend, got %d", len(list.Data))
+	}
+}
@@ -0,0 +1,134 @@
+package functional
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/tracing"
+)
+
+// TestTracingSpans tests that a traced POST /data continues the trace of the client and produces the span tree
+// HTTP request -> 2PC transaction -> prepare and commit phase -> gRPC client call -> gRPC server call, with the commit
+// phase linked to the prepare phase, and that nothing is recorded once tracing is shut down again
+func TestTracingSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.Init("test", exporter)
+	defer tracing.Shutdown(context.Background())
+
+	addr1, _ := startTestDatabase(t, 100)
+	addr2, _ := startTestDatabase(t, 100)
+
+	startTestApp(t, func(config *server.Config) {
+		config.Port = 8122
+		config.DatabaseAddresses = []string{addr1, addr2}
+	})
+
+	//the client continues a trace of its own
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"traceparent":  "00-" + traceID + "-" + parentID + "-01",
+	}
+	client := http.HttpClientFactory(5 * time.Second)
+	resp, err := client.Do(http.POST, "http://localhost:8122/data", []byte(`{"sensorId":"traced-1","value":1.5,"unit":"test"}`), headers)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush spans: %v", err)
+	}
+
+	//only the spans of the traced request, the readiness probes before it are traces of their own
+	var spans tracetest.SpanStubs
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID().String() == traceID {
+			spans = append(spans, span)
+		}
+	}
+
+	byName := func(name string) []tracetest.SpanStub {
+		var found []tracetest.SpanStub
+		for _, span := range spans {
+			if span.Name == name {
+				found = append(found, span)
+			}
+		}
+		return found
+	}
+	single := func(name string) tracetest.SpanStub {
+		t.Helper()
+		found := byName(name)
+		if len(found) != 1 {
+			t.Fatalf("Expected one %q span in the trace, got %d of %d spans", name, len(found), len(spans))
+		}
+		return found[0]
+	}
+	expectChildren := func(name string, parent tracetest.SpanStub, count int, kind trace.SpanKind) []tracetest.SpanStub {
+		t.Helper()
+		var children []tracetest.SpanStub
+		for _, span := range byName(name) {
+			if span.Parent.SpanID() == parent.SpanContext.SpanID() {
+				children = append(children, span)
+			}
+		}
+		if len(children) != count {
+			t.Fatalf("Expected %d %q spans under %q, got %d", count, name, parent.Name, len(children))
+		}
+		for _, child := range children {
+			if child.SpanKind != kind {
+				t.Errorf("Expected %q to be a %v span, got %v", name, kind, child.SpanKind)
+			}
+		}
+		return children
+	}
+
+	request := single("POST")
+	if request.Parent.SpanID().String() != parentID || !request.Parent.IsRemote() {
+		t.Errorf("Expected the request span to continue the remote span %s, got parent %v", parentID, request.Parent.SpanID())
+	}
+	if request.SpanKind != trace.SpanKindServer {
+		t.Errorf("Expected a server span for the request, got %v", request.SpanKind)
+	}
+
+	transaction := expectChildren("2PC transaction", request, 1, trace.SpanKindInternal)[0]
+	prepare := expectChildren("2PC prepare", transaction, 1, trace.SpanKindInternal)[0]
+	commit := expectChildren("2PC commit", transaction, 1, trace.SpanKindInternal)[0]
+
+	if len(commit.Links) != 1 || commit.Links[0].SpanContext.SpanID() != prepare.SpanContext.SpanID() {
+		t.Errorf("Expected the commit span to link to the prepare span, got links %v", commit.Links)
+	}
+
+	//one call per database and phase, each answered by a server span of the database
+	for _, call := range []struct {
+		method string
+		phase  tracetest.SpanStub
+	}{
+		{"database.DatabaseService/PrepareTransaction", prepare},
+		{"database.DatabaseService/CommitTransaction", commit},
+	} {
+		for _, clientSpan := range expectChildren(call.method, call.phase, 2, trace.SpanKindClient) {
+			expectChildren(call.method, clientSpan, 1, trace.SpanKindServer)
+		}
+	}
+
+	//shut down, tracing is a no-op again
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down tracing: %v", err)
+	}
+	if tracing.Enabled() {
+		t.Errorf("Expected tracing to be disabled after Shutdown")
+	}
+	if _, span := tracing.Start(context.Background(), "after shutdown"); span.IsRecording() {
+		t.Errorf("Expected a no-op span after Shutdown")
+	}
+}
@@ -1,3 +1,5 @@
+//go:build loadtest
+
 package performance
 
 import (
@@ -17,50 +19,56 @@ import (
 
 // Test2PCPerformance tests the performance of Two-Phase Commit vs direct database calls
 func Test2PCPerformance(t *testing.T) {
-	client1, err := database.ClientFactory("localhost:50051")
+	dbAddresses := databaseAddresses(t, 2)
+
+	//the baseline writes to the first replica only
+	client1, err := database.ClientFactory(dbAddresses[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database1: %v", err)
 	}
 	defer client1.Close()
 
-	client2, err := database.ClientFactory("localhost:50052")
-	if err != nil {
-		t.Fatalf("Failed to connect to database2: %v", err)
-	}
-	defer client2.Close()
-
-	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{"localhost:50051", "localhost:50052"})
+	tpcClient, err := database.TwoPhaseCommitClientFactory(dbAddresses)
 	if err != nil {
 		t.Fatalf("Failed to create 2PC client: %v", err)
 	}
 	defer tpcClient.Close()
 
-	numRequests := 10_000 //smaller number for 2PC due to crazy costs
-	log.Printf("Starting 2PC performance comparison with %d requests", numRequests)
+	//smaller default for 2PC due to crazy costs, the clients only share the requests of the concurrent run
+	numRequests, concurrentClients := workloadFromEnv(t, "2PC", 10_000, 10)
+	log.Printf("Starting 2PC performance comparison with %d requests on %d replicas", numRequests, len(dbAddresses))
+	partial := newPartialResults(t, "Two-Phase Commit Performance Comparison", "2pc_performance_results.txt")
 
 	//test 1: Direct RPC calls (baseline)
 	log.Println("=== Testing Direct RPC Performance (Baseline) ===")
-	directStats := testDirectRPCPerformance(t, client1, numRequests)
+	directStats := testDirectRPCPerformance(t, client1, numRequests, partial)
 
 	//test 2: Two-Phase Commit
 	log.Println("=== Testing 2PC Performance ===")
-	tpcStats := test2PCPerformance(t, tpcClient, numRequests)
+	tpcStats, phaseStats := test2PCPerformance(t, tpcClient, dbAddresses, numRequests, partial)
 
 	//test 3: Concurrent 2PC transactions
 	log.Println("=== Testing Concurrent 2PC Performance ===")
-	concurrentStats := testConcurrent2PCPerformance(t, tpcClient, numRequests/10, 10)
+	requestsPerClient := numRequests / concurrentClients
+	concurrentStats := testConcurrent2PCPerformance(t, tpcClient, requestsPerClient, concurrentClients, partial)
 
-	err = write2PCComparisonResults(directStats, tpcStats, concurrentStats, "2pc_performance_results.txt")
+	err = write2PCComparisonResults(directStats, tpcStats, concurrentStats, phaseStats, "2pc_performance_results.txt")
 	if err != nil {
 		t.Errorf("Failed to write results to file: %v", err)
+	} else {
+		partial.Complete()
 	}
 
+	assertWithinBudget(t, budgetStats{Name: directStats.Protocol, Requests: numRequests, Succeeded: directStats.Count, P99: directStats.Percentile99}, budgetFromEnv(t, "RPC"))
+	assertWithinBudget(t, budgetStats{Name: tpcStats.Protocol, Requests: numRequests, Succeeded: tpcStats.Count, P99: tpcStats.Percentile99}, budgetFromEnv(t, "2PC"))
+	assertWithinBudget(t, budgetStats{Name: concurrentStats.Protocol, Requests: requestsPerClient * concurrentClients, Succeeded: concurrentStats.Count, P99: concurrentStats.Percentile99}, budgetFromEnv(t, "2PC_CONCURRENT"))
+
 	log.Println("2PC performance testing completed")
 }
 
 // testDirectRPCPerformance measures baseline RPC performance to single database
-func testDirectRPCPerformance(t *testing.T, client *database.Client, numRequests int) TwoPhaseCommitStatistics {
-	var rtts []time.Duration
+func testDirectRPCPerformance(t *testing.T, client *database.Client, numRequests int, partial *partialResults) TwoPhaseCommitStatistics {
+	recorder := partial.Recorder("Direct-RPC")
 	testData := types.SensorData{
 		SensorID:  "direct-rpc-perf",
 		Timestamp: time.Now(),
@@ -78,19 +86,19 @@ func testDirectRPCPerformance(t *testing.T, client *database.Client, numRequests
 			t.Errorf("Direct RPC call %d failed: %v", i, err)
 			continue
 		}
-		rtt := time.Since(requestStart)
-		rtts = append(rtts, rtt)
+		recorder.Add(time.Since(requestStart))
 	}
 
 	totalDuration := time.Since(start)
-	stats := calculate2PCStatistics(rtts, "Direct-RPC", totalDuration)
+	stats := calculate2PCStatistics(partial.RTTs("Direct-RPC"), "Direct-RPC", totalDuration)
 	log2PCStatistics(stats)
 	return stats
 }
 
-// test2PCPerformance measures Two-Phase Commit performance
-func test2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseCommitClient, numRequests int) TwoPhaseCommitStatistics {
-	var rtts []time.Duration
+// test2PCPerformance measures Two-Phase Commit performance including the per replica phase breakdown
+func test2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseCommitClient, addresses []string, numRequests int, partial *partialResults) (TwoPhaseCommitStatistics, PhaseBreakdownStatistics) {
+	recorder := partial.Recorder("2PC-Sequential")
+	var breakdowns []*database.TwoPhaseCommitBreakdown
 	testData := types.SensorData{
 		SensorID:  "2pc-perf-test",
 		Timestamp: time.Now(),
@@ -107,26 +115,93 @@ func test2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseCommitClient,
 		uniqueData.SensorID = fmt.Sprintf("2pc-perf-%d", i)
 		uniqueData.Timestamp = time.Now()
 
-		requestStart := time.Now()
-		err := tpcClient.AddDataPointWithTwoPhaseCommit(uniqueData)
+		breakdown, err := tpcClient.AddDataPointWithTwoPhaseCommitDetailed(uniqueData)
 		if err != nil {
 			t.Errorf("2PC transaction %d failed: %v", i, err)
 			continue
 		}
-		rtt := time.Since(requestStart)
-		rtts = append(rtts, rtt)
+		recorder.Add(breakdown.Total)
+		breakdowns = append(breakdowns, breakdown)
 	}
 
 	totalDuration := time.Since(start)
-	stats := calculate2PCStatistics(rtts, "2PC-Sequential", totalDuration)
+	stats := calculate2PCStatistics(partial.RTTs("2PC-Sequential"), "2PC-Sequential", totalDuration)
 	log2PCStatistics(stats)
+
+	phaseStats := calculatePhaseBreakdown(breakdowns, addresses)
+	logPhaseBreakdown(phaseStats)
+	return stats, phaseStats
+}
+
+// PhaseBreakdownStatistics contains the average per replica phase timings of the 2PC transactions
+type PhaseBreakdownStatistics struct {
+	Addresses       []string
+	AvgPrepare      []time.Duration
+	AvgCommit       []time.Duration
+	SlowestCount    []int //number of transactions in which the replica was the slowest
+	AvgCoordination time.Duration
+	Count           int
+}
+
+// calculatePhaseBreakdown averages the phase timings per replica
+func calculatePhaseBreakdown(breakdowns []*database.TwoPhaseCommitBreakdown, addresses []string) PhaseBreakdownStatistics {
+	stats := PhaseBreakdownStatistics{
+		Addresses:    addresses,
+		AvgPrepare:   make([]time.Duration, len(addresses)),
+		AvgCommit:    make([]time.Duration, len(addresses)),
+		SlowestCount: make([]int, len(addresses)),
+		Count:        len(breakdowns),
+	}
+	if len(breakdowns) == 0 {
+		return stats
+	}
+
+	for _, breakdown := range breakdowns {
+		for r := range addresses {
+			stats.AvgPrepare[r] += breakdown.Prepare[r]
+			stats.AvgCommit[r] += breakdown.Commit[r]
+		}
+		stats.SlowestCount[breakdown.SlowestReplica()]++
+		stats.AvgCoordination += breakdown.Coordination
+	}
+
+	count := time.Duration(len(breakdowns))
+	for r := range addresses {
+		stats.AvgPrepare[r] /= count
+		stats.AvgCommit[r] /= count
+	}
+	stats.AvgCoordination /= count
+
 	return stats
 }
 
+// slowestReplica returns the index of the replica that was the slowest in most transactions
+func (p PhaseBreakdownStatistics) slowestReplica() int {
+	slowest := 0
+	for r := range p.SlowestCount {
+		if p.SlowestCount[r] > p.SlowestCount[slowest] {
+			slowest = r
+		}
+	}
+	return slowest
+}
+
+// logPhaseBreakdown logs the per replica phase breakdown
+func logPhaseBreakdown(stats PhaseBreakdownStatistics) {
+	if stats.Count == 0 {
+		return
+	}
+
+	for r, addr := range stats.Addresses {
+		log.Printf("  Replica %s: avg prepare %v, avg commit %v, slowest in %d transactions",
+			addr, stats.AvgPrepare[r], stats.AvgCommit[r], stats.SlowestCount[r])
+	}
+	log.Printf("  Avg coordination overhead: %v", stats.AvgCoordination)
+	log.Printf("  Slowest replica: %s", stats.Addresses[stats.slowestReplica()])
+}
+
 // testConcurrent2PCPerformance measures 2PC performance under concurrent load
-func testConcurrent2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseCommitClient, requestsPerClient, numClients int) TwoPhaseCommitStatistics {
-	var mu sync.Mutex
-	var allRTTs []time.Duration
+func testConcurrent2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseCommitClient, requestsPerClient, numClients int, partial *partialResults) TwoPhaseCommitStatistics {
 	var wg sync.WaitGroup
 
 	log.Printf("Running %d concurrent 2PC clients with %d requests each...", numClients, requestsPerClient)
@@ -136,6 +211,7 @@ func testConcurrent2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseComm
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
+			recorder := partial.Recorder("2PC-Concurrent")
 
 			for i := range requestsPerClient {
 				testData := types.SensorData{
@@ -151,11 +227,7 @@ func testConcurrent2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseComm
 					log.Printf("Concurrent 2PC client %d, request %d failed: %v", id, i, err)
 					continue
 				}
-				rtt := time.Since(requestStart)
-
-				mu.Lock()
-				allRTTs = append(allRTTs, rtt)
-				mu.Unlock()
+				recorder.Add(time.Since(requestStart))
 			}
 		}(clientID)
 	}
@@ -163,7 +235,7 @@ func testConcurrent2PCPerformance(t *testing.T, tpcClient *database.TwoPhaseComm
 	wg.Wait()
 	totalDuration := time.Since(start)
 
-	stats := calculate2PCStatistics(allRTTs, "2PC-Concurrent", totalDuration)
+	stats := calculate2PCStatistics(partial.RTTs("2PC-Concurrent"), "2PC-Concurrent", totalDuration)
 	log2PCStatistics(stats)
 	return stats
 }
@@ -259,7 +331,7 @@ func log2PCStatistics(stats TwoPhaseCommitStatistics) {
 }
 
 // write2PCComparisonResults writes comprehensive 2PC comparison results to file
-func write2PCComparisonResults(directStats, tpcStats, concurrentStats TwoPhaseCommitStatistics, filename string) error {
+func write2PCComparisonResults(directStats, tpcStats, concurrentStats TwoPhaseCommitStatistics, phaseStats PhaseBreakdownStatistics, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -277,6 +349,10 @@ func write2PCComparisonResults(directStats, tpcStats, concurrentStats TwoPhaseCo
 	file.WriteString("-----------------------------\n")
 	write2PCStatsToFile(file, tpcStats)
 
+	file.WriteString("\nTwo-Phase Commit Phase Breakdown:\n")
+	file.WriteString("---------------------------------\n")
+	writePhaseBreakdownToFile(file, phaseStats)
+
 	file.WriteString("\nConcurrent 2PC Performance:\n")
 	file.WriteString("---------------------------\n")
 	write2PCStatsToFile(file, concurrentStats)
@@ -310,6 +386,26 @@ func write2PCComparisonResults(directStats, tpcStats, concurrentStats TwoPhaseCo
 	return nil
 }
 
+// writePhaseBreakdownToFile writes the per replica prepare vs commit split to file
+func writePhaseBreakdownToFile(file *os.File, stats PhaseBreakdownStatistics) {
+	if stats.Count == 0 {
+		file.WriteString("No successful transactions\n")
+		return
+	}
+
+	for r, addr := range stats.Addresses {
+		total := stats.AvgPrepare[r] + stats.AvgCommit[r]
+		preparePercent := 0.0
+		if total > 0 {
+			preparePercent = float64(stats.AvgPrepare[r]) / float64(total) * 100
+		}
+		fmt.Fprintf(file, "Replica %s: prepare %v / commit %v (%.1f%% / %.1f%%), slowest in %d of %d transactions\n",
+			addr, stats.AvgPrepare[r], stats.AvgCommit[r], preparePercent, 100-preparePercent, stats.SlowestCount[r], stats.Count)
+	}
+	fmt.Fprintf(file, "Coordination overhead: %v\n", stats.AvgCoordination)
+	fmt.Fprintf(file, "Slowest replica:       %s\n", stats.Addresses[stats.slowestReplica()])
+}
+
 // write2PCStatsToFile writes statistics to file
 func write2PCStatsToFile(file *os.File, stats TwoPhaseCommitStatistics) {
 	fmt.Fprintf(file, "Protocol:           %s\n", stats.Protocol)
@@ -0,0 +1,209 @@
+package performance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/http"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+//the benchmarks run against in-process databases and mock connections, so unlike the load tests they need no
+//running infrastructure: go test -bench . -run '^$' ./tests/performance/
+
+// BenchmarkAddDataPoint measures a single RPC write to one database
+func BenchmarkAddDataPoint(b *testing.B) {
+	quietLogs(b)
+	addr, _ := startBenchmarkDatabase(b)
+	client, err := database.ClientFactory(addr)
+	if err != nil {
+		b.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer client.Close()
+
+	data := benchmarkReading()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := client.AddDataPoint(data); err != nil {
+			b.Fatalf("Write failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkTwoPhaseCommit measures a full 2PC write (prepare and commit) across two databases
+func BenchmarkTwoPhaseCommit(b *testing.B) {
+	quietLogs(b)
+	addr1, _ := startBenchmarkDatabase(b)
+	addr2, _ := startBenchmarkDatabase(b)
+	tpcClient, err := database.TwoPhaseCommitClientFactory([]string{addr1, addr2})
+	if err != nil {
+		b.Fatalf("Failed to create 2PC client: %v", err)
+	}
+	defer tpcClient.Close()
+
+	data := benchmarkReading()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := tpcClient.AddDataPointWithTwoPhaseCommit(data); err != nil {
+			b.Fatalf("2PC write failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkParseRequest measures parsing a POST /data request from a connection
+func BenchmarkParseRequest(b *testing.B) {
+	quietLogs(b)
+	body := `{"sensorId":"bench-1","value":23.5,"unit":"°C"}`
+	raw := fmt.Sprintf("POST /data?dryrun=false HTTP/1.1\r\nHost: localhost:8080\r\nContent-Type: application/json\r\nAccept-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	conn := MockConnFactory([]byte(raw))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for range b.N {
+		conn.Reset()
+		if _, err := http.ParseRequest(conn); err != nil {
+			b.Fatalf("Parse failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkResponseWrite measures serializing a JSON response onto a connection
+func BenchmarkResponseWrite(b *testing.B) {
+	quietLogs(b)
+	readings := make([]types.SensorData, 20)
+	for i := range readings {
+		readings[i] = benchmarkReading()
+	}
+	body, err := json.Marshal(readings)
+	if err != nil {
+		b.Fatalf("Failed to encode readings: %v", err)
+	}
+	conn := MockConnFactory(nil)
+
+	b.ReportAllocs()
+	for range b.N {
+		conn.Reset()
+		if err := http.CreateJSONResponse(http.StatusOK, body).Write(conn); err != nil {
+			b.Fatalf("Write failed: %v", err)
+		}
+	}
+}
+
+// benchmarkReading returns the reading stored by the benchmarks
+func benchmarkReading() types.SensorData {
+	return types.SensorData{
+		SensorID:  "bench-1",
+		Timestamp: time.Now(),
+		Value:     23.5,
+		Unit:      "°C",
+	}
+}
+
+// quietLogs discards the per-request logging of the code under test for the duration of a benchmark or test
+func quietLogs(tb testing.TB) {
+	tb.Helper()
+	previous := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() {
+		log.SetOutput(previous)
+	})
+}
+
+// startBenchmarkDatabase runs a database service in-process on a random local port and returns its address
+func startBenchmarkDatabase(tb testing.TB, opts ...database.ServiceOption) (string, *database.DatabaseService) {
+	tb.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Failed to listen for benchmark database: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(database.KeepaliveEnforcement(database.DefaultKeepaliveMinInterval))
+	service, err := database.DatabaseServiceFactory(1000, opts...)
+	if err != nil {
+		tb.Fatalf("Failed to create benchmark database service: %v", err)
+	}
+	pb.RegisterDatabaseServiceServer(grpcServer, service)
+
+	go grpcServer.Serve(lis)
+
+	tb.Cleanup(func() {
+		grpcServer.Stop()
+		service.Stop()
+	})
+
+	return lis.Addr().String(), service
+}
+
+// MockConn is a reusable in-memory net.Conn: reads replay the given data, writes are discarded
+type MockConn struct {
+	reader *bytes.Reader
+	data   []byte
+}
+
+// MockConnFactory creates a new mock connection with the given read data
+func MockConnFactory(readData []byte) *MockConn {
+	return &MockConn{
+		reader: bytes.NewReader(readData),
+		data:   readData,
+	}
+}
+
+// Reset rewinds the connection so the same data can be read again
+func (m *MockConn) Reset() {
+	m.reader.Reset(m.data)
+}
+
+// Read reads data from the mock connection
+func (m *MockConn) Read(b []byte) (int, error) {
+	return m.reader.Read(b)
+}
+
+// Write discards the data written to the mock connection
+func (m *MockConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Close closes the mock connection
+func (m *MockConn) Close() error {
+	return nil
+}
+
+// LocalAddr returns the local network address
+func (m *MockConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+}
+
+// RemoteAddr returns the remote network address
+func (m *MockConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+// SetDeadline sets the read and write deadlines
+func (m *MockConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline sets the read deadline
+func (m *MockConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline
+func (m *MockConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// perfBudget bounds the results of a performance test, so a regression fails the test instead of only showing up in
+// the results file
+type perfBudget struct {
+	MaxP99       time.Duration //99th percentile of the latency, 0 = not checked
+	MaxErrorRate float64       //failed requests as a fraction of all requests, negative = not checked
+}
+
+// budgetStats is the part of the statistics of a test that a budget is checked against
+type budgetStats struct {
+	Name      string
+	Requests  int //requests sent, including the failed ones
+	Succeeded int
+	P99       time.Duration
+}
+
+// budgetFromEnv reads the budget of a test from PERF_<NAME>_MAX_P99 (a duration, e.g. 50ms) and
+// PERF_<NAME>_MAX_ERROR_RATE (a fraction, e.g. 0.01), falling back to PERF_MAX_P99 and PERF_MAX_ERROR_RATE for all
+// tests. A bound that is set nowhere is not checked
+func budgetFromEnv(t *testing.T, name string) perfBudget {
+	t.Helper()
+	budget := perfBudget{MaxErrorRate: -1}
+
+	if value := budgetEnv(name, "MAX_P99"); value != "" {
+		maxP99, err := time.ParseDuration(value)
+		if err != nil || maxP99 <= 0 {
+			t.Fatalf("Invalid p99 budget %q for %s: expected a positive duration", value, name)
+		}
+		budget.MaxP99 = maxP99
+	}
+
+	if value := budgetEnv(name, "MAX_ERROR_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			t.Fatalf("Invalid error rate budget %q for %s: expected a fraction between 0 and 1", value, name)
+		}
+		budget.MaxErrorRate = rate
+	}
+	return budget
+}
+
+// budgetEnv returns the test specific setting if it is set, else the one for all tests
+func budgetEnv(name, setting string) string {
+	if value := os.Getenv("PERF_" + name + "_" + setting); value != "" {
+		return value
+	}
+	return os.Getenv("PERF_" + setting)
+}
+
+// assertWithinBudget fails the test if the p99 latency or the share of failed requests exceeds the budget
+func assertWithinBudget(t *testing.T, stats budgetStats, budget perfBudget) {
+	t.Helper()
+
+	if budget.MaxP99 > 0 && stats.P99 > budget.MaxP99 {
+		t.Errorf("%s: p99 latency %v exceeds the budget of %v", stats.Name, stats.P99, budget.MaxP99)
+	}
+
+	if budget.MaxErrorRate >= 0 && stats.Requests > 0 {
+		failed := stats.Requests - stats.Succeeded
+		rate := float64(failed) / float64(stats.Requests)
+		if rate > budget.MaxErrorRate {
+			t.Errorf("%s: %d of %d requests failed (%.2f%%), the budget allows %.2f%%",
+				stats.Name, failed, stats.Requests, rate*100, budget.MaxErrorRate*100)
+		}
+	}
+}
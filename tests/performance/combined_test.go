@@ -1,3 +1,5 @@
+//go:build loadtest
+
 package performance
 
 import (
@@ -21,7 +23,9 @@ import (
 func TestCompleteHTTPRPCPerformance(t *testing.T) {
 	serverHost := "localhost"
 	serverPort := 8083
-	dbAddr := "localhost:50051"
+	dbAddr := databaseAddresses(t, 1)[0]
+	//the same workload for the baseline, the HTTP requests under load and the RPC background load
+	requests, clients := workloadFromEnv(t, "HTTP_RPC", 1_000_000, 10)
 
 	dbClient, err := database.ClientFactory(dbAddr)
 	if err != nil {
@@ -54,36 +58,42 @@ func TestCompleteHTTPRPCPerformance(t *testing.T) {
 	}
 
 	url := fmt.Sprintf("http://%s:%d/data", serverHost, serverPort)
+	partial := newPartialResults(t, "Complete HTTP+RPC Performance Test Results", "complete_http_rpc_performance_results.txt")
 
 	// Test 1: HTTP+RPC Baseline (no background load)
 	log.Println("=== Starting HTTP+RPC Baseline Performance Test ===")
-	baselineStats := runHTTPBaselineTest(t, url, jsonData)
+	baselineStats := runHTTPBaselineTest(t, url, jsonData, requests, clients, partial)
 
 	// Allow system to cool down between tests
 	time.Sleep(2 * time.Second)
 
 	// Test 2: HTTP+RPC Under Load (with background RPC load)
 	log.Println("=== Starting HTTP+RPC Under Load Performance Test ===")
-	httpStats, rpcStats := runHTTPRPCLoadTest(t, url, jsonData, dbClient, testData)
+	httpStats, rpcStats := runHTTPRPCLoadTest(t, url, jsonData, dbClient, testData, requests, clients, partial)
 
 	// Write comprehensive results
 	err = writeCompleteResultsToFile(baselineStats, httpStats, rpcStats, "complete_http_rpc_performance_results.txt")
 	if err != nil {
 		t.Errorf("Failed to write results to file: %v", err)
+	} else {
+		partial.Complete()
 	}
 
+	//the clients only log failed requests, so the error budget is what makes them count
+	httpBudget := budgetFromEnv(t, "HTTP_RPC")
+	assertWithinBudget(t, budgetStats{Name: baselineStats.Protocol, Requests: requests, Succeeded: baselineStats.Count, P99: baselineStats.Percentile99}, httpBudget)
+	assertWithinBudget(t, budgetStats{Name: httpStats.Protocol, Requests: requests, Succeeded: httpStats.Count, P99: httpStats.Percentile99}, httpBudget)
+	assertWithinBudget(t, budgetStats{Name: rpcStats.Protocol, Requests: requests, Succeeded: rpcStats.Count, P99: rpcStats.Percentile99}, budgetFromEnv(t, "RPC"))
+
 	log.Println("Complete HTTP+RPC performance test finished")
 }
 
 // runHTTPBaselineTest runs HTTP requests against HTTP+RPC system without background load
-func runHTTPBaselineTest(t *testing.T, url string, jsonData []byte) CombinedStatistics {
-	httpRequests := 1_000_000
-	concurrentHTTPClients := 10
+func runHTTPBaselineTest(t *testing.T, url string, jsonData []byte, httpRequests, concurrentHTTPClients int, partial *partialResults) CombinedStatistics {
 
 	log.Printf("Running HTTP+RPC baseline test: %d requests from %d concurrent clients",
 		httpRequests, concurrentHTTPClients)
 
-	httpRTTs := make(chan time.Duration, httpRequests)
 	var wg sync.WaitGroup
 
 	requestsPerClient := httpRequests / concurrentHTTPClients
@@ -92,6 +102,7 @@ func runHTTPBaselineTest(t *testing.T, url string, jsonData []byte) CombinedStat
 		go func(clientID int) {
 			defer wg.Done()
 			client := http.HttpClientFactory(5 * time.Second)
+			recorder := partial.Recorder("HTTP+RPC-Baseline")
 
 			for j := 0; j < requestsPerClient; j++ {
 				start := time.Now()
@@ -107,41 +118,28 @@ func runHTTPBaselineTest(t *testing.T, url string, jsonData []byte) CombinedStat
 					continue
 				}
 
-				httpRTTs <- rtt
+				recorder.Add(rtt)
 			}
 		}(i)
 	}
 
 	wg.Wait()
-	close(httpRTTs)
 
-	// Collect results
-	var httpRTTValues []time.Duration
-	for rtt := range httpRTTs {
-		httpRTTValues = append(httpRTTValues, rtt)
-	}
-
-	stats := calculateCombinedStatistics(httpRTTValues, "HTTP+RPC-Baseline")
+	stats := calculateCombinedStatistics(partial.RTTs("HTTP+RPC-Baseline"), "HTTP+RPC-Baseline")
 	logStatistics(stats)
 
 	return stats
 }
 
 // runHTTPRPCLoadTest runs the existing combined load test
-func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *database.Client, testData types.SensorData) (CombinedStatistics, CombinedStatistics) {
-	httpRequests := 1_000_000
-	rpcRequests := 1_000_000
-	concurrentHTTPClients := 10
-	concurrentRPCClients := 10
+func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *database.Client, testData types.SensorData, requests, clients int, partial *partialResults) (CombinedStatistics, CombinedStatistics) {
+	httpRequests, rpcRequests := requests, requests
+	concurrentHTTPClients, concurrentRPCClients := clients, clients
 
 	log.Printf("Running HTTP+RPC under load test")
 	log.Printf("HTTP: %d requests from %d concurrent clients", httpRequests, concurrentHTTPClients)
 	log.Printf("RPC: %d requests from %d concurrent clients (background load)", rpcRequests, concurrentRPCClients)
 
-	//channels for collecting results
-	httpRTTs := make(chan time.Duration, httpRequests)
-	rpcRTTs := make(chan time.Duration, rpcRequests)
-
 	var wg sync.WaitGroup
 
 	//start RPC background load
@@ -151,6 +149,7 @@ func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *dat
 		wg.Add(1)
 		go func(clientID int) {
 			defer wg.Done()
+			recorder := partial.Recorder("RPC-BackgroundLoad")
 
 			for j := 0; j < requestsPerRPCClient; j++ {
 				start := time.Now()
@@ -159,8 +158,7 @@ func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *dat
 					log.Printf("RPC Client %d: Error: %v", clientID, err)
 					continue
 				}
-				rtt := time.Since(start)
-				rpcRTTs <- rtt
+				recorder.Add(time.Since(start))
 			}
 		}(i)
 	}
@@ -176,6 +174,7 @@ func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *dat
 		go func(clientID int) {
 			defer wg.Done()
 			client := http.HttpClientFactory(5 * time.Second)
+			recorder := partial.Recorder("HTTP+RPC-UnderLoad")
 
 			for j := 0; j < requestsPerHTTPClient; j++ {
 				start := time.Now()
@@ -191,29 +190,16 @@ func runHTTPRPCLoadTest(t *testing.T, url string, jsonData []byte, dbClient *dat
 					continue
 				}
 
-				httpRTTs <- rtt
+				recorder.Add(rtt)
 			}
 		}(i)
 	}
 
 	wg.Wait()
-	close(httpRTTs)
-	close(rpcRTTs)
-
-	//collect and analyze results
-	var httpRTTValues []time.Duration
-	var rpcRTTValues []time.Duration
-
-	for rtt := range httpRTTs {
-		httpRTTValues = append(httpRTTValues, rtt)
-	}
-
-	for rtt := range rpcRTTs {
-		rpcRTTValues = append(rpcRTTValues, rtt)
-	}
 
-	httpStats := calculateCombinedStatistics(httpRTTValues, "HTTP+RPC-UnderLoad")
-	rpcStats := calculateCombinedStatistics(rpcRTTValues, "RPC-BackgroundLoad")
+	//analyze the results
+	httpStats := calculateCombinedStatistics(partial.RTTs("HTTP+RPC-UnderLoad"), "HTTP+RPC-UnderLoad")
+	rpcStats := calculateCombinedStatistics(partial.RTTs("RPC-BackgroundLoad"), "RPC-BackgroundLoad")
 
 	log.Printf("HTTP (under RPC load):")
 	logStatistics(httpStats)
@@ -0,0 +1,33 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+)
+
+// databaseAddresses returns the databases the load tests run against: the comma separated addresses in DB_ADDRS,
+// e.g. localhost:50051,localhost:50052,localhost:50053 to measure 2PC with three replicas, or the default addresses of
+// the server if it is not set. The test fails if there are fewer than minimum
+func databaseAddresses(t *testing.T, minimum int) []string {
+	t.Helper()
+
+	var addresses []string
+	for _, address := range strings.Split(os.Getenv("DB_ADDRS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		addresses = server.DefaultConfig().DatabaseAddresses
+	}
+
+	if len(addresses) < minimum {
+		t.Fatalf("DB_ADDRS needs at least %d database addresses, got %v", minimum, addresses)
+	}
+	return addresses
+}
@@ -0,0 +1,191 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/gateway"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/server"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestEndToEndLatency measures the time from a sensor publish to the 2PC commit on the last replica, across the whole
+// pipeline: sensor -> MQTT -> gateway -> HTTP -> server -> 2PC -> database. Everything but the MQTT broker on
+// localhost:1883 runs in-process, so the publish timestamp in the reading and the commit time share one clock
+func TestEndToEndLatency(t *testing.T) {
+	const (
+		brokerAddr      = "localhost:1883"
+		serverPort      = 8180
+		numReadings     = 1000
+		publishInterval = 5 * time.Millisecond
+		drainTimeout    = 30 * time.Second
+	)
+
+	log.Printf("Starting end-to-end latency test")
+	log.Printf("Readings: %d, Interval: %v", numReadings, publishInterval)
+
+	//a reading counts as committed once every replica stored it, the observers run on their own goroutines
+	tap := &commitTap{replicas: 2, stored: make(map[int64]int), done: make(chan struct{})}
+	tap.remaining = numReadings
+	var addresses []string
+	for range tap.replicas {
+		addr, service := startBenchmarkDatabase(t)
+		if err := service.OnDataStored(tap.observe); err != nil {
+			t.Fatalf("Failed to register the commit observer: %v", err)
+		}
+		addresses = append(addresses, addr)
+	}
+
+	config := server.DefaultConfig()
+	config.Host = "localhost"
+	config.Port = serverPort
+	config.DatabaseAddresses = addresses
+	app, err := server.AppFactory(config)
+	if err != nil {
+		t.Fatalf("Failed to create server app: %v", err)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatalf("Failed to start server app: %v", err)
+	}
+	defer app.Stop()
+
+	gw := gateway.GatewayFactory(fmt.Sprintf("http://localhost:%d", serverPort), brokerAddr)
+	if err := gw.Start(); err != nil {
+		t.Fatalf("Failed to start gateway (is an MQTT broker running on %s?): %v", brokerAddr, err)
+	}
+	defer gw.Stop()
+
+	publisher, err := connectLatencyPublisher(brokerAddr)
+	if err != nil {
+		t.Fatalf("Failed to connect publisher: %v", err)
+	}
+	defer publisher.Disconnect(250)
+
+	//the per-request logging of every component would dominate the measurement
+	previousLogOutput := log.Writer()
+	log.SetOutput(io.Discard)
+
+	start := time.Now()
+	for i := range numReadings {
+		//the timestamp is taken right before the publish, so the latency includes the JSON encoding of the sensor
+		reading := types.SensorData{SensorID: "e2e-1", Timestamp: time.Now(), Value: float64(i), Unit: "test"}
+		payload, err := json.Marshal(reading)
+		if err != nil {
+			t.Fatalf("Failed to encode reading: %v", err)
+		}
+		token := publisher.Publish("sensors/e2e/e2e-1", 0, false, payload)
+		if !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+			t.Fatalf("Publish %d failed: %v", i, token.Error())
+		}
+		time.Sleep(publishInterval)
+	}
+
+	select {
+	case <-tap.done:
+	case <-time.After(drainTimeout):
+		t.Errorf("Only %d of %d readings were committed within %v", numReadings-tap.pending(), numReadings, drainTimeout)
+	}
+	totalDuration := time.Since(start)
+	log.SetOutput(previousLogOutput)
+
+	latencies := tap.results()
+	if len(latencies) == 0 {
+		t.Fatalf("No reading made it through the pipeline")
+	}
+
+	stats := calculate2PCStatistics(latencies, "End-to-end (sensor publish -> commit on all replicas)", totalDuration)
+	log.Printf("End-to-End Latency Test Results:")
+	log2PCStatistics(stats)
+
+	if err := writeEndToEndResults(stats, "e2e_performance_results.txt"); err != nil {
+		t.Errorf("Failed to write results to file: %v", err)
+	}
+
+	//readings not committed within the drain timeout count as failed
+	assertWithinBudget(t, budgetStats{Name: "End-to-end", Requests: numReadings, Succeeded: stats.Count, P99: stats.Percentile99}, budgetFromEnv(t, "E2E"))
+}
+
+// commitTap collects the end-to-end latency of every reading from the stored-data observers of the replicas
+type commitTap struct {
+	mu        sync.Mutex
+	replicas  int
+	stored    map[int64]int //publish timestamp -> number of replicas that stored the reading
+	latencies []time.Duration
+	remaining int
+	done      chan struct{}
+}
+
+// observe is registered with OnDataStored on every replica
+func (c *commitTap) observe(data types.SensorData) {
+	committed := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := data.Timestamp.UnixNano()
+	c.stored[key]++
+	if c.stored[key] < c.replicas {
+		return
+	}
+
+	delete(c.stored, key)
+	c.latencies = append(c.latencies, committed.Sub(data.Timestamp))
+	c.remaining--
+	if c.remaining == 0 {
+		close(c.done)
+	}
+}
+
+// pending returns the number of readings not yet committed on every replica
+func (c *commitTap) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remaining
+}
+
+// results returns a copy of the latencies collected so far
+func (c *commitTap) results() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.latencies...)
+}
+
+// connectLatencyPublisher connects the MQTT client that plays the sensor
+func connectLatencyPublisher(brokerAddr string) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("tcp://" + brokerAddr)
+	opts.SetClientID("e2e-latency-publisher")
+	opts.SetCleanSession(true)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, fmt.Errorf("connect not acknowledged within %v", mqttTimeout)
+	}
+	return client, token.Error()
+}
+
+// writeEndToEndResults writes the latency distribution to file
+func writeEndToEndResults(stats TwoPhaseCommitStatistics, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "End-to-End Latency Test Results\n")
+	fmt.Fprintf(file, "===============================\n")
+	fmt.Fprintf(file, "Test Date: %s\n\n", time.Now().Format(time.RFC3339))
+	write2PCStatsToFile(file, stats)
+	return nil
+}
@@ -1,3 +1,5 @@
+//go:build loadtest
+
 package performance
 
 import (
@@ -19,8 +21,7 @@ import (
 func TestRawHTTPPerformance(t *testing.T) {
 	serverHost := "localhost"
 	serverPort := 8080
-	numRequests := 1_000_000
-	concurrentClients := 10
+	numRequests, concurrentClients := workloadFromEnv(t, "HTTP", 1_000_000, 10)
 
 	time.Sleep(500 * time.Millisecond)
 
@@ -41,8 +42,8 @@ func TestRawHTTPPerformance(t *testing.T) {
 	log.Printf("Starting raw HTTP performance test with %d requests from %d concurrent clients",
 		numRequests, concurrentClients)
 
-	//channel for RTT measurements
-	rtts := make(chan time.Duration, numRequests)
+	//every client records its RTT measurements itself, a test ending early leaves them in the results file
+	partial := newPartialResults(t, "Raw HTTP Performance Test Results", "http_performance_results.txt")
 	done := make(chan struct{})
 
 	//start the clients
@@ -50,6 +51,7 @@ func TestRawHTTPPerformance(t *testing.T) {
 	for i := range concurrentClients {
 		go func(clientID int) {
 			client := http.HttpClientFactory(5 * time.Second)
+			recorder := partial.Recorder("Raw HTTP")
 
 			for range requestsPerClient {
 				//send request and measure RTT
@@ -66,7 +68,7 @@ func TestRawHTTPPerformance(t *testing.T) {
 					continue
 				}
 
-				rtts <- rtt
+				recorder.Add(rtt)
 			}
 
 			done <- struct{}{}
@@ -78,14 +80,7 @@ func TestRawHTTPPerformance(t *testing.T) {
 		<-done
 	}
 
-	close(rtts)
-
-	var rttValues []time.Duration
-	for rtt := range rtts {
-		rttValues = append(rttValues, rtt)
-	}
-
-	stats := calculateRawHTTPStatistics(rttValues)
+	stats := calculateRawHTTPStatistics(partial.RTTs("Raw HTTP"))
 
 	log.Printf("Raw HTTP Performance Test Results:")
 	log.Printf("  Total requests:    %d", stats.Count)
@@ -99,10 +94,16 @@ func TestRawHTTPPerformance(t *testing.T) {
 	log.Printf("  99th percentile:    %v", stats.Percentile99)
 	log.Printf("  Requests per second: %.2f", stats.RequestsPerSecond)
 
-	writeRawHTTPResultsToFile(stats, "http_performance_results.txt")
+	err = writeRawHTTPResultsToFile(stats, "http_performance_results.txt")
 	if err != nil {
 		t.Errorf("Failed to write results to file: %v", err)
+	} else {
+		partial.Complete()
 	}
+
+	//the clients only log failed requests, so the error budget is what makes them count
+	sent := requestsPerClient * concurrentClients
+	assertWithinBudget(t, budgetStats{Name: "Raw HTTP", Requests: sent, Succeeded: stats.Count, P99: stats.Percentile99}, budgetFromEnv(t, "HTTP"))
 }
 
 // RawHTTPStatistics contains statistical measures for raw HTTP RTT measurements
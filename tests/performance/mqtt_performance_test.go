@@ -1,3 +1,5 @@
+//go:build loadtest
+
 package performance
 
 import (
@@ -13,6 +15,9 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// mqttTimeout bounds every wait for a broker acknowledgement, so a stalled broker fails the test instead of hanging it
+const mqttTimeout = 10 * time.Second
+
 // TestMQTTPerformance tests MQTT throughput and latency
 func TestMQTTPerformance(t *testing.T) {
 	brokerURL := "tcp://localhost:1883"
@@ -121,15 +126,18 @@ func (s *MQTTSubscriber) Connect() error {
 
 	s.Client = mqtt.NewClient(opts)
 	token := s.Client.Connect()
-	token.Wait()
-
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("connect not acknowledged within %v", mqttTimeout)
+	}
 	if token.Error() != nil {
 		return token.Error()
 	}
 
 	//subscribe to all sensor topics
 	token = s.Client.Subscribe("sensors/+/+", 0, s.messageHandler)
-	token.Wait()
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("subscribe not acknowledged within %v", mqttTimeout)
+	}
 
 	return token.Error()
 }
@@ -157,7 +165,9 @@ func (p *MQTTPublisher) Connect() error {
 
 	p.Client = mqtt.NewClient(opts)
 	token := p.Client.Connect()
-	token.Wait()
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("connect not acknowledged within %v", mqttTimeout)
+	}
 
 	return token.Error()
 }
@@ -182,7 +192,9 @@ func (p *MQTTPublisher) PublishLoop(stopChan chan struct{}, interval time.Durati
 			topic := fmt.Sprintf("sensors/temp/perf-test-%d", p.PublisherID)
 
 			token := p.Client.Publish(topic, 0, false, jsonData)
-			token.Wait()
+			if !token.WaitTimeout(mqttTimeout) {
+				log.Printf("Publisher %d: publish not acknowledged within %v", p.PublisherID, mqttTimeout)
+			}
 		}
 	}
 }
@@ -0,0 +1,207 @@
+package performance
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// partialResults keeps the measurements of a performance test while they are taken, so a test that ends before it
+// writes its results file, because it failed, was stopped by t.Fatalf or panicked, still leaves a summary of what it
+// measured up to then. The summary is written to the results file on cleanup and starts with a PARTIAL marker
+type partialResults struct {
+	title    string
+	filename string
+	start    time.Time
+
+	mu        sync.Mutex
+	phases    []string                    //phases in the order of their first recorder
+	recorders map[string][]*phaseRecorder //phase -> recorders of the goroutines measuring it
+	complete  bool                        //the test wrote its own results, nothing to flush
+}
+
+// phaseRecorder collects the round trip times one goroutine measures for a phase. They are the only copy the test
+// keeps, it reads them back with RTTs. Each goroutine has its own recorder, so its lock is only ever contended by
+// a flush
+type phaseRecorder struct {
+	mu   sync.Mutex
+	rtts []time.Duration
+}
+
+// newPartialResults starts collecting the measurements of the test and registers the flush of the partial results
+// with t.Cleanup, which also runs if the test panics
+func newPartialResults(t testing.TB, title, filename string) *partialResults {
+	p := &partialResults{
+		title:     title,
+		filename:  filename,
+		start:     time.Now(),
+		recorders: make(map[string][]*phaseRecorder),
+	}
+	t.Cleanup(func() {
+		if err := p.flush(); err != nil {
+			t.Errorf("Failed to write partial results to %s: %v", filename, err)
+		}
+	})
+	return p
+}
+
+// Recorder returns a new recorder for the phase, every goroutine measuring the phase takes its own
+func (p *partialResults) Recorder(phase string) *phaseRecorder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.recorders[phase]; !ok {
+		p.phases = append(p.phases, phase)
+	}
+	r := &phaseRecorder{}
+	p.recorders[phase] = append(p.recorders[phase], r)
+	return r
+}
+
+// RTTs returns the round trip times of all recorders of the phase
+func (p *partialResults) RTTs(phase string) []time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rtts(phase)
+}
+
+// rtts merges the recorders of the phase, the caller must hold the lock
+func (p *partialResults) rtts(phase string) []time.Duration {
+	var rtts []time.Duration
+	for _, r := range p.recorders[phase] {
+		r.mu.Lock()
+		rtts = append(rtts, r.rtts...)
+		r.mu.Unlock()
+	}
+	return rtts
+}
+
+// Add records the round trip time of one successful request
+func (r *phaseRecorder) Add(rtt time.Duration) {
+	r.mu.Lock()
+	r.rtts = append(r.rtts, rtt)
+	r.mu.Unlock()
+}
+
+// Complete marks the results as written by the test itself, so the cleanup leaves the results file alone
+func (p *partialResults) Complete() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.complete = true
+}
+
+// flush writes the summary of the measurements taken so far unless the test completed
+func (p *partialResults) flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.complete {
+		return nil
+	}
+
+	file, err := os.Create(p.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "PARTIAL %s\n", p.title)
+	fmt.Fprintf(file, "The test ended after %v before writing its results, these are the measurements taken until then\n\n", time.Since(p.start).Round(time.Millisecond))
+
+	written := 0
+	for _, phase := range p.phases {
+		if rtts := p.rtts(phase); len(rtts) > 0 {
+			writePartialPhase(file, phase, rtts)
+			written++
+		}
+	}
+	if written == 0 {
+		fmt.Fprintf(file, "No measurements were taken\n")
+	}
+	return file.Sync()
+}
+
+// writePartialPhase writes the statistics of one phase; the percentiles use the nearest rank, so they stay within
+// the samples however few were taken
+func writePartialPhase(file *os.File, phase string, rtts []time.Duration) {
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+
+	var sum time.Duration
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+	percentile := func(p float64) time.Duration {
+		rank := int(float64(len(sorted))*p+0.5) - 1
+		return sorted[max(0, min(rank, len(sorted)-1))]
+	}
+
+	fmt.Fprintf(file, "%s\n", phase)
+	fmt.Fprintf(file, "Total requests:     %d\n", len(sorted))
+	fmt.Fprintf(file, "Min RTT:            %v\n", sorted[0])
+	fmt.Fprintf(file, "Max RTT:            %v\n", sorted[len(sorted)-1])
+	fmt.Fprintf(file, "Mean RTT:           %v\n", sum/time.Duration(len(sorted)))
+	fmt.Fprintf(file, "Median RTT:         %v\n", percentile(0.5))
+	fmt.Fprintf(file, "90th percentile:    %v\n", percentile(0.9))
+	fmt.Fprintf(file, "95th percentile:    %v\n", percentile(0.95))
+	fmt.Fprintf(file, "99th percentile:    %v\n\n", percentile(0.99))
+}
+
+// TestPartialResults checks that a test returning before it writes its results leaves a partial results file, and
+// that a test writing its own results keeps them
+func TestPartialResults(t *testing.T) {
+	dir := t.TempDir()
+	partialFile := dir + "/partial_results.txt"
+	completeFile := dir + "/complete_results.txt"
+
+	t.Run("early return", func(t *testing.T) {
+		results := newPartialResults(t, "RPC Performance Test Results", partialFile)
+		//two goroutines measuring the same phase end up in one summary
+		first, second := results.Recorder("RPC"), results.Recorder("RPC")
+		for i := range 10 {
+			if i%2 == 0 {
+				first.Add(time.Duration(i+1) * time.Millisecond)
+			} else {
+				second.Add(time.Duration(i+1) * time.Millisecond)
+			}
+		}
+		if rtts := results.RTTs("RPC"); len(rtts) != 10 {
+			t.Errorf("Expected 10 RTTs for the phase, got %d", len(rtts))
+		}
+		results.Recorder("2PC").Add(5 * time.Millisecond)
+		//the test stops here, as if a request had failed fatally
+	})
+
+	content, err := os.ReadFile(partialFile)
+	if err != nil {
+		t.Fatalf("No partial results were written: %v", err)
+	}
+	text := string(content)
+	if !strings.HasPrefix(text, "PARTIAL RPC Performance Test Results\n") {
+		t.Errorf("Partial results do not start with the PARTIAL marker:\n%s", text)
+	}
+	for _, want := range []string{"RPC\nTotal requests:     10\n", "Max RTT:            10ms\n", "99th percentile:    10ms\n", "2PC\nTotal requests:     1\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Partial results miss %q:\n%s", want, text)
+		}
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		results := newPartialResults(t, "RPC Performance Test Results", completeFile)
+		results.Recorder("RPC").Add(time.Millisecond)
+		if err := os.WriteFile(completeFile, []byte("complete\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write results: %v", err)
+		}
+		results.Complete()
+	})
+
+	content, err = os.ReadFile(completeFile)
+	if err != nil {
+		t.Fatalf("Failed to read results: %v", err)
+	}
+	if string(content) != "complete\n" {
+		t.Errorf("Results of a completed test were overwritten:\n%s", content)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build loadtest
+
 package performance
 
 import (
@@ -15,17 +17,18 @@ import (
 
 // TestRPCPerformance tests the performance of RPC calls to the database service
 func TestRPCPerformance(t *testing.T) {
-	client, err := database.ClientFactory("localhost:50051")
+	client, err := database.ClientFactory(databaseAddresses(t, 1)[0])
 	if err != nil {
 		t.Fatalf("Failed to connect to database service: %v", err)
 	}
 	defer client.Close()
 
-	numRequests := 1_000_000
+	numRequests := requestsFromEnv(t, "RPC", 1_000_000)
 	log.Printf("Starting RPC performance test with %d requests", numRequests)
 
-	//collect RTT measurements
-	var rtts []time.Duration
+	//collect RTT measurements, a test ending early leaves them in the results file marked as partial
+	partial := newPartialResults(t, "RPC Performance Test Results", "rpc_performance_results.txt")
+	recorder := partial.Recorder("RPC")
 	testData := types.SensorData{
 		SensorID:  "rpc-perf-test",
 		Timestamp: time.Now(),
@@ -43,12 +46,11 @@ func TestRPCPerformance(t *testing.T) {
 			continue
 		}
 
-		rtt := time.Since(start)
-		rtts = append(rtts, rtt)
+		recorder.Add(time.Since(start))
 	}
 
 	//calculate statistics
-	stats := calculateRPCStatistics(rtts)
+	stats := calculateRPCStatistics(partial.RTTs("RPC"))
 
 	log.Printf("RPC Performance Test Results:")
 	log.Printf("  Total requests:     %d", stats.Count)
@@ -66,7 +68,11 @@ func TestRPCPerformance(t *testing.T) {
 	err = writeRPCResultsToFile(stats, "rpc_performance_results.txt")
 	if err != nil {
 		t.Errorf("Failed to write results to file: %v", err)
+	} else {
+		partial.Complete()
 	}
+
+	assertWithinBudget(t, budgetStats{Name: "RPC", Requests: numRequests, Succeeded: stats.Count, P99: stats.Percentile99}, budgetFromEnv(t, "RPC"))
 }
 
 // RPCStatistics contains statistical measures for RPC RTT measurements
@@ -0,0 +1,133 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	pb "code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/generated/rpc"
+)
+
+// SnapshotStatistics contains the flush/load time and file size of one snapshot format
+type SnapshotStatistics struct {
+	Format   string
+	Points   int
+	Flush    time.Duration
+	Load     time.Duration
+	FileSize int64
+}
+
+// TestSnapshotFormatPerformance compares flush time, load time and file size of the snapshot formats
+func TestSnapshotFormatPerformance(t *testing.T) {
+	numPoints := 1_000_000
+	log.Printf("Starting snapshot format comparison with %d points", numPoints)
+
+	formats := []database.SnapshotFormat{database.SnapshotJSON, database.SnapshotGob, database.SnapshotBinary}
+
+	var results []SnapshotStatistics
+	for _, format := range formats {
+		for _, compress := range []bool{false, true} {
+			stats, err := measureSnapshotFormat(t.TempDir(), format, compress, numPoints)
+			if err != nil {
+				t.Errorf("Snapshot format %s failed: %v", stats.Format, err)
+				continue
+			}
+
+			log.Printf("  %-12s flush %-12v load %-12v size %d bytes", stats.Format, stats.Flush, stats.Load, stats.FileSize)
+			results = append(results, stats)
+		}
+	}
+
+	err := writeSnapshotResults(results, "snapshot_performance_results.txt")
+	if err != nil {
+		t.Errorf("Failed to write results to file: %v", err)
+	}
+}
+
+// measureSnapshotFormat fills a service with numPoints, flushes it and reloads the snapshot into a new service
+func measureSnapshotFormat(dir string, format database.SnapshotFormat, compress bool, numPoints int) (SnapshotStatistics, error) {
+	name := string(format)
+	if compress {
+		name += "+gzip"
+	}
+	stats := SnapshotStatistics{Format: name, Points: numPoints}
+	file := filepath.Join(dir, "snapshot")
+
+	service, err := database.DatabaseServiceFactory(numPoints, database.WithDataFile(file), database.WithSnapshotFormat(format, compress))
+	if err != nil {
+		return stats, err
+	}
+	defer service.Stop()
+	start := time.Now()
+	for i := range numPoints {
+		service.CreateSensorData(context.Background(), &pb.SensorDataRequest{
+			SensorId:  fmt.Sprintf("snapshot-perf-%d", i%100),
+			Timestamp: timestamppb.New(start.Add(time.Duration(i) * time.Millisecond)),
+			Value:     float64(i) * 0.1,
+			Unit:      "°C",
+		})
+	}
+
+	flushStart := time.Now()
+	_, _, err = service.Flush()
+	stats.Flush = time.Since(flushStart)
+	if err != nil {
+		return stats, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return stats, err
+	}
+	stats.FileSize = info.Size()
+
+	//loading happens in the factory
+	loadStart := time.Now()
+	reloaded, err := database.DatabaseServiceFactory(numPoints, database.WithDataFile(file), database.WithSnapshotFormat(format, compress))
+	stats.Load = time.Since(loadStart)
+	if err != nil {
+		return stats, err
+	}
+	defer reloaded.Stop()
+
+	resp, err := reloaded.GetAllSensorData(context.Background(), &pb.EmptyRequest{})
+	if err != nil {
+		return stats, err
+	}
+	if len(resp.Data) != numPoints {
+		return stats, fmt.Errorf("expected %d reloaded points, got %d", numPoints, len(resp.Data))
+	}
+
+	return stats, nil
+}
+
+// writeSnapshotResults writes the snapshot format comparison to file
+func writeSnapshotResults(results []SnapshotStatistics, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	file.WriteString("Snapshot Format Performance Analysis\n")
+	file.WriteString("====================================\n\n")
+
+	for _, stats := range results {
+		fmt.Fprintf(file, "Format:     %s\n", stats.Format)
+		fmt.Fprintf(file, "Points:     %d\n", stats.Points)
+		fmt.Fprintf(file, "Flush time: %v\n", stats.Flush)
+		fmt.Fprintf(file, "Load time:  %v\n", stats.Load)
+		fmt.Fprintf(file, "File size:  %d bytes (%.1f bytes/point)\n\n", stats.FileSize, float64(stats.FileSize)/float64(stats.Points))
+	}
+
+	return nil
+}
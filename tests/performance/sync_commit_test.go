@@ -0,0 +1,106 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/internal/database"
+	"code.fbi.h-da.de/distributed-systems/praktika/lab-for-distributed-systems-2025-sose/moore/Mo-4X-TeamE/pkg/types"
+)
+
+// TestSyncCommitPerformance compares the 2PC latency with sync commits, where every commit is synced to disk before
+// it is acknowledged, against the default, where commits reach the disk with the next snapshot. Both run against two
+// in-process databases persisting to a temporary directory, so the disk of the machine running the test is measured
+func TestSyncCommitPerformance(t *testing.T) {
+	numRequests := requestsFromEnv(t, "SYNC_COMMIT", 2_000)
+	log.Printf("Starting sync commit performance comparison with %d requests on 2 replicas", numRequests)
+	partial := newPartialResults(t, "Sync Commit Performance Comparison", "sync_commit_performance_results.txt")
+
+	modes := []struct {
+		protocol string
+		opts     []database.ServiceOption
+	}{
+		{"2PC-AsyncFlush", nil},
+		{"2PC-SyncCommit", []database.ServiceOption{database.WithSyncCommit()}},
+	}
+
+	var stats []TwoPhaseCommitStatistics
+	for _, mode := range modes {
+		log.Printf("=== Testing %s ===", mode.protocol)
+		var addresses []string
+		for replica := range 2 {
+			file := filepath.Join(t.TempDir(), fmt.Sprintf("db%d.json", replica))
+			addr, _ := startBenchmarkDatabase(t, append([]database.ServiceOption{database.WithDataFile(file)}, mode.opts...)...)
+			addresses = append(addresses, addr)
+		}
+
+		tpcClient, err := database.TwoPhaseCommitClientFactory(addresses)
+		if err != nil {
+			t.Fatalf("Failed to create 2PC client: %v", err)
+		}
+
+		recorder := partial.Recorder(mode.protocol)
+		start := time.Now()
+		for i := range numRequests {
+			requestStart := time.Now()
+			err := tpcClient.AddDataPointWithTwoPhaseCommit(types.SensorData{
+				SensorID:  fmt.Sprintf("sync-commit-%d", i),
+				Timestamp: time.Now(),
+				Value:     float64(i),
+				Unit:      "test",
+			})
+			if err != nil {
+				t.Errorf("%s transaction %d failed: %v", mode.protocol, i, err)
+				continue
+			}
+			recorder.Add(time.Since(requestStart))
+		}
+		tpcClient.Close()
+
+		modeStats := calculate2PCStatistics(partial.RTTs(mode.protocol), mode.protocol, time.Since(start))
+		log2PCStatistics(modeStats)
+		stats = append(stats, modeStats)
+	}
+
+	asyncStats, syncStats := stats[0], stats[1]
+	if err := writeSyncCommitResults(asyncStats, syncStats, "sync_commit_performance_results.txt"); err != nil {
+		t.Errorf("Failed to write results to file: %v", err)
+	} else {
+		partial.Complete()
+	}
+
+	assertWithinBudget(t, budgetStats{Name: syncStats.Protocol, Requests: numRequests, Succeeded: syncStats.Count, P99: syncStats.Percentile99}, budgetFromEnv(t, "SYNC_COMMIT"))
+}
+
+// writeSyncCommitResults writes the statistics of both modes and the cost of the sync commits to a file
+func writeSyncCommitResults(asyncStats, syncStats TwoPhaseCommitStatistics, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	file.WriteString("Sync Commit Performance Comparison\n")
+	file.WriteString("==================================\n\n")
+
+	write2PCStatsToFile(file, asyncStats)
+	file.WriteString("\n")
+	write2PCStatsToFile(file, syncStats)
+
+	file.WriteString("\nSync Commit Cost\n")
+	file.WriteString("----------------\n")
+	if asyncStats.Mean > 0 {
+		fmt.Fprintf(file, "Mean latency:       %v more (%.2fx)\n", syncStats.Mean-asyncStats.Mean, float64(syncStats.Mean)/float64(asyncStats.Mean))
+		fmt.Fprintf(file, "99th percentile:    %v more\n", syncStats.Percentile99-asyncStats.Percentile99)
+	}
+	if asyncStats.RequestsPerSecond > 0 {
+		fmt.Fprintf(file, "Throughput:         %.1f%% of the async flush\n", syncStats.RequestsPerSecond/asyncStats.RequestsPerSecond*100)
+	}
+	return nil
+}
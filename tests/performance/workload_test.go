@@ -0,0 +1,42 @@
+//go:build loadtest
+
+package performance
+
+import (
+	"strconv"
+	"testing"
+)
+
+// workloadFromEnv returns how many requests a test sends and from how many concurrent clients:
+// PERF_<NAME>_REQUESTS and PERF_<NAME>_CLIENTS, falling back to PERF_REQUESTS and PERF_CLIENTS for all tests and then
+// to the defaults of the test, e.g. PERF_REQUESTS=10000 for a quick smoke run. The requests are split evenly between
+// the clients, so their count is rounded down to a multiple of the clients
+func workloadFromEnv(t *testing.T, name string, defaultRequests, defaultClients int) (requests, clients int) {
+	t.Helper()
+	requests = requestsFromEnv(t, name, defaultRequests)
+	clients = positiveIntEnv(t, name, "CLIENTS", defaultClients)
+	if clients > requests {
+		t.Fatalf("%s: %d clients cannot share %d requests", name, clients, requests)
+	}
+	return requests / clients * clients, clients
+}
+
+// requestsFromEnv returns the request count of a test that sends from a single client, see workloadFromEnv
+func requestsFromEnv(t *testing.T, name string, defaultRequests int) int {
+	t.Helper()
+	return positiveIntEnv(t, name, "REQUESTS", defaultRequests)
+}
+
+// positiveIntEnv reads a setting like budgetEnv does and returns the default if it is set nowhere
+func positiveIntEnv(t *testing.T, name, setting string, defaultValue int) int {
+	t.Helper()
+	value := budgetEnv(name, setting)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		t.Fatalf("Invalid %s %q for %s: expected a positive number", setting, value, name)
+	}
+	return n
+}